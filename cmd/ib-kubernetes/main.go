@@ -1,13 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/Mellanox/ib-kubernetes/pkg/daemon"
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	"github.com/Mellanox/ib-kubernetes/pkg/profiling"
+	"github.com/Mellanox/ib-kubernetes/pkg/status"
 )
 
 const exitError = 1
@@ -24,15 +31,107 @@ func setupLogging(debug bool) {
 		NoColor:    true})
 }
 
+// checkGUID runs the check-guid command which prints the json report of the guid lifecycle checks,
+// it returns the command exit code which is 0 iff all the checks passed
+func checkGUID(args []string) int {
+	var debug bool
+	var guidFlag string
+	flags := flag.NewFlagSet("check-guid", flag.ExitOnError)
+	flags.BoolVar(&debug, "debug", false, "Debug level logging")
+	flags.StringVar(&guidFlag, "guid", "", "GUID to check, e.g 02:00:00:00:00:00:00:01")
+	_ = flags.Parse(args)
+
+	setupLogging(debug)
+
+	guidAddr, err := net.ParseMAC(guidFlag)
+	if err != nil {
+		log.Error().Msgf("invalid --guid value %q: %v", guidFlag, err)
+		return exitError
+	}
+
+	report, err := daemon.CheckGUID(guidAddr)
+	if err != nil {
+		log.Error().Msgf("failed to check guid %s: %v", guidAddr, err)
+		return exitError
+	}
+
+	reportData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Error().Msgf("failed to dump guid check report into json: %v", err)
+		return exitError
+	}
+	fmt.Println(string(reportData))
+
+	if !report.Healthy {
+		return exitError
+	}
+	return 0
+}
+
+// printStatus runs the status command which prints the status of the running daemon, it returns the command exit
+// code which is the daemon health level, or critical if the daemon can't be reached
+func printStatus() int {
+	daemonStatus, err := daemon.GetStatus()
+	if err != nil {
+		log.Error().Msgf("failed to get daemon status: %v", err)
+		return status.Critical
+	}
+
+	fmt.Println(daemonStatus.String())
+	return daemonStatus.Health()
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check-guid" {
+		os.Exit(checkGUID(os.Args[2:]))
+	}
+
 	var debug bool
+	var printStatusFlag bool
+	var pprofAddr string
+	var simulateAPILatency string
+	options := daemon.Options{}
 	flag.BoolVar(&debug, "debug", false, "Debug level logging")
+	flag.BoolVar(&printStatusFlag, "status", false,
+		"Print the status of the running daemon, exit code is 0 if healthy, 1 if degraded and 2 if critical")
+	flag.StringVar(&pprofAddr, "pprof-addr", "", "Address to serve the net/http/pprof handlers on, disabled if empty")
+	flag.StringVar(&simulateAPILatency, "simulate-api-latency", "",
+		"Gaussian latency added to kubernetes api calls for performance testing, e.g mean=5ms,stddev=2ms")
+	flag.Float64Var(&options.APIErrorRate, "api-error-rate", 0,
+		"Rate of kubernetes api calls failed with a simulated error for performance testing, e.g 0.01")
 	flag.Parse()
 
 	setupLogging(debug)
 
+	if printStatusFlag {
+		os.Exit(printStatus())
+	}
+
+	if simulateAPILatency != "" {
+		latency, err := k8sClient.ParseSimulatedLatency(simulateAPILatency)
+		if err != nil {
+			log.Error().Msgf("invalid --simulate-api-latency value: %v", err)
+			os.Exit(exitError)
+		}
+		options.SimulatedAPILatency = latency
+	}
+
+	if options.APIErrorRate < 0 || options.APIErrorRate > 1 {
+		log.Error().Msgf("invalid --api-error-rate value %v, should be between 0 and 1", options.APIErrorRate)
+		os.Exit(exitError)
+	}
+
+	if pprofAddr != "" {
+		go func() {
+			log.Info().Msgf("Serving pprof on %s", pprofAddr)
+			if err := profiling.NewServer(pprofAddr).ListenAndServe(); err != http.ErrServerClosed {
+				log.Error().Msgf("pprof server failed: %v", err)
+			}
+		}()
+	}
+
 	log.Info().Msg("Starting InfiniBand Daemon")
-	ibDaemon, err := daemon.NewDaemon()
+	ibDaemon, err := daemon.NewDaemon(options)
 	if err != nil {
 		log.Error().Msgf("failed to create daemon: %v", err)
 		os.Exit(exitError)
@@ -0,0 +1,121 @@
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto copies the guid migration into out
+func (m *GUIDMigration) DeepCopyInto(out *GUIDMigration) {
+	*out = *m
+	out.TypeMeta = m.TypeMeta
+	m.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	m.Spec.PodSelector.DeepCopyInto(&out.Spec.PodSelector)
+	out.Status = m.Status
+}
+
+// DeepCopy returns a copy of the guid migration
+func (m *GUIDMigration) DeepCopy() *GUIDMigration {
+	if m == nil {
+		return nil
+	}
+	out := &GUIDMigration{}
+	m.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the guid migration as runtime object
+func (m *GUIDMigration) DeepCopyObject() runtime.Object {
+	if c := m.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the guid migrations list into out
+func (l *GUIDMigrationList) DeepCopyInto(out *GUIDMigrationList) {
+	*out = *l
+	out.TypeMeta = l.TypeMeta
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	if l.Items != nil {
+		out.Items = make([]GUIDMigration, len(l.Items))
+		for index := range l.Items {
+			l.Items[index].DeepCopyInto(&out.Items[index])
+		}
+	}
+}
+
+// DeepCopy returns a copy of the guid migrations list
+func (l *GUIDMigrationList) DeepCopy() *GUIDMigrationList {
+	if l == nil {
+		return nil
+	}
+	out := &GUIDMigrationList{}
+	l.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the guid migrations list as runtime object
+func (l *GUIDMigrationList) DeepCopyObject() runtime.Object {
+	if c := l.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the pKey reservation into out
+func (r *PKeyReservation) DeepCopyInto(out *PKeyReservation) {
+	*out = *r
+	out.TypeMeta = r.TypeMeta
+	r.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = r.Spec
+	out.Status = r.Status
+}
+
+// DeepCopy returns a copy of the pKey reservation
+func (r *PKeyReservation) DeepCopy() *PKeyReservation {
+	if r == nil {
+		return nil
+	}
+	out := &PKeyReservation{}
+	r.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the pKey reservation as runtime object
+func (r *PKeyReservation) DeepCopyObject() runtime.Object {
+	if c := r.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the pKey reservations list into out
+func (l *PKeyReservationList) DeepCopyInto(out *PKeyReservationList) {
+	*out = *l
+	out.TypeMeta = l.TypeMeta
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	if l.Items != nil {
+		out.Items = make([]PKeyReservation, len(l.Items))
+		for index := range l.Items {
+			l.Items[index].DeepCopyInto(&out.Items[index])
+		}
+	}
+}
+
+// DeepCopy returns a copy of the pKey reservations list
+func (l *PKeyReservationList) DeepCopy() *PKeyReservationList {
+	if l == nil {
+		return nil
+	}
+	out := &PKeyReservationList{}
+	l.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the pKey reservations list as runtime object
+func (l *PKeyReservationList) DeepCopyObject() runtime.Object {
+	if c := l.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+// Package v1alpha1 is the v1alpha1 version of the ib.mellanox.com api group of the InfiniBand kubernetes resources
+package v1alpha1
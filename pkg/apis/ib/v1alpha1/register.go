@@ -0,0 +1,33 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the api group of the InfiniBand kubernetes resources
+const GroupName = "ib.mellanox.com"
+
+// GUIDMigrationsResource is the resource name of the guid migration crd
+const GUIDMigrationsResource = "guidmigrations"
+
+// PKeyReservationsResource is the resource name of the pKey reservation crd
+const PKeyReservationsResource = "pkeyreservations"
+
+// SchemeGroupVersion is the group version of the resources of this package
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+var (
+	// SchemeBuilder registers the resources of this package in a scheme
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	// AddToScheme adds the resources of this package to the scheme
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion, &GUIDMigration{}, &GUIDMigrationList{}, &PKeyReservation{},
+		&PKeyReservationList{})
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+	return nil
+}
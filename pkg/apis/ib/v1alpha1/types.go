@@ -0,0 +1,105 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GUIDMigrationPhase is the phase of a guid migration
+type GUIDMigrationPhase string
+
+// The phases of a guid migration in their order, Failed is the final phase of migrations which can't complete
+const (
+	// GUIDMigrationPending migrations aren't started yet, the new guid is allocated for the pod network
+	GUIDMigrationPending GUIDMigrationPhase = "Pending"
+	// GUIDMigrationAdding migrations add the new guid to the network pKey and set it in the pod network annotation
+	GUIDMigrationAdding GUIDMigrationPhase = "Adding"
+	// GUIDMigrationVerifying migrations wait for the new guid to be reachable on the fabric
+	GUIDMigrationVerifying GUIDMigrationPhase = "Verifying"
+	// GUIDMigrationRemoving migrations remove the old guid from the network pKey and release it
+	GUIDMigrationRemoving GUIDMigrationPhase = "Removing"
+	// GUIDMigrationComplete migrations moved the pod network to the new guid
+	GUIDMigrationComplete GUIDMigrationPhase = "Complete"
+	// GUIDMigrationFailed migrations can't be completed, e.g the new guid is already allocated
+	GUIDMigrationFailed GUIDMigrationPhase = "Failed"
+)
+
+// GUIDMigration moves the guid of a running pod network to a new guid without restarting the pod,
+// e.g after replacing the hardware of the pod node
+type GUIDMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GUIDMigrationSpec   `json:"spec"`
+	Status GUIDMigrationStatus `json:"status,omitempty"`
+}
+
+// GUIDMigrationSpec is the pod network to migrate and its new guid
+type GUIDMigrationSpec struct {
+	// PodSelector selects the pod in the migration namespace, it must select a single pod
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+	// NetworkName is the name of the pod InfiniBand network
+	NetworkName string `json:"networkName"`
+	// NewGUID is the guid the pod network is migrated to
+	NewGUID string `json:"newGUID"`
+}
+
+// GUIDMigrationStatus is the progress of the guid migration
+type GUIDMigrationStatus struct {
+	// Phase is the current phase of the migration, Pending if empty
+	Phase GUIDMigrationPhase `json:"phase,omitempty"`
+	// PodName is the name of the selected pod
+	PodName string `json:"podName,omitempty"`
+	// OldGUID is the guid of the pod network before the migration
+	OldGUID string `json:"oldGUID,omitempty"`
+	// PKey is the pKey of the pod network, empty if the network has no pKey
+	PKey string `json:"pKey,omitempty"`
+	// Message is the reason of the last failure of the current phase, or of the migration failure
+	Message string `json:"message,omitempty"`
+}
+
+// GUIDMigrationList is a list of guid migrations
+type GUIDMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []GUIDMigration `json:"items"`
+}
+
+// IsFinished checks whether the migration completed or failed
+func (m *GUIDMigration) IsFinished() bool {
+	return m.Status.Phase == GUIDMigrationComplete || m.Status.Phase == GUIDMigrationFailed
+}
+
+// PKeyReservation reserves seats of a pKey for the pods of a namespace, guids of the namespace pods are added to the
+// pKey only while the namespace has free seats
+type PKeyReservation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PKeyReservationSpec   `json:"spec"`
+	Status PKeyReservationStatus `json:"status,omitempty"`
+}
+
+// PKeyReservationSpec is the pKey, the namespace and its seats
+type PKeyReservationSpec struct {
+	// PKey is the reserved pKey, e.g "0x10"
+	PKey string `json:"pkey"`
+	// Namespace is the namespace of the pods the seats are reserved for
+	Namespace string `json:"namespace"`
+	// MaxSeats is the maximal number of guids of the namespace pods in the pKey
+	MaxSeats int `json:"maxSeats"`
+}
+
+// PKeyReservationStatus is the usage of the reserved seats
+type PKeyReservationStatus struct {
+	// CurrentSeats is the number of guids of the namespace pods in the pKey
+	CurrentSeats int `json:"currentSeats"`
+}
+
+// PKeyReservationList is a list of pKey reservations
+type PKeyReservationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []PKeyReservation `json:"items"`
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// AddRecord is the record type of pods which got guid added
+	AddRecord = "ADD"
+	// DeleteRecord is the record type of pods which got guid released
+	DeleteRecord = "DELETE"
+
+	reconnectInterval = time.Second
+)
+
+// Record is an audit record of pod guid change
+type Record struct {
+	Type      string    `json:"type"`
+	Pod       string    `json:"pod"`
+	GUID      string    `json:"guid"`
+	PKey      string    `json:"pkey"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type Auditor interface {
+	// Audit queues the record to be sent without blocking, the record is dropped if the buffer is full
+	Audit(record *Record)
+	// Run sends the queued records to the audit socket until the stop channel is closed
+	Run(stopChan <-chan struct{})
+}
+
+type auditor struct {
+	socketPath string
+	records    chan *Record
+	writer     io.WriteCloser
+}
+
+// NewAuditor returns an auditor which writes records as json lines to the given unix socket or named pipe,
+// up to bufferSize records are kept while the receiver is disconnected.
+func NewAuditor(socketPath string, bufferSize int) Auditor {
+	return &auditor{socketPath: socketPath, records: make(chan *Record, bufferSize)}
+}
+
+func (a *auditor) Audit(record *Record) {
+	select {
+	case a.records <- record:
+	default:
+		log.Warn().Msgf("audit buffer is full, dropping audit record %+v", record)
+	}
+}
+
+func (a *auditor) Run(stopChan <-chan struct{}) {
+	defer a.disconnect()
+	var pending *Record
+	for {
+		if pending == nil {
+			select {
+			case <-stopChan:
+				return
+			case pending = <-a.records:
+			}
+		}
+
+		if err := a.write(pending); err != nil {
+			log.Warn().Msgf("failed to write audit record to %s with error: %v", a.socketPath, err)
+			a.disconnect()
+			select {
+			case <-stopChan:
+				return
+			case <-time.After(reconnectInterval):
+			}
+			continue
+		}
+		pending = nil
+	}
+}
+
+func (a *auditor) write(record *Record) error {
+	if a.writer == nil {
+		if err := a.connect(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record %+v: %v", record, err)
+	}
+
+	_, err = a.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (a *auditor) connect() error {
+	info, err := os.Stat(a.socketPath)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		a.writer, err = os.OpenFile(a.socketPath, os.O_WRONLY, os.ModeNamedPipe)
+		return err
+	}
+
+	a.writer, err = net.Dial("unix", a.socketPath)
+	return err
+}
+
+func (a *auditor) disconnect() {
+	if a.writer == nil {
+		return
+	}
+
+	if err := a.writer.Close(); err != nil {
+		log.Debug().Msgf("failed to close audit socket %s: %v", a.socketPath, err)
+	}
+	a.writer = nil
+}
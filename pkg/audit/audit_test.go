@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Auditor", func() {
+	var tmpDir string
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "audit")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).ToNot(HaveOccurred())
+	})
+	Context("Audit", func() {
+		It("Drop records when buffer is full", func() {
+			a := NewAuditor(filepath.Join(tmpDir, "audit.sock"), 1).(*auditor)
+			a.Audit(&Record{Type: AddRecord, Pod: "default/pod1"})
+			a.Audit(&Record{Type: AddRecord, Pod: "default/pod2"})
+			Expect(len(a.records)).To(Equal(1))
+			Expect((<-a.records).Pod).To(Equal("default/pod1"))
+		})
+	})
+	Context("Run", func() {
+		It("Send records buffered before the receiver is listening", func() {
+			socketPath := filepath.Join(tmpDir, "audit.sock")
+			a := NewAuditor(socketPath, 10)
+			a.Audit(&Record{Type: AddRecord, Pod: "default/pod", GUID: "02:00:00:00:00:00:00:00", PKey: "0x10"})
+
+			stopChan := make(chan struct{})
+			defer close(stopChan)
+			go a.Run(stopChan)
+
+			listener, err := net.Listen("unix", socketPath)
+			Expect(err).ToNot(HaveOccurred())
+			defer listener.Close()
+
+			conn, err := listener.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			line, err := bufio.NewReader(conn).ReadBytes('\n')
+			Expect(err).ToNot(HaveOccurred())
+			record := &Record{}
+			Expect(json.Unmarshal(line, record)).To(Succeed())
+			Expect(record.Type).To(Equal(AddRecord))
+			Expect(record.Pod).To(Equal("default/pod"))
+			Expect(record.GUID).To(Equal("02:00:00:00:00:00:00:00"))
+			Expect(record.PKey).To(Equal("0x10"))
+		})
+	})
+})
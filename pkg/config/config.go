@@ -1,18 +1,314 @@
 package config
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// maxPercent is the maximum value of the percent options
+const maxPercent = 100
+
+// guidLength is the length in bytes of the guids
+const guidLength = 8
+
 type DaemonConfig struct {
 	// Interval between every check for the added and deleted pods
 	PeriodicUpdate int `env:"DAEMON_PERIODIC_UPDATE" envDefault:"5"`
-	GUIDPool       GUIDPoolConfig
-	// Subnet manager plugin name
+	// Interval between every check for the added pods, PeriodicUpdate if 0
+	AddPeriodicUpdateInterval int `env:"DAEMON_ADD_PERIODIC_UPDATE_INTERVAL" envDefault:"0"`
+	// Interval between every check for the deleted pods, PeriodicUpdate if 0
+	DeletePeriodicUpdateInterval int `env:"DAEMON_DELETE_PERIODIC_UPDATE_INTERVAL" envDefault:"0"`
+	GUIDPool                     GUIDPoolConfig
+	// Range of pKeys assigned to the networks without pKey
+	PKeyPool PKeyPoolConfig
+	// Add and remove the pods guids in the subnet manager pKeys, when false the guids are only allocated and
+	// annotated, e.g for partitions managed by an external tool, and the plugin isn't loaded
+	ManageSubnetManager bool `env:"DAEMON_MANAGE_SUBNET_MANAGER" envDefault:"true"`
+	// Subnet manager plugin name, required if ManageSubnetManager is set
 	Plugin string `env:"DAEMON_SM_PLUGIN"`
+	// Path of the subnet manager plugin configuration file, e.g a mounted Secret, passed to the plugins implementing
+	// Init, disabled if empty
+	PluginConfPath string `env:"DAEMON_SM_PLUGIN_CONF_PATH"`
+	// Duration in seconds to retry loading and validating the subnet manager plugins on startup, with exponential
+	// backoff, before the daemon fails. The plugins are loaded once if 0.
+	StartupTimeout int `env:"DAEMON_STARTUP_TIMEOUT" envDefault:"60"`
+	// Subnet prefix of the gids the guids are added to the pKeys by, e.g "fe80::" or "0xfe80000000000000", for
+	// subnet managers identifying the pKey members by gid. The guids are added by guid if empty.
+	SubnetPrefix string `env:"DAEMON_SUBNET_PREFIX"`
+	// Log the subnet manager and kubernetes changes instead of applying them, the guids are allocated only in memory
+	DryRun bool `env:"DAEMON_DRY_RUN" envDefault:"false"`
+	// Verify that added guids are members of the pkey in the subnet manager after adding them
+	VerifySMAdditions bool `env:"DAEMON_VERIFY_SM_ADDITIONS" envDefault:"false"`
+	// Reject the user allocated guids which are already members in the subnet manager of the network pKey or of the
+	// pKeys of the allocated guids
+	CheckUserGUIDsInSM bool `env:"DAEMON_CHECK_USER_GUIDS_IN_SM" envDefault:"false"`
+	// Annotate the pods with the speed and width of their InfiniBand ports from the subnet manager
+	AnnotatePortCapabilities bool `env:"DAEMON_ANNOTATE_PORT_CAPABILITIES" envDefault:"false"`
+	// Maximum number of guids the subnet manager allows in a single pkey
+	MaxGUIDsPerPKey int `env:"DAEMON_MAX_GUIDS_PER_PKEY" envDefault:"8192"`
+	// Processing priority of networks by network name, higher priority networks are processed first
+	NetworkPriorities map[string]int `env:"DAEMON_NETWORK_PRIORITIES"`
+	// Path of unix socket or named pipe to write pods audit records to, disabled if empty
+	AuditSocket string `env:"DAEMON_AUDIT_SOCKET"`
+	// Number of audit records to buffer while the audit socket receiver is disconnected
+	AuditBufferSize int `env:"DAEMON_AUDIT_BUFFER_SIZE" envDefault:"1000"`
+	// Allocate a guid range for every InfiniBand network attachment definition to assign its pods guids from
+	ManageNADGUIDs bool `env:"DAEMON_MANAGE_NAD_GUIDS" envDefault:"false"`
+	// Number of guids in the guid range allocated for every network attachment definition
+	NADGUIDRangeSize int `env:"DAEMON_NAD_GUID_RANGE_SIZE" envDefault:"256"`
+	// Hold pods of namespaces which exceeded their InfiniBand resource quota until the quota is available
+	EnableQuotaCheck bool `env:"DAEMON_ENABLE_QUOTA_CHECK" envDefault:"false"`
+	// Run as a sidecar of the CNI plugin, handling only the pods of the current node
+	SidecarMode bool `env:"DAEMON_SIDECAR_MODE" envDefault:"false"`
+	// Name of the current node, required in sidecar mode
+	NodeName string `env:"NODE_NAME"`
+	// Path of unix socket to serve the CNI plugin guid requests on in sidecar mode
+	SidecarSocket string `env:"DAEMON_SIDECAR_SOCKET" envDefault:"/var/run/ib-kubernetes/daemon.sock"`
+	// Path of unix socket to serve the daemon status requests on, disabled if empty
+	StatusSocket string `env:"DAEMON_STATUS_SOCKET" envDefault:"/var/run/ib-kubernetes/status.sock"`
+	// Maximum number of guids allocated from the guid pool to the pods of a namespace, mapped by namespace
+	NamespaceGUIDQuotas map[string]int `env:"DAEMON_NAMESPACE_GUID_QUOTAS"`
+	// Duration in seconds of the cpu profile triggered by SIGUSR2
+	CPUProfileDuration int `env:"DAEMON_CPU_PROFILE_DURATION" envDefault:"30"`
+	// Interval in seconds to compare a sample of the cluster pods with the pods watcher cache, disabled if 0
+	DesyncCheckInterval int `env:"DAEMON_DESYNC_CHECK_INTERVAL" envDefault:"300"`
+	// Number of pods the watcher cache may differ from the cluster pods before it is re-listed
+	DesyncThreshold int `env:"DAEMON_DESYNC_THRESHOLD" envDefault:"5"`
+	// PKey usage percent above which a warning is logged when adding guids
+	PKeyUsageWarningPercent int `env:"DAEMON_PKEY_USAGE_WARNING_PERCENT" envDefault:"80"`
+	// PKey usage percent above which guids aren't added to the pkey
+	PKeyUsageBlockPercent int `env:"DAEMON_PKEY_USAGE_BLOCK_PERCENT" envDefault:"95"`
+	// DNS zone of the guid to pod TXT records, e.g "ib.cluster.local", disabled if empty
+	GUIDDNSZone string `env:"DAEMON_GUID_DNS_ZONE"`
+	// Config map "<namespace>/<name>" to write the guid dns zone file to, served by the CoreDNS file plugin
+	GUIDDNSConfigMap string `env:"DAEMON_GUID_DNS_CONFIGMAP"`
+	// Remove guids of pods which no longer exist from the network attachment definitions pKeys on startup
+	CleanSMOnStartup bool `env:"DAEMON_CLEAN_SM_ON_STARTUP" envDefault:"false"`
+	// Config map "<namespace>/<name>" to watch for configuration changes applied without restart, disabled if empty
+	ConfigMap string `env:"DAEMON_CONFIGMAP"`
+	// Reject adding guids to a pKey which would be shared by pods of different namespaces
+	EnforceNamespaceIsolation bool `env:"DAEMON_ENFORCE_NAMESPACE_ISOLATION" envDefault:"false"`
+	// Duration in seconds the namespaces with InfiniBand networks are cached, listed on every use if 0
+	NamespaceCacheTTL int `env:"DAEMON_NAMESPACE_CACHE_TTL" envDefault:"300"`
+	// Serve guid allocation grpc requests of the Multus thick plugin daemon
+	MultusGRPCMode bool `env:"DAEMON_MULTUS_GRPC_MODE" envDefault:"false"`
+	// Unix socket of the guid allocation grpc server
+	MultusGRPCSocket string `env:"DAEMON_MULTUS_GRPC_SOCKET" envDefault:"/var/run/ib-kubernetes/grpc.sock"`
+	// Address of the https admission webhooks server, e.g ":8443", disabled if empty
+	WebhookAddress string `env:"DAEMON_WEBHOOK_ADDRESS"`
+	// Address of the http server of the Prometheus metrics, e.g ":9090", disabled if empty
+	MetricsAddress string `env:"DAEMON_METRICS_ADDRESS"`
+	// Address of the http server of the /healthz liveness and /readyz readiness probes, e.g ":8080", disabled if empty
+	HealthAddress string `env:"DAEMON_HEALTH_ADDRESS"`
+	// TLS certificate file of the admission webhooks server
+	WebhookCertFile string `env:"DAEMON_WEBHOOK_CERT_FILE" envDefault:"/etc/ib-kubernetes/webhook/tls.crt"`
+	// TLS key file of the admission webhooks server
+	WebhookKeyFile string `env:"DAEMON_WEBHOOK_KEY_FILE" envDefault:"/etc/ib-kubernetes/webhook/tls.key"`
+	// Maximum subnet manager pKey additions per second of every network, unlimited if 0
+	MaxSMCallsPerNetworkPerSecond float64 `env:"DAEMON_MAX_SM_CALLS_PER_NETWORK_PER_SECOND" envDefault:"10"`
+	// Duration in milliseconds to wait for the network rate limit before retrying the network on the next update
+	SMCallWaitTimeout int `env:"DAEMON_SM_CALL_WAIT_TIMEOUT" envDefault:"100"`
+	// Timeout in seconds of every subnet manager call, the calls aren't bounded by the daemon if 0
+	SubnetManagerTimeout int `env:"DAEMON_SUBNET_MANAGER_TIMEOUT" envDefault:"120"`
+	// Maximum guids of every subnet manager pKey addition or removal, the guids are split to batches, unlimited if 0
+	SMMaxBatchSize int `env:"DAEMON_SM_MAX_BATCH_SIZE" envDefault:"0"`
+	// Duration in milliseconds to wait between the batches of a subnet manager pKey addition or removal
+	SMBatchInterval int `env:"DAEMON_SM_BATCH_INTERVAL" envDefault:"0"`
+	// Maximum retries with exponential backoff of setting the pods annotations when the pods changed concurrently
+	PodAnnotationRetries int `env:"DAEMON_POD_ANNOTATION_RETRIES" envDefault:"3"`
+	// Add a finalizer to the pods whose guids are configured, so deleted pods are kept until their guids are released
+	// even if the daemon misses their deletion
+	PodFinalizer bool `env:"DAEMON_POD_FINALIZER" envDefault:"false"`
+	// Maximum add updates retries of a pod failing on its own, e.g with a malformed annotation, unlimited if 0
+	MaxPodRetries int `env:"DAEMON_MAX_POD_RETRIES" envDefault:"0"`
+	// Maximum number of concurrent pod annotation writes to the api server, unlimited if 0
+	MaxConcurrentAnnotationWrites int `env:"DAEMON_MAX_CONCURRENT_ANNOTATION_WRITES" envDefault:"0"`
+	// Maximum number of networks processed concurrently by the add update, networks which share pods are processed
+	// by the same worker
+	MaxConcurrentNetworks int `env:"DAEMON_MAX_CONCURRENT_NETWORKS" envDefault:"1"`
+	// Duration in seconds of the last add update flushing the pending pods on termination, disabled if 0
+	DrainTimeout int `env:"DAEMON_DRAIN_TIMEOUT" envDefault:"10"`
+	// Write the pKey changes to both the Plugin and the SecondaryPlugin subnet managers, e.g during migration
+	DualWriteSM bool `env:"DAEMON_DUAL_WRITE_SM" envDefault:"false"`
+	// Run the periodic updates only in the daemon replica holding the leader election lease, the other replicas
+	// wait to take over
+	EnableLeaderElection bool `env:"DAEMON_ENABLE_LEADER_ELECTION" envDefault:"false"`
+	// Lease "<namespace>/<name>" of the leader election
+	LeaderElectionLease string `env:"DAEMON_LEADER_ELECTION_LEASE" envDefault:"kube-system/ib-kubernetes-leader"`
+	// Secondary subnet manager plugin of the dual write mode, its failures don't fail the pKey changes
+	SecondaryPlugin string `env:"DAEMON_SECONDARY_SM_PLUGIN"`
+	// Path of the secondary subnet manager plugin configuration file, disabled if empty
+	SecondaryPluginConfPath string `env:"DAEMON_SECONDARY_SM_PLUGIN_CONF_PATH"`
+	// Format of the serialized guid pool state, "json" or the compact "binary" format for large pools
+	PoolSerializationFormat string `env:"DAEMON_POOL_SERIALIZATION_FORMAT" envDefault:"json"`
+	// Format of the daemon logs, "text" or "json" objects with the log fields as keys, set on startup
+	LogFormat string `env:"DAEMON_LOG_FORMAT" envDefault:"text"`
+	// Format of the guids in the pods network annotations, "colon", "dash" or "hex" separated bytes
+	GUIDFormat string `env:"DAEMON_GUID_FORMAT" envDefault:"colon"`
+	// Duration in seconds without fabric activity after which a guid is removed from its pKey, disabled if 0
+	IdleGUIDEvictionTimeout int `env:"DAEMON_IDLE_GUID_EVICTION_TIMEOUT" envDefault:"0"`
+	// Interval in seconds to reclaim the guids of pods deleted without a delete event, only on startup if 0
+	ReconcilePeriod int `env:"DAEMON_RECONCILE_PERIOD" envDefault:"0"`
+	// Interval in seconds to add the guids of the configured pods missing from their pKeys in the subnet manager
+	// back, on startup and periodically, disabled if 0
+	SMReconcileInterval int `env:"DAEMON_SM_RECONCILE_INTERVAL" envDefault:"0"`
+	// Generate guids from sub-ranges of the guid pool claimed by the node, requires sidecar mode
+	PerNodePool bool `env:"DAEMON_PER_NODE_POOL" envDefault:"false"`
+	// Number of guids in a sub-range claimed by a node
+	PerNodePoolSize int `env:"DAEMON_PER_NODE_POOL_SIZE" envDefault:"1000"`
+	// Config map "<namespace>/<name>" registering the sub-ranges claimed by the nodes
+	PerNodePoolConfigMap string `env:"DAEMON_PER_NODE_POOL_CONFIGMAP" envDefault:"kube-system/ib-kubernetes-node-ranges"`
+	// Extend the guid pool range when the SR-IOV VFs count of the node increases, requires the node name
+	WatchNodeVFs bool `env:"DAEMON_WATCH_NODE_VFS" envDefault:"false"`
+	// Release the guids of the pods of deleted namespaces without waiting for the pods delete events
+	WatchNamespaceDeletion bool `env:"DAEMON_WATCH_NAMESPACE_DELETION" envDefault:"false"`
+	// Generate the pods guids next to the guids of the pods connected to the same switch as their node
+	TopologyAwareAllocation bool `env:"DAEMON_TOPOLOGY_AWARE_ALLOCATION" envDefault:"false"`
+	// Duration in seconds the fabric topology is cached for the topology aware allocation
+	TopologyCacheTTL int `env:"DAEMON_TOPOLOGY_CACHE_TTL" envDefault:"300"`
+	// Re-allocate the guids of pods whose IP changed if their guid annotation is missing or invalid
+	TrackPodIPChanges bool `env:"DAEMON_TRACK_POD_IP_CHANGES" envDefault:"false"`
+	// Migrate the guids of running pods networks requested by GUIDMigration resources, requires the crd
+	EnableGUIDMigration bool `env:"DAEMON_ENABLE_GUID_MIGRATION" envDefault:"false"`
+	// Selector of the pods annotations, e.g "hpc.example.com/workload-class=mpi", guids are allocated only for the
+	// selected pods. Empty selects all the pods.
+	PodFieldSelector string `env:"DAEMON_POD_FIELD_SELECTOR"`
+	// Selector of the pods labels, e.g "network=infiniband", the pods which aren't selected aren't watched.
+	// Empty selects all the pods.
+	PodLabelSelector string `env:"DAEMON_POD_LABEL_SELECTOR"`
+	// Namespaces of the pods which guids are allocated for, all the namespaces if empty
+	WatchNamespaces []string `env:"DAEMON_WATCH_NAMESPACES"`
+	// Namespaces of the pods which guids aren't allocated for, excluded from the watched namespaces
+	ExcludeNamespaces []string `env:"DAEMON_EXCLUDE_NAMESPACES"`
+	// Limit the guids of the namespaces pods in pKeys to the seats of their PKeyReservation, requires the crd
+	EnablePKeyReservations bool `env:"DAEMON_ENABLE_PKEY_RESERVATIONS" envDefault:"false"`
+	// Secret of the key signing the pods guids as "<namespace>/<name>", disabled if empty. Guids of deleted pods
+	// which don't match their signature aren't released.
+	GUIDSigningKeySecret string `env:"DAEMON_GUID_SIGNING_KEY_SECRET"`
+	// Key of the pods networks cni-args marking the networks configured with InfiniBand, the default key if empty,
+	// set on startup. Daemons of different fabrics should use different keys, the networks marked with the default
+	// key are still recognized.
+	InfiniBandAnnotationKey string `env:"DAEMON_INFINIBAND_ANNOTATION_KEY" envDefault:"mellanox.infiniband.app"`
+	// Serve the debug endpoints, e.g the guid pool allocations on /debug/guidpool, on the health probes server
+	EnableDebugEndpoints bool `env:"DAEMON_ENABLE_DEBUG_ENDPOINTS" envDefault:"false"`
+	// Name and namespace of the daemon pod, the guid pool changes are reported as events of the pod if set
+	PodName      string `env:"POD_NAME"`
+	PodNamespace string `env:"POD_NAMESPACE"`
+}
+
+// GetAddPeriodicUpdateInterval returns the interval in seconds between every check for the added pods
+func (dc *DaemonConfig) GetAddPeriodicUpdateInterval() int {
+	if dc.AddPeriodicUpdateInterval == 0 {
+		return dc.PeriodicUpdate
+	}
+	return dc.AddPeriodicUpdateInterval
+}
+
+// GetDeletePeriodicUpdateInterval returns the interval in seconds between every check for the deleted pods
+func (dc *DaemonConfig) GetDeletePeriodicUpdateInterval() int {
+	if dc.DeletePeriodicUpdateInterval == 0 {
+		return dc.PeriodicUpdate
+	}
+	return dc.DeletePeriodicUpdateInterval
+}
+
+// GetGUIDDNSConfigMap returns the namespace and name of the guid dns zone config map
+func (dc *DaemonConfig) GetGUIDDNSConfigMap() (namespace, name string, err error) {
+	return parseNamespacedName("GUIDDNSConfigMap", dc.GUIDDNSConfigMap)
+}
+
+// GetPerNodePoolConfigMap returns the namespace and name of the nodes sub-ranges config map
+func (dc *DaemonConfig) GetPerNodePoolConfigMap() (namespace, name string, err error) {
+	return parseNamespacedName("PerNodePoolConfigMap", dc.PerNodePoolConfigMap)
+}
+
+// GetLeaderElectionLease returns the namespace and name of the leader election lease
+func (dc *DaemonConfig) GetLeaderElectionLease() (namespace, name string, err error) {
+	return parseNamespacedName("LeaderElectionLease", dc.LeaderElectionLease)
+}
+
+// GetConfigMap returns the namespace and name of the watched configuration config map
+func (dc *DaemonConfig) GetConfigMap() (namespace, name string, err error) {
+	return parseNamespacedName("ConfigMap", dc.ConfigMap)
+}
+
+// GetGUIDSigningKeySecret returns the namespace and name of the guid signing key secret
+func (dc *DaemonConfig) GetGUIDSigningKeySecret() (namespace, name string, err error) {
+	return parseNamespacedName("GUIDSigningKeySecret", dc.GUIDSigningKeySecret)
+}
+
+// GetPodFieldSelector returns the selector of the pods annotations which guids are allocated for
+func (dc *DaemonConfig) GetPodFieldSelector() (labels.Selector, error) {
+	selector, err := labels.Parse(dc.PodFieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid \"PodFieldSelector\" value %q: %v", dc.PodFieldSelector, err)
+	}
+
+	return selector, nil
+}
+
+// GetSubnetPrefix returns the subnet prefix as a 16 bytes gid without port guid, nil if no subnet prefix is set
+func (dc *DaemonConfig) GetSubnetPrefix() (net.IP, error) {
+	if dc.SubnetPrefix == "" {
+		return nil, nil
+	}
+
+	var prefix net.IP
+	if lowerPrefix := strings.ToLower(dc.SubnetPrefix); strings.HasPrefix(lowerPrefix, "0x") {
+		if value, err := strconv.ParseUint(lowerPrefix[2:], 16, 64); err == nil {
+			prefix = make(net.IP, net.IPv6len)
+			binary.BigEndian.PutUint64(prefix, value)
+		}
+	} else if ip := net.ParseIP(dc.SubnetPrefix); ip != nil && ip.To4() == nil {
+		prefix = ip
+	}
+
+	if prefix == nil || binary.BigEndian.Uint64(prefix[net.IPv6len-guidLength:]) != 0 {
+		return nil, fmt.Errorf("invalid \"SubnetPrefix\" value %q, should be an ipv6 prefix without port guid, "+
+			"e.g fe80:: or 0xfe80000000000000", dc.SubnetPrefix)
+	}
+	return prefix, nil
+}
+
+// validateNamespaces checks the namespaces of the given option are valid namespace names
+func validateNamespaces(option string, namespaces []string) error {
+	for _, namespace := range namespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) != 0 {
+			return fmt.Errorf("invalid %q namespace %q: %s", option, namespace, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// GetPodLabelSelector returns the selector of the labels of the watched pods
+func (dc *DaemonConfig) GetPodLabelSelector() (labels.Selector, error) {
+	selector, err := labels.Parse(dc.PodLabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid \"PodLabelSelector\" value %q: %v", dc.PodLabelSelector, err)
+	}
+
+	return selector, nil
+}
+
+// parseNamespacedName parses "<namespace>/<name>" value of the given option
+func parseNamespacedName(option, value string) (namespace, name string, err error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid %q value %q, should be <namespace>/<name>", option, value)
+	}
+
+	return parts[0], parts[1], nil
 }
 
 type GUIDPoolConfig struct {
@@ -20,22 +316,549 @@ type GUIDPoolConfig struct {
 	RangeStart string `env:"GUID_POOL_RANGE_START" envDefault:"02:00:00:00:00:00:00:00"`
 	// Last guid in the pool
 	RangeEnd string `env:"GUID_POOL_RANGE_END"   envDefault:"02:FF:FF:FF:FF:FF:FF:FF"`
+	// Ranges of the pool which aren't allocated, e.g guids of physical adapters, as comma separated "<start>-<end>"
+	ExcludeRanges []GUIDPoolRangeConfig `env:"GUID_POOL_EXCLUDE_RANGES"`
+	// Guids of the pool which are never allocated, e.g statically assigned to InfiniBand hosts outside the cluster,
+	// as comma separated guids
+	ReservedGUIDs []string `env:"GUID_POOL_RESERVED_GUIDS"`
+	// Index of the guid byte, 0 to 7, holding the hash of the pod namespace name in generated guids,
+	// empty to generate guids regardless of the pod namespace
+	NamespacePrefix string `env:"GUID_POOL_NAMESPACE_PREFIX"`
+	// Order the guids are generated in, "sequential" or "random" picking uniformly from the free guids. Guids with a
+	// namespace prefix are generated sequentially.
+	AllocationStrategy string `env:"GUID_POOL_ALLOCATION_STRATEGY" envDefault:"sequential"`
+	// Backend persisting the guid pool allocations across restarts, "configmap", or empty to restore the allocations
+	// only from the pods annotations
+	PersistenceBackend string `env:"GUID_POOL_PERSISTENCE_BACKEND"`
+	// Config map "<namespace>/<name>" persisting the guid pool allocations with the "configmap" backend
+	PersistenceConfigMap string `env:"GUID_POOL_PERSISTENCE_CONFIGMAP" envDefault:"kube-system/ib-kubernetes-guid-pool"`
+}
+
+// PersistenceBackendConfigMap persists the guid pool allocations in a config map
+const PersistenceBackendConfigMap = "configmap"
+
+// Guid allocation strategies of the guid pool
+const (
+	AllocationStrategySequential = "sequential"
+	AllocationStrategyRandom     = "random"
+)
+
+// GetPersistenceConfigMap returns the namespace and name of the config map persisting the guid pool allocations
+func (gc *GUIDPoolConfig) GetPersistenceConfigMap() (namespace, name string, err error) {
+	return parseNamespacedName("GUIDPool.PersistenceConfigMap", gc.PersistenceConfigMap)
+}
+
+// PKeyPoolConfig is the range of pKeys assigned to the networks whose spec has no pKey
+type PKeyPoolConfig struct {
+	// First pKey in the pool, e.g "0x1000", pKeys aren't assigned if empty
+	RangeStart string `env:"PKEY_POOL_RANGE_START"`
+	// Last pKey in the pool
+	RangeEnd string `env:"PKEY_POOL_RANGE_END"`
+	// Config map "<namespace>/<name>" persisting the pKeys assigned to the networks
+	ConfigMap string `env:"PKEY_POOL_CONFIGMAP" envDefault:"kube-system/ib-kubernetes-pkey-pool"`
+}
+
+// Enabled returns true if pKeys are assigned to the networks without pKey
+func (pc *PKeyPoolConfig) Enabled() bool {
+	return pc.RangeStart != ""
+}
+
+// GetConfigMap returns the namespace and name of the config map persisting the assigned pKeys
+func (pc *PKeyPoolConfig) GetConfigMap() (namespace, name string, err error) {
+	return parseNamespacedName("PKeyPool.ConfigMap", pc.ConfigMap)
+}
+
+// validateRanges checks the guids of the pool range and of the excluded ranges are valid 8 bytes guids, the ranges
+// start isn't after their end and the pool range doesn't include the reserved all-zeros and all-ones guids.
+// The guids which aren't set aren't checked.
+func (gc *GUIDPoolConfig) validateRanges() error {
+	rangeStart, err := parseGUID("GUIDPool.RangeStart", gc.RangeStart)
+	if err != nil {
+		return err
+	}
+	rangeEnd, err := parseGUID("GUIDPool.RangeEnd", gc.RangeEnd)
+	if err != nil {
+		return err
+	}
+
+	if gc.RangeStart != "" && gc.RangeEnd != "" {
+		switch {
+		case rangeStart > rangeEnd:
+			return fmt.Errorf("invalid guid pool range %s - %s, the range start is after the range end",
+				gc.RangeStart, gc.RangeEnd)
+		case rangeStart == 0:
+			return fmt.Errorf("invalid guid pool range %s - %s, the range includes the reserved all-zeros guid",
+				gc.RangeStart, gc.RangeEnd)
+		case rangeEnd == math.MaxUint64:
+			return fmt.Errorf("invalid guid pool range %s - %s, the range includes the reserved all-ones guid",
+				gc.RangeStart, gc.RangeEnd)
+		}
+	}
+
+	for _, excludeRange := range gc.ExcludeRanges {
+		excludeStart, err := parseGUID("GUIDPool.ExcludeRanges", excludeRange.RangeStart)
+		if err != nil {
+			return err
+		}
+		excludeEnd, err := parseGUID("GUIDPool.ExcludeRanges", excludeRange.RangeEnd)
+		if err != nil {
+			return err
+		}
+		if excludeStart > excludeEnd {
+			return fmt.Errorf("invalid guid pool exclude range %s - %s, the range start is after the range end",
+				excludeRange.RangeStart, excludeRange.RangeEnd)
+		}
+	}
+
+	for _, reservedGUID := range gc.ReservedGUIDs {
+		if reservedGUID == "" {
+			return fmt.Errorf("invalid \"GUIDPool.ReservedGUIDs\" value %q, should be an 8 bytes guid", reservedGUID)
+		}
+		guid, err := parseGUID("GUIDPool.ReservedGUIDs", reservedGUID)
+		if err != nil {
+			return err
+		}
+		if gc.RangeStart != "" && gc.RangeEnd != "" && (guid < rangeStart || guid > rangeEnd) {
+			return fmt.Errorf("invalid guid pool reserved guid %s, not within the range %s - %s", reservedGUID,
+				gc.RangeStart, gc.RangeEnd)
+		}
+	}
+
+	return nil
+}
+
+// parseGUID parses the 8 bytes guid value of the option as the guid pool parses it, empty values are parsed as 0
+func parseGUID(option, value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	address, err := net.ParseMAC(value)
+	if err != nil || len(address) != guidLength {
+		return 0, fmt.Errorf("invalid %q value %q, should be an 8 bytes guid, e.g 02:00:00:00:00:00:00:00", option,
+			value)
+	}
+
+	var guid uint64
+	for _, octet := range address {
+		guid = guid<<8 | uint64(octet)
+	}
+	return guid, nil
+}
+
+// GUIDPoolRangeConfig is a range of guids including its first and last guids
+type GUIDPoolRangeConfig struct {
+	RangeStart string
+	RangeEnd   string
 }
 
 func (dc *DaemonConfig) ReadConfig() error {
 	log.Debug().Msg("Reading configuration environment variables")
-	err := env.Parse(dc)
+	err := env.ParseWithFuncs(dc, map[reflect.Type]env.ParserFunc{
+		reflect.TypeOf(map[string]int{}):      parseIntMap,
+		reflect.TypeOf(GUIDPoolRangeConfig{}): parseGUIDPoolRange,
+	})
 
 	return err
 }
 
+// ReadConfigMapData overrides the configuration options with the values of their environment variable keys
+// in the config map data, options missing from the data are unchanged
+func (dc *DaemonConfig) ReadConfigMapData(data map[string]string) error {
+	log.Debug().Msg("Reading configuration config map data")
+	return readData(reflect.ValueOf(dc).Elem(), data)
+}
+
+func readData(value reflect.Value, data map[string]string) error {
+	for index := 0; index < value.NumField(); index++ {
+		field := value.Field(index)
+		fieldType := value.Type().Field(index)
+		if field.Kind() == reflect.Struct {
+			if err := readData(field, data); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := strings.Split(fieldType.Tag.Get("env"), ",")[0]
+		dataValue, exist := data[key]
+		if key == "" || !exist {
+			continue
+		}
+
+		if err := setField(field, dataValue); err != nil {
+			return fmt.Errorf("invalid %s value %q: %v", key, dataValue, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(intValue))
+	case reflect.Bool:
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolValue)
+	case reflect.Float64:
+		floatValue, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(floatValue)
+	case reflect.Map:
+		mapValue, err := parseIntMap(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(mapValue))
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String {
+			field.Set(reflect.ValueOf(strings.Split(value, ",")))
+			return nil
+		}
+
+		ranges := []GUIDPoolRangeConfig{}
+		for _, rangeValue := range strings.Split(value, ",") {
+			guidRange, err := parseGUIDPoolRange(rangeValue)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, guidRange.(GUIDPoolRangeConfig))
+		}
+		field.Set(reflect.ValueOf(ranges))
+	default:
+		return fmt.Errorf("unsupported option type %s", field.Type())
+	}
+
+	return nil
+}
+
+// ChangedFields returns the options which differ between the configurations as "<option>: <old> -> <new>"
+func ChangedFields(oldConfig, newConfig *DaemonConfig) []string {
+	var changes []string
+	oldValue := reflect.ValueOf(oldConfig).Elem()
+	newValue := reflect.ValueOf(newConfig).Elem()
+	for index := 0; index < oldValue.NumField(); index++ {
+		oldField := oldValue.Field(index).Interface()
+		newField := newValue.Field(index).Interface()
+		if !reflect.DeepEqual(oldField, newField) {
+			changes = append(changes, fmt.Sprintf("%s: %+v -> %+v", oldValue.Type().Field(index).Name, oldField,
+				newField))
+		}
+	}
+
+	return changes
+}
+
+// parseGUIDPoolRange parses guid range "<start>-<end>", e.g "02:00:00:00:00:00:00:00-02:00:00:00:00:00:00:FF",
+// the guids are validated by the guid pool
+func parseGUIDPoolRange(value string) (interface{}, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid guid range %q, should be <start>-<end>", value)
+	}
+
+	return GUIDPoolRangeConfig{RangeStart: parts[0], RangeEnd: parts[1]}, nil
+}
+
+// parseIntMap parses comma separated key=value pairs with integer values, e.g "storage=10,compute=5"
+func parseIntMap(value string) (interface{}, error) {
+	result := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid pair %q, should be <key>=<value>", pair)
+		}
+
+		intValue, err := strconv.Atoi(strings.TrimSpace(keyValue[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of pair %q: %v", pair, err)
+		}
+		result[strings.TrimSpace(keyValue[0])] = intValue
+	}
+
+	return result, nil
+}
+
 func (dc *DaemonConfig) ValidateConfig() error {
 	log.Debug().Msgf("Validating configurations %+v", dc)
 	if dc.PeriodicUpdate <= 0 {
 		return fmt.Errorf("invalid \"PeriodicUpdate\" value %d", dc.PeriodicUpdate)
 	}
 
-	if dc.Plugin == "" {
+	if dc.GetAddPeriodicUpdateInterval() <= 0 {
+		return fmt.Errorf("invalid \"AddPeriodicUpdateInterval\" value %d", dc.AddPeriodicUpdateInterval)
+	}
+
+	if dc.GetDeletePeriodicUpdateInterval() <= 0 {
+		return fmt.Errorf("invalid \"DeletePeriodicUpdateInterval\" value %d", dc.DeletePeriodicUpdateInterval)
+	}
+
+	if dc.MaxGUIDsPerPKey <= 0 {
+		return fmt.Errorf("invalid \"MaxGUIDsPerPKey\" value %d", dc.MaxGUIDsPerPKey)
+	}
+
+	if dc.AuditSocket != "" && dc.AuditBufferSize <= 0 {
+		return fmt.Errorf("invalid \"AuditBufferSize\" value %d", dc.AuditBufferSize)
+	}
+
+	if dc.ManageNADGUIDs && dc.NADGUIDRangeSize <= 0 {
+		return fmt.Errorf("invalid \"NADGUIDRangeSize\" value %d", dc.NADGUIDRangeSize)
+	}
+
+	if dc.CPUProfileDuration <= 0 {
+		return fmt.Errorf("invalid \"CPUProfileDuration\" value %d", dc.CPUProfileDuration)
+	}
+
+	if dc.DesyncCheckInterval < 0 {
+		return fmt.Errorf("invalid \"DesyncCheckInterval\" value %d", dc.DesyncCheckInterval)
+	}
+
+	if dc.DesyncThreshold < 0 {
+		return fmt.Errorf("invalid \"DesyncThreshold\" value %d", dc.DesyncThreshold)
+	}
+
+	if dc.PKeyUsageWarningPercent <= 0 || dc.PKeyUsageWarningPercent > maxPercent {
+		return fmt.Errorf("invalid \"PKeyUsageWarningPercent\" value %d", dc.PKeyUsageWarningPercent)
+	}
+
+	if dc.PKeyUsageBlockPercent <= 0 || dc.PKeyUsageBlockPercent > maxPercent {
+		return fmt.Errorf("invalid \"PKeyUsageBlockPercent\" value %d", dc.PKeyUsageBlockPercent)
+	}
+
+	if dc.PKeyUsageWarningPercent > dc.PKeyUsageBlockPercent {
+		return fmt.Errorf("\"PKeyUsageWarningPercent\" value %d is above \"PKeyUsageBlockPercent\" value %d",
+			dc.PKeyUsageWarningPercent, dc.PKeyUsageBlockPercent)
+	}
+
+	if dc.NamespaceCacheTTL < 0 {
+		return fmt.Errorf("invalid \"NamespaceCacheTTL\" value %d", dc.NamespaceCacheTTL)
+	}
+
+	if dc.MaxSMCallsPerNetworkPerSecond < 0 {
+		return fmt.Errorf("invalid \"MaxSMCallsPerNetworkPerSecond\" value %v", dc.MaxSMCallsPerNetworkPerSecond)
+	}
+
+	if dc.SMCallWaitTimeout < 0 {
+		return fmt.Errorf("invalid \"SMCallWaitTimeout\" value %d", dc.SMCallWaitTimeout)
+	}
+
+	if dc.SubnetManagerTimeout < 0 {
+		return fmt.Errorf("invalid \"SubnetManagerTimeout\" value %d", dc.SubnetManagerTimeout)
+	}
+
+	if dc.StartupTimeout < 0 {
+		return fmt.Errorf("invalid \"StartupTimeout\" value %d", dc.StartupTimeout)
+	}
+
+	if dc.SMMaxBatchSize < 0 {
+		return fmt.Errorf("invalid \"SMMaxBatchSize\" value %d", dc.SMMaxBatchSize)
+	}
+
+	if dc.SMBatchInterval < 0 {
+		return fmt.Errorf("invalid \"SMBatchInterval\" value %d", dc.SMBatchInterval)
+	}
+
+	if dc.PodAnnotationRetries < 0 {
+		return fmt.Errorf("invalid \"PodAnnotationRetries\" value %d", dc.PodAnnotationRetries)
+	}
+
+	if dc.MaxPodRetries < 0 {
+		return fmt.Errorf("invalid \"MaxPodRetries\" value %d", dc.MaxPodRetries)
+	}
+
+	if dc.MaxConcurrentAnnotationWrites < 0 {
+		return fmt.Errorf("invalid \"MaxConcurrentAnnotationWrites\" value %d", dc.MaxConcurrentAnnotationWrites)
+	}
+
+	if dc.MaxConcurrentNetworks < 0 {
+		return fmt.Errorf("invalid \"MaxConcurrentNetworks\" value %d", dc.MaxConcurrentNetworks)
+	}
+
+	if dc.DrainTimeout < 0 {
+		return fmt.Errorf("invalid \"DrainTimeout\" value %d", dc.DrainTimeout)
+	}
+
+	if dc.DualWriteSM && !dc.ManageSubnetManager {
+		return fmt.Errorf("dual write mode requires managing the subnet manager")
+	}
+
+	if dc.DualWriteSM && (dc.SecondaryPlugin == "" || dc.SecondaryPlugin == dc.Plugin) {
+		return fmt.Errorf("invalid \"SecondaryPlugin\" value %q, a different plugin than %q is required "+
+			"in dual write mode", dc.SecondaryPlugin, dc.Plugin)
+	}
+
+	if dc.PoolSerializationFormat != "json" && dc.PoolSerializationFormat != "binary" {
+		return fmt.Errorf("invalid \"PoolSerializationFormat\" value %q", dc.PoolSerializationFormat)
+	}
+
+	if dc.LogFormat != "" && dc.LogFormat != "text" && dc.LogFormat != "json" {
+		return fmt.Errorf("invalid \"LogFormat\" value %q", dc.LogFormat)
+	}
+
+	if dc.GUIDFormat != "" && dc.GUIDFormat != "colon" && dc.GUIDFormat != "dash" && dc.GUIDFormat != "hex" {
+		return fmt.Errorf("invalid \"GUIDFormat\" value %q", dc.GUIDFormat)
+	}
+
+	if dc.IdleGUIDEvictionTimeout < 0 {
+		return fmt.Errorf("invalid \"IdleGUIDEvictionTimeout\" value %d", dc.IdleGUIDEvictionTimeout)
+	}
+
+	if dc.ReconcilePeriod < 0 {
+		return fmt.Errorf("invalid \"ReconcilePeriod\" value %d", dc.ReconcilePeriod)
+	}
+
+	if dc.SMReconcileInterval < 0 {
+		return fmt.Errorf("invalid \"SMReconcileInterval\" value %d", dc.SMReconcileInterval)
+	}
+
+	if dc.TopologyCacheTTL < 0 {
+		return fmt.Errorf("invalid \"TopologyCacheTTL\" value %d", dc.TopologyCacheTTL)
+	}
+
+	if dc.MultusGRPCMode && dc.MultusGRPCSocket == "" {
+		return fmt.Errorf("no grpc socket set in multus grpc mode")
+	}
+
+	if dc.WebhookAddress != "" && (dc.WebhookCertFile == "" || dc.WebhookKeyFile == "") {
+		return fmt.Errorf("no webhook certificate and key files set for webhook address %s", dc.WebhookAddress)
+	}
+
+	if (dc.GUIDDNSZone == "") != (dc.GUIDDNSConfigMap == "") {
+		return fmt.Errorf("guid dns zone and config map must be set together")
+	}
+
+	if dc.GUIDDNSConfigMap != "" {
+		if _, _, err := dc.GetGUIDDNSConfigMap(); err != nil {
+			return err
+		}
+	}
+
+	if dc.ConfigMap != "" {
+		if _, _, err := dc.GetConfigMap(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dc.GetPodFieldSelector(); err != nil {
+		return err
+	}
+
+	if _, err := dc.GetPodLabelSelector(); err != nil {
+		return err
+	}
+
+	if err := validateNamespaces("WatchNamespaces", dc.WatchNamespaces); err != nil {
+		return err
+	}
+
+	if err := validateNamespaces("ExcludeNamespaces", dc.ExcludeNamespaces); err != nil {
+		return err
+	}
+
+	if dc.GUIDSigningKeySecret != "" {
+		if _, _, err := dc.GetGUIDSigningKeySecret(); err != nil {
+			return err
+		}
+	}
+
+	if dc.InfiniBandAnnotationKey != "" {
+		if errs := validation.IsQualifiedName(dc.InfiniBandAnnotationKey); len(errs) != 0 {
+			return fmt.Errorf("invalid \"InfiniBandAnnotationKey\" value %q: %s", dc.InfiniBandAnnotationKey,
+				strings.Join(errs, ", "))
+		}
+	}
+
+	// the guid is set in the "guid" key of the same cni-args
+	if dc.InfiniBandAnnotationKey == "guid" {
+		return fmt.Errorf("invalid \"InfiniBandAnnotationKey\" value %q: the key is reserved for the guid",
+			dc.InfiniBandAnnotationKey)
+	}
+
+	if dc.EnableDebugEndpoints && dc.HealthAddress == "" {
+		return fmt.Errorf("debug endpoints require a health address, they are served on the health probes server")
+	}
+
+	if dc.SidecarMode && dc.NodeName == "" {
+		return fmt.Errorf("no node name set in sidecar mode")
+	}
+
+	if dc.SidecarMode && dc.SidecarSocket == "" {
+		return fmt.Errorf("no sidecar socket set in sidecar mode")
+	}
+
+	if dc.PerNodePool {
+		if !dc.SidecarMode {
+			return fmt.Errorf("per node pool requires sidecar mode")
+		}
+		if dc.PerNodePoolSize <= 0 {
+			return fmt.Errorf("invalid \"PerNodePoolSize\" value %d", dc.PerNodePoolSize)
+		}
+		if _, _, err := dc.GetPerNodePoolConfigMap(); err != nil {
+			return err
+		}
+	}
+
+	if dc.EnableLeaderElection {
+		if dc.SidecarMode {
+			return fmt.Errorf("leader election isn't supported in sidecar mode")
+		}
+		if _, _, err := dc.GetLeaderElectionLease(); err != nil {
+			return err
+		}
+	}
+
+	if dc.WatchNodeVFs && dc.NodeName == "" {
+		return fmt.Errorf("no node name set for watching the node VFs")
+	}
+
+	if err := dc.GUIDPool.validateRanges(); err != nil {
+		return err
+	}
+
+	if dc.GUIDPool.AllocationStrategy != "" && dc.GUIDPool.AllocationStrategy != AllocationStrategySequential &&
+		dc.GUIDPool.AllocationStrategy != AllocationStrategyRandom {
+		return fmt.Errorf("invalid \"GUIDPool.AllocationStrategy\" value %q", dc.GUIDPool.AllocationStrategy)
+	}
+
+	if dc.GUIDPool.PersistenceBackend != "" {
+		if dc.GUIDPool.PersistenceBackend != PersistenceBackendConfigMap {
+			return fmt.Errorf("invalid \"GUIDPool.PersistenceBackend\" value %q", dc.GUIDPool.PersistenceBackend)
+		}
+		if _, _, err := dc.GUIDPool.GetPersistenceConfigMap(); err != nil {
+			return err
+		}
+	}
+
+	if dc.PKeyPool.Enabled() {
+		if dc.PKeyPool.RangeEnd == "" {
+			return fmt.Errorf("no pKey pool range end set for pKey pool range start %s", dc.PKeyPool.RangeStart)
+		}
+		if _, _, err := dc.PKeyPool.GetConfigMap(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dc.GetSubnetPrefix(); err != nil {
+		return err
+	}
+
+	if dc.ManageSubnetManager && dc.Plugin == "" {
 		return fmt.Errorf("no plugin selected")
 	}
 	return nil
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net"
 	"os"
 
 	. "github.com/onsi/ginkgo"
@@ -19,6 +20,12 @@ var _ = Describe("Configuration", func() {
 			Expect(os.Setenv("GUID_POOL_RANGE_START", "02:00:00:00:00:00:00:00")).ToNot(HaveOccurred())
 			Expect(os.Setenv("GUID_POOL_RANGE_END", "02:00:00:00:00:00:00:FF")).ToNot(HaveOccurred())
 			Expect(os.Setenv("DAEMON_SM_PLUGIN", "ufm")).ToNot(HaveOccurred())
+			Expect(os.Setenv("DAEMON_VERIFY_SM_ADDITIONS", "true")).ToNot(HaveOccurred())
+			Expect(os.Setenv("DAEMON_NETWORK_PRIORITIES", "storage=10, compute=5")).ToNot(HaveOccurred())
+			Expect(os.Setenv("DAEMON_NAMESPACE_GUID_QUOTAS", "team-a=100")).ToNot(HaveOccurred())
+			Expect(os.Setenv("GUID_POOL_EXCLUDE_RANGES",
+				"02:00:00:00:00:00:00:00-02:00:00:00:00:00:00:0F,02:00:00:00:00:00:00:F0-02:00:00:00:00:00:00:FF")).
+				ToNot(HaveOccurred())
 
 			err := dc.ReadConfig()
 			Expect(err).ToNot(HaveOccurred())
@@ -26,6 +33,12 @@ var _ = Describe("Configuration", func() {
 			Expect(dc.GUIDPool.RangeStart).To(Equal("02:00:00:00:00:00:00:00"))
 			Expect(dc.GUIDPool.RangeEnd).To(Equal("02:00:00:00:00:00:00:FF"))
 			Expect(dc.Plugin).To(Equal("ufm"))
+			Expect(dc.VerifySMAdditions).To(BeTrue())
+			Expect(dc.NetworkPriorities).To(Equal(map[string]int{"storage": 10, "compute": 5}))
+			Expect(dc.NamespaceGUIDQuotas).To(Equal(map[string]int{"team-a": 100}))
+			Expect(dc.GUIDPool.ExcludeRanges).To(Equal([]GUIDPoolRangeConfig{
+				{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:0F"},
+				{RangeStart: "02:00:00:00:00:00:00:F0", RangeEnd: "02:00:00:00:00:00:00:FF"}}))
 		})
 		It("Read configuration with default values", func() {
 			dc := &DaemonConfig{}
@@ -34,9 +47,138 @@ var _ = Describe("Configuration", func() {
 			err := dc.ReadConfig()
 			Expect(err).ToNot(HaveOccurred())
 			Expect(dc.PeriodicUpdate).To(Equal(5))
+			Expect(dc.GetAddPeriodicUpdateInterval()).To(Equal(5))
+			Expect(dc.GetDeletePeriodicUpdateInterval()).To(Equal(5))
 			Expect(dc.GUIDPool.RangeStart).To(Equal("02:00:00:00:00:00:00:00"))
 			Expect(dc.GUIDPool.RangeEnd).To(Equal("02:FF:FF:FF:FF:FF:FF:FF"))
+			Expect(dc.GUIDPool.NamespacePrefix).To(Equal(""))
+			Expect(dc.GUIDPool.ReservedGUIDs).To(BeEmpty())
 			Expect(dc.Plugin).To(Equal("ufm"))
+			Expect(dc.VerifySMAdditions).To(BeFalse())
+			Expect(dc.CheckUserGUIDsInSM).To(BeFalse())
+			Expect(dc.MaxGUIDsPerPKey).To(Equal(8192))
+			Expect(dc.NetworkPriorities).To(BeNil())
+			Expect(dc.AuditSocket).To(Equal(""))
+			Expect(dc.AuditBufferSize).To(Equal(1000))
+			Expect(dc.ManageNADGUIDs).To(BeFalse())
+			Expect(dc.NADGUIDRangeSize).To(Equal(256))
+			Expect(dc.EnableQuotaCheck).To(BeFalse())
+			Expect(dc.SidecarMode).To(BeFalse())
+			Expect(dc.SidecarSocket).To(Equal("/var/run/ib-kubernetes/daemon.sock"))
+			Expect(dc.StatusSocket).To(Equal("/var/run/ib-kubernetes/status.sock"))
+			Expect(dc.NamespaceGUIDQuotas).To(BeNil())
+			Expect(dc.CPUProfileDuration).To(Equal(30))
+			Expect(dc.DesyncCheckInterval).To(Equal(300))
+			Expect(dc.DesyncThreshold).To(Equal(5))
+			Expect(dc.PKeyUsageWarningPercent).To(Equal(80))
+			Expect(dc.PKeyUsageBlockPercent).To(Equal(95))
+			Expect(dc.GUIDDNSZone).To(Equal(""))
+			Expect(dc.GUIDDNSConfigMap).To(Equal(""))
+			Expect(dc.CleanSMOnStartup).To(BeFalse())
+			Expect(dc.ConfigMap).To(Equal(""))
+			Expect(dc.EnforceNamespaceIsolation).To(BeFalse())
+			Expect(dc.NamespaceCacheTTL).To(Equal(300))
+			Expect(dc.MultusGRPCMode).To(BeFalse())
+			Expect(dc.MultusGRPCSocket).To(Equal("/var/run/ib-kubernetes/grpc.sock"))
+			Expect(dc.WebhookAddress).To(Equal(""))
+			Expect(dc.MetricsAddress).To(Equal(""))
+			Expect(dc.HealthAddress).To(Equal(""))
+			Expect(dc.WebhookCertFile).To(Equal("/etc/ib-kubernetes/webhook/tls.crt"))
+			Expect(dc.WebhookKeyFile).To(Equal("/etc/ib-kubernetes/webhook/tls.key"))
+			Expect(dc.MaxSMCallsPerNetworkPerSecond).To(Equal(10.0))
+			Expect(dc.SMCallWaitTimeout).To(Equal(100))
+			Expect(dc.SubnetManagerTimeout).To(Equal(120))
+			Expect(dc.SMMaxBatchSize).To(Equal(0))
+			Expect(dc.SMBatchInterval).To(Equal(0))
+			Expect(dc.PodAnnotationRetries).To(Equal(3))
+			Expect(dc.MaxPodRetries).To(Equal(0))
+			Expect(dc.MaxConcurrentAnnotationWrites).To(Equal(0))
+			Expect(dc.DryRun).To(BeFalse())
+			Expect(dc.MaxConcurrentNetworks).To(Equal(1))
+			Expect(dc.DrainTimeout).To(Equal(10))
+			Expect(dc.DualWriteSM).To(BeFalse())
+			Expect(dc.ManageSubnetManager).To(BeTrue())
+			Expect(dc.EnableLeaderElection).To(BeFalse())
+			Expect(dc.LeaderElectionLease).To(Equal("kube-system/ib-kubernetes-leader"))
+			Expect(dc.SecondaryPlugin).To(Equal(""))
+			Expect(dc.PluginConfPath).To(Equal(""))
+			Expect(dc.SecondaryPluginConfPath).To(Equal(""))
+			Expect(dc.SubnetPrefix).To(Equal(""))
+			Expect(dc.StartupTimeout).To(Equal(60))
+			Expect(dc.PodFinalizer).To(BeFalse())
+			Expect(dc.PoolSerializationFormat).To(Equal("json"))
+			Expect(dc.LogFormat).To(Equal("text"))
+			Expect(dc.GUIDFormat).To(Equal("colon"))
+			Expect(dc.IdleGUIDEvictionTimeout).To(Equal(0))
+			Expect(dc.ReconcilePeriod).To(Equal(0))
+			Expect(dc.SMReconcileInterval).To(Equal(0))
+			Expect(dc.AnnotatePortCapabilities).To(BeFalse())
+			Expect(dc.PerNodePool).To(BeFalse())
+			Expect(dc.PerNodePoolSize).To(Equal(1000))
+			Expect(dc.PerNodePoolConfigMap).To(Equal("kube-system/ib-kubernetes-node-ranges"))
+			Expect(dc.PKeyPool.Enabled()).To(BeFalse())
+			Expect(dc.GUIDPool.AllocationStrategy).To(Equal("sequential"))
+			Expect(dc.GUIDPool.PersistenceBackend).To(BeEmpty())
+			Expect(dc.GUIDPool.PersistenceConfigMap).To(Equal("kube-system/ib-kubernetes-guid-pool"))
+			Expect(dc.PKeyPool.ConfigMap).To(Equal("kube-system/ib-kubernetes-pkey-pool"))
+			Expect(dc.WatchNodeVFs).To(BeFalse())
+			Expect(dc.WatchNamespaceDeletion).To(BeFalse())
+			Expect(dc.TopologyAwareAllocation).To(BeFalse())
+			Expect(dc.TopologyCacheTTL).To(Equal(300))
+			Expect(dc.TrackPodIPChanges).To(BeFalse())
+			Expect(dc.EnableGUIDMigration).To(BeFalse())
+			Expect(dc.PodFieldSelector).To(BeEmpty())
+			Expect(dc.PodLabelSelector).To(BeEmpty())
+			Expect(dc.WatchNamespaces).To(BeEmpty())
+			Expect(dc.ExcludeNamespaces).To(BeEmpty())
+			Expect(dc.EnablePKeyReservations).To(BeFalse())
+			Expect(dc.GUIDSigningKeySecret).To(BeEmpty())
+			Expect(dc.InfiniBandAnnotationKey).To(Equal("mellanox.infiniband.app"))
+			Expect(dc.EnableDebugEndpoints).To(BeFalse())
+			Expect(dc.PodName).To(BeEmpty())
+		})
+		It("Read configuration with invalid guid pool exclude ranges", func() {
+			dc := &DaemonConfig{}
+			Expect(os.Setenv("GUID_POOL_EXCLUDE_RANGES", "02:00:00:00:00:00:00:00")).ToNot(HaveOccurred())
+
+			err := dc.ReadConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Read configuration with invalid network priorities", func() {
+			dc := &DaemonConfig{}
+			Expect(os.Setenv("DAEMON_NETWORK_PRIORITIES", "storage:10")).ToNot(HaveOccurred())
+
+			err := dc.ReadConfig()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("ReadConfigMapData", func() {
+		It("Override options from config map data", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 5, Plugin: "ufm", MaxGUIDsPerPKey: 8192}
+			err := dc.ReadConfigMapData(map[string]string{
+				"DAEMON_PERIODIC_UPDATE":     "10",
+				"DAEMON_VERIFY_SM_ADDITIONS": "true",
+				"DAEMON_NETWORK_PRIORITIES":  "storage=10",
+				"GUID_POOL_RANGE_START":      "02:00:00:00:00:00:00:10",
+				"DAEMON_WATCH_NAMESPACES":    "team-a,team-b",
+				"UNKNOWN_KEY":                "value"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dc.PeriodicUpdate).To(Equal(10))
+			Expect(dc.VerifySMAdditions).To(BeTrue())
+			Expect(dc.NetworkPriorities).To(Equal(map[string]int{"storage": 10}))
+			Expect(dc.GUIDPool.RangeStart).To(Equal("02:00:00:00:00:00:00:10"))
+			Expect(dc.WatchNamespaces).To(Equal([]string{"team-a", "team-b"}))
+			Expect(dc.Plugin).To(Equal("ufm"))
+			Expect(dc.MaxGUIDsPerPKey).To(Equal(8192))
+		})
+		It("Read invalid config map data", func() {
+			dc := &DaemonConfig{}
+			Expect(dc.ReadConfigMapData(map[string]string{"DAEMON_PERIODIC_UPDATE": "often"})).ToNot(Succeed())
+		})
+		It("List changed fields", func() {
+			oldConfig := &DaemonConfig{PeriodicUpdate: 5, Plugin: "ufm"}
+			newConfig := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm"}
+			Expect(ChangedFields(oldConfig, newConfig)).To(Equal([]string{"PeriodicUpdate: 5 -> 10"}))
 		})
 	})
 	Context("ValidateConfig", func() {
@@ -46,7 +188,12 @@ var _ = Describe("Configuration", func() {
 				GUIDPool: GUIDPoolConfig{
 					RangeStart: "02:00:00:00:00:00:00:10",
 					RangeEnd:   "02:00:00:00:00:00:00:FF"},
-				Plugin: "noop"}
+				Plugin:                  "noop",
+				MaxGUIDsPerPKey:         8192,
+				CPUProfileDuration:      30,
+				PKeyUsageWarningPercent: 80,
+				PKeyUsageBlockPercent:   95,
+				PoolSerializationFormat: "json"}
 
 			err := dc.ValidateConfig()
 			Expect(err).ToNot(HaveOccurred())
@@ -56,21 +203,390 @@ var _ = Describe("Configuration", func() {
 			err := dc.ValidateConfig()
 			Expect(err).To(HaveOccurred())
 		})
+		It("Validate configuration with invalid max guids per pkey", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 0}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with audit socket and invalid audit buffer size", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192,
+				AuditSocket: "/var/run/audit.sock", AuditBufferSize: 0}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with managed nad guids and invalid nad guid range size", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192,
+				ManageNADGUIDs: true, NADGUIDRangeSize: 0}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid cpu profile duration", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 0}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid desync threshold", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				DesyncThreshold: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid pkey usage block percent", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 101}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with pkey usage warning percent above block percent", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 96, PKeyUsageBlockPercent: 95}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid namespace cache ttl", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, NamespaceCacheTTL: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid max sm calls per network per second", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				MaxSMCallsPerNetworkPerSecond: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with dual write mode and no secondary plugin", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				ManageSubnetManager: true, DualWriteSM: true, SecondaryPlugin: "ufm"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with per node pool and no sidecar mode", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				PerNodePool: true, PerNodePoolSize: 1000, PerNodePoolConfigMap: "kube-system/node-ranges"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with leader election and invalid lease", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				EnableLeaderElection: true, LeaderElectionLease: "ib-kubernetes-leader"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid guid allocation strategy", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				GUIDPool: GUIDPoolConfig{AllocationStrategy: "round-robin"}}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid guid pool persistence backend", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				GUIDPool: GUIDPoolConfig{PersistenceBackend: "etcd"}}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with pKey pool and no range end", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				PKeyPool: PKeyPoolConfig{RangeStart: "0x1000", ConfigMap: "kube-system/pkey-pool"}}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with node VFs watch and no node name", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				WatchNodeVFs: true}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid pool serialization format", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "xml"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid log format", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				LogFormat: "xml"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid guid format", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				GUIDFormat: "dot"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("GUIDFormat"))
+
+			dc.GUIDFormat = "hex"
+			Expect(dc.ValidateConfig()).To(Succeed())
+		})
+		It("Validate configuration with invalid idle guid eviction timeout", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, IdleGUIDEvictionTimeout: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid subnet manager timeout", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, SubnetManagerTimeout: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid startup timeout", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				StartupTimeout: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("StartupTimeout"))
+		})
+		It("Validate configuration with invalid subnet manager batches", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				SMMaxBatchSize: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("SMMaxBatchSize"))
+
+			dc.SMMaxBatchSize = 100
+			dc.SMBatchInterval = -1
+			err = dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("SMBatchInterval"))
+		})
+		It("Validate configuration with invalid max pod retries", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				MaxPodRetries: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("MaxPodRetries"))
+		})
+		It("Validate configuration with invalid max concurrent annotation writes", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				MaxConcurrentAnnotationWrites: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("MaxConcurrentAnnotationWrites"))
+		})
+		It("Validate configuration with invalid subnet manager reconcile interval", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				SMReconcileInterval: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("SMReconcileInterval"))
+		})
+		It("Validate configuration with invalid topology cache ttl", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				TopologyCacheTTL: -1}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with multus grpc mode and no socket", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, MultusGRPCMode: true}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with webhook address and no certificate", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, WebhookAddress: ":8443"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with guid dns zone and no config map", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, GUIDDNSZone: "ib.cluster.local"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid guid dns config map", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, GUIDDNSZone: "ib.cluster.local",
+				GUIDDNSConfigMap: "guid-dns"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid config map", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, ConfigMap: "kube-system/"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Get guid dns config map namespace and name", func() {
+			dc := &DaemonConfig{GUIDDNSConfigMap: "kube-system/guid-dns"}
+			namespace, name, err := dc.GetGUIDDNSConfigMap()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(namespace).To(Equal("kube-system"))
+			Expect(name).To(Equal("guid-dns"))
+		})
+		It("Validate configuration with sidecar mode and no node name", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95,
+				SidecarMode: true, SidecarSocket: "/var/run/ib-kubernetes/daemon.sock"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+		})
+		It("Validate configuration with invalid pod field selector", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				PodFieldSelector: "workload-class in mpi"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("PodFieldSelector"))
+		})
+		It("Validate configuration with invalid pod label selector", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				PodLabelSelector: "network in infiniband"}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("PodLabelSelector"))
+		})
+		It("Validate configuration with add and delete periodic update intervals", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				DeletePeriodicUpdateInterval: 2}
+			Expect(dc.ValidateConfig()).To(Succeed())
+			Expect(dc.GetAddPeriodicUpdateInterval()).To(Equal(10))
+			Expect(dc.GetDeletePeriodicUpdateInterval()).To(Equal(2))
+
+			dc.AddPeriodicUpdateInterval = -1
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("AddPeriodicUpdateInterval"))
+
+			dc.AddPeriodicUpdateInterval = 0
+			dc.DeletePeriodicUpdateInterval = -1
+			err = dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("DeletePeriodicUpdateInterval"))
+		})
+		It("Validate configuration with invalid InfiniBand annotation key", func() {
+			for _, key := range []string{"fabric b", "guid"} {
+				dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+					PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+					InfiniBandAnnotationKey: key}
+				err := dc.ValidateConfig()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("InfiniBandAnnotationKey"))
+			}
+		})
+		It("Validate configuration with debug endpoints without health address", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				EnableDebugEndpoints: true}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("health address"))
+
+			dc.HealthAddress = ":8080"
+			Expect(dc.ValidateConfig()).To(Succeed())
+		})
+		It("Validate configuration with invalid watched namespace", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				WatchNamespaces: []string{"team-a", "Team_B"}}
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("WatchNamespaces"))
+		})
+		It("Validate configuration with subnet prefix", func() {
+			for subnetPrefix, valid := range map[string]bool{"fe80::": true, "0xfe80000000000000": true,
+				"FE80:0000:0000:0000::": true, "fe80::1": false, "0xfe8000000000000000": false, "10.0.0.0": false,
+				"fe80": false} {
+				dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+					PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+					SubnetPrefix: subnetPrefix}
+				if valid {
+					Expect(dc.ValidateConfig()).To(Succeed(), subnetPrefix)
+					prefix, err := dc.GetSubnetPrefix()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(prefix.Equal(net.ParseIP("fe80::"))).To(BeTrue(), subnetPrefix)
+				} else {
+					err := dc.ValidateConfig()
+					Expect(err).To(HaveOccurred(), subnetPrefix)
+					Expect(err.Error()).To(ContainSubstring("SubnetPrefix"))
+				}
+			}
+		})
 		It("Validate configuration with not selected plugin", func() {
-			dc := &DaemonConfig{PeriodicUpdate: 10}
+			dc := &DaemonConfig{PeriodicUpdate: 10, MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, ManageSubnetManager: true}
 			err := dc.ValidateConfig()
 			Expect(err).To(HaveOccurred())
 		})
+		It("Validate configuration without plugin when not managing the subnet manager", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json"}
+			Expect(dc.ValidateConfig()).To(Succeed())
+
+			dc.DualWriteSM = true
+			dc.SecondaryPlugin = "opensm"
+			err := dc.ValidateConfig()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("dual write mode"))
+		})
 		It("Validate configuration with guid pool start not set", func() {
-			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm"}
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json"}
+			err := dc.ValidateConfig()
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("Validate configuration with invalid guid pool ranges", func() {
+			for _, guidPool := range []GUIDPoolConfig{
+				{RangeStart: "02:00:00:00:00:00", RangeEnd: "02:FF:FF:FF:FF:FF:FF:FF"},
+				{RangeStart: "02:00:00:00:00:00:00:FF", RangeEnd: "02:00:00:00:00:00:00:00"},
+				{RangeStart: "00:00:00:00:00:00:00:00", RangeEnd: "02:FF:FF:FF:FF:FF:FF:FF"},
+				{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "FF:FF:FF:FF:FF:FF:FF:FF"},
+				{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:FF:FF:FF:FF:FF:FF:FF",
+					ExcludeRanges: []GUIDPoolRangeConfig{
+						{RangeStart: "02:00:00:00:00:00:00:FF", RangeEnd: "02:00:00:00:00:00:00:00"}}},
+				{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:FF:FF:FF:FF:FF:FF:FF",
+					ReservedGUIDs: []string{"02:00:00:00:00:00:00"}},
+				{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:FF",
+					ReservedGUIDs: []string{"02:00:00:00:00:00:01:00"}},
+				{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:FF:FF:FF:FF:FF:FF:FF",
+					ReservedGUIDs: []string{""}},
+			} {
+				dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+					PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+					GUIDPool: guidPool}
+				err := dc.ValidateConfig()
+				Expect(err).To(HaveOccurred(), "guid pool %+v", guidPool)
+				Expect(err.Error()).To(ContainSubstring("guid"))
+			}
+		})
+		It("Validate configuration with reserved guids", func() {
+			dc := &DaemonConfig{PeriodicUpdate: 10, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+				PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json",
+				GUIDPool: GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:FF",
+					ReservedGUIDs: []string{"02:00:00:00:00:00:00:10", "02:00:00:00:00:00:00:FF"}}}
 			err := dc.ValidateConfig()
 			Expect(err).ToNot(HaveOccurred())
 		})
 		It("Validate configuration with guid pool end not set", func() {
 			dc := &DaemonConfig{
-				PeriodicUpdate: 10,
-				GUIDPool:       GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00"},
-				Plugin:         "ufm"}
+				PeriodicUpdate:          10,
+				GUIDPool:                GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00"},
+				Plugin:                  "ufm",
+				MaxGUIDsPerPKey:         8192,
+				CPUProfileDuration:      30,
+				PKeyUsageWarningPercent: 80,
+				PKeyUsageBlockPercent:   95,
+				PoolSerializationFormat: "json"}
 			err := dc.ValidateConfig()
 			Expect(err).ToNot(HaveOccurred())
 		})
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/tools/cache"
+
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+)
+
+// ConfigErrorAnnotation is the watched config map annotation of the rejected configuration validation error
+const ConfigErrorAnnotation = "ib.mellanox.com/config-error"
+
+// ConfigMapWatcher watches a config map and applies its data on top of the base configuration.
+// Valid configurations are passed to the change callback, invalid configurations are rejected and their error is
+// set in the ConfigErrorAnnotation of the config map.
+type ConfigMapWatcher struct {
+	client     k8sClient.Client
+	namespace  string
+	name       string
+	baseConfig DaemonConfig
+	current    DaemonConfig
+	onChange   func(DaemonConfig)
+}
+
+// NewConfigMapWatcher returns watcher of the config map with the given namespace and name, the base configuration
+// is the current configuration read from the environment variables
+func NewConfigMapWatcher(client k8sClient.Client, namespace, name string, baseConfig DaemonConfig,
+	onChange func(DaemonConfig)) *ConfigMapWatcher {
+	return &ConfigMapWatcher{
+		client:     client,
+		namespace:  namespace,
+		name:       name,
+		baseConfig: baseConfig,
+		current:    baseConfig,
+		onChange:   onChange,
+	}
+}
+
+// Run watches the config map until the stop channel is closed
+func (w *ConfigMapWatcher) Run(stopChan <-chan struct{}) {
+	watchList := cache.NewListWatchFromClient(w.client.GetRestClient(), "configmaps", w.namespace,
+		fields.OneTermEqualSelector("metadata.name", w.name))
+	informer := cache.NewSharedInformer(watchList, &kapi.ConfigMap{}, 0)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.handleConfigMap,
+		UpdateFunc: func(oldObj, newObj interface{}) { w.handleConfigMap(newObj) },
+	})
+	informer.Run(stopChan)
+}
+
+func (w *ConfigMapWatcher) handleConfigMap(obj interface{}) {
+	configMap, ok := obj.(*kapi.ConfigMap)
+	if !ok {
+		log.Warn().Msgf("unexpected config map event object %T", obj)
+		return
+	}
+
+	newConfig := w.baseConfig
+	err := newConfig.ReadConfigMapData(configMap.Data)
+	if err == nil {
+		err = newConfig.ValidateConfig()
+	}
+	if err != nil {
+		log.Error().Msgf("rejected configuration of config map %s/%s with error: %v", w.namespace, w.name, err)
+		w.setConfigError(configMap, err.Error())
+		return
+	}
+	w.setConfigError(configMap, "")
+
+	changes := ChangedFields(&w.current, &newConfig)
+	if len(changes) == 0 {
+		return
+	}
+
+	for _, change := range changes {
+		log.Info().Msgf("configuration changed %s", change)
+	}
+	w.current = newConfig
+	w.onChange(newConfig)
+}
+
+// setConfigError sets the config error annotation of the config map, an empty error removes the annotation
+func (w *ConfigMapWatcher) setConfigError(configMap *kapi.ConfigMap, configError string) {
+	if configMap.Annotations[ConfigErrorAnnotation] == configError {
+		return
+	}
+
+	if err := w.client.SetAnnotationsOnConfigMap(configMap,
+		map[string]string{ConfigErrorAnnotation: configError}); err != nil {
+		log.Warn().Msgf("failed to set %s annotation of config map %s/%s with error: %v", ConfigErrorAnnotation,
+			w.namespace, w.name, err)
+	}
+}
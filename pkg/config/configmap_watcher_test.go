@@ -0,0 +1,60 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
+)
+
+var _ = Describe("ConfigMap Watcher", func() {
+	baseConfig := DaemonConfig{PeriodicUpdate: 5, Plugin: "ufm", MaxGUIDsPerPKey: 8192, CPUProfileDuration: 30,
+		PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95, PoolSerializationFormat: "json"}
+	newConfigMap := func(data, annotations map[string]string) *kapi.ConfigMap {
+		return &kapi.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "ib-kubernetes-config",
+			Annotations: annotations}, Data: data}
+	}
+	Context("handleConfigMap", func() {
+		It("Apply valid configuration", func() {
+			var applied []DaemonConfig
+			w := NewConfigMapWatcher(&k8sClientMock.Client{}, "kube-system", "ib-kubernetes-config", baseConfig,
+				func(dc DaemonConfig) { applied = append(applied, dc) })
+
+			w.handleConfigMap(newConfigMap(map[string]string{"DAEMON_PERIODIC_UPDATE": "10"}, nil))
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].PeriodicUpdate).To(Equal(10))
+			Expect(applied[0].Plugin).To(Equal("ufm"))
+
+			// unchanged configuration isn't applied again
+			w.handleConfigMap(newConfigMap(map[string]string{"DAEMON_PERIODIC_UPDATE": "10"}, nil))
+			Expect(applied).To(HaveLen(1))
+		})
+		It("Reject invalid configuration and annotate the config map", func() {
+			client := &k8sClientMock.Client{}
+			configMap := newConfigMap(map[string]string{"DAEMON_PERIODIC_UPDATE": "0"}, nil)
+			client.On("SetAnnotationsOnConfigMap", configMap, map[string]string{
+				ConfigErrorAnnotation: "invalid \"PeriodicUpdate\" value 0"}).Return(nil)
+			w := NewConfigMapWatcher(client, "kube-system", "ib-kubernetes-config", baseConfig,
+				func(dc DaemonConfig) { Fail("invalid configuration applied") })
+
+			w.handleConfigMap(configMap)
+			client.AssertExpectations(GinkgoT())
+		})
+		It("Remove config error annotation of valid configuration", func() {
+			client := &k8sClientMock.Client{}
+			configMap := newConfigMap(map[string]string{"DAEMON_SM_PLUGIN": "noop"},
+				map[string]string{ConfigErrorAnnotation: "invalid \"PeriodicUpdate\" value 0"})
+			client.On("SetAnnotationsOnConfigMap", configMap, map[string]string{ConfigErrorAnnotation: ""}).Return(nil)
+			var applied []DaemonConfig
+			w := NewConfigMapWatcher(client, "kube-system", "ib-kubernetes-config", baseConfig,
+				func(dc DaemonConfig) { applied = append(applied, dc) })
+
+			w.handleConfigMap(configMap)
+			client.AssertExpectations(GinkgoT())
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].Plugin).To(Equal("noop"))
+		})
+	})
+})
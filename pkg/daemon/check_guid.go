@@ -0,0 +1,233 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	kapi "k8s.io/api/core/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// The checks of a guid lifecycle in the order they are run
+const (
+	PodNetworkCheck         = "pod_network"
+	PodAnnotationCheck      = "pod_annotation"
+	SMMembershipCheck       = "sm_membership"
+	FabricReachabilityCheck = "fabric_reachability"
+)
+
+// GUIDCheck is the result of a single check of a guid lifecycle
+type GUIDCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// GUIDCheckReport is the result of the checks of a guid lifecycle, healthy if all the checks passed
+type GUIDCheckReport struct {
+	GUID      string      `json:"guid"`
+	Namespace string      `json:"namespace,omitempty"`
+	Pod       string      `json:"pod,omitempty"`
+	Network   string      `json:"network,omitempty"`
+	PKey      string      `json:"pkey,omitempty"`
+	Checks    []GUIDCheck `json:"checks"`
+	Healthy   bool        `json:"healthy"`
+}
+
+func (r *GUIDCheckReport) addCheck(name string, passed bool, message string) {
+	r.Checks = append(r.Checks, GUIDCheck{Name: name, Passed: passed, Message: message})
+	r.Healthy = r.Healthy && passed
+}
+
+// CheckGUID checks the lifecycle of the guid: the pod network it's allocated for in the guid pool restored from the
+// running pods, the pod network annotation, its pKey membership in the subnet manager and its reachability on the
+// fabric. It uses the daemon configuration from the environment.
+// It returns error if the daemon components can't be initialized.
+func CheckGUID(guidAddr net.HardwareAddr) (*GUIDCheckReport, error) {
+	daemonConfig := config.DaemonConfig{}
+	if err := daemonConfig.ReadConfig(); err != nil {
+		return nil, err
+	}
+
+	if err := daemonConfig.ValidateConfig(); err != nil {
+		return nil, err
+	}
+
+	client, err := k8sClient.NewK8sClientWithNamespaceCacheTTL(
+		time.Duration(daemonConfig.NamespaceCacheTTL) * time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	guidPool, err := guid.NewPool(&daemonConfig.GUIDPool)
+	if err != nil {
+		return nil, err
+	}
+
+	smClient, err := loadSMClient(daemonConfig.Plugin, daemonConfig.PluginConfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the pods aren't watched by the checks
+	d, err := newDaemon(daemonConfig, client, guidPool, smClient, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if daemonConfig.ManageNADGUIDs {
+		if err = d.initNADGUIDPools(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = d.initPool(); err != nil {
+		return nil, err
+	}
+
+	return d.checkGUID(guidAddr), nil
+}
+
+// checkGUID runs the guid lifecycle checks, the checks which depend on the guid pod network fail if it isn't found
+func (d *daemon) checkGUID(guidAddr net.HardwareAddr) *GUIDCheckReport {
+	report := &GUIDCheckReport{GUID: guidAddr.String(), Healthy: true}
+
+	d.guidAllocationLock.Lock()
+	podNetworkID := d.guidPodNetworkMap[guidAddr.String()]
+	d.guidAllocationLock.Unlock()
+
+	pod, network, err := d.findGUIDPodNetwork(guidAddr, podNetworkID)
+	switch {
+	case err != nil:
+		report.addCheck(PodNetworkCheck, false, err.Error())
+	case pod == nil:
+		report.addCheck(PodNetworkCheck, false, "no pod network found for the guid")
+	default:
+		report.Namespace, report.Pod, report.Network = pod.Namespace, pod.Name, network.Name
+		report.addCheck(PodNetworkCheck, true, "")
+	}
+
+	if pod == nil {
+		// the namespace of guids allocated for pods which aren't found is known only from the guid pool
+		if namespace, found := utils.GetNamespaceFromGUID(guidAddr, d.guidPool); found {
+			report.Namespace = namespace
+		}
+		report.addCheck(PodAnnotationCheck, false, "skipped, no pod network found for the guid")
+		report.addCheck(SMMembershipCheck, false, "skipped, no pod network found for the guid")
+	} else {
+		passed, message := checkPodNetworkAnnotation(network, guidAddr, d.ibAnnotationKey)
+		report.addCheck(PodAnnotationCheck, passed, message)
+		passed, message = d.checkSMMembership(report, pod, network, guidAddr)
+		report.addCheck(SMMembershipCheck, passed, message)
+	}
+
+	if err = d.smClient.PingGUID(context.Background(), guidAddr); err != nil {
+		report.addCheck(FabricReachabilityCheck, false, err.Error())
+	} else {
+		report.addCheck(FabricReachabilityCheck, true, "")
+	}
+
+	return report
+}
+
+// findGUIDPodNetwork returns the pod network the guid is allocated for, or the pod network annotated with the guid
+// if it isn't allocated. It returns nil pod if no pod network is found.
+func (d *daemon) findGUIDPodNetwork(guidAddr net.HardwareAddr, podNetworkID string) (
+	*kapi.Pod, *v1.NetworkSelectionElement, error) {
+	pods, err := d.kubeClient.GetPods(kapi.NamespaceAll)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get pods from kubernetes: %v", err)
+	}
+
+	for index := range pods.Items {
+		pod := &pods.Items[index]
+		networks, networksErr := netAttUtils.ParsePodNetworkAnnotation(pod)
+		if networksErr != nil {
+			continue
+		}
+
+		for _, network := range networks {
+			if podNetworkID != "" {
+				if string(d.vmiAnnotator.GetAllocationUID(pod))+network.Name == podNetworkID {
+					return pod, network, nil
+				}
+				continue
+			}
+
+			if podGUID, guidErr := utils.GetPodNetworkGUID(network); guidErr == nil && isSameGUID(podGUID, guidAddr) {
+				return pod, network, nil
+			}
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// checkPodNetworkAnnotation checks the pod network annotation has the guid and is configured with InfiniBand with
+// the given annotation key
+func checkPodNetworkAnnotation(network *v1.NetworkSelectionElement, guidAddr net.HardwareAddr,
+	annotationKey string) (bool, string) {
+	podGUID, err := utils.GetPodNetworkGUID(network)
+	if err != nil {
+		return false, err.Error()
+	}
+
+	if !isSameGUID(podGUID, guidAddr) {
+		return false, fmt.Sprintf("pod network annotation has guid %s", podGUID)
+	}
+
+	if !utils.IsPodNetworkConfiguredWithInfiniBand(network, annotationKey) {
+		return false, "pod network isn't configured with InfiniBand"
+	}
+
+	return true, ""
+}
+
+// checkSMMembership checks the guid is a member of the pod network pKey in the subnet manager,
+// guids of networks without pKey aren't added to the subnet manager
+func (d *daemon) checkSMMembership(report *GUIDCheckReport, pod *kapi.Pod, network *v1.NetworkSelectionElement,
+	guidAddr net.HardwareAddr) (bool, string) {
+	networkPKey, _, err := d.getPodNetworkPKey(pod, network)
+	if err != nil {
+		return false, err.Error()
+	}
+
+	if networkPKey == "" {
+		return true, "network has no pKey"
+	}
+	report.PKey = networkPKey
+
+	pKey, err := utils.ParsePKey(networkPKey)
+	if err != nil {
+		return false, err.Error()
+	}
+
+	members, err := d.smClient.GetPKeyMembership(context.Background(), pKey)
+	if err != nil {
+		return false, fmt.Sprintf("failed to get pKey %s members with subnet manager %s with error: %v",
+			networkPKey, d.smClient.Name(), err)
+	}
+
+	for _, member := range members {
+		if member.String() == guidAddr.String() {
+			return true, ""
+		}
+	}
+
+	return false, fmt.Sprintf("guid isn't a member of pKey %s in subnet manager %s", networkPKey,
+		d.smClient.Name())
+}
+
+// isSameGUID checks the guid string is the given guid, regardless of its letter case
+func isSameGUID(podGUID string, guidAddr net.HardwareAddr) bool {
+	podGUIDAddr, err := net.ParseMAC(podGUID)
+	return err == nil && podGUIDAddr.String() == guidAddr.String()
+}
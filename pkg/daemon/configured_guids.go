@@ -0,0 +1,42 @@
+package daemon
+
+import (
+	"context"
+	"net"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+)
+
+// addMissingConfiguredGUIDs appends the guids of the interfaces already configured with InfiniBand, which are
+// missing from the pKey members in the subnet manager, to the guids to add. The configured pods are re-queued on
+// container restart, so their pKey membership is checked with a single membership query instead of adding them again.
+// All the configured guids are added again if the pKey members can't be checked.
+func (d *daemon) addMissingConfiguredGUIDs(pKey int, configuredPods []*kapi.Pod, configuredGUIDs []net.HardwareAddr,
+	passedPods []*kapi.Pod, guidList []net.HardwareAddr) ([]*kapi.Pod, []net.HardwareAddr) {
+	members, err := d.smClient.GetPKeyMembership(context.Background(), pKey)
+	if err != nil {
+		log.Warn().Msgf("failed to get pKey 0x%04X members with subnet manager %s, adding the configured guids "+
+			"again, with error: %v", pKey, d.smClient.Name(), err)
+		return append(passedPods, configuredPods...), append(guidList, configuredGUIDs...)
+	}
+
+	membersSet := make(map[string]bool, len(members))
+	for _, member := range members {
+		membersSet[member.String()] = true
+	}
+
+	var skipped int
+	for index, pod := range configuredPods {
+		if membersSet[configuredGUIDs[index].String()] {
+			skipped++
+			continue
+		}
+		log.Info().Msgf("guid %s of configured pod %s in namespace %s is missing from pKey 0x%04X, adding it again",
+			configuredGUIDs[index], pod.Name, pod.Namespace, pKey)
+		passedPods = append(passedPods, pod)
+		guidList = append(guidList, configuredGUIDs[index])
+	}
+	log.Debug().Msgf("skipped %d already configured guids of pKey 0x%04X", skipped, pKey)
+	return passedPods, guidList
+}
@@ -1,13 +1,19 @@
 package daemon
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/signal"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,36 +21,131 @@ import (
 	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
 	"github.com/rs/zerolog/log"
 	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 
+	"github.com/Mellanox/ib-kubernetes/pkg/audit"
 	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/dns"
+	ibgrpc "github.com/Mellanox/ib-kubernetes/pkg/grpc"
 	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/health"
 	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+	"github.com/Mellanox/ib-kubernetes/pkg/pkey"
+	"github.com/Mellanox/ib-kubernetes/pkg/profiling"
 	"github.com/Mellanox/ib-kubernetes/pkg/sm"
 	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins/opensm"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins/ufm"
+	"github.com/Mellanox/ib-kubernetes/pkg/status"
 	"github.com/Mellanox/ib-kubernetes/pkg/utils"
 	"github.com/Mellanox/ib-kubernetes/pkg/watcher"
 	resEvenHandler "github.com/Mellanox/ib-kubernetes/pkg/watcher/handler"
+	"github.com/Mellanox/ib-kubernetes/pkg/webhook"
 )
 
+// fragmentationWarningScore is the guid pool fragmentation score above which a warning is logged
+const fragmentationWarningScore = 0.7
+
+// networkGUIDsRemoval holds the guids of the deleted pods of a network to remove from the subnet manager
+type networkGUIDsRemoval struct {
+	networkID  string
+	pKeyName   string // pKey of the network spec, empty if the network has no pKey
+	pKey       int
+	hasPKey    bool // the guids should be removed from the pKey in the subnet manager
+	guidList   []net.HardwareAddr
+	guidPods   []*kapi.Pod
+	failedPods []*kapi.Pod
+	pods       []*kapi.Pod   // deleted pods of the network, their guid release finalizers are removed
+	duration   time.Duration // time spent processing the network pods, without the subnet manager removal
+}
+
 type Daemon interface {
 	// Execute Daemon loop, returns when os.Interrupt signal is received
 	Run()
+
+	// AddPeriodicUpdate configures the guids of the added pods received by the watcher
+	AddPeriodicUpdate()
+
+	// DeletePeriodicUpdate releases the guids of the deleted pods received by the watcher
+	DeletePeriodicUpdate()
 }
 
+// ErrNamespaceIsolationViolation is returned when adding pods to a pKey would share it between namespaces
+var ErrNamespaceIsolationViolation = errors.New("namespace isolation violation")
+
+// namespaceIsolationReason is the reason of the pods events of namespace isolation violations
+const namespaceIsolationReason = "NamespaceIsolationViolation"
+
+// Reasons of the pods events of failures to allocate their guids and to add their guids to the pKey
+const (
+	guidAllocationFailedReason = "GUIDAllocationFailed"
+	subnetManagerErrorReason   = "SubnetManagerError"
+)
+
 type daemon struct {
 	config            config.DaemonConfig
+	configLock        sync.RWMutex // guards config, which is replaced by the config map watcher
 	watcher           watcher.Watcher
 	kubeClient        k8sClient.Client
 	guidPool          guid.Pool
 	smClient          plugins.SubnetManagerClient
-	guidPodNetworkMap map[string]string // allocated guid mapped to the pod and network
+	auditor           audit.Auditor
+	nadWatcher        watcher.Watcher        // network attachment definitions watcher, nil if not managing nad guids
+	nadGUIDPools      *utils.SynchronizedMap // network attachment definitions guid pools mapped by network id
+	drainingNADs      *utils.SynchronizedMap // deleted network attachment definitions with allocated guids by network id
+	nodeWatcher       watcher.Watcher        // node SR-IOV VFs count watcher, nil if not watching the node VFs
+	nodeVFs           int64                  // highest SR-IOV VFs count of the node, -1 until the node is seen
+	namespaceWatcher  watcher.Watcher        // namespaces deletion watcher, nil if not watching namespaces deletion
+	migrationWatcher  watcher.Watcher        // guid migrations watcher, nil if guid migration is disabled
+	sidecarServer     ibgrpc.Server          // CNI plugin guid requests server, nil if not in sidecar mode
+	dnsExporter       dns.Exporter           // guid to pod dns records exporter, nil if disabled
+	webhookServer     webhook.Server         // admission webhooks server, nil if disabled
+	metricsServer     metrics.Server         // prometheus metrics server, nil if disabled
+	healthServer      health.Server          // liveness and readiness probes server, nil if disabled
+	pKeyPool          pkey.Pool              // pKeys assigned to the networks without pKey, nil if disabled
+	poolPersistence   *guidPoolPersistence   // last persisted guid pool state, nil if the pool isn't persisted
+	grpcServer        ibgrpc.Server          // multus guid allocator grpc server, nil if not in multus grpc mode
+	idleGUIDs         *idleGUIDTracker       // guids tracked for idle eviction, nil if disabled
+	topologyCache     *fabricTopologyCache   // fabric topology of topology aware allocation, nil if disabled
+	smRateLimiter     *networkRateLimiter    // per network subnet manager calls rate limiter
+	networkSpecs      *networkSpecCache      // parsed network attachment definitions specs, nil if not cached
+	statusServer      status.Server          // daemon status requests server, nil if disabled
+	guidSigningKey    ed25519.PrivateKey     // key of the pods guid signatures, nil if disabled
+	ibAnnotationKey   string                 // cni-args key of the configured pod networks, set on startup
+	startTime         time.Time
+	updateTimes       periodicUpdateTimes
+	vmiAnnotator      VMIGUIDAnnotator
+	guidPodNetworkMap map[string]string // allocated guid mapped to the pod and network, guarded by guidAllocationLock
+
+	// serializes the guid allocations and releases, and guards the guidPodNetworkMap reads and writes, as the networks
+	// are processed concurrently with the guid requests
+	guidAllocationLock sync.Mutex
+	// guid pools found exhausted in the current add update, guarded by guidAllocationLock
+	exhaustedGUIDPools map[exhaustedGUIDPool]bool
+	// guids released by ReleaseGUID requests mapped to their pod uid until the pod deletion is received, guarded by
+	// guidAllocationLock
+	releasedGUIDs map[string]types.UID
+	// consecutive add updates failures of the failed pods mapped by network id, guarded by the add map lock
+	podRetries map[string]map[types.UID]int
+	// deleted pods whose guid release finalizer failed to be removed mapped by pod uid, guarded by the delete map lock
+	pendingFinalizers map[types.UID]*kapi.Pod
+}
+
+// Options are the daemon command line options
+type Options struct {
+	// Latency added to the kubernetes api calls for performance testing, disabled if nil
+	SimulatedAPILatency *k8sClient.SimulatedLatency
+	// Rate of kubernetes api calls failed with a simulated error for performance testing, between 0 and 1
+	APIErrorRate float64
 }
 
 // NewDaemon initializes the need components including k8s client, subnet manager client plugins, and guid pool.
 // It returns error in case of failure.
-func NewDaemon() (Daemon, error) {
+func NewDaemon(options Options) (Daemon, error) {
 	daemonConfig := config.DaemonConfig{}
 	if err := daemonConfig.ReadConfig(); err != nil {
 		return nil, err
@@ -53,43 +154,324 @@ func NewDaemon() (Daemon, error) {
 	if err := daemonConfig.ValidateConfig(); err != nil {
 		return nil, err
 	}
+	setLogFormat(daemonConfig.LogFormat)
 
-	podEventHandler := resEvenHandler.NewPodEventHandler()
-	client, err := k8sClient.NewK8sClient()
+	client, err := k8sClient.NewK8sClientWithNamespaceCacheTTL(
+		time.Duration(daemonConfig.NamespaceCacheTTL) * time.Second)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if options.SimulatedAPILatency != nil || options.APIErrorRate > 0 {
+		latency := k8sClient.SimulatedLatency{}
+		if options.SimulatedAPILatency != nil {
+			latency = *options.SimulatedAPILatency
+		}
+		log.Warn().Msgf("simulating kubernetes api latency %+v with error rate %v", latency, options.APIErrorRate)
+		client = k8sClient.NewLatencySimulatingClient(client, latency, options.APIErrorRate)
+	}
+
+	if daemonConfig.DryRun {
+		log.Warn().Msg("dry run: subnet manager and kubernetes changes are logged and not applied")
+		client = k8sClient.NewDryRunClient(client)
+	}
+
+	if daemonConfig.MaxConcurrentAnnotationWrites > 0 {
+		client = k8sClient.NewAnnotationWriteLimitedClient(client, daemonConfig.MaxConcurrentAnnotationWrites)
+	}
+
+	var quotaChecker resEvenHandler.QuotaChecker
+	if daemonConfig.EnableQuotaCheck {
+		quotaChecker = resEvenHandler.NewQuotaChecker(client)
+	}
+	podEventHandler := resEvenHandler.NewPodEventHandler(quotaChecker)
+	if ipTracker, ok := podEventHandler.(resEvenHandler.PodIPChangesTracker); ok && daemonConfig.TrackPodIPChanges {
+		ipTracker.TrackPodIPChanges()
+	}
+	if selector, ok := podEventHandler.(resEvenHandler.PodAnnotationsSelector); ok &&
+		daemonConfig.PodFieldSelector != "" {
+		podSelector, selectorErr := daemonConfig.GetPodFieldSelector()
+		if selectorErr != nil {
+			return nil, selectorErr
+		}
+		selector.SelectPodsByAnnotations(podSelector)
+	}
+	if filter, ok := podEventHandler.(resEvenHandler.PodNamespacesFilter); ok &&
+		(len(daemonConfig.WatchNamespaces) != 0 || len(daemonConfig.ExcludeNamespaces) != 0) {
+		filter.FilterNamespaces(daemonConfig.WatchNamespaces, daemonConfig.ExcludeNamespaces)
+	}
+	if keySetter, ok := podEventHandler.(resEvenHandler.InfiniBandAnnotationKeySetter); ok {
+		keySetter.SetInfiniBandAnnotationKey(daemonConfig.InfiniBandAnnotationKey)
+	}
+
 	guidPool, err := guid.NewPool(&daemonConfig.GUIDPool)
 	if err != nil {
 		return nil, err
 	}
 
-	pluginLoader := sm.NewPluginLoader()
-	getSmClientFunc, err := pluginLoader.LoadPlugin(path.Join("/plugins", daemonConfig.Plugin+".so"),
-		sm.InitializePluginFunc)
+	smClient, err := newSMClient(&daemonConfig)
 	if err != nil {
 		return nil, err
 	}
+	if err = checkGIDSupport(smClient, daemonConfig.SubnetPrefix); err != nil {
+		return nil, err
+	}
 
-	smClient, err := getSmClientFunc()
+	if daemonConfig.DualWriteSM {
+		secondarySMClient, loadErr := loadSMClientWithRetry(daemonConfig.SecondaryPlugin,
+			daemonConfig.SecondaryPluginConfPath, time.Duration(daemonConfig.StartupTimeout)*time.Second)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		if err = checkGIDSupport(secondarySMClient, daemonConfig.SubnetPrefix); err != nil {
+			return nil, err
+		}
+		log.Info().Msgf("writing pKey changes to subnet managers %s and %s", smClient.Name(), secondarySMClient.Name())
+		smClient = plugins.NewDualWriteClient(smClient, secondarySMClient)
+	}
+
+	if daemonConfig.SubnetManagerTimeout > 0 {
+		smClient = plugins.NewTimeoutClient(smClient, time.Duration(daemonConfig.SubnetManagerTimeout)*time.Second)
+	}
+	// the calls are traced only by builds with the otel tag
+	smClient = plugins.NewOTelClient(smClient)
+
+	if daemonConfig.DryRun {
+		smClient = plugins.NewDryRunClient(smClient)
+	}
+
+	podLabelSelector, err := daemonConfig.GetPodLabelSelector()
 	if err != nil {
 		return nil, err
 	}
 
-	if err := smClient.Validate(); err != nil {
+	var podWatcher watcher.Watcher
+	if daemonConfig.SidecarMode {
+		podWatcher = watcher.NewNodeWatcher(podEventHandler, client, daemonConfig.NodeName, podLabelSelector)
+	} else {
+		podWatcher = watcher.NewPodWatcher(podEventHandler, client, podLabelSelector)
+	}
+
+	return NewDaemonWithDeps(daemonConfig, client, guidPool, smClient, podWatcher)
+}
+
+// NewDaemonWithDeps initializes the daemon components with the given k8s client, guid pool, subnet manager client
+// and pods watcher, e.g fakes in tests. The dependencies are used as given, the configuration isn't read from the
+// environment and the dry run and timeout clients aren't added. It returns error in case of failure.
+func NewDaemonWithDeps(daemonConfig config.DaemonConfig, client k8sClient.Client, guidPool guid.Pool,
+	smClient plugins.SubnetManagerClient, podWatcher watcher.Watcher) (Daemon, error) {
+	metrics.GUIDPoolExcluded.Set(float64(guidPool.Stats().Excluded))
+	d, err := newDaemon(daemonConfig, client, guidPool, smClient, podWatcher)
+	if err != nil {
 		return nil, err
 	}
 
-	podWatcher := watcher.NewWatcher(podEventHandler, client)
-	return &daemon{
+	if daemonConfig.AuditSocket != "" {
+		d.auditor = audit.NewAuditor(daemonConfig.AuditSocket, daemonConfig.AuditBufferSize)
+	}
+
+	if daemonConfig.ManageNADGUIDs {
+		d.nadWatcher = watcher.NewNetworkAttachmentDefinitionWatcher(
+			resEvenHandler.NewNetworkAttachmentDefinitionEventHandler(), client)
+	}
+
+	if daemonConfig.WatchNodeVFs {
+		d.nodeWatcher = watcher.NewNodeObjectWatcher(resEvenHandler.NewNodeEventHandler(), client,
+			daemonConfig.NodeName)
+	}
+
+	if daemonConfig.WatchNamespaceDeletion {
+		d.namespaceWatcher = watcher.NewWatcher(resEvenHandler.NewNamespaceEventHandler(), client)
+	}
+
+	if daemonConfig.EnableGUIDMigration {
+		d.migrationWatcher = watcher.NewGUIDMigrationWatcher(resEvenHandler.NewGUIDMigrationEventHandler(), client)
+	}
+
+	if daemonConfig.GUIDDNSZone != "" {
+		// the config map format is checked by ValidateConfig
+		namespace, name, _ := daemonConfig.GetGUIDDNSConfigMap()
+		d.dnsExporter = dns.NewExporter(client, daemonConfig.GUIDDNSZone, namespace, name)
+	}
+
+	if daemonConfig.MultusGRPCMode {
+		d.grpcServer = ibgrpc.NewServer(daemonConfig.MultusGRPCSocket, d)
+	}
+
+	if daemonConfig.WebhookAddress != "" {
+		d.webhookServer = webhook.NewServer(daemonConfig.WebhookAddress, daemonConfig.WebhookCertFile,
+			daemonConfig.WebhookKeyFile, client)
+	}
+
+	if daemonConfig.GUIDPool.PersistenceBackend == config.PersistenceBackendConfigMap {
+		d.poolPersistence = &guidPoolPersistence{}
+	}
+
+	if daemonConfig.MetricsAddress != "" {
+		d.metricsServer = metrics.NewServer(daemonConfig.MetricsAddress)
+	}
+
+	if daemonConfig.HealthAddress != "" {
+		var dumper health.GUIDPoolDumper
+		if daemonConfig.EnableDebugEndpoints {
+			dumper = d
+		}
+		d.healthServer = health.NewServer(daemonConfig.HealthAddress, d, dumper)
+	}
+
+	if daemonConfig.CleanSMOnStartup {
+		// stale guids are retried on the next startup, the daemon can run without cleaning them
+		if cleanErr := d.CleanSMOnStartup(context.Background()); cleanErr != nil {
+			log.Warn().Msgf("failed to clean stale guids from subnet manager with error: %v", cleanErr)
+		}
+	}
+
+	if daemonConfig.PerNodePool {
+		if err = d.initNodeSubRanges(); err != nil {
+			return nil, err
+		}
+	}
+
+	if daemonConfig.SidecarMode {
+		d.sidecarServer = ibgrpc.NewServer(daemonConfig.SidecarSocket, d)
+	}
+
+	if daemonConfig.StatusSocket != "" {
+		if d.statusServer, err = status.NewServer(daemonConfig.StatusSocket, d); err != nil {
+			return nil, err
+		}
+	}
+
+	if daemonConfig.GUIDSigningKeySecret != "" {
+		// the secret format is checked by ValidateConfig
+		namespace, name, _ := daemonConfig.GetGUIDSigningKeySecret()
+		if d.guidSigningKey, err = loadGUIDSigningKey(client, namespace, name); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// newDaemon returns the daemon of the given configuration and dependencies, without the watchers of the other
+// resources, the servers and the startup actions, e.g to check guids without running the daemon
+func newDaemon(daemonConfig config.DaemonConfig, client k8sClient.Client, guidPool guid.Pool,
+	smClient plugins.SubnetManagerClient, podWatcher watcher.Watcher) (*daemon, error) {
+	var pKeyPool pkey.Pool
+	if daemonConfig.PKeyPool.Enabled() {
+		var err error
+		if pKeyPool, err = pkey.NewPool(&daemonConfig.PKeyPool); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &daemon{
 		config:            daemonConfig,
 		watcher:           podWatcher,
 		kubeClient:        client,
 		guidPool:          guidPool,
 		smClient:          smClient,
-		guidPodNetworkMap: make(map[string]string)}, nil
+		nadGUIDPools:      utils.NewSynchronizedMap(),
+		drainingNADs:      utils.NewSynchronizedMap(),
+		nodeVFs:           -1,
+		smRateLimiter:     newNetworkRateLimiter(),
+		networkSpecs:      newNetworkSpecCache(networkSpecCacheSize),
+		pKeyPool:          pKeyPool,
+		ibAnnotationKey:   daemonConfig.InfiniBandAnnotationKey,
+		startTime:         time.Now(),
+		guidPodNetworkMap: make(map[string]string)}
+
+	if daemonConfig.IdleGUIDEvictionTimeout > 0 {
+		d.idleGUIDs = newIdleGUIDTracker()
+	}
+
+	if daemonConfig.TopologyAwareAllocation {
+		d.topologyCache = &fabricTopologyCache{}
+	}
+
+	return d, nil
+}
+
+// builtInPlugins are the subnet manager plugins initialized by the daemon instead of loaded with the plugin loader
+var builtInPlugins = map[string]sm.PluginInitialize{
+	ufm.PluginName:    ufm.Initialize,
+	opensm.PluginName: opensm.Initialize,
+}
+
+// newSMClient returns the client of the configured subnet manager plugin, or the built-in noop plugin if the
+// daemon doesn't manage the subnet manager so the plugin isn't loaded and the pKeys aren't changed
+func newSMClient(daemonConfig *config.DaemonConfig) (plugins.SubnetManagerClient, error) {
+	if !daemonConfig.ManageSubnetManager {
+		log.Info().Msg("not managing the subnet manager, the guids are only allocated and annotated")
+		return plugins.NewNoopClient(), nil
+	}
+	return loadSMClientWithRetry(daemonConfig.Plugin, daemonConfig.PluginConfPath,
+		time.Duration(daemonConfig.StartupTimeout)*time.Second)
+}
+
+// loadSMClient loads and validates the subnet manager client plugin, the noop plugin and the builtInPlugins aren't
+// loaded from plugin files. The plugins implementing plugins.ConfigurablePlugin are initialized with the content of
+// the plugin configuration file if it exists.
+func loadSMClient(pluginName, pluginConfPath string) (plugins.SubnetManagerClient, error) {
+	if pluginName == plugins.NoopPluginName {
+		log.Info().Msg("using built-in noop subnet manager plugin")
+		return plugins.NewNoopClient(), nil
+	}
+
+	getSmClientFunc, builtIn := builtInPlugins[pluginName]
+	if builtIn {
+		log.Info().Msgf("using built-in %s subnet manager plugin", pluginName)
+	} else {
+		pluginLoader := sm.NewPluginLoader()
+		var err error
+		getSmClientFunc, err = pluginLoader.LoadPlugin(path.Join("/plugins", pluginName+".so"),
+			sm.InitializePluginFunc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	smClient, err := getSmClientFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = initSMClient(smClient, pluginConfPath); err != nil {
+		return nil, err
+	}
+
+	if err := smClient.Validate(); err != nil {
+		return nil, err
+	}
+
+	return smClient, nil
+}
+
+// initSMClient passes the content of the plugin configuration file to the plugin if it implements
+// plugins.ConfigurablePlugin, plugins are left with their own configuration if the file doesn't exist
+func initSMClient(smClient plugins.SubnetManagerClient, pluginConfPath string) error {
+	configurable, ok := smClient.(plugins.ConfigurablePlugin)
+	if !ok || pluginConfPath == "" {
+		return nil
+	}
+
+	conf, err := ioutil.ReadFile(pluginConfPath)
+	if os.IsNotExist(err) {
+		log.Info().Msgf("subnet manager plugin %s configuration file %s doesn't exist, it isn't initialized",
+			smClient.Name(), pluginConfPath)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read subnet manager plugin %s configuration file %s: %v", smClient.Name(),
+			pluginConfPath, err)
+	}
+
+	if err = configurable.Init(conf); err != nil {
+		return fmt.Errorf("failed to initialize subnet manager plugin %s with configuration file %s: %v",
+			smClient.Name(), pluginConfPath, err)
+	}
+	return nil
 }
 
 func (d *daemon) Run() {
@@ -97,248 +479,1200 @@ func (d *daemon) Run() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// closing the channel will stop the goroutines executed in the wait.Until() calls below
+	stopPeriodicsChan := make(chan struct{})
+	defer close(stopPeriodicsChan)
+
+	// the metrics and the probes are served while waiting for the leadership
+	if d.metricsServer != nil {
+		go func() {
+			if runErr := d.metricsServer.Run(stopPeriodicsChan); runErr != nil {
+				log.Error().Msgf("metrics server failed with error: %v", runErr)
+			}
+		}()
+	}
+
+	if d.healthServer != nil {
+		go func() {
+			if runErr := d.healthServer.Run(stopPeriodicsChan); runErr != nil {
+				log.Error().Msgf("health probes server failed with error: %v", runErr)
+			}
+		}()
+	}
+
+	// the guid pool is restored by the leader only once elected, from the pods annotations set by the previous leader
+	if d.getConfig().EnableLeaderElection {
+		elected, release := d.waitForLeadership(sigChan)
+		if !elected {
+			return
+		}
+		defer release()
+	}
+
+	// Restore the network attachment definitions guid pools before the guids of their pods
+	if d.nadWatcher != nil {
+		if err := d.initNADGUIDPools(); err != nil {
+			log.Error().Msgf("initNADGUIDPools(): Daemon could not init the network attachment definitions "+
+				"guid pools: %v", err)
+			os.Exit(1)
+		}
+	}
+
 	// Init the guid pool
 	if err := d.initPool(); err != nil {
 		log.Error().Msgf("initPool(): Daemon could not init the guid pool: %v", err)
 		os.Exit(1)
 	}
 
+	if d.pKeyPool != nil {
+		if err := d.initPKeyPool(); err != nil {
+			log.Error().Msgf("initPKeyPool(): Daemon could not init the pKey pool: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	// orphaned guids are retried on the next startup, the daemon can run without reclaiming them
+	if err := d.ReconcileOrphanedGUIDs(); err != nil {
+		log.Warn().Msgf("failed to reclaim orphaned guids with error: %v", err)
+	}
+
+	// Set the namespaces quotas after restoring the guids of the running pods, which may exceed the quotas
+	for namespace, maxGUIDs := range d.getConfig().NamespaceGUIDQuotas {
+		d.guidPool.SetQuota(namespace, maxGUIDs)
+	}
+	d.checkQuotaNamespaces()
+
 	// Run periodic tasks
-	// closing the channel will stop the goroutines executed in the wait.Until() calls below
-	stopPeriodicsChan := make(chan struct{})
-	go wait.Until(d.AddPeriodicUpdate, time.Duration(d.config.PeriodicUpdate)*time.Second, stopPeriodicsChan)
-	go wait.Until(d.DeletePeriodicUpdate, time.Duration(d.config.PeriodicUpdate)*time.Second, stopPeriodicsChan)
-	defer close(stopPeriodicsChan)
+	periodicsConfig := d.getConfig()
+	go wait.Until(d.AddPeriodicUpdate, time.Duration(periodicsConfig.GetAddPeriodicUpdateInterval())*time.Second,
+		stopPeriodicsChan)
+	go wait.Until(d.DeletePeriodicUpdate, time.Duration(periodicsConfig.GetDeletePeriodicUpdateInterval())*time.Second,
+		stopPeriodicsChan)
+	// the namespaces are listed from the api server once per namespace cache ttl
+	go wait.Until(d.updateManagedNamespaces, time.Duration(d.getConfig().PeriodicUpdate)*time.Second,
+		stopPeriodicsChan)
+
+	if d.auditor != nil {
+		go d.auditor.Run(stopPeriodicsChan)
+	}
+
+	if d.dnsExporter != nil {
+		go d.dnsExporter.Run(stopPeriodicsChan)
+	}
+
+	if reconcilePeriod := d.getConfig().ReconcilePeriod; reconcilePeriod > 0 {
+		// the orphaned guids are reclaimed on startup above, the first periodic reconcile is after a period
+		go wait.Until(d.OrphanedGUIDsPeriodicUpdate, time.Duration(reconcilePeriod)*time.Second, stopPeriodicsChan)
+	}
+
+	if smReconcileInterval := d.getConfig().SMReconcileInterval; smReconcileInterval > 0 {
+		// the first reconcile runs on startup, failed additions are retried on the next interval
+		go wait.Until(d.SMReconcilePeriodicUpdate, time.Duration(smReconcileInterval)*time.Second, stopPeriodicsChan)
+	}
+
+	if d.idleGUIDs != nil {
+		go wait.Until(d.evictIdleGUIDs,
+			time.Duration(d.getConfig().IdleGUIDEvictionTimeout)*time.Second/idleGUIDCheckDivisor, stopPeriodicsChan)
+	}
+
+	if daemonConfig := d.getConfig(); daemonConfig.ConfigMap != "" {
+		// the config map format is checked by ValidateConfig
+		namespace, name, _ := daemonConfig.GetConfigMap()
+		go config.NewConfigMapWatcher(d.kubeClient, namespace, name, daemonConfig, d.setConfig).Run(stopPeriodicsChan)
+	}
+
+	go profiling.RunCPUProfileOnSignal(time.Duration(d.getConfig().CPUProfileDuration)*time.Second, stopPeriodicsChan)
+
+	if d.grpcServer != nil {
+		go func() {
+			if runErr := d.grpcServer.Run(stopPeriodicsChan); runErr != nil {
+				log.Error().Msgf("guid allocator grpc server failed with error: %v", runErr)
+			}
+		}()
+	}
+
+	if d.webhookServer != nil {
+		go func() {
+			if runErr := d.webhookServer.Run(stopPeriodicsChan); runErr != nil {
+				log.Error().Msgf("admission webhooks server failed with error: %v", runErr)
+			}
+		}()
+	}
+
+	if d.sidecarServer != nil {
+		go func() {
+			if runErr := d.sidecarServer.Run(stopPeriodicsChan); runErr != nil {
+				log.Error().Msgf("sidecar guid requests server failed with error: %v", runErr)
+			}
+		}()
+	}
+
+	if d.statusServer != nil {
+		go func() {
+			if runErr := d.statusServer.Run(stopPeriodicsChan); runErr != nil {
+				log.Error().Msgf("status requests server failed with error: %v", runErr)
+			}
+		}()
+	}
+
+	if pendingQuotaHandler, ok := d.watcher.GetHandler().(resEvenHandler.PendingQuotaHandler); ok {
+		go wait.Until(pendingQuotaHandler.RecheckPendingQuota, time.Duration(d.getConfig().PeriodicUpdate)*time.Second,
+			stopPeriodicsChan)
+	}
+
+	if d.nadWatcher != nil {
+		go wait.Until(d.NADPeriodicUpdate, time.Duration(d.getConfig().PeriodicUpdate)*time.Second, stopPeriodicsChan)
+		nadWatcherStopFunc := d.nadWatcher.RunBackground()
+		defer nadWatcherStopFunc()
+	}
+
+	if d.nodeWatcher != nil {
+		nodeWatcherStopFunc := d.nodeWatcher.RunBackground()
+		defer nodeWatcherStopFunc()
+	}
+
+	if d.namespaceWatcher != nil {
+		namespaceWatcherStopFunc := d.namespaceWatcher.RunBackground()
+		defer namespaceWatcherStopFunc()
+	}
+
+	if d.migrationWatcher != nil {
+		go wait.Until(d.GUIDMigrationPeriodicUpdate, time.Duration(d.getConfig().PeriodicUpdate)*time.Second,
+			stopPeriodicsChan)
+		migrationWatcherStopFunc := d.migrationWatcher.RunBackground()
+		defer migrationWatcherStopFunc()
+	}
+
+	// the pending pods are flushed once the pods watcher is stopped
+	defer d.drain()
 
 	// Run Watcher in background, calling watcherStopFunc() will stop the watcher
 	watcherStopFunc := d.watcher.RunBackground()
 	defer watcherStopFunc()
 
+	if desyncDetector, ok := d.watcher.(watcher.DesyncDetector); ok && d.getConfig().DesyncCheckInterval > 0 {
+		go d.runDesyncDetection(desyncDetector, stopPeriodicsChan)
+	}
+
 	// Run until interrupted by os signals
 	sig := <-sigChan
 	log.Info().Msgf("Received signal %s. Terminating...", sig)
 }
 
-func (d *daemon) AddPeriodicUpdate() {
-	log.Info().Msgf("running periodic add update")
+// RequestGUID handles the given pod network immediately if it is pending in the add map, and returns its guid.
+// It returns error if the pod network isn't configured with InfiniBand, e.g the pod event wasn't received yet.
+func (d *daemon) RequestGUID(podUID types.UID, networkName string) (string, error) {
+	if pod := d.processAddedPodNetwork(podUID, networkName); pod != nil {
+		return getPodNetworkGUID(pod, networkName, d.ibAnnotationKey)
+	}
+
+	// the pod network is either configured already or its pod event wasn't received yet
+	pods, err := d.kubeClient.GetNodePods(d.getConfig().NodeName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get pods of node %s: %v", d.getConfig().NodeName, err)
+	}
+
+	for index := range pods.Items {
+		if pods.Items[index].UID == podUID {
+			return getPodNetworkGUID(&pods.Items[index], networkName, d.ibAnnotationKey)
+		}
+	}
+	return "", fmt.Errorf("pod %s not found on node %s", podUID, d.getConfig().NodeName)
+}
+
+// AllocateGUID handles the given pod network immediately if it is pending in the add map, and returns its guid.
+// It returns error if the pod network isn't configured with InfiniBand, e.g the pod event wasn't received yet.
+func (d *daemon) AllocateGUID(podNamespace, podName string, podUID types.UID, networkName string) (string, error) {
+	pod := d.processAddedPodNetwork(podUID, networkName)
+	if pod == nil {
+		// the pod network is either configured already or its pod event wasn't received yet
+		var err error
+		if pod, err = d.kubeClient.GetPod(podNamespace, podName); err != nil {
+			return "", fmt.Errorf("failed to get pod namespace %s name %s: %v", podNamespace, podName, err)
+		}
+		if pod.UID != podUID {
+			return "", fmt.Errorf("pod %s not found in namespace %s", podUID, podNamespace)
+		}
+	}
+
+	return getPodNetworkGUID(pod, networkName, d.ibAnnotationKey)
+}
+
+// processAddedPodNetwork allocates and configures the guids of the pod network pending in the add map, like the add
+// periodic update does for all the pending pods, and returns the pod. The pod is kept in the add map only if it
+// failed. It returns nil if the pod network isn't pending.
+func (d *daemon) processAddedPodNetwork(podUID types.UID, networkName string) *kapi.Pod {
 	addMap, _ := d.watcher.GetHandler().GetResults()
 	addMap.Lock()
 	defer addMap.Unlock()
-	podNetworksMap := map[types.UID][]*v1.NetworkSelectionElement{}
 	for networkID, podsInterface := range addMap.Items {
-		log.Info().Msgf("processing network networkID %s", networkID)
-		networkNamespace, networkName, err := utils.ParseNetworkID(networkID)
-		if err != nil {
-			log.Err(err)
+		if _, name, err := utils.ParseNetworkID(networkID); err != nil || name != networkName {
 			continue
 		}
-		pods, ok := podsInterface.([]*kapi.Pod)
-		if !ok {
-			log.Error().Msgf(
-				"invalid value for add map networks expected pods array \"[]*kubernetes.Pod\", found %T",
-				podsInterface)
+		pods, _ := podsInterface.([]*kapi.Pod)
+		// the pod is listed once for every interface of the network
+		var networkPods, otherPods []*kapi.Pod
+		for _, pod := range pods {
+			if pod.UID == podUID {
+				networkPods = append(networkPods, pod)
+			} else {
+				otherPods = append(otherPods, pod)
+			}
+		}
+		if len(networkPods) == 0 {
 			continue
 		}
 
-		if len(pods) == 0 {
-			continue
+		result := d.processAddNetwork(networkID, networkPods, map[types.UID][]*v1.NetworkSelectionElement{})
+		if result.ibNetwork {
+			recordNetworkReconcile(networkID, result.duration, result.failureReason)
+		}
+		if result.processed {
+			otherPods = append(otherPods, result.failedPods...)
+			if len(otherPods) == 0 {
+				addMap.UnSafeRemove(networkID)
+			} else {
+				addMap.UnSafeSet(networkID, otherPods)
+			}
 		}
+		readyPods := map[types.UID]*kapi.Pod{}
+		for _, pod := range result.readyPods {
+			readyPods[pod.UID] = pod
+		}
+		d.setIBReadyConditions(addMap, readyPods)
+		return networkPods[0]
+	}
 
-		netAttInfo, err := d.kubeClient.GetNetworkAttachmentDefinition(networkNamespace, networkName)
+	return nil
+}
+
+// poolAllocation is a guid allocation of a guid pool
+type poolAllocation struct {
+	pool       guid.Pool
+	allocation guid.Allocation
+}
+
+// ReleaseGUID removes the guids which the guid pools hold for the pod network from their pKeys and releases them.
+// The released guids are skipped once the pod deletion is received. Nothing is released if the pod network has no
+// allocated guids, e.g they are already released.
+// It returns error if the guids failed to be removed from their pKeys or released.
+func (d *daemon) ReleaseGUID(podUID types.UID, networkName string) error {
+	var allocations []poolAllocation
+	d.guidAllocationLock.Lock()
+	for _, guidPool := range d.getGUIDPools() {
+		for _, allocation := range guidPool.GetAllocations() {
+			if allocation.PodUID == podUID && allocation.Network == networkName {
+				allocations = append(allocations, poolAllocation{pool: guidPool, allocation: allocation})
+			}
+		}
+	}
+	d.guidAllocationLock.Unlock()
+	if len(allocations) == 0 {
+		log.Info().Msgf("no guids allocated for pod %s network %s", podUID, networkName)
+		return nil
+	}
+
+	// the guids are released only once removed from their pKeys, so they aren't allocated to other pods while
+	// still in the pKeys
+	pKeyGUIDs := map[string][]net.HardwareAddr{}
+	for _, allocation := range allocations {
+		if allocation.allocation.PKey != "" {
+			pKeyGUIDs[allocation.allocation.PKey] = append(pKeyGUIDs[allocation.allocation.PKey],
+				allocation.allocation.GUID.HardWareAddress())
+		}
+	}
+	for pKeyName, guidList := range pKeyGUIDs {
+		pKey, err := utils.ParsePKey(pKeyName)
 		if err != nil {
-			log.Warn().Msgf("failed to get networkName attachment %s with error: %v", networkName, err)
-			// skip failed networks
+			return fmt.Errorf("failed to parse pKey %s of pod %s network %s guids: %v", pKeyName, podUID,
+				networkName, err)
+		}
+		if _, err = d.removeGuidsFromPKeyInBatches(pKey, guidList); err != nil {
+			return fmt.Errorf("failed to remove guids %v of pod %s network %s from pKey %s with subnet manager %s: %v",
+				guidList, podUID, networkName, pKeyName, d.smClient.Name(), err)
+		}
+	}
+
+	var releasedGUIDs []net.HardwareAddr
+	var releaseErr error
+	d.guidAllocationLock.Lock()
+	if d.releasedGUIDs == nil {
+		d.releasedGUIDs = map[string]types.UID{}
+	}
+	for _, allocation := range allocations {
+		guidAddr := allocation.allocation.GUID.HardWareAddress()
+		if err := allocation.pool.ReleaseGUID(guidAddr.String()); err != nil {
+			releaseErr = fmt.Errorf("failed to release guid %s of pod %s network %s: %v", guidAddr, podUID,
+				networkName, err)
 			continue
 		}
+		delete(d.guidPodNetworkMap, guidAddr.String())
+		d.releasedGUIDs[guidAddr.String()] = podUID
+		releasedGUIDs = append(releasedGUIDs, guidAddr)
+	}
+	d.guidAllocationLock.Unlock()
 
-		log.Debug().Msgf("networkName attachment %v", netAttInfo)
-		networkSpec := make(map[string]interface{})
-		err = json.Unmarshal([]byte(netAttInfo.Spec.Config), &networkSpec)
-		if err != nil {
-			log.Warn().Msgf("failed to parse networkName attachment %s with error: %v", networkName, err)
-			// skip failed networks
+	for _, guidAddr := range releasedGUIDs {
+		d.removeDNSRecord(guidAddr)
+		d.untrackIdleGUID(guidAddr)
+	}
+	log.Info().Msgf("released guids %v of pod %s network %s", releasedGUIDs, podUID, networkName)
+	return releaseErr
+}
+
+// skipReleasedGUIDs returns the guids of the deleted pod which weren't released by ReleaseGUID requests, the released
+// guids may be allocated to other pods since
+func (d *daemon) skipReleasedGUIDs(podUID types.UID, podGUIDs []net.HardwareAddr) []net.HardwareAddr {
+	d.guidAllocationLock.Lock()
+	defer d.guidAllocationLock.Unlock()
+	if len(d.releasedGUIDs) == 0 {
+		return podGUIDs
+	}
+
+	var remainingGUIDs []net.HardwareAddr
+	for _, guidAddr := range podGUIDs {
+		if releasedFor, released := d.releasedGUIDs[guidAddr.String()]; released && releasedFor == podUID {
+			delete(d.releasedGUIDs, guidAddr.String())
 			continue
 		}
-		log.Debug().Msgf("networkName attachment spec %+v", networkSpec)
+		remainingGUIDs = append(remainingGUIDs, guidAddr)
+	}
+	return remainingGUIDs
+}
+
+// getPodNetworkGUID returns the guid of the pod network configured with the given annotation key
+func getPodNetworkGUID(pod *kapi.Pod, networkName, annotationKey string) (string, error) {
+	networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse network annotations of pod %s: %v", pod.UID, err)
+	}
+
+	network, err := utils.GetPodNetwork(networks, networkName)
+	if err != nil {
+		return "", err
+	}
+
+	if !utils.IsPodNetworkConfiguredWithInfiniBand(network, annotationKey) {
+		return "", fmt.Errorf("network %s of pod %s is not configured with InfiniBand yet", networkName, pod.UID)
+	}
+
+	return utils.GetPodNetworkGUID(network)
+}
+
+// getConfig returns the current daemon configuration
+func (d *daemon) getConfig() config.DaemonConfig {
+	d.configLock.RLock()
+	defer d.configLock.RUnlock()
+	return d.config
+}
+
+// setConfig replaces the daemon configuration, options which are used only on startup, e.g the guid pool range,
+// take effect after restart
+func (d *daemon) setConfig(newConfig config.DaemonConfig) {
+	d.configLock.Lock()
+	defer d.configLock.Unlock()
+	d.config = newConfig
+	log.Info().Msg("applied configuration from config map")
+}
+
+// runDesyncDetection checks the watcher cache desync every DesyncCheckInterval until the stop channel is closed
+func (d *daemon) runDesyncDetection(desyncDetector watcher.DesyncDetector, stopChan <-chan struct{}) {
+	interval := time.Duration(d.getConfig().DesyncCheckInterval) * time.Second
+	// let the watcher sync its cache before the first check
+	select {
+	case <-stopChan:
+		return
+	case <-time.After(interval):
+	}
 
-		ibCniSpec, err := utils.GetIbSriovCniFromNetwork(networkSpec)
+	wait.Until(func() {
+		desync, err := desyncDetector.DetectDesync(d.getConfig().DesyncThreshold)
 		if err != nil {
-			addMap.UnSafeRemove(networkID)
-			log.Warn().Msgf("failed to get InfiniBand SR-IOV CNI spec from network attachment %+v, with error %v",
-				networkSpec, err)
-			// skip failed network
+			log.Warn().Msgf("failed to detect watcher desync with error: %v", err)
+			return
+		}
+		log.Debug().Msgf("watcher cache desync %d", desync)
+	}, interval, stopChan)
+}
+
+func (d *daemon) AddPeriodicUpdate() {
+	log.Info().Msgf("running periodic add update")
+	var smErr error // last subnet manager failure of the update, the daemon isn't ready until an update succeeds
+	defer func() { d.updateTimes.addDone(smErr) }()
+	// extend the guid pool for added VFs before allocating guids for their pods
+	if d.nodeWatcher != nil {
+		d.updateNodeVFs()
+	}
+
+	addMap, _ := d.watcher.GetHandler().GetResults()
+	addMap.Lock()
+	defer addMap.Unlock()
+	readyPods := map[types.UID]*kapi.Pod{} // configured pods with the InfiniBand ready readiness gate
+	for _, result := range d.processAddNetworks(addMap.Items) {
+		if result.smErr != nil {
+			smErr = result.smErr
+		}
+		if result.ibNetwork {
+			recordNetworkReconcile(result.networkID, result.duration, result.failureReason)
+		}
+		for _, pod := range result.readyPods {
+			readyPods[pod.UID] = pod
+		}
+
+		if !result.processed {
 			continue
 		}
-		log.Debug().Msgf("CNI spec %+v", ibCniSpec)
+		result.failedPods = d.dropPodsExceedingRetries(result)
+		if len(result.failedPods) == 0 {
+			addMap.UnSafeRemove(result.networkID)
+		} else {
+			addMap.UnSafeSet(result.networkID, result.failedPods)
+		}
+	}
+	d.prunePodRetries(addMap.Items)
+	d.setIBReadyConditions(addMap, readyPods)
+	d.reportExhaustedGUIDPools()
+	if d.getConfig().EnablePKeyReservations {
+		d.updatePKeyReservationsStatus()
+	}
+	d.checkGUIDPoolFragmentation()
+	d.flushDNSRecords()
+	if d.poolPersistence != nil {
+		d.persistGUIDPool()
+	}
+	log.Info().Msg("add periodic update finished")
+}
 
-		var guidList []net.HardwareAddr
-		var passedPods []*kapi.Pod
-		var failedPods []*kapi.Pod
-		podNetworkMap := map[types.UID]*v1.NetworkSelectionElement{}
-		for _, pod := range pods {
-			log.Debug().Msgf("pod namespace %s name %s", pod.Namespace, pod.Name)
-			networks, ok := podNetworksMap[pod.UID]
-			if !ok {
-				networks, err = netAttUtils.ParsePodNetworkAnnotation(pod)
-				if err != nil {
-					log.Error().Msgf("failed to read pod networkName annotations pod namespace %s name %s, with error: %v",
-						pod.Namespace, pod.Name, err)
-					failedPods = append(failedPods, pod)
-					continue
-				}
+// addNetworkResult is the result of processing the added pods of a network, applied to the add map once all the
+// networks are processed
+type addNetworkResult struct {
+	networkID  string
+	processed  bool        // the network pods were processed, the network is retried as is otherwise
+	failedPods []*kapi.Pod // pods to retry in the next update, the network is removed from the add map if empty
+	readyPods  []*kapi.Pod // configured pods with the InfiniBand ready readiness gate
+	smErr      error       // subnet manager failure of adding the network guids
+	// the network is an InfiniBand network, its duration and failure reason are recorded in the metrics
+	ibNetwork     bool
+	duration      time.Duration
+	failureReason string // reason of the network reconcile failures metric, empty if succeeded
+}
 
-				podNetworksMap[pod.UID] = networks
-			}
-			network, err := utils.GetPodNetwork(networks, networkName)
+// podAllocationFailure is a failure to allocate the guid of a pod, reported as a warning event of the pod
+type podAllocationFailure struct {
+	pod *kapi.Pod
+	err error
+}
+
+// processAddNetwork allocates guids for the added pods of the network, adds them to the network pKey and annotates
+// the pods. The networks of the pods are shared with the other networks of the same pods through podNetworksMap,
+// so networks which share pods must not be processed concurrently.
+func (d *daemon) processAddNetwork(networkID string, podsInterface interface{},
+	podNetworksMap map[types.UID][]*v1.NetworkSelectionElement) *addNetworkResult {
+	result := &addNetworkResult{networkID: networkID}
+	start := time.Now()
+	defer func() { result.duration = time.Since(start) }()
+	log.Info().Str("network", networkID).Msg("processing network")
+	networkNamespace, networkName, err := utils.ParseNetworkID(networkID)
+	if err != nil {
+		log.Err(err)
+		return result
+	}
+	pods, ok := podsInterface.([]*kapi.Pod)
+	if !ok {
+		log.Error().Msgf(
+			"invalid value for add map networks expected pods array \"[]*kubernetes.Pod\", found %T",
+			podsInterface)
+		return result
+	}
+
+	if len(pods) == 0 {
+		return result
+	}
+
+	netAttInfo, err := d.kubeClient.GetNetworkAttachmentDefinition(networkNamespace, networkName)
+	if apiErrors.IsNotFound(err) {
+		// the pods may be created before their network attachment definition, they are retried until it is created
+		log.Info().Msgf("network attachment %s not found, retrying its %d pods", networkID, len(pods))
+		return result
+	}
+	if err != nil {
+		log.Warn().Msgf("failed to get networkName attachment %s with error: %v", networkName, err)
+		// skip failed networks
+		return result
+	}
+
+	log.Debug().Msgf("networkName attachment %v", netAttInfo)
+	ibCniSpec, err := d.getIbSriovCniSpec(networkNamespace, networkName, netAttInfo)
+	if errors.Is(err, errInvalidNetworkConfig) {
+		log.Warn().Msgf("failed to parse networkName attachment %s with error: %v", networkName, err)
+		// skip failed networks
+		return result
+	}
+	if errors.Is(err, errCNIConfigMapUnavailable) {
+		log.Warn().Msgf("failed to get cni config of network attachment %s, will retry, with error: %v", networkID,
+			err)
+		return result
+	}
+	if err != nil {
+		result.processed = true
+		log.Warn().Msgf("failed to get InfiniBand SR-IOV CNI spec with error %v", err)
+		// skip failed network
+		return result
+	}
+	log.Debug().Msgf("CNI spec %+v", ibCniSpec)
+	result.ibNetwork = true
+
+	if ibCniSpec.PKey == "" && d.pKeyPool != nil {
+		d.guidAllocationLock.Lock()
+		ibCniSpec.PKey, err = d.assignNetworkPKey(networkID)
+		d.guidAllocationLock.Unlock()
+		if err != nil {
+			log.Error().Msgf("failed to assign pKey to network %s with error: %v", networkID, err)
+			result.failureReason = reconcileFailurePKey
+			return result
+		}
+	}
+
+	if d.getConfig().ManageNADGUIDs && utils.IsInfiniBandNetworkAttachmentDefinition(netAttInfo) {
+		if _, ok := d.nadGUIDPools.Get(networkID); !ok {
+			log.Info().Msgf("guid range of network attachment %s is not allocated yet, will retry", networkID)
+			return result
+		}
+		if _, draining := d.drainingNADs.Get(networkID); draining {
+			log.Info().Msgf("guid range of deleted network attachment %s is draining, will retry", networkID)
+			return result
+		}
+	}
+	guidPool := d.getNetworkGUIDPool(networkID)
+
+	var guidList []net.HardwareAddr
+	var passedPods []*kapi.Pod
+	var failedPods []*kapi.Pod
+	// guids of the interfaces which are already configured with InfiniBand, and their pods
+	var configuredGUIDs []net.HardwareAddr
+	var configuredPods []*kapi.Pod
+	// the events are created once the guid allocation lock is released
+	var allocationFailures []podAllocationFailure
+	// user allocated guids allocated in this update by their parsed guids
+	userGUIDs := map[string]string{}
+	// the networks of the pods interfaces by their guids, pods have a guid for every interface of the network
+	guidNetworkMap := map[string]*v1.NetworkSelectionElement{}
+	processedPods := map[types.UID]bool{}
+	// user allocated guids which are allocated to deleted pods, looked up before taking the lock
+	staleGUIDs := d.findStaleGUIDs(guidPool, pods, networkID, networkName)
+	d.guidAllocationLock.Lock()
+	for _, pod := range pods {
+		// the pods are listed once for every interface of the network, all the interfaces are processed at once
+		if processedPods[pod.UID] {
+			continue
+		}
+		processedPods[pod.UID] = true
+
+		podLog := podLogger(networkID, pod)
+		podLog.Debug().Msg("processing pod")
+		networks, ok := podNetworksMap[pod.UID]
+		if !ok {
+			networks, err = netAttUtils.ParsePodNetworkAnnotation(pod)
 			if err != nil {
+				podLog.Error().Err(err).Msg("failed to read pod networks annotation")
 				failedPods = append(failedPods, pod)
-				log.Error().Msgf("failed to get pod networkName spec %s with error: %v", networkName, err)
-				// skip failed pod
 				continue
 			}
-			podNetworkMap[pod.UID] = network
 
+			podNetworksMap[pod.UID] = networks
+		}
+		podNetworks, err := utils.GetPodNetworks(networks, networkName)
+		if err != nil {
+			failedPods = append(failedPods, pod)
+			podLog.Error().Err(err).Msg("failed to get pod network spec")
+			// skip failed pod
+			continue
+		}
+
+		allocationUID := d.vmiAnnotator.GetAllocationUID(pod)
+		podNetworkID := string(allocationUID) + networkID
+		podGUIDs := map[string]bool{} // guids of the pod interfaces
+		for _, network := range podNetworks {
 			var guidAddr guid.GUID
-			allocatedGUID, err := utils.GetPodNetworkGUID(network)
-			podNetworkID := string(pod.UID) + networkID
+			if err = d.applyPodGUIDReservation(guidPool, pod, allocationUID, networks, network,
+				networkName); err != nil {
+				failedPods = append(failedPods, pod)
+				podLog.Error().Err(err).Msg("failed to reserve guid")
+				allocationFailures = append(allocationFailures, podAllocationFailure{pod: pod, err: err})
+				continue
+			}
+			allocatedGUID, err := utils.GetPodNetworkGUIDStrict(network)
+			if errors.Is(err, utils.ErrGUIDSanityFailed) {
+				failedPods = append(failedPods, pod)
+				podLog.Error().Err(err).Msg("invalid user allocated guid")
+				allocationFailures = append(allocationFailures, podAllocationFailure{pod: pod, err: err})
+				continue
+			}
 			if err == nil {
 				// User allocated guid manually
+				isNewUserGUID := false
+				if staleUID, stale := staleGUIDs[allocatedGUID]; stale {
+					// the guid is of the previous pod of a recreated pod
+					d.releaseStaleGUID(guidPool, pod, networkID, allocatedGUID, staleUID)
+				}
 				if _, exist := d.guidPodNetworkMap[allocatedGUID]; exist {
 					if podNetworkID != d.guidPodNetworkMap[allocatedGUID] {
 						err = fmt.Errorf("failed to allocate requested guid %s, already allocated for %s",
 							allocatedGUID, d.guidPodNetworkMap[allocatedGUID])
 						log.Err(err)
+						allocationFailures = append(allocationFailures, podAllocationFailure{pod: pod, err: err})
 						continue
 					}
-				} else if err = d.guidPool.AllocateGUID(allocatedGUID); err != nil {
+				} else if err = guidPool.AllocateGUID(
+					allocationUID, pod.Namespace, networkName, allocatedGUID); err != nil {
 					failedPods = append(failedPods, pod)
-					log.Error().Msgf("failed to allocate GUID for pod ID %s, wit error: %v", pod.UID, err)
+					podLog.Error().Err(err).Str("guid", allocatedGUID).Msg("failed to allocate guid")
+					allocationFailures = append(allocationFailures, podAllocationFailure{pod: pod, err: err})
 					continue
 				} else {
 					d.guidPodNetworkMap[allocatedGUID] = podNetworkID
+					isNewUserGUID = true
 				}
 				guidAddr, err = guid.ParseGUID(allocatedGUID)
 				if err != nil {
 					failedPods = append(failedPods, pod)
-					log.Error().Msgf("failed to parse user allocated guid %s with error: %v", allocatedGUID, err)
+					podLog.Error().Err(err).Str("guid", allocatedGUID).Msg(
+						"failed to parse user allocated guid")
+					continue
+				}
+				if podGUIDs[guidAddr.HardWareAddress().String()] {
+					// the guid is allocated for the first interface which requested it
+					failedPods = append(failedPods, pod)
+					err = fmt.Errorf("guid %s is requested for several interfaces of network %s", allocatedGUID,
+						networkName)
+					podLog.Error().Err(err).Msg("invalid user allocated guid")
+					allocationFailures = append(allocationFailures, podAllocationFailure{pod: pod, err: err})
+					continue
+				}
+				if isNewUserGUID {
+					userGUIDs[guidAddr.HardWareAddress().String()] = allocatedGUID
+				} else if utils.IsPodNetworkConfiguredWithInfiniBand(network, d.ibAnnotationKey) {
+					// the guid is already added to the pKey, it is added again only if it is missing from the pKey
+					configuredGUIDs = append(configuredGUIDs, guidAddr.HardWareAddress())
+					configuredPods = append(configuredPods, pod)
+					podGUIDs[guidAddr.HardWareAddress().String()] = true
+					guidNetworkMap[guidAddr.HardWareAddress().String()] = network
 					continue
 				}
 			} else {
-				guidAddr, err = d.guidPool.GenerateGUID()
+				var topologyAllocated bool
+				guidAddr, topologyAllocated, err = d.generatePodGUIDUnlessExhausted(guidPool, pod, allocationUID,
+					networkName)
 				if err != nil {
 					failedPods = append(failedPods, pod)
-					log.Error().Msgf("failed to generate GUID for pod ID %s, wit error: %v", pod.UID, err)
+					podLog.Error().Err(err).Msg("failed to generate guid")
+					allocationFailures = append(allocationFailures, podAllocationFailure{pod: pod, err: err})
 					continue
 				}
 				allocatedGUID = guidAddr.String()
-				if _, exist := d.guidPodNetworkMap[allocatedGUID]; exist {
+				if topologyAllocated {
+					d.guidPodNetworkMap[allocatedGUID] = podNetworkID
+				} else if _, exist := d.guidPodNetworkMap[allocatedGUID]; exist {
 					if podNetworkID != d.guidPodNetworkMap[allocatedGUID] {
 						err = fmt.Errorf("failed to allocate requested guid %s, already allocated for %s",
 							allocatedGUID, d.guidPodNetworkMap[allocatedGUID])
 						log.Err(err)
+						allocationFailures = append(allocationFailures, podAllocationFailure{pod: pod, err: err})
 						continue
 					}
-				} else if guidErr := d.guidPool.AllocateGUID(allocatedGUID); guidErr != nil {
+				} else if guidErr := guidPool.AllocateGUID(
+					allocationUID, pod.Namespace, networkName, allocatedGUID); guidErr != nil {
 					failedPods = append(failedPods, pod)
-					log.Error().Msgf("failed to allocate GUID for pod ID %s, wit error: %v", pod.UID, err)
+					podLog.Error().Err(guidErr).Str("guid", allocatedGUID).Msg("failed to allocate guid")
+					allocationFailures = append(allocationFailures, podAllocationFailure{pod: pod, err: guidErr})
 					continue
 				} else {
 					d.guidPodNetworkMap[allocatedGUID] = podNetworkID
 				}
 
-				if err = utils.SetPodNetworkGUID(network, allocatedGUID); err != nil {
+				if err = utils.SetPodNetworkGUID(network,
+					utils.FormatGUID(allocatedGUID, d.getConfig().GUIDFormat)); err != nil {
 					failedPods = append(failedPods, pod)
-					log.Error().Msgf("failed to set pod network guid with error: %v ", err)
+					podLog.Error().Err(err).Str("guid", allocatedGUID).Msg("failed to set pod network guid")
 					continue
 				}
 
-				netAnnotations, err := json.Marshal(networks)
-				if err != nil {
-					failedPods = append(failedPods, pod)
-					log.Warn().Msgf("failed to dump networks %+v of pod into json with error: %v",
-						networks, err)
-					continue
-				}
+				netAnnotations, err := json.Marshal(networks)
+				if err != nil {
+					failedPods = append(failedPods, pod)
+					log.Warn().Msgf("failed to dump networks %+v of pod into json with error: %v",
+						networks, err)
+					continue
+				}
+
+				pod.Annotations[v1.NetworkAttachmentAnnot] = string(netAnnotations)
+			}
+
+			// used GUID as net.HardwareAddress to use it in sm plugin which receive n[]et.HardwareAddress as parameter
+			guidList = append(guidList, guidAddr.HardWareAddress())
+			podGUIDs[guidAddr.HardWareAddress().String()] = true
+			guidNetworkMap[guidAddr.HardWareAddress().String()] = network
+			passedPods = append(passedPods, pod)
+		}
+	}
+	d.guidAllocationLock.Unlock()
+	for _, failure := range allocationFailures {
+		d.warnPods([]*kapi.Pod{failure.pod}, guidAllocationFailedReason,
+			fmt.Sprintf("failed to allocate guid of network %s: %v", networkID, failure.err))
+	}
+
+	if ibCniSpec.PKey != "" && len(configuredGUIDs) != 0 {
+		// the pKey is parsed again below, its parse failure is reported once there are guids to add
+		if pKey, err := utils.ParsePKey(ibCniSpec.PKey); err == nil {
+			passedPods, guidList = d.addMissingConfiguredGUIDs(pKey, configuredPods, configuredGUIDs, passedPods,
+				guidList)
+		}
+	}
+
+	if ibCniSpec.PKey != "" && len(guidList) != 0 {
+		pKey, err := utils.ParsePKey(ibCniSpec.PKey)
+		if err != nil {
+			log.Error().Msgf("failed to parse PKey %s with error: %v", ibCniSpec.PKey, err)
+			result.failureReason = reconcileFailurePKey
+			return result
+		}
+		membership, err := plugins.ParseMembership(ibCniSpec.Membership)
+		if err != nil {
+			log.Error().Msgf("failed to parse PKey %s membership with error: %v", ibCniSpec.PKey, err)
+			result.failureReason = reconcileFailurePKey
+			return result
+		}
+
+		if d.getConfig().CheckUserGUIDsInSM && len(userGUIDs) != 0 {
+			passedPods, guidList, failedPods = d.rejectUserGUIDsInUse(guidPool, pKey, userGUIDs, passedPods, guidList,
+				failedPods)
+		}
+
+		if d.getConfig().EnforceNamespaceIsolation {
+			if err = d.checkNamespaceIsolation(pKey, passedPods); err != nil {
+				log.Error().Msgf("failed to add guids to pKey %s with error: %v", ibCniSpec.PKey, err)
+				if errors.Is(err, ErrNamespaceIsolationViolation) {
+					d.warnPods(passedPods, namespaceIsolationReason, err.Error())
+				}
+				result.failureReason = reconcileFailureNamespaceIsolation
+				return result
+			}
+		}
+
+		if d.getConfig().EnablePKeyReservations {
+			passedPods, guidList, failedPods, err = d.limitToPKeyReservations(pKey, passedPods, guidList,
+				failedPods)
+			if err != nil {
+				log.Error().Msgf("failed to check pKey %s reservations with error: %v", ibCniSpec.PKey, err)
+				result.failureReason = reconcileFailurePKeyReservations
+				return result
+			}
+		}
+
+		var capacity int
+		passedPods, guidList, failedPods, capacity, err = d.limitToPKeyCapacity(pKey, passedPods, guidList,
+			failedPods)
+		if err != nil {
+			log.Error().Msgf("failed to check pKey %s capacity with subnet manager %s with error: %v",
+				ibCniSpec.PKey, d.smClient.Name(), err)
+			result.failureReason = reconcileFailurePKeyCapacity
+			return result
+		}
+
+		if len(guidList) != 0 {
+			if !d.acquireSMCallToken(networkNamespace, networkName) {
+				log.Info().Msgf("subnet manager calls of network %s are rate limited, will retry", networkID)
+				return result
+			}
+
+			added, failedIndexes, smErr := d.addGuidsToPKeyInCapacityChunks(pKey, membership, guidList, capacity)
+			if len(failedIndexes) == len(guidList) {
+				log.Error().Msgf("failed to config pKey with subnet manager %s with error: %v",
+					d.smClient.Name(), smErr)
+				d.warnPods(passedPods, subnetManagerErrorReason, fmt.Sprintf(
+					"failed to add guid to pKey %s with subnet manager %s: %v", ibCniSpec.PKey, d.smClient.Name(), smErr))
+				result.smErr = smErr
+				result.failureReason = reconcileFailureSubnetManager
+				return result
+			}
+			if added < len(guidList) {
+				log.Warn().Msgf("pKey 0x%04X has capacity for %d guids out of %d requested, remaining pods will be "+
+					"retried", pKey, added, len(guidList))
+				passedPods, guidList, failedPods, failedIndexes = excludePodsBeyondCapacity(passedPods, guidList,
+					failedPods, failedIndexes, added)
+			}
+			if len(failedIndexes) != 0 {
+				// only the pods of the failed batches are retried
+				log.Error().Msgf("failed to add %d out of %d guids to pKey %s with subnet manager %s with error: %v",
+					len(failedIndexes), len(guidList), ibCniSpec.PKey, d.smClient.Name(), smErr)
+				var batchFailedPods []*kapi.Pod
+				passedPods, guidList, failedPods, batchFailedPods = excludeFailedBatchPods(passedPods, guidList,
+					failedPods, failedIndexes)
+				d.warnPods(batchFailedPods, subnetManagerErrorReason, fmt.Sprintf(
+					"failed to add guid to pKey %s with subnet manager %s: %v", ibCniSpec.PKey, d.smClient.Name(), smErr))
+				result.smErr = smErr
+				result.failureReason = reconcileFailureSubnetManager
+			}
+
+			if d.getConfig().VerifySMAdditions {
+				passedPods, guidList, failedPods = d.verifyPKeyMembership(pKey, passedPods, guidList, failedPods)
+			}
+		}
+	}
+
+	// Update annotations for passed pods, the pods with several interfaces of the network are updated once
+	podGUIDIndexes := map[types.UID][]int{}
+	var annotatedPods []*kapi.Pod
+	for index, pod := range passedPods {
+		if _, exist := podGUIDIndexes[pod.UID]; !exist {
+			annotatedPods = append(annotatedPods, pod)
+		}
+		podGUIDIndexes[pod.UID] = append(podGUIDIndexes[pod.UID], index)
+	}
+
+	var removedGUIDList []net.HardwareAddr
+	for _, pod := range annotatedPods {
+		podLog := podLogger(networkID, pod)
+		indexes := podGUIDIndexes[pod.UID]
+		networks := podNetworksMap[pod.UID]
+		if !d.setPodGUIDsAnnotations(networkID, pod, networks, indexes, guidList, guidNetworkMap) {
+			failedPods = append(failedPods, pod)
+			continue
+		}
+
+		netAnnotations, err := json.Marshal(networks)
+		if err != nil {
+			failedPods = append(failedPods, pod)
+			log.Warn().Msgf("failed to dump networks %+v of pod into json with error: %v", networks, err)
+			continue
+		}
+		pod.Annotations[v1.NetworkAttachmentAnnot] = string(netAnnotations)
+		if d.getConfig().AnnotatePortCapabilities {
+			// the port capabilities annotations are of the first interface
+			d.setPortCapabilitiesAnnotations(pod, guidList[indexes[0]])
+		}
+		if err := k8sClient.SetAnnotationsOnPodWithRetry(d.kubeClient, pod, daemonPodAnnotations(pod),
+			d.getConfig().PodAnnotationRetries); err != nil {
+			if !strings.Contains(strings.ToLower(err.Error()), "not found") {
+				failedPods = append(failedPods, pod)
+				podLog.Error().Err(err).Msg("failed to update pod annotations")
+				continue
+			}
+
+			for _, index := range indexes {
+				if err = d.releasePoolGUID(guidPool, guidList[index].String()); err != nil {
+					podLog.Warn().Err(err).Str("guid", guidList[index].String()).Msg(
+						"failed to release guid of removed pod")
+				}
+
+				removedGUIDList = append(removedGUIDList, guidList[index])
+			}
+			continue
+		}
+
+		if d.getConfig().PodFinalizer && !utils.PodHasGUIDReleaseFinalizer(pod) {
+			// the guids of a pod without finalizer are released only if its deletion isn't missed
+			if err := d.addPodFinalizer(pod); err != nil {
+				podLog.Warn().Err(err).Msg("failed to add guid release finalizer to pod")
+			}
+		}
+		for _, index := range indexes {
+			podLog.Info().Str("guid", guidList[index].String()).Str("pkey", ibCniSpec.PKey).Msg(
+				"configured pod network guid")
+			d.audit(audit.AddRecord, pod, guidList[index], ibCniSpec.PKey)
+			d.addDNSRecord(pod, guidList[index])
+			d.trackIdleGUID(ibCniSpec.PKey, ibCniSpec.Membership, guidList[index])
+			if ibCniSpec.PKey != "" {
+				// the pKey is kept to remove the guid from it if the pod deletion is missed
+				if pKeyErr := guidPool.SetGUIDPKey(guidList[index].String(), ibCniSpec.PKey); pKeyErr != nil {
+					log.Warn().Msgf("failed to record pKey of guid %s with error: %v", guidList[index], pKeyErr)
+				}
+			}
+		}
+		if utils.HasIBReadyGate(pod) {
+			result.readyPods = append(result.readyPods, pod)
+		}
+	}
+
+	if ibCniSpec.PKey != "" && len(removedGUIDList) != 0 {
+		// Already check the parse above
+		pKey, _ := utils.ParsePKey(ibCniSpec.PKey)
+		if _, pkeyErr := d.removeGuidsFromPKeyInBatches(pKey, removedGUIDList); pkeyErr != nil {
+			log.Warn().Msgf("failed to remove guids of removed pods from pKey %s with subnet manager %s with error: %v",
+				ibCniSpec.PKey, d.smClient.Name(), pkeyErr)
+			result.failureReason = reconcileFailureSubnetManager
+			return result
+		}
+	}
+
+	result.processed = true
+	result.failedPods = failedPods
+	if len(failedPods) != 0 && result.failureReason == "" {
+		result.failureReason = reconcileFailurePods
+	}
+	return result
+}
+
+// setPodGUIDsAnnotations marks the pod networks of the guids of the given indexes as configured, and sets their
+// virtual machine and signature annotations. It returns false if the annotations of any of the guids failed.
+func (d *daemon) setPodGUIDsAnnotations(networkID string, pod *kapi.Pod, networks []*v1.NetworkSelectionElement,
+	indexes []int, guidList []net.HardwareAddr, guidNetworkMap map[string]*v1.NetworkSelectionElement) bool {
+	for _, index := range indexes {
+		guidLog := podLogger(networkID, pod).With().Str("guid", guidList[index].String()).Logger()
+		network := guidNetworkMap[guidList[index].String()]
+		utils.SetPodNetworkConfiguredWithInfiniBand(network, d.ibAnnotationKey)
+		// the annotations mapped by network have a key for every interface of the network
+		interfaceKey := utils.GetPodNetworkInterfaceKey(networks, network)
+		if vmiErr := d.vmiAnnotator.SetGUIDAnnotation(pod, interfaceKey, guidList[index].String()); vmiErr != nil {
+			guidLog.Warn().Err(vmiErr).Msg("failed to set virtual machine guid annotation")
+			return false
+		}
+		if signErr := d.signPodNetworkGUID(pod, interfaceKey, guidList[index].String()); signErr != nil {
+			guidLog.Warn().Err(signErr).Msg("failed to sign guid")
+			return false
+		}
+	}
+	return true
+}
+
+// setPortCapabilitiesAnnotations sets the speed and width annotations of the pod InfiniBand port of the guid,
+// the pod is configured without them if the subnet manager doesn't report its port capabilities
+func (d *daemon) setPortCapabilitiesAnnotations(pod *kapi.Pod, guidAddr net.HardwareAddr) {
+	capabilities, err := d.smClient.GetPortCapabilities(context.Background(), guidAddr)
+	if err != nil {
+		log.Warn().Msgf("failed to get port capabilities of guid %s of pod namespace %s name %s with error: %v",
+			guidAddr, pod.Namespace, pod.Name, err)
+		return
+	}
+
+	rate := capabilities.Rate()
+	if rate == "" {
+		log.Debug().Msgf("unknown port capabilities %+v of guid %s", capabilities, guidAddr)
+		return
+	}
+
+	pod.Annotations[utils.PortSpeedAnnotation] = rate
+	pod.Annotations[utils.PortWidthAnnotation] = capabilities.Width
+}
+
+// setIBReadyConditions sets the InfiniBand ready condition of the configured pods, pods with networks which are
+// still pending in the add map aren't ready. The add map lock must be held.
+func (d *daemon) setIBReadyConditions(addMap *utils.SynchronizedMap, readyPods map[types.UID]*kapi.Pod) {
+	if len(readyPods) == 0 {
+		return
+	}
+
+	for _, podsInterface := range addMap.Items {
+		pods, _ := podsInterface.([]*kapi.Pod)
+		for _, pod := range pods {
+			delete(readyPods, pod.UID)
+		}
+	}
+
+	for _, pod := range readyPods {
+		condition := kapi.PodCondition{Type: utils.IBReadyConditionType, Status: kapi.ConditionTrue,
+			LastTransitionTime: metav1.Now(), Reason: "GUIDsConfigured",
+			Message: "InfiniBand guids are configured in the subnet manager"}
+		if err := d.kubeClient.SetPodCondition(pod, condition); err != nil {
+			log.Warn().Msgf("failed to set %s condition of pod namespace %s name %s with error: %v",
+				utils.IBReadyConditionType, pod.Namespace, pod.Name, err)
+		}
+	}
+}
+
+// sortNetworksByPriority returns the network ids of the given map sorted by the configured network priority,
+// networks with the same priority are sorted by network id. Networks without priority get the default priority 0.
+func (d *daemon) sortNetworksByPriority(networks map[string]interface{}) []string {
+	networkIDs := make([]string, 0, len(networks))
+	priorities := make(map[string]int, len(networks))
+	for networkID := range networks {
+		networkIDs = append(networkIDs, networkID)
+		if _, networkName, err := utils.ParseNetworkID(networkID); err == nil {
+			priorities[networkID] = d.getConfig().NetworkPriorities[networkName]
+		}
+	}
+
+	sort.Slice(networkIDs, func(i, j int) bool {
+		if priorities[networkIDs[i]] != priorities[networkIDs[j]] {
+			return priorities[networkIDs[i]] > priorities[networkIDs[j]]
+		}
+		return networkIDs[i] < networkIDs[j]
+	})
+
+	return networkIDs
+}
+
+// getPKeyCapacity returns the number of members of the pKey in the subnet manager and its maximum number of members.
+// The pKey members are counted if the subnet manager fails to report the pKey usage stats.
+func (d *daemon) getPKeyCapacity(pKey int) (int, int, error) {
+	stats, err := d.smClient.GetPKeyUsageStats(context.Background(), pKey)
+	if err != nil {
+		members, membershipErr := d.smClient.GetPKeyMembership(context.Background(), pKey)
+		if membershipErr != nil {
+			return 0, 0, err
+		}
+		log.Warn().Msgf("failed to get pKey 0x%04X usage stats with subnet manager %s, counting its %d members, "+
+			"with error: %v", pKey, d.smClient.Name(), len(members), err)
+		stats = plugins.PKeyStats{PKey: pKey, MemberCount: len(members)}
+	}
+
+	maxMembers := stats.MaxMembers
+	if maxMembers == 0 || maxMembers > d.getConfig().MaxGUIDsPerPKey {
+		maxMembers = d.getConfig().MaxGUIDsPerPKey
+	}
+	return stats.MemberCount, maxMembers, nil
+}
+
+// limitToPKeyCapacity returns the remaining capacity of the pKey in the subnet manager, the number of guids of the
+// first chunk to add to the pKey. The pods are moved to the failed pods to be retried if the pKey is full or its usage
+// is above the block percent.
+func (d *daemon) limitToPKeyCapacity(pKey int, passedPods []*kapi.Pod, guidList []net.HardwareAddr,
+	failedPods []*kapi.Pod) ([]*kapi.Pod, []net.HardwareAddr, []*kapi.Pod, int, error) {
+	memberCount, maxMembers, err := d.getPKeyCapacity(pKey)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+
+	utilization := float64(memberCount) * 100 / float64(maxMembers)
+	metrics.PKeyUtilization.WithLabelValues(fmt.Sprintf("0x%04X", pKey)).Set(utilization)
+	if utilization > float64(d.getConfig().PKeyUsageBlockPercent) {
+		log.Error().Msgf("pKey 0x%04X is %.1f%% full, above %d%%, pods will be retried", pKey, utilization,
+			d.getConfig().PKeyUsageBlockPercent)
+		return nil, nil, append(failedPods, passedPods...), 0, nil
+	}
+
+	remaining := maxMembers - memberCount
+	if remaining <= 0 {
+		log.Warn().Msgf("pKey 0x%04X is full, %d requested guids will be retried", pKey, len(guidList))
+		return nil, nil, append(failedPods, passedPods...), 0, nil
+	}
 
-				pod.Annotations[v1.NetworkAttachmentAnnot] = string(netAnnotations)
-			}
+	added := len(guidList)
+	if added > remaining {
+		added = remaining
+	}
+	usedPercent := (memberCount + added) * 100 / maxMembers
+	if usedPercent > d.getConfig().PKeyUsageWarningPercent {
+		log.Warn().Msgf("pKey 0x%04X is %d%% full, %d guids out of %d", pKey, usedPercent, memberCount+added,
+			maxMembers)
+	}
 
-			// used GUID as net.HardwareAddress to use it in sm plugin which receive n[]et.HardwareAddress as parameter
-			guidList = append(guidList, guidAddr.HardWareAddress())
-			passedPods = append(passedPods, pod)
-		}
+	return passedPods, guidList, failedPods, remaining, nil
+}
 
-		if ibCniSpec.PKey != "" && len(guidList) != 0 {
-			pKey, err := utils.ParsePKey(ibCniSpec.PKey)
-			if err != nil {
-				log.Error().Msgf("failed to parse PKey %s with error: %v", ibCniSpec.PKey, err)
-				continue
-			}
+// verifyPKeyMembership checks that the given guids are members of the pKey in the subnet manager.
+// Pods with guids missing from the pKey are moved to the failed pods to be retried.
+// The guids aren't verified if the subnet manager plugin can't report the pKey members.
+func (d *daemon) verifyPKeyMembership(pKey int, passedPods []*kapi.Pod, guidList []net.HardwareAddr,
+	failedPods []*kapi.Pod) ([]*kapi.Pod, []net.HardwareAddr, []*kapi.Pod) {
+	members, err := d.smClient.GetPKeyMembership(context.Background(), pKey)
+	if errors.Is(err, plugins.ErrMembershipNotSupported) {
+		log.Debug().Msgf("skipping verification of pKey 0x%04X members, subnet manager %s can't report them",
+			pKey, d.smClient.Name())
+		return passedPods, guidList, failedPods
+	}
+	if err != nil {
+		log.Error().Msgf("failed to verify pKey 0x%04X members with subnet manager %s with error: %v",
+			pKey, d.smClient.Name(), err)
+		return nil, nil, append(failedPods, passedPods...)
+	}
 
-			if err = d.smClient.AddGuidsToPKey(pKey, guidList); err != nil {
-				log.Error().Msgf("failed to config pKey with subnet manager %s with error: %v",
-					d.smClient.Name(), err)
-				continue
-			}
+	membersSet := make(map[string]bool, len(members))
+	for _, member := range members {
+		membersSet[member.String()] = true
+	}
+
+	var verifiedPods []*kapi.Pod
+	var verifiedGUIDs []net.HardwareAddr
+	for index, pod := range passedPods {
+		if !membersSet[guidList[index].String()] {
+			log.Error().Msgf("guid %s of pod %s in namespace %s is missing from pKey 0x%04X in subnet manager %s",
+				guidList[index], pod.Name, pod.Namespace, pKey, d.smClient.Name())
+			metrics.SMAddVerificationFailures.Inc()
+			failedPods = append(failedPods, pod)
+			continue
 		}
+		verifiedPods = append(verifiedPods, pod)
+		verifiedGUIDs = append(verifiedGUIDs, guidList[index])
+	}
+
+	return verifiedPods, verifiedGUIDs, failedPods
+}
 
-		// Update annotations for passed pods
-		var removedGUIDList []net.HardwareAddr
-		for index, pod := range passedPods {
-			network := podNetworkMap[pod.UID]
-			(*network.CNIArgs)[utils.InfiniBandAnnotation] = utils.ConfiguredInfiniBandPod
+// checkNamespaceIsolation returns error wrapping ErrNamespaceIsolationViolation if the pods and the pods of the
+// pKey members in the subnet manager aren't all of the same namespace
+func (d *daemon) checkNamespaceIsolation(pKey int, pods []*kapi.Pod) error {
+	namespaces := map[string]bool{}
+	for _, pod := range pods {
+		namespaces[pod.Namespace] = true
+	}
 
-			networks := podNetworksMap[pod.UID]
-			netAnnotations, err := json.Marshal(networks)
-			if err != nil {
-				failedPods = append(failedPods, pod)
-				log.Warn().Msgf("failed to dump networks %+v of pod into json with error: %v", networks, err)
-				continue
-			}
-			pod.Annotations[v1.NetworkAttachmentAnnot] = string(netAnnotations)
-			if err := d.kubeClient.SetAnnotationsOnPod(pod, pod.Annotations); err != nil {
-				if !strings.Contains(strings.ToLower(err.Error()), "not found") {
-					failedPods = append(failedPods, pod)
-					log.Error().Msgf("failed to update pod annotations with err: %v", err)
-					continue
-				}
+	// the pods are checked only against each other if the subnet manager plugin can't report the pKey members
+	members, err := d.smClient.GetPKeyMembership(context.Background(), pKey)
+	if err != nil && !errors.Is(err, plugins.ErrMembershipNotSupported) {
+		return fmt.Errorf("failed to get pKey 0x%04X members with subnet manager %s with error: %v",
+			pKey, d.smClient.Name(), err)
+	}
 
-				if err = d.guidPool.ReleaseGUID(guidList[index].String()); err != nil {
-					log.Warn().Msgf("failed to release guid \"%s\" from removed pod \"%s\" in namespace "+
-						"\"%s\" with error: %v", guidList[index].String(), pod.Name, pod.Namespace, err)
-				} else {
-					delete(d.guidPodNetworkMap, guidList[index].String())
-				}
+	for _, member := range members {
+		if namespace, ok := d.getGUIDNamespace(member.String()); ok {
+			namespaces[namespace] = true
+		}
+	}
 
-				removedGUIDList = append(removedGUIDList, guidList[index])
-			}
+	if len(namespaces) > 1 {
+		sortedNamespaces := make([]string, 0, len(namespaces))
+		for namespace := range namespaces {
+			sortedNamespaces = append(sortedNamespaces, namespace)
 		}
+		sort.Strings(sortedNamespaces)
+		return fmt.Errorf("%w: pKey 0x%04X would be shared by pods of namespaces %s", ErrNamespaceIsolationViolation,
+			pKey, strings.Join(sortedNamespaces, ", "))
+	}
 
-		if ibCniSpec.PKey != "" && len(removedGUIDList) != 0 {
-			// Already check the parse above
-			pKey, _ := utils.ParsePKey(ibCniSpec.PKey)
-			if pkeyErr := d.smClient.RemoveGuidsFromPKey(pKey, removedGUIDList); pkeyErr != nil {
-				log.Warn().Msgf("failed to remove guids of removed pods from pKey %s with subnet manager %s with error: %v",
-					ibCniSpec.PKey, d.smClient.Name(), pkeyErr)
-				continue
-			}
+	return nil
+}
+
+// getGUIDNamespace returns the namespace of the pod which the guid is allocated for in the guid pools
+func (d *daemon) getGUIDNamespace(guidAddr string) (string, bool) {
+	if namespace, ok := d.guidPool.GetGUIDNamespace(guidAddr); ok {
+		return namespace, true
+	}
+
+	d.nadGUIDPools.RLock()
+	defer d.nadGUIDPools.RUnlock()
+	for _, nadGUIDPool := range d.nadGUIDPools.Items {
+		if namespace, ok := nadGUIDPool.(guid.Pool).GetGUIDNamespace(guidAddr); ok {
+			return namespace, true
 		}
+	}
+	return "", false
+}
 
-		if len(failedPods) == 0 {
-			addMap.UnSafeRemove(networkID)
-		} else {
-			addMap.UnSafeSet(networkID, failedPods)
+// warnPods creates warning event with the given reason and message on every pod
+func (d *daemon) warnPods(pods []*kapi.Pod, reason, message string) {
+	for _, pod := range pods {
+		if err := d.kubeClient.CreatePodEvent(pod, kapi.EventTypeWarning, reason, message); err != nil {
+			log.Warn().Msgf("failed to create %s event of pod namespace %s name %s with error: %v",
+				reason, pod.Namespace, pod.Name, err)
 		}
 	}
-	log.Info().Msg("add periodic update finished")
 }
 
 func (d *daemon) DeletePeriodicUpdate() {
 	log.Info().Msg("running delete periodic update")
+	defer d.updateTimes.deleteDone()
 	_, deleteMap := d.watcher.GetHandler().GetResults()
 	deleteMap.Lock()
 	defer deleteMap.Unlock()
+	if d.namespaceWatcher != nil {
+		d.releaseDeletedNamespaces(deleteMap)
+	}
+	d.retryPodFinalizers()
+
+	var removals []*networkGUIDsRemoval
 	for networkID, podsInterface := range deleteMap.Items {
+		start := time.Now()
 		log.Info().Msgf("processing network with networkID %s", networkID)
 		networkNamespace, networkName, err := utils.ParseNetworkID(networkID)
 		if err != nil {
@@ -357,105 +1691,554 @@ func (d *daemon) DeletePeriodicUpdate() {
 		}
 
 		netAttInfo, err := d.kubeClient.GetNetworkAttachmentDefinition(networkNamespace, networkName)
-		if err != nil {
-			log.Warn().Msgf("failed to get networkName attachment %s with error: %v", networkName, err)
-			// skip failed networks
+		if apiErrors.IsNotFound(err) {
+			d.releaseDeletedNetworkGUIDs(deleteMap, networkID, networkName, pods)
 			continue
 		}
-		log.Debug().Msgf("networkName attachment %v", netAttInfo)
-
-		networkSpec := make(map[string]interface{})
-		err = json.Unmarshal([]byte(netAttInfo.Spec.Config), &networkSpec)
 		if err != nil {
-			log.Warn().Msgf("failed to parse networkName attachment %s with error: %v", networkName, err)
+			log.Warn().Msgf("failed to get networkName attachment %s with error: %v", networkName, err)
 			// skip failed networks
 			continue
 		}
-		log.Debug().Msgf("networkName attachment spec %+v", networkSpec)
+		log.Debug().Msgf("networkName attachment %v", netAttInfo)
 
-		ibCniSpec, err := utils.GetIbSriovCniFromNetwork(networkSpec)
+		ibCniSpec, err := d.getIbSriovCniSpec(networkNamespace, networkName, netAttInfo)
 		if err != nil {
-			log.Warn().Msgf("failed to get InfiniBand SR-IOV CNI spec from network attachment %+v, with error: %v",
-				networkSpec, err)
+			log.Warn().Msgf("failed to get InfiniBand SR-IOV CNI spec with error: %v", err)
 			// skip failed networks
 			continue
 		}
+		ibCniSpec.PKey = d.getNetworkPKey(networkID, ibCniSpec)
 		log.Debug().Msgf("CNI spec %+v", ibCniSpec)
 
 		var guidList []net.HardwareAddr
+		var guidPods []*kapi.Pod
 		var failedPods []*kapi.Pod
+		processedPods := map[types.UID]bool{}
 		for _, pod := range pods {
-			log.Debug().Msgf("pod namespace %s name %s", pod.Namespace, pod.Name)
+			// the pods are listed once for every interface of the network, all the interfaces are processed at once
+			if processedPods[pod.UID] {
+				continue
+			}
+			processedPods[pod.UID] = true
+
+			podLog := podLogger(networkID, pod)
+			podLog.Debug().Msg("processing deleted pod")
 			networks, netErr := netAttUtils.ParsePodNetworkAnnotation(pod)
 			if netErr != nil {
-				failedPods = append(failedPods, pod)
-				log.Error().Msgf("failed to read pod networkName annotations pod namespace %s name %s, with error: %v",
-					pod.Namespace, pod.Name, netErr)
+				podLog.Error().Err(netErr).Msg("failed to read pod networks annotation")
+				d.releasePodGUIDs(pod)
 				continue
 			}
 
-			network, netErr := utils.GetPodNetwork(networks, networkName)
+			podNetworks, netErr := utils.GetPodNetworks(networks, networkName)
 			if netErr != nil {
 				failedPods = append(failedPods, pod)
-				log.Error().Msgf("failed to get pod networkName spec %s with error: %v", networkName, netErr)
+				podLog.Error().Err(netErr).Msg("failed to get pod network spec")
 				// skip failed pod
 				continue
 			}
 
-			if !utils.IsPodNetworkConfiguredWithInfiniBand(network) {
-				log.Warn().Msgf("network %+v is not InfiniBand configured", network)
-				continue
-			}
-
-			allocatedGUID, netErr := utils.GetPodNetworkGUID(network)
+			podGUIDs, netErr := getConfiguredPodNetworksGUIDs(podNetworks, d.ibAnnotationKey)
 			if netErr != nil {
 				failedPods = append(failedPods, pod)
-				log.Err(netErr)
+				podLog.Error().Err(netErr).Msg("failed to get pod network guid")
 				continue
 			}
 
-			guidAddr, guidErr := net.ParseMAC(allocatedGUID)
-			if guidErr != nil {
-				failedPods = append(failedPods, pod)
-				log.Error().Msgf("failed to parse allocated pod with error: %v", guidErr)
+			podGUIDs = d.skipReleasedGUIDs(d.vmiAnnotator.GetAllocationUID(pod), podGUIDs)
+			// the guid of a tampered annotation may be of another pod, so it isn't removed from the pKey
+			if len(podGUIDs) == 0 || !d.verifyPodGUIDs(pod) {
 				continue
 			}
-			guidList = append(guidList, guidAddr)
+
+			for _, guidAddr := range podGUIDs {
+				guidList = append(guidList, guidAddr)
+				guidPods = append(guidPods, pod)
+			}
 		}
 
+		removal := &networkGUIDsRemoval{networkID: networkID, pKeyName: ibCniSpec.PKey, guidList: guidList,
+			guidPods: guidPods, failedPods: failedPods, pods: pods}
 		if ibCniSpec.PKey != "" && len(guidList) != 0 {
 			pKey, pkeyErr := utils.ParsePKey(ibCniSpec.PKey)
 			if pkeyErr != nil {
 				log.Error().Msgf("failed to parse PKey %s with error: %v", ibCniSpec.PKey, pkeyErr)
+				recordNetworkReconcile(networkID, time.Since(start), reconcileFailurePKey)
 				continue
 			}
+			removal.pKey = pKey
+			removal.hasPKey = true
+		}
+		removal.duration = time.Since(start)
+		removals = append(removals, removal)
+	}
 
-			if pkeyErr = d.smClient.RemoveGuidsFromPKey(pKey, guidList); pkeyErr != nil {
-				log.Error().Msgf("failed to config pKey with subnet manager %s with error: %v",
-					d.smClient.Name(), pkeyErr)
-				continue
+	// pods whose guids failed to be removed from the subnet manager are retried in the next update
+	failedGUIDs := d.removeGuidsFromPKeys(removals)
+	for _, removal := range removals {
+		// the guids of a pod are released once all of them are removed, so a retry never removes released guids
+		smFailedPods := map[types.UID]bool{}
+		var smFailedPodList []*kapi.Pod
+		for index, guidAddr := range removal.guidList {
+			pod := removal.guidPods[index]
+			if failedGUIDs[guidAddr.String()] && !smFailedPods[pod.UID] {
+				smFailedPods[pod.UID] = true
+				smFailedPodList = append(smFailedPodList, pod)
 			}
 		}
 
-		for _, guidAddr := range guidList {
-			if err = d.guidPool.ReleaseGUID(guidAddr.String()); err != nil {
+		guidPool := d.getNetworkGUIDPool(removal.networkID)
+		for index, guidAddr := range removal.guidList {
+			if smFailedPods[removal.guidPods[index].UID] {
+				continue
+			}
+			if err := d.releasePoolGUID(guidPool, guidAddr.String()); err != nil {
 				log.Err(err)
 				continue
 			}
 
-			delete(d.guidPodNetworkMap, guidAddr.String())
+			d.audit(audit.DeleteRecord, removal.guidPods[index], guidAddr, removal.pKeyName)
+			d.removeDNSRecord(guidAddr)
+			d.untrackIdleGUID(guidAddr)
 		}
-		if len(failedPods) == 0 {
-			deleteMap.UnSafeRemove(networkID)
+		// the pods whose guids failed to be removed from the subnet manager are kept until the retry
+		d.releasePodFinalizers(removal.pods, smFailedPods)
+		if len(smFailedPodList) != 0 {
+			recordNetworkReconcile(removal.networkID, removal.duration, reconcileFailureSubnetManager)
+			deleteMap.UnSafeSet(removal.networkID, append(removal.failedPods, smFailedPodList...))
+		} else if len(removal.failedPods) == 0 {
+			recordNetworkReconcile(removal.networkID, removal.duration, "")
+			deleteMap.UnSafeRemove(removal.networkID)
 		} else {
-			deleteMap.UnSafeSet(networkID, failedPods)
+			recordNetworkReconcile(removal.networkID, removal.duration, reconcileFailurePods)
+			deleteMap.UnSafeSet(removal.networkID, removal.failedPods)
 		}
 	}
 
+	if d.getConfig().EnablePKeyReservations {
+		d.updatePKeyReservationsStatus()
+	}
+	d.checkGUIDPoolFragmentation()
+	d.flushDNSRecords()
+	if d.poolPersistence != nil {
+		d.persistGUIDPool()
+	}
 	log.Info().Msg("delete periodic update finished")
 }
 
-//  initPool check the guids that are already allocated by the running pods
+// getConfiguredPodNetworksGUIDs returns the guids of the pod networks configured with InfiniBand with the given
+// annotation key
+func getConfiguredPodNetworksGUIDs(podNetworks []*v1.NetworkSelectionElement, annotationKey string) (
+	[]net.HardwareAddr, error) {
+	var guids []net.HardwareAddr
+	for _, network := range podNetworks {
+		if !utils.IsPodNetworkConfiguredWithInfiniBand(network, annotationKey) {
+			log.Warn().Msgf("network %+v is not InfiniBand configured", network)
+			continue
+		}
+
+		allocatedGUID, err := utils.GetPodNetworkGUID(network)
+		if err != nil {
+			return nil, err
+		}
+
+		guidAddr, err := net.ParseMAC(allocatedGUID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse allocated guid %s: %v", allocatedGUID, err)
+		}
+		guids = append(guids, guidAddr)
+	}
+	return guids, nil
+}
+
+// removeGuidsFromPKeys removes the guids of all the networks from their pKeys in the subnet manager.
+// Guids of multiple pKeys are removed in one bulk operation, unless they are more than the subnet manager batch size,
+// otherwise with single pKey removes in batches. It returns the guids which failed to be removed.
+func (d *daemon) removeGuidsFromPKeys(removals []*networkGUIDsRemoval) map[string]bool {
+	requests := map[int][]net.HardwareAddr{}
+	var guidCount int
+	for _, removal := range removals {
+		if removal.hasPKey {
+			requests[removal.pKey] = append(requests[removal.pKey], removal.guidList...)
+			guidCount += len(removal.guidList)
+		}
+	}
+
+	failedGUIDs := map[string]bool{}
+	batchSize := d.getConfig().SMMaxBatchSize
+	if len(requests) == 1 || (batchSize > 0 && guidCount > batchSize) {
+		for pKey, guids := range requests {
+			failedIndexes, err := d.removeGuidsFromPKeyInBatches(pKey, guids)
+			if err != nil {
+				log.Error().Msgf("failed to remove %d out of %d guids from pKey 0x%04X with subnet manager %s "+
+					"with error: %v", len(failedIndexes), len(guids), pKey, d.smClient.Name(), err)
+			}
+			for index := range failedIndexes {
+				failedGUIDs[guids[index].String()] = true
+			}
+		}
+	} else if len(requests) > 1 {
+		if err := d.smClient.BulkRemoveGuidsFromPKeys(context.Background(), requests); err != nil {
+			log.Error().Msgf("failed to remove guids from %d pKeys with subnet manager %s with error: %v",
+				len(requests), d.smClient.Name(), err)
+			for _, guids := range requests {
+				for _, guidAddr := range guids {
+					failedGUIDs[guidAddr.String()] = true
+				}
+			}
+		}
+	}
+
+	return failedGUIDs
+}
+
+// checkGUIDPoolFragmentation updates the guid pool usage and fragmentation metrics and warns if the pool is too
+// fragmented
+func (d *daemon) checkGUIDPoolFragmentation() {
+	stats := d.guidPool.Stats()
+	metrics.GUIDPoolAllocated.Set(float64(stats.Allocated))
+	metrics.GUIDPoolAvailable.Set(float64(stats.Available))
+	score := d.guidPool.FragmentationScore()
+	metrics.GUIDPoolFragmentation.Set(score)
+	if score > fragmentationWarningScore {
+		log.Warn().Msgf("guid pool is fragmented, fragmentation score %.2f", score)
+	}
+}
+
+// getGUIDPools returns the guid pool and the network attachment definitions guid pools
+func (d *daemon) getGUIDPools() []guid.Pool {
+	guidPools := []guid.Pool{d.guidPool}
+	d.nadGUIDPools.RLock()
+	defer d.nadGUIDPools.RUnlock()
+	for _, nadGUIDPool := range d.nadGUIDPools.Items {
+		guidPools = append(guidPools, nadGUIDPool.(guid.Pool))
+	}
+	return guidPools
+}
+
+// getNetworkGUIDPool returns the guid pool of the network attachment definition guid range if allocated,
+// otherwise the global guid pool
+func (d *daemon) getNetworkGUIDPool(networkID string) guid.Pool {
+	if nadGUIDPool, ok := d.nadGUIDPools.Get(networkID); ok {
+		return nadGUIDPool.(guid.Pool)
+	}
+	return d.guidPool
+}
+
+// releasePoolGUID releases the guid from the guid pool and removes it from guidPodNetworkMap
+func (d *daemon) releasePoolGUID(guidPool guid.Pool, guidAddr string) error {
+	d.guidAllocationLock.Lock()
+	defer d.guidAllocationLock.Unlock()
+	if err := guidPool.ReleaseGUID(guidAddr); err != nil {
+		return err
+	}
+
+	delete(d.guidPodNetworkMap, guidAddr)
+	return nil
+}
+
+// NADPeriodicUpdate allocates guid ranges for the added InfiniBand network attachment definitions
+// and releases the guid ranges of the deleted ones
+func (d *daemon) NADPeriodicUpdate() {
+	log.Info().Msg("running network attachment definitions periodic update")
+	addMap, deleteMap := d.nadWatcher.GetHandler().GetResults()
+
+	// release the guid ranges of the deleted network attachment definitions whose guids are all released
+	d.drainingNADs.Lock()
+	drainingNADs := make(map[string]interface{}, len(d.drainingNADs.Items))
+	for networkID, netAttDef := range d.drainingNADs.Items {
+		drainingNADs[networkID] = netAttDef
+	}
+	d.drainingNADs.Unlock()
+	for networkID, netAttDef := range drainingNADs {
+		d.releaseNADGUIDRange(networkID, netAttDef.(*v1.NetworkAttachmentDefinition))
+	}
+
+	// release the deleted first, a network attachment definition may be deleted and created again
+	deleteMap.Lock()
+	for networkID, netAttDefInterface := range deleteMap.Items {
+		netAttDef, ok := netAttDefInterface.(*v1.NetworkAttachmentDefinition)
+		if ok {
+			d.releaseNADGUIDRange(networkID, netAttDef)
+			if d.pKeyPool != nil {
+				d.releaseNetworkPKey(networkID)
+			}
+		} else {
+			log.Error().Msgf("invalid value for delete map network expected \"*NetworkAttachmentDefinition\", found %T",
+				netAttDefInterface)
+		}
+		deleteMap.UnSafeRemove(networkID)
+	}
+	deleteMap.Unlock()
+
+	addMap.Lock()
+	defer addMap.Unlock()
+	for networkID, netAttDefInterface := range addMap.Items {
+		netAttDef, ok := netAttDefInterface.(*v1.NetworkAttachmentDefinition)
+		if !ok {
+			log.Error().Msgf("invalid value for add map network expected \"*NetworkAttachmentDefinition\", found %T",
+				netAttDefInterface)
+			addMap.UnSafeRemove(networkID)
+			continue
+		}
+
+		if _, draining := d.drainingNADs.Get(networkID); draining {
+			// the network attachment definition was created again, retry once the deleted one guid range is released
+			log.Info().Msgf("guid range of deleted network attachment %s is still draining, will retry", networkID)
+			continue
+		}
+
+		if _, exist := d.nadGUIDPools.Get(networkID); exist {
+			// guid range is already allocated, e.g restored on startup
+			addMap.UnSafeRemove(networkID)
+			continue
+		}
+
+		var err error
+		if _, _, hasRange := utils.GetNetworkAttachmentDefinitionGUIDRange(netAttDef); hasRange {
+			err = d.restoreNADGUIDPool(networkID, netAttDef)
+		} else {
+			err = d.allocateNADGUIDRange(networkID, netAttDef)
+		}
+		if err != nil {
+			// retry in the next update
+			log.Error().Msgf("failed to set guid range of network attachment %s with error: %v", networkID, err)
+			continue
+		}
+		addMap.UnSafeRemove(networkID)
+	}
+	log.Info().Msg("network attachment definitions periodic update finished")
+}
+
+// allocateNADGUIDRange allocates a guid range from the global pool for the network attachment definition
+// and annotates the network attachment definition with it
+func (d *daemon) allocateNADGUIDRange(networkID string, netAttDef *v1.NetworkAttachmentDefinition) error {
+	rangeStart, rangeEnd, err := d.guidPool.AllocateGUIDRange(netAttDef.UID, netAttDef.Name,
+		d.getConfig().NADGUIDRangeSize)
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{
+		utils.GUIDRangeStartAnnotation: rangeStart.String(),
+		utils.GUIDRangeEndAnnotation:   rangeEnd.String(),
+	}
+	if err = d.kubeClient.SetAnnotationsOnNetworkAttachmentDefinition(netAttDef, annotations); err != nil {
+		if _, releaseErr := d.guidPool.ReleaseGUIDByPodUID(netAttDef.UID); releaseErr != nil {
+			log.Warn().Msgf("failed to release guid range of network attachment %s with error: %v",
+				networkID, releaseErr)
+		}
+		return fmt.Errorf("failed to annotate network attachment with guid range: %v", err)
+	}
+
+	log.Info().Msgf("allocated guid range %s - %s for network attachment %s", rangeStart, rangeEnd, networkID)
+	return d.createNADGUIDPool(networkID, rangeStart, rangeEnd)
+}
+
+// restoreNADGUIDPool reserves the annotated guid range of the network attachment definition in the global pool
+// and creates the network attachment definition guid pool
+func (d *daemon) restoreNADGUIDPool(networkID string, netAttDef *v1.NetworkAttachmentDefinition) error {
+	rangeStartAnnotation, rangeEndAnnotation, _ := utils.GetNetworkAttachmentDefinitionGUIDRange(netAttDef)
+	rangeStart, err := guid.ParseGUID(rangeStartAnnotation)
+	if err != nil {
+		return fmt.Errorf("failed to parse guid range start %s: %v", rangeStartAnnotation, err)
+	}
+	rangeEnd, err := guid.ParseGUID(rangeEndAnnotation)
+	if err != nil {
+		return fmt.Errorf("failed to parse guid range end %s: %v", rangeEndAnnotation, err)
+	}
+	if rangeStart > rangeEnd {
+		return fmt.Errorf("invalid guid range %s - %s", rangeStart, rangeEnd)
+	}
+
+	for guidAddr := rangeStart; guidAddr <= rangeEnd; guidAddr++ {
+		// guid ranges aren't counted in the namespaces usage
+		if err = d.guidPool.AllocateGUID(netAttDef.UID, "", netAttDef.Name, guidAddr.String()); err != nil {
+			// release the partially reserved range
+			_, _ = d.guidPool.ReleaseGUIDByPodUID(netAttDef.UID)
+			return fmt.Errorf("failed to reserve guid range %s - %s: %v", rangeStart, rangeEnd, err)
+		}
+	}
+
+	log.Info().Msgf("restored guid range %s - %s of network attachment %s", rangeStart, rangeEnd, networkID)
+	return d.createNADGUIDPool(networkID, rangeStart, rangeEnd)
+}
+
+// createNADGUIDPool creates the guid pool of the network attachment definition guid range
+func (d *daemon) createNADGUIDPool(networkID string, rangeStart, rangeEnd guid.GUID) error {
+	nadGUIDPool, err := guid.NewPool(&config.GUIDPoolConfig{RangeStart: rangeStart.String(),
+		RangeEnd: rangeEnd.String(), AllocationStrategy: d.getConfig().GUIDPool.AllocationStrategy})
+	if err != nil {
+		return err
+	}
+
+	d.nadGUIDPools.Set(networkID, nadGUIDPool)
+	return nil
+}
+
+// releaseNADGUIDRange releases the guid range of the deleted network attachment definition to the global pool.
+// The range is kept draining, without new allocations, while guids allocated from it aren't released yet.
+func (d *daemon) releaseNADGUIDRange(networkID string, netAttDef *v1.NetworkAttachmentDefinition) {
+	d.guidAllocationLock.Lock()
+	defer d.guidAllocationLock.Unlock()
+	if nadGUIDPool, ok := d.nadGUIDPools.Get(networkID); ok {
+		if allocations := len(nadGUIDPool.(guid.Pool).GetAllocations()); allocations != 0 {
+			if _, draining := d.drainingNADs.Get(networkID); !draining {
+				log.Info().Msgf("keeping guid range of deleted network attachment %s until its %d allocated guids "+
+					"are released", networkID, allocations)
+				d.drainingNADs.Set(networkID, netAttDef)
+			}
+			return
+		}
+	}
+
+	d.drainingNADs.Remove(networkID)
+	d.nadGUIDPools.Remove(networkID)
+	releasedGUIDs, err := d.guidPool.ReleaseGUIDByPodUID(netAttDef.UID)
+	if err != nil {
+		log.Warn().Msgf("failed to release guid range of network attachment %s with error: %v", networkID, err)
+		return
+	}
+	log.Info().Msgf("released %d guids of network attachment %s", len(releasedGUIDs), networkID)
+}
+
+// initNADGUIDPools restores the guid pools of the network attachment definitions with allocated guid range
+func (d *daemon) initNADGUIDPools() error {
+	log.Info().Msg("Initializing network attachment definitions GUID pools.")
+	netAttDefs, err := d.kubeClient.GetNetworkAttachmentDefinitions(kapi.NamespaceAll)
+	if err != nil {
+		err = fmt.Errorf("failed to get network attachment definitions from kubernetes: %v", err)
+		log.Err(err)
+		return err
+	}
+
+	for index := range netAttDefs.Items {
+		netAttDef := &netAttDefs.Items[index]
+		if _, _, ok := utils.GetNetworkAttachmentDefinitionGUIDRange(netAttDef); !ok {
+			continue
+		}
+
+		networkID := utils.GenerateNetAttDefNetworkID(netAttDef)
+		if err = d.restoreNADGUIDPool(networkID, netAttDef); err != nil {
+			log.Error().Msgf("failed to restore guid range of network attachment %s with error: %v", networkID, err)
+		}
+	}
+
+	return nil
+}
+
+// audit sends audit record of the pod guid change if auditing is enabled
+func (d *daemon) audit(recordType string, pod *kapi.Pod, guidAddr net.HardwareAddr, pKey string) {
+	if d.auditor == nil {
+		return
+	}
+
+	d.auditor.Audit(&audit.Record{
+		Type:      recordType,
+		Pod:       pod.Namespace + "/" + pod.Name,
+		GUID:      guidAddr.String(),
+		PKey:      pKey,
+		Timestamp: time.Now(),
+	})
+}
+
+// addDNSRecord adds the dns record of the pod guid if dns export is enabled
+func (d *daemon) addDNSRecord(pod *kapi.Pod, guidAddr net.HardwareAddr) {
+	if d.dnsExporter == nil {
+		return
+	}
+
+	d.dnsExporter.AddRecord(guidAddr, pod.Namespace, pod.Name)
+}
+
+// removeDNSRecord removes the dns record of the guid if dns export is enabled
+func (d *daemon) removeDNSRecord(guidAddr net.HardwareAddr) {
+	if d.dnsExporter == nil {
+		return
+	}
+
+	d.dnsExporter.RemoveRecord(guidAddr)
+}
+
+// flushDNSRecords requests writing the changed dns records if dns export is enabled
+func (d *daemon) flushDNSRecords() {
+	if d.dnsExporter == nil {
+		return
+	}
+
+	d.dnsExporter.Flush()
+}
+
+// releasePodGUIDs releases all the guids allocated for the pod regardless of its networks,
+// used as a fallback when the pod networks can't be read to prevent leaking guids from the pool.
+func (d *daemon) releasePodGUIDs(pod *kapi.Pod) {
+	allocationUID := d.vmiAnnotator.GetAllocationUID(pod)
+	d.guidAllocationLock.Lock()
+	releasedGUIDs, err := d.guidPool.ReleaseGUIDByPodUID(allocationUID)
+	d.nadGUIDPools.RLock()
+	for _, nadGUIDPool := range d.nadGUIDPools.Items {
+		if nadReleasedGUIDs, nadErr := nadGUIDPool.(guid.Pool).ReleaseGUIDByPodUID(allocationUID); nadErr == nil {
+			releasedGUIDs = append(releasedGUIDs, nadReleasedGUIDs...)
+		}
+	}
+	d.nadGUIDPools.RUnlock()
+	for _, releasedGUID := range releasedGUIDs {
+		delete(d.guidPodNetworkMap, releasedGUID)
+	}
+	d.guidAllocationLock.Unlock()
+
+	if len(releasedGUIDs) == 0 {
+		log.Warn().Msgf("failed to release guids of pod namespace %s name %s with error: %v",
+			pod.Namespace, pod.Name, err)
+		return
+	}
+
+	for _, releasedGUID := range releasedGUIDs {
+		if guidAddr, parseErr := net.ParseMAC(releasedGUID); parseErr == nil {
+			d.removeDNSRecord(guidAddr)
+			d.untrackIdleGUID(guidAddr)
+		}
+	}
+	log.Info().Msgf("released guids %v of pod namespace %s name %s", releasedGUIDs, pod.Namespace, pod.Name)
+}
+
+// checkQuotaNamespaces warns about guid quotas of namespaces without InfiniBand networks, which are likely typos
+func (d *daemon) checkQuotaNamespaces() {
+	quotas := d.getConfig().NamespaceGUIDQuotas
+	if len(quotas) == 0 {
+		return
+	}
+
+	namespaces, err := d.kubeClient.ListNamespacesWithIBNetworks()
+	if err != nil {
+		log.Warn().Msgf("failed to list namespaces with InfiniBand networks: %v", err)
+		return
+	}
+
+	managed := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		managed[namespace] = true
+	}
+
+	for namespace := range quotas {
+		if !managed[namespace] {
+			log.Warn().Msgf("guid quota is set for namespace %s which has no InfiniBand networks", namespace)
+		}
+	}
+}
+
+// updateManagedNamespaces refreshes the namespaces with InfiniBand networks and their metric
+func (d *daemon) updateManagedNamespaces() {
+	namespaces, err := d.kubeClient.ListNamespacesWithIBNetworks()
+	if err != nil {
+		log.Warn().Msgf("failed to list namespaces with InfiniBand networks: %v", err)
+		return
+	}
+	log.Debug().Msgf("InfiniBand networks namespaces: %v", namespaces)
+}
+
+// initPool check the guids that are already allocated by the running pods
 func (d *daemon) initPool() error {
 	log.Info().Msg("Initializing GUID pool.")
 	pods, err := d.kubeClient.GetPods(kapi.NamespaceAll)
@@ -465,6 +2248,7 @@ func (d *daemon) initPool() error {
 		return err
 	}
 
+	d.guidAllocationLock.Lock()
 	for index := range pods.Items {
 		log.Debug().Msgf("checking pod for network annotations %v", pods.Items[index])
 		pod := pods.Items[index]
@@ -474,7 +2258,7 @@ func (d *daemon) initPool() error {
 		}
 
 		for _, network := range networks {
-			if !utils.IsPodNetworkConfiguredWithInfiniBand(network) {
+			if !utils.IsPodNetworkConfiguredWithInfiniBand(network, d.ibAnnotationKey) {
 				continue
 			}
 
@@ -482,24 +2266,41 @@ func (d *daemon) initPool() error {
 			if err != nil {
 				continue
 			}
-			podNetworkID := string(pod.UID) + network.Name
+			allocationUID := d.vmiAnnotator.GetAllocationUID(&pod)
+			podNetworkID := string(allocationUID) + network.Name
 			if _, exist := d.guidPodNetworkMap[podGUID]; exist {
 				if podNetworkID != d.guidPodNetworkMap[podGUID] {
-					return fmt.Errorf("failed to allocate requested guid %s, already allocated for %s",
+					err = fmt.Errorf("failed to allocate requested guid %s, already allocated for %s",
 						podGUID, d.guidPodNetworkMap[podGUID])
+					d.guidAllocationLock.Unlock()
+					return err
 				}
 				continue
 			}
 
-			if err = d.guidPool.AllocateGUID(podGUID); err != nil {
+			guidPool := d.getNetworkGUIDPool(utils.GenerateNetworkID(network))
+			if err = guidPool.AllocateGUID(allocationUID, pod.Namespace, network.Name, podGUID); err != nil {
 				err = fmt.Errorf("failed to allocate guid for running pod: %v", err)
 				log.Err(err)
 				continue
 			}
 
 			d.guidPodNetworkMap[podGUID] = podNetworkID
+			if guidAddr, parseErr := net.ParseMAC(podGUID); parseErr == nil {
+				d.addDNSRecord(&pod, guidAddr)
+			}
+		}
+	}
+	d.guidAllocationLock.Unlock()
+
+	// the pods annotations take precedence over the persisted allocations
+	if d.poolPersistence != nil {
+		if err = d.restorePersistedGUIDs(pods); err != nil {
+			log.Err(err)
+			return err
 		}
 	}
 
+	d.flushDNSRecords()
 	return nil
 }
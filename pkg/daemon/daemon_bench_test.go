@@ -0,0 +1,231 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/mock"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+	"github.com/Mellanox/ib-kubernetes/pkg/watcher"
+	resEvenHandler "github.com/Mellanox/ib-kubernetes/pkg/watcher/handler"
+)
+
+// countingSMClient is a subnet manager client which counts the subnet manager calls
+type countingSMClient struct {
+	calls    int
+	stats    plugins.PKeyStats
+	statsErr error                      // error returned by GetPKeyUsageStats
+	members  map[int][]net.HardwareAddr // pKey members returned by GetPKeyMembership
+	removed  map[int][]net.HardwareAddr // guids removed by RemoveGuidsFromPKey, recorded if not nil
+	added    map[int][]net.HardwareAddr // guids added by AddGuidsToPKey, recorded if not nil
+	// memberships of the guids added by AddGuidsToPKey mapped by guid string, recorded if added isn't nil
+	memberships map[string]string
+	// guids last activity returned by GetGUIDLastActivity mapped by guid string
+	activity map[string]time.Time
+	pingErr  error // error returned by PingGUID
+	addErr   error // error returned by AddGuidsToPKey
+	// error returned by GetPKeyMembership
+	membersErr error
+	// errors returned by the successive AddGuidsToPKey and RemoveGuidsFromPKey calls, before addErr and nil
+	addErrs    []error
+	removeErrs []error
+	// port capabilities returned by GetPortCapabilities
+	capabilities plugins.PortCapabilities
+	topology     plugins.FabricTopology // fabric topology returned by GetFabricTopology
+}
+
+func (c *countingSMClient) Name() string    { return "counting" }
+func (c *countingSMClient) Spec() string    { return "1.0" }
+func (c *countingSMClient) Validate() error { return nil }
+
+func (c *countingSMClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr,
+	membership string) error {
+	c.calls++
+	if len(c.addErrs) != 0 {
+		err := c.addErrs[0]
+		c.addErrs = c.addErrs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	if c.added != nil {
+		c.added[pkey] = append(c.added[pkey], guids...)
+		if c.memberships == nil {
+			c.memberships = map[string]string{}
+		}
+		for _, guid := range guids {
+			c.memberships[guid.String()] = membership
+		}
+	}
+	return c.addErr
+}
+
+func (c *countingSMClient) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
+	c.calls++
+	if len(c.removeErrs) != 0 {
+		err := c.removeErrs[0]
+		c.removeErrs = c.removeErrs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	if c.removed != nil {
+		c.removed[pkey] = append(c.removed[pkey], guids...)
+	}
+	return nil
+}
+
+func (c *countingSMClient) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	c.calls++
+	return nil
+}
+
+func (c *countingSMClient) GetPKeyMembership(ctx context.Context, pkey int) ([]net.HardwareAddr, error) {
+	c.calls++
+	return c.members[pkey], c.membersErr
+}
+
+func (c *countingSMClient) GetPKeyUsageStats(ctx context.Context, pkey int) (plugins.PKeyStats, error) {
+	c.calls++
+	return c.stats, c.statsErr
+}
+
+func (c *countingSMClient) GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error) {
+	c.calls++
+	return c.activity[guid.String()], nil
+}
+
+func (c *countingSMClient) PingGUID(ctx context.Context, guid net.HardwareAddr) error {
+	c.calls++
+	return c.pingErr
+}
+
+func (c *countingSMClient) GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (
+	plugins.PortCapabilities, error) {
+	c.calls++
+	return c.capabilities, nil
+}
+
+func (c *countingSMClient) GetFabricTopology(ctx context.Context) (plugins.FabricTopology, error) {
+	c.calls++
+	return c.topology, nil
+}
+
+// gidSMClient is a counting subnet manager client which records the gids added by AddGidsToPKey
+type gidSMClient struct {
+	*countingSMClient
+	gids map[int][]string // gids added by AddGidsToPKey
+}
+
+func (g *gidSMClient) AddGidsToPKey(ctx context.Context, pkey int, gids []net.IP, membership string) error {
+	if g.gids == nil {
+		g.gids = map[int][]string{}
+	}
+	for _, gid := range gids {
+		g.gids[pkey] = append(g.gids[pkey], gid.String())
+	}
+	return nil
+}
+
+// removeHookSMClient is a counting subnet manager client which calls onRemove from RemoveGuidsFromPKey
+type removeHookSMClient struct {
+	*countingSMClient
+	onRemove func()
+}
+
+func (r *removeHookSMClient) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
+	r.onRemove()
+	return r.countingSMClient.RemoveGuidsFromPKey(ctx, pkey, guids)
+}
+
+type fakeWatcher struct {
+	eventHandler resEvenHandler.ResourceEventHandler
+}
+
+func (w *fakeWatcher) RunBackground() watcher.StopFunc { return func() {} }
+
+func (w *fakeWatcher) GetHandler() resEvenHandler.ResourceEventHandler { return w.eventHandler }
+
+// benchmarkDeletePeriodicUpdate deletes one pod of every network, the networks are spread over the given pKeys.
+// The kubernetes api calls are delayed by the given latency and fail at the given error rate.
+func benchmarkDeletePeriodicUpdate(b *testing.B, networks, pKeys int, latency k8sClient.SimulatedLatency,
+	errorRate float64) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	defer zerolog.SetGlobalLevel(zerolog.DebugLevel)
+
+	client := &k8sClientMock.Client{}
+	client.On("GetNetworkAttachmentDefinition", "default", mock.Anything).Return(
+		func(namespace, name string) *v1.NetworkAttachmentDefinition {
+			var index int
+			_, _ = fmt.Sscanf(name, "net-%d", &index)
+			return &v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+				Config: fmt.Sprintf(`{"type": "ib-sriov", "pkey": "0x%04X"}`, index%pKeys+1)}}
+		}, nil)
+
+	smClient := &countingSMClient{}
+	d := &daemon{
+		watcher:           &fakeWatcher{eventHandler: resEvenHandler.NewPodEventHandler(nil)},
+		kubeClient:        k8sClient.NewLatencySimulatingClient(client, latency, errorRate),
+		smClient:          smClient,
+		nadGUIDPools:      utils.NewSynchronizedMap(),
+		guidPodNetworkMap: map[string]string{},
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		guidPool, err := guid.NewPool(&config.GUIDPoolConfig{
+			RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:FF:FF"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		d.guidPool = guidPool
+
+		_, deleteMap := d.watcher.GetHandler().GetResults()
+		for index := 0; index < networks; index++ {
+			networkName := fmt.Sprintf("net-%d", index)
+			podGUID := guid.GUID(0x0200000000000000 + index).String()
+			if err = guidPool.AllocateGUID(types.UID(networkName), "default", networkName, podGUID); err != nil {
+				b.Fatal(err)
+			}
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: networkName,
+				UID: types.UID(networkName), Annotations: map[string]string{v1.NetworkAttachmentAnnot: fmt.Sprintf(
+					`[{"name":"%s","namespace":"default","cni-args":{"guid":"%s","mellanox.infiniband.app":"configured"}}]`,
+					networkName, podGUID)}}}
+			deleteMap.Set("default_"+networkName, []*kapi.Pod{pod})
+		}
+		b.StartTimer()
+
+		d.DeletePeriodicUpdate()
+	}
+
+	b.ReportMetric(float64(smClient.calls)/float64(b.N), "sm-calls/op")
+	b.ReportMetric(float64(networks), "networks/op")
+}
+
+func BenchmarkDeletePeriodicUpdateSinglePKey(b *testing.B) {
+	benchmarkDeletePeriodicUpdate(b, 100, 1, k8sClient.SimulatedLatency{}, 0)
+}
+
+func BenchmarkDeletePeriodicUpdateMultiplePKeys(b *testing.B) {
+	benchmarkDeletePeriodicUpdate(b, 100, 10, k8sClient.SimulatedLatency{}, 0)
+}
+
+func BenchmarkDeletePeriodicUpdateAPILatency(b *testing.B) {
+	benchmarkDeletePeriodicUpdate(b, 100, 10,
+		k8sClient.SimulatedLatency{Mean: 5 * time.Millisecond, StdDev: 2 * time.Millisecond}, 0.01)
+}
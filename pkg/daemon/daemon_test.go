@@ -0,0 +1,2270 @@
+package daemon
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ibapi "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+	"github.com/Mellanox/ib-kubernetes/pkg/pkey"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins/ufm"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+	resEvenHandler "github.com/Mellanox/ib-kubernetes/pkg/watcher/handler"
+)
+
+// configurableSMClient is a noop subnet manager client recording its plugin configuration
+type configurableSMClient struct {
+	plugins.SubnetManagerClient
+	conf    []byte
+	initErr error
+}
+
+func (c *configurableSMClient) Name() string {
+	return "configurable"
+}
+
+func (c *configurableSMClient) Validate() error {
+	return nil
+}
+
+func (c *configurableSMClient) Init(conf []byte) error {
+	c.conf = conf
+	return c.initErr
+}
+
+// testDaemonOptions are the dependencies of the daemon returned by newTestDaemon
+type testDaemonOptions struct {
+	// config of the daemon, its guid pool defaults to the 02:00:00:00:00:00:00:00-02:00:00:00:00:00:00:FF range
+	config     config.DaemonConfig
+	kubeClient k8sClient.Client
+	smClient   plugins.SubnetManagerClient
+	// pod event handler of the daemon watcher, defaults to a new pod event handler
+	podEventHandler   resEvenHandler.ResourceEventHandler
+	guidPodNetworkMap map[string]string
+}
+
+// newTestDaemon returns daemon with a pod watcher, a guid pool and the state of the periodic updates
+func newTestDaemon(opts testDaemonOptions) *daemon {
+	if opts.config.GUIDPool.RangeStart == "" {
+		opts.config.GUIDPool.RangeStart = "02:00:00:00:00:00:00:00"
+		opts.config.GUIDPool.RangeEnd = "02:00:00:00:00:00:00:FF"
+	}
+	guidPool, err := guid.NewPool(&opts.config.GUIDPool)
+	Expect(err).ToNot(HaveOccurred())
+	if opts.podEventHandler == nil {
+		opts.podEventHandler = resEvenHandler.NewPodEventHandler(nil)
+	}
+	if opts.guidPodNetworkMap == nil {
+		opts.guidPodNetworkMap = map[string]string{}
+	}
+	return &daemon{
+		config:            opts.config,
+		watcher:           &fakeWatcher{eventHandler: opts.podEventHandler},
+		kubeClient:        opts.kubeClient,
+		smClient:          opts.smClient,
+		guidPool:          guidPool,
+		nadGUIDPools:      utils.NewSynchronizedMap(),
+		drainingNADs:      utils.NewSynchronizedMap(),
+		guidPodNetworkMap: opts.guidPodNetworkMap,
+	}
+}
+
+var _ = Describe("Daemon", func() {
+	Context("sortNetworksByPriority", func() {
+		It("Sort networks by priority", func() {
+			d := &daemon{config: config.DaemonConfig{
+				NetworkPriorities: map[string]int{"storage": 10, "compute": 5, "management": -1}}}
+			networks := map[string]interface{}{
+				"default_management": nil,
+				"default_compute":    nil,
+				"default_storage":    nil,
+			}
+
+			Expect(d.sortNetworksByPriority(networks)).To(Equal(
+				[]string{"default_storage", "default_compute", "default_management"}))
+		})
+		It("Sort networks with same priority by network id", func() {
+			d := &daemon{config: config.DaemonConfig{NetworkPriorities: map[string]int{"storage": 10}}}
+			networks := map[string]interface{}{
+				"kube-system_test": nil,
+				"default_test":     nil,
+				"default_storage":  nil,
+			}
+
+			Expect(d.sortNetworksByPriority(networks)).To(Equal(
+				[]string{"default_storage", "default_test", "kube-system_test"}))
+		})
+	})
+	Context("Network attachment definition guid range", func() {
+		var d *daemon
+		var client *k8sClientMock.Client
+		netAttDef := &v1.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: "nad-uid"}}
+		BeforeEach(func() {
+			client = &k8sClientMock.Client{}
+			d = newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{ManageNADGUIDs: true, NADGUIDRangeSize: 16},
+				kubeClient: client,
+			})
+		})
+		It("Allocate guid range for network attachment definition", func() {
+			client.On("SetAnnotationsOnNetworkAttachmentDefinition", netAttDef, map[string]string{
+				utils.GUIDRangeStartAnnotation: "02:00:00:00:00:00:00:00",
+				utils.GUIDRangeEndAnnotation:   "02:00:00:00:00:00:00:0f"}).Return(nil)
+
+			Expect(d.allocateNADGUIDRange("default_test", netAttDef)).ToNot(HaveOccurred())
+			Expect(d.getNetworkGUIDPool("default_test")).ToNot(Equal(d.guidPool))
+			Expect(d.getNetworkGUIDPool("default_other")).To(Equal(d.guidPool))
+
+			guidAddr, err := d.getNetworkGUIDPool("default_test").GenerateGUID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr.String()).To(Equal("02:00:00:00:00:00:00:00"))
+
+			// the range is reserved in the global pool
+			guidAddr, err = d.guidPool.GenerateGUID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr.String()).To(Equal("02:00:00:00:00:00:00:10"))
+		})
+		It("Allocate guid range with failure annotating network attachment definition", func() {
+			client.On("SetAnnotationsOnNetworkAttachmentDefinition", netAttDef, mock.Anything).Return(
+				errors.New("failed"))
+
+			Expect(d.allocateNADGUIDRange("default_test", netAttDef)).To(HaveOccurred())
+			Expect(d.getNetworkGUIDPool("default_test")).To(Equal(d.guidPool))
+
+			// the range is released to the global pool
+			guidAddr, err := d.guidPool.GenerateGUID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr.String()).To(Equal("02:00:00:00:00:00:00:00"))
+		})
+		It("Restore and release guid range of network attachment definition", func() {
+			rangeNetAttDef := netAttDef.DeepCopy()
+			rangeNetAttDef.Annotations = map[string]string{
+				utils.GUIDRangeStartAnnotation: "02:00:00:00:00:00:00:00",
+				utils.GUIDRangeEndAnnotation:   "02:00:00:00:00:00:00:03"}
+
+			Expect(d.restoreNADGUIDPool("default_test", rangeNetAttDef)).ToNot(HaveOccurred())
+			Expect(d.guidPool.AllocateGUID("pod", "default", "test",
+				"02:00:00:00:00:00:00:03")).To(HaveOccurred())
+
+			d.releaseNADGUIDRange("default_test", rangeNetAttDef)
+			Expect(d.getNetworkGUIDPool("default_test")).To(Equal(d.guidPool))
+			Expect(d.guidPool.AllocateGUID("pod", "default", "test",
+				"02:00:00:00:00:00:00:03")).ToNot(HaveOccurred())
+		})
+		It("Keep guid range of deleted network attachment definition until its guids are released", func() {
+			rangeNetAttDef := netAttDef.DeepCopy()
+			rangeNetAttDef.Annotations = map[string]string{
+				utils.GUIDRangeStartAnnotation: "02:00:00:00:00:00:00:00",
+				utils.GUIDRangeEndAnnotation:   "02:00:00:00:00:00:00:03"}
+			Expect(d.restoreNADGUIDPool("default_test", rangeNetAttDef)).ToNot(HaveOccurred())
+			nadGUIDPool := d.getNetworkGUIDPool("default_test")
+			Expect(nadGUIDPool.AllocateGUID("pod-uid", "default", "test", "02:00:00:00:00:00:00:01")).To(Succeed())
+			d.nadWatcher = &fakeWatcher{eventHandler: resEvenHandler.NewNetworkAttachmentDefinitionEventHandler()}
+			addMap, deleteMap := d.nadWatcher.GetHandler().GetResults()
+			deleteMap.Set("default_test", rangeNetAttDef)
+
+			d.NADPeriodicUpdate()
+			Expect(d.getNetworkGUIDPool("default_test")).To(BeIdenticalTo(nadGUIDPool))
+			Expect(d.guidPool.AllocateGUID("pod", "default", "test", "02:00:00:00:00:00:00:03")).To(HaveOccurred())
+
+			// the network attachment definition created again waits for the deleted one guid range
+			addMap.Set("default_test", netAttDef)
+			d.NADPeriodicUpdate()
+			Expect(addMap.Items).To(HaveKey("default_test"))
+
+			Expect(d.releasePoolGUID(nadGUIDPool, "02:00:00:00:00:00:00:01")).To(Succeed())
+			client.On("SetAnnotationsOnNetworkAttachmentDefinition", netAttDef, mock.Anything).Return(nil)
+			d.NADPeriodicUpdate()
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(d.getNetworkGUIDPool("default_test")).ToNot(BeIdenticalTo(nadGUIDPool))
+			Expect(d.drainingNADs.Items).To(BeEmpty())
+		})
+		It("Restore guid range overlapping allocated guids", func() {
+			rangeNetAttDef := netAttDef.DeepCopy()
+			rangeNetAttDef.Annotations = map[string]string{
+				utils.GUIDRangeStartAnnotation: "02:00:00:00:00:00:00:00",
+				utils.GUIDRangeEndAnnotation:   "02:00:00:00:00:00:00:03"}
+			Expect(d.guidPool.AllocateGUID("pod", "default", "test",
+				"02:00:00:00:00:00:00:02")).ToNot(HaveOccurred())
+
+			Expect(d.restoreNADGUIDPool("default_test", rangeNetAttDef)).To(HaveOccurred())
+			Expect(d.getNetworkGUIDPool("default_test")).To(Equal(d.guidPool))
+			Expect(d.guidPool.AllocateGUID("pod", "default", "test",
+				"02:00:00:00:00:00:00:00")).ToNot(HaveOccurred())
+		})
+	})
+	Context("RequestGUID", func() {
+		var d *daemon
+		var client *k8sClientMock.Client
+		BeforeEach(func() {
+			client = &k8sClientMock.Client{}
+			d = newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{SidecarMode: true, NodeName: "node1"},
+				kubeClient: client,
+			})
+		})
+		It("Request guid of configured pod network", func() {
+			client.On("GetNodePods", "node1").Return(&kapi.PodList{Items: []kapi.Pod{{
+				ObjectMeta: metav1.ObjectMeta{UID: "pod", Annotations: map[string]string{
+					v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default",` +
+						`"cni-args":{"guid":"02:00:00:00:00:00:00:01","mellanox.infiniband.app":"configured"}}]`}}}}}, nil)
+
+			podGUID, err := d.RequestGUID("pod", "test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(podGUID).To(Equal("02:00:00:00:00:00:00:01"))
+		})
+		It("Request guid of not configured pod network", func() {
+			client.On("GetNodePods", "node1").Return(&kapi.PodList{Items: []kapi.Pod{{
+				ObjectMeta: metav1.ObjectMeta{UID: "pod", Annotations: map[string]string{
+					v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}}}, nil)
+
+			_, err := d.RequestGUID("pod", "test")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Request guid of pod not on the node", func() {
+			client.On("GetNodePods", "node1").Return(&kapi.PodList{}, nil)
+
+			_, err := d.RequestGUID("pod", "test")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Allocate guid of configured pod network in namespace", func() {
+			client.On("GetPod", "default", "pod").Return(&kapi.Pod{
+				ObjectMeta: metav1.ObjectMeta{UID: "pod", Namespace: "default", Name: "pod", Annotations: map[string]string{
+					v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default",` +
+						`"cni-args":{"guid":"02:00:00:00:00:00:00:01","mellanox.infiniband.app":"configured"}}]`}}}, nil)
+
+			podGUID, err := d.AllocateGUID("default", "pod", "pod", "test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(podGUID).To(Equal("02:00:00:00:00:00:00:01"))
+
+			_, err = d.AllocateGUID("default", "pod", "other", "test")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Allocate guid of pending pod network handling only the pod", func() {
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			newPod := func(name string) *kapi.Pod {
+				return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name),
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			}
+			otherPod := newPod("other")
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{newPod("pod"), otherPod})
+
+			podGUID, err := d.AllocateGUID("default", "pod", "pod", "test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d.guidPodNetworkMap).To(HaveKeyWithValue(podGUID, "pod"+"default_test"))
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(addMap.Items).To(HaveKeyWithValue("default_test", []*kapi.Pod{otherPod}))
+			client.AssertNotCalled(GinkgoT(), "GetPod", mock.Anything, mock.Anything)
+		})
+		It("Request guid of pending pod network handling only the pod", func() {
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			newPod := func(name string) *kapi.Pod {
+				return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name),
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			}
+			otherPod := newPod("other")
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{newPod("pod"), otherPod})
+
+			podGUID, err := d.RequestGUID("pod", "test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d.guidPodNetworkMap).To(HaveKeyWithValue(podGUID, "pod"+"default_test"))
+			Expect(addMap.Items).To(HaveKeyWithValue("default_test", []*kapi.Pod{otherPod}))
+			client.AssertNotCalled(GinkgoT(), "GetNodePods", mock.Anything)
+		})
+	})
+	Context("AddPeriodicUpdate", func() {
+		It("Get network attachment definition of cross-namespace network from its namespace", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "bar", "test").Return(nil, errors.New("failed"))
+			d := newTestDaemon(testDaemonOptions{
+				kubeClient: client,
+			})
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "pod", Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"bar"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("bar_test", []*kapi.Pod{pod})
+
+			d.AddPeriodicUpdate()
+			client.AssertCalled(GinkgoT(), "GetNetworkAttachmentDefinition", "bar", "test")
+		})
+		It("Process networks in priority order", func() {
+			var processed []string
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", mock.Anything).Run(func(args mock.Arguments) {
+				processed = append(processed, args.String(1))
+			}).Return(&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+				Config: `{"type": "ib-sriov"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			d := newTestDaemon(testDaemonOptions{
+				config: config.DaemonConfig{
+					NetworkPriorities: map[string]int{"storage": 10, "compute": 5, "management": -1}},
+				kubeClient: client,
+			})
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			for _, network := range []string{"management", "compute", "storage"} {
+				addMap.Set("default_"+network, []*kapi.Pod{{ObjectMeta: metav1.ObjectMeta{Namespace: "default",
+					Name: "pod-" + network, UID: types.UID(network), Annotations: map[string]string{
+						v1.NetworkAttachmentAnnot: `[{"name":"` + network + `","namespace":"default"}]`}}}})
+			}
+
+			d.AddPeriodicUpdate()
+			Expect(processed).To(Equal([]string{"storage", "compute", "management"}))
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(3))
+		})
+		It("Process networks concurrently and networks sharing pods by the same worker", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", mock.Anything).Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			d := newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxConcurrentNetworks: 3},
+				kubeClient: client,
+			})
+			newPod := func(name, networks string) *kapi.Pod {
+				return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name),
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: networks}}}
+			}
+			sharedPod := newPod("shared", `[{"name":"a","namespace":"default"},{"name":"b","namespace":"default"}]`)
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_a", []*kapi.Pod{newPod("pod-a", `[{"name":"a","namespace":"default"}]`), sharedPod})
+			addMap.Set("default_b", []*kapi.Pod{sharedPod})
+			addMap.Set("default_c", []*kapi.Pod{newPod("pod-c", `[{"name":"c","namespace":"default"}]`)})
+			Expect(groupNetworksBySharedPods(d.sortNetworksByPriority(addMap.Items), addMap.Items)).To(Equal(
+				[][]string{{"default_a", "default_b"}, {"default_c"}}))
+
+			d.AddPeriodicUpdate()
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(4))
+			Expect(d.guidPodNetworkMap).To(HaveLen(4))
+			// the shared pod annotation has the guids of both networks
+			networks, err := netAttUtils.ParsePodNetworkAnnotation(sharedPod)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networks).To(HaveLen(2))
+			for _, network := range networks {
+				Expect((*network.CNIArgs)[utils.InfiniBandAnnotation]).To(Equal(utils.ConfiguredInfiniBandPod))
+			}
+		})
+		It("Create warning events of pods whose guids failed to be allocated or added to the pKey", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, mock.Anything, mock.Anything).Return(nil)
+			d := newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+				kubeClient: client,
+				smClient:   &countingSMClient{addErr: errors.New("unreachable")},
+			})
+			Expect(d.guidPool.AllocateGUID("other-uid", "default", "test", "02:00:00:00:00:00:00:10")).To(Succeed())
+			takenPod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "taken", UID: "taken-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default",` +
+					`"cni-args":{"guid":"02:00:00:00:00:00:00:10"}}]`}}}
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{takenPod, pod})
+
+			d.AddPeriodicUpdate()
+			client.AssertCalled(GinkgoT(), "CreatePodEvent", takenPod, kapi.EventTypeWarning,
+				guidAllocationFailedReason, mock.Anything)
+			client.AssertCalled(GinkgoT(), "CreatePodEvent", pod, kapi.EventTypeWarning, subnetManagerErrorReason,
+				mock.Anything)
+			client.AssertNumberOfCalls(GinkgoT(), "CreatePodEvent", 2)
+		})
+		It("Stop generating guids of an exhausted guid pool until the next update", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, mock.Anything, mock.Anything).Return(nil)
+			d := newTestDaemon(testDaemonOptions{
+				config: config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95,
+					PodName: "ib-kubernetes", PodNamespace: "kube-system", GUIDPool: config.GUIDPoolConfig{
+						RangeStart: "02:00:00:00:00:00:00:01", RangeEnd: "02:00:00:00:00:00:00:02"}},
+				kubeClient: client,
+				smClient:   &countingSMClient{},
+			})
+			guidPool := d.guidPool
+			var pods []*kapi.Pod
+			for index := 0; index < 4; index++ {
+				name := fmt.Sprintf("pod-%d", index)
+				pods = append(pods, &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name,
+					UID: types.UID(name), Annotations: map[string]string{
+						v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}})
+			}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", pods)
+			before := testutil.ToFloat64(metrics.GUIDPoolExhaustions)
+
+			d.AddPeriodicUpdate()
+			Expect(guidPool.GetAllocations()).To(HaveLen(2))
+			Expect(addMap.Items["default_test"]).To(Equal(pods[2:]))
+			Expect(testutil.ToFloat64(metrics.GUIDPoolExhaustions)).To(Equal(before + 1))
+			client.AssertCalled(GinkgoT(), "CreatePodEvent", mock.MatchedBy(func(pod *kapi.Pod) bool {
+				return pod.Name == "ib-kubernetes"
+			}), kapi.EventTypeWarning, guidPoolExhaustedReason, mock.Anything)
+			Expect(d.exhaustedGUIDPools).To(BeEmpty())
+
+			// a released guid is generated on the next update only
+			Expect(guidPool.ReleaseGUID("02:00:00:00:00:00:00:01")).To(Succeed())
+			d.exhaustedGUIDPools = map[exhaustedGUIDPool]bool{{guidPool: guidPool, namespace: "default"}: true}
+			_, _, err := d.generatePodGUIDUnlessExhausted(guidPool, pods[2], pods[2].UID, "test")
+			Expect(errors.Is(err, guid.ErrPoolExhausted)).To(BeTrue())
+			d.exhaustedGUIDPools = nil
+			_, _, err = d.generatePodGUIDUnlessExhausted(guidPool, pods[2], pods[2].UID, "test")
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("Record the reconcile failures of InfiniBand networks only", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "ib-metrics").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("GetNetworkAttachmentDefinition", "default", "bridge-metrics").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "bridge"}`}}, nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, mock.Anything, mock.Anything).Return(nil)
+			d := newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+				kubeClient: client,
+				smClient:   &countingSMClient{addErr: errors.New("unreachable")},
+			})
+			ibPod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ib", UID: "ib-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"ib-metrics"}]`}}}
+			bridgePod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "bridge",
+				UID: "bridge-uid", Annotations: map[string]string{
+					v1.NetworkAttachmentAnnot: `[{"name":"bridge-metrics"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_ib-metrics", []*kapi.Pod{ibPod})
+			addMap.Set("default_bridge-metrics", []*kapi.Pod{bridgePod})
+			failures := metrics.NetworkReconcileFailures.WithLabelValues("default_ib-metrics",
+				reconcileFailureSubnetManager)
+			before := testutil.ToFloat64(failures)
+
+			d.AddPeriodicUpdate()
+			Expect(testutil.ToFloat64(failures)).To(Equal(before + 1))
+			// the durations are recorded only for the InfiniBand network
+			Expect(metrics.NetworkReconcileDuration.DeleteLabelValues("default_ib-metrics")).To(BeTrue())
+			Expect(metrics.NetworkReconcileDuration.DeleteLabelValues("default_bridge-metrics")).To(BeFalse())
+		})
+	})
+	Context("drain", func() {
+		var d *daemon
+		BeforeEach(func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			d = newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{DrainTimeout: 1},
+				kubeClient: client,
+			})
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pod})
+		})
+		It("Flush pending pods on drain", func() {
+			d.drain()
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+		})
+		It("Stop waiting for the running add update after the drain timeout", func() {
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Lock()
+			defer addMap.Unlock()
+			start := time.Now()
+			d.drain()
+			Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+			Expect(addMap.Items).To(HaveLen(1))
+		})
+	})
+	Context("multiple interfaces of a network", func() {
+		It("Allocate a guid for every interface and release them on delete periodic update", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			smClient := &countingSMClient{added: map[int][]net.HardwareAddr{}, removed: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[` +
+					`{"name":"test","namespace":"default","interface":"net1"},` +
+					`{"name":"test","namespace":"default","interface":"net2"}]`}}}
+			// the pod is listed once for every interface of the network
+			addMap, deleteMap := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pod, pod})
+
+			d.AddPeriodicUpdate()
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(2))
+			Expect(smClient.added[0x10]).To(HaveLen(2))
+			networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networks).To(HaveLen(2))
+			var podGUIDs []string
+			for _, network := range networks {
+				Expect(utils.IsPodNetworkConfiguredWithInfiniBand(network, "")).To(BeTrue())
+				podGUID, guidErr := utils.GetPodNetworkGUID(network)
+				Expect(guidErr).ToNot(HaveOccurred())
+				podGUIDs = append(podGUIDs, podGUID)
+			}
+			Expect(podGUIDs[0]).ToNot(Equal(podGUIDs[1]))
+			client.AssertNumberOfCalls(GinkgoT(), "SetAnnotationsOnPod", 1)
+
+			deleteMap.Set("default_test", []*kapi.Pod{pod, pod})
+			d.DeletePeriodicUpdate()
+			Expect(deleteMap.Items).To(BeEmpty())
+			Expect(d.guidPool.GetAllocations()).To(BeEmpty())
+			Expect(smClient.removed[0x10]).To(ConsistOf(smClient.added[0x10]))
+			Expect(d.guidPodNetworkMap).To(BeEmpty())
+		})
+	})
+	Context("ReleaseGUID", func() {
+		var d *daemon
+		var smClient *countingSMClient
+		var pod *kapi.Pod
+		BeforeEach(func() {
+
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			smClient = &countingSMClient{added: map[int][]net.HardwareAddr{}, removed: map[int][]net.HardwareAddr{}}
+			d = newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+			pod = &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pod})
+			d.AddPeriodicUpdate()
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+		})
+		It("Release guid of pod network and skip it on delete periodic update", func() {
+			podGUID := d.guidPool.GetAllocations()[0].GUID.String()
+			Expect(d.ReleaseGUID("pod-uid", "test")).To(Succeed())
+			Expect(d.guidPool.GetAllocations()).To(BeEmpty())
+			Expect(smClient.removed[0x10]).To(ConsistOf(smClient.added[0x10]))
+			Expect(d.guidPodNetworkMap).To(BeEmpty())
+
+			// the released guid may be allocated to another pod before the pod deletion is received
+			Expect(d.guidPool.AllocateGUID("other-uid", "default", "test", podGUID)).To(Succeed())
+			_, deleteMap := d.watcher.GetHandler().GetResults()
+			deleteMap.Set("default_test", []*kapi.Pod{pod})
+			d.DeletePeriodicUpdate()
+			Expect(deleteMap.Items).To(BeEmpty())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(smClient.removed[0x10]).To(HaveLen(1))
+			Expect(d.releasedGUIDs).To(BeEmpty())
+		})
+		It("Release guid of pod network without allocated guids", func() {
+			Expect(d.ReleaseGUID("other-uid", "test")).To(Succeed())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(smClient.removed).To(BeEmpty())
+		})
+		It("Keep guid of pod network failed to be removed from its pKey", func() {
+			smClient.removeErrs = []error{errors.New("unreachable")}
+			Expect(d.ReleaseGUID("pod-uid", "test")).ToNot(Succeed())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(d.guidPodNetworkMap).To(HaveLen(1))
+		})
+	})
+	Context("pKey membership", func() {
+		var client *k8sClientMock.Client
+		var smClient *countingSMClient
+		var d *daemon
+		BeforeEach(func() {
+			client = &k8sClientMock.Client{}
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			smClient = &countingSMClient{added: map[int][]net.HardwareAddr{}}
+			d = newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+		})
+		addPod := func(networkConfig string) {
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{Config: networkConfig}}, nil)
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pod})
+			d.AddPeriodicUpdate()
+		}
+		It("Add the guids with the membership of the network", func() {
+			addPod(`{"type": "ib-sriov", "pkey": "0x10", "membership": "limited"}`)
+			Expect(smClient.added[0x10]).To(HaveLen(1))
+			Expect(smClient.memberships[smClient.added[0x10][0].String()]).To(Equal(plugins.MembershipLimited))
+		})
+		It("Add the guids with full membership if the network has no membership", func() {
+			addPod(`{"type": "ib-sriov", "pkey": "0x10"}`)
+			Expect(smClient.added[0x10]).To(HaveLen(1))
+			Expect(smClient.memberships[smClient.added[0x10][0].String()]).To(Equal(plugins.MembershipFull))
+		})
+		It("Fail the network with invalid membership", func() {
+			addPod(`{"type": "ib-sriov", "pkey": "0x10", "membership": "partial"}`)
+			Expect(smClient.added).To(BeEmpty())
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			Expect(addMap.Items).To(HaveKey("default_test"))
+		})
+	})
+	Context("verify subnet manager additions", func() {
+		var client *k8sClientMock.Client
+		BeforeEach(func() {
+			client = &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+		})
+		addPod := func(smClient plugins.SubnetManagerClient) map[string]interface{} {
+			d := newTestDaemon(testDaemonOptions{
+				config: config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95,
+					VerifySMAdditions: true},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pod})
+			d.AddPeriodicUpdate()
+			return addMap.Items
+		}
+		It("Configure the pods whose guids are members of the pKey", func() {
+			Expect(addPod(plugins.NewNoopClient())).To(BeEmpty())
+			client.AssertNumberOfCalls(GinkgoT(), "SetAnnotationsOnPod", 1)
+		})
+		It("Retry the pods whose guids are missing from the pKey", func() {
+			before := testutil.ToFloat64(metrics.SMAddVerificationFailures)
+			Expect(addPod(&countingSMClient{})).To(HaveKey("default_test"))
+			Expect(testutil.ToFloat64(metrics.SMAddVerificationFailures)).To(Equal(before + 1))
+			client.AssertNotCalled(GinkgoT(), "SetAnnotationsOnPod", mock.Anything, mock.Anything)
+		})
+		It("Skip the verification if the plugin can't report the pKey members", func() {
+			smClient := &countingSMClient{membersErr: plugins.ErrMembershipNotSupported}
+			Expect(addPod(smClient)).To(BeEmpty())
+			client.AssertNumberOfCalls(GinkgoT(), "SetAnnotationsOnPod", 1)
+		})
+	})
+	Context("daemonPodAnnotations", func() {
+		It("Return only the annotations set by the daemon", func() {
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"test"}]`, utils.PortSpeedAnnotation: "100Gb",
+				"kubectl.kubernetes.io/last-applied-configuration": "{}"}}}
+			Expect(daemonPodAnnotations(pod)).To(Equal(map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"test"}]`, utils.PortSpeedAnnotation: "100Gb"}))
+		})
+	})
+	Context("already configured pods", func() {
+		It("Add the guids of re-queued configured pods only if they are missing from the pKey", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			smClient := &countingSMClient{added: map[int][]net.HardwareAddr{}, members: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pod})
+			d.AddPeriodicUpdate()
+			Expect(smClient.added[0x10]).To(HaveLen(1))
+			client.AssertNumberOfCalls(GinkgoT(), "SetAnnotationsOnPod", 1)
+
+			// the configured pod is re-queued while its guid is a member of the pKey
+			smClient.members[0x10] = smClient.added[0x10]
+			addMap.Set("default_test", []*kapi.Pod{pod})
+			d.AddPeriodicUpdate()
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(smClient.added[0x10]).To(HaveLen(1))
+			client.AssertNumberOfCalls(GinkgoT(), "SetAnnotationsOnPod", 1)
+
+			// the configured pod is re-queued after its guid was removed from the pKey
+			smClient.members[0x10] = nil
+			addMap.Set("default_test", []*kapi.Pod{pod})
+			d.AddPeriodicUpdate()
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(smClient.added[0x10]).To(HaveLen(2))
+			Expect(smClient.added[0x10][1]).To(Equal(smClient.added[0x10][0]))
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+		})
+	})
+	Context("NewDaemonWithDeps", func() {
+		DescribeTable("Add and delete the guids of the network pods with the injected dependencies",
+			func(netConf string, addedGUIDs int) {
+				guidPool, err := guid.NewPool(&config.GUIDPoolConfig{
+					RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:FF"})
+				Expect(err).ToNot(HaveOccurred())
+
+				client := &k8sClientMock.Client{}
+				client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+					&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{Config: netConf}}, nil)
+				client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+				smClient := &countingSMClient{added: map[int][]net.HardwareAddr{}, removed: map[int][]net.HardwareAddr{}}
+				podWatcher := &fakeWatcher{eventHandler: resEvenHandler.NewPodEventHandler(nil)}
+				d, err := NewDaemonWithDeps(config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+					client, guidPool, smClient, podWatcher)
+				Expect(err).ToNot(HaveOccurred())
+
+				pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+					Annotations: map[string]string{
+						v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+				addMap, deleteMap := podWatcher.GetHandler().GetResults()
+				addMap.Set("default_test", []*kapi.Pod{pod})
+				d.AddPeriodicUpdate()
+				Expect(addMap.Items).To(BeEmpty())
+				Expect(guidPool.GetAllocations()).To(HaveLen(1))
+				Expect(smClient.added[0x10]).To(HaveLen(addedGUIDs))
+				client.AssertNumberOfCalls(GinkgoT(), "SetAnnotationsOnPod", 1)
+
+				deleteMap.Set("default_test", []*kapi.Pod{pod})
+				d.DeletePeriodicUpdate()
+				Expect(deleteMap.Items).To(BeEmpty())
+				Expect(guidPool.GetAllocations()).To(BeEmpty())
+				Expect(smClient.removed[0x10]).To(HaveLen(addedGUIDs))
+			},
+			Entry("network with pKey", `{"type": "ib-sriov", "pkey": "0x10"}`, 1),
+			Entry("network without pKey", `{"type": "ib-sriov"}`, 0),
+		)
+	})
+	Context("max pod retries", func() {
+		var d *daemon
+		var client *k8sClientMock.Client
+		var smClient *countingSMClient
+		newPod := func(name, podGUID string) *kapi.Pod {
+			return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name,
+				UID: types.UID(name + "-uid"), Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{` +
+					`"name":"test","namespace":"default","cni-args":{"guid":"` + podGUID + `"}}]`}}}
+		}
+		BeforeEach(func() {
+			client = &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, mock.Anything, mock.Anything).Return(nil)
+			smClient = &countingSMClient{added: map[int][]net.HardwareAddr{}}
+			d = newTestDaemon(testDaemonOptions{
+				config: config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95,
+					MaxPodRetries: 2},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+		})
+		It("Drop the pod failing more than the max retries", func() {
+			invalidPod := newPod("invalid", "00:00:00:00:00:00:00:00")
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{invalidPod, newPod("valid", "02:00:00:00:00:00:00:01")})
+
+			for update := 0; update < 2; update++ {
+				d.AddPeriodicUpdate()
+				pods, exist := addMap.Get("default_test")
+				Expect(exist).To(BeTrue())
+				Expect(pods).To(Equal([]*kapi.Pod{invalidPod}))
+			}
+			Expect(smClient.added[0x10]).To(HaveLen(1))
+
+			d.AddPeriodicUpdate()
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(d.podRetries).To(BeEmpty())
+			client.AssertCalled(GinkgoT(), "CreatePodEvent", invalidPod, kapi.EventTypeWarning,
+				podRetriesExceededReason, mock.Anything)
+		})
+		It("Don't count the network failures of the subnet manager", func() {
+			smClient.addErr = errors.New("subnet manager is unreachable")
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{newPod("valid", "02:00:00:00:00:00:00:01")})
+
+			for update := 0; update < 4; update++ {
+				d.AddPeriodicUpdate()
+				Expect(addMap.Items).To(HaveKey("default_test"))
+			}
+			client.AssertNotCalled(GinkgoT(), "CreatePodEvent", mock.Anything, kapi.EventTypeWarning,
+				podRetriesExceededReason, mock.Anything)
+		})
+	})
+	Context("recreated pods", func() {
+		It("Allocate the user guid of a recreated pod after its previous pod no longer exists", func() {
+			newPod := func(uid types.UID) *kapi.Pod {
+				return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod-0", UID: uid,
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default",` +
+						`"cni-args":{"guid":"02:00:00:00:00:00:00:10"}}]`}}}
+			}
+			previousPod := newPod("previous-uid")
+			recreatedPod := newPod("recreated-uid")
+
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, mock.Anything, mock.Anything).Return(nil)
+			client.On("GetPods", "default").Return(&kapi.PodList{Items: []kapi.Pod{*previousPod}}, nil).Once()
+			client.On("GetPods", "default").Return(&kapi.PodList{Items: []kapi.Pod{*recreatedPod}}, nil)
+			smClient := &countingSMClient{added: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{previousPod})
+			d.AddPeriodicUpdate()
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(d.guidPool.GetAllocations()[0].PodUID).To(Equal(types.UID("previous-uid")))
+
+			// the deletion of the previous pod was missed, its guid isn't reclaimed while it exists
+			addMap.Set("default_test", []*kapi.Pod{recreatedPod})
+			d.AddPeriodicUpdate()
+			Expect(d.guidPool.GetAllocations()[0].PodUID).To(Equal(types.UID("previous-uid")))
+
+			addMap.Set("default_test", []*kapi.Pod{recreatedPod})
+			d.AddPeriodicUpdate()
+			Expect(addMap.Items).To(BeEmpty())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(d.guidPool.GetAllocations()[0].PodUID).To(Equal(types.UID("recreated-uid")))
+			client.AssertNumberOfCalls(GinkgoT(), "SetAnnotationsOnPod", 2)
+		})
+	})
+	Context("guid reservations", func() {
+		It("Allocate the reserved guid of the pod and reject conflicting reservations", func() {
+			newPod := func(name string, uid types.UID) *kapi.Pod {
+				return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: uid,
+					Annotations: map[string]string{
+						v1.NetworkAttachmentAnnot:       `[{"name":"test","namespace":"default"}]`,
+						utils.GUIDReservationAnnotation: "test=02:00:00:00:00:00:00:20"}}}
+			}
+			reservedPod := newPod("pod-0", "pod-0-uid")
+			conflictingPod := newPod("pod-1", "pod-1-uid")
+
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, mock.Anything, mock.Anything).Return(nil)
+			smClient := &countingSMClient{added: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{reservedPod, conflictingPod})
+			d.AddPeriodicUpdate()
+
+			reservedGUID := guid.GUID(0x0200000000000020)
+			Expect(smClient.added[0x10]).To(Equal([]net.HardwareAddr{reservedGUID.HardWareAddress()}))
+			Expect(reservedPod.Annotations[v1.NetworkAttachmentAnnot]).To(ContainSubstring(reservedGUID.String()))
+			Expect(d.guidPool.GetAllocations()).To(Equal([]guid.Allocation{{GUID: reservedGUID, PodUID: "pod-0-uid",
+				Namespace: "default", Network: "test", PKey: "0x10"}}))
+			client.AssertCalled(GinkgoT(), "CreatePodEvent", conflictingPod, kapi.EventTypeWarning,
+				guidAllocationFailedReason, mock.MatchedBy(func(message string) bool {
+					return strings.Contains(message, "reserved for another pod")
+				}))
+			client.AssertNumberOfCalls(GinkgoT(), "CreatePodEvent", 1)
+
+			// the guid isn't generated for other pods once the reserved pod is deleted
+			_, err := d.guidPool.ReleaseGUIDByPodUID("pod-0-uid")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d.guidPool.AllocateGUID("pod-1-uid", "default", "test", reservedGUID.String())).To(
+				MatchError(guid.ErrReserved))
+		})
+	})
+	Context("subnet manager batches", func() {
+		var d *daemon
+		var smClient *countingSMClient
+		var pods []*kapi.Pod
+		BeforeEach(func() {
+
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, mock.Anything, mock.Anything).Return(nil)
+			smClient = &countingSMClient{added: map[int][]net.HardwareAddr{}, removed: map[int][]net.HardwareAddr{}}
+			d = newTestDaemon(testDaemonOptions{
+				config: config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95,
+					SMMaxBatchSize: 1},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+			pods = nil
+			for _, name := range []string{"first", "second"} {
+				pods = append(pods, &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name,
+					UID: types.UID(name + "-uid"), Annotations: map[string]string{
+						v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}})
+			}
+		})
+		It("Retry only the pods of the failed add batches", func() {
+			smClient.addErrs = []error{nil, errors.New("throttled")}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pods[0], pods[1]})
+
+			d.AddPeriodicUpdate()
+			Expect(smClient.added[0x10]).To(HaveLen(1))
+			Expect(addMap.Items["default_test"]).To(Equal([]*kapi.Pod{pods[1]}))
+			networks, err := netAttUtils.ParsePodNetworkAnnotation(pods[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(utils.IsPodNetworkConfiguredWithInfiniBand(networks[0], "")).To(BeTrue())
+
+			d.AddPeriodicUpdate()
+			Expect(smClient.added[0x10]).To(HaveLen(2))
+			Expect(addMap.Items).To(BeEmpty())
+		})
+		It("Release only the guids of the pods of the succeeded remove batches", func() {
+			addMap, deleteMap := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pods[0], pods[1]})
+			d.AddPeriodicUpdate()
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(2))
+
+			smClient.removeErrs = []error{errors.New("throttled"), nil}
+			deleteMap.Set("default_test", []*kapi.Pod{pods[0], pods[1]})
+			d.DeletePeriodicUpdate()
+			Expect(smClient.removed[0x10]).To(Equal(smClient.added[0x10][1:]))
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(deleteMap.Items["default_test"]).To(Equal([]*kapi.Pod{pods[0]}))
+
+			d.DeletePeriodicUpdate()
+			Expect(d.guidPool.GetAllocations()).To(BeEmpty())
+			Expect(deleteMap.Items).To(BeEmpty())
+		})
+	})
+	Context("pod finalizers", func() {
+		var d *daemon
+		var client *k8sClientMock.Client
+		var smClient *countingSMClient
+		var pod *kapi.Pod
+		isRemovePatch := func(data []byte) bool { return strings.Contains(string(data), "$deleteFromPrimitiveList") }
+		patches := func() []string {
+			var patchData []string
+			for _, call := range client.Calls {
+				if call.Method == "PatchPod" {
+					patchData = append(patchData, string(call.Arguments.Get(2).([]byte)))
+				}
+			}
+			return patchData
+		}
+		BeforeEach(func() {
+
+			client = &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				&v1.NetworkAttachmentDefinition{Spec: v1.NetworkAttachmentDefinitionSpec{
+					Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, mock.Anything, mock.Anything).Return(nil)
+			smClient = &countingSMClient{added: map[int][]net.HardwareAddr{}, removed: map[int][]net.HardwareAddr{}}
+			d = newTestDaemon(testDaemonOptions{
+				config:     config.DaemonConfig{MaxGUIDsPerPKey: 8192, PKeyUsageBlockPercent: 95, PodFinalizer: true},
+				kubeClient: client,
+				smClient:   smClient,
+			})
+			pod = &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+		})
+		deletePod := func() {
+			deletionTime := metav1.Now()
+			pod.DeletionTimestamp = &deletionTime
+			pod.Finalizers = []string{utils.GUIDReleaseFinalizer}
+			_, deleteMap := d.watcher.GetHandler().GetResults()
+			deleteMap.Set("default_test", []*kapi.Pod{pod})
+		}
+		It("Add the finalizer to configured pods and remove it once their guids are released", func() {
+			client.On("PatchPod", pod, types.StrategicMergePatchType, mock.Anything).Return(nil)
+			addMap, deleteMap := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pod})
+			d.AddPeriodicUpdate()
+			Expect(patches()).To(Equal([]string{`{"metadata":{"finalizers":["ib.mellanox.com/guid-release"]}}`}))
+
+			// the finalizer is kept until the guids are removed from the subnet manager
+			smClient.removeErrs = []error{errors.New("throttled")}
+			deletePod()
+			d.DeletePeriodicUpdate()
+			Expect(patches()).To(HaveLen(1))
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+
+			d.DeletePeriodicUpdate()
+			Expect(d.guidPool.GetAllocations()).To(BeEmpty())
+			Expect(deleteMap.Items).To(BeEmpty())
+			Expect(patches()).To(HaveLen(2))
+			Expect(patches()[1]).To(Equal(
+				`{"metadata":{"$deleteFromPrimitiveList/finalizers":["ib.mellanox.com/guid-release"]}}`))
+		})
+		It("Retry removing the finalizer without releasing the guids again", func() {
+			client.On("PatchPod", pod, types.StrategicMergePatchType, mock.MatchedBy(isRemovePatch)).Return(
+				errors.New("api server unavailable")).Once()
+			client.On("PatchPod", pod, types.StrategicMergePatchType, mock.Anything).Return(nil)
+			Expect(d.guidPool.AllocateGUID("pod-uid", "default", "test", "02:00:00:00:00:00:00:01")).To(Succeed())
+			pod.Annotations[v1.NetworkAttachmentAnnot] = `[{"name":"test","namespace":"default",` +
+				`"cni-args":{"guid":"02:00:00:00:00:00:00:01","mellanox.infiniband.app":"configured"}}]`
+
+			deletePod()
+			d.DeletePeriodicUpdate()
+			Expect(d.guidPool.GetAllocations()).To(BeEmpty())
+			Expect(d.pendingFinalizers).To(HaveKey(types.UID("pod-uid")))
+
+			d.DeletePeriodicUpdate()
+			Expect(d.pendingFinalizers).To(BeEmpty())
+			Expect(smClient.removed[0x10]).To(HaveLen(1))
+			Expect(patches()).To(HaveLen(2))
+		})
+	})
+	Context("limitToPKeyCapacity", func() {
+		newPods := func(count int) ([]*kapi.Pod, []net.HardwareAddr) {
+			var pods []*kapi.Pod
+			var guidList []net.HardwareAddr
+			for index := 0; index < count; index++ {
+				pods = append(pods, &kapi.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(fmt.Sprint(index))}})
+				guidList = append(guidList, guid.GUID(0x0200000000000000+index).HardWareAddress())
+			}
+			return pods, guidList
+		}
+		newDaemon := func(stats plugins.PKeyStats) *daemon {
+			return &daemon{
+				config: config.DaemonConfig{
+					MaxGUIDsPerPKey: 10, PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95},
+				smClient: &countingSMClient{stats: stats}}
+		}
+		It("Return the remaining capacity of the pkey", func() {
+			pods, guidList := newPods(3)
+			passedPods, passedGUIDs, failedPods, capacity, err := newDaemon(plugins.PKeyStats{MemberCount: 8}).
+				limitToPKeyCapacity(0x10, pods, guidList, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capacity).To(Equal(2))
+			Expect(passedPods).To(Equal(pods))
+			Expect(passedGUIDs).To(Equal(guidList))
+			Expect(failedPods).To(BeEmpty())
+		})
+		It("Return the remaining capacity of the pkey reported by the subnet manager", func() {
+			pods, guidList := newPods(3)
+			_, _, _, capacity, err := newDaemon(plugins.PKeyStats{MemberCount: 2, MaxMembers: 4}).
+				limitToPKeyCapacity(0x10, pods, guidList, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capacity).To(Equal(2))
+		})
+		It("Return the remaining capacity of the counted members if the usage stats fail", func() {
+			pods, guidList := newPods(3)
+			d := &daemon{config: config.DaemonConfig{
+				MaxGUIDsPerPKey: 10, PKeyUsageWarningPercent: 80, PKeyUsageBlockPercent: 95},
+				smClient: &countingSMClient{statsErr: errors.New("unsupported"), members: map[int][]net.HardwareAddr{
+					0x10: make([]net.HardwareAddr, 8)}}}
+			_, _, _, capacity, err := d.limitToPKeyCapacity(0x10, pods, guidList, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capacity).To(Equal(2))
+		})
+		It("Retry the pods of a full pkey", func() {
+			pods, guidList := newPods(1)
+			passedPods, _, failedPods, capacity, err := newDaemon(plugins.PKeyStats{MemberCount: 4, MaxMembers: 4}).
+				limitToPKeyCapacity(0x10, pods, guidList, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capacity).To(BeZero())
+			Expect(passedPods).To(BeEmpty())
+			Expect(failedPods).To(Equal(pods))
+		})
+		It("Block guids of pkey above block percent", func() {
+			pods, guidList := newPods(1)
+			passedPods, passedGUIDs, failedPods, _, err := newDaemon(
+				plugins.PKeyStats{MemberCount: 96, MaxMembers: 100}).limitToPKeyCapacity(0x10, pods, guidList, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(passedPods).To(BeEmpty())
+			Expect(passedGUIDs).To(BeEmpty())
+			Expect(failedPods).To(HaveLen(1))
+		})
+		It("Add the guids in chunks of the remaining capacity of the pkey", func() {
+			_, guidList := newPods(5)
+			smClient := &countingSMClient{stats: plugins.PKeyStats{MaxMembers: 2},
+				added: map[int][]net.HardwareAddr{}}
+			d := &daemon{config: config.DaemonConfig{MaxGUIDsPerPKey: 10}, smClient: smClient}
+			added, failedIndexes, err := d.addGuidsToPKeyInCapacityChunks(0x10, plugins.MembershipFull, guidList, 2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(added).To(Equal(5))
+			Expect(failedIndexes).To(BeEmpty())
+			Expect(smClient.added[0x10]).To(Equal(guidList))
+			// 3 chunks and the capacity checks between them
+			Expect(smClient.calls).To(Equal(5))
+		})
+		It("Stop adding the chunks once the pkey is full", func() {
+			_, guidList := newPods(5)
+			smClient := &countingSMClient{stats: plugins.PKeyStats{MemberCount: 4, MaxMembers: 4},
+				added: map[int][]net.HardwareAddr{}, addErrs: []error{errors.New("unreachable")}}
+			d := &daemon{config: config.DaemonConfig{MaxGUIDsPerPKey: 10}, smClient: smClient}
+			added, failedIndexes, err := d.addGuidsToPKeyInCapacityChunks(0x10, plugins.MembershipFull, guidList, 2)
+			Expect(err).To(HaveOccurred())
+			Expect(added).To(Equal(2))
+			Expect(failedIndexes).To(Equal(map[int]bool{0: true, 1: true}))
+		})
+		It("Retry the pods beyond the pkey capacity with all their guids", func() {
+			pods, guidList := newPods(4)
+			pods[2] = pods[1]
+			passedPods, passedGUIDs, failedPods, failedIndexes := excludePodsBeyondCapacity(pods, guidList, nil,
+				map[int]bool{0: true}, 2)
+			Expect(passedPods).To(Equal(pods[:1]))
+			Expect(passedGUIDs).To(Equal(guidList[:1]))
+			Expect(failedPods).To(Equal([]*kapi.Pod{pods[1], pods[3]}))
+			Expect(failedIndexes).To(Equal(map[int]bool{0: true}))
+		})
+	})
+	Context("setIBReadyConditions", func() {
+		It("Set InfiniBand ready condition of pods without pending networks", func() {
+			readyPod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ready", UID: "ready"}}
+			pendingPod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pending", UID: "pending"}}
+			client := &k8sClientMock.Client{}
+			client.On("SetPodCondition", readyPod, mock.MatchedBy(func(condition kapi.PodCondition) bool {
+				return condition.Type == utils.IBReadyConditionType && condition.Status == kapi.ConditionTrue
+			})).Return(nil)
+			d := &daemon{kubeClient: client}
+
+			addMap := utils.NewSynchronizedMap()
+			addMap.Set("default_other", []*kapi.Pod{pendingPod})
+			d.setIBReadyConditions(addMap, map[types.UID]*kapi.Pod{"ready": readyPod, "pending": pendingPod})
+			client.AssertNumberOfCalls(GinkgoT(), "SetPodCondition", 1)
+			client.AssertExpectations(GinkgoT())
+		})
+	})
+	Context("evictIdleGUIDs", func() {
+		It("Evict idle guids and add back resumed guids", func() {
+			idleGUID, _ := net.ParseMAC("02:00:00:00:00:00:00:01")
+			activeGUID, _ := net.ParseMAC("02:00:00:00:00:00:00:02")
+			smClient := &countingSMClient{removed: map[int][]net.HardwareAddr{}, added: map[int][]net.HardwareAddr{},
+				activity: map[string]time.Time{activeGUID.String(): time.Now()}}
+			d := &daemon{config: config.DaemonConfig{IdleGUIDEvictionTimeout: 60}, smClient: smClient,
+				idleGUIDs: newIdleGUIDTracker()}
+			d.trackIdleGUID("0x10", "", idleGUID)
+			d.trackIdleGUID("0x10", "", activeGUID)
+			// the idle guid was added to the pKey before the timeout without activity since
+			d.idleGUIDs.guids[idleGUID.String()].since = time.Now().Add(-2 * time.Minute)
+
+			d.evictIdleGUIDs()
+			Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{0x10: {idleGUID}}))
+			Expect(smClient.added).To(BeEmpty())
+
+			// already evicted guids aren't removed again
+			d.evictIdleGUIDs()
+			Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{0x10: {idleGUID}}))
+
+			smClient.activity[idleGUID.String()] = time.Now()
+			d.evictIdleGUIDs()
+			Expect(smClient.added).To(Equal(map[int][]net.HardwareAddr{0x10: {idleGUID}}))
+			Expect(d.idleGUIDs.guids[idleGUID.String()].evicted).To(BeFalse())
+		})
+		It("Released guids aren't evicted", func() {
+			guidAddr, _ := net.ParseMAC("02:00:00:00:00:00:00:01")
+			smClient := &countingSMClient{}
+			d := &daemon{config: config.DaemonConfig{IdleGUIDEvictionTimeout: 60}, smClient: smClient,
+				idleGUIDs: newIdleGUIDTracker()}
+			d.trackIdleGUID("0x10", "", guidAddr)
+			d.untrackIdleGUID(guidAddr)
+
+			d.evictIdleGUIDs()
+			Expect(smClient.calls).To(Equal(0))
+		})
+		It("Don't mark guids tracked again during their eviction as evicted", func() {
+			guidAddr, _ := net.ParseMAC("02:00:00:00:00:00:00:01")
+			d := &daemon{config: config.DaemonConfig{IdleGUIDEvictionTimeout: 60}, idleGUIDs: newIdleGUIDTracker()}
+			// the guid is released and allocated again while it is removed from the pKey
+			d.smClient = &removeHookSMClient{countingSMClient: &countingSMClient{}, onRemove: func() {
+				d.untrackIdleGUID(guidAddr)
+				d.trackIdleGUID("0x10", "", guidAddr)
+			}}
+			d.trackIdleGUID("0x10", "", guidAddr)
+			d.idleGUIDs.guids[guidAddr.String()].since = time.Now().Add(-2 * time.Minute)
+
+			d.evictIdleGUIDs()
+			Expect(d.isIdleGUIDEvicted(guidAddr)).To(BeFalse())
+		})
+		It("Track guids with idle guid eviction disabled", func() {
+			guidAddr, _ := net.ParseMAC("02:00:00:00:00:00:00:01")
+			d := &daemon{}
+			d.trackIdleGUID("0x10", "", guidAddr)
+			d.untrackIdleGUID(guidAddr)
+			Expect(d.idleGUIDs).To(BeNil())
+		})
+	})
+	Context("networkRateLimiter", func() {
+		It("Limit subnet manager calls per network", func() {
+			now := time.Now()
+			var slept time.Duration
+			limiter := newNetworkRateLimiter()
+			limiter.now = func() time.Time { return now }
+			limiter.sleep = func(duration time.Duration) { slept += duration }
+
+			// the bucket holds one second of tokens
+			for index := 0; index < 10; index++ {
+				Expect(limiter.acquire("default/busy", 10, 100*time.Millisecond)).To(BeTrue())
+			}
+			Expect(slept).To(BeZero())
+
+			// the next token is available in 100ms
+			Expect(limiter.acquire("default/busy", 10, 100*time.Millisecond)).To(BeTrue())
+			Expect(slept).To(Equal(100 * time.Millisecond))
+			Expect(limiter.acquire("default/busy", 10, 100*time.Millisecond)).To(BeFalse())
+
+			// other networks aren't limited by the busy network
+			Expect(limiter.acquire("default/other", 10, 100*time.Millisecond)).To(BeTrue())
+
+			now = now.Add(time.Second)
+			Expect(limiter.acquire("default/busy", 10, 100*time.Millisecond)).To(BeTrue())
+		})
+		It("Acquire token without rate limiter", func() {
+			d := &daemon{config: config.DaemonConfig{MaxSMCallsPerNetworkPerSecond: 10}}
+			Expect(d.acquireSMCallToken("default", "test")).To(BeTrue())
+		})
+	})
+	Context("checkNamespaceIsolation", func() {
+		var d *daemon
+		BeforeEach(func() {
+			d = newTestDaemon(testDaemonOptions{
+				smClient: &countingSMClient{members: map[int][]net.HardwareAddr{
+					0x10: {guid.GUID(0x0200000000000001).HardWareAddress()}}},
+			})
+			Expect(d.guidPool.AllocateGUID("pod1", "foo", "test", "02:00:00:00:00:00:00:01")).To(Succeed())
+		})
+		It("Allow pods of the pKey members namespace", func() {
+			pods := []*kapi.Pod{{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "pod2"}}}
+			Expect(d.checkNamespaceIsolation(0x10, pods)).To(Succeed())
+			Expect(d.checkNamespaceIsolation(0x20, pods)).To(Succeed())
+		})
+		It("Reject pods of other namespace than the pKey members", func() {
+			pods := []*kapi.Pod{{ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "pod2"}}}
+			err := d.checkNamespaceIsolation(0x10, pods)
+			Expect(errors.Is(err, ErrNamespaceIsolationViolation)).To(BeTrue())
+		})
+		It("Reject pods of different namespaces", func() {
+			pods := []*kapi.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "pod2"}},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "pod3"}}}
+			err := d.checkNamespaceIsolation(0x20, pods)
+			Expect(errors.Is(err, ErrNamespaceIsolationViolation)).To(BeTrue())
+		})
+	})
+	Context("rejectUserGUIDsInUse", func() {
+		It("Reject new user allocated guids which are members of the known pKeys", func() {
+			client := &k8sClientMock.Client{}
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, guidAllocationFailedReason,
+				mock.Anything).Return(nil)
+			d := newTestDaemon(testDaemonOptions{
+				kubeClient: client,
+				smClient: &countingSMClient{members: map[int][]net.HardwareAddr{
+					0x10: {guid.GUID(0x0200000000000002).HardWareAddress()},
+					0x20: {guid.GUID(0x0200000000000003).HardWareAddress()}}},
+				guidPodNetworkMap: map[string]string{"02:00:00:00:00:00:00:02": "pod2foo_test",
+					"02:00:00:00:00:00:00:03": "pod3foo_test", "02:00:00:00:00:00:00:04": "pod4foo_test"},
+			})
+			guidPool := d.guidPool
+			Expect(guidPool.AllocateGUID("pod1", "foo", "other", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(guidPool.SetGUIDPKey("02:00:00:00:00:00:00:01", "0x20")).To(Succeed())
+			Expect(guidPool.AllocateGUID("pod2", "foo", "test", "02:00:00:00:00:00:00:02")).To(Succeed())
+			Expect(guidPool.AllocateGUID("pod3", "foo", "test", "02:00:00:00:00:00:00:03")).To(Succeed())
+			Expect(guidPool.AllocateGUID("pod4", "foo", "test", "02:00:00:00:00:00:00:04")).To(Succeed())
+
+			var pods []*kapi.Pod
+			var guidList []net.HardwareAddr
+			for index := 2; index <= 4; index++ {
+				name := fmt.Sprintf("pod%d", index)
+				pods = append(pods, &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: name,
+					UID: types.UID(name)}})
+				guidList = append(guidList, guid.GUID(0x0200000000000000+index).HardWareAddress())
+			}
+			userGUIDs := map[string]string{guidList[0].String(): "02:00:00:00:00:00:00:02",
+				guidList[1].String(): "02:00:00:00:00:00:00:03"}
+
+			passedPods, passedGUIDs, failedPods := d.rejectUserGUIDsInUse(guidPool, 0x10, userGUIDs, pods, guidList,
+				nil)
+			Expect(passedPods).To(Equal(pods[2:]))
+			Expect(passedGUIDs).To(Equal(guidList[2:]))
+			Expect(failedPods).To(Equal(pods[:2]))
+			Expect(guidPool.GetAllocations()).To(HaveLen(2))
+			Expect(d.guidPodNetworkMap).To(HaveLen(1))
+			client.AssertNumberOfCalls(GinkgoT(), "CreatePodEvent", 2)
+		})
+	})
+	Context("pKey reservations", func() {
+		var (
+			d      *daemon
+			client *k8sClientMock.Client
+		)
+		BeforeEach(func() {
+			client = &k8sClientMock.Client{}
+			client.On("GetPKeyReservations").Return(&ibapi.PKeyReservationList{Items: []ibapi.PKeyReservation{
+				{ObjectMeta: metav1.ObjectMeta{Name: "foo-0x10"},
+					Spec: ibapi.PKeyReservationSpec{PKey: "0x10", Namespace: "foo", MaxSeats: 2}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "bar-0x20"},
+					Spec: ibapi.PKeyReservationSpec{PKey: "0x20", Namespace: "bar", MaxSeats: 1}}}}, nil)
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeWarning, pKeyReservationReason,
+				mock.Anything).Return(nil)
+			d = newTestDaemon(testDaemonOptions{kubeClient: client})
+			Expect(d.guidPool.AllocateGUID("pod1", "foo", "test", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(d.guidPool.SetGUIDPKey("02:00:00:00:00:00:00:01", "0x10")).To(Succeed())
+			Expect(d.guidPool.AllocateGUID("pod5", "foo", "other", "02:00:00:00:00:00:00:05")).To(Succeed())
+			Expect(d.guidPool.SetGUIDPKey("02:00:00:00:00:00:00:05", "0x20")).To(Succeed())
+		})
+		It("Limit guids to the free seats of the namespaces reservations", func() {
+			pods := []*kapi.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "pod2"}},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "pod3"}},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "pod4"}}}
+			guids := []net.HardwareAddr{guid.GUID(0x0200000000000002).HardWareAddress(),
+				guid.GUID(0x0200000000000003).HardWareAddress(), guid.GUID(0x0200000000000004).HardWareAddress()}
+
+			passedPods, guidList, failedPods, err := d.limitToPKeyReservations(0x10, pods, guids, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(passedPods).To(Equal([]*kapi.Pod{pods[0], pods[2]}))
+			Expect(guidList).To(Equal([]net.HardwareAddr{guids[0], guids[2]}))
+			Expect(failedPods).To(Equal([]*kapi.Pod{pods[1]}))
+			client.AssertNumberOfCalls(GinkgoT(), "CreatePodEvent", 1)
+		})
+		It("Update current seats of the reservations", func() {
+			client.On("UpdatePKeyReservationStatus", mock.Anything).Return(nil, nil)
+
+			d.updatePKeyReservationsStatus()
+			client.AssertNumberOfCalls(GinkgoT(), "UpdatePKeyReservationStatus", 1)
+			updated := client.Calls[len(client.Calls)-1].Arguments.Get(0).(*ibapi.PKeyReservation)
+			Expect(updated.Name).To(Equal("foo-0x10"))
+			Expect(updated.Status.CurrentSeats).To(Equal(1))
+		})
+	})
+	Context("loadSMClient", func() {
+		It("Load the built-in noop plugin", func() {
+			smClient, err := loadSMClient(plugins.NoopPluginName, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(smClient.Name()).To(Equal(plugins.NoopPluginName))
+		})
+		It("Initialize the built-in ufm plugin without loading a plugin file", func() {
+			Expect(os.Unsetenv("UFM_ADDRESS")).To(Succeed())
+			_, err := loadSMClient(ufm.PluginName, "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("missing one or more required"))
+		})
+		It("Initialize the plugin with its configuration file", func() {
+			confDir, err := ioutil.TempDir("", "plugin-conf")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(confDir)
+			confPath := filepath.Join(confDir, "plugin.conf")
+			Expect(ioutil.WriteFile(confPath, []byte("token: secret"), 0600)).To(Succeed())
+
+			configurable := &configurableSMClient{}
+			builtInPlugins["configurable"] = func() (plugins.SubnetManagerClient, error) { return configurable, nil }
+			defer delete(builtInPlugins, "configurable")
+
+			_, err = loadSMClient("configurable", confPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configurable.conf).To(Equal([]byte("token: secret")))
+
+			configurable.initErr = errors.New("invalid token")
+			_, err = loadSMClient("configurable", confPath)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Load the plugin without initializing it if its configuration file doesn't exist", func() {
+			configurable := &configurableSMClient{}
+			builtInPlugins["configurable"] = func() (plugins.SubnetManagerClient, error) { return configurable, nil }
+			defer delete(builtInPlugins, "configurable")
+
+			_, err := loadSMClient("configurable", "/not/existing/plugin.conf")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configurable.conf).To(BeNil())
+		})
+		It("Retry loading the plugin until the startup timeout", func() {
+			defer func(backoff time.Duration) { smStartupBackoff = backoff }(smStartupBackoff)
+			smStartupBackoff = time.Millisecond
+			var attempts int
+			builtInPlugins["flaky"] = func() (plugins.SubnetManagerClient, error) {
+				attempts++
+				if attempts < 3 {
+					return nil, errors.New("subnet manager unreachable")
+				}
+				return &countingSMClient{}, nil
+			}
+			defer delete(builtInPlugins, "flaky")
+
+			smClient, err := loadSMClientWithRetry("flaky", "", time.Second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(smClient.Name()).To(Equal("counting"))
+			Expect(attempts).To(Equal(3))
+
+			attempts = 0
+			_, err = loadSMClientWithRetry("flaky", "", 0)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("subnet manager unreachable"))
+			Expect(attempts).To(Equal(1))
+		})
+		It("Use the noop plugin without loading the plugin when not managing the subnet manager", func() {
+			smClient, err := newSMClient(&config.DaemonConfig{Plugin: "not-existing"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(smClient.Name()).To(Equal(plugins.NoopPluginName))
+
+			_, err = newSMClient(&config.DaemonConfig{ManageSubnetManager: true, Plugin: "not-existing"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("CleanSMOnStartup", func() {
+		It("Remove guids of deleted pods from the network attachment definitions pKeys", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinitions", kapi.NamespaceAll).Return(
+				&v1.NetworkAttachmentDefinitionList{Items: []v1.NetworkAttachmentDefinition{
+					{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ib"},
+						Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "ib-sriov", "pkey": "0x10"}`}},
+					{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "bridge"},
+						Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "bridge"}`}}}}, nil)
+			client.On("GetPods", kapi.NamespaceAll).Return(&kapi.PodList{Items: []kapi.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", Annotations: map[string]string{
+					v1.NetworkAttachmentAnnot: `[{"name":"ib","cni-args":{"guid":"02:00:00:00:00:00:00:01",` +
+						`"mellanox.infiniband.app":"configured"}}]`}}}}}, nil)
+
+			livePodGUID := guid.GUID(0x0200000000000001).HardWareAddress()
+			staleGUID := guid.GUID(0x0200000000000002).HardWareAddress()
+			outOfRangeGUID := guid.GUID(0x0300000000000001).HardWareAddress()
+			smClient := &countingSMClient{
+				members: map[int][]net.HardwareAddr{0x10: {livePodGUID, staleGUID, outOfRangeGUID}},
+				removed: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{kubeClient: client, smClient: smClient})
+
+			Expect(d.CleanSMOnStartup(context.Background())).To(Succeed())
+			Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{0x10: {staleGUID}}))
+		})
+	})
+	Context("SMReconcilePeriodicUpdate", func() {
+		It("Add the guids of configured pods missing from their pKeys back", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinitions", kapi.NamespaceAll).Return(
+				&v1.NetworkAttachmentDefinitionList{Items: []v1.NetworkAttachmentDefinition{
+					{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ib"},
+						Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}}}, nil)
+			client.On("GetPods", kapi.NamespaceAll).Return(&kapi.PodList{Items: []kapi.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "member", UID: "member-uid",
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"ib","cni-args":{` +
+						`"guid":"02:00:00:00:00:00:00:01","mellanox.infiniband.app":"configured"}}]`}}},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "missing", UID: "missing-uid",
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"ib","cni-args":{` +
+						`"guid":"02:00:00:00:00:00:00:02","mellanox.infiniband.app":"configured"}}]`}}},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deleted", UID: "deleted-uid",
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"ib","cni-args":{` +
+						`"guid":"02:00:00:00:00:00:00:03","mellanox.infiniband.app":"configured"}}]`}}},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pending", UID: "pending-uid",
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"ib","cni-args":{` +
+						`"guid":"02:00:00:00:00:00:00:04"}}]`}}}}}, nil)
+
+			memberGUID := guid.GUID(0x0200000000000001).HardWareAddress()
+			smClient := &countingSMClient{members: map[int][]net.HardwareAddr{0x10: {memberGUID}},
+				added: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{kubeClient: client, smClient: smClient})
+			// the guid of the deleted pod pending in the delete map is left to the delete update
+			_, deleteMap := d.watcher.GetHandler().GetResults()
+			deleteMap.Set("default_ib", []*kapi.Pod{{ObjectMeta: metav1.ObjectMeta{Namespace: "default",
+				Name: "deleted", UID: "deleted-uid"}}})
+
+			d.SMReconcilePeriodicUpdate()
+			Expect(smClient.added).To(Equal(map[int][]net.HardwareAddr{
+				0x10: {guid.GUID(0x0200000000000002).HardWareAddress()}}))
+		})
+	})
+	Context("ReconcileOrphanedGUIDs", func() {
+		It("Reclaim guids of deleted pods", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetPods", kapi.NamespaceAll).Return(&kapi.PodList{Items: []kapi.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid"}}}}, nil)
+			smClient := &countingSMClient{removed: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{kubeClient: client, smClient: smClient,
+				guidPodNetworkMap: map[string]string{"02:00:00:00:00:00:00:02": "deleted-uidib"}})
+
+			guidPool := d.guidPool
+			Expect(guidPool.AllocateGUID("pod-uid", "default", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(guidPool.AllocateGUID("deleted-uid", "default", "ib", "02:00:00:00:00:00:00:02")).To(Succeed())
+			Expect(guidPool.AllocateGUID("deleted-uid", "default", "eth", "02:00:00:00:00:00:00:03")).To(Succeed())
+			Expect(guidPool.SetGUIDPKey("02:00:00:00:00:00:00:02", "0x10")).To(Succeed())
+			// guid ranges of network attachment definitions have no namespace
+			_, _, err := guidPool.AllocateGUIDRange("nad-uid", "nad", 16)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(d.ReconcileOrphanedGUIDs()).To(Succeed())
+			Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{
+				0x10: {guid.GUID(0x0200000000000002).HardWareAddress()}}))
+			Expect(d.guidPodNetworkMap).To(BeEmpty())
+			allocated := map[guid.GUID]types.UID{}
+			for _, allocation := range guidPool.GetAllocations() {
+				allocated[allocation.GUID] = allocation.PodUID
+			}
+			Expect(allocated).To(HaveLen(17))
+			Expect(allocated).To(HaveKeyWithValue(guid.GUID(0x0200000000000001), types.UID("pod-uid")))
+			Expect(allocated).ToNot(HaveKey(guid.GUID(0x0200000000000002)))
+			Expect(allocated).ToNot(HaveKey(guid.GUID(0x0200000000000003)))
+		})
+	})
+	Context("OrphanedGUIDsPeriodicUpdate", func() {
+		It("Keep guids of deleted pods pending in the delete map", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetPods", kapi.NamespaceAll).Return(&kapi.PodList{}, nil)
+			d := newTestDaemon(testDaemonOptions{
+				kubeClient: client,
+				smClient:   &countingSMClient{},
+			})
+			Expect(d.guidPool.AllocateGUID("pending-uid", "default", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(d.guidPool.AllocateGUID("deleted-uid", "default", "ib", "02:00:00:00:00:00:00:02")).To(Succeed())
+
+			_, deleteMap := d.watcher.GetHandler().GetResults()
+			deleteMap.Set("default_ib", []*kapi.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pending", UID: "pending-uid"}}})
+
+			d.OrphanedGUIDsPeriodicUpdate()
+			allocations := d.guidPool.GetAllocations()
+			Expect(allocations).To(HaveLen(1))
+			Expect(allocations[0].PodUID).To(Equal(types.UID("pending-uid")))
+		})
+	})
+	Context("namespace deletion", func() {
+		It("Release guids of deleted namespace on delete periodic update", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetPods", "deleted").Return(&kapi.PodList{}, nil)
+			smClient := &countingSMClient{removed: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{kubeClient: client, smClient: smClient,
+				guidPodNetworkMap: map[string]string{
+					"02:00:00:00:00:00:00:01": "pod-uidib", "02:00:00:00:00:00:00:03": "other-uidib"}})
+			guidPool := d.guidPool
+			Expect(guidPool.AllocateGUID("pod-uid", "deleted", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(guidPool.AllocateGUID("pod2-uid", "deleted", "ib", "02:00:00:00:00:00:00:02")).To(Succeed())
+			Expect(guidPool.AllocateGUID("other-uid", "other", "ib", "02:00:00:00:00:00:00:03")).To(Succeed())
+			Expect(guidPool.SetGUIDPKey("02:00:00:00:00:00:00:01", "0x10")).To(Succeed())
+
+			_, deleteMap := d.watcher.GetHandler().GetResults()
+			deleteMap.Set("deleted_ib", []*kapi.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "deleted", Name: "pod", UID: "pod-uid"}}})
+			namespaceEventHandler := resEvenHandler.NewNamespaceEventHandler()
+			namespaceEventHandler.OnDelete(&kapi.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "deleted"}})
+			d.namespaceWatcher = &fakeWatcher{eventHandler: namespaceEventHandler}
+
+			d.DeletePeriodicUpdate()
+			Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{
+				0x10: {guid.GUID(0x0200000000000001).HardWareAddress()}}))
+			Expect(guidPool.GetNamespaceUsage("deleted")).To(Equal(0))
+			Expect(guidPool.GetNamespaceUsage("other")).To(Equal(1))
+			Expect(d.guidPodNetworkMap).To(Equal(map[string]string{"02:00:00:00:00:00:00:03": "other-uidib"}))
+			Expect(deleteMap.Items).To(BeEmpty())
+			_, deletedNamespaces := namespaceEventHandler.GetResults()
+			Expect(deletedNamespaces.Items).To(BeEmpty())
+		})
+		It("Keep guids of the pods of recreated namespace", func() {
+			recreatedPod := kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "deleted", Name: "pod", UID: "new-uid"}}
+			client := &k8sClientMock.Client{}
+			client.On("GetPods", "deleted").Return(&kapi.PodList{Items: []kapi.Pod{recreatedPod}}, nil)
+			smClient := &countingSMClient{removed: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{kubeClient: client, smClient: smClient,
+				guidPodNetworkMap: map[string]string{
+					"02:00:00:00:00:00:00:01": "pod-uidib", "02:00:00:00:00:00:00:02": "new-uidib"}})
+			guidPool := d.guidPool
+			Expect(guidPool.ReserveGUID("new-uid", "deleted", "pod", "02:00:00:00:00:00:00:02")).To(Succeed())
+			Expect(guidPool.AllocateGUID("pod-uid", "deleted", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(guidPool.AllocateGUID("new-uid", "deleted", "ib", "02:00:00:00:00:00:00:02")).To(Succeed())
+			Expect(guidPool.SetGUIDPKey("02:00:00:00:00:00:00:01", "0x10")).To(Succeed())
+			Expect(guidPool.SetGUIDPKey("02:00:00:00:00:00:00:02", "0x10")).To(Succeed())
+
+			namespaceEventHandler := resEvenHandler.NewNamespaceEventHandler()
+			namespaceEventHandler.OnDelete(&kapi.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "deleted"}})
+			d.namespaceWatcher = &fakeWatcher{eventHandler: namespaceEventHandler}
+
+			d.DeletePeriodicUpdate()
+			Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{
+				0x10: {guid.GUID(0x0200000000000001).HardWareAddress()}}))
+			Expect(guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(guidPool.GetAllocations()[0].PodUID).To(Equal(types.UID("new-uid")))
+			Expect(guidPool.GetReservations()).To(HaveLen(1))
+			Expect(d.guidPodNetworkMap).To(Equal(map[string]string{"02:00:00:00:00:00:00:02": "new-uidib"}))
+			_, deletedNamespaces := namespaceEventHandler.GetResults()
+			Expect(deletedNamespaces.Items).To(BeEmpty())
+		})
+	})
+	Context("networkSpecCache", func() {
+		newNetAttDef := func(resourceVersion, config string) *v1.NetworkAttachmentDefinition {
+			return &v1.NetworkAttachmentDefinition{ObjectMeta: metav1.ObjectMeta{ResourceVersion: resourceVersion},
+				Spec: v1.NetworkAttachmentDefinitionSpec{Config: config}}
+		}
+		It("Parse the network spec again only when its resource version changes", func() {
+			d := &daemon{networkSpecs: newNetworkSpecCache(networkSpecCacheSize)}
+			ibCniSpec, err := d.getIbSriovCniSpec("default", "test", newNetAttDef("1",
+				`{"type": "ib-sriov", "pkey": "0x10"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ibCniSpec.PKey).To(Equal("0x10"))
+			// the returned spec is a copy of the cached spec
+			ibCniSpec.PKey = "0x20"
+
+			ibCniSpec, err = d.getIbSriovCniSpec("default", "test", newNetAttDef("1", `invalid`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ibCniSpec.PKey).To(Equal("0x10"))
+
+			ibCniSpec, err = d.getIbSriovCniSpec("default", "test", newNetAttDef("2",
+				`{"type": "ib-sriov", "pkey": "0x30"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ibCniSpec.PKey).To(Equal("0x30"))
+
+			_, err = d.getIbSriovCniSpec("default", "test", newNetAttDef("3", `invalid`))
+			Expect(errors.Is(err, errInvalidNetworkConfig)).To(BeTrue())
+			_, err = d.getIbSriovCniSpec("default", "test", newNetAttDef("4", `{"type": "bridge"}`))
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, errInvalidNetworkConfig)).To(BeFalse())
+		})
+		It("Evict the least recently used network spec", func() {
+			cache := newNetworkSpecCache(2)
+			cache.add("default", "net-1", "1", &utils.IbSriovCniSpec{PKey: "0x1"})
+			cache.add("default", "net-2", "1", &utils.IbSriovCniSpec{PKey: "0x2"})
+			_, ok := cache.get("default", "net-1", "1")
+			Expect(ok).To(BeTrue())
+			cache.add("default", "net-3", "1", &utils.IbSriovCniSpec{PKey: "0x3"})
+
+			_, ok = cache.get("default", "net-2", "1")
+			Expect(ok).To(BeFalse())
+			for _, name := range []string{"net-1", "net-3"} {
+				_, ok = cache.get("default", name, "1")
+				Expect(ok).To(BeTrue(), name)
+			}
+			Expect(cache.recent.Len()).To(Equal(2))
+		})
+		It("Don't cache network spec of the cni config ConfigMap", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetConfigMap", "default", "ib-conf").Return(&kapi.ConfigMap{Data: map[string]string{
+				"ib.conf": `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			d := &daemon{kubeClient: client, networkSpecs: newNetworkSpecCache(networkSpecCacheSize)}
+			netAttDef := newNetAttDef("1", "")
+			netAttDef.Annotations = map[string]string{utils.CNIConfNameAnnotation: "ib-conf"}
+			for i := 0; i < 2; i++ {
+				ibCniSpec, err := d.getIbSriovCniSpec("default", "test", netAttDef)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ibCniSpec.PKey).To(Equal("0x10"))
+			}
+			client.AssertNumberOfCalls(GinkgoT(), "GetConfigMap", 2)
+		})
+		It("Retry the network whose cni config ConfigMap can't be read", func() {
+			netAttDef := newNetAttDef("1", "")
+			netAttDef.Annotations = map[string]string{utils.CNIConfNameAnnotation: "ib-conf"}
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(netAttDef, nil)
+			client.On("GetConfigMap", "default", "ib-conf").Return(nil, errors.New("timeout"))
+			d := newTestDaemon(testDaemonOptions{kubeClient: client})
+			_, err := d.getIbSriovCniSpec("default", "test", netAttDef)
+			Expect(errors.Is(err, errCNIConfigMapUnavailable)).To(BeTrue())
+
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_test", []*kapi.Pod{pod})
+			d.AddPeriodicUpdate()
+			Expect(addMap.Items).To(HaveKey("default_test"))
+		})
+	})
+	Context("deleted network attachment definitions", func() {
+		newPod := func(name string, uid types.UID, guidAddr string) *kapi.Pod {
+			return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: uid,
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default",` +
+					`"cni-args":{"guid":"` + guidAddr + `","mellanox.infiniband.app":"configured"}}]`}}}
+		}
+		notFoundClient := func() *k8sClientMock.Client {
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "test").Return(
+				nil, apiErrors.NewNotFound(kapi.Resource("network-attachment-definitions"), "test"))
+			return client
+		}
+		for _, removeErr := range []error{nil, errors.New("subnet manager unavailable")} {
+			removeErr := removeErr
+			It(fmt.Sprintf("Release guids of deleted pods whose network no longer exists, removal error %v",
+				removeErr), func() {
+				smClient := &countingSMClient{removed: map[int][]net.HardwareAddr{}, removeErrs: []error{removeErr}}
+				d := newTestDaemon(testDaemonOptions{kubeClient: notFoundClient(), smClient: smClient,
+					guidPodNetworkMap: map[string]string{"02:00:00:00:00:00:00:01": "pod-0-uiddefault_test",
+						"02:00:00:00:00:00:00:02": "pod-1-uiddefault_test"}})
+				Expect(d.guidPool.AllocateGUID("pod-0-uid", "default", "test", "02:00:00:00:00:00:00:01")).To(Succeed())
+				Expect(d.guidPool.SetGUIDPKey("02:00:00:00:00:00:00:01", "0x10")).To(Succeed())
+				// the pKey of the guid isn't known, it is only released
+				Expect(d.guidPool.AllocateGUID("pod-1-uid", "default", "test", "02:00:00:00:00:00:00:02")).To(Succeed())
+
+				_, deleteMap := d.watcher.GetHandler().GetResults()
+				deleteMap.Set("default_test", []*kapi.Pod{newPod("pod-0", "pod-0-uid", "02:00:00:00:00:00:00:01"),
+					newPod("pod-1", "pod-1-uid", "02:00:00:00:00:00:00:02")})
+
+				d.DeletePeriodicUpdate()
+				Expect(smClient.calls).To(Equal(1))
+				if removeErr == nil {
+					Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{
+						0x10: {guid.GUID(0x0200000000000001).HardWareAddress()}}))
+				}
+				Expect(d.guidPool.GetAllocations()).To(BeEmpty())
+				Expect(d.guidPodNetworkMap).To(BeEmpty())
+				Expect(deleteMap.Items).To(BeEmpty())
+			})
+		}
+		It("Retry added pods until their network attachment definition is created", func() {
+			d := newTestDaemon(testDaemonOptions{kubeClient: notFoundClient(), smClient: &countingSMClient{}})
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod-0", UID: "pod-0-uid",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default"}]`}}}
+			addMap.Set("default_test", []*kapi.Pod{pod})
+
+			d.AddPeriodicUpdate()
+			Expect(addMap.Items).To(HaveKeyWithValue("default_test", []*kapi.Pod{pod}))
+			Expect(d.guidPool.GetAllocations()).To(BeEmpty())
+		})
+	})
+	Context("guid pool persistence", func() {
+		poolConfig := config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:FF",
+			PersistenceBackend: config.PersistenceBackendConfigMap, PersistenceConfigMap: "kube-system/guid-pool"}
+		for _, format := range []string{guid.SerializationFormatJSON, guid.SerializationFormatBinary} {
+			format := format
+			// binary format keeps the pod uids only if they are uuids
+			inFlightPodUID := types.UID("7f1d7a2e-3a6b-4f0e-9b6e-2d5c8a1f0b11")
+			deletedPodUID := types.UID("0b5e6c3d-8f7a-4d2e-a1b9-6c4d2e8f9a10")
+			It("Restore persisted guid of pod without guid annotation in "+format+" format", func() {
+				daemonConfig := config.DaemonConfig{GUIDPool: poolConfig, PoolSerializationFormat: format}
+				var persisted map[string]string
+				client := &k8sClientMock.Client{}
+				client.On("SetConfigMapData", "kube-system", "guid-pool", mock.Anything).Return(nil).Run(
+					func(args mock.Arguments) { persisted = args.Get(2).(map[string]string) }).Once()
+				d := newTestDaemon(testDaemonOptions{config: daemonConfig, kubeClient: client})
+				d.poolPersistence = &guidPoolPersistence{}
+				guidPool := d.guidPool
+				Expect(guidPool.AllocateGUID(inFlightPodUID, "default", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+				Expect(guidPool.AllocateGUID(deletedPodUID, "default", "ib", "02:00:00:00:00:00:00:02")).To(Succeed())
+				Expect(guidPool.ReserveGUID(deletedPodUID, "default", "pod-1", "02:00:00:00:00:00:00:03")).To(Succeed())
+
+				d.persistGUIDPool()
+				// unchanged pool isn't written again
+				d.persistGUIDPool()
+				client.AssertExpectations(GinkgoT())
+				Expect(persisted).To(HaveKeyWithValue(guidPoolFormatKey, format))
+
+				client = &k8sClientMock.Client{}
+				client.On("GetPods", kapi.NamespaceAll).Return(&kapi.PodList{Items: []kapi.Pod{
+					{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: inFlightPodUID}}}}, nil)
+				client.On("GetConfigMap", "kube-system", "guid-pool").Return(&kapi.ConfigMap{Data: persisted}, nil)
+				d = newTestDaemon(testDaemonOptions{config: daemonConfig, kubeClient: client})
+				d.poolPersistence = &guidPoolPersistence{}
+				Expect(d.initPool()).To(Succeed())
+
+				restoredPool := d.guidPool
+
+				Expect(restoredPool.ValidateAllocation("other-uid", "default", "ib",
+					"02:00:00:00:00:00:00:01")).ToNot(Succeed())
+				// guids of deleted pods are restored only if their namespace is persisted, to reclaim them
+				Expect(restoredPool.ValidateAllocation("other-uid", "default", "ib",
+					"02:00:00:00:00:00:00:02") == nil).To(Equal(format == guid.SerializationFormatBinary))
+				// guid reservations are persisted only in the json format
+				Expect(restoredPool.GetReservations()).To(HaveLen(map[string]int{guid.SerializationFormatJSON: 1,
+					guid.SerializationFormatBinary: 0}[format]))
+			})
+		}
+		It("Start without persisted guids before the config map is created", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetPods", kapi.NamespaceAll).Return(&kapi.PodList{}, nil)
+			client.On("GetConfigMap", "kube-system", "guid-pool").Return(
+				nil, apiErrors.NewNotFound(kapi.Resource("configmaps"), "guid-pool"))
+			d := newTestDaemon(testDaemonOptions{config: config.DaemonConfig{GUIDPool: poolConfig}, kubeClient: client})
+			d.poolPersistence = &guidPoolPersistence{}
+			Expect(d.initPool()).To(Succeed())
+			Expect(d.guidPool.GetAllocations()).To(BeEmpty())
+		})
+	})
+	Context("pkey pool", func() {
+		pKeyPoolConfig := config.PKeyPoolConfig{RangeStart: "0x1000", RangeEnd: "0x10FF",
+			ConfigMap: "kube-system/pkey-pool"}
+		It("Assign and persist pKey of network without pKey", func() {
+			pKeyPool, err := pkey.NewPool(&pKeyPoolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			client := &k8sClientMock.Client{}
+			client.On("SetConfigMapData", "kube-system", "pkey-pool",
+				map[string]string{"default_ib": "0x1000"}).Return(nil).Once()
+			d := &daemon{config: config.DaemonConfig{PKeyPool: pKeyPoolConfig}, kubeClient: client,
+				pKeyPool: pKeyPool}
+
+			pKey, err := d.assignNetworkPKey("default_ib")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal("0x1000"))
+			// assigned pKeys aren't persisted again
+			pKey, err = d.assignNetworkPKey("default_ib")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal("0x1000"))
+			Expect(d.getNetworkPKey("default_ib", &utils.IbSriovCniSpec{})).To(Equal("0x1000"))
+			Expect(d.getNetworkPKey("default_ib", &utils.IbSriovCniSpec{PKey: "0x10"})).To(Equal("0x10"))
+			client.AssertExpectations(GinkgoT())
+		})
+		It("Release pKey which failed to be persisted", func() {
+			pKeyPool, err := pkey.NewPool(&pKeyPoolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			client := &k8sClientMock.Client{}
+			client.On("SetConfigMapData", "kube-system", "pkey-pool", mock.Anything).Return(errors.New("forbidden"))
+			d := &daemon{config: config.DaemonConfig{PKeyPool: pKeyPoolConfig}, kubeClient: client,
+				pKeyPool: pKeyPool}
+
+			_, err = d.assignNetworkPKey("default_ib")
+			Expect(err).To(HaveOccurred())
+			Expect(pKeyPool.Allocations()).To(BeEmpty())
+		})
+		It("Restore pKeys of existing networks on startup", func() {
+			pKeyPool, err := pkey.NewPool(&pKeyPoolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			client := &k8sClientMock.Client{}
+			client.On("GetConfigMap", "kube-system", "pkey-pool").Return(&kapi.ConfigMap{
+				Data: map[string]string{"default_ib": "0x1001", "default_deleted": "0x1000"}}, nil)
+			client.On("GetNetworkAttachmentDefinitions", kapi.NamespaceAll).Return(
+				&v1.NetworkAttachmentDefinitionList{Items: []v1.NetworkAttachmentDefinition{
+					{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ib"}}}}, nil)
+			client.On("SetConfigMapData", "kube-system", "pkey-pool",
+				map[string]string{"default_ib": "0x1001"}).Return(nil)
+			d := &daemon{config: config.DaemonConfig{PKeyPool: pKeyPoolConfig}, kubeClient: client,
+				pKeyPool: pKeyPool}
+
+			Expect(d.initPKeyPool()).To(Succeed())
+			Expect(pKeyPool.Allocations()).To(Equal(map[string]int{"default_ib": 0x1001}))
+			client.AssertExpectations(GinkgoT())
+		})
+		It("Start with empty pKey pool before the config map is created", func() {
+			pKeyPool, err := pkey.NewPool(&pKeyPoolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			client := &k8sClientMock.Client{}
+			client.On("GetConfigMap", "kube-system", "pkey-pool").Return(
+				nil, apiErrors.NewNotFound(kapi.Resource("configmaps"), "pkey-pool"))
+			d := &daemon{config: config.DaemonConfig{PKeyPool: pKeyPoolConfig}, kubeClient: client,
+				pKeyPool: pKeyPool}
+
+			Expect(d.initPKeyPool()).To(Succeed())
+			Expect(pKeyPool.Allocations()).To(BeEmpty())
+		})
+	})
+	Context("guid signature", func() {
+		It("Skip cleanup of tampered guids on delete periodic update", func() {
+			_, signingKey, err := ed25519.GenerateKey(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			newPod := func(name, uid, podGUID string) *kapi.Pod {
+				return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(uid),
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"ib","cni-args":{"guid":"` +
+						podGUID + `","mellanox.infiniband.app":"configured"}}]`}}}
+			}
+			pod := newPod("pod", "pod-uid", "02:00:00:00:00:00:00:01")
+			tamperedPod := newPod("tampered", "tampered-uid", "02:00:00:00:00:00:00:03")
+			Expect(utils.SignGUIDAnnotation(pod, "ib", "02:00:00:00:00:00:00:01", signingKey, time.Now())).To(Succeed())
+			Expect(utils.SignGUIDAnnotation(tamperedPod, "ib", "02:00:00:00:00:00:00:03", signingKey,
+				time.Now())).To(Succeed())
+			// claim the guid of another pod
+			tamperedPod.Annotations[v1.NetworkAttachmentAnnot] = `[{"name":"ib","cni-args":{` +
+				`"guid":"02:00:00:00:00:00:00:02","mellanox.infiniband.app":"configured"}}]`
+
+			client := &k8sClientMock.Client{}
+			client.On("GetNetworkAttachmentDefinition", "default", "ib").Return(&v1.NetworkAttachmentDefinition{
+				Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("CreatePodEvent", tamperedPod, kapi.EventTypeWarning, guidSignatureReason,
+				mock.Anything).Return(nil)
+			smClient := &countingSMClient{removed: map[int][]net.HardwareAddr{}}
+			d := newTestDaemon(testDaemonOptions{kubeClient: client, smClient: smClient,
+				guidPodNetworkMap: map[string]string{
+					"02:00:00:00:00:00:00:01": "pod-uiddefault_ib", "02:00:00:00:00:00:00:02": "victim-uiddefault_ib"}})
+			d.guidSigningKey = signingKey
+			Expect(d.guidPool.AllocateGUID("pod-uid", "default", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(d.guidPool.AllocateGUID("victim-uid", "default", "ib", "02:00:00:00:00:00:00:02")).To(Succeed())
+			_, deleteMap := d.watcher.GetHandler().GetResults()
+			deleteMap.Set("default_ib", []*kapi.Pod{pod, tamperedPod})
+
+			d.DeletePeriodicUpdate()
+			Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{
+				0x10: {guid.GUID(0x0200000000000001).HardWareAddress()}}))
+			Expect(d.guidPodNetworkMap).To(Equal(map[string]string{
+				"02:00:00:00:00:00:00:02": "victim-uiddefault_ib"}))
+			client.AssertNumberOfCalls(GinkgoT(), "CreatePodEvent", 1)
+			Expect(deleteMap.Items).To(BeEmpty())
+		})
+	})
+	Context("guid migration", func() {
+		var (
+			client         *k8sClientMock.Client
+			smClient       *countingSMClient
+			d              *daemon
+			migrationMap   *utils.SynchronizedMap
+			statusPhases   []ibapi.GUIDMigrationPhase
+			podAnnotations map[string]string
+		)
+		newMigration := func(podSelector map[string]string) *ibapi.GUIDMigration {
+			return &ibapi.GUIDMigration{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "migration"},
+				Spec: ibapi.GUIDMigrationSpec{PodSelector: metav1.LabelSelector{MatchLabels: podSelector},
+					NetworkName: "ib", NewGUID: "02:00:00:00:00:00:00:10"}}
+		}
+		BeforeEach(func() {
+			podAnnotations = map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"ib","namespace":"default",` +
+				`"cni-args":{"guid":"02:00:00:00:00:00:00:01","mellanox.infiniband.app":"configured"}}]`}
+			client = &k8sClientMock.Client{}
+			client.On("GetPods", "default").Return(&kapi.PodList{Items: []kapi.Pod{{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default", Name: "pod", UID: "pod-uid", Labels: map[string]string{"app": "ib"},
+				Annotations: podAnnotations}}}}, nil)
+			client.On("GetNetworkAttachmentDefinition", "default", "ib").Return(&v1.NetworkAttachmentDefinition{
+				Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+			client.On("SetAnnotationsOnPod", mock.Anything, mock.Anything).Return(nil)
+			statusPhases = nil
+			client.On("UpdateGUIDMigrationStatus", mock.Anything).Return(
+				func(migration *ibapi.GUIDMigration) *ibapi.GUIDMigration {
+					statusPhases = append(statusPhases, migration.Status.Phase)
+					return migration.DeepCopy()
+				}, nil)
+
+			smClient = &countingSMClient{added: map[int][]net.HardwareAddr{}, removed: map[int][]net.HardwareAddr{}}
+			migrationEventHandler := resEvenHandler.NewGUIDMigrationEventHandler()
+			migrationMap, _ = migrationEventHandler.GetResults()
+			d = newTestDaemon(testDaemonOptions{kubeClient: client, smClient: smClient,
+				guidPodNetworkMap: map[string]string{"02:00:00:00:00:00:00:01": "pod-uiddefault_ib"}})
+			d.migrationWatcher = &fakeWatcher{eventHandler: migrationEventHandler}
+			Expect(d.guidPool.AllocateGUID("pod-uid", "default", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+		})
+		It("Migrate pod network guid on guid migration periodic update", func() {
+			migrationMap.Set("default/migration", newMigration(map[string]string{"app": "ib"}))
+
+			d.GUIDMigrationPeriodicUpdate()
+			Expect(statusPhases).To(Equal([]ibapi.GUIDMigrationPhase{ibapi.GUIDMigrationAdding,
+				ibapi.GUIDMigrationVerifying, ibapi.GUIDMigrationRemoving, ibapi.GUIDMigrationComplete}))
+			Expect(smClient.added).To(Equal(map[int][]net.HardwareAddr{
+				0x10: {guid.GUID(0x0200000000000010).HardWareAddress()}}))
+			Expect(smClient.removed).To(Equal(map[int][]net.HardwareAddr{
+				0x10: {guid.GUID(0x0200000000000001).HardWareAddress()}}))
+			Expect(d.guidPool.GetAllocations()).To(Equal([]guid.Allocation{{GUID: 0x0200000000000010,
+				PodUID: "pod-uid", Namespace: "default", Network: "ib", PKey: "0x10"}}))
+			Expect(d.guidPodNetworkMap).To(Equal(map[string]string{"02:00:00:00:00:00:00:10": "pod-uiddefault_ib"}))
+			Expect(podAnnotations[v1.NetworkAttachmentAnnot]).To(ContainSubstring(`"guid":"02:00:00:00:00:00:00:10"`))
+			Expect(migrationMap.Items).To(BeEmpty())
+		})
+		It("Retry guid migration verification until the new guid is reachable", func() {
+			migrationMap.Set("default/migration", newMigration(map[string]string{"app": "ib"}))
+			smClient.pingErr = errors.New("unreachable")
+
+			d.GUIDMigrationPeriodicUpdate()
+			Expect(statusPhases).To(Equal([]ibapi.GUIDMigrationPhase{ibapi.GUIDMigrationAdding,
+				ibapi.GUIDMigrationVerifying, ibapi.GUIDMigrationVerifying}))
+			migration, _ := migrationMap.Get("default/migration")
+			Expect(migration.(*ibapi.GUIDMigration).Status.Message).To(ContainSubstring("unreachable"))
+			Expect(smClient.removed).To(BeEmpty())
+
+			// the status isn't updated while the failure is unchanged
+			d.GUIDMigrationPeriodicUpdate()
+			Expect(statusPhases).To(HaveLen(3))
+
+			smClient.pingErr = nil
+			d.GUIDMigrationPeriodicUpdate()
+			Expect(statusPhases[len(statusPhases)-1]).To(Equal(ibapi.GUIDMigrationComplete))
+			Expect(smClient.removed).To(HaveLen(1))
+			Expect(migrationMap.Items).To(BeEmpty())
+		})
+		It("Fail guid migration without selected pod", func() {
+			migrationMap.Set("default/migration", newMigration(map[string]string{"app": "other"}))
+
+			d.GUIDMigrationPeriodicUpdate()
+			Expect(statusPhases).To(Equal([]ibapi.GUIDMigrationPhase{ibapi.GUIDMigrationFailed}))
+			Expect(smClient.added).To(BeEmpty())
+			Expect(d.guidPool.GetAllocations()).To(HaveLen(1))
+			Expect(migrationMap.Items).To(BeEmpty())
+		})
+	})
+	Context("topology aware allocation", func() {
+		It("Generate guids next to the guids of the node switch", func() {
+			smClient := &countingSMClient{topology: plugins.FabricTopology{Switches: []plugins.Switch{
+				{GUID: "switch-1", Ports: []plugins.Port{{GUID: "0x11", NodeName: "node-1"}}},
+				{GUID: "switch-2", Ports: []plugins.Port{{GUID: "0x21", NodeName: "node-2"}}}}}}
+			d := newTestDaemon(testDaemonOptions{config: config.DaemonConfig{TopologyCacheTTL: 300},
+				smClient: smClient})
+			d.topologyCache = &fabricTopologyCache{}
+			guidPool := d.guidPool
+			podOnNode := func(nodeName string) *kapi.Pod {
+				return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", UID: types.UID(nodeName)},
+					Spec: kapi.PodSpec{NodeName: nodeName}}
+			}
+
+			guidAddr, allocated, err := d.generatePodGUID(guidPool, podOnNode("node-1"), "pod-1", "ib")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(allocated).To(BeTrue())
+			Expect(guidAddr).To(Equal(guid.GUID(0x0200000000000000)))
+			guidAddr, _, err = d.generatePodGUID(guidPool, podOnNode("node-2"), "pod-2", "ib")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr).To(Equal(guid.GUID(0x0200000000000001)))
+			guidAddr, _, err = d.generatePodGUID(guidPool, podOnNode("node-2"), "pod-3", "ib")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr).To(Equal(guid.GUID(0x0200000000000002)))
+			// the topology is fetched once within the cache ttl
+			Expect(smClient.calls).To(Equal(1))
+
+			// guids of nodes missing from the topology are generated without allocating them
+			guidAddr, allocated, err = d.generatePodGUID(guidPool, podOnNode("node-3"), "pod-4", "ib")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(allocated).To(BeFalse())
+			Expect(guidPool.AllocateGUID("pod-4", "default", "ib", guidAddr.String())).To(Succeed())
+		})
+	})
+	Context("per node pool", func() {
+		newNodePoolDaemon := func(client *k8sClientMock.Client, rangeEnd string) *daemon {
+			poolConfig := config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: rangeEnd}
+			return newTestDaemon(testDaemonOptions{
+				config: config.DaemonConfig{GUIDPool: poolConfig, NodeName: "node-a", PerNodePool: true,
+					PerNodePoolSize: 2, PerNodePoolConfigMap: "kube-system/node-ranges"},
+				kubeClient: client,
+			})
+		}
+		It("Claim a sub-range when the config map doesn't exist", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetConfigMap", "kube-system", "node-ranges").Return(
+				nil, apiErrors.NewNotFound(kapi.Resource("configmaps"), "node-ranges"))
+			client.On("CreateConfigMap", mock.Anything).Return(nil)
+			d := newNodePoolDaemon(client, "02:00:00:00:00:00:00:01")
+
+			Expect(d.initNodeSubRanges()).To(Succeed())
+			configMap := client.Calls[2].Arguments.Get(0).(*kapi.ConfigMap)
+			Expect(configMap.Data).To(Equal(map[string]string{"subrange-0": "node-a"}))
+			Expect(d.guidPool.SubRangeInfo().Total).To(Equal(uint64(2)))
+		})
+		It("Add the sub-ranges claimed by the node", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetConfigMap", "kube-system", "node-ranges").Return(&kapi.ConfigMap{
+				Data: map[string]string{"subrange-0": "node-b", "subrange-1": "node-a"}}, nil)
+			d := newNodePoolDaemon(client, "02:00:00:00:00:00:00:03")
+
+			Expect(d.initNodeSubRanges()).To(Succeed())
+			Expect(d.guidPool.SubRangeInfo().Ranges).To(Equal([]config.GUIDPoolRangeConfig{
+				{RangeStart: "02:00:00:00:00:00:00:02", RangeEnd: "02:00:00:00:00:00:00:03"}}))
+			client.AssertNotCalled(GinkgoT(), "UpdateConfigMap", mock.Anything)
+		})
+		It("Claim an overflow sub-range when the node sub-ranges are exhausted", func() {
+			claims := map[string]string{"subrange-0": "node-a", "subrange-1": "node-b"}
+			client := &k8sClientMock.Client{}
+			client.On("GetConfigMap", "kube-system", "node-ranges").Return(
+				func(namespace, name string) *kapi.ConfigMap {
+					data := map[string]string{}
+					for key, value := range claims {
+						data[key] = value
+					}
+					return &kapi.ConfigMap{Data: data}
+				}, nil)
+			// another node claims sub-range 2 concurrently
+			client.On("UpdateConfigMap", mock.Anything).Return(
+				apiErrors.NewConflict(kapi.Resource("configmaps"), "node-ranges", errors.New("changed"))).Run(
+				func(mock.Arguments) { claims["subrange-2"] = "node-c" }).Once()
+			client.On("UpdateConfigMap", mock.Anything).Return(nil)
+			d := newNodePoolDaemon(client, "02:00:00:00:00:00:00:07")
+			Expect(d.initNodeSubRanges()).To(Succeed())
+			for _, podGUID := range []string{"02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:01"} {
+				Expect(d.guidPool.AllocateGUID("pod", "default", "ib", podGUID)).To(Succeed())
+			}
+
+			guidAddr, err := d.generateGUID(d.guidPool, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr.String()).To(Equal("02:00:00:00:00:00:00:06"))
+		})
+	})
+	Context("updateNodeVFs", func() {
+		It("Extend the guid pool when the node VFs count increases", func() {
+			client := &k8sClientMock.Client{}
+			client.On("CreatePodEvent", mock.Anything, kapi.EventTypeNormal, guidPoolExtendedReason,
+				mock.Anything).Return(nil)
+			nodeEventHandler := resEvenHandler.NewNodeEventHandler()
+			d := newTestDaemon(testDaemonOptions{
+				config: config.DaemonConfig{PodName: "ib-kubernetes", PodNamespace: "kube-system",
+					GUIDPool: config.GUIDPoolConfig{
+						RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:07"}},
+				kubeClient: client,
+			})
+			guidPool := d.guidPool
+			d.nodeWatcher = &fakeWatcher{eventHandler: nodeEventHandler}
+			d.nodeVFs = -1
+			node := func(numVFs string) *kapi.Node {
+				return &kapi.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{
+					resEvenHandler.NumVFsAnnotation: numVFs}}}
+			}
+
+			nodeEventHandler.OnAdd(node("8"))
+			d.updateNodeVFs()
+			Expect(guidPool.Stats().Total).To(Equal(uint64(8)))
+
+			nodeEventHandler.OnUpdate(node("8"), node("16"))
+			d.updateNodeVFs()
+			Expect(guidPool.Stats().Total).To(Equal(uint64(16)))
+			client.AssertNumberOfCalls(GinkgoT(), "CreatePodEvent", 1)
+
+			// the pool isn't shrunk, and extended only beyond the highest VFs count
+			nodeEventHandler.OnUpdate(node("16"), node("4"))
+			d.updateNodeVFs()
+			nodeEventHandler.OnUpdate(node("4"), node("20"))
+			d.updateNodeVFs()
+			Expect(guidPool.Stats().Total).To(Equal(uint64(20)))
+		})
+	})
+	Context("setPortCapabilitiesAnnotations", func() {
+		It("Annotate pod with its port speed and width", func() {
+			d := &daemon{smClient: &countingSMClient{
+				capabilities: plugins.PortCapabilities{Speed: "HDR", Width: "4x"}}}
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+			d.setPortCapabilitiesAnnotations(pod, guid.GUID(0x0200000000000001).HardWareAddress())
+			Expect(pod.Annotations).To(Equal(map[string]string{
+				utils.PortSpeedAnnotation: "200Gb", utils.PortWidthAnnotation: "4x"}))
+		})
+		It("Don't annotate pod with unknown port capabilities", func() {
+			d := &daemon{smClient: &countingSMClient{}}
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+			d.setPortCapabilitiesAnnotations(pod, guid.GUID(0x0200000000000001).HardWareAddress())
+			Expect(pod.Annotations).To(BeEmpty())
+		})
+	})
+	Context("Status", func() {
+		It("Get status of the daemon", func() {
+			d := newTestDaemon(testDaemonOptions{
+				config: config.DaemonConfig{PeriodicUpdate: 5, GUIDPool: config.GUIDPoolConfig{
+					RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:09"}},
+				smClient: &countingSMClient{},
+			})
+			d.startTime = time.Now().Add(-time.Minute)
+			Expect(d.guidPool.AllocateGUID("pod", "default", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+			addMap, _ := d.watcher.GetHandler().GetResults()
+			addMap.Set("default_ib", []*kapi.Pod{{}, {}})
+
+			daemonStatus := d.Status()
+			Expect(daemonStatus.PoolAllocated).To(Equal(uint64(1)))
+			Expect(daemonStatus.PoolTotal).To(Equal(uint64(10)))
+			Expect(daemonStatus.SMConnected).To(BeTrue())
+			Expect(daemonStatus.AddQueueNetworks).To(Equal(1))
+			Expect(daemonStatus.AddQueuePods).To(Equal(2))
+			Expect(daemonStatus.Stalled).To(BeTrue())
+
+			d.updateTimes.addDone(nil)
+			Expect(d.Status().Stalled).To(BeFalse())
+		})
+		It("Not ready if the last add update failed", func() {
+			d := &daemon{smClient: &countingSMClient{}}
+			Expect(d.Ready()).To(Succeed())
+
+			d.updateTimes.addDone(errors.New("pKey update failed"))
+			Expect(d.Ready()).ToNot(Succeed())
+
+			d.updateTimes.addDone(nil)
+			Expect(d.Ready()).To(Succeed())
+		})
+	})
+	Context("checkGUID", func() {
+		var client *k8sClientMock.Client
+		var smClient *countingSMClient
+		var d *daemon
+		podGUID := guid.GUID(0x0200000000000001).HardWareAddress()
+		BeforeEach(func() {
+			client = &k8sClientMock.Client{}
+			client.On("GetPods", kapi.NamespaceAll).Return(&kapi.PodList{Items: []kapi.Pod{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid",
+					Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"ib","cni-args":{` +
+						`"guid":"02:00:00:00:00:00:00:01","mellanox.infiniband.app":"configured"}}]`}}}}}, nil)
+			client.On("GetNetworkAttachmentDefinition", "default", "ib").Return(&v1.NetworkAttachmentDefinition{
+				Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+
+			smClient = &countingSMClient{members: map[int][]net.HardwareAddr{0x10: {podGUID}}}
+			d = newTestDaemon(testDaemonOptions{kubeClient: client, smClient: smClient})
+			Expect(d.initPool()).To(Succeed())
+		})
+		It("Check healthy guid", func() {
+			report := d.checkGUID(podGUID)
+			Expect(report.Healthy).To(BeTrue())
+			Expect(report.Namespace).To(Equal("default"))
+			Expect(report.Pod).To(Equal("pod"))
+			Expect(report.Network).To(Equal("ib"))
+			Expect(report.PKey).To(Equal("0x10"))
+			Expect(report.Checks).To(HaveLen(4))
+		})
+		It("Check guid missing from the pKey and unreachable", func() {
+			smClient.members = nil
+			smClient.pingErr = errors.New("unreachable")
+
+			report := d.checkGUID(podGUID)
+			Expect(report.Healthy).To(BeFalse())
+			Expect(report.Checks).To(Equal([]GUIDCheck{
+				{Name: PodNetworkCheck, Passed: true},
+				{Name: PodAnnotationCheck, Passed: true},
+				{Name: SMMembershipCheck, Passed: false,
+					Message: "guid isn't a member of pKey 0x10 in subnet manager counting"},
+				{Name: FabricReachabilityCheck, Passed: false, Message: "unreachable"}}))
+		})
+		It("Check unallocated guid", func() {
+			report := d.checkGUID(guid.GUID(0x0200000000000002).HardWareAddress())
+			Expect(report.Healthy).To(BeFalse())
+			Expect(report.Pod).To(BeEmpty())
+			Expect(report.Checks[0]).To(Equal(GUIDCheck{Name: PodNetworkCheck, Passed: false,
+				Message: "no pod network found for the guid"}))
+			Expect(report.Checks[1].Passed).To(BeFalse())
+		})
+	})
+	Context("gid addressing", func() {
+		guids := []net.HardwareAddr{{0x02, 0, 0, 0, 0, 0, 0, 0x01}}
+		It("Add guids by gid with subnet prefix", func() {
+			smClient := &gidSMClient{countingSMClient: &countingSMClient{}}
+			d := &daemon{smClient: smClient, config: config.DaemonConfig{SubnetPrefix: "fe80::"}}
+			failedIndexes, err := d.addGuidsToPKeyInBatches(0x10, plugins.MembershipFull, guids)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(failedIndexes).To(BeEmpty())
+			Expect(smClient.gids).To(Equal(map[int][]string{0x10: {"fe80::200:0:0:1"}}))
+			Expect(smClient.calls).To(Equal(0))
+		})
+		It("Add guids by guid without subnet prefix", func() {
+			smClient := &gidSMClient{countingSMClient: &countingSMClient{added: map[int][]net.HardwareAddr{}}}
+			d := &daemon{smClient: smClient}
+			_, err := d.addGuidsToPKeyInBatches(0x10, plugins.MembershipFull, guids)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(smClient.added).To(Equal(map[int][]net.HardwareAddr{0x10: guids}))
+			Expect(smClient.gids).To(BeEmpty())
+		})
+		It("Fail plugins without gids support with subnet prefix", func() {
+			Expect(checkGIDSupport(&countingSMClient{}, "")).To(Succeed())
+			Expect(checkGIDSupport(&gidSMClient{countingSMClient: &countingSMClient{}}, "fe80::")).To(Succeed())
+			Expect(checkGIDSupport(&countingSMClient{}, "fe80::")).ToNot(Succeed())
+
+			d := &daemon{smClient: &countingSMClient{}, config: config.DaemonConfig{SubnetPrefix: "fe80::"}}
+			failedIndexes, err := d.addGuidsToPKeyInBatches(0x10, plugins.MembershipFull, guids)
+			Expect(err).To(HaveOccurred())
+			Expect(failedIndexes).To(Equal(map[int]bool{0: true}))
+		})
+	})
+})
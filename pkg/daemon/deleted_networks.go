@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"net"
+
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/audit"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// releaseDeletedNetworkGUIDs releases the guids of the deleted pods of a network whose network attachment definition
+// no longer exists, so their guids aren't leaked while the network is retried forever. The network pKey is unknown,
+// the guids are removed from the pKeys recorded on their allocations in the subnet manager on a best-effort basis,
+// the removal failures are logged and the guids are released anyway. Guids without a recorded pKey are only
+// released. The network is removed from the delete map, which must be locked by the caller.
+func (d *daemon) releaseDeletedNetworkGUIDs(deleteMap *utils.SynchronizedMap, networkID, networkName string,
+	pods []*kapi.Pod) {
+	guidPool := d.getNetworkGUIDPool(networkID)
+	recordedPKeys := map[string]string{} // pKeys recorded on the allocations by guid
+	for _, allocation := range guidPool.GetAllocations() {
+		if allocation.PKey != "" {
+			recordedPKeys[allocation.GUID.String()] = allocation.PKey
+		}
+	}
+
+	var guidList []net.HardwareAddr
+	var guidPods []*kapi.Pod
+	pKeysGUIDs := map[string][]net.HardwareAddr{}
+	processedPods := map[types.UID]bool{}
+	for _, pod := range pods {
+		if processedPods[pod.UID] {
+			continue
+		}
+		processedPods[pod.UID] = true
+
+		podLog := podLogger(networkID, pod)
+		networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+		if err != nil {
+			podLog.Error().Err(err).Msg("failed to read pod networks annotation")
+			d.releasePodGUIDs(pod)
+			continue
+		}
+
+		podNetworks, err := utils.GetPodNetworks(networks, networkName)
+		if err != nil {
+			podLog.Error().Err(err).Msg("failed to get pod network spec")
+			continue
+		}
+
+		podGUIDs, err := getConfiguredPodNetworksGUIDs(podNetworks, d.ibAnnotationKey)
+		if err != nil {
+			podLog.Error().Err(err).Msg("failed to get pod network guid")
+			continue
+		}
+
+		// the guid of a tampered annotation may be of another pod, so it isn't released
+		if len(podGUIDs) == 0 || !d.verifyPodGUIDs(pod) {
+			continue
+		}
+
+		for _, guidAddr := range podGUIDs {
+			guidList = append(guidList, guidAddr)
+			guidPods = append(guidPods, pod)
+			if pKeyName, ok := recordedPKeys[guidAddr.String()]; ok {
+				pKeysGUIDs[pKeyName] = append(pKeysGUIDs[pKeyName], guidAddr)
+			}
+		}
+	}
+
+	for pKeyName, pKeyGUIDs := range pKeysGUIDs {
+		pKey, err := utils.ParsePKey(pKeyName)
+		if err != nil {
+			log.Warn().Msgf("failed to parse recorded pKey %s of deleted network %s with error: %v", pKeyName,
+				networkID, err)
+			continue
+		}
+		if failedIndexes, err := d.removeGuidsFromPKeyInBatches(pKey, pKeyGUIDs); err != nil {
+			log.Warn().Msgf("failed to remove %d out of %d guids of deleted network %s from pKey %s with "+
+				"subnet manager %s with error: %v", len(failedIndexes), len(pKeyGUIDs), networkID, pKeyName,
+				d.smClient.Name(), err)
+		}
+	}
+
+	for index, guidAddr := range guidList {
+		if err := d.releasePoolGUID(guidPool, guidAddr.String()); err != nil {
+			log.Err(err)
+			continue
+		}
+
+		d.audit(audit.DeleteRecord, guidPods[index], guidAddr, recordedPKeys[guidAddr.String()])
+		d.removeDNSRecord(guidAddr)
+		d.untrackIdleGUID(guidAddr)
+	}
+
+	d.releasePodFinalizers(pods, nil)
+	log.Info().Msgf("released %d guids of network %s whose network attachment definition no longer exists",
+		len(guidList), networkID)
+	deleteMap.UnSafeRemove(networkID)
+}
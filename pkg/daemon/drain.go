@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// drain runs a last add update for the pending pods on termination, bounded by the drain timeout. The networks
+// still pending when the update finishes or times out are logged, they are handled by the next daemon.
+func (d *daemon) drain() {
+	timeout := time.Duration(d.getConfig().DrainTimeout) * time.Second
+	if timeout <= 0 {
+		return
+	}
+
+	log.Info().Msgf("draining pending pods with timeout %v", timeout)
+	addMap, _ := d.watcher.GetHandler().GetResults()
+	startedChan := make(chan []string, 1)
+	doneChan := make(chan []string, 1)
+	go func() {
+		// the networks are listed once the running add update, if any, is finished
+		startedChan <- pendingNetworks(addMap)
+		d.AddPeriodicUpdate()
+		doneChan <- pendingNetworks(addMap)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var networks []string
+	for {
+		select {
+		case networks = <-startedChan:
+		case remaining := <-doneChan:
+			if len(remaining) != 0 {
+				log.Warn().Msgf("drain finished with pending networks %v", remaining)
+				return
+			}
+			log.Info().Msg("drain finished without pending networks")
+			return
+		case <-timer.C:
+			if networks == nil {
+				log.Warn().Msgf("drain timed out after %v waiting for the running add update", timeout)
+				return
+			}
+			log.Warn().Msgf("drain timed out after %v with pending networks %v", timeout, networks)
+			return
+		}
+	}
+}
+
+// pendingNetworks returns the sorted ids of the networks of the add map
+func pendingNetworks(addMap *utils.SynchronizedMap) []string {
+	addMap.RLock()
+	defer addMap.RUnlock()
+	networks := make([]string, 0, len(addMap.Items))
+	for networkID := range addMap.Items {
+		networks = append(networks, networkID)
+	}
+	sort.Strings(networks)
+	return networks
+}
@@ -0,0 +1,367 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	ibapi "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// errMigrationFailed is wrapped by the errors of guid migrations which can't be completed, they aren't retried
+var errMigrationFailed = errors.New("guid migration failed")
+
+// errMigrationPodNotFound is wrapped by the errors of guid migrations whose selected pod doesn't exist
+var errMigrationPodNotFound = fmt.Errorf("%w: pod not found", errMigrationFailed)
+
+// GUIDMigrationPeriodicUpdate advances the guid migrations through their phases. A migration moves to its next phase
+// once its current phase succeeds, its status is updated on every phase change so the migration is resumed after
+// restarts. Phases which fail with transient errors, e.g subnet manager errors, are retried in the next update.
+func (d *daemon) GUIDMigrationPeriodicUpdate() {
+	log.Info().Msg("running guid migrations periodic update")
+	addMap, _ := d.migrationWatcher.GetHandler().GetResults()
+	addMap.Lock()
+	defer addMap.Unlock()
+	for key, migrationInterface := range addMap.Items {
+		migration, ok := migrationInterface.(*ibapi.GUIDMigration)
+		if !ok {
+			log.Error().Msgf("invalid value for guid migrations map expected \"*GUIDMigration\", found %T",
+				migrationInterface)
+			addMap.UnSafeRemove(key)
+			continue
+		}
+
+		migration = d.runGUIDMigration(migration.DeepCopy())
+		if migration.IsFinished() {
+			addMap.UnSafeRemove(key)
+		} else {
+			addMap.UnSafeSet(key, migration)
+		}
+	}
+	d.flushDNSRecords()
+	log.Info().Msg("guid migrations periodic update finished")
+}
+
+// runGUIDMigration runs the migration phases until a phase fails or the migration is finished,
+// and returns the migration with its last status
+func (d *daemon) runGUIDMigration(migration *ibapi.GUIDMigration) *ibapi.GUIDMigration {
+	for !migration.IsFinished() {
+		phase, message := migration.Status.Phase, migration.Status.Message
+		err := d.runGUIDMigrationPhase(migration)
+		switch {
+		case errors.Is(err, errMigrationFailed):
+			log.Error().Msgf("guid migration namespace %s name %s failed in phase %s: %v", migration.Namespace,
+				migration.Name, phase, err)
+			migration.Status.Phase = ibapi.GUIDMigrationFailed
+			migration.Status.Message = err.Error()
+		case err != nil:
+			log.Warn().Msgf("guid migration namespace %s name %s phase %s failed, will retry: %v",
+				migration.Namespace, migration.Name, phase, err)
+			if err.Error() == message {
+				return migration
+			}
+			migration.Status.Message = err.Error()
+		default:
+			log.Info().Msgf("guid migration namespace %s name %s moved from phase %s to %s", migration.Namespace,
+				migration.Name, phase, migration.Status.Phase)
+			migration.Status.Message = ""
+		}
+
+		updated, updateErr := d.kubeClient.UpdateGUIDMigrationStatus(migration)
+		if updateErr != nil {
+			// the phases are idempotent, the next phase is run again if the status change is lost
+			log.Warn().Msgf("failed to update guid migration namespace %s name %s status with error: %v",
+				migration.Namespace, migration.Name, updateErr)
+			return migration
+		}
+		migration = updated
+
+		if err != nil {
+			return migration
+		}
+	}
+
+	return migration
+}
+
+// runGUIDMigrationPhase runs the current phase of the migration, the migration phase is set to the next phase if
+// the current phase succeeds
+func (d *daemon) runGUIDMigrationPhase(migration *ibapi.GUIDMigration) error {
+	switch migration.Status.Phase {
+	case "", ibapi.GUIDMigrationPending:
+		return d.allocateMigrationGUID(migration)
+	case ibapi.GUIDMigrationAdding:
+		return d.addMigrationGUID(migration)
+	case ibapi.GUIDMigrationVerifying:
+		return d.verifyMigrationGUID(migration)
+	case ibapi.GUIDMigrationRemoving:
+		return d.removeMigrationOldGUID(migration)
+	default:
+		return fmt.Errorf("%w: unknown phase %s", errMigrationFailed, migration.Status.Phase)
+	}
+}
+
+// allocateMigrationGUID allocates the new guid for the pod network selected by the migration
+func (d *daemon) allocateMigrationGUID(migration *ibapi.GUIDMigration) error {
+	pod, _, network, err := d.getMigrationPodNetwork(migration)
+	if err != nil {
+		return err
+	}
+
+	oldGUID, err := utils.GetPodNetworkGUID(network)
+	if err != nil || !utils.IsPodNetworkConfiguredWithInfiniBand(network, d.ibAnnotationKey) {
+		return fmt.Errorf("%w: pod %s network %s isn't configured with InfiniBand", errMigrationFailed, pod.Name,
+			network.Name)
+	}
+
+	newGUID, err := guid.ParseGUID(migration.Spec.NewGUID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid new guid %s: %v", errMigrationFailed, migration.Spec.NewGUID, err)
+	}
+
+	if isSameGUID(oldGUID, newGUID.HardWareAddress()) {
+		return fmt.Errorf("%w: pod %s network %s already has guid %s", errMigrationFailed, pod.Name, network.Name,
+			oldGUID)
+	}
+
+	d.guidAllocationLock.Lock()
+	defer d.guidAllocationLock.Unlock()
+	podNetworkID, exist := d.guidPodNetworkMap[oldGUID]
+	if !exist {
+		podNetworkID = string(d.vmiAnnotator.GetAllocationUID(pod)) + utils.GenerateNetworkID(network)
+	}
+
+	if allocatedFor, allocated := d.guidPodNetworkMap[newGUID.String()]; !allocated {
+		guidPool := d.getNetworkGUIDPool(utils.GenerateNetworkID(network))
+		if err = guidPool.AllocateGUID(d.vmiAnnotator.GetAllocationUID(pod), pod.Namespace, network.Name,
+			newGUID.String()); err != nil {
+			return fmt.Errorf("%w: %v", errMigrationFailed, err)
+		}
+		d.guidPodNetworkMap[newGUID.String()] = podNetworkID
+	} else if allocatedFor != podNetworkID {
+		return fmt.Errorf("%w: guid %s is already allocated for %s", errMigrationFailed, newGUID, allocatedFor)
+	}
+
+	migration.Status.PodName = pod.Name
+	migration.Status.OldGUID = oldGUID
+	migration.Status.Phase = ibapi.GUIDMigrationAdding
+	return nil
+}
+
+// addMigrationGUID adds the new guid to the pod network pKey and sets it in the pod network annotation
+func (d *daemon) addMigrationGUID(migration *ibapi.GUIDMigration) error {
+	newGUID, err := guid.ParseGUID(migration.Spec.NewGUID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid new guid %s: %v", errMigrationFailed, migration.Spec.NewGUID, err)
+	}
+
+	pod, networks, network, err := d.getMigrationPodNetwork(migration)
+	if errors.Is(err, errMigrationPodNotFound) {
+		// the pod network annotation isn't updated yet, so the new guid isn't released with the pod
+		d.releaseMigrationGUID(migration.Namespace, newGUID.String())
+	}
+	if err != nil {
+		return err
+	}
+
+	pKey, membershipName, err := d.getPodNetworkPKey(pod, network)
+	if err != nil {
+		return err
+	}
+
+	if pKey != "" {
+		pKeyValue, parseErr := utils.ParsePKey(pKey)
+		if parseErr != nil {
+			return fmt.Errorf("%w: %v", errMigrationFailed, parseErr)
+		}
+		membership, parseErr := plugins.ParseMembership(membershipName)
+		if parseErr != nil {
+			return fmt.Errorf("%w: %v", errMigrationFailed, parseErr)
+		}
+
+		if err = d.addGuidsToPKey(context.Background(), pKeyValue,
+			[]net.HardwareAddr{newGUID.HardWareAddress()}, membership); err != nil {
+			return fmt.Errorf("failed to add guid %s to pKey %s with subnet manager %s with error: %v", newGUID,
+				pKey, d.smClient.Name(), err)
+		}
+	}
+
+	guidFormat := d.getConfig().GUIDFormat
+	if err = utils.SetPodNetworkGUID(network, utils.FormatGUID(newGUID.String(), guidFormat)); err != nil {
+		return fmt.Errorf("%w: %v", errMigrationFailed, err)
+	}
+
+	if err = d.vmiAnnotator.SetGUIDAnnotation(pod, network.Name, newGUID.String()); err != nil {
+		return fmt.Errorf("failed to set virtual machine guid annotation with error: %v", err)
+	}
+
+	if err = d.signPodNetworkGUID(pod, network.Name, newGUID.String()); err != nil {
+		return fmt.Errorf("failed to sign guid with error: %v", err)
+	}
+
+	netAnnotations, err := json.Marshal(networks)
+	if err != nil {
+		return fmt.Errorf("%w: failed to dump pod networks into json: %v", errMigrationFailed, err)
+	}
+	pod.Annotations[v1.NetworkAttachmentAnnot] = string(netAnnotations)
+	if err = k8sClient.SetAnnotationsOnPodWithRetry(d.kubeClient, pod, daemonPodAnnotations(pod),
+		d.getConfig().PodAnnotationRetries); err != nil {
+		return fmt.Errorf("failed to update pod annotations with error: %v", err)
+	}
+
+	if pKey != "" {
+		guidPool := d.getNetworkGUIDPool(utils.GenerateNetworkID(network))
+		if pKeyErr := guidPool.SetGUIDPKey(newGUID.String(), pKey); pKeyErr != nil {
+			log.Warn().Msgf("failed to record pKey of guid %s with error: %v", newGUID, pKeyErr)
+		}
+	}
+	d.addDNSRecord(pod, newGUID.HardWareAddress())
+
+	migration.Status.PKey = pKey
+	migration.Status.Phase = ibapi.GUIDMigrationVerifying
+	return nil
+}
+
+// verifyMigrationGUID checks the new guid is reachable on the fabric
+func (d *daemon) verifyMigrationGUID(migration *ibapi.GUIDMigration) error {
+	newGUID, err := guid.ParseGUID(migration.Spec.NewGUID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid new guid %s: %v", errMigrationFailed, migration.Spec.NewGUID, err)
+	}
+
+	if err = d.smClient.PingGUID(context.Background(), newGUID.HardWareAddress()); err != nil {
+		return fmt.Errorf("guid %s isn't reachable on the fabric yet: %v", newGUID, err)
+	}
+
+	migration.Status.Phase = ibapi.GUIDMigrationRemoving
+	return nil
+}
+
+// removeMigrationOldGUID removes the old guid from the pod network pKey and releases it
+func (d *daemon) removeMigrationOldGUID(migration *ibapi.GUIDMigration) error {
+	oldGUID, err := net.ParseMAC(migration.Status.OldGUID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid old guid %s: %v", errMigrationFailed, migration.Status.OldGUID, err)
+	}
+
+	if migration.Status.PKey != "" {
+		pKey, parseErr := utils.ParsePKey(migration.Status.PKey)
+		if parseErr != nil {
+			return fmt.Errorf("%w: %v", errMigrationFailed, parseErr)
+		}
+
+		if err = d.smClient.RemoveGuidsFromPKey(context.Background(), pKey, []net.HardwareAddr{oldGUID}); err != nil {
+			return fmt.Errorf("failed to remove guid %s from pKey %s with subnet manager %s with error: %v",
+				oldGUID, migration.Status.PKey, d.smClient.Name(), err)
+		}
+	}
+
+	d.releaseMigrationGUID(migration.Namespace, migration.Status.OldGUID)
+	d.removeDNSRecord(oldGUID)
+	d.untrackIdleGUID(oldGUID)
+
+	migration.Status.Phase = ibapi.GUIDMigrationComplete
+	return nil
+}
+
+// getMigrationPodNetwork returns the pod selected by the migration, its networks and the migrated network.
+// The pod is selected by name once the migration started.
+func (d *daemon) getMigrationPodNetwork(migration *ibapi.GUIDMigration) (
+	*kapi.Pod, []*v1.NetworkSelectionElement, *v1.NetworkSelectionElement, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&migration.Spec.PodSelector)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: invalid pod selector: %v", errMigrationFailed, err)
+	}
+
+	pods, err := d.kubeClient.GetPods(migration.Namespace)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get pods from kubernetes: %v", err)
+	}
+
+	var selected []*kapi.Pod
+	for index := range pods.Items {
+		pod := &pods.Items[index]
+		if (migration.Status.PodName != "" && pod.Name == migration.Status.PodName) ||
+			(migration.Status.PodName == "" && selector.Matches(labels.Set(pod.Labels))) {
+			selected = append(selected, pod)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, nil, nil, errMigrationPodNotFound
+	}
+	if len(selected) != 1 {
+		return nil, nil, nil, fmt.Errorf("%w: pod selector selects %d pods, should select a single pod",
+			errMigrationFailed, len(selected))
+	}
+
+	pod := selected[0]
+	networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: failed to read pod %s networks annotation: %v", errMigrationFailed,
+			pod.Name, err)
+	}
+
+	network, err := utils.GetPodNetwork(networks, migration.Spec.NetworkName)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", errMigrationFailed, err)
+	}
+
+	return pod, networks, network, nil
+}
+
+// releaseMigrationGUID releases the guid allocated for a pod of the namespace from its guid pool,
+// the guids of the network attachment definitions guid ranges in the global pool have no namespace
+func (d *daemon) releaseMigrationGUID(namespace, guidAddr string) {
+	d.guidAllocationLock.Lock()
+	defer d.guidAllocationLock.Unlock()
+	for _, guidPool := range d.getGUIDPools() {
+		if guidNamespace, allocated := guidPool.GetGUIDNamespace(guidAddr); !allocated || guidNamespace != namespace {
+			continue
+		}
+
+		if err := guidPool.ReleaseGUID(guidAddr); err != nil {
+			log.Warn().Msgf("failed to release migrated guid %s with error: %v", guidAddr, err)
+			return
+		}
+		delete(d.guidPodNetworkMap, guidAddr)
+		return
+	}
+
+	log.Warn().Msgf("migrated guid %s isn't allocated for namespace %s", guidAddr, namespace)
+}
+
+// getPodNetworkPKey returns the pKey of the pod network attachment definition, empty if the network has no pKey,
+// and the pKey membership of the network guids
+func (d *daemon) getPodNetworkPKey(pod *kapi.Pod, network *v1.NetworkSelectionElement) (string, string, error) {
+	networkNamespace := network.Namespace
+	if networkNamespace == "" {
+		networkNamespace = pod.Namespace
+	}
+
+	netAttDef, err := d.kubeClient.GetNetworkAttachmentDefinition(networkNamespace, network.Name)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get network attachment definition %s/%s with error: %v",
+			networkNamespace, network.Name, err)
+	}
+
+	ibCniSpec, err := d.getIbSriovCniSpec(networkNamespace, network.Name, netAttDef)
+	if err != nil {
+		return "", "", err
+	}
+
+	return d.getNetworkPKey(networkNamespace+"_"+network.Name, ibCniSpec), ibCniSpec.Membership, nil
+}
@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+)
+
+// Keys of the guid pool persistence config map
+const (
+	// guidPoolFormatKey is the serialization format of the persisted guid pool state
+	guidPoolFormatKey = "format"
+	// guidPoolStateKey is the persisted guid pool state, base64 encoded in the binary format
+	guidPoolStateKey = "state"
+)
+
+// guidPoolPersistence holds the guid pool state last written to the persistence config map, the add and delete
+// periodic updates persist the pool concurrently
+type guidPoolPersistence struct {
+	lock  sync.Mutex
+	state string
+}
+
+// restorePersistedGUIDs allocates the guids of the persisted guid pool state which aren't in the pods annotations,
+// e.g guids of pods whose annotation wasn't set before the daemon restarted, so they aren't allocated to other pods.
+// Guids of pods which no longer exist are restored if their namespace is known, so they are reclaimed with the other
+// orphaned guids. The guids reserved for pod names are restored as well. The config map doesn't exist before the pool
+// is persisted for the first time.
+func (d *daemon) restorePersistedGUIDs(pods *kapi.PodList) error {
+	daemonConfig := d.getConfig()
+	// the config map format is checked by ValidateConfig
+	namespace, name, _ := daemonConfig.GUIDPool.GetPersistenceConfigMap()
+	configMap, err := d.kubeClient.GetConfigMap(namespace, name)
+	if apiErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get guid pool persistence config map %s/%s with error: %v", namespace, name,
+			err)
+	}
+
+	persistedPool, err := guid.NewPool(&daemonConfig.GUIDPool)
+	if err != nil {
+		return err
+	}
+	format := configMap.Data[guidPoolFormatKey]
+	state := []byte(configMap.Data[guidPoolStateKey])
+	if format == guid.SerializationFormatBinary {
+		if state, err = base64.StdEncoding.DecodeString(configMap.Data[guidPoolStateKey]); err != nil {
+			return fmt.Errorf("failed to decode persisted guid pool state with error: %v", err)
+		}
+	}
+	if err = guid.Unmarshal(persistedPool, format, state); err != nil {
+		// e.g the pool range changed, the allocations are restored only from the pods annotations
+		log.Warn().Msgf("failed to restore persisted guid pool state with error: %v", err)
+		return nil
+	}
+
+	d.guidAllocationLock.Lock()
+	defer d.guidAllocationLock.Unlock()
+	for _, reservation := range persistedPool.GetReservations() {
+		if err = d.guidPool.ReserveGUID(reservation.PodUID, reservation.Namespace, reservation.PodName,
+			reservation.GUID.String()); err != nil {
+			log.Warn().Msgf("failed to restore persisted guid %s reservation of pod %s/%s with error: %v",
+				reservation.GUID, reservation.Namespace, reservation.PodName, err)
+		}
+	}
+
+	allocationUIDs := make(map[types.UID]bool, len(pods.Items))
+	for index := range pods.Items {
+		allocationUIDs[d.vmiAnnotator.GetAllocationUID(&pods.Items[index])] = true
+	}
+
+	for _, allocation := range persistedPool.GetAllocations() {
+		guidAddr := allocation.GUID.String()
+		// guid ranges of network attachment definitions are restored with their guid pools
+		if !allocationUIDs[allocation.PodUID] && allocation.Namespace == "" {
+			continue
+		}
+		if _, exist := d.guidPodNetworkMap[guidAddr]; exist {
+			continue
+		}
+
+		if err = d.guidPool.AllocateGUID(allocation.PodUID, allocation.Namespace, allocation.Network,
+			guidAddr); err != nil {
+			log.Warn().Msgf("failed to restore persisted guid %s of pod %s with error: %v", guidAddr,
+				allocation.PodUID, err)
+			continue
+		}
+		if allocation.PKey != "" {
+			if err = d.guidPool.SetGUIDPKey(guidAddr, allocation.PKey); err != nil {
+				log.Warn().Msgf("failed to restore pKey of persisted guid %s with error: %v", guidAddr, err)
+			}
+		}
+		d.guidPodNetworkMap[guidAddr] = string(allocation.PodUID) + allocation.Network
+		log.Info().Msgf("restored persisted guid %s of pod %s", guidAddr, allocation.PodUID)
+	}
+
+	return nil
+}
+
+// persistGUIDPool writes the guid pool state to the persistence config map if it changed since it was last written
+func (d *daemon) persistGUIDPool() {
+	daemonConfig := d.getConfig()
+	data, err := guid.Marshal(d.guidPool, daemonConfig.PoolSerializationFormat)
+	if err != nil {
+		log.Error().Msgf("failed to serialize guid pool state with error: %v", err)
+		return
+	}
+
+	state := string(data)
+	if daemonConfig.PoolSerializationFormat == guid.SerializationFormatBinary {
+		state = base64.StdEncoding.EncodeToString(data)
+	}
+
+	d.poolPersistence.lock.Lock()
+	defer d.poolPersistence.lock.Unlock()
+	if state == d.poolPersistence.state {
+		return
+	}
+
+	namespace, name, _ := daemonConfig.GUIDPool.GetPersistenceConfigMap()
+	if err = d.kubeClient.SetConfigMapData(namespace, name, map[string]string{
+		guidPoolFormatKey: daemonConfig.PoolSerializationFormat, guidPoolStateKey: state}); err != nil {
+		log.Error().Msgf("failed to write guid pool persistence config map %s/%s with error: %v", namespace, name,
+			err)
+		return
+	}
+	d.poolPersistence.state = state
+}
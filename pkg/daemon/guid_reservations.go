@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// applyPodGUIDReservation reserves the guid of the pod guid reservation annotation for the network in the pool and
+// sets it in the network cni-args if the network has no guid, so it is allocated like a user allocated guid ahead of
+// the generated guids. The reservation applies to the first interface of the network only.
+// It returns error if the annotation is invalid, the network guid isn't the reserved guid, or the guid is reserved
+// for another pod. The caller should hold guidAllocationLock.
+func (d *daemon) applyPodGUIDReservation(guidPool guid.Pool, pod *kapi.Pod, allocationUID types.UID,
+	networks []*v1.NetworkSelectionElement, network *v1.NetworkSelectionElement, networkName string) error {
+	reservations, err := utils.GetPodGUIDReservations(pod)
+	if err != nil {
+		return err
+	}
+	reservedGUID, exist := reservations[networkName]
+	if !exist || utils.GetPodNetworkInterfaceKey(networks, network) != networkName {
+		return nil
+	}
+
+	if networkGUID, guidErr := utils.GetPodNetworkGUID(network); guidErr == nil {
+		if guidAddr, parseErr := utils.ParseFormattedGUID(networkGUID); parseErr != nil ||
+			guidAddr.String() != reservedGUID {
+			return fmt.Errorf("network %s guid %s isn't the guid %s reserved by the %s annotation", networkName,
+				networkGUID, reservedGUID, utils.GUIDReservationAnnotation)
+		}
+	}
+
+	if err = guidPool.ReserveGUID(allocationUID, pod.Namespace, pod.Name, reservedGUID); err != nil {
+		return err
+	}
+
+	if utils.PodNetworkHasGUID(network) {
+		return nil
+	}
+	if err = utils.SetPodNetworkGUID(network, utils.FormatGUID(reservedGUID, d.getConfig().GUIDFormat)); err != nil {
+		return err
+	}
+	netAnnotations, err := json.Marshal(networks)
+	if err != nil {
+		return fmt.Errorf("failed to dump networks %+v of pod into json with error: %v", networks, err)
+	}
+	pod.Annotations[v1.NetworkAttachmentAnnot] = string(netAnnotations)
+	return nil
+}
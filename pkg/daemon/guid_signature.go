@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// GUIDSigningKeySecretKey is the key of the PEM encoded PKCS #8 Ed25519 private key in the guid signing key secret
+const GUIDSigningKeySecretKey = "signing.key"
+
+// guidSignatureReason is the reason of the pods events of guids which don't match their signature
+const guidSignatureReason = "GUIDSignatureInvalid"
+
+// loadGUIDSigningKey reads the guid signing key from the secret
+func loadGUIDSigningKey(client k8sClient.Client, namespace, name string) (ed25519.PrivateKey, error) {
+	secret, err := client.GetSecret(namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guid signing key secret %s/%s: %v", namespace, name, err)
+	}
+
+	data, ok := secret.Data[GUIDSigningKeySecretKey]
+	if !ok {
+		return nil, fmt.Errorf("guid signing key secret %s/%s has no %q key", namespace, name,
+			GUIDSigningKeySecretKey)
+	}
+
+	return utils.ParseGUIDSigningKey(data)
+}
+
+// signPodNetworkGUID signs the guid of the pod network in the pod annotations, pods aren't signed without signing key
+func (d *daemon) signPodNetworkGUID(pod *kapi.Pod, networkName, guidAddr string) error {
+	if d.guidSigningKey == nil {
+		return nil
+	}
+
+	return utils.SignGUIDAnnotation(pod, networkName, guidAddr, d.guidSigningKey, time.Now())
+}
+
+// verifyPodGUIDs checks the guids of the pod networks match their daemon signatures, and creates a warning event on
+// the pod if they don't. The unsigned guids, e.g allocated before the signing key was configured, are valid with a
+// warning. The guids of all the pods are valid without signing key.
+func (d *daemon) verifyPodGUIDs(pod *kapi.Pod) bool {
+	if d.guidSigningKey == nil {
+		return true
+	}
+
+	err := utils.VerifyGUIDAnnotation(pod, d.guidSigningKey.Public().(ed25519.PublicKey), d.ibAnnotationKey)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, utils.ErrGUIDUnsigned) {
+		log.Warn().Msgf("guids of pod namespace %s name %s aren't signed, releasing them: %v", pod.Namespace,
+			pod.Name, err)
+		return true
+	}
+
+	log.Error().Msgf("security warning: guids of pod namespace %s name %s may have been tampered with: %v",
+		pod.Namespace, pod.Name, err)
+	d.warnPods([]*kapi.Pod{pod}, guidSignatureReason, err.Error())
+	return false
+}
@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// idleGUIDCheckDivisor sets the idle guids check interval to 1/idleGUIDCheckDivisor of the eviction timeout
+const idleGUIDCheckDivisor = 2
+
+// trackedGUID is a guid added to a pKey by the daemon
+type trackedGUID struct {
+	guid       net.HardwareAddr
+	pKey       int
+	membership string    // pKey membership of the guid, kept to add the guid back with it
+	since      time.Time // time the guid was added to the pKey, earlier fabric activity is ignored
+	evicted    bool      // the guid was removed from the pKey for being idle
+}
+
+// idleGUIDTracker tracks the guids added to pKeys to evict the idle guids from their pKeys
+type idleGUIDTracker struct {
+	lock  sync.Mutex
+	guids map[string]*trackedGUID // tracked guids mapped by guid string
+}
+
+func newIdleGUIDTracker() *idleGUIDTracker {
+	return &idleGUIDTracker{guids: map[string]*trackedGUID{}}
+}
+
+// trackIdleGUID starts tracking the activity of the guid added to the pKey with the membership if idle guids eviction
+// is enabled
+func (d *daemon) trackIdleGUID(pKeyName, membershipName string, guidAddr net.HardwareAddr) {
+	if d.idleGUIDs == nil || pKeyName == "" {
+		return
+	}
+
+	pKey, err := utils.ParsePKey(pKeyName)
+	if err != nil {
+		return
+	}
+	membership, err := plugins.ParseMembership(membershipName)
+	if err != nil {
+		return
+	}
+
+	d.idleGUIDs.lock.Lock()
+	defer d.idleGUIDs.lock.Unlock()
+	d.idleGUIDs.guids[guidAddr.String()] = &trackedGUID{guid: guidAddr, pKey: pKey, membership: membership,
+		since: time.Now()}
+}
+
+// untrackIdleGUID stops tracking the activity of the released guid if idle guids eviction is enabled
+func (d *daemon) untrackIdleGUID(guidAddr net.HardwareAddr) {
+	if d.idleGUIDs == nil {
+		return
+	}
+
+	d.idleGUIDs.lock.Lock()
+	defer d.idleGUIDs.lock.Unlock()
+	delete(d.idleGUIDs.guids, guidAddr.String())
+}
+
+// isIdleGUIDEvicted returns true if the guid was removed from its pKey for being idle
+func (d *daemon) isIdleGUIDEvicted(guidAddr net.HardwareAddr) bool {
+	if d.idleGUIDs == nil {
+		return false
+	}
+
+	d.idleGUIDs.lock.Lock()
+	defer d.idleGUIDs.lock.Unlock()
+	tracked, exist := d.idleGUIDs.guids[guidAddr.String()]
+	return exist && tracked.evicted
+}
+
+// evictIdleGUIDs removes the guids without fabric activity for longer than the idle guid eviction timeout from
+// their pKeys, and adds the evicted guids back to their pKeys when their activity resumes.
+// The pods network annotations keep the evicted guids.
+func (d *daemon) evictIdleGUIDs() {
+	timeout := time.Duration(d.getConfig().IdleGUIDEvictionTimeout) * time.Second
+	// the timeout can be disabled by a configuration update
+	if timeout == 0 {
+		return
+	}
+
+	// copy the tracked guids so the subnet manager calls don't hold the lock
+	d.idleGUIDs.lock.Lock()
+	trackedGUIDs := make([]trackedGUID, 0, len(d.idleGUIDs.guids))
+	for _, tracked := range d.idleGUIDs.guids {
+		trackedGUIDs = append(trackedGUIDs, *tracked)
+	}
+	d.idleGUIDs.lock.Unlock()
+
+	evictions := map[int][]trackedGUID{}
+	resumes := map[int][]trackedGUID{}
+	for _, tracked := range trackedGUIDs {
+		lastActivity, err := d.smClient.GetGUIDLastActivity(context.Background(), tracked.guid)
+		if err != nil {
+			log.Warn().Msgf("failed to get last activity of guid %s with error: %v", tracked.guid, err)
+			continue
+		}
+		if lastActivity.Before(tracked.since) {
+			lastActivity = tracked.since
+		}
+
+		idle := time.Since(lastActivity) > timeout
+		if idle && !tracked.evicted {
+			evictions[tracked.pKey] = append(evictions[tracked.pKey], tracked)
+		} else if !idle && tracked.evicted {
+			resumes[tracked.pKey] = append(resumes[tracked.pKey], tracked)
+		}
+	}
+
+	for _, pKey := range sortedPKeys(evictions) {
+		guids := trackedGUIDAddresses(evictions[pKey])
+		if err := d.smClient.RemoveGuidsFromPKey(context.Background(), pKey, guids); err != nil {
+			log.Warn().Msgf("failed to evict idle guids %v from pKey 0x%04X with error: %v", guids, pKey, err)
+			continue
+		}
+		log.Info().Msgf("evicted idle guids %v from pKey 0x%04X", guids, pKey)
+		d.setIdleGUIDsEvicted(evictions[pKey], true)
+	}
+
+	for _, pKey := range sortedPKeys(resumes) {
+		for _, membership := range []string{plugins.MembershipFull, plugins.MembershipLimited} {
+			membershipResumes := trackedGUIDsWithMembership(resumes[pKey], membership)
+			if len(membershipResumes) == 0 {
+				continue
+			}
+
+			guids := trackedGUIDAddresses(membershipResumes)
+			if err := d.addGuidsToPKey(context.Background(), pKey, guids, membership); err != nil {
+				log.Warn().Msgf("failed to add resumed guids %v to pKey 0x%04X with error: %v", guids, pKey, err)
+				continue
+			}
+			log.Info().Msgf("added resumed guids %v back to pKey 0x%04X", guids, pKey)
+			d.setIdleGUIDsEvicted(membershipResumes, false)
+		}
+	}
+}
+
+// setIdleGUIDsEvicted sets whether the copied tracked guids are evicted, the guids which were released, or released
+// and tracked again, since they were copied are skipped
+func (d *daemon) setIdleGUIDsEvicted(copies []trackedGUID, evicted bool) {
+	d.idleGUIDs.lock.Lock()
+	defer d.idleGUIDs.lock.Unlock()
+	for index := range copies {
+		tracked, exist := d.idleGUIDs.guids[copies[index].guid.String()]
+		if exist && tracked.since.Equal(copies[index].since) {
+			tracked.evicted = evicted
+		}
+	}
+}
+
+func trackedGUIDsWithMembership(tracked []trackedGUID, membership string) []trackedGUID {
+	var filtered []trackedGUID
+	for _, trackedGUID := range tracked {
+		if trackedGUID.membership == membership {
+			filtered = append(filtered, trackedGUID)
+		}
+	}
+	return filtered
+}
+
+func sortedPKeys(guids map[int][]trackedGUID) []int {
+	pKeys := make([]int, 0, len(guids))
+	for pKey := range guids {
+		pKeys = append(pKeys, pKey)
+	}
+	sort.Ints(pKeys)
+	return pKeys
+}
+
+func trackedGUIDAddresses(tracked []trackedGUID) []net.HardwareAddr {
+	guids := make([]net.HardwareAddr, 0, len(tracked))
+	for _, trackedGUID := range tracked {
+		guids = append(guids, trackedGUID.guid)
+	}
+	return guids
+}
@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Durations of the leader election, a new leader is elected at most leaseDuration after the leader stopped renewing
+// its lease
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// waitForLeadership blocks until the daemon is elected leader with the leader election lease, it returns false if
+// a signal is received before. The release function releases the lease on shutdown.
+// The daemon exits if it loses the leadership, the new leader restores the guid pool from the pods annotations.
+func (d *daemon) waitForLeadership(sigChan <-chan os.Signal) (elected bool, release func()) {
+	daemonConfig := d.getConfig()
+	// the lease format is checked by ValidateConfig
+	namespace, name, _ := daemonConfig.GetLeaderElectionLease()
+	identity := daemonConfig.PodName
+	if identity == "" {
+		var err error
+		if identity, err = os.Hostname(); err != nil {
+			log.Error().Msgf("failed to get the leader election identity with error: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	electedChan := make(chan struct{})
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:     d.kubeClient.GetLeasesClient(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	go leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Info().Msgf("%s elected leader with lease namespace %s name %s", identity, namespace, name)
+				close(electedChan)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					// the daemon is shutting down
+					return
+				}
+				log.Error().Msgf("%s lost the leadership of lease namespace %s name %s", identity, namespace, name)
+				os.Exit(1)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Info().Msgf("waiting for the leadership, current leader is %s", leader)
+				}
+			},
+		},
+	})
+
+	log.Info().Msgf("%s waiting for the leadership of lease namespace %s name %s", identity, namespace, name)
+	select {
+	case <-electedChan:
+		return true, cancel
+	case sig := <-sigChan:
+		log.Info().Msgf("signal received while waiting for the leadership: %v", sig)
+		cancel()
+		return false, cancel
+	}
+}
@@ -0,0 +1,22 @@
+package daemon
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+)
+
+// setLogFormat writes the logs as json objects with the fields as keys for the "json" log format, the logs are
+// written as text with key=value fields otherwise
+func setLogFormat(format string) {
+	if format == "json" {
+		log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+	}
+}
+
+// podLogger returns a logger with the network id and the namespace and name of the pod as fields
+func podLogger(networkID string, pod *kapi.Pod) zerolog.Logger {
+	return log.With().Str("network", networkID).Str("namespace", pod.Namespace).Str("pod", pod.Name).Logger()
+}
@@ -0,0 +1,164 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// releaseDeletedNamespaces releases the guids of the pods of the deleted namespaces and drops the namespaces pods
+// from the pods delete map, as their guids are already released. Namespaces which fail to be released are retried
+// in the next update. The delete map must be locked by the caller.
+func (d *daemon) releaseDeletedNamespaces(deleteMap *utils.SynchronizedMap) {
+	_, deletedNamespaces := d.namespaceWatcher.GetHandler().GetResults()
+	deletedNamespaces.Lock()
+	defer deletedNamespaces.Unlock()
+	for namespace := range deletedNamespaces.Items {
+		if err := d.releaseNamespaceGUIDs(namespace); err != nil {
+			log.Warn().Msgf("failed to release guids of deleted namespace %s with error: %v", namespace, err)
+			continue
+		}
+
+		removeNamespacePods(deleteMap, namespace)
+		deletedNamespaces.UnSafeRemove(namespace)
+	}
+}
+
+// releaseNamespaceGUIDs removes the guids allocated for the deleted pods of the namespace from their recorded pKeys in
+// the subnet manager and releases them from the guid pools. Guids without a recorded pKey are only released. The pods
+// of a namespace recreated with the same name keep their guids, and the guids reserved for the namespace pods are
+// dropped only if the namespace has no pods. It returns error if the namespace pods can't be listed or the guids fail
+// to be removed from a pKey, in which case no guid is released.
+func (d *daemon) releaseNamespaceGUIDs(namespace string) error {
+	guidPools := d.getGUIDPools()
+	// the allocations are read before the pods, so the allocations of the pods of a recreated namespace are of
+	// listed pods
+	staleGUIDs := map[string]types.UID{} // allocation uids of the deleted pods by guid
+	pKeysGUIDs := map[string][]net.HardwareAddr{}
+	var allocations []guid.Allocation
+	for _, guidPool := range guidPools {
+		for _, allocation := range guidPool.GetAllocations() {
+			if allocation.Namespace == namespace {
+				allocations = append(allocations, allocation)
+			}
+		}
+	}
+
+	pods, err := d.kubeClient.GetPods(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to list pods of namespace %s with error: %v", namespace, err)
+	}
+	livePods := map[types.UID]bool{}
+	for index := range pods.Items {
+		livePods[d.vmiAnnotator.GetAllocationUID(&pods.Items[index])] = true
+	}
+
+	for _, allocation := range allocations {
+		if livePods[allocation.PodUID] {
+			continue
+		}
+		staleGUIDs[allocation.GUID.String()] = allocation.PodUID
+		if allocation.PKey != "" {
+			pKeysGUIDs[allocation.PKey] = append(pKeysGUIDs[allocation.PKey], allocation.GUID.HardWareAddress())
+		}
+	}
+
+	for pKeyName, guidList := range pKeysGUIDs {
+		pKey, parseErr := utils.ParsePKey(pKeyName)
+		if parseErr != nil {
+			return fmt.Errorf("failed to parse pKey %s with error: %v", pKeyName, parseErr)
+		}
+
+		if err = d.smClient.RemoveGuidsFromPKey(context.Background(), pKey, guidList); err != nil {
+			return fmt.Errorf("failed to remove guids %v from pKey %s with subnet manager %s with error: %v",
+				guidList, pKeyName, d.smClient.Name(), err)
+		}
+	}
+
+	d.guidAllocationLock.Lock()
+	releasedGUIDs := d.releaseStaleNamespaceGUIDs(guidPools, namespace, staleGUIDs, len(livePods) == 0)
+	d.guidAllocationLock.Unlock()
+
+	for _, releasedGUID := range releasedGUIDs {
+		if guidAddr, parseErr := net.ParseMAC(releasedGUID); parseErr == nil {
+			d.removeDNSRecord(guidAddr)
+			d.untrackIdleGUID(guidAddr)
+		}
+	}
+
+	if len(releasedGUIDs) != 0 {
+		log.Info().Msgf("released guids %v of deleted namespace %s", releasedGUIDs, namespace)
+	}
+	return nil
+}
+
+// releaseStaleNamespaceGUIDs releases the guids of the deleted pods of the namespace and returns them. If the namespace
+// has no pods, and no guid was allocated for its pods since they were listed, all its guids and reservations are
+// released. The caller should hold guidAllocationLock.
+func (d *daemon) releaseStaleNamespaceGUIDs(guidPools []guid.Pool, namespace string, staleGUIDs map[string]types.UID,
+	noPods bool) []string {
+	var releasedGUIDs []string
+	for _, guidPool := range guidPools {
+		releaseAll := noPods
+		var poolStaleGUIDs []string
+		for _, allocation := range guidPool.GetAllocations() {
+			if allocation.Namespace != namespace {
+				continue
+			}
+			guidAddr := allocation.GUID.String()
+			if staleUID, stale := staleGUIDs[guidAddr]; stale && staleUID == allocation.PodUID {
+				poolStaleGUIDs = append(poolStaleGUIDs, guidAddr)
+			} else {
+				releaseAll = false
+			}
+		}
+
+		if releaseAll {
+			// pools without guids of the namespace return error
+			if released, err := guidPool.ReleaseAllGUIDsInNamespace(namespace); err == nil {
+				releasedGUIDs = append(releasedGUIDs, released...)
+			}
+			continue
+		}
+		for _, guidAddr := range poolStaleGUIDs {
+			if err := guidPool.ReleaseGUID(guidAddr); err == nil {
+				releasedGUIDs = append(releasedGUIDs, guidAddr)
+			}
+		}
+	}
+
+	for _, releasedGUID := range releasedGUIDs {
+		delete(d.guidPodNetworkMap, releasedGUID)
+	}
+	return releasedGUIDs
+}
+
+// removeNamespacePods removes the pods of the namespace from the pods of every network in the pods map
+func removeNamespacePods(podsMap *utils.SynchronizedMap, namespace string) {
+	for networkID, podsInterface := range podsMap.Items {
+		pods, ok := podsInterface.([]*kapi.Pod)
+		if !ok {
+			continue
+		}
+
+		var remainingPods []*kapi.Pod
+		for _, pod := range pods {
+			if pod.Namespace != namespace {
+				remainingPods = append(remainingPods, pod)
+			}
+		}
+
+		if len(remainingPods) == 0 {
+			podsMap.UnSafeRemove(networkID)
+		} else {
+			podsMap.UnSafeSet(networkID, remainingPods)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+)
+
+// Reasons of the network reconcile failures metric
+const (
+	reconcileFailurePKey               = "pkey" // the network pKey or its membership can't be assigned or parsed
+	reconcileFailureNamespaceIsolation = "namespace_isolation"
+	reconcileFailurePKeyReservations   = "pkey_reservations"
+	reconcileFailurePKeyCapacity       = "pkey_capacity"
+	reconcileFailureSubnetManager      = "subnet_manager"
+	reconcileFailurePods               = "pods" // some of the network pods failed and are retried
+)
+
+// recordNetworkReconcile records the time spent processing the pods of the network and its failure reason, empty if
+// it succeeded. It is called only for InfiniBand networks, so the metrics cardinality is bounded by the InfiniBand
+// network attachment definitions and not by the networks requested by the pods.
+func recordNetworkReconcile(networkID string, duration time.Duration, failureReason string) {
+	metrics.NetworkReconcileDuration.WithLabelValues(networkID).Observe(duration.Seconds())
+	if failureReason != "" {
+		metrics.NetworkReconcileFailures.WithLabelValues(networkID, failureReason).Inc()
+	}
+}
@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// networkSpecCacheSize is the maximum number of network attachment definitions whose parsed specs are cached
+const networkSpecCacheSize = 1024
+
+// errInvalidNetworkConfig is returned for network attachment definitions whose cni config isn't valid json
+var errInvalidNetworkConfig = errors.New("invalid network attachment definition config")
+
+// errCNIConfigMapUnavailable is returned for network attachment definitions whose cni config ConfigMap can't be read,
+// their networks are retried
+var errCNIConfigMapUnavailable = errors.New("cni config ConfigMap unavailable")
+
+// cachedNetworkSpec is the InfiniBand SR-IOV CNI spec parsed from a resource version of a network attachment
+// definition
+type cachedNetworkSpec struct {
+	key             string
+	resourceVersion string
+	spec            utils.IbSriovCniSpec
+}
+
+// networkSpecCache holds the parsed InfiniBand SR-IOV CNI specs of the network attachment definitions, so they are
+// parsed again only when their resource version changes. The least recently used spec is evicted once the cache
+// holds maxSize specs.
+type networkSpecCache struct {
+	lock    sync.Mutex
+	maxSize int
+	specs   map[string]*list.Element // cachedNetworkSpec elements mapped by "<namespace>/<name>"
+	recent  *list.List               // cachedNetworkSpec elements, the most recently used first
+}
+
+func newNetworkSpecCache(maxSize int) *networkSpecCache {
+	return &networkSpecCache{maxSize: maxSize, specs: map[string]*list.Element{}, recent: list.New()}
+}
+
+// get returns a copy of the cached spec of the network attachment definition. It returns false if the spec isn't
+// cached or was parsed from another resource version.
+func (c *networkSpecCache) get(namespace, name, resourceVersion string) (*utils.IbSriovCniSpec, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.specs[namespace+"/"+name]
+	if !ok {
+		return nil, false
+	}
+
+	cached := element.Value.(*cachedNetworkSpec)
+	if cached.resourceVersion != resourceVersion {
+		c.recent.Remove(element)
+		delete(c.specs, cached.key)
+		return nil, false
+	}
+
+	c.recent.MoveToFront(element)
+	spec := cached.spec
+	return &spec, true
+}
+
+// add caches a copy of the spec parsed from the network attachment definition, evicting the least recently used spec
+// if the cache is full
+func (c *networkSpecCache) add(namespace, name, resourceVersion string, spec *utils.IbSriovCniSpec) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := namespace + "/" + name
+	if element, ok := c.specs[key]; ok {
+		c.recent.Remove(element)
+	}
+
+	c.specs[key] = c.recent.PushFront(&cachedNetworkSpec{key: key, resourceVersion: resourceVersion, spec: *spec})
+	for c.recent.Len() > c.maxSize {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.specs, oldest.Value.(*cachedNetworkSpec).key)
+	}
+}
+
+// getIbSriovCniSpec returns the InfiniBand SR-IOV CNI spec of the network attachment definition of the given
+// namespace and name, from the network spec cache if its resource version was already parsed. Specs read from the cni
+// config ConfigMap aren't cached, as the ConfigMap may change without the network attachment definition.
+// It returns error wrapping errInvalidNetworkConfig if the cni config isn't valid json, or error if the network
+// isn't an InfiniBand SR-IOV network.
+func (d *daemon) getIbSriovCniSpec(namespace, name string, netAttDef *v1.NetworkAttachmentDefinition) (
+	*utils.IbSriovCniSpec, error) {
+	if d.networkSpecs != nil {
+		if ibCniSpec, ok := d.networkSpecs.get(namespace, name, netAttDef.ResourceVersion); ok {
+			return ibCniSpec, nil
+		}
+	}
+
+	networkSpec := make(map[string]interface{})
+	if netAttDef.Spec.Config != "" {
+		if err := json.Unmarshal([]byte(netAttDef.Spec.Config), &networkSpec); err != nil {
+			return nil, fmt.Errorf("%w %s/%s: %v", errInvalidNetworkConfig, namespace, name, err)
+		}
+	}
+
+	confName := netAttDef.Annotations[utils.CNIConfNameAnnotation]
+	ibCniSpec, err := d.getIbSriovCniFromNetwork(networkSpec, namespace, confName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get InfiniBand SR-IOV CNI spec from network attachment %s/%s config %s: %w",
+			namespace, name, netAttDef.Spec.Config, err)
+	}
+
+	if d.networkSpecs != nil && confName == "" && netAttDef.ResourceVersion != "" {
+		d.networkSpecs.add(namespace, name, netAttDef.ResourceVersion, ibCniSpec)
+	}
+	return ibCniSpec, nil
+}
+
+// getIbSriovCniFromNetwork returns the InfiniBand SR-IOV CNI spec of the network spec, if the network spec has no cni
+// config it falls back to the cni config stored in the ConfigMap confName in the given namespace.
+// It returns error wrapping errCNIConfigMapUnavailable if the ConfigMap can't be read.
+func (d *daemon) getIbSriovCniFromNetwork(networkSpec map[string]interface{}, namespace, confName string) (
+	*utils.IbSriovCniSpec, error) {
+	if utils.HasCniConfig(networkSpec) || confName == "" {
+		return utils.GetIbSriovCniFromNetwork(networkSpec)
+	}
+
+	configMap, err := d.kubeClient.GetConfigMap(namespace, confName)
+	if err != nil {
+		return nil, fmt.Errorf("%w, failed to get ConfigMap %s in namespace %s: %v", errCNIConfigMapUnavailable,
+			confName, namespace, err)
+	}
+	return utils.GetIbSriovCniFromConfigMap(configMap)
+}
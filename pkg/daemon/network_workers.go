@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"sync"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// processAddNetworks processes the added pods of the networks in priority order. With MaxConcurrentNetworks above 1
+// the networks are grouped by their shared pods and the groups are processed concurrently by a bounded worker pool,
+// the networks of a group are processed in priority order by the same worker.
+// The results are returned in the groups order, to be applied to the add map once all the networks are processed.
+func (d *daemon) processAddNetworks(items map[string]interface{}) []*addNetworkResult {
+	networkIDs := d.sortNetworksByPriority(items)
+	groups := [][]string{networkIDs}
+	workers := d.getConfig().MaxConcurrentNetworks
+	if workers > 1 {
+		groups = groupNetworksBySharedPods(networkIDs, items)
+	}
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	groupIndexes := make(chan int, len(groups))
+	for index := range groups {
+		groupIndexes <- index
+	}
+	close(groupIndexes)
+
+	// every group results are written only by the worker of the group
+	groupResults := make([][]*addNetworkResult, len(groups))
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range groupIndexes {
+				podNetworksMap := map[types.UID][]*v1.NetworkSelectionElement{}
+				for _, networkID := range groups[index] {
+					groupResults[index] = append(groupResults[index],
+						d.processAddNetwork(networkID, items[networkID], podNetworksMap))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	results := make([]*addNetworkResult, 0, len(networkIDs))
+	for _, groupResult := range groupResults {
+		results = append(results, groupResult...)
+	}
+	return results
+}
+
+// groupNetworksBySharedPods groups the networks which share pods, a group is ordered by the networks order and the
+// groups are ordered by their first network
+func groupNetworksBySharedPods(networkIDs []string, items map[string]interface{}) [][]string {
+	// parents of the networks indexes in the disjoint sets of the groups, the root of a set is its first network
+	parents := make([]int, len(networkIDs))
+	for index := range parents {
+		parents[index] = index
+	}
+	findRoot := func(index int) int {
+		for parents[index] != index {
+			parents[index] = parents[parents[index]]
+			index = parents[index]
+		}
+		return index
+	}
+
+	podNetworks := map[types.UID]int{} // index of the first network of every pod
+	for index, networkID := range networkIDs {
+		pods, _ := items[networkID].([]*kapi.Pod)
+		for _, pod := range pods {
+			first, ok := podNetworks[pod.UID]
+			if !ok {
+				podNetworks[pod.UID] = index
+				continue
+			}
+
+			root, otherRoot := findRoot(first), findRoot(index)
+			if otherRoot < root {
+				root, otherRoot = otherRoot, root
+			}
+			parents[otherRoot] = root
+		}
+	}
+
+	var groups [][]string
+	groupIndexes := map[int]int{} // group index mapped by its root
+	for index, networkID := range networkIDs {
+		root := findRoot(index)
+		groupIndex, ok := groupIndexes[root]
+		if !ok {
+			groupIndex = len(groups)
+			groupIndexes[root] = groupIndex
+			groups = append(groups, nil)
+		}
+		groups[groupIndex] = append(groups[groupIndex], networkID)
+	}
+	return groups
+}
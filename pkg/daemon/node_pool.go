@@ -0,0 +1,179 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+)
+
+const (
+	// nodeSubRangeKeyPrefix is the prefix of the per node pool config map keys, followed by the sub-range index.
+	// The keys values are the names of the nodes which claimed the sub-ranges.
+	nodeSubRangeKeyPrefix = "subrange-"
+	// nodeSubRangeClaimAttempts is the number of attempts to claim a sub-range when other nodes claim concurrently
+	nodeSubRangeClaimAttempts = 5
+)
+
+// initNodeSubRanges adds the sub-ranges registered for the node to the guid pool, a sub-range is claimed if the node
+// has none. Each node generates guids from its own sub-ranges so the nodes don't coordinate the guids allocation.
+func (d *daemon) initNodeSubRanges() error {
+	daemonConfig := d.getConfig()
+	configMap, _, err := d.getNodeSubRangesConfigMap()
+	if err != nil {
+		return err
+	}
+
+	var claimed bool
+	for key, nodeName := range configMap.Data {
+		if nodeName != daemonConfig.NodeName || !strings.HasPrefix(key, nodeSubRangeKeyPrefix) {
+			continue
+		}
+
+		index, parseErr := strconv.ParseUint(strings.TrimPrefix(key, nodeSubRangeKeyPrefix), 10, 64)
+		if parseErr != nil {
+			log.Warn().Msgf("invalid guid pool sub-range key %s with error: %v", key, parseErr)
+			continue
+		}
+
+		if err = d.addNodeSubRange(index); err != nil {
+			return err
+		}
+		claimed = true
+	}
+
+	if claimed {
+		return nil
+	}
+	return d.claimNodeSubRange()
+}
+
+// claimNodeSubRange registers a free sub-range for the node in the per node pool config map and adds it to the
+// guid pool. The first sub-range probed is chosen by a stable hash of the node name.
+// The config map update fails if another node changed it since it was read, in which case the claim is retried.
+func (d *daemon) claimNodeSubRange() error {
+	daemonConfig := d.getConfig()
+	subRanges, err := d.nodeSubRangesCount()
+	if err != nil {
+		return err
+	}
+
+	nodeHash := fnv.New64a()
+	_, _ = nodeHash.Write([]byte(daemonConfig.NodeName))
+	firstIndex := nodeHash.Sum64() % subRanges
+
+	for attempt := 0; attempt < nodeSubRangeClaimAttempts; attempt++ {
+		configMap, exists, getErr := d.getNodeSubRangesConfigMap()
+		if getErr != nil {
+			return getErr
+		}
+
+		index, found := uint64(0), false
+		for offset := uint64(0); offset < subRanges; offset++ {
+			index = (firstIndex + offset) % subRanges
+			if _, claimed := configMap.Data[nodeSubRangeKeyPrefix+strconv.FormatUint(index, 10)]; !claimed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("all the %d guid pool sub-ranges are claimed", subRanges)
+		}
+
+		configMap.Data[nodeSubRangeKeyPrefix+strconv.FormatUint(index, 10)] = daemonConfig.NodeName
+		if exists {
+			err = d.kubeClient.UpdateConfigMap(configMap)
+		} else {
+			err = d.kubeClient.CreateConfigMap(configMap)
+		}
+		if apiErrors.IsConflict(err) || apiErrors.IsAlreadyExists(err) {
+			log.Info().Msgf("guid pool sub-ranges changed by another node, retrying claim")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to register guid pool sub-range with error: %v", err)
+		}
+
+		log.Info().Msgf("claimed guid pool sub-range %d for node %s", index, daemonConfig.NodeName)
+		return d.addNodeSubRange(index)
+	}
+
+	return fmt.Errorf("failed to claim guid pool sub-range after %d attempts", nodeSubRangeClaimAttempts)
+}
+
+// generateGUID generates a guid of the namespace from the guid pool, an overflow sub-range is claimed for the node
+// if its sub-ranges are exhausted
+func (d *daemon) generateGUID(guidPool guid.Pool, namespace string) (guid.GUID, error) {
+	guidAddr, err := guidPool.GenerateNamespaceGUID(namespace)
+	if err == nil || !errors.Is(err, guid.ErrPoolExhausted) || !d.getConfig().PerNodePool || guidPool != d.guidPool {
+		return guidAddr, err
+	}
+
+	log.Info().Msg("guid pool sub-ranges of the node are exhausted, claiming overflow sub-range")
+	if claimErr := d.claimNodeSubRange(); claimErr != nil {
+		return 0, fmt.Errorf("%v, failed to claim overflow sub-range: %v", err, claimErr)
+	}
+	return guidPool.GenerateNamespaceGUID(namespace)
+}
+
+// getNodeSubRangesConfigMap returns the per node pool config map, a new config map if it doesn't exist
+func (d *daemon) getNodeSubRangesConfigMap() (*kapi.ConfigMap, bool, error) {
+	// the config map format is checked by ValidateConfig
+	daemonConfig := d.getConfig()
+	namespace, name, _ := daemonConfig.GetPerNodePoolConfigMap()
+	configMap, err := d.kubeClient.GetConfigMap(namespace, name)
+	if apiErrors.IsNotFound(err) {
+		return &kapi.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Data: map[string]string{}}, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get guid pool sub-ranges config map %s/%s with error: %v",
+			namespace, name, err)
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	return configMap, true, nil
+}
+
+// nodeSubRangesCount returns the number of sub-ranges in the guid pool range
+func (d *daemon) nodeSubRangesCount() (uint64, error) {
+	daemonConfig := d.getConfig()
+	rangeStart, err := guid.ParseGUID(daemonConfig.GUIDPool.RangeStart)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse guid pool range start: %v", err)
+	}
+	rangeEnd, err := guid.ParseGUID(daemonConfig.GUIDPool.RangeEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse guid pool range end: %v", err)
+	}
+
+	subRanges := (uint64(rangeEnd-rangeStart) + 1) / uint64(daemonConfig.PerNodePoolSize)
+	if subRanges == 0 {
+		return 0, fmt.Errorf("guid pool range is smaller than the per node pool size %d",
+			daemonConfig.PerNodePoolSize)
+	}
+	return subRanges, nil
+}
+
+// addNodeSubRange adds the sub-range with the given index to the guid pool
+func (d *daemon) addNodeSubRange(index uint64) error {
+	daemonConfig := d.getConfig()
+	rangeStart, err := guid.ParseGUID(daemonConfig.GUIDPool.RangeStart)
+	if err != nil {
+		return fmt.Errorf("failed to parse guid pool range start: %v", err)
+	}
+
+	size := uint64(daemonConfig.PerNodePoolSize)
+	start := rangeStart + guid.GUID(index*size)
+	return d.guidPool.AddSubRange(start, start+guid.GUID(size-1))
+}
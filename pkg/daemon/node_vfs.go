@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	resEvenHandler "github.com/Mellanox/ib-kubernetes/pkg/watcher/handler"
+)
+
+// guidPoolExtendedReason is the reason of the daemon pod events of guid pool extensions
+const guidPoolExtendedReason = "GUIDPoolExtended"
+
+// updateNodeVFs extends the guid pool range by the number of SR-IOV VFs added to the node since the highest VFs
+// count seen, the first VFs count seen is covered by the configured guid pool range.
+// The guid pool isn't shrunk when VFs are removed.
+func (d *daemon) updateNodeVFs() {
+	updatedNodes, _ := d.nodeWatcher.GetHandler().GetResults()
+	updatedNodes.Lock()
+	defer updatedNodes.Unlock()
+	for nodeName, nodeInterface := range updatedNodes.Items {
+		updatedNodes.UnSafeRemove(nodeName)
+		node, ok := nodeInterface.(*kapi.Node)
+		if !ok {
+			log.Error().Msgf("invalid value for updated node %s: %v", nodeName, nodeInterface)
+			continue
+		}
+
+		numVFs, ok := resEvenHandler.GetNodeVFs(node)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case d.nodeVFs < 0:
+			log.Info().Msgf("node %s has %d SR-IOV VFs", nodeName, numVFs)
+			d.nodeVFs = numVFs
+		case numVFs < d.nodeVFs:
+			log.Warn().Msgf("node %s SR-IOV VFs count decreased from %d to %d, guid pool isn't shrunk",
+				nodeName, d.nodeVFs, numVFs)
+		case numVFs > d.nodeVFs:
+			addedVFs := numVFs - d.nodeVFs
+			if err := d.guidPool.ExtendRange(uint64(addedVFs)); err != nil {
+				log.Error().Msgf("failed to extend guid pool for %d SR-IOV VFs added to node %s with error: %v",
+					addedVFs, nodeName, err)
+				continue
+			}
+
+			stats := d.guidPool.Stats()
+			d.reportDaemonEvent(kapi.EventTypeNormal, guidPoolExtendedReason, fmt.Sprintf(
+				"guid pool extended by %d guids to %d guids for %d SR-IOV VFs of node %s",
+				addedVFs, stats.Total, numVFs, nodeName))
+			d.nodeVFs = numVFs
+		}
+	}
+}
+
+// reportDaemonEvent logs the message and creates event of the given type with the given reason and message on the
+// daemon pod, if its name is set
+func (d *daemon) reportDaemonEvent(eventType, reason, message string) {
+	if eventType == kapi.EventTypeWarning {
+		log.Warn().Msg(message)
+	} else {
+		log.Info().Msg(message)
+	}
+	daemonConfig := d.getConfig()
+	if daemonConfig.PodName == "" {
+		return
+	}
+
+	pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: daemonConfig.PodNamespace, Name: daemonConfig.PodName}}
+	if err := d.kubeClient.CreatePodEvent(pod, eventType, reason, message); err != nil {
+		log.Warn().Msgf("failed to create %s event of daemon pod namespace %s name %s with error: %v",
+			reason, pod.Namespace, pod.Name, err)
+	}
+}
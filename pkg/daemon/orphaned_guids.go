@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// ReconcileOrphanedGUIDs releases the guids allocated in the guid pools for pods which no longer exist, and removes
+// them from their last known pKey in the subnet manager. Guids without a recorded pKey are only released, and guids
+// which fail to be removed from their pKey are kept allocated so they aren't reused while still pKey members.
+// The guid ranges of network attachment definitions aren't pod allocations and are kept.
+func (d *daemon) ReconcileOrphanedGUIDs() error {
+	return d.reconcileOrphanedGUIDs(nil)
+}
+
+// OrphanedGUIDsPeriodicUpdate reconciles the orphaned guids while holding the add and delete maps, so the guids
+// allocated and released by the add and delete updates meanwhile aren't reclaimed. The guids of the deleted pods
+// pending in the delete map are left to the delete update.
+func (d *daemon) OrphanedGUIDsPeriodicUpdate() {
+	log.Info().Msg("running orphaned guids periodic update")
+	addMap, deleteMap := d.watcher.GetHandler().GetResults()
+	addMap.Lock()
+	defer addMap.Unlock()
+	deleteMap.Lock()
+	defer deleteMap.Unlock()
+
+	pendingUIDs := map[types.UID]bool{}
+	for _, podsInterface := range deleteMap.Items {
+		pods, _ := podsInterface.([]*kapi.Pod)
+		for _, pod := range pods {
+			pendingUIDs[d.vmiAnnotator.GetAllocationUID(pod)] = true
+		}
+	}
+
+	if err := d.reconcileOrphanedGUIDs(pendingUIDs); err != nil {
+		log.Warn().Msgf("failed to reclaim orphaned guids with error: %v", err)
+		return
+	}
+	if d.poolPersistence != nil {
+		d.persistGUIDPool()
+	}
+	log.Info().Msg("orphaned guids periodic update finished")
+}
+
+// reconcileOrphanedGUIDs reclaims the guids of the pods which no longer exist, except the guids of the pods of the
+// given allocation UIDs
+func (d *daemon) reconcileOrphanedGUIDs(skippedUIDs map[types.UID]bool) error {
+	pods, err := d.kubeClient.GetPods(kapi.NamespaceAll)
+	if err != nil {
+		return fmt.Errorf("failed to get pods from kubernetes: %v", err)
+	}
+
+	allocationUIDs := make(map[types.UID]bool, len(pods.Items)+len(skippedUIDs))
+	for index := range pods.Items {
+		allocationUIDs[d.vmiAnnotator.GetAllocationUID(&pods.Items[index])] = true
+	}
+	for uid := range skippedUIDs {
+		allocationUIDs[uid] = true
+	}
+
+	for _, guidPool := range d.getGUIDPools() {
+		for _, allocation := range guidPool.GetAllocations() {
+			if allocation.Namespace == "" || allocationUIDs[allocation.PodUID] {
+				continue
+			}
+
+			d.reclaimOrphanedGUID(guidPool, allocation)
+		}
+	}
+
+	d.flushDNSRecords()
+	return nil
+}
+
+// reclaimOrphanedGUID removes the guid from its pKey if known and releases it from the guid pool
+func (d *daemon) reclaimOrphanedGUID(guidPool guid.Pool, allocation guid.Allocation) {
+	guidAddr := allocation.GUID.HardWareAddress()
+	if allocation.PKey != "" {
+		pKey, err := utils.ParsePKey(allocation.PKey)
+		if err != nil {
+			log.Warn().Msgf("failed to parse pKey %s of orphaned guid %s with error: %v", allocation.PKey,
+				allocation.GUID, err)
+			return
+		}
+
+		if err = d.smClient.RemoveGuidsFromPKey(context.Background(), pKey, []net.HardwareAddr{guidAddr}); err != nil {
+			log.Warn().Msgf("failed to remove orphaned guid %s from pKey %s with subnet manager %s with error: %v",
+				allocation.GUID, allocation.PKey, d.smClient.Name(), err)
+			return
+		}
+	}
+
+	if err := d.releasePoolGUID(guidPool, allocation.GUID.String()); err != nil {
+		log.Warn().Msgf("failed to release orphaned guid %s with error: %v", allocation.GUID, err)
+		return
+	}
+
+	d.removeDNSRecord(guidAddr)
+	d.untrackIdleGUID(guidAddr)
+	log.Info().Msgf("reclaimed orphaned guid %s of pod uid %s namespace %s network %s pKey %q",
+		allocation.GUID, allocation.PodUID, allocation.Namespace, allocation.Network, allocation.PKey)
+}
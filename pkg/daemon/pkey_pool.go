@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// initPKeyPool restores the pKeys assigned to the networks from the pKey pool config map, the config map doesn't
+// exist before the first pKey is assigned. The pKeys of network attachment definitions which no longer exist are
+// released.
+func (d *daemon) initPKeyPool() error {
+	log.Info().Msg("Initializing pKey pool.")
+	// the config map format is checked by ValidateConfig
+	daemonConfig := d.getConfig()
+	namespace, name, _ := daemonConfig.PKeyPool.GetConfigMap()
+	configMap, err := d.kubeClient.GetConfigMap(namespace, name)
+	if apiErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get pKey pool config map %s/%s with error: %v", namespace, name, err)
+	}
+
+	netAttDefs, err := d.kubeClient.GetNetworkAttachmentDefinitions(kapi.NamespaceAll)
+	if err != nil {
+		return fmt.Errorf("failed to get network attachment definitions from kubernetes: %v", err)
+	}
+	existingNetworks := make(map[string]bool, len(netAttDefs.Items))
+	for index := range netAttDefs.Items {
+		existingNetworks[utils.GenerateNetAttDefNetworkID(&netAttDefs.Items[index])] = true
+	}
+
+	allocations := make(map[string]int, len(configMap.Data))
+	for networkID, pKeyName := range configMap.Data {
+		if !existingNetworks[networkID] {
+			log.Info().Msgf("releasing pKey %s of deleted network attachment %s", pKeyName, networkID)
+			continue
+		}
+
+		pKey, parseErr := utils.ParsePKey(pKeyName)
+		if parseErr != nil {
+			log.Warn().Msgf("invalid pKey %s of network %s in pKey pool config map: %v", pKeyName, networkID,
+				parseErr)
+			continue
+		}
+		allocations[networkID] = pKey
+	}
+
+	if err = d.pKeyPool.Restore(allocations); err != nil {
+		return err
+	}
+
+	if len(allocations) != len(configMap.Data) {
+		return d.persistPKeyPool()
+	}
+	return nil
+}
+
+// assignNetworkPKey returns the pKey assigned to the network from the pKey pool, a pKey is assigned and persisted if
+// the network has none
+func (d *daemon) assignNetworkPKey(networkID string) (string, error) {
+	if pKey, ok := d.pKeyPool.GetPKey(networkID); ok {
+		return formatPKey(pKey), nil
+	}
+
+	pKey, err := d.pKeyPool.AllocatePKey(networkID)
+	if err != nil {
+		return "", err
+	}
+
+	// the pKey isn't used until it's persisted, so it's assigned to the network again after restart
+	if err = d.persistPKeyPool(); err != nil {
+		if releaseErr := d.pKeyPool.ReleasePKey(networkID); releaseErr != nil {
+			log.Warn().Msgf("failed to release pKey of network %s with error: %v", networkID, releaseErr)
+		}
+		return "", err
+	}
+	return formatPKey(pKey), nil
+}
+
+// getNetworkPKey returns the pKey of the network spec, or the pKey assigned to the network from the pKey pool if the
+// spec has no pKey
+func (d *daemon) getNetworkPKey(networkID string, ibCniSpec *utils.IbSriovCniSpec) string {
+	if ibCniSpec.PKey != "" || d.pKeyPool == nil {
+		return ibCniSpec.PKey
+	}
+
+	if pKey, ok := d.pKeyPool.GetPKey(networkID); ok {
+		return formatPKey(pKey)
+	}
+	return ""
+}
+
+// releaseNetworkPKey releases the pKey assigned to the deleted network, if any
+func (d *daemon) releaseNetworkPKey(networkID string) {
+	if _, ok := d.pKeyPool.GetPKey(networkID); !ok {
+		return
+	}
+
+	if err := d.pKeyPool.ReleasePKey(networkID); err != nil {
+		log.Warn().Msgf("failed to release pKey of network %s with error: %v", networkID, err)
+		return
+	}
+
+	if err := d.persistPKeyPool(); err != nil {
+		log.Warn().Msgf("failed to persist released pKey of network %s with error: %v", networkID, err)
+	}
+}
+
+// persistPKeyPool writes the pKeys assigned to the networks to the pKey pool config map
+func (d *daemon) persistPKeyPool() error {
+	daemonConfig := d.getConfig()
+	namespace, name, _ := daemonConfig.PKeyPool.GetConfigMap()
+	allocations := d.pKeyPool.Allocations()
+	data := make(map[string]string, len(allocations))
+	for networkID, pKey := range allocations {
+		data[networkID] = formatPKey(pKey)
+	}
+
+	if err := d.kubeClient.SetConfigMapData(namespace, name, data); err != nil {
+		return fmt.Errorf("failed to write pKey pool config map %s/%s with error: %v", namespace, name, err)
+	}
+	return nil
+}
+
+func formatPKey(pKey int) string {
+	return fmt.Sprintf("0x%04X", pKey)
+}
@@ -0,0 +1,124 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+
+	ibapi "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// ErrPKeyReservationExceeded is returned when adding pods to a pKey would exceed the seats reserved for their namespace
+var ErrPKeyReservationExceeded = errors.New("pKey reservation exceeded")
+
+// pKeyReservationReason is the reason of the pods events of exceeded pKey reservations
+const pKeyReservationReason = "PKeyReservationExceeded"
+
+// limitToPKeyReservations trims the guids to add to the free seats reserved for the pods namespaces in the pKey.
+// Pods of namespaces without free seats are moved to the failed pods to be retried once seats are released,
+// namespaces without a reservation of the pKey aren't limited.
+func (d *daemon) limitToPKeyReservations(pKey int, passedPods []*kapi.Pod, guidList []net.HardwareAddr,
+	failedPods []*kapi.Pod) ([]*kapi.Pod, []net.HardwareAddr, []*kapi.Pod, error) {
+	reservations, err := d.kubeClient.GetPKeyReservations()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get pKey reservations with error: %v", err)
+	}
+
+	maxSeats := map[string]int{}
+	for index := range reservations.Items {
+		reservation := &reservations.Items[index]
+		if isReservationOfPKey(reservation, pKey) {
+			maxSeats[reservation.Spec.Namespace] = reservation.Spec.MaxSeats
+		}
+	}
+	if len(maxSeats) == 0 {
+		return passedPods, guidList, failedPods, nil
+	}
+
+	seats := d.countPKeySeats(pKey)
+	var allowedPods, exceededPods []*kapi.Pod
+	var allowedGUIDs []net.HardwareAddr
+	for index, pod := range passedPods {
+		namespaceMaxSeats, reserved := maxSeats[pod.Namespace]
+		if reserved && seats[pod.Namespace] >= namespaceMaxSeats {
+			exceededPods = append(exceededPods, pod)
+			continue
+		}
+		seats[pod.Namespace]++
+		allowedPods = append(allowedPods, pod)
+		allowedGUIDs = append(allowedGUIDs, guidList[index])
+	}
+
+	for _, pod := range exceededPods {
+		err = fmt.Errorf("%w: namespace %s has no free seats of its %d seats in pKey 0x%04X, will retry",
+			ErrPKeyReservationExceeded, pod.Namespace, maxSeats[pod.Namespace], pKey)
+		log.Warn().Msgf("pod namespace %s name %s: %v", pod.Namespace, pod.Name, err)
+		d.warnPods([]*kapi.Pod{pod}, pKeyReservationReason, err.Error())
+	}
+
+	return allowedPods, allowedGUIDs, append(failedPods, exceededPods...), nil
+}
+
+// updatePKeyReservationsStatus sets the current seats of the pKey reservations to the number of guids of their
+// namespace pods added to their pKey
+func (d *daemon) updatePKeyReservationsStatus() {
+	reservations, err := d.kubeClient.GetPKeyReservations()
+	if err != nil {
+		log.Warn().Msgf("failed to get pKey reservations with error: %v", err)
+		return
+	}
+
+	pKeysSeats := map[int]map[string]int{}
+	for index := range reservations.Items {
+		reservation := &reservations.Items[index]
+		pKey, parseErr := utils.ParsePKey(reservation.Spec.PKey)
+		if parseErr != nil {
+			log.Warn().Msgf("invalid pKey %s of pKey reservation %s: %v", reservation.Spec.PKey, reservation.Name,
+				parseErr)
+			continue
+		}
+
+		seats, ok := pKeysSeats[pKey]
+		if !ok {
+			seats = d.countPKeySeats(pKey)
+			pKeysSeats[pKey] = seats
+		}
+
+		if reservation.Status.CurrentSeats == seats[reservation.Spec.Namespace] {
+			continue
+		}
+
+		reservation.Status.CurrentSeats = seats[reservation.Spec.Namespace]
+		if _, err = d.kubeClient.UpdatePKeyReservationStatus(reservation); err != nil {
+			log.Warn().Msgf("failed to update pKey reservation %s status with error: %v", reservation.Name, err)
+		}
+	}
+}
+
+// countPKeySeats returns the number of guids added to the pKey in the guid pools mapped by their pods namespace
+func (d *daemon) countPKeySeats(pKey int) map[string]int {
+	seats := map[string]int{}
+	for _, guidPool := range d.getGUIDPools() {
+		for _, allocation := range guidPool.GetAllocations() {
+			if allocation.Namespace == "" || allocation.PKey == "" {
+				continue
+			}
+
+			if allocationPKey, err := utils.ParsePKey(allocation.PKey); err == nil && allocationPKey == pKey {
+				seats[allocation.Namespace]++
+			}
+		}
+	}
+
+	return seats
+}
+
+// isReservationOfPKey checks the pKey reservation is of the given pKey, reservations with invalid pKey are ignored
+func isReservationOfPKey(reservation *ibapi.PKeyReservation, pKey int) bool {
+	reservationPKey, err := utils.ParsePKey(reservation.Spec.PKey)
+	return err == nil && reservationPKey == pKey
+}
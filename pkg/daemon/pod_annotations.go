@@ -0,0 +1,24 @@
+package daemon
+
+import (
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	kapi "k8s.io/api/core/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// podAnnotationKeys are the pod annotations set by the daemon
+var podAnnotationKeys = []string{v1.NetworkAttachmentAnnot, KubeVirtIBGUIDAnnotation, utils.GUIDSignatureAnnotation,
+	utils.PortSpeedAnnotation, utils.PortWidthAnnotation}
+
+// daemonPodAnnotations returns the annotations of the pod set by the daemon. The pods are patched with them only
+// instead of all their annotations, so the patches stay small and don't override annotations changed meanwhile.
+func daemonPodAnnotations(pod *kapi.Pod) map[string]string {
+	annotations := map[string]string{}
+	for _, key := range podAnnotationKeys {
+		if value, exist := pod.Annotations[key]; exist {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
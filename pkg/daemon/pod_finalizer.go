@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// addPodFinalizer adds the guid release finalizer to the pod. The strategic merge patch keeps the other finalizers
+// of the pod, and the pod is unchanged if it already has the finalizer.
+func (d *daemon) addPodFinalizer(pod *kapi.Pod) error {
+	patchData := []byte(fmt.Sprintf(`{"metadata":{"finalizers":[%q]}}`, utils.GUIDReleaseFinalizer))
+	if err := d.kubeClient.PatchPod(pod, types.StrategicMergePatchType, patchData); err != nil {
+		return fmt.Errorf("failed to add finalizer %s to pod %s/%s: %v", utils.GUIDReleaseFinalizer, pod.Namespace,
+			pod.Name, err)
+	}
+	return nil
+}
+
+// removePodFinalizer removes the guid release finalizer from the pod, keeping its other finalizers.
+// It returns nil if the pod no longer exists.
+func (d *daemon) removePodFinalizer(pod *kapi.Pod) error {
+	patchData := []byte(fmt.Sprintf(`{"metadata":{"$deleteFromPrimitiveList/finalizers":[%q]}}`,
+		utils.GUIDReleaseFinalizer))
+	if err := d.kubeClient.PatchPod(pod, types.StrategicMergePatchType, patchData); err != nil &&
+		!apiErrors.IsNotFound(err) {
+		return fmt.Errorf("failed to remove finalizer %s from pod %s/%s: %v", utils.GUIDReleaseFinalizer,
+			pod.Namespace, pod.Name, err)
+	}
+	return nil
+}
+
+// releasePodFinalizers removes the guid release finalizer from the deleted pods kept by it, except the pods whose
+// guids are kept for the next delete update. Failed removals are retried by retryPodFinalizers, the pods aren't
+// processed again as their guids may be allocated to other pods. The caller should hold the delete map lock.
+func (d *daemon) releasePodFinalizers(pods []*kapi.Pod, keptPods map[types.UID]bool) {
+	released := map[types.UID]bool{}
+	for _, pod := range pods {
+		if released[pod.UID] || keptPods[pod.UID] || !utils.PodIsReleasingGUIDs(pod) {
+			continue
+		}
+		released[pod.UID] = true
+
+		if err := d.removePodFinalizer(pod); err != nil {
+			log.Warn().Msgf("%v, retrying in the next delete update", err)
+			if d.pendingFinalizers == nil {
+				d.pendingFinalizers = map[types.UID]*kapi.Pod{}
+			}
+			d.pendingFinalizers[pod.UID] = pod
+			continue
+		}
+		log.Info().Msgf("released guids of deleted pod %s/%s, removed its finalizer", pod.Namespace, pod.Name)
+	}
+}
+
+// retryPodFinalizers retries removing the guid release finalizer of the pods whose removal failed.
+// The caller should hold the delete map lock.
+func (d *daemon) retryPodFinalizers() {
+	for uid, pod := range d.pendingFinalizers {
+		if err := d.removePodFinalizer(pod); err != nil {
+			log.Warn().Msgf("%v, retrying in the next delete update", err)
+			continue
+		}
+		delete(d.pendingFinalizers, uid)
+	}
+}
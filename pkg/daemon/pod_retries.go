@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// podRetriesExceededReason is the reason of the pods events of the pods dropped after failing MaxPodRetries updates
+const podRetriesExceededReason = "PodRetriesExceeded"
+
+// dropPodsExceedingRetries counts the consecutive add updates failures of the failed pods of the processed network
+// and returns its failed pods without the pods which failed more than MaxPodRetries updates. The dropped pods are
+// reported with a warning event and aren't retried. Only the failures of the pods are counted, the updates failed
+// for the whole network, e.g by the subnet manager, keep the pods counts. The caller should hold the add map lock.
+func (d *daemon) dropPodsExceedingRetries(result *addNetworkResult) []*kapi.Pod {
+	maxRetries := d.getConfig().MaxPodRetries
+	if maxRetries <= 0 {
+		return result.failedPods
+	}
+
+	counts := d.podRetries[result.networkID]
+	updatedCounts := map[types.UID]int{}
+	dropped := map[types.UID]bool{}
+	var retriedPods, droppedPods []*kapi.Pod
+	for _, pod := range result.failedPods {
+		if _, counted := updatedCounts[pod.UID]; !counted && !dropped[pod.UID] {
+			count := counts[pod.UID]
+			if result.failureReason == reconcileFailurePods {
+				count++
+			}
+			if count > maxRetries {
+				dropped[pod.UID] = true
+				droppedPods = append(droppedPods, pod)
+			} else {
+				updatedCounts[pod.UID] = count
+			}
+		}
+		if !dropped[pod.UID] {
+			retriedPods = append(retriedPods, pod)
+		}
+	}
+
+	if len(updatedCounts) == 0 {
+		delete(d.podRetries, result.networkID)
+	} else {
+		if d.podRetries == nil {
+			d.podRetries = map[string]map[types.UID]int{}
+		}
+		d.podRetries[result.networkID] = updatedCounts
+	}
+
+	if len(droppedPods) != 0 {
+		message := fmt.Sprintf("pod isn't retried after failing %d updates of network %s, it is handled again "+
+			"when recreated or on daemon restart", maxRetries+1, result.networkID)
+		log.Warn().Msgf("dropping %d pods of network %s: %s", len(droppedPods), result.networkID, message)
+		d.warnPods(droppedPods, podRetriesExceededReason, message)
+	}
+	return retriedPods
+}
+
+// prunePodRetries forgets the failures counts of the networks which are no longer in the add map.
+// The caller should hold the add map lock.
+func (d *daemon) prunePodRetries(addMapItems map[string]interface{}) {
+	for networkID := range d.podRetries {
+		if _, exist := addMapItems[networkID]; !exist {
+			delete(d.podRetries, networkID)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+)
+
+// guidPoolExhaustedReason is the reason of the daemon pod events of guid pools exhaustion
+const guidPoolExhaustedReason = "GUIDPoolExhausted"
+
+// exhaustedGUIDPool is a guid pool found exhausted for the namespace, guids with a namespace prefix may be exhausted
+// for a single namespace
+type exhaustedGUIDPool struct {
+	guidPool  guid.Pool
+	namespace string
+}
+
+// generatePodGUIDUnlessExhausted generates the pod guid unless the guid pool was found exhausted for the pod namespace
+// in the current add update, so the pods of an exhausted pool fail without scanning the pool for every pod.
+// The caller should hold guidAllocationLock.
+func (d *daemon) generatePodGUIDUnlessExhausted(guidPool guid.Pool, pod *kapi.Pod, allocationUID types.UID,
+	networkName string) (guid.GUID, bool, error) {
+	exhausted := exhaustedGUIDPool{guidPool: guidPool, namespace: pod.Namespace}
+	if d.exhaustedGUIDPools[exhausted] {
+		return 0, false, fmt.Errorf("%w: guids aren't generated until the next update", guid.ErrPoolExhausted)
+	}
+
+	guidAddr, topologyAllocated, err := d.generatePodGUID(guidPool, pod, allocationUID, networkName)
+	if errors.Is(err, guid.ErrPoolExhausted) {
+		if d.exhaustedGUIDPools == nil {
+			d.exhaustedGUIDPools = map[exhaustedGUIDPool]bool{}
+		}
+		d.exhaustedGUIDPools[exhausted] = true
+	}
+	return guidAddr, topologyAllocated, err
+}
+
+// reportExhaustedGUIDPools reports the guid pools found exhausted in the add update with their size and allocated
+// guids, and resets them for the next update
+func (d *daemon) reportExhaustedGUIDPools() {
+	d.guidAllocationLock.Lock()
+	exhaustedPools := d.exhaustedGUIDPools
+	d.exhaustedGUIDPools = nil
+	d.guidAllocationLock.Unlock()
+
+	reported := map[guid.Pool]bool{}
+	for exhausted := range exhaustedPools {
+		if reported[exhausted.guidPool] {
+			continue
+		}
+		reported[exhausted.guidPool] = true
+		metrics.GUIDPoolExhaustions.Inc()
+
+		stats := exhausted.guidPool.Stats()
+		message := fmt.Sprintf("guid pool is exhausted, %d guids allocated out of %d guids, %d excluded, pods are "+
+			"retried on the next update", stats.Allocated, stats.Total, stats.Excluded)
+		d.reportDaemonEvent(kapi.EventTypeWarning, guidPoolExhaustedReason, message)
+	}
+}
@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+)
+
+// tokenBucket holds the available subnet manager calls tokens of a network
+type tokenBucket struct {
+	tokens float64 // negative when tokens are reserved by waiting calls
+	last   time.Time
+}
+
+// networkRateLimiter limits the rate of the subnet manager calls per network with a token bucket of every network,
+// so a network with many pods doesn't delay the subnet manager calls of the other networks
+type networkRateLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket // token buckets mapped by "<network namespace>/<network name>"
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+func newNetworkRateLimiter() *networkRateLimiter {
+	return &networkRateLimiter{buckets: map[string]*tokenBucket{}, now: time.Now, sleep: time.Sleep}
+}
+
+// acquire takes a token of the network, waiting for it up to the timeout, the bucket holds up to one second of
+// tokens. It returns false without waiting if the token isn't available within the timeout.
+func (r *networkRateLimiter) acquire(network string, rate float64, timeout time.Duration) bool {
+	r.lock.Lock()
+	now := r.now()
+	bucket, ok := r.buckets[network]
+	if !ok {
+		bucket = &tokenBucket{tokens: rate, last: now}
+		r.buckets[network] = bucket
+	}
+
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.last = now
+
+	var wait time.Duration
+	if bucket.tokens < 1 {
+		wait = time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+		if wait > timeout {
+			r.lock.Unlock()
+			return false
+		}
+	}
+	bucket.tokens--
+	r.lock.Unlock()
+
+	metrics.SMRateLimitWait.Observe(wait.Seconds())
+	if wait > 0 {
+		r.sleep(wait)
+	}
+	return true
+}
+
+// acquireSMCallToken takes a subnet manager call token of the network if the rate limit is enabled.
+// It returns false if the network should be retried on the next periodic update.
+func (d *daemon) acquireSMCallToken(networkNamespace, networkName string) bool {
+	daemonConfig := d.getConfig()
+	if d.smRateLimiter == nil || daemonConfig.MaxSMCallsPerNetworkPerSecond <= 0 {
+		return true
+	}
+
+	return d.smRateLimiter.acquire(networkNamespace+"/"+networkName, daemonConfig.MaxSMCallsPerNetworkPerSecond,
+		time.Duration(daemonConfig.SMCallWaitTimeout)*time.Millisecond)
+}
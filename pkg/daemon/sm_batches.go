@@ -0,0 +1,152 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// forEachSMBatch calls fn with the start and end indexes of every batch of the guids, waiting SMBatchInterval
+// milliseconds between the batches. The batches have up to SMMaxBatchSize guids, all the guids are one batch if 0.
+func (d *daemon) forEachSMBatch(count int, fn func(start, end int)) {
+	batchSize := d.getConfig().SMMaxBatchSize
+	if batchSize <= 0 || batchSize > count {
+		batchSize = count
+	}
+
+	for start := 0; start < count; start += batchSize {
+		if start != 0 && d.getConfig().SMBatchInterval > 0 {
+			time.Sleep(time.Duration(d.getConfig().SMBatchInterval) * time.Millisecond)
+		}
+		end := start + batchSize
+		if end > count {
+			end = count
+		}
+		fn(start, end)
+	}
+}
+
+// addGuidsToPKeyInBatches adds the guids to the pKey with the membership in the subnet manager in batches. It returns
+// the indexes of the guids of the failed batches and the error of the last failed batch.
+func (d *daemon) addGuidsToPKeyInBatches(pKey int, membership string, guidList []net.HardwareAddr) (map[int]bool,
+	error) {
+	failedIndexes := map[int]bool{}
+	var lastErr error
+	d.forEachSMBatch(len(guidList), func(start, end int) {
+		if err := d.addGuidsToPKey(context.Background(), pKey, guidList[start:end], membership); err != nil {
+			lastErr = err
+			for index := start; index < end; index++ {
+				failedIndexes[index] = true
+			}
+		}
+	})
+	return failedIndexes, lastErr
+}
+
+// addGuidsToPKeyInCapacityChunks adds the guids to the pKey with the membership in the subnet manager in sequential
+// chunks of the remaining capacity of the pKey, starting with the given capacity. The capacity is checked again with
+// the subnet manager after every chunk, the chunks are added until all the guids are added or the pKey is full.
+// It returns the number of guids of the added chunks, the indexes of the guids of the failed batches and the error of
+// the last failed batch.
+func (d *daemon) addGuidsToPKeyInCapacityChunks(pKey int, membership string, guidList []net.HardwareAddr,
+	capacity int) (int, map[int]bool, error) {
+	failedIndexes := map[int]bool{}
+	var lastErr error
+	var added int
+	for added < len(guidList) && capacity > 0 {
+		end := added + capacity
+		if end > len(guidList) {
+			end = len(guidList)
+		}
+		chunkFailedIndexes, err := d.addGuidsToPKeyInBatches(pKey, membership, guidList[added:end])
+		if err != nil {
+			lastErr = err
+		}
+		for index := range chunkFailedIndexes {
+			failedIndexes[added+index] = true
+		}
+		added = end
+		if added == len(guidList) {
+			break
+		}
+
+		memberCount, maxMembers, err := d.getPKeyCapacity(pKey)
+		if err != nil {
+			log.Warn().Msgf("failed to check pKey 0x%04X capacity with subnet manager %s, %d guids will be retried, "+
+				"with error: %v", pKey, d.smClient.Name(), len(guidList)-added, err)
+			break
+		}
+		capacity = maxMembers - memberCount
+	}
+	return added, failedIndexes, lastErr
+}
+
+// removeGuidsFromPKeyInBatches removes the guids from the pKey in the subnet manager in batches. It returns the
+// indexes of the guids of the failed batches and the error of the last failed batch.
+func (d *daemon) removeGuidsFromPKeyInBatches(pKey int, guidList []net.HardwareAddr) (map[int]bool, error) {
+	failedIndexes := map[int]bool{}
+	var lastErr error
+	d.forEachSMBatch(len(guidList), func(start, end int) {
+		if err := d.smClient.RemoveGuidsFromPKey(context.Background(), pKey, guidList[start:end]); err != nil {
+			lastErr = err
+			for index := start; index < end; index++ {
+				failedIndexes[index] = true
+			}
+		}
+	})
+	return failedIndexes, lastErr
+}
+
+// excludeFailedBatchPods moves the pods with a guid in the failed indexes to the failed pods, with all their guids,
+// since the pods interfaces are annotated at once. It returns the kept pods and guids, the failed pods and the pods
+// moved to them.
+func excludeFailedBatchPods(passedPods []*kapi.Pod, guidList []net.HardwareAddr, failedPods []*kapi.Pod,
+	failedIndexes map[int]bool) ([]*kapi.Pod, []net.HardwareAddr, []*kapi.Pod, []*kapi.Pod) {
+	batchFailed := map[types.UID]bool{}
+	var batchFailedPods []*kapi.Pod
+	for index, pod := range passedPods {
+		if failedIndexes[index] && !batchFailed[pod.UID] {
+			batchFailed[pod.UID] = true
+			batchFailedPods = append(batchFailedPods, pod)
+		}
+	}
+
+	var keptPods []*kapi.Pod
+	var keptGUIDs []net.HardwareAddr
+	for index, pod := range passedPods {
+		if batchFailed[pod.UID] {
+			continue
+		}
+		keptPods = append(keptPods, pod)
+		keptGUIDs = append(keptGUIDs, guidList[index])
+	}
+	return keptPods, keptGUIDs, append(failedPods, batchFailedPods...), batchFailedPods
+}
+
+// excludePodsBeyondCapacity moves the pods with a guid from the added index on, which didn't fit the pKey capacity, to
+// the failed pods, with all their guids. It returns the kept pods and guids, the failed pods and the indexes of the
+// kept guids of the failed batches.
+func excludePodsBeyondCapacity(passedPods []*kapi.Pod, guidList []net.HardwareAddr, failedPods []*kapi.Pod,
+	failedIndexes map[int]bool, added int) ([]*kapi.Pod, []net.HardwareAddr, []*kapi.Pod, map[int]bool) {
+	failedGUIDs := map[string]bool{}
+	for index := range failedIndexes {
+		failedGUIDs[guidList[index].String()] = true
+	}
+	exceedingIndexes := map[int]bool{}
+	for index := added; index < len(guidList); index++ {
+		exceedingIndexes[index] = true
+	}
+	passedPods, guidList, failedPods, _ = excludeFailedBatchPods(passedPods, guidList, failedPods, exceedingIndexes)
+
+	keptFailedIndexes := map[int]bool{}
+	for index, guidAddr := range guidList {
+		if failedGUIDs[guidAddr.String()] {
+			keptFailedIndexes[index] = true
+		}
+	}
+	return passedPods, guidList, failedPods, keptFailedIndexes
+}
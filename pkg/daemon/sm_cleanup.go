@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// CleanSMOnStartup removes guids of pods which no longer exist from the pKeys of the InfiniBand network attachment
+// definitions in the subnet manager, e.g guids left registered when the daemon crashed before handling the pods
+// deletion. Only guids in the guid pool range are removed, the other pKey members aren't managed by the daemon.
+func (d *daemon) CleanSMOnStartup(ctx context.Context) error {
+	log.Info().Msg("cleaning stale guids from subnet manager")
+	rangeStart, err := guid.ParseGUID(d.getConfig().GUIDPool.RangeStart)
+	if err != nil {
+		return fmt.Errorf("failed to parse guid pool range start: %v", err)
+	}
+	rangeEnd, err := guid.ParseGUID(d.getConfig().GUIDPool.RangeEnd)
+	if err != nil {
+		return fmt.Errorf("failed to parse guid pool range end: %v", err)
+	}
+
+	pKeys, err := d.getNetworkAttachmentDefinitionsPKeys()
+	if err != nil {
+		return err
+	}
+
+	podGUIDs, err := d.getPodsGUIDs()
+	if err != nil {
+		return err
+	}
+
+	cleaned := map[int][]net.HardwareAddr{}
+	for pKey := range pKeys {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		members, membershipErr := d.smClient.GetPKeyMembership(ctx, pKey)
+		if errors.Is(membershipErr, plugins.ErrMembershipNotSupported) {
+			log.Info().Msgf("skipping cleaning of pKey 0x%04X, subnet manager %s can't report its members",
+				pKey, d.smClient.Name())
+			continue
+		}
+		if membershipErr != nil {
+			return fmt.Errorf("failed to get pKey 0x%04X members with subnet manager %s with error: %v",
+				pKey, d.smClient.Name(), membershipErr)
+		}
+
+		var staleGUIDs []net.HardwareAddr
+		for _, member := range members {
+			memberGUID, parseErr := guid.ParseGUID(member.String())
+			if parseErr != nil || memberGUID < rangeStart || memberGUID > rangeEnd || podGUIDs[memberGUID] {
+				continue
+			}
+			staleGUIDs = append(staleGUIDs, member)
+		}
+
+		if len(staleGUIDs) == 0 {
+			continue
+		}
+
+		if removeErr := d.smClient.RemoveGuidsFromPKey(ctx, pKey, staleGUIDs); removeErr != nil {
+			return fmt.Errorf("failed to remove stale guids from pKey 0x%04X with subnet manager %s with error: %v",
+				pKey, d.smClient.Name(), removeErr)
+		}
+		cleaned[pKey] = staleGUIDs
+	}
+
+	var total int
+	for pKey, staleGUIDs := range cleaned {
+		total += len(staleGUIDs)
+		log.Info().Msgf("removed stale guids %v from pKey 0x%04X", staleGUIDs, pKey)
+	}
+	log.Info().Msgf("cleaned %d stale guids from %d pKeys of %d checked pKeys in subnet manager",
+		total, len(cleaned), len(pKeys))
+	return nil
+}
+
+// getNetworkAttachmentDefinitionsPKeys returns the pKeys of the InfiniBand network attachment definitions
+func (d *daemon) getNetworkAttachmentDefinitionsPKeys() (map[int]bool, error) {
+	networkPKeys, err := d.getNetworkAttachmentDefinitionsNetworkPKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	pKeys := map[int]bool{}
+	for _, netPKey := range networkPKeys {
+		pKeys[netPKey.pKey] = true
+	}
+	return pKeys, nil
+}
+
+// networkPKey is the pKey of an InfiniBand network and the pKey membership of its guids
+type networkPKey struct {
+	pKey       int
+	membership string
+}
+
+// getNetworkAttachmentDefinitionsNetworkPKeys returns the pKeys of the InfiniBand network attachment definitions
+// mapped by their network id
+func (d *daemon) getNetworkAttachmentDefinitionsNetworkPKeys() (map[string]networkPKey, error) {
+	netAttDefs, err := d.kubeClient.GetNetworkAttachmentDefinitions(kapi.NamespaceAll)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network attachment definitions: %v", err)
+	}
+
+	networkPKeys := map[string]networkPKey{}
+	for index := range netAttDefs.Items {
+		netAttDef := &netAttDefs.Items[index]
+		networkSpec := make(map[string]interface{})
+		if netAttDef.Spec.Config != "" && json.Unmarshal([]byte(netAttDef.Spec.Config), &networkSpec) != nil {
+			continue
+		}
+
+		ibCniSpec, specErr := d.getIbSriovCniFromNetwork(networkSpec, netAttDef.Namespace,
+			netAttDef.Annotations[utils.CNIConfNameAnnotation])
+		if specErr != nil {
+			continue
+		}
+		networkID := utils.GenerateNetAttDefNetworkID(netAttDef)
+		ibCniSpec.PKey = d.getNetworkPKey(networkID, ibCniSpec)
+		if ibCniSpec.PKey == "" {
+			continue
+		}
+
+		pKey, parseErr := utils.ParsePKey(ibCniSpec.PKey)
+		if parseErr != nil {
+			log.Warn().Msgf("failed to parse pKey %s of network attachment definition %s/%s with error: %v",
+				ibCniSpec.PKey, netAttDef.Namespace, netAttDef.Name, parseErr)
+			continue
+		}
+
+		membership, membershipErr := plugins.ParseMembership(ibCniSpec.Membership)
+		if membershipErr != nil {
+			log.Warn().Msgf("failed to parse pKey membership of network attachment definition %s/%s with error: %v",
+				netAttDef.Namespace, netAttDef.Name, membershipErr)
+			continue
+		}
+		networkPKeys[networkID] = networkPKey{pKey: pKey, membership: membership}
+	}
+
+	return networkPKeys, nil
+}
+
+// getPodsGUIDs returns the guids in the network annotations of the existing pods
+func (d *daemon) getPodsGUIDs() (map[guid.GUID]bool, error) {
+	pods, err := d.kubeClient.GetPods(kapi.NamespaceAll)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods from kubernetes: %v", err)
+	}
+
+	podGUIDs := map[guid.GUID]bool{}
+	for index := range pods.Items {
+		networks, networksErr := netAttUtils.ParsePodNetworkAnnotation(&pods.Items[index])
+		if networksErr != nil {
+			continue
+		}
+
+		for _, network := range networks {
+			podGUID, guidErr := utils.GetPodNetworkGUID(network)
+			if guidErr != nil {
+				continue
+			}
+
+			if guidAddr, parseErr := guid.ParseGUID(podGUID); parseErr == nil {
+				podGUIDs[guidAddr] = true
+			}
+		}
+	}
+
+	return podGUIDs, nil
+}
@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// checkGIDSupport checks the subnet manager plugin implements plugins.GIDPlugin if the guids are added to the pKeys
+// by gid, so the daemon fails on startup instead of failing every addition
+func checkGIDSupport(smClient plugins.SubnetManagerClient, subnetPrefix string) error {
+	if subnetPrefix == "" {
+		return nil
+	}
+	if _, ok := smClient.(plugins.GIDPlugin); !ok {
+		return fmt.Errorf("subnet manager plugin %s doesn't support adding gids to pKeys, required by subnet "+
+			"prefix %s", smClient.Name(), subnetPrefix)
+	}
+	return nil
+}
+
+// addGuidsToPKey adds the guids to the pKey with the membership in the subnet manager, by their gids if a subnet
+// prefix is set or by guid otherwise
+func (d *daemon) addGuidsToPKey(ctx context.Context, pKey int, guids []net.HardwareAddr, membership string) error {
+	daemonConfig := d.getConfig()
+	subnetPrefix, err := daemonConfig.GetSubnetPrefix()
+	if err != nil {
+		return err
+	}
+	if subnetPrefix == nil {
+		return d.smClient.AddGuidsToPKey(ctx, pKey, guids, membership)
+	}
+
+	gids := make([]net.IP, 0, len(guids))
+	for _, guidAddr := range guids {
+		gid, gidErr := utils.GetGID(subnetPrefix, guidAddr)
+		if gidErr != nil {
+			return gidErr
+		}
+		gids = append(gids, gid)
+	}
+	return plugins.AddGidsToPKey(ctx, d.smClient, pKey, gids, membership)
+}
@@ -0,0 +1,142 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// SMReconcilePeriodicUpdate adds the guids of the pods networks configured with InfiniBand back to the pKeys of their
+// network attachment definitions if they are missing from the pKeys in the subnet manager, e.g after the subnet
+// manager lost its partitions configuration. It holds the add and delete maps, so the guids added and removed by the
+// add and delete updates meanwhile aren't reconciled. The guids of the deleted pods pending in the delete map are left
+// to the delete update, and the guids evicted from their pKeys for being idle aren't added back.
+func (d *daemon) SMReconcilePeriodicUpdate() {
+	log.Info().Msg("running subnet manager reconcile periodic update")
+	addMap, deleteMap := d.watcher.GetHandler().GetResults()
+	addMap.Lock()
+	defer addMap.Unlock()
+	deleteMap.Lock()
+	defer deleteMap.Unlock()
+
+	pendingUIDs := map[types.UID]bool{}
+	for _, podsInterface := range deleteMap.Items {
+		pods, _ := podsInterface.([]*kapi.Pod)
+		for _, pod := range pods {
+			pendingUIDs[pod.UID] = true
+		}
+	}
+
+	if err := d.reconcileSMMembership(pendingUIDs); err != nil {
+		log.Warn().Msgf("failed to reconcile subnet manager pKeys members with error: %v", err)
+		return
+	}
+	log.Info().Msg("subnet manager reconcile periodic update finished")
+}
+
+// reconcileSMMembership adds the missing guids of the configured pods networks to their pKeys, except the guids of
+// the pods of the given UIDs
+func (d *daemon) reconcileSMMembership(skippedUIDs map[types.UID]bool) error {
+	networkPKeys, err := d.getNetworkAttachmentDefinitionsNetworkPKeys()
+	if err != nil {
+		return err
+	}
+
+	pods, err := d.kubeClient.GetPods(kapi.NamespaceAll)
+	if err != nil {
+		return fmt.Errorf("failed to get pods from kubernetes: %v", err)
+	}
+
+	desired := map[networkPKey][]net.HardwareAddr{}
+	for index := range pods.Items {
+		pod := &pods.Items[index]
+		if skippedUIDs[pod.UID] || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		networks, networksErr := netAttUtils.ParsePodNetworkAnnotation(pod)
+		if networksErr != nil {
+			continue
+		}
+
+		for _, network := range networks {
+			if !utils.IsPodNetworkConfiguredWithInfiniBand(network, d.ibAnnotationKey) {
+				continue
+			}
+
+			netPKey, exist := networkPKeys[utils.GenerateNetworkID(network)]
+			if !exist {
+				continue
+			}
+
+			podGUID, guidErr := utils.GetPodNetworkGUID(network)
+			if guidErr != nil {
+				continue
+			}
+
+			guidAddr, parseErr := net.ParseMAC(podGUID)
+			if parseErr != nil || d.isIdleGUIDEvicted(guidAddr) {
+				continue
+			}
+			desired[netPKey] = append(desired[netPKey], guidAddr)
+		}
+	}
+
+	pKeys := make([]networkPKey, 0, len(desired))
+	for netPKey := range desired {
+		pKeys = append(pKeys, netPKey)
+	}
+	sort.Slice(pKeys, func(i, j int) bool {
+		if pKeys[i].pKey != pKeys[j].pKey {
+			return pKeys[i].pKey < pKeys[j].pKey
+		}
+		return pKeys[i].membership < pKeys[j].membership
+	})
+
+	var restored int
+	for _, netPKey := range pKeys {
+		pKey := netPKey.pKey
+		members, membershipErr := d.smClient.GetPKeyMembership(context.Background(), pKey)
+		if membershipErr != nil {
+			log.Warn().Msgf("failed to get pKey 0x%04X members with subnet manager %s with error: %v",
+				pKey, d.smClient.Name(), membershipErr)
+			continue
+		}
+
+		memberGUIDs := make(map[string]bool, len(members))
+		for _, member := range members {
+			memberGUIDs[member.String()] = true
+		}
+
+		var missingGUIDs []net.HardwareAddr
+		for _, guidAddr := range desired[netPKey] {
+			if !memberGUIDs[guidAddr.String()] {
+				memberGUIDs[guidAddr.String()] = true
+				missingGUIDs = append(missingGUIDs, guidAddr)
+			}
+		}
+		if len(missingGUIDs) == 0 {
+			continue
+		}
+
+		failedIndexes, addErr := d.addGuidsToPKeyInBatches(pKey, netPKey.membership, missingGUIDs)
+		restored += len(missingGUIDs) - len(failedIndexes)
+		if addErr != nil {
+			log.Warn().Msgf("failed to add missing guids to pKey 0x%04X with subnet manager %s with error: %v",
+				pKey, d.smClient.Name(), addErr)
+			continue
+		}
+		log.Info().Msgf("added missing guids %v back to pKey 0x%04X", missingGUIDs, pKey)
+	}
+
+	log.Info().Msgf("added %d missing guids back to %d checked pKeys in subnet manager", restored, len(pKeys))
+	return nil
+}
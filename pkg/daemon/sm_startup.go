@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+)
+
+var (
+	// smStartupBackoff is the backoff before the first retry of loading the subnet manager plugin, doubled on every
+	// retry up to smStartupMaxBackoff
+	smStartupBackoff    = time.Second
+	smStartupMaxBackoff = 30 * time.Second
+)
+
+// loadSMClientWithRetry loads and validates the subnet manager client plugin like loadSMClient, retrying with
+// exponential backoff until the timeout expires, so the daemon starts after the subnet manager is briefly
+// unreachable instead of crashing. The plugin is loaded once if the timeout is 0.
+// It returns the error of the last attempt if the timeout expired.
+func loadSMClientWithRetry(pluginName, pluginConfPath string, timeout time.Duration) (plugins.SubnetManagerClient,
+	error) {
+	deadline := time.Now().Add(timeout)
+	backoff := smStartupBackoff
+	for attempt := 1; ; attempt++ {
+		smClient, err := loadSMClient(pluginName, pluginConfPath)
+		if err == nil {
+			if attempt > 1 {
+				log.Info().Msgf("loaded subnet manager plugin %s on attempt %d", pluginName, attempt)
+			}
+			return smClient, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("failed to load subnet manager plugin %s in %d attempts: %v", pluginName,
+				attempt, err)
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+		log.Warn().Msgf("attempt %d to load subnet manager plugin %s failed, retrying in %v, with error: %v",
+			attempt, pluginName, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > smStartupMaxBackoff {
+			backoff = smStartupMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// findStaleGUIDs returns the user allocated guids requested by the pods of the network which are allocated to other
+// pods of the same namespace and network which no longer exist, e.g the previous pods of recreated StatefulSet pods
+// whose deletion was missed, mapped to the allocation uids of the deleted pods. The pods and the pool allocations are
+// read without holding guidAllocationLock, which is held only to read the guids owners.
+func (d *daemon) findStaleGUIDs(guidPool guid.Pool, pods []*kapi.Pod, networkID,
+	networkName string) map[string]types.UID {
+	// the pods requesting guids allocated to other pods, by the requested guids
+	requestingPods := map[string]*kapi.Pod{}
+	d.guidAllocationLock.Lock()
+	for _, pod := range pods {
+		networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+		if err != nil {
+			continue
+		}
+		podNetworks, err := utils.GetPodNetworks(networks, networkName)
+		if err != nil {
+			continue
+		}
+
+		podNetworkID := string(d.vmiAnnotator.GetAllocationUID(pod)) + networkID
+		for _, network := range podNetworks {
+			requestedGUID, guidErr := utils.GetPodNetworkGUIDStrict(network)
+			if guidErr != nil {
+				continue
+			}
+			if owner, exist := d.guidPodNetworkMap[requestedGUID]; exist && owner != podNetworkID {
+				requestingPods[requestedGUID] = pod
+			}
+		}
+	}
+	d.guidAllocationLock.Unlock()
+	if len(requestingPods) == 0 {
+		return nil
+	}
+
+	allocations := map[guid.GUID]guid.Allocation{}
+	for _, allocation := range guidPool.GetAllocations() {
+		allocations[allocation.GUID] = allocation
+	}
+
+	staleGUIDs := map[string]types.UID{}
+	namespacePods := map[string]map[types.UID]bool{} // allocation uids of the existing pods by namespace
+	for requestedGUID, pod := range requestingPods {
+		guidAddr, err := guid.ParseGUID(requestedGUID)
+		if err != nil {
+			continue
+		}
+		allocation, exist := allocations[guidAddr]
+		if !exist || allocation.Namespace != pod.Namespace || allocation.Network != networkName {
+			continue
+		}
+
+		existingPods, ok := namespacePods[pod.Namespace]
+		if !ok {
+			var podList *kapi.PodList
+			if podList, err = d.kubeClient.GetPods(pod.Namespace); err != nil {
+				log.Warn().Msgf("failed to check pod %s of guid %s exists with error: %v", allocation.PodUID,
+					requestedGUID, err)
+				continue
+			}
+			existingPods = map[types.UID]bool{}
+			for index := range podList.Items {
+				existingPods[d.vmiAnnotator.GetAllocationUID(&podList.Items[index])] = true
+			}
+			namespacePods[pod.Namespace] = existingPods
+		}
+		if !existingPods[allocation.PodUID] {
+			staleGUIDs[requestedGUID] = allocation.PodUID
+		}
+	}
+	return staleGUIDs
+}
+
+// releaseStaleGUID releases the user allocated guid requested by the pod if it is still allocated to the deleted pod
+// found by findStaleGUIDs, so the guid is allocated again for the pod. The guid is kept in its pKey, which is the pKey
+// of the same network. It returns true if the guid was released. The caller should hold guidAllocationLock.
+func (d *daemon) releaseStaleGUID(guidPool guid.Pool, pod *kapi.Pod, networkID, requestedGUID string,
+	staleUID types.UID) bool {
+	if d.guidPodNetworkMap[requestedGUID] != string(staleUID)+networkID {
+		return false
+	}
+
+	if err := guidPool.ReleaseGUID(requestedGUID); err != nil {
+		log.Warn().Msgf("failed to release stale guid %s with error: %v", requestedGUID, err)
+		return false
+	}
+	delete(d.guidPodNetworkMap, requestedGUID)
+	log.Info().Msgf("released guid %s of deleted pod %s of network %s, requested by pod %s namespace %s",
+		requestedGUID, staleUID, networkID, pod.Name, pod.Namespace)
+	return true
+}
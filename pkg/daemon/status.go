@@ -0,0 +1,127 @@
+package daemon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	kapi "k8s.io/api/core/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/status"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// stalledUpdatePeriods is the number of periodic update periods without an add update after which the daemon is
+// reported stalled
+const stalledUpdatePeriods = 3
+
+// periodicUpdateTimes are the times the periodic updates last completed
+type periodicUpdateTimes struct {
+	lock         sync.Mutex
+	addUpdate    time.Time
+	addErr       error // subnet manager failure of the last add update, nil if it succeeded
+	deleteUpdate time.Time
+}
+
+func (t *periodicUpdateTimes) addDone(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.addUpdate = time.Now()
+	t.addErr = err
+}
+
+func (t *periodicUpdateTimes) lastAddErr() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.addErr
+}
+
+func (t *periodicUpdateTimes) deleteDone() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.deleteUpdate = time.Now()
+}
+
+// GetStatus requests the status of the daemon running with the configuration from the environment.
+// It returns error if the daemon can't be reached.
+func GetStatus() (*status.Status, error) {
+	daemonConfig := config.DaemonConfig{}
+	if err := daemonConfig.ReadConfig(); err != nil {
+		return nil, err
+	}
+
+	if daemonConfig.StatusSocket == "" {
+		return nil, fmt.Errorf("no status socket set")
+	}
+
+	return status.GetStatus(daemonConfig.StatusSocket)
+}
+
+// Status returns the current state of the daemon, the subnet manager is connected if its plugin validation passes
+func (d *daemon) Status() status.Status {
+	poolStats := d.guidPool.Stats()
+	daemonStatus := status.Status{
+		PoolAllocated: poolStats.Allocated,
+		PoolTotal:     poolStats.Total - poolStats.Excluded,
+		SMName:        d.smClient.Name(),
+		SMVersion:     d.smClient.Spec(),
+		SMConnected:   d.smClient.Validate() == nil,
+		StartTime:     d.startTime,
+		Now:           time.Now()}
+
+	addMap, deleteMap := d.watcher.GetHandler().GetResults()
+	daemonStatus.AddQueueNetworks, daemonStatus.AddQueuePods = countQueuedPods(addMap)
+	daemonStatus.DeleteQueueNetworks, daemonStatus.DeleteQueuePods = countQueuedPods(deleteMap)
+
+	d.updateTimes.lock.Lock()
+	daemonStatus.LastAddUpdate = d.updateTimes.addUpdate
+	daemonStatus.LastDeleteUpdate = d.updateTimes.deleteUpdate
+	d.updateTimes.lock.Unlock()
+
+	lastAddUpdate := daemonStatus.LastAddUpdate
+	if lastAddUpdate.IsZero() {
+		lastAddUpdate = d.startTime
+	}
+	daemonConfig := d.getConfig()
+	stallTimeout := stalledUpdatePeriods * time.Duration(daemonConfig.GetAddPeriodicUpdateInterval()) * time.Second
+	daemonStatus.Stalled = daemonStatus.Now.Sub(lastAddUpdate) > stallTimeout
+
+	return daemonStatus
+}
+
+// countQueuedPods returns the number of networks and pods in the pods watcher results
+func countQueuedPods(queue *utils.SynchronizedMap) (networks, pods int) {
+	queue.Lock()
+	defer queue.Unlock()
+	for _, podsInterface := range queue.Items {
+		if networkPods, ok := podsInterface.([]*kapi.Pod); ok {
+			networks++
+			pods += len(networkPods)
+		}
+	}
+	return networks, pods
+}
+
+// Ready returns error if the subnet manager can't be reached or failed in the last add update
+func (d *daemon) Ready() error {
+	if err := d.smClient.Validate(); err != nil {
+		return fmt.Errorf("subnet manager %s isn't reachable: %v", d.smClient.Name(), err)
+	}
+
+	if err := d.updateTimes.lastAddErr(); err != nil {
+		return fmt.Errorf("last add update failed: %v", err)
+	}
+
+	return nil
+}
+
+// GUIDAllocations returns the allocated guids of the guid pool and of the network attachment definitions guid pools
+func (d *daemon) GUIDAllocations() []guid.Allocation {
+	var allocations []guid.Allocation
+	for _, guidPool := range d.getGUIDPools() {
+		allocations = append(allocations, guidPool.GetAllocations()...)
+	}
+	return allocations
+}
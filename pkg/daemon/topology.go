@@ -0,0 +1,82 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+)
+
+// fabricTopologyCache is the fabric topology from the subnet manager, refreshed after the topology cache ttl
+type fabricTopologyCache struct {
+	lock      sync.Mutex
+	topology  plugins.FabricTopology
+	updatedAt time.Time // zero until the topology is first fetched
+}
+
+// generatePodGUID generates a guid for the pod network. With topology aware allocation the guid is allocated next to
+// the guids of the switch of the pod node, and true is returned as the guid is already allocated.
+func (d *daemon) generatePodGUID(guidPool guid.Pool, pod *kapi.Pod, allocationUID types.UID, networkName string) (
+	guid.GUID, bool, error) {
+	if d.topologyCache != nil {
+		if nodeSwitch := d.getNodeSwitch(pod.Spec.NodeName); nodeSwitch != "" {
+			guidAddr, err := guidPool.AllocateGUIDTopologyAware(allocationUID, pod.Namespace, networkName, nodeSwitch)
+			if err == nil {
+				allocatedGUID, parseErr := guid.ParseGUID(guidAddr.String())
+				return allocatedGUID, true, parseErr
+			}
+
+			// exhausted sub-ranges of the node are extended by generateGUID
+			if !errors.Is(err, guid.ErrPoolExhausted) {
+				return 0, false, err
+			}
+		}
+	}
+
+	guidAddr, err := d.generateGUID(guidPool, pod.Namespace)
+	return guidAddr, false, err
+}
+
+// getNodeSwitch returns the switch which the node is connected to in the fabric topology, empty if unknown
+func (d *daemon) getNodeSwitch(nodeName string) string {
+	if nodeName == "" {
+		return ""
+	}
+
+	topology := d.getFabricTopology()
+	nodeSwitch, found := topology.GetNodeSwitch(nodeName)
+	if !found {
+		log.Debug().Msgf("node %s isn't found in the fabric topology", nodeName)
+	}
+	return nodeSwitch
+}
+
+// getFabricTopology returns the cached fabric topology, fetched from the subnet manager if the cache expired.
+// The expired topology is used if it fails to be fetched.
+func (d *daemon) getFabricTopology() plugins.FabricTopology {
+	d.topologyCache.lock.Lock()
+	defer d.topologyCache.lock.Unlock()
+
+	ttl := time.Duration(d.getConfig().TopologyCacheTTL) * time.Second
+	if !d.topologyCache.updatedAt.IsZero() && time.Since(d.topologyCache.updatedAt) < ttl {
+		return d.topologyCache.topology
+	}
+
+	topology, err := d.smClient.GetFabricTopology(context.Background())
+	if err != nil {
+		log.Warn().Msgf("failed to get fabric topology with subnet manager %s with error: %v", d.smClient.Name(), err)
+		return d.topologyCache.topology
+	}
+
+	log.Debug().Msgf("fetched fabric topology of %d switches", len(topology.Switches))
+	d.topologyCache.topology = topology
+	d.topologyCache.updatedAt = time.Now()
+	return topology
+}
@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// rejectUserGUIDsInUse moves the pods whose user allocated guids were allocated in this update, given by their
+// parsed guids, and are already members in the subnet manager of the network pKey or of the pKeys of the guid pool
+// allocations to the failed pods. The guids of the rejected pods are released, so they are checked again when the
+// pods are retried. All the new user allocated guids are rejected if the pKeys members can't be checked.
+func (d *daemon) rejectUserGUIDsInUse(guidPool guid.Pool, networkPKey int, userGUIDs map[string]string,
+	passedPods []*kapi.Pod, guidList []net.HardwareAddr, failedPods []*kapi.Pod) (
+	[]*kapi.Pod, []net.HardwareAddr, []*kapi.Pod) {
+	pKeys := []int{networkPKey}
+	checked := map[int]bool{networkPKey: true}
+	for _, allocation := range guidPool.GetAllocations() {
+		if allocation.PKey == "" {
+			continue
+		}
+		pKey, err := utils.ParsePKey(allocation.PKey)
+		if err != nil || checked[pKey] {
+			continue
+		}
+		checked[pKey] = true
+		pKeys = append(pKeys, pKey)
+	}
+
+	members := map[string]int{} // pKey of every member guid
+	var membershipErr error
+	for _, pKey := range pKeys {
+		pKeyMembers, err := d.smClient.GetPKeyMembership(context.Background(), pKey)
+		if errors.Is(err, plugins.ErrMembershipNotSupported) {
+			// the guids in use can't be checked against the pKey members
+			continue
+		}
+		if err != nil {
+			membershipErr = fmt.Errorf("failed to get pKey 0x%04X members with subnet manager %s: %v", pKey,
+				d.smClient.Name(), err)
+			break
+		}
+		for _, member := range pKeyMembers {
+			members[member.String()] = pKey
+		}
+	}
+
+	var allowedPods, rejectedPods []*kapi.Pod
+	var allowedGUIDs []net.HardwareAddr
+	for index, pod := range passedPods {
+		userGUID, isNew := userGUIDs[guidList[index].String()]
+		if !isNew {
+			allowedPods = append(allowedPods, pod)
+			allowedGUIDs = append(allowedGUIDs, guidList[index])
+			continue
+		}
+
+		if membershipErr != nil {
+			log.Error().Msgf("failed to check user allocated guid %s of pod namespace %s name %s, will retry: %v",
+				userGUID, pod.Namespace, pod.Name, membershipErr)
+		} else if pKey, inUse := members[guidList[index].String()]; inUse {
+			err := fmt.Errorf("user allocated guid %s is already a member of pKey 0x%04X in subnet manager %s",
+				userGUID, pKey, d.smClient.Name())
+			log.Error().Msgf("pod namespace %s name %s: %v", pod.Namespace, pod.Name, err)
+			d.warnPods([]*kapi.Pod{pod}, guidAllocationFailedReason, err.Error())
+		} else {
+			allowedPods = append(allowedPods, pod)
+			allowedGUIDs = append(allowedGUIDs, guidList[index])
+			continue
+		}
+
+		rejectedPods = append(rejectedPods, pod)
+		d.guidAllocationLock.Lock()
+		if err := guidPool.ReleaseGUID(userGUID); err != nil {
+			log.Warn().Msgf("failed to release user allocated guid %s with error: %v", userGUID, err)
+		}
+		delete(d.guidPodNetworkMap, userGUID)
+		d.guidAllocationLock.Unlock()
+	}
+
+	return allowedPods, allowedGUIDs, append(failedPods, rejectedPods...)
+}
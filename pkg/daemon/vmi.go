@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// VMIKind is the owner kind of the KubeVirt virt-launcher pods
+	VMIKind = "VirtualMachineInstance"
+	// KubeVirtIBGUIDAnnotation is the virt-launcher pod annotation of the guids passed to the virtual machine,
+	// its value is a json object of the InfiniBand network names to their guids
+	KubeVirtIBGUIDAnnotation = "vm.kubevirt.io/ib-guid"
+)
+
+// VMIGUIDAnnotator handles the guids of KubeVirt virtual machine instances with InfiniBand SR-IOV passthrough.
+// The interfaces of the virtual machine instance are the networks of its virt-launcher pod, the guids are allocated
+// for the virtual machine instance uid so they are kept by the virtual machine rather than the virt-launcher pod.
+type VMIGUIDAnnotator struct{}
+
+// GetVMIUID returns the uid of the virtual machine instance which owns the pod, false if it isn't a virt-launcher pod
+func (a *VMIGUIDAnnotator) GetVMIUID(pod *kapi.Pod) (types.UID, bool) {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == VMIKind && owner.UID != "" {
+			return owner.UID, true
+		}
+	}
+
+	return "", false
+}
+
+// GetAllocationUID returns the uid to allocate the pod guids for from the guid pool,
+// the virtual machine instance uid for virt-launcher pods and the pod uid otherwise
+func (a *VMIGUIDAnnotator) GetAllocationUID(pod *kapi.Pod) types.UID {
+	if vmiUID, ok := a.GetVMIUID(pod); ok {
+		return vmiUID
+	}
+
+	return pod.UID
+}
+
+// SetGUIDAnnotation adds the network guid to the KubeVirt guid annotation in the pod annotations,
+// pods which aren't owned by a virtual machine instance are left unchanged
+func (a *VMIGUIDAnnotator) SetGUIDAnnotation(pod *kapi.Pod, networkName, guidAddr string) error {
+	if _, ok := a.GetVMIUID(pod); !ok {
+		return nil
+	}
+
+	networkGUIDs := map[string]string{}
+	if value, exist := pod.Annotations[KubeVirtIBGUIDAnnotation]; exist && value != "" {
+		if err := json.Unmarshal([]byte(value), &networkGUIDs); err != nil {
+			return fmt.Errorf("failed to parse %s annotation %q: %v", KubeVirtIBGUIDAnnotation, value, err)
+		}
+	}
+	networkGUIDs[networkName] = guidAddr
+
+	value, err := json.Marshal(networkGUIDs)
+	if err != nil {
+		return err
+	}
+
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[KubeVirtIBGUIDAnnotation] = string(value)
+	return nil
+}
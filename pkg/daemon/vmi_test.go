@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("VMI GUID Annotator", func() {
+	annotator := &VMIGUIDAnnotator{}
+	vmiPod := func() *kapi.Pod {
+		return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "virt-launcher-vm", UID: "pod-uid",
+			OwnerReferences: []metav1.OwnerReference{{Kind: VMIKind, Name: "vm", UID: "vmi-uid"}}}}
+	}
+	Context("GetAllocationUID", func() {
+		It("Use virtual machine instance uid of virt-launcher pod", func() {
+			Expect(annotator.GetAllocationUID(vmiPod())).To(Equal(types.UID("vmi-uid")))
+		})
+		It("Use pod uid of other pods", func() {
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{UID: "pod-uid",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", UID: "rs-uid"}}}}
+			Expect(annotator.GetAllocationUID(pod)).To(Equal(types.UID("pod-uid")))
+		})
+	})
+	Context("SetGUIDAnnotation", func() {
+		It("Add network guids to the annotation", func() {
+			pod := vmiPod()
+			Expect(annotator.SetGUIDAnnotation(pod, "ib1", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(annotator.SetGUIDAnnotation(pod, "ib2", "02:00:00:00:00:00:00:02")).To(Succeed())
+			Expect(pod.Annotations[KubeVirtIBGUIDAnnotation]).To(MatchJSON(
+				`{"ib1": "02:00:00:00:00:00:00:01", "ib2": "02:00:00:00:00:00:00:02"}`))
+		})
+		It("Leave pods which aren't owned by virtual machine instance unchanged", func() {
+			pod := &kapi.Pod{}
+			Expect(annotator.SetGUIDAnnotation(pod, "ib1", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(pod.Annotations).To(BeNil())
+		})
+		It("Fail on invalid annotation", func() {
+			pod := vmiPod()
+			pod.Annotations = map[string]string{KubeVirtIBGUIDAnnotation: "02:00:00:00:00:00:00:01"}
+			Expect(annotator.SetGUIDAnnotation(pod, "ib1", "02:00:00:00:00:00:00:01")).ToNot(Succeed())
+		})
+	})
+})
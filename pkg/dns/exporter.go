@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+)
+
+const (
+	// minWriteInterval is the minimal interval between two writes of the zone config map
+	minWriteInterval = time.Second
+	recordTTL        = 30
+)
+
+// Exporter exports the guid to pod mappings as TXT records of a zone file served by the CoreDNS file plugin
+type Exporter interface {
+	// AddRecord adds TXT record "namespace/name" of the given guid
+	AddRecord(guid net.HardwareAddr, namespace, name string)
+	// RemoveRecord removes the TXT record of the given guid
+	RemoveRecord(guid net.HardwareAddr)
+	// Flush requests writing the changed records without blocking
+	Flush()
+	// Run writes the changed records to the zone config map, at most once per second, until the stop channel is closed
+	Run(stopChan <-chan struct{})
+}
+
+type exporter struct {
+	client    k8sClient.Client
+	zone      string
+	namespace string
+	name      string
+	flushChan chan struct{}
+
+	mutex   sync.Mutex
+	records map[string]string // guid hex to "namespace/name"
+	changed bool
+}
+
+// NewExporter returns exporter which writes the records of the given zone to the config map with the given namespace
+// and name, the config map data key is "db.<zone>" to be mounted as the CoreDNS file plugin zone file
+func NewExporter(client k8sClient.Client, zone, namespace, name string) Exporter {
+	return &exporter{
+		client:    client,
+		zone:      strings.TrimSuffix(zone, "."),
+		namespace: namespace,
+		name:      name,
+		flushChan: make(chan struct{}, 1),
+		records:   make(map[string]string),
+	}
+}
+
+func (e *exporter) AddRecord(guid net.HardwareAddr, namespace, name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	value := namespace + "/" + name
+	key := recordName(guid)
+	if e.records[key] == value {
+		return
+	}
+	e.records[key] = value
+	e.changed = true
+}
+
+func (e *exporter) RemoveRecord(guid net.HardwareAddr) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	key := recordName(guid)
+	if _, exist := e.records[key]; !exist {
+		return
+	}
+	delete(e.records, key)
+	e.changed = true
+}
+
+func (e *exporter) Flush() {
+	select {
+	case e.flushChan <- struct{}{}:
+	default:
+	}
+}
+
+func (e *exporter) Run(stopChan <-chan struct{}) {
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-e.flushChan:
+		}
+
+		e.write()
+
+		// batch the changes of the next second into one write
+		select {
+		case <-stopChan:
+			return
+		case <-time.After(minWriteInterval):
+		}
+	}
+}
+
+func (e *exporter) write() {
+	e.mutex.Lock()
+	if !e.changed {
+		e.mutex.Unlock()
+		return
+	}
+	zoneData := e.zoneData()
+	e.changed = false
+	e.mutex.Unlock()
+
+	data := map[string]string{"db." + e.zone: zoneData}
+	if err := e.client.SetConfigMapData(e.namespace, e.name, data); err != nil {
+		log.Warn().Msgf("failed to write guid dns zone config map %s/%s with error: %v", e.namespace, e.name, err)
+		e.mutex.Lock()
+		e.changed = true
+		e.mutex.Unlock()
+		e.Flush()
+		return
+	}
+	log.Debug().Msgf("wrote guid dns zone config map %s/%s", e.namespace, e.name)
+}
+
+// zoneData returns the zone file of the records, it must be called with the mutex held
+func (e *exporter) zoneData() string {
+	origin := e.zone + "."
+	lines := make([]string, 0, len(e.records))
+	for key, value := range e.records {
+		lines = append(lines, fmt.Sprintf("%s.%s %d IN TXT %q", key, origin, recordTTL, value))
+	}
+	sort.Strings(lines)
+
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "$ORIGIN %s\n", origin)
+	// the serial must increase on every change for CoreDNS to reload the zone
+	fmt.Fprintf(builder, "@ %d IN SOA ns.%s hostmaster.%s %d 7200 3600 1209600 %d\n",
+		recordTTL, origin, origin, time.Now().Unix(), recordTTL)
+	for _, line := range lines {
+		builder.WriteString(line + "\n")
+	}
+	return builder.String()
+}
+
+// recordName returns the dns label of the guid, e.g guid-0200000000000001
+func recordName(guid net.HardwareAddr) string {
+	return "guid-" + strings.ReplaceAll(guid.String(), ":", "")
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+
+	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
+)
+
+var _ = Describe("DNS Exporter", func() {
+	guid1, _ := net.ParseMAC("02:00:00:00:00:00:00:01")
+	guid2, _ := net.ParseMAC("02:00:00:00:00:00:00:02")
+
+	Context("zoneData", func() {
+		It("Generate sorted TXT records of the zone", func() {
+			e := NewExporter(&k8sClientMock.Client{}, "ib.cluster.local.", "kube-system", "guid-dns").(*exporter)
+			e.AddRecord(guid2, "default", "pod2")
+			e.AddRecord(guid1, "default", "pod1")
+
+			lines := strings.Split(strings.TrimSpace(e.zoneData()), "\n")
+			Expect(len(lines)).To(Equal(4))
+			Expect(lines[0]).To(Equal("$ORIGIN ib.cluster.local."))
+			Expect(lines[1]).To(HavePrefix("@ 30 IN SOA ns.ib.cluster.local. hostmaster.ib.cluster.local. "))
+			Expect(lines[2]).To(Equal(`guid-0200000000000001.ib.cluster.local. 30 IN TXT "default/pod1"`))
+			Expect(lines[3]).To(Equal(`guid-0200000000000002.ib.cluster.local. 30 IN TXT "default/pod2"`))
+		})
+		It("Remove record", func() {
+			e := NewExporter(&k8sClientMock.Client{}, "ib.cluster.local", "kube-system", "guid-dns").(*exporter)
+			e.AddRecord(guid1, "default", "pod1")
+			e.changed = false
+			e.RemoveRecord(guid1)
+			e.RemoveRecord(guid2)
+			Expect(e.changed).To(BeTrue())
+			Expect(e.zoneData()).ToNot(ContainSubstring("guid-0200000000000001"))
+		})
+	})
+	Context("write", func() {
+		It("Write config map only when records changed", func() {
+			client := &k8sClientMock.Client{}
+			client.On("SetConfigMapData", "kube-system", "guid-dns",
+				mock.AnythingOfType("map[string]string")).Return(nil).Once()
+			e := NewExporter(client, "ib.cluster.local", "kube-system", "guid-dns").(*exporter)
+			e.AddRecord(guid1, "default", "pod1")
+			e.write()
+			e.AddRecord(guid1, "default", "pod1")
+			e.write()
+
+			client.AssertNumberOfCalls(GinkgoT(), "SetConfigMapData", 1)
+			data := client.Calls[0].Arguments.Get(2).(map[string]string)
+			Expect(data).To(HaveKey("db.ib.cluster.local"))
+			Expect(data["db.ib.cluster.local"]).To(ContainSubstring(`"default/pod1"`))
+		})
+		It("Retry failed write", func() {
+			client := &k8sClientMock.Client{}
+			client.On("SetConfigMapData", "kube-system", "guid-dns",
+				mock.AnythingOfType("map[string]string")).Return(errors.New("failed"))
+			e := NewExporter(client, "ib.cluster.local", "kube-system", "guid-dns").(*exporter)
+			e.AddRecord(guid1, "default", "pod1")
+			e.write()
+			Expect(e.changed).To(BeTrue())
+			Expect(len(e.flushChan)).To(Equal(1))
+		})
+	})
+	Context("Run", func() {
+		It("Batch flushes into one write", func() {
+			var writes int32
+			client := &k8sClientMock.Client{}
+			client.On("SetConfigMapData", "kube-system", "guid-dns",
+				mock.AnythingOfType("map[string]string")).Return(nil).Run(func(mock.Arguments) {
+				atomic.AddInt32(&writes, 1)
+			})
+			e := NewExporter(client, "ib.cluster.local", "kube-system", "guid-dns")
+			stopChan := make(chan struct{})
+			defer close(stopChan)
+			go e.Run(stopChan)
+
+			getWrites := func() int32 { return atomic.LoadInt32(&writes) }
+			e.AddRecord(guid1, "default", "pod1")
+			e.Flush()
+			Eventually(getWrites).Should(Equal(int32(1)))
+			e.AddRecord(guid2, "default", "pod2")
+			e.Flush()
+			e.Flush()
+			Consistently(getWrites, 500*time.Millisecond).Should(Equal(int32(1)))
+			Eventually(getWrites, 2*time.Second).Should(Equal(int32(2)))
+		})
+	})
+})
@@ -2,6 +2,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -17,60 +18,138 @@ type Client interface {
 	Post(url string, expectedStatusCode int, body []byte) ([]byte, error)
 }
 
+// ContextClient is a Client which sends the requests with a context, the requests are cancelled once their context
+// is done and are passed to the request editor of the client before they are sent
+type ContextClient interface {
+	Client
+	GetWithContext(ctx context.Context, url string, expectedStatusCode int) ([]byte, error)
+	PostWithContext(ctx context.Context, url string, expectedStatusCode int, body []byte) ([]byte, error)
+	// SetRequestEditor sets the editor of the requests, nil to send them unchanged
+	SetRequestEditor(editor RequestEditor)
+}
+
+// RequestEditor edits the request before it is sent, e.g sets headers from the request context
+type RequestEditor func(req *http.Request)
+
+// StatusError is returned for responses with unexpected status code
+type StatusError struct {
+	StatusCode         int
+	ExpectedStatusCode int
+	Body               []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed request with status code %v, expected status code %v: %v",
+		e.StatusCode, e.ExpectedStatusCode, string(e.Body))
+}
+
 type BasicAuth struct {
 	Username string
 	Password string
 }
 
+// GetClientCertificateFunc returns the client certificate for mutual tls authentication
+type GetClientCertificateFunc func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+// TokenSource returns the bearer token to authenticate a request with
+type TokenSource func() (string, error)
+
 type client struct {
-	basicAuth  *BasicAuth
-	httpClient *http.Client
+	basicAuth     *BasicAuth
+	tokenSource   TokenSource
+	httpClient    *http.Client
+	requestEditor RequestEditor
 }
 
-func NewClient(isSecure bool, basicAuth *BasicAuth, cert string) (Client, error) {
+func NewClient(isSecure bool, basicAuth *BasicAuth, cert string,
+	getClientCert GetClientCertificateFunc) (ContextClient, error) {
 	log.Debug().Msgf("creating http client, isSecure %v, basicAuth %+v, cert %s", isSecure, basicAuth, cert)
 	if basicAuth == nil {
 		return nil, fmt.Errorf("invalid basicAuth value %v", basicAuth)
 	}
-	httpClient := &http.Client{Transport: http.DefaultTransport}
+
+	return &client{basicAuth: basicAuth, httpClient: newHTTPClient(isSecure, cert, getClientCert)}, nil
+}
+
+// NewTokenClient returns http client which authenticates every request with a bearer token from the token source
+func NewTokenClient(isSecure bool, tokenSource TokenSource, cert string,
+	getClientCert GetClientCertificateFunc) (ContextClient, error) {
+	log.Debug().Msgf("creating http client with token authentication, isSecure %v, cert %s", isSecure, cert)
+	if tokenSource == nil {
+		return nil, fmt.Errorf("invalid nil tokenSource")
+	}
+
+	return &client{tokenSource: tokenSource, httpClient: newHTTPClient(isSecure, cert, getClientCert)}, nil
+}
+
+// newHTTPClient returns http client, the client certificate is requested by getClientCert for every new connection
+// so the certificate can be rotated without recreating the client. The client transport is a clone of the default
+// transport so its tls config doesn't change the other clients.
+func newHTTPClient(isSecure bool, cert string, getClientCert GetClientCertificateFunc) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
 	if isSecure {
 		if cert == "" {
 			/* #nosec */
-			httpClient.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 		} else {
 			caCertPool := x509.NewCertPool()
 			caCertPool.AppendCertsFromPEM([]byte(cert))
-			httpClient.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: caCertPool}
+			transport.TLSClientConfig = &tls.Config{RootCAs: caCertPool}
 		}
+		transport.TLSClientConfig.GetClientCertificate = getClientCert
 	}
 
-	return &client{basicAuth: basicAuth, httpClient: httpClient}, nil
+	return &http.Client{Transport: transport}
 }
 
 func (c *client) Get(url string, expectedStatusCode int) ([]byte, error) {
-	log.Debug().Msgf("Http client GET: url %s, expectedStatusCode %v", url, expectedStatusCode)
-	return c.executeRequest(http.MethodGet, url, expectedStatusCode, nil)
+	return c.GetWithContext(context.Background(), url, expectedStatusCode)
 }
 
 func (c *client) Post(url string, expectedStatusCode int, body []byte) ([]byte, error) {
+	return c.PostWithContext(context.Background(), url, expectedStatusCode, body)
+}
+
+func (c *client) GetWithContext(ctx context.Context, url string, expectedStatusCode int) ([]byte, error) {
+	log.Debug().Msgf("Http client GET: url %s, expectedStatusCode %v", url, expectedStatusCode)
+	return c.executeRequest(ctx, http.MethodGet, url, expectedStatusCode, nil)
+}
+
+func (c *client) PostWithContext(ctx context.Context, url string, expectedStatusCode int, body []byte) ([]byte, error) {
 	log.Debug().Msgf("Http client POST: url %s, expectedStatusCode %v, body %s", url, expectedStatusCode, string(body))
-	return c.executeRequest(http.MethodPost, url, expectedStatusCode, body)
+	return c.executeRequest(ctx, http.MethodPost, url, expectedStatusCode, body)
+}
+
+func (c *client) SetRequestEditor(editor RequestEditor) {
+	c.requestEditor = editor
 }
 
-func (c *client) createRequest(method, url string, body io.Reader) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, body)
+func (c *client) createRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request object %v", err)
 	}
 
-	req.SetBasicAuth(c.basicAuth.Username, c.basicAuth.Password)
+	if c.tokenSource != nil {
+		token, err := c.tokenSource()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get authentication token %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(c.basicAuth.Username, c.basicAuth.Password)
+	}
 
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if c.requestEditor != nil {
+		c.requestEditor(req)
+	}
 	return req, nil
 }
 
-func (c *client) executeRequest(method, url string, expectedStatusCode int, body []byte) ([]byte, error) {
-	req, err := c.createRequest(method, url, bytes.NewBuffer(body))
+func (c *client) executeRequest(ctx context.Context, method, url string, expectedStatusCode int,
+	body []byte) ([]byte, error) {
+	req, err := c.createRequest(ctx, method, url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -81,8 +160,8 @@ func (c *client) executeRequest(method, url string, expectedStatusCode int, body
 	defer resp.Body.Close()
 	responseBody, _ := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode != expectedStatusCode {
-		return responseBody, fmt.Errorf("failed request with status code %v, expected status code %v: %v",
-			resp.StatusCode, expectedStatusCode, string(responseBody))
+		return responseBody, &StatusError{StatusCode: resp.StatusCode, ExpectedStatusCode: expectedStatusCode,
+			Body: responseBody}
 	}
 
 	return responseBody, nil
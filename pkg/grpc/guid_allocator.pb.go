@@ -0,0 +1,447 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: guid_allocator.proto
+
+package grpc
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type GUIDRequest struct {
+	PodUid               string   `protobuf:"bytes,1,opt,name=pod_uid,json=podUid,proto3" json:"pod_uid,omitempty"`
+	PodNamespace         string   `protobuf:"bytes,2,opt,name=pod_namespace,json=podNamespace,proto3" json:"pod_namespace,omitempty"`
+	PodName              string   `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	NetworkName          string   `protobuf:"bytes,4,opt,name=network_name,json=networkName,proto3" json:"network_name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GUIDRequest) Reset()         { *m = GUIDRequest{} }
+func (m *GUIDRequest) String() string { return proto.CompactTextString(m) }
+func (*GUIDRequest) ProtoMessage()    {}
+func (*GUIDRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_7849aabf7bbbd4c4, []int{0}
+}
+
+func (m *GUIDRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GUIDRequest.Unmarshal(m, b)
+}
+func (m *GUIDRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GUIDRequest.Marshal(b, m, deterministic)
+}
+func (m *GUIDRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GUIDRequest.Merge(m, src)
+}
+func (m *GUIDRequest) XXX_Size() int {
+	return xxx_messageInfo_GUIDRequest.Size(m)
+}
+func (m *GUIDRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GUIDRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GUIDRequest proto.InternalMessageInfo
+
+func (m *GUIDRequest) GetPodUid() string {
+	if m != nil {
+		return m.PodUid
+	}
+	return ""
+}
+
+func (m *GUIDRequest) GetPodNamespace() string {
+	if m != nil {
+		return m.PodNamespace
+	}
+	return ""
+}
+
+func (m *GUIDRequest) GetPodName() string {
+	if m != nil {
+		return m.PodName
+	}
+	return ""
+}
+
+func (m *GUIDRequest) GetNetworkName() string {
+	if m != nil {
+		return m.NetworkName
+	}
+	return ""
+}
+
+type PodGUIDRequest struct {
+	PodUid               string   `protobuf:"bytes,1,opt,name=pod_uid,json=podUid,proto3" json:"pod_uid,omitempty"`
+	NetworkName          string   `protobuf:"bytes,2,opt,name=network_name,json=networkName,proto3" json:"network_name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PodGUIDRequest) Reset()         { *m = PodGUIDRequest{} }
+func (m *PodGUIDRequest) String() string { return proto.CompactTextString(m) }
+func (*PodGUIDRequest) ProtoMessage()    {}
+func (*PodGUIDRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_7849aabf7bbbd4c4, []int{1}
+}
+
+func (m *PodGUIDRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PodGUIDRequest.Unmarshal(m, b)
+}
+func (m *PodGUIDRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PodGUIDRequest.Marshal(b, m, deterministic)
+}
+func (m *PodGUIDRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PodGUIDRequest.Merge(m, src)
+}
+func (m *PodGUIDRequest) XXX_Size() int {
+	return xxx_messageInfo_PodGUIDRequest.Size(m)
+}
+func (m *PodGUIDRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PodGUIDRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PodGUIDRequest proto.InternalMessageInfo
+
+func (m *PodGUIDRequest) GetPodUid() string {
+	if m != nil {
+		return m.PodUid
+	}
+	return ""
+}
+
+func (m *PodGUIDRequest) GetNetworkName() string {
+	if m != nil {
+		return m.NetworkName
+	}
+	return ""
+}
+
+type GUIDResponse struct {
+	Guid                 string   `protobuf:"bytes,1,opt,name=guid,proto3" json:"guid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GUIDResponse) Reset()         { *m = GUIDResponse{} }
+func (m *GUIDResponse) String() string { return proto.CompactTextString(m) }
+func (*GUIDResponse) ProtoMessage()    {}
+func (*GUIDResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_7849aabf7bbbd4c4, []int{2}
+}
+
+func (m *GUIDResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GUIDResponse.Unmarshal(m, b)
+}
+func (m *GUIDResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GUIDResponse.Marshal(b, m, deterministic)
+}
+func (m *GUIDResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GUIDResponse.Merge(m, src)
+}
+func (m *GUIDResponse) XXX_Size() int {
+	return xxx_messageInfo_GUIDResponse.Size(m)
+}
+func (m *GUIDResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GUIDResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GUIDResponse proto.InternalMessageInfo
+
+func (m *GUIDResponse) GetGuid() string {
+	if m != nil {
+		return m.Guid
+	}
+	return ""
+}
+
+type GUIDReleaseRequest struct {
+	PodUid               string   `protobuf:"bytes,1,opt,name=pod_uid,json=podUid,proto3" json:"pod_uid,omitempty"`
+	NetworkName          string   `protobuf:"bytes,2,opt,name=network_name,json=networkName,proto3" json:"network_name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GUIDReleaseRequest) Reset()         { *m = GUIDReleaseRequest{} }
+func (m *GUIDReleaseRequest) String() string { return proto.CompactTextString(m) }
+func (*GUIDReleaseRequest) ProtoMessage()    {}
+func (*GUIDReleaseRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_7849aabf7bbbd4c4, []int{3}
+}
+
+func (m *GUIDReleaseRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GUIDReleaseRequest.Unmarshal(m, b)
+}
+func (m *GUIDReleaseRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GUIDReleaseRequest.Marshal(b, m, deterministic)
+}
+func (m *GUIDReleaseRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GUIDReleaseRequest.Merge(m, src)
+}
+func (m *GUIDReleaseRequest) XXX_Size() int {
+	return xxx_messageInfo_GUIDReleaseRequest.Size(m)
+}
+func (m *GUIDReleaseRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GUIDReleaseRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GUIDReleaseRequest proto.InternalMessageInfo
+
+func (m *GUIDReleaseRequest) GetPodUid() string {
+	if m != nil {
+		return m.PodUid
+	}
+	return ""
+}
+
+func (m *GUIDReleaseRequest) GetNetworkName() string {
+	if m != nil {
+		return m.NetworkName
+	}
+	return ""
+}
+
+type GUIDReleaseResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GUIDReleaseResponse) Reset()         { *m = GUIDReleaseResponse{} }
+func (m *GUIDReleaseResponse) String() string { return proto.CompactTextString(m) }
+func (*GUIDReleaseResponse) ProtoMessage()    {}
+func (*GUIDReleaseResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_7849aabf7bbbd4c4, []int{4}
+}
+
+func (m *GUIDReleaseResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GUIDReleaseResponse.Unmarshal(m, b)
+}
+func (m *GUIDReleaseResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GUIDReleaseResponse.Marshal(b, m, deterministic)
+}
+func (m *GUIDReleaseResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GUIDReleaseResponse.Merge(m, src)
+}
+func (m *GUIDReleaseResponse) XXX_Size() int {
+	return xxx_messageInfo_GUIDReleaseResponse.Size(m)
+}
+func (m *GUIDReleaseResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GUIDReleaseResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GUIDReleaseResponse proto.InternalMessageInfo
+
+func init() {
+	proto.RegisterType((*GUIDRequest)(nil), "ibkubernetes.v1.GUIDRequest")
+	proto.RegisterType((*PodGUIDRequest)(nil), "ibkubernetes.v1.PodGUIDRequest")
+	proto.RegisterType((*GUIDResponse)(nil), "ibkubernetes.v1.GUIDResponse")
+	proto.RegisterType((*GUIDReleaseRequest)(nil), "ibkubernetes.v1.GUIDReleaseRequest")
+	proto.RegisterType((*GUIDReleaseResponse)(nil), "ibkubernetes.v1.GUIDReleaseResponse")
+}
+
+func init() { proto.RegisterFile("guid_allocator.proto", fileDescriptor_7849aabf7bbbd4c4) }
+
+var fileDescriptor_7849aabf7bbbd4c4 = []byte{
+	// 324 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x92, 0x4f, 0x4b, 0xf3, 0x40,
+	0x10, 0x87, 0x69, 0xdf, 0xd2, 0xbe, 0x4e, 0x52, 0x85, 0x55, 0xb1, 0x16, 0x45, 0x4d, 0x3d, 0x88,
+	0x68, 0x82, 0xfa, 0x09, 0x14, 0x41, 0x44, 0x2b, 0xa5, 0x50, 0x10, 0x2f, 0x25, 0xc9, 0x0e, 0x31,
+	0x34, 0xcd, 0xac, 0xc9, 0x46, 0xfd, 0x02, 0x1e, 0xfc, 0xd6, 0x92, 0xcd, 0x86, 0xfe, 0xa3, 0xea,
+	0xc1, 0x5b, 0x32, 0xcf, 0x6f, 0x9e, 0xd9, 0xcd, 0x04, 0x36, 0x82, 0x2c, 0xe4, 0x43, 0x37, 0x8a,
+	0xc8, 0x77, 0x25, 0x25, 0xb6, 0x48, 0x48, 0x12, 0x5b, 0x0b, 0xbd, 0x51, 0xe6, 0x61, 0x12, 0xa3,
+	0xc4, 0xd4, 0x7e, 0x3d, 0xb3, 0x3e, 0x2b, 0x60, 0xdc, 0x0c, 0x6e, 0xaf, 0xfb, 0xf8, 0x92, 0x61,
+	0x2a, 0xd9, 0x16, 0x34, 0x04, 0xf1, 0x61, 0x16, 0xf2, 0x56, 0x65, 0xbf, 0x72, 0xb4, 0xd2, 0xaf,
+	0x0b, 0xe2, 0x83, 0x90, 0xb3, 0x0e, 0x34, 0x73, 0x10, 0xbb, 0x63, 0x4c, 0x85, 0xeb, 0x63, 0xab,
+	0xaa, 0xb0, 0x29, 0x88, 0x3f, 0x94, 0x35, 0xb6, 0x0d, 0xff, 0xcb, 0x50, 0xeb, 0x9f, 0xe2, 0x0d,
+	0xcd, 0xd9, 0x01, 0x98, 0x31, 0xca, 0x37, 0x4a, 0x46, 0x05, 0xae, 0x29, 0x6c, 0xe8, 0x5a, 0x1e,
+	0xb1, 0xee, 0x61, 0xb5, 0x47, 0xfc, 0x57, 0xa7, 0x99, 0xb7, 0x55, 0x17, 0x6d, 0x16, 0x98, 0x85,
+	0x2a, 0x15, 0x14, 0xa7, 0xc8, 0x18, 0xd4, 0x82, 0x89, 0x48, 0x3d, 0x5b, 0x3d, 0x60, 0x45, 0x26,
+	0x42, 0x37, 0xc5, 0xbf, 0x98, 0xba, 0x09, 0xeb, 0x33, 0xc6, 0x62, 0xf8, 0xf9, 0x47, 0x15, 0x9a,
+	0x79, 0xfd, 0xb2, 0xdc, 0x07, 0xbb, 0x03, 0x53, 0xbf, 0x60, 0x0e, 0xd8, 0x8e, 0x3d, 0xb7, 0x1a,
+	0x7b, 0xea, 0x43, 0xb4, 0x77, 0x97, 0x50, 0x7d, 0xb7, 0x47, 0x30, 0xf4, 0x44, 0xe5, 0xea, 0x2c,
+	0x49, 0x4f, 0xdf, 0xb2, 0x7d, 0xf8, 0x7d, 0x48, 0x9b, 0xbb, 0x60, 0xe8, 0x06, 0x65, 0xde, 0x5b,
+	0x68, 0x9a, 0xdd, 0xd8, 0x0f, 0x07, 0xbd, 0x3a, 0x79, 0x3a, 0x0e, 0x42, 0xf9, 0x9c, 0x79, 0xb6,
+	0x4f, 0x63, 0xa7, 0x8b, 0x51, 0xe4, 0xc6, 0xf4, 0xee, 0x84, 0xde, 0xe9, 0xa4, 0xc9, 0x11, 0xa3,
+	0xc0, 0x09, 0x12, 0xe1, 0x7b, 0x75, 0xf5, 0xd3, 0x5e, 0x7c, 0x0d, 0x00, 0x72, 0xdf, 0x62, 0x7d,
+	0xcc, 0x02, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// GUIDAllocatorClient is the client API for GUIDAllocator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type GUIDAllocatorClient interface {
+	// AllocateGUID configures the pod network with InfiniBand and returns its guid, called during cmdAdd
+	AllocateGUID(ctx context.Context, in *GUIDRequest, opts ...grpc.CallOption) (*GUIDResponse, error)
+	// ReleaseGUID handles the pending guid releases of deleted pods, called during cmdDel
+	ReleaseGUID(ctx context.Context, in *GUIDReleaseRequest, opts ...grpc.CallOption) (*GUIDReleaseResponse, error)
+	// RequestGUID configures the pod network of the node with InfiniBand and returns its guid, called by the CNI
+	// plugin during cmdAdd in sidecar mode
+	RequestGUID(ctx context.Context, in *PodGUIDRequest, opts ...grpc.CallOption) (*GUIDResponse, error)
+}
+
+type gUIDAllocatorClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewGUIDAllocatorClient(cc *grpc.ClientConn) GUIDAllocatorClient {
+	return &gUIDAllocatorClient{cc}
+}
+
+func (c *gUIDAllocatorClient) AllocateGUID(ctx context.Context, in *GUIDRequest, opts ...grpc.CallOption) (*GUIDResponse, error) {
+	out := new(GUIDResponse)
+	err := c.cc.Invoke(ctx, "/ibkubernetes.v1.GUIDAllocator/AllocateGUID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gUIDAllocatorClient) ReleaseGUID(ctx context.Context, in *GUIDReleaseRequest, opts ...grpc.CallOption) (*GUIDReleaseResponse, error) {
+	out := new(GUIDReleaseResponse)
+	err := c.cc.Invoke(ctx, "/ibkubernetes.v1.GUIDAllocator/ReleaseGUID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gUIDAllocatorClient) RequestGUID(ctx context.Context, in *PodGUIDRequest, opts ...grpc.CallOption) (*GUIDResponse, error) {
+	out := new(GUIDResponse)
+	err := c.cc.Invoke(ctx, "/ibkubernetes.v1.GUIDAllocator/RequestGUID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GUIDAllocatorServer is the server API for GUIDAllocator service.
+type GUIDAllocatorServer interface {
+	// AllocateGUID configures the pod network with InfiniBand and returns its guid, called during cmdAdd
+	AllocateGUID(context.Context, *GUIDRequest) (*GUIDResponse, error)
+	// ReleaseGUID handles the pending guid releases of deleted pods, called during cmdDel
+	ReleaseGUID(context.Context, *GUIDReleaseRequest) (*GUIDReleaseResponse, error)
+	// RequestGUID configures the pod network of the node with InfiniBand and returns its guid, called by the CNI
+	// plugin during cmdAdd in sidecar mode
+	RequestGUID(context.Context, *PodGUIDRequest) (*GUIDResponse, error)
+}
+
+// UnimplementedGUIDAllocatorServer can be embedded to have forward compatible implementations.
+type UnimplementedGUIDAllocatorServer struct {
+}
+
+func (*UnimplementedGUIDAllocatorServer) AllocateGUID(ctx context.Context, req *GUIDRequest) (*GUIDResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AllocateGUID not implemented")
+}
+func (*UnimplementedGUIDAllocatorServer) ReleaseGUID(ctx context.Context, req *GUIDReleaseRequest) (*GUIDReleaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseGUID not implemented")
+}
+func (*UnimplementedGUIDAllocatorServer) RequestGUID(ctx context.Context, req *PodGUIDRequest) (*GUIDResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestGUID not implemented")
+}
+
+func RegisterGUIDAllocatorServer(s *grpc.Server, srv GUIDAllocatorServer) {
+	s.RegisterService(&_GUIDAllocator_serviceDesc, srv)
+}
+
+func _GUIDAllocator_AllocateGUID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GUIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GUIDAllocatorServer).AllocateGUID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ibkubernetes.v1.GUIDAllocator/AllocateGUID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GUIDAllocatorServer).AllocateGUID(ctx, req.(*GUIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GUIDAllocator_ReleaseGUID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GUIDReleaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GUIDAllocatorServer).ReleaseGUID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ibkubernetes.v1.GUIDAllocator/ReleaseGUID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GUIDAllocatorServer).ReleaseGUID(ctx, req.(*GUIDReleaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GUIDAllocator_RequestGUID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PodGUIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GUIDAllocatorServer).RequestGUID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ibkubernetes.v1.GUIDAllocator/RequestGUID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GUIDAllocatorServer).RequestGUID(ctx, req.(*PodGUIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _GUIDAllocator_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ibkubernetes.v1.GUIDAllocator",
+	HandlerType: (*GUIDAllocatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AllocateGUID",
+			Handler:    _GUIDAllocator_AllocateGUID_Handler,
+		},
+		{
+			MethodName: "ReleaseGUID",
+			Handler:    _GUIDAllocator_ReleaseGUID_Handler,
+		},
+		{
+			MethodName: "RequestGUID",
+			Handler:    _GUIDAllocator_RequestGUID_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "guid_allocator.proto",
+}
@@ -0,0 +1,128 @@
+package grpc
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. guid_allocator.proto
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// GUIDAllocator allocates and releases the guids of pods networks on demand
+type GUIDAllocator interface {
+	// AllocateGUID configures the pod network with InfiniBand and returns its guid
+	AllocateGUID(podNamespace, podName string, podUID types.UID, networkName string) (string, error)
+	// ReleaseGUID releases the guids of the pod network
+	ReleaseGUID(podUID types.UID, networkName string) error
+	// RequestGUID configures the pod network of the node with InfiniBand and returns its guid
+	RequestGUID(podUID types.UID, networkName string) (string, error)
+}
+
+type Server interface {
+	// Run serves the GUIDAllocator service on the unix socket until the stop channel is closed
+	Run(stopChan <-chan struct{}) error
+}
+
+type server struct {
+	socketPath string
+	grpcServer *gogrpc.Server
+}
+
+type guidAllocatorService struct {
+	allocator GUIDAllocator
+}
+
+func (g *guidAllocatorService) AllocateGUID(_ context.Context, request *GUIDRequest) (*GUIDResponse, error) {
+	log.Info().Msgf("guid allocation request for pod namespace %s name %s uid %s network %s",
+		request.PodNamespace, request.PodName, request.PodUid, request.NetworkName)
+	if request.PodUid == "" || request.PodNamespace == "" || request.PodName == "" || request.NetworkName == "" {
+		return nil, status.Error(codes.InvalidArgument,
+			"pod uid, pod namespace, pod name and network name are required")
+	}
+
+	podGUID, err := g.allocator.AllocateGUID(request.PodNamespace, request.PodName, types.UID(request.PodUid),
+		request.NetworkName)
+	if err != nil {
+		log.Warn().Msgf("failed to allocate guid for pod %s network %s with error: %v", request.PodUid,
+			request.NetworkName, err)
+		// the pod network may not be handled yet, multus retries the cni add
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &GUIDResponse{Guid: podGUID}, nil
+}
+
+func (g *guidAllocatorService) ReleaseGUID(_ context.Context, request *GUIDReleaseRequest) (
+	*GUIDReleaseResponse, error) {
+	log.Info().Msgf("guid release request for pod %s network %s", request.PodUid, request.NetworkName)
+	if err := g.allocator.ReleaseGUID(types.UID(request.PodUid), request.NetworkName); err != nil {
+		log.Warn().Msgf("failed to release guid of pod %s network %s with error: %v", request.PodUid,
+			request.NetworkName, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &GUIDReleaseResponse{}, nil
+}
+
+func (g *guidAllocatorService) RequestGUID(_ context.Context, request *PodGUIDRequest) (*GUIDResponse, error) {
+	log.Info().Msgf("guid request for pod %s network %s", request.PodUid, request.NetworkName)
+	if request.PodUid == "" || request.NetworkName == "" {
+		return nil, status.Error(codes.InvalidArgument, "pod uid and network name are required")
+	}
+
+	podGUID, err := g.allocator.RequestGUID(types.UID(request.PodUid), request.NetworkName)
+	if err != nil {
+		log.Warn().Msgf("failed to handle guid request for pod %s network %s with error: %v", request.PodUid,
+			request.NetworkName, err)
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &GUIDResponse{Guid: podGUID}, nil
+}
+
+// NewServer returns a grpc server of the GUIDAllocator service for the Multus thick plugin daemon, or the CNI plugin
+// in sidecar mode, on the given unix socket
+func NewServer(socketPath string, allocator GUIDAllocator) Server {
+	grpcServer := gogrpc.NewServer()
+	RegisterGUIDAllocatorServer(grpcServer, &guidAllocatorService{allocator: allocator})
+	return &server{socketPath: socketPath, grpcServer: grpcServer}
+}
+
+func (s *server) Run(stopChan <-chan struct{}) error {
+	// remove stale socket of previous run
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove socket %s: %v", s.socketPath, err)
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on socket %s: %v", s.socketPath, err)
+	}
+
+	go func() {
+		<-stopChan
+		s.grpcServer.GracefulStop()
+	}()
+
+	log.Info().Msgf("serving guid allocator grpc service on %s", s.socketPath)
+	if err = s.grpcServer.Serve(listener); err != nil {
+		return fmt.Errorf("failed to serve grpc on socket %s: %v", s.socketPath, err)
+	}
+
+	return nil
+}
+
+// Dial returns a grpc client connection to the server listening on the given unix socket
+func Dial(ctx context.Context, socketPath string) (*gogrpc.ClientConn, error) {
+	return gogrpc.DialContext(ctx, socketPath, gogrpc.WithInsecure(), gogrpc.WithBlock(),
+		gogrpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", address)
+		}))
+}
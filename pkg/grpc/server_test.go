@@ -0,0 +1,119 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type fakeAllocator struct {
+	guids    map[string]string
+	released chan string
+}
+
+func (f *fakeAllocator) AllocateGUID(podNamespace, podName string, podUID types.UID, networkName string) (string,
+	error) {
+	podGUID, ok := f.guids[podNamespace+podName+string(podUID)+networkName]
+	if !ok {
+		return "", errors.New("pod network not found")
+	}
+
+	return podGUID, nil
+}
+
+func (f *fakeAllocator) RequestGUID(podUID types.UID, networkName string) (string, error) {
+	podGUID, ok := f.guids[string(podUID)+networkName]
+	if !ok {
+		return "", errors.New("pod network not found")
+	}
+
+	return podGUID, nil
+}
+
+func (f *fakeAllocator) ReleaseGUID(podUID types.UID, networkName string) error {
+	f.released <- string(podUID) + networkName
+	return nil
+}
+
+var _ = Describe("GUID Allocator GRPC Server", func() {
+	var socketDir string
+	var stopChan chan struct{}
+	var runErr chan error
+	var allocator *fakeAllocator
+	var conn *gogrpc.ClientConn
+
+	BeforeEach(func() {
+		var err error
+		socketDir, err = ioutil.TempDir("", "grpc")
+		Expect(err).ToNot(HaveOccurred())
+
+		allocator = &fakeAllocator{guids: map[string]string{"defaultpod1pod1test": "02:00:00:00:00:00:00:01",
+			"pod1test": "02:00:00:00:00:00:00:01"},
+			released: make(chan string, 1)}
+		grpcServer := NewServer(filepath.Join(socketDir, "grpc.sock"), allocator)
+		stopChan = make(chan struct{})
+		runErr = make(chan error, 1)
+		go func() { runErr <- grpcServer.Run(stopChan) }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err = Dial(ctx, filepath.Join(socketDir, "grpc.sock"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(conn.Close()).To(Succeed())
+		close(stopChan)
+		Eventually(runErr).Should(Receive(BeNil()))
+		Expect(os.RemoveAll(socketDir)).ToNot(HaveOccurred())
+	})
+	Context("AllocateGUID", func() {
+		It("Allocate guid of pod network", func() {
+			response, err := NewGUIDAllocatorClient(conn).AllocateGUID(context.Background(),
+				&GUIDRequest{PodUid: "pod1", PodNamespace: "default", PodName: "pod1", NetworkName: "test"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Guid).To(Equal("02:00:00:00:00:00:00:01"))
+		})
+		It("Allocate guid of unknown pod network", func() {
+			_, err := NewGUIDAllocatorClient(conn).AllocateGUID(context.Background(),
+				&GUIDRequest{PodUid: "pod2", PodNamespace: "default", PodName: "pod2", NetworkName: "test"})
+			Expect(status.Code(err)).To(Equal(codes.Unavailable))
+		})
+		It("Allocate guid without network name", func() {
+			_, err := NewGUIDAllocatorClient(conn).AllocateGUID(context.Background(),
+				&GUIDRequest{PodUid: "pod1", PodNamespace: "default", PodName: "pod1"})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+	})
+	Context("RequestGUID", func() {
+		It("Request guid of pod network", func() {
+			response, err := NewGUIDAllocatorClient(conn).RequestGUID(context.Background(),
+				&PodGUIDRequest{PodUid: "pod1", NetworkName: "test"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Guid).To(Equal("02:00:00:00:00:00:00:01"))
+		})
+		It("Request guid of unknown pod network", func() {
+			_, err := NewGUIDAllocatorClient(conn).RequestGUID(context.Background(),
+				&PodGUIDRequest{PodUid: "pod2", NetworkName: "test"})
+			Expect(status.Code(err)).To(Equal(codes.Unavailable))
+			Expect(status.Convert(err).Message()).To(Equal("pod network not found"))
+		})
+	})
+	Context("ReleaseGUID", func() {
+		It("Release guid of pod network", func() {
+			_, err := NewGUIDAllocatorClient(conn).ReleaseGUID(context.Background(),
+				&GUIDReleaseRequest{PodUid: "pod1", NetworkName: "test"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(allocator.released).To(Receive(Equal("pod1test")))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package guid
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+)
+
+// allocationStrategy selects the guids generated from the free guids of the pool, its methods are called with the
+// pool lock held
+type allocationStrategy interface {
+	// generateGUID returns a free guid of the pool sub-ranges, or of the pool range if it has no sub-ranges.
+	// It returns 0 if there is no free guid.
+	generateGUID(p *guidPool) GUID
+}
+
+// newAllocationStrategy returns the allocation strategy of the given name, sequential if empty
+func newAllocationStrategy(name string) (allocationStrategy, error) {
+	switch name {
+	case "", config.AllocationStrategySequential:
+		return sequentialStrategy{}, nil
+	case config.AllocationStrategyRandom:
+		return &randomStrategy{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+	default:
+		return nil, fmt.Errorf("unknown guid allocation strategy %q", name)
+	}
+}
+
+// sequentialStrategy generates the first free guid of the sub-ranges, or the first free guid following the last
+// generated guid in the pool range
+type sequentialStrategy struct{}
+
+func (sequentialStrategy) generateGUID(p *guidPool) GUID {
+	if len(p.subRanges) != 0 {
+		for _, subRange := range p.subRanges {
+			if guid := p.getFreeGUID(subRange.start, subRange.end); guid != 0 {
+				return guid
+			}
+		}
+		return 0
+	}
+
+	// this look will ensure that we check all the range
+	// first iteration from current guid to last guid in the range
+	// second iteration from first guid in the range to the latest one
+	if guid := p.getFreeGUID(p.currentGUID, p.rangeEnd); guid != 0 {
+		return guid
+	}
+	return p.getFreeGUID(p.rangeStart, p.rangeEnd)
+}
+
+// randomStrategy generates a guid picked uniformly from the free guids of the sub-ranges, or of the pool range
+type randomStrategy struct {
+	rand *rand.Rand // guarded by the pool lock
+}
+
+func (s *randomStrategy) generateGUID(p *guidPool) GUID {
+	searchRanges := p.subRanges
+	if len(searchRanges) == 0 {
+		searchRanges = []guidRange{{start: p.rangeStart, end: p.rangeEnd}}
+	}
+
+	used := p.sortedUsedRanges()
+	var freeRanges []guidRange
+	var freeGUIDs uint64
+	for _, searchRange := range searchRanges {
+		for _, freeRange := range getFreeRanges(searchRange, used) {
+			freeRanges = append(freeRanges, freeRange)
+			freeGUIDs += uint64(freeRange.end-freeRange.start) + 1
+		}
+	}
+	if freeGUIDs == 0 {
+		return 0
+	}
+
+	index := s.randomIndex(freeGUIDs)
+	for _, freeRange := range freeRanges {
+		size := uint64(freeRange.end-freeRange.start) + 1
+		if index < size {
+			return freeRange.start + GUID(index)
+		}
+		index -= size
+	}
+	return 0
+}
+
+// randomIndex returns a uniformly distributed random index lower than count
+func (s *randomStrategy) randomIndex(count uint64) uint64 {
+	// values above the largest multiple of count are rejected so every index is equally likely
+	limit := math.MaxUint64 - math.MaxUint64%count
+	for {
+		if value := s.rand.Uint64(); value < limit {
+			return value % count
+		}
+	}
+}
+
+// getFreeRanges returns the ranges of the search range which don't overlap the given sorted used ranges
+func getFreeRanges(searchRange guidRange, used []guidRange) []guidRange {
+	var freeRanges []guidRange
+	next := searchRange.start // first guid which may be free
+	for _, usedRange := range used {
+		if usedRange.end < next {
+			continue
+		}
+		if usedRange.start > searchRange.end {
+			break
+		}
+		if usedRange.start > next {
+			freeRanges = append(freeRanges, guidRange{start: next, end: usedRange.start - 1})
+		}
+		if usedRange.end >= searchRange.end {
+			return freeRanges
+		}
+		next = usedRange.end + 1
+	}
+	return append(freeRanges, guidRange{start: next, end: searchRange.end})
+}
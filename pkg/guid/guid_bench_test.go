@@ -0,0 +1,41 @@
+package guid
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+)
+
+// benchmarkMarshal serializes a pool of the given number of allocated guids in the given format
+func benchmarkMarshal(b *testing.B, guids int, format string) {
+	pool, err := NewPool(&config.GUIDPoolConfig{
+		RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:FF:FF:FF"})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for index := 0; index < guids; index++ {
+		podUID := types.UID(fmt.Sprintf("a8d3a6b4-1b7f-4c0e-9c38-%012x", index))
+		if err = pool.AllocateGUID(podUID, "default", "test", GUID(0x0200000000000000+index).String()); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err = Marshal(pool, format); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalJSON(b *testing.B) {
+	benchmarkMarshal(b, 100000, SerializationFormatJSON)
+}
+
+func BenchmarkMarshalBinary(b *testing.B) {
+	benchmarkMarshal(b, 100000, SerializationFormatBinary)
+}
@@ -1,31 +1,216 @@
 package guid
 
 import (
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+)
+
+// Errors returned by ValidateAllocation, wrapped with the failure details
+var (
+	// ErrOutOfRange is returned for guids outside the pool range
+	ErrOutOfRange = errors.New("guid out of pool range")
+	// ErrAllocated is returned for guids allocated to a different pod network
+	ErrAllocated = errors.New("guid already allocated")
+	// ErrReserved is returned for guids reserved in a guid range, e.g of a network attachment definition,
+	// or excluded from the pool
+	ErrReserved = errors.New("guid reserved")
+	// ErrQuotaExceeded is returned when the namespace has no remaining guid quota
+	ErrQuotaExceeded = errors.New("namespace guid quota exceeded")
+	// ErrPoolExhausted is returned when all the guids of the pool are allocated
+	ErrPoolExhausted = errors.New("guid pool exhausted")
+	// ErrAllocatedToOtherPod is returned by AllocateGUID for guids allocated to another pod of the same namespace and
+	// network, e.g the previous pod of a recreated StatefulSet pod whose deletion was missed
+	ErrAllocatedToOtherPod = errors.New("guid allocated to another pod of the network")
+	// ErrReservationConflict is returned by ReserveGUID for guids already reserved for another pod name
+	ErrReservationConflict = errors.New("guid reserved for another pod")
 )
 
 type Pool interface {
-	// AllocateGUID allocate given guid if in range or
-	// allocate the next free guid in the range if no given guid.
-	// It returns the allocated guid or error if range is full.
-	AllocateGUID(string) error
+	// ValidateAllocation checks whether AllocateGUID of the given guid for the pod network would succeed without
+	// allocating it. A guid which is already allocated to the same pod network is valid.
+	// It returns error wrapping ErrOutOfRange, ErrPoolExhausted, ErrReserved, ErrAllocated or ErrQuotaExceeded.
+	ValidateAllocation(podUID types.UID, namespace, network, guid string) error
+
+	// AllocateGUID allocate given guid for the given pod network if in range.
+	// It returns error if the guid is out of range, already allocated or the pod namespace quota is exceeded.
+	// The error wraps ErrAllocatedToOtherPod if the guid is allocated to another pod of the same namespace and network,
+	// the caller may release the guid if that pod no longer exists, otherwise ErrAllocated if allocated.
+	AllocateGUID(podUID types.UID, namespace, network, guid string) error
 
+	// AllocateGUIDTopologyAware allocates a free guid for the given pod network, preferring the free guids next to
+	// guids allocated on the preferred switch so the guids of each switch are kept in contiguous blocks.
+	// The guid is generated with GenerateNamespaceGUID if there is no such free guid, and recorded on the preferred
+	// switch.
+	// It returns error if the pool is exhausted or the pod namespace quota is exceeded.
+	AllocateGUIDTopologyAware(podUID types.UID, namespace, network, preferredSwitch string) (net.HardwareAddr, error)
+
+	// GenerateGUID generates a free guid from the pool sub-ranges, or from the pool range if it has no sub-ranges.
+	// It returns error wrapping ErrPoolExhausted if there is no free guid.
 	GenerateGUID() (GUID, error)
 
+	// GenerateNamespaceGUID generates a free guid like GenerateGUID, whose namespace prefix byte is the hash of the
+	// namespace name if the pool has a namespace prefix.
+	// It returns error wrapping ErrPoolExhausted if there is no such free guid.
+	GenerateNamespaceGUID(namespace string) (GUID, error)
+
+	// HasNamespacePrefix checks the namespace prefix byte of the guid is the hash of the namespace name,
+	// every guid has the namespace prefix if the pool has no namespace prefix
+	HasNamespacePrefix(guid GUID, namespace string) bool
+
+	// AddSubRange adds a range of the pool which GenerateGUID generates guids from, once the pool has sub-ranges
+	// guids are generated only from them while guids of the whole pool range can still be allocated.
+	// It returns error if the sub-range isn't within the pool range.
+	AddSubRange(start, end GUID) error
+
+	// SubRangeInfo returns the sub-ranges of the pool and their allocated guids
+	SubRangeInfo() SubRangeConfig
+
+	// ExtendRange adds count guids to the pool after its range end.
+	// It returns error if the extended range end isn't a valid guid.
+	ExtendRange(count uint64) error
+
+	// ReserveGUID pins the guid to the pod name, so it is allocated only for the pod of that name, e.g the pod
+	// recreated by its StatefulSet with a new uid, and is never generated for other pods. The reservation outlives the
+	// pod allocation and is dropped only with the pod namespace by ReleaseAllGUIDsInNamespace. Once reserved, the guid
+	// can be allocated only for the given pod uid, until it is reserved again for the next pod uid of the same name.
+	// It returns error if the guid is out of range or excluded, or wrapping ErrReservationConflict if the guid is
+	// reserved for another pod name.
+	ReserveGUID(podUID types.UID, namespace, podName, guid string) error
+
+	// AllocateGUIDRange allocate a range of size contiguous free guids for the given owner and network.
+	// It returns the first and last guids of the range or error if there is no such free range in the pool.
+	AllocateGUIDRange(ownerUID types.UID, network string, size int) (GUID, GUID, error)
+
 	// ReleaseGUID release the reservation of the guid.
 	// It returns error if the guid is not in the range.
 	ReleaseGUID(string) error
+
+	// ReleaseGUIDByPodUID release the reservation of all the guids allocated for the given pod.
+	// It returns the released guids or error if no guid is allocated for the pod.
+	ReleaseGUIDByPodUID(podUID types.UID) ([]string, error)
+
+	// ReleaseAllGUIDsInNamespace release the reservation of all the guids allocated for the pods of the namespace,
+	// and drops the guids reserved by ReserveGUID for the namespace pods.
+	// It returns the released guids or error if no guid is allocated for the namespace pods.
+	ReleaseAllGUIDsInNamespace(namespace string) ([]string, error)
+
+	// SetQuota limits the number of guids allocated to the pods of the namespace, non positive maxGUIDs removes
+	// the namespace quota. Guids which are already allocated aren't released when exceeding the quota.
+	SetQuota(namespace string, maxGUIDs int)
+
+	// GetNamespaceUsage returns the number of guids allocated to the pods of the namespace
+	GetNamespaceUsage(namespace string) int
+
+	// SetGUIDPKey records the pKey which the allocated guid was added to in the subnet manager.
+	// It returns error if the guid isn't allocated.
+	SetGUIDPKey(guid, pKey string) error
+
+	// GetAllocations returns the allocated guids sorted by guid
+	GetAllocations() []Allocation
+
+	// GetReservations returns the guids reserved by ReserveGUID sorted by guid
+	GetReservations() []Reservation
+
+	// GetGUIDNamespace returns the namespace of the pod which the guid is allocated for.
+	// It returns false if the guid isn't allocated for a pod.
+	GetGUIDNamespace(guid string) (string, bool)
+
+	// FragmentationScore returns the fragmentation of the free guids in the pool between 0.0, all the free guids
+	// are in one contiguous block, and 1.0, every free guid is a separate block.
+	FragmentationScore() float64
+
+	// Stats returns the number of total, allocated, excluded and available guids in the pool
+	Stats() Stats
+
+	// The pool state serialization, see Marshal and Unmarshal
+	json.Marshaler
+	json.Unmarshaler
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// Stats are the guid counts of the pool
+type Stats struct {
+	Total     uint64 // guids in the pool range
+	Allocated uint64 // allocated guids, including guid ranges
+	Excluded  uint64 // guids in the excluded ranges
+	Available uint64 // guids which can be allocated
+}
+
+// SubRangeConfig describes the sub-ranges which the pool generates guids from
+type SubRangeConfig struct {
+	Ranges    []config.GUIDPoolRangeConfig // sub-ranges sorted by range start, the whole pool range is used if empty
+	Total     uint64                       // guids in the sub-ranges
+	Allocated uint64                       // allocated guids in the sub-ranges
+}
+
+// Allocation is an allocated guid of the pool
+type Allocation struct {
+	GUID      GUID
+	PodUID    types.UID
+	Namespace string // pod namespace, empty for guid ranges
+	Network   string
+	PKey      string // pKey the guid was added to in the subnet manager, empty if unknown
+	Switch    string // switch the guid was allocated on by AllocateGUIDTopologyAware, empty if unknown
 }
 
+// Reservation is a guid of the pool reserved for a pod name
+type Reservation struct {
+	GUID      GUID
+	Namespace string
+	PodName   string
+	PodUID    types.UID // uid of the current pod of the name
+}
+
+// allocation holds the pod network which an allocated guid belongs to
+type allocation struct {
+	podUID    types.UID
+	namespace string // pod namespace, empty for guid ranges which aren't counted in the namespaces usage
+	network   string
+	pKey      string // pKey the guid was added to, empty if unknown
+	switchID  string // switch the guid was allocated on, empty if unknown
+}
+
+// reservation holds the pod which a guid reserved by ReserveGUID is pinned to
+type reservation struct {
+	namespace string
+	podName   string
+	podUID    types.UID // uid of the current pod of the name, the only pod which may allocate the guid
+}
+
+// guidRange is a range of guids including its first and last guids
+type guidRange struct {
+	start GUID
+	end   GUID
+}
+
+// guidPool is safe for concurrent use, every exported method holds the lock for its whole critical section and the
+// unexported methods expect the caller to hold it
 type guidPool struct {
-	rangeStart  GUID          // first guid in range
-	rangeEnd    GUID          // last guid in range
-	currentGUID GUID          // last given guid
-	guidPoolMap map[GUID]bool // allocated guid map and status
+	lock          sync.RWMutex          // guards all the fields below
+	rangeStart    GUID                  // first guid in range
+	rangeEnd      GUID                  // last guid in range
+	currentGUID   GUID                  // last given guid
+	guidPoolMap   map[GUID]*allocation  // allocated guid map and its owner
+	reservations  map[GUID]*reservation // guids pinned to pod names, allocated or not
+	quotas        map[string]int        // max allocated guids mapped by namespace
+	excludeRanges []guidRange           // sorted ranges of the pool which aren't allocated
+	subRanges     []guidRange           // sorted ranges of the pool which guids are generated from, if not empty
+	namespaceByte int                   // index of the guid byte holding the namespace hash, -1 if disabled
+	strategy      allocationStrategy    // selects the generated guids
 }
 
 func NewPool(conf *config.GUIDPoolConfig) (Pool, error) {
@@ -36,37 +221,312 @@ func NewPool(conf *config.GUIDPoolConfig) (Pool, error) {
 	}
 	rangeEnd, err := ParseGUID(conf.RangeEnd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse guidRangeStart %v", err)
+		return nil, fmt.Errorf("failed to parse guidRangeEnd %v", err)
 	}
-	if !isValidRange(rangeStart, rangeEnd) {
-		return nil, fmt.Errorf("invalid guid range. rangeStart: %v rangeEnd: %v", rangeStart, rangeEnd)
+	if err = validateRange(rangeStart, rangeEnd); err != nil {
+		return nil, err
+	}
+
+	excludeRanges, err := parseExcludeRanges(conf.ExcludeRanges, rangeStart, rangeEnd)
+	if err != nil {
+		return nil, err
+	}
+	if excludeRanges, err = addReservedGUIDs(excludeRanges, conf.ReservedGUIDs, rangeStart, rangeEnd); err != nil {
+		return nil, err
+	}
+
+	namespaceByte, err := parseNamespacePrefix(conf.NamespacePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy, err := newAllocationStrategy(conf.AllocationStrategy)
+	if err != nil {
+		return nil, err
 	}
 
 	return &guidPool{
-		rangeStart:  rangeStart,
-		rangeEnd:    rangeEnd,
-		currentGUID: rangeStart,
-		guidPoolMap: map[GUID]bool{},
+		rangeStart:    rangeStart,
+		rangeEnd:      rangeEnd,
+		currentGUID:   rangeStart,
+		guidPoolMap:   map[GUID]*allocation{},
+		reservations:  map[GUID]*reservation{},
+		quotas:        map[string]int{},
+		excludeRanges: excludeRanges,
+		namespaceByte: namespaceByte,
+		strategy:      strategy,
 	}, nil
 }
 
+// parseNamespacePrefix parses the index of the guid byte holding the namespace hash, -1 if empty.
+// It returns error if the index isn't a guid byte index.
+func parseNamespacePrefix(namespacePrefix string) (int, error) {
+	if namespacePrefix == "" {
+		return -1, nil
+	}
+
+	namespaceByte, err := strconv.Atoi(namespacePrefix)
+	if err != nil || namespaceByte < 0 || namespaceByte >= guidLength {
+		return 0, fmt.Errorf("invalid guid namespace prefix %q, should be a guid byte index 0 to %d",
+			namespacePrefix, guidLength-1)
+	}
+	return namespaceByte, nil
+}
+
+// parseExcludeRanges parses the excluded ranges and sorts them.
+// It returns error if a range isn't within the pool range or the ranges overlap.
+func parseExcludeRanges(ranges []config.GUIDPoolRangeConfig, rangeStart, rangeEnd GUID) ([]guidRange, error) {
+	excludeRanges := make([]guidRange, 0, len(ranges))
+	for _, excludeRange := range ranges {
+		start, err := ParseGUID(excludeRange.RangeStart)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse excluded range start %v", err)
+		}
+		end, err := ParseGUID(excludeRange.RangeEnd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse excluded range end %v", err)
+		}
+		if start > end || start < rangeStart || end > rangeEnd {
+			return nil, fmt.Errorf("invalid excluded guid range %v - %v, not within pool range %v - %v",
+				start, end, rangeStart, rangeEnd)
+		}
+		excludeRanges = append(excludeRanges, guidRange{start: start, end: end})
+	}
+
+	sort.Slice(excludeRanges, func(i, j int) bool { return excludeRanges[i].start < excludeRanges[j].start })
+	for index := 1; index < len(excludeRanges); index++ {
+		if excludeRanges[index].start <= excludeRanges[index-1].end {
+			return nil, fmt.Errorf("excluded guid ranges %v - %v and %v - %v overlap",
+				excludeRanges[index-1].start, excludeRanges[index-1].end,
+				excludeRanges[index].start, excludeRanges[index].end)
+		}
+	}
+
+	return excludeRanges, nil
+}
+
+// addReservedGUIDs adds the reserved guids, which are never allocated, to the sorted excluded ranges as single guid
+// ranges. The reserved guids already excluded are skipped.
+// It returns error if a reserved guid isn't within the pool range.
+func addReservedGUIDs(excludeRanges []guidRange, reservedGUIDs []string, rangeStart, rangeEnd GUID) ([]guidRange,
+	error) {
+	if len(reservedGUIDs) == 0 {
+		return excludeRanges, nil
+	}
+
+	excluded := map[GUID]bool{}
+	var reservedRanges []guidRange
+	for _, reservedGUID := range reservedGUIDs {
+		guid, err := ParseGUID(reservedGUID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse reserved guid %v", err)
+		}
+		if guid < rangeStart || guid > rangeEnd {
+			return nil, fmt.Errorf("invalid reserved guid %v, not within pool range %v - %v", guid, rangeStart,
+				rangeEnd)
+		}
+		if excluded[guid] {
+			continue
+		}
+		excluded[guid] = true
+
+		var inExcludeRange bool
+		for _, excludeRange := range excludeRanges {
+			if guid >= excludeRange.start && guid <= excludeRange.end {
+				inExcludeRange = true
+				break
+			}
+		}
+		if !inExcludeRange {
+			reservedRanges = append(reservedRanges, guidRange{start: guid, end: guid})
+		}
+	}
+
+	excludeRanges = append(excludeRanges, reservedRanges...)
+	sort.Slice(excludeRanges, func(i, j int) bool { return excludeRanges[i].start < excludeRanges[j].start })
+	return excludeRanges, nil
+}
+
 // GenerateGUID generates a guid from the range
 func (p *guidPool) GenerateGUID() (GUID, error) {
-	// this look will ensure that we check all the range
-	// first iteration from current guid to last guid in the range
-	// second iteration from first guid in the range to the latest one
-	if guid := p.getFreeGUID(p.currentGUID, p.rangeEnd); guid != 0 {
+	// RaceCheck: generating a guid advances currentGUID
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.generateGUID()
+}
+
+func (p *guidPool) generateGUID() (GUID, error) {
+	if guid := p.strategy.generateGUID(p); guid != 0 {
 		return guid, nil
 	}
 
-	if guid := p.getFreeGUID(p.rangeStart, p.rangeEnd); guid != 0 {
-		return guid, nil
+	if len(p.subRanges) != 0 {
+		return 0, fmt.Errorf("%w: guid pool sub-ranges are full", ErrPoolExhausted)
 	}
-	return 0, fmt.Errorf("guid pool range is full")
+	return 0, fmt.Errorf("%w: guid pool range is full", ErrPoolExhausted)
+}
+
+// GenerateNamespaceGUID generates a guid of the namespace from the range
+func (p *guidPool) GenerateNamespaceGUID(namespace string) (GUID, error) {
+	// RaceCheck: generating a guid without namespace prefix advances currentGUID
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.generateNamespaceGUID(namespace)
+}
+
+func (p *guidPool) generateNamespaceGUID(namespace string) (GUID, error) {
+	if p.namespaceByte < 0 {
+		return p.generateGUID()
+	}
+
+	// namespace guids are generated sequentially in their sub-space, from the first free guid of the pool
+	searchRanges := p.subRanges
+	if len(searchRanges) == 0 {
+		searchRanges = []guidRange{{start: p.rangeStart, end: p.rangeEnd}}
+	}
+
+	prefix := namespaceHash(namespace)
+	for _, searchRange := range searchRanges {
+		if guid := p.getFreeNamespaceGUID(searchRange.start, searchRange.end, prefix); guid != 0 {
+			return guid, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no free guid with namespace %s prefix %02x", ErrPoolExhausted, namespace, prefix)
+}
+
+// HasNamespacePrefix checks the namespace prefix byte of the guid
+func (p *guidPool) HasNamespacePrefix(guid GUID, namespace string) bool {
+	// RaceCheck: namespaceByte is never written after the pool is created
+	return p.hasNamespacePrefix(guid, namespace)
+}
+
+func (p *guidPool) hasNamespacePrefix(guid GUID, namespace string) bool {
+	return p.namespaceByte < 0 || p.getNamespacePrefix(guid) == namespaceHash(namespace)
+}
+
+// getNamespacePrefix returns the namespace prefix byte of the guid
+func (p *guidPool) getNamespacePrefix(guid GUID) byte {
+	return byte(guid >> p.namespaceShift())
+}
+
+// namespaceShift returns the bit offset of the namespace prefix byte in the guid
+func (p *guidPool) namespaceShift() uint {
+	return uint(byteBitLen * (guidLength - 1 - p.namespaceByte))
+}
+
+// nextNamespaceGUID returns the first guid from the given guid whose namespace prefix byte is the given prefix.
+// It returns false if there is no such guid.
+func (p *guidPool) nextNamespaceGUID(guid GUID, prefix byte) (GUID, bool) {
+	current := p.getNamespacePrefix(guid)
+	if current == prefix {
+		return guid, true
+	}
+
+	shift := p.namespaceShift()
+	lowerMask := GUID(1)<<shift - 1
+	next := guid&^(lowerMask|GUID(byteMask)<<shift) | GUID(prefix)<<shift
+	if current > prefix {
+		// the prefix is behind the guid, move to the next block of the bytes before the prefix byte.
+		// The block size overflows to 0 for the first guid byte, which has no such block.
+		next += GUID(1) << (shift + byteBitLen)
+		if next < guid {
+			return 0, false
+		}
+	}
+	return next, true
+}
+
+// getFreeNamespaceGUID return free guid with the namespace prefix in given range, skipping the excluded ranges
+func (p *guidPool) getFreeNamespaceGUID(start, end GUID, prefix byte) GUID {
+	guid, found := p.nextNamespaceGUID(start, prefix)
+	for found && guid <= end {
+		if excludeRange, excluded := p.getExcludeRange(guid); excluded {
+			guid, found = p.nextNamespaceGUID(excludeRange.end+1, prefix)
+			continue
+		}
+		if !p.isUsedGUID(guid) {
+			return guid
+		}
+		// the last guid can't be in the range, so the next guid doesn't overflow
+		guid, found = p.nextNamespaceGUID(guid+1, prefix)
+	}
+
+	return 0
+}
+
+// namespaceHash returns the namespace prefix byte of the namespace guids, the fnv-1a hash of the namespace name
+func namespaceHash(namespace string) byte {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(namespace))
+	return byte(hash.Sum32() & byteMask)
+}
+
+// AddSubRange adds the sub-range which guids are generated from
+func (p *guidPool) AddSubRange(start, end GUID) error {
+	// RaceCheck: adding a sub-range writes subRanges
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if start > end || start < p.rangeStart || end > p.rangeEnd {
+		return fmt.Errorf("invalid guid sub-range %v - %v, not within pool range %v - %v",
+			start, end, p.rangeStart, p.rangeEnd)
+	}
+
+	for _, subRange := range p.subRanges {
+		if start <= subRange.end && end >= subRange.start {
+			return fmt.Errorf("guid sub-range %v - %v overlaps sub-range %v - %v",
+				start, end, subRange.start, subRange.end)
+		}
+	}
+
+	log.Info().Msgf("adding guid pool sub-range %v - %v", start, end)
+	p.subRanges = append(p.subRanges, guidRange{start: start, end: end})
+	sort.Slice(p.subRanges, func(i, j int) bool { return p.subRanges[i].start < p.subRanges[j].start })
+	return nil
+}
+
+// SubRangeInfo returns the sub-ranges of the pool
+func (p *guidPool) SubRangeInfo() SubRangeConfig {
+	// RaceCheck: reads subRanges and guidPoolMap
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	info := SubRangeConfig{}
+	for _, subRange := range p.subRanges {
+		info.Ranges = append(info.Ranges, config.GUIDPoolRangeConfig{
+			RangeStart: subRange.start.String(), RangeEnd: subRange.end.String()})
+		info.Total += uint64(subRange.end-subRange.start) + 1
+	}
+
+	for guid := range p.guidPoolMap {
+		for _, subRange := range p.subRanges {
+			if guid >= subRange.start && guid <= subRange.end {
+				info.Allocated++
+				break
+			}
+		}
+	}
+	return info
+}
+
+// ExtendRange moves the range end by count guids
+func (p *guidPool) ExtendRange(count uint64) error {
+	// RaceCheck: extending the range writes rangeEnd
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	rangeEnd := p.rangeEnd + GUID(count)
+	if rangeEnd < p.rangeEnd || validateRange(p.rangeStart, rangeEnd) != nil {
+		return fmt.Errorf("can't extend guid range %v - %v by %d guids", p.rangeStart, p.rangeEnd, count)
+	}
+
+	log.Info().Msgf("extending guid pool range end from %v to %v", p.rangeEnd, rangeEnd)
+	p.rangeEnd = rangeEnd
+	return nil
 }
 
 // ReleaseGUID release allocated guid
 func (p *guidPool) ReleaseGUID(guid string) error {
+	// RaceCheck: releasing deletes from guidPoolMap
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	log.Debug().Msgf("releasing guid %s", guid)
 	guidAddr, err := ParseGUID(guid)
 	if err != nil {
@@ -77,11 +537,69 @@ func (p *guidPool) ReleaseGUID(guid string) error {
 		return fmt.Errorf("failed to release guid %s, not allocated ", guid)
 	}
 	delete(p.guidPoolMap, guidAddr)
+	metrics.GUIDReleases.Inc()
 	return nil
 }
 
-func (p *guidPool) AllocateGUID(guid string) error {
-	log.Debug().Msgf("allocating guid %s", guid)
+// ReleaseGUIDByPodUID release all the allocated guids of the pod
+func (p *guidPool) ReleaseGUIDByPodUID(podUID types.UID) ([]string, error) {
+	// RaceCheck: releasing deletes from guidPoolMap while iterating it
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	log.Debug().Msgf("releasing guids of pod %s", podUID)
+	var released []string
+	for guidAddr, owner := range p.guidPoolMap {
+		if owner.podUID != podUID {
+			continue
+		}
+		delete(p.guidPoolMap, guidAddr)
+		released = append(released, guidAddr.String())
+	}
+
+	if len(released) == 0 {
+		return nil, fmt.Errorf("failed to release guids of pod %s, no allocated guids", podUID)
+	}
+	metrics.GUIDReleases.Add(float64(len(released)))
+	return released, nil
+}
+
+// ReleaseAllGUIDsInNamespace release all the allocated guids of the namespace pods
+func (p *guidPool) ReleaseAllGUIDsInNamespace(namespace string) ([]string, error) {
+	// RaceCheck: releasing deletes from guidPoolMap while iterating it
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	log.Debug().Msgf("releasing guids of namespace %s", namespace)
+	var released []string
+	for guidAddr, owner := range p.guidPoolMap {
+		// guid ranges have no namespace and are never released with the namespace
+		if namespace == "" || owner.namespace != namespace {
+			continue
+		}
+		delete(p.guidPoolMap, guidAddr)
+		released = append(released, guidAddr.String())
+	}
+	for guidAddr, reserved := range p.reservations {
+		if reserved.namespace == namespace {
+			delete(p.reservations, guidAddr)
+		}
+	}
+
+	if len(released) == 0 {
+		return nil, fmt.Errorf("failed to release guids of namespace %s, no allocated guids", namespace)
+	}
+	metrics.GUIDReleases.Add(float64(len(released)))
+	return released, nil
+}
+
+func (p *guidPool) AllocateGUID(podUID types.UID, namespace, network, guid string) error {
+	// RaceCheck: the free guid check and the allocation must be atomic
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.allocateGUID(podUID, namespace, network, guid)
+}
+
+func (p *guidPool) allocateGUID(podUID types.UID, namespace, network, guid string) error {
+	log.Debug().Msgf("allocating guid %s for pod %s namespace %s network %s", guid, podUID, namespace, network)
 
 	guidAddr, err := ParseGUID(guid)
 	if err != nil {
@@ -92,22 +610,419 @@ func (p *guidPool) AllocateGUID(guid string) error {
 		return fmt.Errorf("out of range guid %s, pool range %v - %v", guid, p.rangeStart, p.rangeEnd)
 	}
 
-	if _, exist := p.guidPoolMap[guidAddr]; exist {
-		return fmt.Errorf("failed to allocate requested guid %s, already allocated", guid)
+	if _, excluded := p.getExcludeRange(guidAddr); excluded {
+		return fmt.Errorf("failed to allocate requested guid %s, excluded from the pool", guid)
 	}
 
-	p.guidPoolMap[guidAddr] = true
+	if owner, exist := p.guidPoolMap[guidAddr]; exist {
+		if owner.podUID != podUID && owner.namespace == namespace && owner.network == network {
+			return fmt.Errorf("%w: guid %s is allocated for pod %s network %s", ErrAllocatedToOtherPod, guid,
+				owner.podUID, owner.network)
+		}
+		return fmt.Errorf("%w: guid %s is allocated for pod %s network %s", ErrAllocated, guid, owner.podUID,
+			owner.network)
+	}
+
+	if reserved, exist := p.reservations[guidAddr]; exist && reserved.podUID != podUID {
+		return fmt.Errorf("%w: guid %s is reserved for pod %s/%s", ErrReserved, guid, reserved.namespace,
+			reserved.podName)
+	}
+
+	if quota, ok := p.quotas[namespace]; ok && p.namespaceUsage(namespace) >= quota {
+		return fmt.Errorf("failed to allocate requested guid %s, namespace %s exceeded its quota of %d guids",
+			guid, namespace, quota)
+	}
+
+	p.guidPoolMap[guidAddr] = &allocation{podUID: podUID, namespace: namespace, network: network}
+	metrics.GUIDAllocations.Inc()
 	return nil
 }
 
-func isValidRange(rangeStart, rangeEnd GUID) bool {
-	return rangeStart <= rangeEnd && rangeStart != 0 && rangeEnd != 0xFFFFFFFFFFFFFFFF
+// ReserveGUID pins the guid to the namespace pod name and records the current uid of the pod
+func (p *guidPool) ReserveGUID(podUID types.UID, namespace, podName, guid string) error {
+	// RaceCheck: the reservation check and update must be atomic
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	guidAddr, err := ParseGUID(guid)
+	if err != nil {
+		return err
+	}
+
+	if guidAddr < p.rangeStart || guidAddr > p.rangeEnd {
+		return fmt.Errorf("%w: guid %s, pool range %v - %v", ErrOutOfRange, guid, p.rangeStart, p.rangeEnd)
+	}
+
+	if _, excluded := p.getExcludeRange(guidAddr); excluded {
+		return fmt.Errorf("failed to reserve guid %s, excluded from the pool", guid)
+	}
+
+	if reserved, exist := p.reservations[guidAddr]; exist {
+		if reserved.namespace != namespace || reserved.podName != podName {
+			return fmt.Errorf("%w: guid %s is reserved for pod %s/%s", ErrReservationConflict, guid,
+				reserved.namespace, reserved.podName)
+		}
+		reserved.podUID = podUID
+		return nil
+	}
+
+	log.Info().Msgf("reserving guid %s for pod %s/%s", guid, namespace, podName)
+	p.reservations[guidAddr] = &reservation{namespace: namespace, podName: podName, podUID: podUID}
+	return nil
+}
+
+// AllocateGUIDTopologyAware allocates the first free guid next to the guids allocated on the preferred switch
+func (p *guidPool) AllocateGUIDTopologyAware(podUID types.UID, namespace, network, preferredSwitch string) (
+	net.HardwareAddr, error) {
+	// RaceCheck: the sibling guid lookup and the allocation must be atomic
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	guid, found := p.getSwitchSiblingGUID(preferredSwitch, namespace)
+	if !found {
+		var err error
+		if guid, err = p.generateNamespaceGUID(namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.allocateGUID(podUID, namespace, network, guid.String()); err != nil {
+		return nil, err
+	}
+
+	p.guidPoolMap[guid].switchID = preferredSwitch
+	return guid.HardWareAddress(), nil
+}
+
+// getSwitchSiblingGUID returns the lowest free guid with the namespace prefix which follows or precedes a guid
+// allocated on the switch, within the pool sub-ranges if it has any. It returns false if there is no such guid.
+func (p *guidPool) getSwitchSiblingGUID(switchID, namespace string) (GUID, bool) {
+	if switchID == "" {
+		return 0, false
+	}
+
+	for _, guid := range p.sortedAllocatedGUIDs() {
+		if p.guidPoolMap[guid].switchID != switchID {
+			continue
+		}
+
+		for _, sibling := range []GUID{guid + 1, guid - 1} {
+			if p.isFreeGUID(sibling) && p.hasNamespacePrefix(sibling, namespace) {
+				return sibling, true
+			}
+		}
+	}
+
+	return 0, false
 }
 
-// getFreeGUID return free guid in given range
+// isFreeGUID checks the guid is in the pool range and its sub-ranges if any, and isn't excluded, allocated or
+// reserved
+func (p *guidPool) isFreeGUID(guid GUID) bool {
+	if guid < p.rangeStart || guid > p.rangeEnd {
+		return false
+	}
+
+	if len(p.subRanges) != 0 {
+		inSubRange := false
+		for _, subRange := range p.subRanges {
+			inSubRange = inSubRange || (guid >= subRange.start && guid <= subRange.end)
+		}
+		if !inSubRange {
+			return false
+		}
+	}
+
+	if _, excluded := p.getExcludeRange(guid); excluded {
+		return false
+	}
+
+	return !p.isUsedGUID(guid)
+}
+
+// isUsedGUID checks whether the guid is allocated or reserved for a pod name
+func (p *guidPool) isUsedGUID(guid GUID) bool {
+	_, allocated := p.guidPoolMap[guid]
+	_, reserved := p.reservations[guid]
+	return allocated || reserved
+}
+
+// SetGUIDPKey records the pKey of the allocated guid
+func (p *guidPool) SetGUIDPKey(guid, pKey string) error {
+	// RaceCheck: writes the allocation shared with guidPoolMap readers
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	guidAddr, err := ParseGUID(guid)
+	if err != nil {
+		return err
+	}
+
+	allocation, exist := p.guidPoolMap[guidAddr]
+	if !exist {
+		return fmt.Errorf("failed to set pKey of guid %s, not allocated", guid)
+	}
+
+	allocation.pKey = pKey
+	return nil
+}
+
+// GetAllocations returns the allocated guids sorted by guid
+func (p *guidPool) GetAllocations() []Allocation {
+	// RaceCheck: reads guidPoolMap
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	allocations := make([]Allocation, 0, len(p.guidPoolMap))
+	for _, guid := range p.sortedAllocatedGUIDs() {
+		owner := p.guidPoolMap[guid]
+		allocations = append(allocations, Allocation{GUID: guid, PodUID: owner.podUID, Namespace: owner.namespace,
+			Network: owner.network, PKey: owner.pKey, Switch: owner.switchID})
+	}
+	return allocations
+}
+
+// GetReservations returns the reserved guids sorted by guid
+func (p *guidPool) GetReservations() []Reservation {
+	// RaceCheck: reads reservations
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	reservations := make([]Reservation, 0, len(p.reservations))
+	for _, guid := range p.sortedReservedGUIDs() {
+		reserved := p.reservations[guid]
+		reservations = append(reservations, Reservation{GUID: guid, Namespace: reserved.namespace,
+			PodName: reserved.podName, PodUID: reserved.podUID})
+	}
+	return reservations
+}
+
+// ValidateAllocation checks the allocation of the guid for the pod network without allocating it
+func (p *guidPool) ValidateAllocation(podUID types.UID, namespace, network, guid string) error {
+	// RaceCheck: reads guidPoolMap and quotas
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	guidAddr, err := ParseGUID(guid)
+	if err != nil {
+		return err
+	}
+
+	if guidAddr < p.rangeStart || guidAddr > p.rangeEnd {
+		return fmt.Errorf("%w: guid %s, pool range %v - %v", ErrOutOfRange, guid, p.rangeStart, p.rangeEnd)
+	}
+
+	owner, exist := p.guidPoolMap[guidAddr]
+	if exist && owner.podUID == podUID && owner.network == network {
+		return nil
+	}
+
+	if p.stats().Available == 0 {
+		return fmt.Errorf("%w: all the guids in range %v - %v are allocated", ErrPoolExhausted,
+			p.rangeStart, p.rangeEnd)
+	}
+
+	if excludeRange, excluded := p.getExcludeRange(guidAddr); excluded {
+		return fmt.Errorf("%w: guid %s is excluded from the pool in range %v - %v", ErrReserved, guid,
+			excludeRange.start, excludeRange.end)
+	}
+
+	if exist && owner.namespace == "" {
+		return fmt.Errorf("%w: guid %s is reserved for %s network %s", ErrReserved, guid, owner.podUID, owner.network)
+	}
+
+	if exist {
+		return fmt.Errorf("%w: guid %s is allocated for pod %s network %s", ErrAllocated, guid, owner.podUID,
+			owner.network)
+	}
+
+	if reserved, reservedExist := p.reservations[guidAddr]; reservedExist && reserved.podUID != podUID {
+		return fmt.Errorf("%w: guid %s is reserved for pod %s/%s", ErrReserved, guid, reserved.namespace,
+			reserved.podName)
+	}
+
+	if quota, ok := p.quotas[namespace]; ok && p.namespaceUsage(namespace) >= quota {
+		return fmt.Errorf("%w: namespace %s has a quota of %d guids", ErrQuotaExceeded, namespace, quota)
+	}
+
+	return nil
+}
+
+// SetQuota sets the maximum number of guids allocated to the pods of the namespace
+func (p *guidPool) SetQuota(namespace string, maxGUIDs int) {
+	// RaceCheck: writes quotas
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	log.Debug().Msgf("setting guid quota of namespace %s to %d", namespace, maxGUIDs)
+	if maxGUIDs <= 0 {
+		delete(p.quotas, namespace)
+		return
+	}
+	p.quotas[namespace] = maxGUIDs
+}
+
+// GetNamespaceUsage returns the number of guids allocated to the pods of the namespace
+func (p *guidPool) GetNamespaceUsage(namespace string) int {
+	// RaceCheck: reads guidPoolMap
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.namespaceUsage(namespace)
+}
+
+func (p *guidPool) namespaceUsage(namespace string) int {
+	var usage int
+	for _, owner := range p.guidPoolMap {
+		if owner.namespace == namespace {
+			usage++
+		}
+	}
+	return usage
+}
+
+// GetGUIDNamespace returns the namespace of the pod which the guid is allocated for
+func (p *guidPool) GetGUIDNamespace(guid string) (string, bool) {
+	// RaceCheck: reads guidPoolMap
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	guidAddr, err := ParseGUID(guid)
+	if err != nil {
+		return "", false
+	}
+
+	owner, exist := p.guidPoolMap[guidAddr]
+	if !exist || owner.namespace == "" {
+		return "", false
+	}
+	return owner.namespace, true
+}
+
+// AllocateGUIDRange allocates the first range of size contiguous free guids in the pool
+func (p *guidPool) AllocateGUIDRange(ownerUID types.UID, network string, size int) (GUID, GUID, error) {
+	// RaceCheck: the free range lookup and the allocation must be atomic
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	log.Debug().Msgf("allocating guid range of size %d for %s network %s", size, ownerUID, network)
+	if size <= 0 {
+		return 0, 0, fmt.Errorf("invalid guid range size %d", size)
+	}
+
+	// find the first gap between the allocated guids and excluded ranges that fits the range
+	rangeStart := p.rangeStart
+	for _, usedRange := range p.sortedUsedRanges() {
+		if usedRange.start-rangeStart >= GUID(size) {
+			break
+		}
+		rangeStart = usedRange.end + 1
+	}
+
+	rangeEnd := rangeStart + GUID(size-1)
+	if rangeEnd < rangeStart || rangeEnd > p.rangeEnd {
+		return 0, 0, fmt.Errorf("no free guid range of size %d in pool range %v - %v", size, p.rangeStart, p.rangeEnd)
+	}
+
+	for guidAddr := rangeStart; guidAddr <= rangeEnd; guidAddr++ {
+		p.guidPoolMap[guidAddr] = &allocation{podUID: ownerUID, network: network}
+	}
+	metrics.GUIDAllocations.Add(float64(size))
+	return rangeStart, rangeEnd, nil
+}
+
+// FragmentationScore returns the number of free contiguous blocks relative to the number of free guids,
+// normalized so a single free block scores 0.0 and free guids which are all separated score 1.0.
+// The reserved guids which aren't allocated aren't free.
+func (p *guidPool) FragmentationScore() float64 {
+	// RaceCheck: reads guidPoolMap, reservations and the range
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	freeGUIDs := p.stats().Available
+	for guidAddr := range p.reservations {
+		_, allocated := p.guidPoolMap[guidAddr]
+		if _, excluded := p.getExcludeRange(guidAddr); !allocated && !excluded &&
+			guidAddr >= p.rangeStart && guidAddr <= p.rangeEnd {
+			freeGUIDs--
+		}
+	}
+	if freeGUIDs <= 1 {
+		return 0
+	}
+
+	var freeBlocks uint64
+	next := p.rangeStart // first guid after the last allocated guid or excluded range
+	for _, usedRange := range p.sortedUsedRanges() {
+		if usedRange.start > next {
+			freeBlocks++
+		}
+		next = usedRange.end + 1
+	}
+	if next <= p.rangeEnd {
+		freeBlocks++
+	}
+
+	return float64(freeBlocks-1) / float64(freeGUIDs-1)
+}
+
+// Stats returns the guid counts of the pool, the excluded guids aren't available
+func (p *guidPool) Stats() Stats {
+	// RaceCheck: reads guidPoolMap and the range
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.stats()
+}
+
+func (p *guidPool) stats() Stats {
+	stats := Stats{Total: uint64(p.rangeEnd-p.rangeStart) + 1, Allocated: uint64(len(p.guidPoolMap))}
+	for _, excludeRange := range p.excludeRanges {
+		stats.Excluded += uint64(excludeRange.end-excludeRange.start) + 1
+	}
+	stats.Available = stats.Total - stats.Allocated - stats.Excluded
+	return stats
+}
+
+// sortedUsedRanges returns the allocated and reserved guids, as single guid ranges, and the excluded ranges in
+// ascending order
+func (p *guidPool) sortedUsedRanges() []guidRange {
+	used := make([]guidRange, 0, len(p.guidPoolMap)+len(p.reservations)+len(p.excludeRanges))
+	for guidAddr := range p.guidPoolMap {
+		used = append(used, guidRange{start: guidAddr, end: guidAddr})
+	}
+	for guidAddr := range p.reservations {
+		if _, allocated := p.guidPoolMap[guidAddr]; !allocated {
+			used = append(used, guidRange{start: guidAddr, end: guidAddr})
+		}
+	}
+	used = append(used, p.excludeRanges...)
+	sort.Slice(used, func(i, j int) bool { return used[i].start < used[j].start })
+	return used
+}
+
+// getExcludeRange returns the excluded range which contains the guid
+func (p *guidPool) getExcludeRange(guid GUID) (guidRange, bool) {
+	for _, excludeRange := range p.excludeRanges {
+		if guid >= excludeRange.start && guid <= excludeRange.end {
+			return excludeRange, true
+		}
+	}
+	return guidRange{}, false
+}
+
+// validateRange returns a descriptive error if the range is empty or includes the reserved all-zeros or all-ones
+// guids
+func validateRange(rangeStart, rangeEnd GUID) error {
+	switch {
+	case rangeStart > rangeEnd:
+		return fmt.Errorf("invalid guid range %v - %v, the range start is after the range end", rangeStart, rangeEnd)
+	case rangeStart == 0:
+		return fmt.Errorf("invalid guid range %v - %v, the reserved all-zeros guid %v can't be allocated",
+			rangeStart, rangeEnd, rangeStart)
+	case rangeEnd == 0xFFFFFFFFFFFFFFFF:
+		return fmt.Errorf("invalid guid range %v - %v, the reserved all-ones guid %v can't be allocated",
+			rangeStart, rangeEnd, rangeEnd)
+	}
+	return nil
+}
+
+// getFreeGUID return free guid in given range, skipping the excluded ranges
 func (p *guidPool) getFreeGUID(start, end GUID) GUID {
 	for guid := start; guid <= end; guid++ {
-		if _, ok := p.guidPoolMap[guid]; !ok {
+		if excludeRange, excluded := p.getExcludeRange(guid); excluded {
+			guid = excludeRange.end
+			continue
+		}
+		if !p.isUsedGUID(guid) {
 			p.currentGUID++
 			return guid
 		}
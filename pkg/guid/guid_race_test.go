@@ -0,0 +1,69 @@
+package guid
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+)
+
+// TestGuidPoolRaceCondition allocates and releases guids of the pool concurrently, run with -race to detect
+// unguarded accesses to the pool fields
+func TestGuidPoolRaceCondition(t *testing.T) {
+	const goroutines = 100
+	const iterations = 50
+
+	pool, err := NewPool(&config.GUIDPoolConfig{
+		RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:0F:FF"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetQuota("default", goroutines)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for index := 0; index < goroutines; index++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			podUID := types.UID(fmt.Sprintf("pod-%d", index))
+			for iteration := 0; iteration < iterations; iteration++ {
+				guid, generateErr := pool.GenerateGUID()
+				if generateErr != nil {
+					errs <- generateErr
+					return
+				}
+
+				// the generated guid isn't reserved, another goroutine may allocate it first
+				if pool.AllocateGUID(podUID, "default", "test", guid.String()) != nil {
+					continue
+				}
+
+				_ = pool.Stats()
+				_ = pool.GetAllocations()
+				if _, marshalErr := Marshal(pool, SerializationFormatJSON); marshalErr != nil {
+					errs <- marshalErr
+					return
+				}
+
+				if releaseErr := pool.ReleaseGUID(guid.String()); releaseErr != nil {
+					errs <- releaseErr
+					return
+				}
+			}
+		}(index)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err = range errs {
+		t.Error(err)
+	}
+
+	if stats := pool.Stats(); stats.Allocated != 0 {
+		t.Errorf("expected all the guids to be released, %d guids are allocated", stats.Allocated)
+	}
+}
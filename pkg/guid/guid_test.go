@@ -1,13 +1,22 @@
 package guid
 
 import (
+	"errors"
+
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
 )
 
 var _ = Describe("GUID Pool", func() {
+	podUID := types.UID("a8d3a6b4-1b7f-4c0e-9c38-7e3ac5bd5a2f")
+	namespace := "default"
+	network := "test"
 	conf := &config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:FF:FF:FF:FF:FF:FF:FF"}
 	Context("NewPool", func() {
 		It("Create guid pool with valid  parameters", func() {
@@ -46,8 +55,21 @@ var _ = Describe("GUID Pool", func() {
 				RangeEnd: "02:00:00:00:00:00:00:00"}
 			pool, err := NewPool(invalidRangeConf)
 			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("range start is after the range end"))
 			Expect(pool).To(BeNil())
 		})
+		It("Create guid pool with a single guid", func() {
+			singleGUIDConf := &config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:01",
+				RangeEnd: "02:00:00:00:00:00:00:01"}
+			pool, err := NewPool(singleGUIDConf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.Stats().Total).To(Equal(uint64(1)))
+		})
+		It("Create guid pool with a 6 bytes mac address", func() {
+			invalidConf := &config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00", RangeEnd: "02:FF:FF:FF:FF:FF:FF:FF"}
+			_, err := NewPool(invalidConf)
+			Expect(err).To(HaveOccurred())
+		})
 
 	})
 	Context("GenerateGUID", func() {
@@ -58,7 +80,7 @@ var _ = Describe("GUID Pool", func() {
 			Expect(err).ToNot(HaveOccurred())
 			guid, err := pool.GenerateGUID()
 			Expect(err).ToNot(HaveOccurred())
-			Expect(pool.AllocateGUID(guid.String())).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).ToNot(HaveOccurred())
 			Expect(guid.String()).To(Equal("00:00:00:00:00:00:01:00"))
 			guid, err = pool.GenerateGUID()
 			Expect(err).ToNot(HaveOccurred())
@@ -72,7 +94,7 @@ var _ = Describe("GUID Pool", func() {
 			guid, err := pool.GenerateGUID()
 			Expect(err).ToNot(HaveOccurred())
 			Expect(guid.String()).To(Equal("00:00:00:00:00:00:01:00"))
-			Expect(pool.AllocateGUID(guid.String())).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).ToNot(HaveOccurred())
 			err = pool.ReleaseGUID(guid.String())
 			Expect(err).ToNot(HaveOccurred())
 
@@ -81,7 +103,7 @@ var _ = Describe("GUID Pool", func() {
 			for i := 0; i < 255; i++ {
 				guid, err = pool.GenerateGUID()
 				Expect(err).ToNot(HaveOccurred())
-				Expect(pool.AllocateGUID(guid.String())).ToNot(HaveOccurred())
+				Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).ToNot(HaveOccurred())
 			}
 
 			// After the last guid in the pool was allocated then the pool check back from first guid
@@ -94,7 +116,7 @@ var _ = Describe("GUID Pool", func() {
 				RangeEnd: "00:00:00:00:00:00:01:01"}
 			p, err := NewPool(poolConfig)
 			Expect(err).ToNot(HaveOccurred())
-			err = p.AllocateGUID("00:00:00:00:00:00:01:00")
+			err = p.AllocateGUID(podUID, namespace, network, "00:00:00:00:00:00:01:00")
 			Expect(err).ToNot(HaveOccurred())
 
 			guid, err := p.GenerateGUID()
@@ -109,58 +131,793 @@ var _ = Describe("GUID Pool", func() {
 			guid, err := pool.GenerateGUID()
 			Expect(err).ToNot(HaveOccurred())
 			Expect(guid.String()).To(Equal("00:00:00:00:00:00:01:00"))
-			Expect(pool.AllocateGUID(guid.String())).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).ToNot(HaveOccurred())
+			_, err = pool.GenerateGUID()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("AllocateGUIDRange", func() {
+		It("Allocate guid range from the pool", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			rangeStart, rangeEnd, err := pool.AllocateGUIDRange(podUID, network, 4)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rangeStart.String()).To(Equal("02:00:00:00:00:00:00:00"))
+			Expect(rangeEnd.String()).To(Equal("02:00:00:00:00:00:00:03"))
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:03")).To(HaveOccurred())
+
+			released, err := pool.ReleaseGUIDByPodUID(podUID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(released).To(HaveLen(4))
+		})
+		It("Allocate guid range skipping allocated guids", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:01")).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:05")).ToNot(HaveOccurred())
+
+			rangeStart, rangeEnd, err := pool.AllocateGUIDRange("nad", network, 3)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rangeStart.String()).To(Equal("02:00:00:00:00:00:00:02"))
+			Expect(rangeEnd.String()).To(Equal("02:00:00:00:00:00:00:04"))
+		})
+		It("Allocate guid range larger than the free guids", func() {
+			poolConfig := &config.GUIDPoolConfig{RangeStart: "00:00:00:00:00:00:01:00",
+				RangeEnd: "00:00:00:00:00:00:01:03"}
+			pool, err := NewPool(poolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "00:00:00:00:00:00:01:01")).ToNot(HaveOccurred())
+
+			_, _, err = pool.AllocateGUIDRange("nad", network, 3)
+			Expect(err).To(HaveOccurred())
+			_, _, err = pool.AllocateGUIDRange("nad", network, 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("SetQuota", func() {
+		It("Allocate guids within namespace quota", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			pool.SetQuota(namespace, 2)
+
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:00")).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:01")).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:02")).To(HaveOccurred())
+			Expect(pool.GetNamespaceUsage(namespace)).To(Equal(2))
+
+			// other namespaces aren't limited
+			Expect(pool.AllocateGUID("other", "other", network, "02:00:00:00:00:00:00:02")).ToNot(HaveOccurred())
+			Expect(pool.GetNamespaceUsage("other")).To(Equal(1))
+
+			Expect(pool.ReleaseGUID("02:00:00:00:00:00:00:00")).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:03")).ToNot(HaveOccurred())
+		})
+		It("Remove namespace quota", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			pool.SetQuota(namespace, 1)
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:00")).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:01")).To(HaveOccurred())
+
+			pool.SetQuota(namespace, 0)
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:01")).ToNot(HaveOccurred())
+		})
+	})
+	Context("GetGUIDNamespace", func() {
+		It("Get namespace of allocated guids", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:01")).To(Succeed())
+			_, _, err = pool.AllocateGUIDRange("nad", network, 1)
+			Expect(err).ToNot(HaveOccurred())
+
+			podNamespace, ok := pool.GetGUIDNamespace("02:00:00:00:00:00:00:01")
+			Expect(ok).To(BeTrue())
+			Expect(podNamespace).To(Equal(namespace))
+
+			// guid ranges and free guids have no pod namespace
+			_, ok = pool.GetGUIDNamespace("02:00:00:00:00:00:00:00")
+			Expect(ok).To(BeFalse())
+			_, ok = pool.GetGUIDNamespace("02:00:00:00:00:00:00:02")
+			Expect(ok).To(BeFalse())
+		})
+	})
+	Context("ExcludeRanges", func() {
+		excludeConf := func(ranges ...config.GUIDPoolRangeConfig) *config.GUIDPoolConfig {
+			return &config.GUIDPoolConfig{RangeStart: "00:00:00:00:00:00:01:00",
+				RangeEnd: "00:00:00:00:00:00:01:0F", ExcludeRanges: ranges}
+		}
+		It("Create guid pool with excluded ranges outside the pool range", func() {
+			_, err := NewPool(excludeConf(config.GUIDPoolRangeConfig{RangeStart: "00:00:00:00:00:00:01:0E",
+				RangeEnd: "00:00:00:00:00:00:01:10"}))
+			Expect(err).To(HaveOccurred())
+		})
+		It("Create guid pool with overlapping excluded ranges", func() {
+			_, err := NewPool(excludeConf(
+				config.GUIDPoolRangeConfig{RangeStart: "00:00:00:00:00:00:01:04", RangeEnd: "00:00:00:00:00:00:01:08"},
+				config.GUIDPoolRangeConfig{RangeStart: "00:00:00:00:00:00:01:00", RangeEnd: "00:00:00:00:00:00:01:04"}))
+			Expect(err).To(HaveOccurred())
+		})
+		It("Generate and allocate guids outside the excluded ranges", func() {
+			pool, err := NewPool(excludeConf(
+				config.GUIDPoolRangeConfig{RangeStart: "00:00:00:00:00:00:01:00", RangeEnd: "00:00:00:00:00:00:01:01"},
+				config.GUIDPoolRangeConfig{RangeStart: "00:00:00:00:00:00:01:03", RangeEnd: "00:00:00:00:00:00:01:0E"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.Stats()).To(Equal(Stats{Total: 16, Excluded: 14, Available: 2}))
+
+			guid, err := pool.GenerateGUID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid.String()).To(Equal("00:00:00:00:00:00:01:02"))
+			Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).To(Succeed())
+			guid, err = pool.GenerateGUID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid.String()).To(Equal("00:00:00:00:00:00:01:0f"))
+			Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).To(Succeed())
+			Expect(pool.Stats().Available).To(BeZero())
+
+			_, err = pool.GenerateGUID()
+			Expect(err).To(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "00:00:00:00:00:00:01:05")).ToNot(Succeed())
+		})
+		It("Allocate guid range outside the excluded ranges", func() {
+			pool, err := NewPool(excludeConf(
+				config.GUIDPoolRangeConfig{RangeStart: "00:00:00:00:00:00:01:02", RangeEnd: "00:00:00:00:00:00:01:05"}))
+			Expect(err).ToNot(HaveOccurred())
+			rangeStart, rangeEnd, err := pool.AllocateGUIDRange(podUID, network, 4)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rangeStart.String()).To(Equal("00:00:00:00:00:00:01:06"))
+			Expect(rangeEnd.String()).To(Equal("00:00:00:00:00:00:01:09"))
+		})
+		It("Validate allocation of excluded guid", func() {
+			pool, err := NewPool(excludeConf(
+				config.GUIDPoolRangeConfig{RangeStart: "00:00:00:00:00:00:01:02", RangeEnd: "00:00:00:00:00:00:01:05"}))
+			Expect(err).ToNot(HaveOccurred())
+			err = pool.ValidateAllocation(podUID, namespace, network, "00:00:00:00:00:00:01:03")
+			Expect(errors.Is(err, ErrReserved)).To(BeTrue())
+		})
+	})
+	Context("ReservedGUIDs", func() {
+		reservedConf := func(reservedGUIDs ...string) *config.GUIDPoolConfig {
+			return &config.GUIDPoolConfig{RangeStart: "00:00:00:00:00:00:01:00",
+				RangeEnd: "00:00:00:00:00:00:01:03", ReservedGUIDs: reservedGUIDs}
+		}
+		It("Create guid pool with reserved guid outside the pool range", func() {
+			_, err := NewPool(reservedConf("00:00:00:00:00:00:01:04"))
+			Expect(err).To(HaveOccurred())
+		})
+		It("Create guid pool with invalid reserved guid", func() {
+			_, err := NewPool(reservedConf("00:00:00:00:00:01:00"))
+			Expect(err).To(HaveOccurred())
+		})
+		It("Generate and allocate guids other than the reserved guids", func() {
+			pool, err := NewPool(reservedConf("00:00:00:00:00:00:01:00", "00:00:00:00:00:00:01:02",
+				"00:00:00:00:00:00:01:02"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.Stats()).To(Equal(Stats{Total: 4, Excluded: 2, Available: 2}))
+
+			guid, err := pool.GenerateGUID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid.String()).To(Equal("00:00:00:00:00:00:01:01"))
+			Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).To(Succeed())
+			guid, err = pool.GenerateGUID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid.String()).To(Equal("00:00:00:00:00:00:01:03"))
+			Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).To(Succeed())
+
+			_, err = pool.GenerateGUID()
+			Expect(err).To(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "00:00:00:00:00:00:01:02")).ToNot(Succeed())
+		})
+		It("Create guid pool with reserved guid within an excluded range", func() {
+			conf := reservedConf("00:00:00:00:00:00:01:01")
+			conf.ExcludeRanges = []config.GUIDPoolRangeConfig{
+				{RangeStart: "00:00:00:00:00:00:01:00", RangeEnd: "00:00:00:00:00:00:01:01"}}
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.Stats()).To(Equal(Stats{Total: 4, Excluded: 2, Available: 2}))
+		})
+	})
+	Context("FragmentationScore", func() {
+		poolConfig := &config.GUIDPoolConfig{RangeStart: "00:00:00:00:00:00:01:00",
+			RangeEnd: "00:00:00:00:00:00:01:0F"}
+		It("Fragmentation score of empty and full pool", func() {
+			pool, err := NewPool(poolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.FragmentationScore()).To(Equal(0.0))
+
+			_, _, err = pool.AllocateGUIDRange(podUID, network, 16)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.FragmentationScore()).To(Equal(0.0))
+		})
+		It("Fragmentation score of contiguous allocations", func() {
+			pool, err := NewPool(poolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = pool.AllocateGUIDRange(podUID, network, 8)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.FragmentationScore()).To(Equal(0.0))
+		})
+		It("Fragmentation score after releasing separated guids", func() {
+			pool, err := NewPool(poolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = pool.AllocateGUIDRange(podUID, network, 16)
+			Expect(err).ToNot(HaveOccurred())
+
+			// 4 free guids in 4 blocks
+			for _, guid := range []string{"00:00:00:00:00:00:01:01", "00:00:00:00:00:00:01:03",
+				"00:00:00:00:00:00:01:05", "00:00:00:00:00:00:01:07"} {
+				Expect(pool.ReleaseGUID(guid)).ToNot(HaveOccurred())
+			}
+			Expect(pool.FragmentationScore()).To(Equal(1.0))
+
+			// 5 free guids in 4 blocks
+			Expect(pool.ReleaseGUID("00:00:00:00:00:00:01:08")).ToNot(HaveOccurred())
+			Expect(pool.FragmentationScore()).To(Equal(0.75))
+		})
+		It("Fragmentation score doesn't count reserved guids as free", func() {
+			pool, err := NewPool(poolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = pool.AllocateGUIDRange(podUID, network, 12)
+			Expect(err).ToNot(HaveOccurred())
+			for _, guid := range []string{"00:00:00:00:00:00:01:0D", "00:00:00:00:00:00:01:0E",
+				"00:00:00:00:00:00:01:0F"} {
+				Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", guid)).To(Succeed())
+			}
+
+			// a single free guid
+			Expect(pool.FragmentationScore()).To(Equal(0.0))
+
+			// 2 free guids in 2 blocks
+			Expect(pool.ReleaseGUID("00:00:00:00:00:00:01:0A")).ToNot(HaveOccurred())
+			Expect(pool.FragmentationScore()).To(Equal(1.0))
+		})
+	})
+	Context("SubRanges", func() {
+		poolConfig := &config.GUIDPoolConfig{RangeStart: "00:00:00:00:00:00:01:00",
+			RangeEnd: "00:00:00:00:00:00:01:0F"}
+		It("Add sub-ranges outside the pool range or overlapping", func() {
+			pool, err := NewPool(poolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AddSubRange(0x10c, 0x110)).ToNot(Succeed())
+			Expect(pool.AddSubRange(0x104, 0x103)).ToNot(Succeed())
+			Expect(pool.AddSubRange(0x104, 0x107)).To(Succeed())
+			Expect(pool.AddSubRange(0x100, 0x104)).ToNot(Succeed())
+		})
+		It("Generate guids from the sub-ranges", func() {
+			pool, err := NewPool(poolConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AddSubRange(0x10c, 0x10d)).To(Succeed())
+			Expect(pool.AddSubRange(0x104, 0x104)).To(Succeed())
+
+			for _, expected := range []string{"00:00:00:00:00:00:01:04", "00:00:00:00:00:00:01:0c",
+				"00:00:00:00:00:00:01:0d"} {
+				guid, err := pool.GenerateGUID()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(guid.String()).To(Equal(expected))
+				Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).To(Succeed())
+			}
+
 			_, err = pool.GenerateGUID()
+			Expect(errors.Is(err, ErrPoolExhausted)).To(BeTrue())
+			Expect(pool.SubRangeInfo()).To(Equal(SubRangeConfig{Ranges: []config.GUIDPoolRangeConfig{
+				{RangeStart: "00:00:00:00:00:00:01:04", RangeEnd: "00:00:00:00:00:00:01:04"},
+				{RangeStart: "00:00:00:00:00:00:01:0c", RangeEnd: "00:00:00:00:00:00:01:0d"}},
+				Total: 3, Allocated: 3}))
+		})
+	})
+	Context("AllocationStrategy", func() {
+		It("Create guid pool with unknown allocation strategy", func() {
+			_, err := NewPool(&config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00",
+				RangeEnd: "02:00:00:00:00:00:00:FF", AllocationStrategy: "round-robin"})
 			Expect(err).To(HaveOccurred())
 		})
+		It("Generate random guids from the free guids until the pool is exhausted", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "00:00:00:00:00:00:01:00",
+				RangeEnd: "00:00:00:00:00:00:01:0F", AllocationStrategy: config.AllocationStrategyRandom,
+				ExcludeRanges: []config.GUIDPoolRangeConfig{
+					{RangeStart: "00:00:00:00:00:00:01:04", RangeEnd: "00:00:00:00:00:00:01:07"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "00:00:00:00:00:00:01:00")).To(Succeed())
+
+			generated := map[GUID]bool{}
+			for index := 0; index < 11; index++ {
+				guid, err := pool.GenerateGUID()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(guid).To(BeNumerically(">", 0x100))
+				Expect(guid).To(BeNumerically("<=", 0x10f))
+				Expect(guid < 0x104 || guid > 0x107).To(BeTrue())
+				Expect(generated).ToNot(HaveKey(guid))
+				generated[guid] = true
+				Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).To(Succeed())
+			}
+
+			_, err = pool.GenerateGUID()
+			Expect(errors.Is(err, ErrPoolExhausted)).To(BeTrue())
+		})
+		It("Generate random guids from the sub-ranges", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "00:00:00:00:00:00:01:00",
+				RangeEnd: "00:00:00:00:00:00:01:0F", AllocationStrategy: config.AllocationStrategyRandom})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AddSubRange(0x10c, 0x10d)).To(Succeed())
+			Expect(pool.AddSubRange(0x104, 0x104)).To(Succeed())
+
+			var generated []GUID
+			for index := 0; index < 3; index++ {
+				guid, err := pool.GenerateGUID()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pool.AllocateGUID(podUID, namespace, network, guid.String())).To(Succeed())
+				generated = append(generated, guid)
+			}
+			Expect(generated).To(ConsistOf(GUID(0x104), GUID(0x10c), GUID(0x10d)))
+		})
+		It("Get the free ranges between the used ranges", func() {
+			used := []guidRange{{start: 0x100, end: 0x101}, {start: 0x104, end: 0x104}, {start: 0x10e, end: 0x120}}
+			Expect(getFreeRanges(guidRange{start: 0x100, end: 0x10f}, used)).To(Equal(
+				[]guidRange{{start: 0x102, end: 0x103}, {start: 0x105, end: 0x10d}}))
+			Expect(getFreeRanges(guidRange{start: 0x0f0, end: 0x0ff}, used)).To(Equal(
+				[]guidRange{{start: 0x0f0, end: 0x0ff}}))
+			Expect(getFreeRanges(guidRange{start: 0x110, end: 0x115}, used)).To(BeEmpty())
+		})
+	})
+	Context("NamespacePrefix", func() {
+		poolConfig := &config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00",
+			RangeEnd: "02:00:00:00:00:00:FF:FF", NamespacePrefix: "6"}
+		It("Create guid pool with invalid namespace prefix", func() {
+			for _, namespacePrefix := range []string{"8", "-1", "byte"} {
+				_, err := NewPool(&config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00",
+					RangeEnd: "02:00:00:00:00:00:FF:FF", NamespacePrefix: namespacePrefix})
+				Expect(err).To(HaveOccurred())
+			}
+		})
+		It("Generate guids with the namespace prefix", func() {
+			pool, err := NewPool(poolConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			// the hash of "default" is 0xde and of "team-b" is 0x43
+			for _, expected := range []string{"02:00:00:00:00:00:de:00", "02:00:00:00:00:00:de:01"} {
+				guid, err := pool.GenerateNamespaceGUID("default")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(guid.String()).To(Equal(expected))
+				Expect(pool.AllocateGUID(podUID, "default", network, guid.String())).To(Succeed())
+				Expect(pool.HasNamespacePrefix(guid, "default")).To(BeTrue())
+				Expect(pool.HasNamespacePrefix(guid, "team-b")).To(BeFalse())
+			}
+
+			guid, err := pool.GenerateNamespaceGUID("team-b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid.String()).To(Equal("02:00:00:00:00:00:43:00"))
+		})
+		It("Generate guids with the namespace prefix in the next block of the range", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:df:00",
+				RangeEnd: "02:00:00:00:00:01:de:00", NamespacePrefix: "6"})
+			Expect(err).ToNot(HaveOccurred())
+
+			guid, err := pool.GenerateNamespaceGUID("default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid.String()).To(Equal("02:00:00:00:00:01:de:00"))
+			Expect(pool.AllocateGUID(podUID, "default", network, guid.String())).To(Succeed())
+
+			_, err = pool.GenerateNamespaceGUID("default")
+			Expect(errors.Is(err, ErrPoolExhausted)).To(BeTrue())
+		})
+		It("Generate guids with the namespace prefix outside the excluded ranges", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00",
+				RangeEnd: "02:00:00:00:00:01:FF:FF", NamespacePrefix: "6", ExcludeRanges: []config.GUIDPoolRangeConfig{
+					{RangeStart: "02:00:00:00:00:00:de:00", RangeEnd: "02:00:00:00:00:01:00:00"}}})
+			Expect(err).ToNot(HaveOccurred())
+
+			guid, err := pool.GenerateNamespaceGUID("default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid.String()).To(Equal("02:00:00:00:00:01:de:00"))
+		})
+		It("Generate guids without namespace prefix", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+
+			guid, err := pool.GenerateNamespaceGUID("default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid.String()).To(Equal("02:00:00:00:00:00:00:00"))
+			Expect(pool.HasNamespacePrefix(guid, "team-b")).To(BeTrue())
+		})
+	})
+	Context("ExtendRange", func() {
+		It("Extend the pool range end", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "00:00:00:00:00:00:01:00",
+				RangeEnd: "00:00:00:00:00:00:01:07"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ExtendRange(8)).To(Succeed())
+			Expect(pool.Stats().Total).To(Equal(uint64(16)))
+			Expect(pool.AllocateGUID(podUID, namespace, network, "00:00:00:00:00:00:01:0F")).To(Succeed())
+		})
+		It("Extend the pool range end beyond the last guid", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "FF:FF:FF:FF:FF:FF:FF:00",
+				RangeEnd: "FF:FF:FF:FF:FF:FF:FF:F0"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ExtendRange(0x0F)).ToNot(Succeed())
+			Expect(pool.ExtendRange(0x10)).ToNot(Succeed())
+			Expect(pool.Stats().Total).To(Equal(uint64(0xF1)))
+		})
+	})
+	Context("ValidateAllocation", func() {
+		smallConf := &config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:03"}
+		DescribeTable("Validate allocation rules",
+			func(setup func(pool Pool), guid string, expectedErr error) {
+				pool, err := NewPool(smallConf)
+				Expect(err).ToNot(HaveOccurred())
+				setup(pool)
+
+				err = pool.ValidateAllocation(podUID, namespace, network, guid)
+				if expectedErr == nil {
+					Expect(err).ToNot(HaveOccurred())
+				} else {
+					Expect(errors.Is(err, expectedErr)).To(BeTrue(), "unexpected error %v", err)
+				}
+			},
+			Entry("free guid", func(pool Pool) {}, "02:00:00:00:00:00:00:01", nil),
+			Entry("guid allocated to the same pod network", func(pool Pool) {
+				Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:01")).To(Succeed())
+			}, "02:00:00:00:00:00:00:01", nil),
+			Entry("out of range guid", func(pool Pool) {}, "02:00:00:00:00:00:01:00", ErrOutOfRange),
+			Entry("guid allocated to other pod", func(pool Pool) {
+				Expect(pool.AllocateGUID("other", namespace, network, "02:00:00:00:00:00:00:01")).To(Succeed())
+			}, "02:00:00:00:00:00:00:01", ErrAllocated),
+			Entry("guid reserved in guid range", func(pool Pool) {
+				_, _, err := pool.AllocateGUIDRange("nad", network, 2)
+				Expect(err).ToNot(HaveOccurred())
+			}, "02:00:00:00:00:00:00:01", ErrReserved),
+			Entry("namespace without remaining quota", func(pool Pool) {
+				pool.SetQuota(namespace, 1)
+				Expect(pool.AllocateGUID("other", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+			}, "02:00:00:00:00:00:00:01", ErrQuotaExceeded),
+			Entry("exhausted pool", func(pool Pool) {
+				_, _, err := pool.AllocateGUIDRange("nad", network, 4)
+				Expect(err).ToNot(HaveOccurred())
+			}, "02:00:00:00:00:00:00:01", ErrPoolExhausted),
+		)
+		It("Validate allocation doesn't allocate the guid", func() {
+			pool, err := NewPool(smallConf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ValidateAllocation(podUID, namespace, network, "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(pool.GetNamespaceUsage(namespace)).To(Equal(0))
+			Expect(pool.ValidateAllocation(podUID, namespace, network, "invalid")).ToNot(Succeed())
+		})
 	})
 	Context("AllocateGUID", func() {
 		It("Allocate guid from the pool", func() {
 			pool, err := NewPool(conf)
 			Expect(err).ToNot(HaveOccurred())
-			err = pool.AllocateGUID("02:00:00:00:00:00:00:00")
+			err = pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:00")
 			Expect(err).ToNot(HaveOccurred())
 		})
 		It("Allocate out of range guid from the pool", func() {
 			pool, err := NewPool(conf)
 			Expect(err).ToNot(HaveOccurred())
-			err = pool.AllocateGUID("55:00:00:00:00:00:00:FF")
+			err = pool.AllocateGUID(podUID, namespace, network, "55:00:00:00:00:00:00:FF")
 			Expect(err).To(HaveOccurred())
 		})
 		It("Allocate an allocated guid from the pool", func() {
 			pool, err := NewPool(conf)
 			Expect(err).ToNot(HaveOccurred())
-			err = pool.AllocateGUID("02:00:00:00:00:00:00:00")
+			err = pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:00")
 			Expect(err).ToNot(HaveOccurred())
-			err = pool.AllocateGUID("02:00:00:00:00:00:00:00")
+			err = pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:00")
 			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrAllocated)).To(BeTrue())
+			err = pool.AllocateGUID("other-pod", namespace, "other-network", "02:00:00:00:00:00:00:00")
+			Expect(errors.Is(err, ErrAllocated)).To(BeTrue())
+		})
+		It("Allocate a guid of a recreated pod which is allocated to the previous pod", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID("previous-pod", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+
+			// the pod is recreated with a new uid while the previous pod deletion was missed
+			err = pool.AllocateGUID("recreated-pod", namespace, network, "02:00:00:00:00:00:00:00")
+			Expect(errors.Is(err, ErrAllocatedToOtherPod)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("previous-pod"))
+
+			_, err = pool.ReleaseGUIDByPodUID("previous-pod")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID("recreated-pod", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
 		})
 		It("Allocate invalid guid from the pool", func() {
-			pool := &guidPool{guidPoolMap: map[GUID]bool{}}
-			err := pool.AllocateGUID("invalid")
+			pool := &guidPool{guidPoolMap: map[GUID]*allocation{}}
+			err := pool.AllocateGUID(podUID, namespace, network, "invalid")
 			Expect(err).To(HaveOccurred())
 		})
 		It("Allocate valid network address but invalid guid from the pool", func() {
 			pool, err := NewPool(conf)
 			Expect(err).ToNot(HaveOccurred())
-			err = pool.AllocateGUID("00:00:00:00:00:00:00:00")
+			err = pool.AllocateGUID(podUID, namespace, network, "00:00:00:00:00:00:00:00")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("ReserveGUID", func() {
+		It("Allocate reserved guid only for the pod it is reserved for", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", "02:00:00:00:00:00:00:00")).To(Succeed())
+
+			err = pool.AllocateGUID("other-pod", namespace, network, "02:00:00:00:00:00:00:00")
+			Expect(errors.Is(err, ErrReserved)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("default/pod-0"))
+			Expect(pool.ValidateAllocation("other-pod", namespace, network, "02:00:00:00:00:00:00:00")).To(
+				MatchError(ErrReserved))
+			Expect(pool.AllocateGUID("pod-0-uid", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+		})
+		It("Allocate reserved guid for the recreated pod of the same name", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", "02:00:00:00:00:00:00:00")).To(Succeed())
+			Expect(pool.AllocateGUID("pod-0-uid", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+			_, err = pool.ReleaseGUIDByPodUID("pod-0-uid")
+			Expect(err).ToNot(HaveOccurred())
+
+			// the released guid isn't generated for other pods until the pod is recreated
+			generated, err := pool.GenerateGUID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(generated.String()).To(Equal("02:00:00:00:00:00:00:01"))
+			Expect(pool.AllocateGUID("pod-0-uid", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+			_, err = pool.ReleaseGUIDByPodUID("pod-0-uid")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(pool.ReserveGUID("pod-0-new-uid", namespace, "pod-0", "02:00:00:00:00:00:00:00")).To(Succeed())
+			err = pool.AllocateGUID("pod-0-uid", namespace, network, "02:00:00:00:00:00:00:00")
+			Expect(errors.Is(err, ErrReserved)).To(BeTrue())
+			Expect(pool.AllocateGUID("pod-0-new-uid", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+		})
+		It("Reserve guid reserved for another pod", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", "02:00:00:00:00:00:00:00")).To(Succeed())
+
+			err = pool.ReserveGUID("pod-1-uid", namespace, "pod-1", "02:00:00:00:00:00:00:00")
+			Expect(errors.Is(err, ErrReservationConflict)).To(BeTrue())
+			err = pool.ReserveGUID("pod-0-uid", "other", "pod-0", "02:00:00:00:00:00:00:00")
+			Expect(errors.Is(err, ErrReservationConflict)).To(BeTrue())
+			Expect(pool.GetReservations()).To(Equal([]Reservation{
+				{GUID: 0x0200000000000000, Namespace: namespace, PodName: "pod-0", PodUID: "pod-0-uid"}}))
+		})
+		It("Reserve out of range or excluded guid", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00",
+				RangeEnd: "02:00:00:00:00:00:00:FF", ExcludeRanges: []config.GUIDPoolRangeConfig{
+					{RangeStart: "02:00:00:00:00:00:00:10", RangeEnd: "02:00:00:00:00:00:00:1F"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", "02:00:00:00:00:00:01:00")).To(
+				MatchError(ErrOutOfRange))
+			Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", "02:00:00:00:00:00:00:10")).ToNot(Succeed())
+			Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", "invalid")).ToNot(Succeed())
+		})
+		It("Generate guids skipping the reserved guids", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00",
+				RangeEnd: "02:00:00:00:00:00:00:03", AllocationStrategy: config.AllocationStrategyRandom})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", "02:00:00:00:00:00:00:01")).To(Succeed())
+
+			var generated []string
+			for i := 0; i < 3; i++ {
+				guidAddr, err := pool.GenerateGUID()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pool.AllocateGUID(podUID, namespace, network, guidAddr.String())).To(Succeed())
+				generated = append(generated, guidAddr.String())
+			}
+			Expect(generated).To(ConsistOf("02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:02",
+				"02:00:00:00:00:00:00:03"))
+			_, err = pool.GenerateGUID()
 			Expect(err).To(HaveOccurred())
 		})
+		It("Drop the reservations of the released namespace", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.ReserveGUID("pod-0-uid", namespace, "pod-0", "02:00:00:00:00:00:00:00")).To(Succeed())
+			Expect(pool.AllocateGUID("pod-0-uid", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+
+			_, err = pool.ReleaseAllGUIDsInNamespace(namespace)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID("other-pod", namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+		})
 	})
 	Context("ReleaseGUID", func() {
 		It("release existing allocated guid", func() {
 			guid := "00:00:00:00:00:00:00:01"
-			pool := &guidPool{guidPoolMap: map[GUID]bool{1: true}}
+			pool := &guidPool{guidPoolMap: map[GUID]*allocation{1: {podUID: podUID, network: network}}}
 
 			err := pool.ReleaseGUID(guid)
 			Expect(err).ToNot(HaveOccurred())
 		})
 		It("release non existing allocated guid", func() {
 			guid := "02:00:00:00:00:00:00:00"
-			pool := &guidPool{guidPoolMap: map[GUID]bool{}}
+			pool := &guidPool{guidPoolMap: map[GUID]*allocation{}}
 
 			err := pool.ReleaseGUID(guid)
 			Expect(err).To(HaveOccurred())
 		})
+		It("count the allocated and released guids", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			allocations := testutil.ToFloat64(metrics.GUIDAllocations)
+			releases := testutil.ToFloat64(metrics.GUIDReleases)
+
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:01")).ToNot(Succeed())
+			Expect(pool.ReleaseGUID("02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(pool.ReleaseGUID("02:00:00:00:00:00:00:01")).ToNot(Succeed())
+
+			Expect(testutil.ToFloat64(metrics.GUIDAllocations)).To(Equal(allocations + 1))
+			Expect(testutil.ToFloat64(metrics.GUIDReleases)).To(Equal(releases + 1))
+		})
+	})
+	Context("ReleaseGUIDByPodUID", func() {
+		It("release all the guids allocated for pod", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, "test", "02:00:00:00:00:00:00:00")).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, "test2", "02:00:00:00:00:00:00:01")).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID("other", namespace, "test", "02:00:00:00:00:00:00:02")).ToNot(HaveOccurred())
+
+			released, err := pool.ReleaseGUIDByPodUID(podUID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(released).To(ConsistOf("02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:01"))
+
+			// guids of other pods are still allocated
+			Expect(pool.AllocateGUID(podUID, namespace, "test", "02:00:00:00:00:00:00:02")).To(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, "test", "02:00:00:00:00:00:00:00")).ToNot(HaveOccurred())
+		})
+		It("release guids of pod without allocated guids", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			released, err := pool.ReleaseGUIDByPodUID(podUID)
+			Expect(err).To(HaveOccurred())
+			Expect(released).To(BeEmpty())
+		})
+	})
+	Context("AllocateGUIDTopologyAware", func() {
+		It("Allocate guids next to the guids of the preferred switch", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+
+			// guids of switches without allocations are generated
+			guidAddr, err := pool.AllocateGUIDTopologyAware(podUID, namespace, network, "switch-1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr.String()).To(Equal("02:00:00:00:00:00:00:00"))
+			guidAddr, err = pool.AllocateGUIDTopologyAware(podUID, namespace, "test2", "switch-2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr.String()).To(Equal("02:00:00:00:00:00:00:01"))
+			Expect(pool.AllocateGUID(podUID, namespace, "test3", "02:00:00:00:00:00:00:02")).To(Succeed())
+
+			guidAddr, err = pool.AllocateGUIDTopologyAware(podUID, namespace, "test4", "switch-2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr.String()).To(Equal("02:00:00:00:00:00:00:03"))
+			guidAddr, err = pool.AllocateGUIDTopologyAware(podUID, namespace, "test5", "switch-2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guidAddr.String()).To(Equal("02:00:00:00:00:00:00:04"))
+
+			Expect(pool.GetAllocations()[3]).To(Equal(Allocation{GUID: 0x0200000000000003, PodUID: podUID,
+				Namespace: namespace, Network: "test4", Switch: "switch-2"}))
+		})
+		It("Allocate guid next to the guids of the preferred switch in exhausted pool", func() {
+			pool, err := NewPool(&config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00",
+				RangeEnd: "02:00:00:00:00:00:00:00"})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = pool.AllocateGUIDTopologyAware(podUID, namespace, network, "switch-1")
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = pool.AllocateGUIDTopologyAware(podUID, namespace, "test2", "switch-1")
+			Expect(errors.Is(err, ErrPoolExhausted)).To(BeTrue())
+		})
+	})
+	Context("ReleaseAllGUIDsInNamespace", func() {
+		It("release all the guids allocated for the namespace pods", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, "test", "02:00:00:00:00:00:00:00")).To(Succeed())
+			Expect(pool.AllocateGUID("other", namespace, "test", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(pool.AllocateGUID("other", "other", "test", "02:00:00:00:00:00:00:02")).To(Succeed())
+			_, _, err = pool.AllocateGUIDRange("nad", "test", 2)
+			Expect(err).ToNot(HaveOccurred())
+
+			released, err := pool.ReleaseAllGUIDsInNamespace(namespace)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(released).To(ConsistOf("02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:01"))
+			Expect(pool.GetNamespaceUsage("other")).To(Equal(1))
+			Expect(pool.Stats().Allocated).To(Equal(uint64(3)))
+		})
+		It("release guids of namespace without allocated guids", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = pool.AllocateGUIDRange("nad", "test", 2)
+			Expect(err).ToNot(HaveOccurred())
+
+			released, err := pool.ReleaseAllGUIDsInNamespace("")
+			Expect(err).To(HaveOccurred())
+			Expect(released).To(BeEmpty())
+		})
+	})
+	Context("GetAllocations", func() {
+		It("Get the allocations with their recorded pKeys", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, "test2", "02:00:00:00:00:00:00:01")).To(Succeed())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:00")).To(Succeed())
+			Expect(pool.SetGUIDPKey("02:00:00:00:00:00:00:01", "0x10")).To(Succeed())
+			Expect(pool.SetGUIDPKey("02:00:00:00:00:00:00:02", "0x10")).ToNot(Succeed())
+
+			Expect(pool.GetAllocations()).To(Equal([]Allocation{
+				{GUID: 0x0200000000000000, PodUID: podUID, Namespace: namespace, Network: network},
+				{GUID: 0x0200000000000001, PodUID: podUID, Namespace: namespace, Network: "test2", PKey: "0x10"}}))
+		})
+	})
+	Context("Serialization", func() {
+		newAllocatedPool := func() Pool {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID(podUID, namespace, network, "02:00:00:00:00:00:00:02")).To(Succeed())
+			Expect(pool.AllocateGUID(podUID, namespace, "test2", "02:00:00:00:00:00:00:01")).To(Succeed())
+			return pool
+		}
+		It("Serialize and restore pool state in json format", func() {
+			data, err := Marshal(newAllocatedPool(), SerializationFormatJSON)
+			Expect(err).ToNot(HaveOccurred())
+
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(Unmarshal(pool, SerializationFormatJSON, data)).To(Succeed())
+			Expect(pool.GetNamespaceUsage(namespace)).To(Equal(2))
+			Expect(pool.ValidateAllocation(podUID, namespace, network, "02:00:00:00:00:00:00:02")).To(Succeed())
+			Expect(pool.ValidateAllocation(podUID, namespace, network, "02:00:00:00:00:00:00:01")).To(
+				MatchError(ErrAllocated))
+		})
+		It("Serialize and restore pool reservations in json format", func() {
+			reservedPool := newAllocatedPool()
+			Expect(reservedPool.ReserveGUID("pod-0-uid", namespace, "pod-0", "02:00:00:00:00:00:00:05")).To(Succeed())
+			data, err := Marshal(reservedPool, SerializationFormatJSON)
+			Expect(err).ToNot(HaveOccurred())
+
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(Unmarshal(pool, SerializationFormatJSON, data)).To(Succeed())
+			Expect(pool.ValidateAllocation(podUID, namespace, network, "02:00:00:00:00:00:00:05")).To(
+				MatchError(ErrReserved))
+			Expect(pool.ValidateAllocation("pod-0-uid", namespace, network, "02:00:00:00:00:00:00:05")).To(Succeed())
+			err = pool.ReserveGUID("pod-1-uid", namespace, "pod-1", "02:00:00:00:00:00:00:05")
+			Expect(errors.Is(err, ErrReservationConflict)).To(BeTrue())
+		})
+		It("Serialize and restore pool state in binary format", func() {
+			data, err := Marshal(newAllocatedPool(), SerializationFormatBinary)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(HaveLen(3*8 + 2*24))
+
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(Unmarshal(pool, SerializationFormatBinary, data)).To(Succeed())
+			Expect(pool.Stats().Allocated).To(Equal(uint64(2)))
+			released, err := pool.ReleaseGUIDByPodUID(podUID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(released).To(ConsistOf("02:00:00:00:00:00:00:01", "02:00:00:00:00:00:00:02"))
+		})
+		It("Restore pool state of another pool range", func() {
+			otherPool, err := NewPool(&config.GUIDPoolConfig{
+				RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:FF"})
+			Expect(err).ToNot(HaveOccurred())
+			data, err := otherPool.MarshalBinary()
+			Expect(err).ToNot(HaveOccurred())
+
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.UnmarshalBinary(data)).ToNot(Succeed())
+		})
+		It("Restore invalid binary pool state", func() {
+			data, err := newAllocatedPool().MarshalBinary()
+			Expect(err).ToNot(HaveOccurred())
+
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.UnmarshalBinary(data[:len(data)-1])).ToNot(Succeed())
+			data[7] = 2
+			Expect(pool.UnmarshalBinary(data)).ToNot(Succeed())
+		})
+		It("Serialize pool state in unknown format", func() {
+			_, err := Marshal(newAllocatedPool(), "xml")
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
@@ -0,0 +1,280 @@
+package guid
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Pool state serialization formats
+const (
+	// SerializationFormatJSON is the readable pool state format, it keeps the allocations namespaces and networks,
+	// and the guids reserved for pod names
+	SerializationFormatJSON = "json"
+	// SerializationFormatBinary is the compact pool state format, it keeps only the allocations pod uids
+	SerializationFormatBinary = "binary"
+)
+
+const (
+	binaryFormatVersion = 1
+	binaryHeaderSize    = 3 * guidLength // version, range start and range end
+	podUIDSize          = 16
+	binaryRecordSize    = guidLength + podUIDSize
+	uuidLength          = 36
+)
+
+// uuidDashes are the indexes of the dashes in the uuid string of a pod uid
+var uuidDashes = []int{8, 13, 18, 23}
+
+// Marshal serializes the pool state in the given format.
+// It returns error if the format is unknown.
+func Marshal(pool Pool, format string) ([]byte, error) {
+	switch format {
+	case SerializationFormatJSON:
+		return json.Marshal(pool)
+	case SerializationFormatBinary:
+		return pool.MarshalBinary()
+	default:
+		return nil, fmt.Errorf("unknown pool serialization format %q", format)
+	}
+}
+
+// Unmarshal restores the pool state serialized in the given format.
+// It returns error if the format is unknown or the data is invalid for the pool.
+func Unmarshal(pool Pool, format string, data []byte) error {
+	switch format {
+	case SerializationFormatJSON:
+		return json.Unmarshal(data, pool)
+	case SerializationFormatBinary:
+		return pool.UnmarshalBinary(data)
+	default:
+		return fmt.Errorf("unknown pool serialization format %q", format)
+	}
+}
+
+type poolState struct {
+	RangeStart  string            `json:"rangeStart"`
+	RangeEnd    string            `json:"rangeEnd"`
+	Allocations []allocationState `json:"allocations"`
+	// Reservations are the guids reserved for pod names, missing in the state of older versions
+	Reservations []reservationState `json:"reservations,omitempty"`
+}
+
+type allocationState struct {
+	GUID      string    `json:"guid"`
+	PodUID    types.UID `json:"podUID"`
+	Namespace string    `json:"namespace,omitempty"`
+	Network   string    `json:"network"`
+	PKey      string    `json:"pKey,omitempty"`
+	Switch    string    `json:"switch,omitempty"`
+}
+
+type reservationState struct {
+	GUID      string    `json:"guid"`
+	Namespace string    `json:"namespace"`
+	PodName   string    `json:"podName"`
+	PodUID    types.UID `json:"podUID"`
+}
+
+// MarshalJSON returns the pool range, its allocations and its reservations sorted by guid
+func (p *guidPool) MarshalJSON() ([]byte, error) {
+	// RaceCheck: reads guidPoolMap and the range
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	state := poolState{RangeStart: p.rangeStart.String(), RangeEnd: p.rangeEnd.String(),
+		Allocations: make([]allocationState, 0, len(p.guidPoolMap))}
+	for _, guid := range p.sortedAllocatedGUIDs() {
+		owner := p.guidPoolMap[guid]
+		state.Allocations = append(state.Allocations, allocationState{
+			GUID: guid.String(), PodUID: owner.podUID, Namespace: owner.namespace, Network: owner.network,
+			PKey: owner.pKey, Switch: owner.switchID})
+	}
+	for _, guid := range p.sortedReservedGUIDs() {
+		reserved := p.reservations[guid]
+		state.Reservations = append(state.Reservations, reservationState{GUID: guid.String(),
+			Namespace: reserved.namespace, PodName: reserved.podName, PodUID: reserved.podUID})
+	}
+
+	return json.Marshal(state)
+}
+
+// UnmarshalJSON replaces the pool allocations and reservations with the serialized allocations and reservations.
+// It returns error if the serialized pool range isn't the pool range.
+func (p *guidPool) UnmarshalJSON(data []byte) error {
+	// RaceCheck: replaces guidPoolMap and reservations
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	state := poolState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse pool state: %v", err)
+	}
+
+	rangeStart, err := ParseGUID(state.RangeStart)
+	if err != nil {
+		return fmt.Errorf("failed to parse pool state range start: %v", err)
+	}
+	rangeEnd, err := ParseGUID(state.RangeEnd)
+	if err != nil {
+		return fmt.Errorf("failed to parse pool state range end: %v", err)
+	}
+	if err = p.checkStateRange(rangeStart, rangeEnd); err != nil {
+		return err
+	}
+
+	guidPoolMap := make(map[GUID]*allocation, len(state.Allocations))
+	for _, allocationState := range state.Allocations {
+		guid, parseErr := p.parseStateGUID(allocationState.GUID)
+		if parseErr != nil {
+			return parseErr
+		}
+		guidPoolMap[guid] = &allocation{podUID: allocationState.PodUID, namespace: allocationState.Namespace,
+			network: allocationState.Network, pKey: allocationState.PKey, switchID: allocationState.Switch}
+	}
+
+	reservations := make(map[GUID]*reservation, len(state.Reservations))
+	for _, reservationState := range state.Reservations {
+		guid, parseErr := p.parseStateGUID(reservationState.GUID)
+		if parseErr != nil {
+			return parseErr
+		}
+		reservations[guid] = &reservation{namespace: reservationState.Namespace, podName: reservationState.PodName,
+			podUID: reservationState.PodUID}
+	}
+
+	p.guidPoolMap = guidPoolMap
+	p.reservations = reservations
+	return nil
+}
+
+// MarshalBinary returns a header of the format version, the pool range start and range end as 8 bytes each,
+// followed by 24 bytes records of the allocations sorted by guid: the 8 bytes guid and the 16 bytes pod uid.
+// Pod uids which aren't uuids are stored as their sha256 hash prefix, which can't be restored.
+func (p *guidPool) MarshalBinary() ([]byte, error) {
+	// RaceCheck: reads guidPoolMap and the range
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	data := make([]byte, binaryHeaderSize, binaryHeaderSize+len(p.guidPoolMap)*binaryRecordSize)
+	binary.BigEndian.PutUint64(data, binaryFormatVersion)
+	binary.BigEndian.PutUint64(data[guidLength:], uint64(p.rangeStart))
+	binary.BigEndian.PutUint64(data[2*guidLength:], uint64(p.rangeEnd))
+
+	record := make([]byte, binaryRecordSize)
+	for _, guid := range p.sortedAllocatedGUIDs() {
+		binary.BigEndian.PutUint64(record, uint64(guid))
+		encodePodUID(record[guidLength:], p.guidPoolMap[guid].podUID)
+		data = append(data, record...)
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary replaces the pool allocations with the serialized allocations, the allocations namespaces and
+// networks aren't serialized so the restored guids aren't counted in the namespaces usage. The pool reservations
+// aren't serialized and are kept.
+// It returns error if the data is invalid or the serialized pool range isn't the pool range.
+func (p *guidPool) UnmarshalBinary(data []byte) error {
+	// RaceCheck: replaces guidPoolMap
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(data) < binaryHeaderSize || (len(data)-binaryHeaderSize)%binaryRecordSize != 0 {
+		return fmt.Errorf("invalid pool state size %d bytes", len(data))
+	}
+
+	if version := binary.BigEndian.Uint64(data); version != binaryFormatVersion {
+		return fmt.Errorf("unsupported pool state version %d", version)
+	}
+
+	rangeStart := GUID(binary.BigEndian.Uint64(data[guidLength:]))
+	rangeEnd := GUID(binary.BigEndian.Uint64(data[2*guidLength:]))
+	if err := p.checkStateRange(rangeStart, rangeEnd); err != nil {
+		return err
+	}
+
+	guidPoolMap := make(map[GUID]*allocation, (len(data)-binaryHeaderSize)/binaryRecordSize)
+	for offset := binaryHeaderSize; offset < len(data); offset += binaryRecordSize {
+		guid := GUID(binary.BigEndian.Uint64(data[offset:]))
+		if guid < p.rangeStart || guid > p.rangeEnd {
+			return fmt.Errorf("pool state guid %s out of pool range", guid)
+		}
+		guidPoolMap[guid] = &allocation{podUID: decodePodUID(data[offset+guidLength : offset+binaryRecordSize])}
+	}
+
+	p.guidPoolMap = guidPoolMap
+	return nil
+}
+
+func (p *guidPool) sortedAllocatedGUIDs() []GUID {
+	guids := make([]GUID, 0, len(p.guidPoolMap))
+	for guid := range p.guidPoolMap {
+		guids = append(guids, guid)
+	}
+	sort.Slice(guids, func(i, j int) bool { return guids[i] < guids[j] })
+	return guids
+}
+
+func (p *guidPool) sortedReservedGUIDs() []GUID {
+	guids := make([]GUID, 0, len(p.reservations))
+	for guid := range p.reservations {
+		guids = append(guids, guid)
+	}
+	sort.Slice(guids, func(i, j int) bool { return guids[i] < guids[j] })
+	return guids
+}
+
+func (p *guidPool) checkStateRange(rangeStart, rangeEnd GUID) error {
+	if rangeStart != p.rangeStart || rangeEnd != p.rangeEnd {
+		return fmt.Errorf("pool state range %s - %s isn't the pool range %s - %s",
+			rangeStart, rangeEnd, p.rangeStart, p.rangeEnd)
+	}
+	return nil
+}
+
+func (p *guidPool) parseStateGUID(guidString string) (GUID, error) {
+	guid, err := ParseGUID(guidString)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse pool state guid: %v", err)
+	}
+	if guid < p.rangeStart || guid > p.rangeEnd {
+		return 0, fmt.Errorf("pool state guid %s out of pool range", guid)
+	}
+	return guid, nil
+}
+
+// encodePodUID writes the 16 bytes of the pod uid uuid, or of its hash if it isn't a uuid
+func encodePodUID(dst []byte, podUID types.UID) {
+	uid := string(podUID)
+	if len(uid) == uuidLength {
+		if uuid, err := hex.DecodeString(strings.Replace(uid, "-", "", -1)); err == nil && len(uuid) == podUIDSize &&
+			isUUIDDashes(uid) {
+			copy(dst, uuid)
+			return
+		}
+	}
+
+	hash := sha256.Sum256([]byte(uid))
+	copy(dst, hash[:podUIDSize])
+}
+
+// decodePodUID returns the uuid string of the 16 bytes pod uid
+func decodePodUID(src []byte) types.UID {
+	uid := []byte(hex.EncodeToString(src))
+	for _, index := range uuidDashes {
+		uid = append(uid[:index], append([]byte{'-'}, uid[index:]...)...)
+	}
+	return types.UID(uid)
+}
+
+func isUUIDDashes(uid string) bool {
+	for _, index := range uuidDashes {
+		if uid[index] != '-' {
+			return false
+		}
+	}
+	return strings.Count(uid, "-") == len(uuidDashes)
+}
@@ -0,0 +1,120 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+)
+
+// Paths of the liveness and readiness probes
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// GUIDPoolPath is the path of the guid pool debug endpoint
+const GUIDPoolPath = "/debug/guidpool"
+
+// readHeaderTimeout limits the time to read the request headers of the probes
+const readHeaderTimeout = 10 * time.Second
+
+// ReadinessChecker checks the daemon is ready
+type ReadinessChecker interface {
+	// Ready returns error if the daemon isn't ready
+	Ready() error
+}
+
+// GUIDPoolDumper returns the guid allocations of the daemon for the debug endpoints
+type GUIDPoolDumper interface {
+	// GUIDAllocations returns the allocated guids of all the guid pools of the daemon
+	GUIDAllocations() []guid.Allocation
+}
+
+// guidAllocation is the json of an allocated guid served by the guid pool debug endpoint
+type guidAllocation struct {
+	GUID      string `json:"guid"`
+	PodUID    string `json:"podUID"`
+	Namespace string `json:"namespace,omitempty"`
+	Network   string `json:"network"`
+	PKey      string `json:"pkey,omitempty"`
+	Switch    string `json:"switch,omitempty"`
+}
+
+type Server interface {
+	// Run serves the probes until the stop channel is closed
+	Run(stopChan <-chan struct{}) error
+}
+
+type server struct {
+	httpServer *http.Server
+}
+
+// NewServer returns a http server of the liveness and readiness probes on the given address, e.g ":8080".
+// The guid pool debug endpoint is served too if the dumper isn't nil.
+func NewServer(address string, checker ReadinessChecker, dumper GUIDPoolDumper) Server {
+	return &server{httpServer: &http.Server{Addr: address, Handler: newHandler(checker, dumper),
+		ReadHeaderTimeout: readHeaderTimeout}}
+}
+
+// newHandler returns the probes handler, the liveness probe always succeeds once the daemon serves it and the
+// readiness probe fails with 503 and the reason while the checker isn't ready
+func newHandler(checker ReadinessChecker, dumper GUIDPoolDumper) http.Handler {
+	mux := http.NewServeMux()
+	if dumper != nil {
+		mux.HandleFunc(GUIDPoolPath, func(w http.ResponseWriter, r *http.Request) {
+			serveGUIDPool(w, dumper)
+		})
+	}
+	mux.HandleFunc(LivenessPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc(ReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		if err := checker.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// serveGUIDPool writes the guid allocations of the dumper as a json array sorted by guid within every pool
+func serveGUIDPool(w http.ResponseWriter, dumper GUIDPoolDumper) {
+	allocations := dumper.GUIDAllocations()
+	guidAllocations := make([]guidAllocation, 0, len(allocations))
+	for _, allocation := range allocations {
+		guidAllocations = append(guidAllocations, guidAllocation{GUID: allocation.GUID.String(),
+			PodUID: string(allocation.PodUID), Namespace: allocation.Namespace, Network: allocation.Network,
+			PKey: allocation.PKey, Switch: allocation.Switch})
+	}
+
+	data, err := json.Marshal(guidAllocations)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to dump guid pool: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+func (s *server) Run(stopChan <-chan struct{}) error {
+	go func() {
+		<-stopChan
+		s.httpServer.Close()
+	}()
+
+	log.Info().Msgf("serving health probes on %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve health probes on %s: %v", s.httpServer.Addr, err)
+	}
+
+	return nil
+}
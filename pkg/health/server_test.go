@@ -0,0 +1,73 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
+)
+
+type fakeChecker struct {
+	err error
+}
+
+func (f *fakeChecker) Ready() error { return f.err }
+
+type fakeDumper struct {
+	allocations []guid.Allocation
+}
+
+func (f *fakeDumper) GUIDAllocations() []guid.Allocation { return f.allocations }
+
+var _ = Describe("Health", func() {
+	serve := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+	Context("Liveness", func() {
+		It("Live while not ready", func() {
+			handler := newHandler(&fakeChecker{err: errors.New("subnet manager unreachable")}, nil)
+			Expect(serve(handler, LivenessPath).Code).To(Equal(http.StatusOK))
+		})
+	})
+	Context("Readiness", func() {
+		It("Ready if the checker is ready", func() {
+			recorder := serve(newHandler(&fakeChecker{}, nil), ReadinessPath)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+		It("Not ready with the checker error", func() {
+			checker := &fakeChecker{err: errors.New("subnet manager unreachable")}
+			recorder := serve(newHandler(checker, nil), ReadinessPath)
+			Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(recorder.Body.String()).To(ContainSubstring("subnet manager unreachable"))
+		})
+	})
+	Context("GUID pool", func() {
+		It("Serve the guid allocations of the dumper", func() {
+			dumper := &fakeDumper{allocations: []guid.Allocation{{GUID: 0x0200000000000001, PodUID: "pod-uid",
+				Namespace: "default", Network: "ib-sriov-network", PKey: "0x10"}}}
+			recorder := serve(newHandler(&fakeChecker{}, dumper), GUIDPoolPath)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			var allocations []map[string]string
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &allocations)).To(Succeed())
+			Expect(allocations).To(Equal([]map[string]string{{"guid": "02:00:00:00:00:00:00:01",
+				"podUID": "pod-uid", "namespace": "default", "network": "ib-sriov-network", "pkey": "0x10"}}))
+		})
+		It("Serve an empty array without allocations", func() {
+			recorder := serve(newHandler(&fakeChecker{}, &fakeDumper{}), GUIDPoolPath)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(Equal("[]"))
+		})
+		It("Don't serve the guid pool without dumper", func() {
+			Expect(serve(newHandler(&fakeChecker{}, nil), GUIDPoolPath).Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -1,6 +1,8 @@
 package ibutils
 
 import (
+	"encoding/hex"
+	"fmt"
 	"net"
 	"strings"
 )
@@ -14,3 +16,14 @@ func IsPKeyValid(pkey int) bool {
 func GUIDToString(guidAddr net.HardwareAddr) string {
 	return strings.Replace(guidAddr.String(), ":", "", -1)
 }
+
+// StringToGUID return HardwareAddr from string guid without separators as returned from GUIDToString
+func StringToGUID(guid string) (net.HardwareAddr, error) {
+	guid = strings.TrimPrefix(strings.ToLower(guid), "0x")
+	guidAddr, err := hex.DecodeString(guid)
+	if err != nil || len(guidAddr) != 8 {
+		return nil, fmt.Errorf("invalid guid %s", guid)
+	}
+
+	return guidAddr, nil
+}
@@ -3,33 +3,130 @@ package k8sclient
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	netapi "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	netclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/typed/k8s.cni.cncf.io/v1" //nolint:lll
 	"github.com/rs/zerolog/log"
+	authv1 "k8s.io/api/authentication/v1"
 	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/client-go/kubernetes"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	ibapi "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
 )
 
 type Client interface {
 	GetPods(namespace string) (*kapi.PodList, error)
+	GetNodePods(nodeName string) (*kapi.PodList, error)
+	GetPod(namespace, name string) (*kapi.Pod, error)
 	SetAnnotationsOnPod(pod *kapi.Pod, annotations map[string]string) error
 	PatchPod(pod *kapi.Pod, patchType types.PatchType, patchData []byte) error
+	CreatePodEvent(pod *kapi.Pod, eventType, reason, message string) error
+	SetPodCondition(pod *kapi.Pod, condition kapi.PodCondition) error
 	GetNetworkAttachmentDefinition(namespace, name string) (*netapi.NetworkAttachmentDefinition, error)
+	GetNetworkAttachmentDefinitions(namespace string) (*netapi.NetworkAttachmentDefinitionList, error)
+	ListNamespacesWithIBNetworks() ([]string, error)
+	SetAnnotationsOnNetworkAttachmentDefinition(netAttDef *netapi.NetworkAttachmentDefinition,
+		annotations map[string]string) error
+	GetConfigMap(namespace, name string) (*kapi.ConfigMap, error)
+	SetConfigMapData(namespace, name string, data map[string]string) error
+	CreateConfigMap(configMap *kapi.ConfigMap) error
+	UpdateConfigMap(configMap *kapi.ConfigMap) error
+	CreateOrUpdateConfigMap(namespace, name string, data map[string]string, ignoreOwnedBy string) error
+	SetAnnotationsOnConfigMap(configMap *kapi.ConfigMap, annotations map[string]string) error
+	GetResourceQuota(namespace string) (*kapi.ResourceQuota, error)
+	GetServiceAccount(namespace, name string) (*kapi.ServiceAccount, error)
+	GetSecret(namespace, name string) (*kapi.Secret, error)
+	GetServiceAccountToken(namespace, name string) (string, error)
 	GetRestClient() rest.Interface
+	GetNetRestClient() rest.Interface
+	GetIBRestClient() rest.Interface
+	GetLeasesClient() coordinationv1.LeasesGetter
+	UpdateGUIDMigrationStatus(migration *ibapi.GUIDMigration) (*ibapi.GUIDMigration, error)
+	GetPKeyReservations() (*ibapi.PKeyReservationList, error)
+	UpdatePKeyReservationStatus(reservation *ibapi.PKeyReservation) (*ibapi.PKeyReservation, error)
+}
+
+// InfiniBandQuotaResources are the resource quota resources which limit InfiniBand guids allocation
+var InfiniBandQuotaResources = []kapi.ResourceName{
+	"requests.rdma/hca",
+	"ib.mellanox.com/guid",
+	"requests.ib.mellanox.com/guid",
+}
+
+// DefaultTokenExpirationSeconds is the default expiration of the requested service account tokens
+const DefaultTokenExpirationSeconds = 3600
+
+// tokenRotationDivisor sets the service account token rotation to the last 1/tokenRotationDivisor of its lifetime
+const tokenRotationDivisor = 5
+
+// DefaultNamespaceCacheTTL is the default duration the namespaces with InfiniBand networks are cached
+const DefaultNamespaceCacheTTL = 5 * time.Minute
+
+// configMapUpdateAttempts is the number of attempts to update a ConfigMap changed concurrently
+const configMapUpdateAttempts = 3
+
+// fieldManager is the field manager of the ConfigMaps applied with server-side apply
+const fieldManager = "ib-kubernetes"
+
+// serverSideApplyVersion is the first kubernetes version with server-side apply enabled
+var serverSideApplyVersion = version.MustParseGeneric("1.18")
+
+// infiniBandSriovCni is the cni type of InfiniBand networks, utils.InfiniBandSriovCni can't be used as utils
+// imports this package
+const infiniBandSriovCni = "ib-sriov"
+
+type serviceAccountToken struct {
+	token    string
+	rotateAt time.Time // time to request a new token before the token expires
 }
 
 type client struct {
-	clientset kubernetes.Interface
-	netClient netclient.K8sCniCncfIoV1Interface
+	clientset              kubernetes.Interface
+	netClient              netclient.K8sCniCncfIoV1Interface
+	ibClient               rest.Interface // rest client of the ib.mellanox.com crds
+	tokenExpirationSeconds int64
+	tokensLock             sync.Mutex
+	tokens                 map[string]*serviceAccountToken // service account tokens mapped by namespace/name
+	namespaceCacheTTL      time.Duration
+	namespacesLock         sync.Mutex
+	namespaces             []string  // cached namespaces with InfiniBand networks
+	namespacesExpireAt     time.Time // time the cached namespaces are listed again
+	serverSideApplyOnce    sync.Once
+	serverSideApply        bool // whether the api server supports server-side apply
 }
 
 // NewK8sClient returns a kubernetes client
 func NewK8sClient() (Client, error) {
+	return NewK8sClientWithTokenExpiration(DefaultTokenExpirationSeconds)
+}
+
+// NewK8sClientWithTokenExpiration returns a kubernetes client which requests service account tokens
+// with the given expiration
+func NewK8sClientWithTokenExpiration(tokenExpirationSeconds int64) (Client, error) {
+	return newK8sClient(tokenExpirationSeconds, DefaultNamespaceCacheTTL)
+}
+
+// NewK8sClientWithNamespaceCacheTTL returns a kubernetes client which caches the namespaces with InfiniBand
+// networks for the given duration, they are listed on every call if the duration is 0
+func NewK8sClientWithNamespaceCacheTTL(namespaceCacheTTL time.Duration) (Client, error) {
+	return newK8sClient(DefaultTokenExpirationSeconds, namespaceCacheTTL)
+}
+
+func newK8sClient(tokenExpirationSeconds int64, namespaceCacheTTL time.Duration) (Client, error) {
 	// Get a config to talk to the api server
 	log.Debug().Msg("Setting up kubernetes client")
 	conf, err := config.GetConfig()
@@ -47,7 +144,32 @@ func NewK8sClient() (Client, error) {
 		return nil, fmt.Errorf("unable to create a network attachment client: %v", err)
 	}
 
-	return &client{clientset: clientset, netClient: netClient}, nil
+	ibClient, err := newIBRestClient(conf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create an InfiniBand resources client: %v", err)
+	}
+
+	return &client{
+		clientset:              clientset,
+		netClient:              netClient,
+		ibClient:               ibClient,
+		tokenExpirationSeconds: tokenExpirationSeconds,
+		tokens:                 map[string]*serviceAccountToken{},
+		namespaceCacheTTL:      namespaceCacheTTL}, nil
+}
+
+// newIBRestClient returns a rest client of the ib.mellanox.com crds
+func newIBRestClient(conf *rest.Config) (rest.Interface, error) {
+	scheme := runtime.NewScheme()
+	if err := ibapi.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	ibConf := *conf
+	ibConf.GroupVersion = &ibapi.SchemeGroupVersion
+	ibConf.APIPath = "/apis"
+	ibConf.NegotiatedSerializer = serializer.NewCodecFactory(scheme).WithoutConversion()
+	return rest.RESTClientFor(&ibConf)
 }
 
 // GetPods obtains the Pods resources from kubernetes api server for given namespace
@@ -56,6 +178,19 @@ func (c *client) GetPods(namespace string) (*kapi.PodList, error) {
 	return c.clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{})
 }
 
+// GetNodePods obtains the Pods resources of all namespaces scheduled on the given node
+func (c *client) GetNodePods(nodeName string) (*kapi.PodList, error) {
+	log.Debug().Msgf("getting pods of node %s", nodeName)
+	return c.clientset.CoreV1().Pods(kapi.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String()})
+}
+
+// GetPod obtains the Pod resource of the given namespace and name
+func (c *client) GetPod(namespace, name string) (*kapi.Pod, error) {
+	log.Debug().Msgf("getting pod namespace %s, name: %s", namespace, name)
+	return c.clientset.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+}
+
 // SetAnnotationsOnPod takes the pod object and map of key/value string pairs to set as annotations
 func (c *client) SetAnnotationsOnPod(pod *kapi.Pod, annotations map[string]string) error {
 	log.Debug().Msgf("Setting annotation on pod, namespace: %s, podName: %s, annotations: %v",
@@ -85,13 +220,409 @@ func (c *client) PatchPod(pod *kapi.Pod, patchType types.PatchType, patchData []
 	return err
 }
 
+// CreatePodEvent creates an event of the given type, e.g Warning, reason and message about the pod
+func (c *client) CreatePodEvent(pod *kapi.Pod, eventType, reason, message string) error {
+	log.Debug().Msgf("creating %s event %s on pod, namespace: %s, podName: %s", eventType, reason,
+		pod.Namespace, pod.Name)
+	now := metav1.Now()
+	event := &kapi.Event{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: pod.Name + ".", Namespace: pod.Namespace},
+		InvolvedObject: kapi.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: pod.Namespace,
+			Name: pod.Name, UID: pod.UID},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         kapi.EventSource{Component: "ib-kubernetes"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err := c.clientset.CoreV1().Events(pod.Namespace).Create(event)
+	return err
+}
+
+// SetPodCondition adds the condition to the pod status or replaces the pod condition of the same type
+func (c *client) SetPodCondition(pod *kapi.Pod, condition kapi.PodCondition) error {
+	log.Debug().Msgf("setting condition %s=%s on pod namespace %s name %s", condition.Type, condition.Status,
+		pod.Namespace, pod.Name)
+	// pod conditions are merged by their type by strategic merge patch
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{"conditions": []kapi.PodCondition{condition}}}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to set condition on pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+
+	_, err = c.clientset.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.StrategicMergePatchType, patchData,
+		"status")
+	return err
+}
+
 // GetNetworkAttachmentDefinition returns the network crd from kubernetes api server for given namespace and name
 func (c *client) GetNetworkAttachmentDefinition(namespace, name string) (*netapi.NetworkAttachmentDefinition, error) {
 	log.Debug().Msgf("getting NetworkAttachmentDefinition namespace %s, name: %s", namespace, name)
 	return c.netClient.NetworkAttachmentDefinitions(namespace).Get(name, metav1.GetOptions{})
 }
 
+// GetNetworkAttachmentDefinitions obtains the network crds from kubernetes api server for given namespace
+func (c *client) GetNetworkAttachmentDefinitions(namespace string) (*netapi.NetworkAttachmentDefinitionList, error) {
+	log.Debug().Msgf("getting NetworkAttachmentDefinitions in namespace %s", namespace)
+	return c.netClient.NetworkAttachmentDefinitions(namespace).List(metav1.ListOptions{})
+}
+
+// ListNamespacesWithIBNetworks returns the sorted namespaces which have at least one InfiniBand network
+// attachment definition, the result is cached for the client namespace cache ttl
+func (c *client) ListNamespacesWithIBNetworks() ([]string, error) {
+	c.namespacesLock.Lock()
+	defer c.namespacesLock.Unlock()
+
+	if c.namespaces != nil && time.Now().Before(c.namespacesExpireAt) {
+		return c.namespaces, nil
+	}
+
+	log.Debug().Msg("listing namespaces with InfiniBand NetworkAttachmentDefinitions")
+	netAttDefs, err := c.netClient.NetworkAttachmentDefinitions(kapi.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NetworkAttachmentDefinitions: %v", err)
+	}
+
+	namespaceSet := map[string]bool{}
+	for index := range netAttDefs.Items {
+		if isInfiniBandNetworkConfig(netAttDefs.Items[index].Spec.Config) {
+			namespaceSet[netAttDefs.Items[index].Namespace] = true
+		}
+	}
+
+	namespaces := make([]string, 0, len(namespaceSet))
+	for namespace := range namespaceSet {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	c.namespaces = namespaces
+	c.namespacesExpireAt = time.Now().Add(c.namespaceCacheTTL)
+	metrics.ManagedNamespaces.Set(float64(len(namespaces)))
+	return namespaces, nil
+}
+
+// isInfiniBandNetworkConfig checks if the cni config or one of its plugins is InfiniBand SR-IOV CNI
+func isInfiniBandNetworkConfig(cniConfig string) bool {
+	netConf := struct {
+		Type    string `json:"type"`
+		Plugins []struct {
+			Type string `json:"type"`
+		} `json:"plugins"`
+	}{}
+	if err := json.Unmarshal([]byte(cniConfig), &netConf); err != nil {
+		return false
+	}
+
+	if netConf.Type == infiniBandSriovCni {
+		return true
+	}
+
+	for _, plugin := range netConf.Plugins {
+		if plugin.Type == infiniBandSriovCni {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetAnnotationsOnNetworkAttachmentDefinition takes the network crd object and map of key/value string pairs
+// to set as annotations
+func (c *client) SetAnnotationsOnNetworkAttachmentDefinition(netAttDef *netapi.NetworkAttachmentDefinition,
+	annotations map[string]string) error {
+	log.Debug().Msgf("Setting annotation on NetworkAttachmentDefinition, namespace: %s, name: %s, annotations: %v",
+		netAttDef.Namespace, netAttDef.Name, annotations)
+	patch := struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}{
+		Metadata: map[string]interface{}{
+			"annotations": annotations,
+		},
+	}
+
+	netAttDefDesc := netAttDef.Namespace + "/" + netAttDef.Name
+	patchData, err := json.Marshal(&patch)
+	if err != nil {
+		return fmt.Errorf("failed to set annotations on NetworkAttachmentDefinition %s: %v", netAttDefDesc, err)
+	}
+
+	_, err = c.netClient.NetworkAttachmentDefinitions(netAttDef.Namespace).Patch(netAttDef.Name,
+		types.MergePatchType, patchData)
+	return err
+}
+
+// GetConfigMap returns the ConfigMap from kubernetes api server for given namespace and name
+func (c *client) GetConfigMap(namespace, name string) (*kapi.ConfigMap, error) {
+	log.Debug().Msgf("getting ConfigMap namespace %s, name: %s", namespace, name)
+	return c.clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+}
+
+// SetConfigMapData replaces the data of the ConfigMap with the given namespace and name, the ConfigMap is created
+// if it doesn't exist
+func (c *client) SetConfigMapData(namespace, name string, data map[string]string) error {
+	log.Debug().Msgf("setting ConfigMap data namespace %s, name: %s", namespace, name)
+	configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if apiErrors.IsNotFound(err) {
+		configMap = &kapi.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Data: data}
+		_, err = c.clientset.CoreV1().ConfigMaps(namespace).Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	configMap.Data = data
+	_, err = c.clientset.CoreV1().ConfigMaps(namespace).Update(configMap)
+	return err
+}
+
+// CreateConfigMap creates the ConfigMap, it returns already exists error if the ConfigMap exists
+func (c *client) CreateConfigMap(configMap *kapi.ConfigMap) error {
+	log.Debug().Msgf("creating ConfigMap namespace %s, name: %s", configMap.Namespace, configMap.Name)
+	_, err := c.clientset.CoreV1().ConfigMaps(configMap.Namespace).Create(configMap)
+	return err
+}
+
+// UpdateConfigMap updates the ConfigMap, it returns conflict error if the ConfigMap was changed since the
+// resource version of the given ConfigMap
+func (c *client) UpdateConfigMap(configMap *kapi.ConfigMap) error {
+	log.Debug().Msgf("updating ConfigMap namespace %s, name: %s", configMap.Namespace, configMap.Name)
+	_, err := c.clientset.CoreV1().ConfigMaps(configMap.Namespace).Update(configMap)
+	return err
+}
+
+// CreateOrUpdateConfigMap sets the data of the ConfigMap with the given namespace and name, the ConfigMap is
+// created if it doesn't exist. The ConfigMap is applied with server-side apply if the api server supports it,
+// otherwise it is updated with its current resource version and the update is retried on conflict.
+// If ignoreOwnedBy isn't empty the update is skipped when the ConfigMap is owned by a pod other than the
+// ignoreOwnedBy pod, so daemon instances don't overwrite each other's state.
+func (c *client) CreateOrUpdateConfigMap(namespace, name string, data map[string]string,
+	ignoreOwnedBy string) error {
+	log.Debug().Msgf("creating or updating ConfigMap namespace %s, name: %s", namespace, name)
+	if c.isServerSideApplySupported() {
+		if ignoreOwnedBy != "" {
+			configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+			if err != nil && !apiErrors.IsNotFound(err) {
+				return err
+			}
+			if err == nil && isOwnedByOtherPod(configMap, ignoreOwnedBy) {
+				return nil
+			}
+		}
+		return c.applyConfigMap(namespace, name, data)
+	}
+
+	configMap := &kapi.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Data: data}
+	_, err := c.clientset.CoreV1().ConfigMaps(namespace).Create(configMap)
+	if !apiErrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	for attempt := 0; attempt < configMapUpdateAttempts; attempt++ {
+		configMap, err = c.clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if ignoreOwnedBy != "" && isOwnedByOtherPod(configMap, ignoreOwnedBy) {
+			return nil
+		}
+
+		configMap.Data = data
+		_, err = c.clientset.CoreV1().ConfigMaps(namespace).Update(configMap)
+		if !apiErrors.IsConflict(err) {
+			return err
+		}
+		log.Debug().Msgf("ConfigMap %s/%s changed concurrently, retrying update", namespace, name)
+	}
+
+	return err
+}
+
+// applyConfigMap sets the data of the ConfigMap with server-side apply, forcing ownership of the data fields
+func (c *client) applyConfigMap(namespace, name string, data map[string]string) error {
+	patchData, err := json.Marshal(&kapi.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       data})
+	if err != nil {
+		return fmt.Errorf("failed to apply ConfigMap %s/%s: %v", namespace, name, err)
+	}
+
+	return c.clientset.CoreV1().RESTClient().Patch(types.ApplyPatchType).Namespace(namespace).
+		Resource("configmaps").Name(name).Param("fieldManager", fieldManager).Param("force", "true").
+		Body(patchData).Do().Error()
+}
+
+// isServerSideApplySupported checks once whether the api server version supports server-side apply
+func (c *client) isServerSideApplySupported() bool {
+	c.serverSideApplyOnce.Do(func() {
+		serverVersion, err := c.clientset.Discovery().ServerVersion()
+		if err != nil {
+			log.Warn().Msgf("failed to get api server version, server-side apply is disabled: %v", err)
+			return
+		}
+
+		parsedVersion, err := version.ParseGeneric(serverVersion.GitVersion)
+		if err != nil {
+			log.Debug().Msgf("failed to parse api server version %s: %v", serverVersion.GitVersion, err)
+			return
+		}
+		c.serverSideApply = parsedVersion.AtLeast(serverSideApplyVersion)
+	})
+	return c.serverSideApply
+}
+
+// isOwnedByOtherPod checks whether the ConfigMap has an owner reference to a pod other than the given pod
+func isOwnedByOtherPod(configMap *kapi.ConfigMap, podName string) bool {
+	for _, owner := range configMap.OwnerReferences {
+		if owner.Kind == "Pod" && owner.Name != podName {
+			log.Warn().Msgf("skipping update of ConfigMap %s/%s owned by pod %s", configMap.Namespace,
+				configMap.Name, owner.Name)
+			return true
+		}
+	}
+	return false
+}
+
+// SetAnnotationsOnConfigMap takes the ConfigMap object and map of key/value string pairs to set as annotations,
+// annotations with empty values are removed
+func (c *client) SetAnnotationsOnConfigMap(configMap *kapi.ConfigMap, annotations map[string]string) error {
+	log.Debug().Msgf("Setting annotation on ConfigMap, namespace: %s, name: %s, annotations: %v",
+		configMap.Namespace, configMap.Name, annotations)
+	patchAnnotations := make(map[string]interface{}, len(annotations))
+	for key, value := range annotations {
+		if value == "" {
+			patchAnnotations[key] = nil
+			continue
+		}
+		patchAnnotations[key] = value
+	}
+	patch := struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}{
+		Metadata: map[string]interface{}{
+			"annotations": patchAnnotations,
+		},
+	}
+
+	configMapDesc := configMap.Namespace + "/" + configMap.Name
+	patchData, err := json.Marshal(&patch)
+	if err != nil {
+		return fmt.Errorf("failed to set annotations on ConfigMap %s: %v", configMapDesc, err)
+	}
+
+	_, err = c.clientset.CoreV1().ConfigMaps(configMap.Namespace).Patch(configMap.Name, types.MergePatchType,
+		patchData)
+	return err
+}
+
+// GetResourceQuota returns the first ResourceQuota of the given namespace which limits InfiniBand resources,
+// or nil if the namespace has no such quota
+func (c *client) GetResourceQuota(namespace string) (*kapi.ResourceQuota, error) {
+	log.Debug().Msgf("getting ResourceQuotas in namespace %s", namespace)
+	quotas, err := c.clientset.CoreV1().ResourceQuotas(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for index := range quotas.Items {
+		for _, resource := range InfiniBandQuotaResources {
+			if _, ok := quotas.Items[index].Spec.Hard[resource]; ok {
+				return &quotas.Items[index], nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// GetServiceAccount returns the ServiceAccount from kubernetes api server for given namespace and name
+func (c *client) GetServiceAccount(namespace, name string) (*kapi.ServiceAccount, error) {
+	log.Debug().Msgf("getting ServiceAccount namespace %s, name: %s", namespace, name)
+	return c.clientset.CoreV1().ServiceAccounts(namespace).Get(name, metav1.GetOptions{})
+}
+
+// GetSecret returns the Secret from kubernetes api server for given namespace and name
+func (c *client) GetSecret(namespace, name string) (*kapi.Secret, error) {
+	log.Debug().Msgf("getting Secret namespace %s, name: %s", namespace, name)
+	return c.clientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+}
+
+// GetServiceAccountToken returns a token of the ServiceAccount for given namespace and name.
+// The token is cached and a new token is requested before the cached token expires.
+func (c *client) GetServiceAccountToken(namespace, name string) (string, error) {
+	c.tokensLock.Lock()
+	defer c.tokensLock.Unlock()
+
+	key := namespace + "/" + name
+	if cached, ok := c.tokens[key]; ok && time.Now().Before(cached.rotateAt) {
+		return cached.token, nil
+	}
+
+	log.Debug().Msgf("requesting token of ServiceAccount namespace %s, name: %s", namespace, name)
+	expirationSeconds := c.tokenExpirationSeconds
+	tokenRequest := &authv1.TokenRequest{Spec: authv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds}}
+	tokenRequest, err := c.clientset.CoreV1().ServiceAccounts(namespace).CreateToken(name, tokenRequest)
+	if err != nil {
+		return "", fmt.Errorf("failed to request token of ServiceAccount %s: %v", key, err)
+	}
+
+	expiresAt := tokenRequest.Status.ExpirationTimestamp.Time
+	lifetime := time.Until(expiresAt)
+	c.tokens[key] = &serviceAccountToken{
+		token:    tokenRequest.Status.Token,
+		rotateAt: expiresAt.Add(-lifetime / tokenRotationDivisor),
+	}
+
+	return tokenRequest.Status.Token, nil
+}
+
 // GetRestClient returns the client rest api for k8s
 func (c *client) GetRestClient() rest.Interface {
 	return c.clientset.CoreV1().RESTClient()
 }
+
+// GetNetRestClient returns the client rest api for the network attachment definition crds
+func (c *client) GetNetRestClient() rest.Interface {
+	return c.netClient.RESTClient()
+}
+
+// GetIBRestClient returns the client rest api for the ib.mellanox.com crds
+func (c *client) GetIBRestClient() rest.Interface {
+	return c.ibClient
+}
+
+// GetLeasesClient returns the client of the coordination leases, e.g of the leader election
+func (c *client) GetLeasesClient() coordinationv1.LeasesGetter {
+	return c.clientset.CoordinationV1()
+}
+
+// UpdateGUIDMigrationStatus updates the status of the guid migration and returns the updated guid migration
+func (c *client) UpdateGUIDMigrationStatus(migration *ibapi.GUIDMigration) (*ibapi.GUIDMigration, error) {
+	log.Debug().Msgf("updating guid migration namespace %s name %s status to %+v", migration.Namespace,
+		migration.Name, migration.Status)
+	updated := &ibapi.GUIDMigration{}
+	err := c.ibClient.Put().Namespace(migration.Namespace).Resource(ibapi.GUIDMigrationsResource).
+		Name(migration.Name).SubResource("status").Body(migration).Do().Into(updated)
+	return updated, err
+}
+
+// GetPKeyReservations returns the pKey reservations of all the namespaces
+func (c *client) GetPKeyReservations() (*ibapi.PKeyReservationList, error) {
+	reservations := &ibapi.PKeyReservationList{}
+	err := c.ibClient.Get().Resource(ibapi.PKeyReservationsResource).Do().Into(reservations)
+	return reservations, err
+}
+
+// UpdatePKeyReservationStatus updates the status of the pKey reservation and returns the updated pKey reservation
+func (c *client) UpdatePKeyReservationStatus(reservation *ibapi.PKeyReservation) (*ibapi.PKeyReservation, error) {
+	log.Debug().Msgf("updating pKey reservation %s status to %+v", reservation.Name, reservation.Status)
+	updated := &ibapi.PKeyReservation{}
+	err := c.ibClient.Put().Resource(ibapi.PKeyReservationsResource).Name(reservation.Name).SubResource("status").
+		Body(reservation).Do().Into(updated)
+	return updated, err
+}
@@ -0,0 +1,124 @@
+package k8sclient
+
+import (
+	"errors"
+	"time"
+
+	netapi "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	netfake "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Client", func() {
+	netAttDef := func(namespace, name, config string) *netapi.NetworkAttachmentDefinition {
+		return &netapi.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       netapi.NetworkAttachmentDefinitionSpec{Config: config}}
+	}
+	Context("ListNamespacesWithIBNetworks", func() {
+		var netClientset *netfake.Clientset
+		BeforeEach(func() {
+			// objects passed to NewSimpleClientset are tracked under a guessed resource name which the typed
+			// client doesn't list, the network attachment definitions are created instead
+			netClientset = netfake.NewSimpleClientset()
+			for _, networkAttachmentDef := range []*netapi.NetworkAttachmentDefinition{
+				netAttDef("foo", "ib", `{"type": "ib-sriov", "pkey": "0x10"}`),
+				netAttDef("foo", "ib2", `{"type": "ib-sriov", "pkey": "0x20"}`),
+				netAttDef("bar", "chained", `{"plugins": [{"type": "ib-sriov"}, {"type": "tuning"}]}`),
+				netAttDef("baz", "bridge", `{"type": "bridge"}`),
+			} {
+				_, err := netClientset.K8sCniCncfIoV1().NetworkAttachmentDefinitions(networkAttachmentDef.Namespace).
+					Create(networkAttachmentDef)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+		It("List the namespaces with InfiniBand networks", func() {
+			c := &client{netClient: netClientset.K8sCniCncfIoV1(), namespaceCacheTTL: time.Minute}
+			namespaces, err := c.ListNamespacesWithIBNetworks()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(namespaces).To(Equal([]string{"bar", "foo"}))
+		})
+		It("Cache the namespaces for the namespace cache ttl", func() {
+			c := &client{netClient: netClientset.K8sCniCncfIoV1(), namespaceCacheTTL: time.Minute}
+			_, err := c.ListNamespacesWithIBNetworks()
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = netClientset.K8sCniCncfIoV1().NetworkAttachmentDefinitions("qux").Create(
+				netAttDef("qux", "ib", `{"type": "ib-sriov"}`))
+			Expect(err).ToNot(HaveOccurred())
+			namespaces, err := c.ListNamespacesWithIBNetworks()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(namespaces).To(Equal([]string{"bar", "foo"}))
+
+			c.namespacesExpireAt = time.Now()
+			namespaces, err = c.ListNamespacesWithIBNetworks()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(namespaces).To(Equal([]string{"bar", "foo", "qux"}))
+		})
+		It("List the namespaces on every call without cache ttl", func() {
+			c := &client{netClient: netClientset.K8sCniCncfIoV1()}
+			_, err := c.ListNamespacesWithIBNetworks()
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = netClientset.K8sCniCncfIoV1().NetworkAttachmentDefinitions("qux").Create(
+				netAttDef("qux", "ib", `{"type": "ib-sriov"}`))
+			Expect(err).ToNot(HaveOccurred())
+			namespaces, err := c.ListNamespacesWithIBNetworks()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(namespaces).To(Equal([]string{"bar", "foo", "qux"}))
+		})
+	})
+	Context("CreateOrUpdateConfigMap", func() {
+		configMap := func(data map[string]string, owners ...metav1.OwnerReference) *kapi.ConfigMap {
+			return &kapi.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "state",
+				OwnerReferences: owners}, Data: data}
+		}
+		getData := func(clientset *fake.Clientset) map[string]string {
+			current, err := clientset.CoreV1().ConfigMaps("kube-system").Get("state", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			return current.Data
+		}
+		It("Create the config map if it doesn't exist", func() {
+			clientset := fake.NewSimpleClientset()
+			c := &client{clientset: clientset}
+			Expect(c.CreateOrUpdateConfigMap("kube-system", "state", map[string]string{"a": "1"}, "")).To(Succeed())
+			Expect(getData(clientset)).To(Equal(map[string]string{"a": "1"}))
+		})
+		It("Update the existing config map and retry on conflict", func() {
+			clientset := fake.NewSimpleClientset(configMap(map[string]string{"a": "1"}))
+			conflicts := 0
+			clientset.PrependReactor("update", "configmaps", func(action k8sTesting.Action) (
+				bool, runtime.Object, error) {
+				if conflicts > 0 {
+					return false, nil, nil
+				}
+				conflicts++
+				return true, nil, apiErrors.NewConflict(kapi.Resource("configmaps"), "state",
+					errors.New("changed"))
+			})
+			c := &client{clientset: clientset}
+			Expect(c.CreateOrUpdateConfigMap("kube-system", "state", map[string]string{"b": "2"}, "")).To(Succeed())
+			Expect(conflicts).To(Equal(1))
+			Expect(getData(clientset)).To(Equal(map[string]string{"b": "2"}))
+		})
+		It("Skip update of config map owned by another pod", func() {
+			clientset := fake.NewSimpleClientset(configMap(map[string]string{"a": "1"},
+				metav1.OwnerReference{Kind: "Pod", Name: "ib-kubernetes-other"}))
+			c := &client{clientset: clientset}
+			Expect(c.CreateOrUpdateConfigMap("kube-system", "state", map[string]string{"b": "2"},
+				"ib-kubernetes-self")).To(Succeed())
+			Expect(getData(clientset)).To(Equal(map[string]string{"a": "1"}))
+
+			Expect(c.CreateOrUpdateConfigMap("kube-system", "state", map[string]string{"b": "2"},
+				"ib-kubernetes-other")).To(Succeed())
+			Expect(getData(clientset)).To(Equal(map[string]string{"b": "2"}))
+		})
+	})
+})
@@ -0,0 +1,105 @@
+package k8sclient
+
+import (
+	"sort"
+
+	netapi "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ibapi "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
+)
+
+// dryRunClient logs the changes of the kubernetes resources instead of applying them, the reads are served by the
+// api server
+type dryRunClient struct {
+	Client
+}
+
+// NewDryRunClient returns kubernetes client which logs the annotations, events, conditions, config maps and statuses
+// it would set without changing the resources
+func NewDryRunClient(client Client) Client {
+	return &dryRunClient{Client: client}
+}
+
+func (c *dryRunClient) SetAnnotationsOnPod(pod *kapi.Pod, annotations map[string]string) error {
+	log.Info().Msgf("dry run: would set annotations %v on pod namespace %s name %s", annotations, pod.Namespace,
+		pod.Name)
+	return nil
+}
+
+func (c *dryRunClient) PatchPod(pod *kapi.Pod, patchType types.PatchType, patchData []byte) error {
+	log.Info().Msgf("dry run: would patch pod namespace %s name %s with %s", pod.Namespace, pod.Name, patchData)
+	return nil
+}
+
+func (c *dryRunClient) CreatePodEvent(pod *kapi.Pod, eventType, reason, message string) error {
+	log.Info().Msgf("dry run: would create %s event %s of pod namespace %s name %s: %s", eventType, reason,
+		pod.Namespace, pod.Name, message)
+	return nil
+}
+
+func (c *dryRunClient) SetPodCondition(pod *kapi.Pod, condition kapi.PodCondition) error {
+	log.Info().Msgf("dry run: would set condition %s to %s on pod namespace %s name %s", condition.Type,
+		condition.Status, pod.Namespace, pod.Name)
+	return nil
+}
+
+func (c *dryRunClient) SetAnnotationsOnNetworkAttachmentDefinition(netAttDef *netapi.NetworkAttachmentDefinition,
+	annotations map[string]string) error {
+	log.Info().Msgf("dry run: would set annotations %v on network attachment definition namespace %s name %s",
+		annotations, netAttDef.Namespace, netAttDef.Name)
+	return nil
+}
+
+func (c *dryRunClient) SetConfigMapData(namespace, name string, data map[string]string) error {
+	log.Info().Msgf("dry run: would set data keys %v of ConfigMap namespace %s name %s", dataKeys(data),
+		namespace, name)
+	return nil
+}
+
+func (c *dryRunClient) CreateConfigMap(configMap *kapi.ConfigMap) error {
+	log.Info().Msgf("dry run: would create ConfigMap namespace %s name %s", configMap.Namespace, configMap.Name)
+	return nil
+}
+
+func (c *dryRunClient) UpdateConfigMap(configMap *kapi.ConfigMap) error {
+	log.Info().Msgf("dry run: would update ConfigMap namespace %s name %s", configMap.Namespace, configMap.Name)
+	return nil
+}
+
+func (c *dryRunClient) CreateOrUpdateConfigMap(namespace, name string, data map[string]string,
+	ignoreOwnedBy string) error {
+	log.Info().Msgf("dry run: would set data keys %v of ConfigMap namespace %s name %s", dataKeys(data),
+		namespace, name)
+	return nil
+}
+
+func (c *dryRunClient) SetAnnotationsOnConfigMap(configMap *kapi.ConfigMap, annotations map[string]string) error {
+	log.Info().Msgf("dry run: would set annotations %v on ConfigMap namespace %s name %s", annotations,
+		configMap.Namespace, configMap.Name)
+	return nil
+}
+
+func (c *dryRunClient) UpdateGUIDMigrationStatus(migration *ibapi.GUIDMigration) (*ibapi.GUIDMigration, error) {
+	log.Info().Msgf("dry run: would update status of guid migration namespace %s name %s to phase %s",
+		migration.Namespace, migration.Name, migration.Status.Phase)
+	return migration, nil
+}
+
+func (c *dryRunClient) UpdatePKeyReservationStatus(reservation *ibapi.PKeyReservation) (
+	*ibapi.PKeyReservation, error) {
+	log.Info().Msgf("dry run: would update status of pKey reservation %s", reservation.Name)
+	return reservation, nil
+}
+
+// dataKeys returns the sorted keys of the config map data, the values may be large
+func dataKeys(data map[string]string) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
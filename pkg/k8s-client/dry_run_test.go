@@ -0,0 +1,26 @@
+package k8sclient
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Dry run client", func() {
+	It("Skip the resources changes and serve the reads", func() {
+		pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"}}
+		c := NewDryRunClient(&client{clientset: fake.NewSimpleClientset(pod.DeepCopy())})
+
+		Expect(c.SetAnnotationsOnPod(pod, map[string]string{"a": "1"})).To(Succeed())
+		Expect(c.SetConfigMapData("kube-system", "state", map[string]string{"a": "1"})).To(Succeed())
+
+		current, err := c.GetPod("default", "pod")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(current.Annotations).To(BeEmpty())
+		_, err = c.GetConfigMap("kube-system", "state")
+		Expect(apiErrors.IsNotFound(err)).To(BeTrue())
+	})
+})
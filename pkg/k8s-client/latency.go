@@ -0,0 +1,276 @@
+package k8sclient
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	netapi "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/rest"
+
+	ibapi "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
+)
+
+// ErrSimulatedAPIError is the error injected by the latency simulating client
+var ErrSimulatedAPIError = errors.New("simulated api error")
+
+// SimulatedLatency is the gaussian distribution of the simulated api calls latency
+type SimulatedLatency struct {
+	Mean   time.Duration
+	StdDev time.Duration
+}
+
+// ParseSimulatedLatency parses simulated latency of "mean=<duration>,stddev=<duration>" format,
+// e.g "mean=5ms,stddev=2ms"
+func ParseSimulatedLatency(value string) (*SimulatedLatency, error) {
+	latency := &SimulatedLatency{}
+	for _, pair := range strings.Split(value, ",") {
+		keyValue := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid pair %q, should be <key>=<duration>", pair)
+		}
+
+		duration, err := time.ParseDuration(keyValue[1])
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid duration of pair %q", pair)
+		}
+
+		switch keyValue[0] {
+		case "mean":
+			latency.Mean = duration
+		case "stddev":
+			latency.StdDev = duration
+		default:
+			return nil, fmt.Errorf("unknown key of pair %q, should be mean or stddev", pair)
+		}
+	}
+
+	return latency, nil
+}
+
+type latencySimulatingClient struct {
+	client    Client
+	latency   SimulatedLatency
+	errorRate float64
+}
+
+// NewLatencySimulatingClient returns a client for performance testing which delays every api call of the given client
+// by the simulated latency, and fails the calls at the given error rate between 0 and 1 without calling the client
+func NewLatencySimulatingClient(client Client, latency SimulatedLatency, errorRate float64) Client {
+	return &latencySimulatingClient{client: client, latency: latency, errorRate: errorRate}
+}
+
+// simulate sleeps for the simulated latency and returns error at the configured error rate
+func (c *latencySimulatingClient) simulate() error {
+	delay := c.latency.Mean + time.Duration(rand.NormFloat64()*float64(c.latency.StdDev)) //nolint:gosec
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	if c.errorRate > 0 && rand.Float64() < c.errorRate { //nolint:gosec
+		return ErrSimulatedAPIError
+	}
+
+	return nil
+}
+
+func (c *latencySimulatingClient) GetPods(namespace string) (*kapi.PodList, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetPods(namespace)
+}
+
+func (c *latencySimulatingClient) GetNodePods(nodeName string) (*kapi.PodList, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetNodePods(nodeName)
+}
+
+func (c *latencySimulatingClient) GetPod(namespace, name string) (*kapi.Pod, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetPod(namespace, name)
+}
+
+func (c *latencySimulatingClient) SetAnnotationsOnPod(pod *kapi.Pod, annotations map[string]string) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.SetAnnotationsOnPod(pod, annotations)
+}
+
+func (c *latencySimulatingClient) PatchPod(pod *kapi.Pod, patchType types.PatchType, patchData []byte) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.PatchPod(pod, patchType, patchData)
+}
+
+func (c *latencySimulatingClient) CreatePodEvent(pod *kapi.Pod, eventType, reason, message string) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.CreatePodEvent(pod, eventType, reason, message)
+}
+
+func (c *latencySimulatingClient) SetPodCondition(pod *kapi.Pod, condition kapi.PodCondition) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.SetPodCondition(pod, condition)
+}
+
+func (c *latencySimulatingClient) GetNetworkAttachmentDefinition(namespace, name string) (
+	*netapi.NetworkAttachmentDefinition, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetNetworkAttachmentDefinition(namespace, name)
+}
+
+func (c *latencySimulatingClient) GetNetworkAttachmentDefinitions(namespace string) (
+	*netapi.NetworkAttachmentDefinitionList, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetNetworkAttachmentDefinitions(namespace)
+}
+
+func (c *latencySimulatingClient) ListNamespacesWithIBNetworks() ([]string, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.ListNamespacesWithIBNetworks()
+}
+
+func (c *latencySimulatingClient) SetAnnotationsOnNetworkAttachmentDefinition(
+	netAttDef *netapi.NetworkAttachmentDefinition, annotations map[string]string) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.SetAnnotationsOnNetworkAttachmentDefinition(netAttDef, annotations)
+}
+
+func (c *latencySimulatingClient) GetConfigMap(namespace, name string) (*kapi.ConfigMap, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetConfigMap(namespace, name)
+}
+
+func (c *latencySimulatingClient) SetConfigMapData(namespace, name string, data map[string]string) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.SetConfigMapData(namespace, name, data)
+}
+
+func (c *latencySimulatingClient) CreateConfigMap(configMap *kapi.ConfigMap) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.CreateConfigMap(configMap)
+}
+
+func (c *latencySimulatingClient) UpdateConfigMap(configMap *kapi.ConfigMap) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.UpdateConfigMap(configMap)
+}
+
+func (c *latencySimulatingClient) CreateOrUpdateConfigMap(namespace, name string, data map[string]string,
+	ignoreOwnedBy string) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.CreateOrUpdateConfigMap(namespace, name, data, ignoreOwnedBy)
+}
+
+func (c *latencySimulatingClient) SetAnnotationsOnConfigMap(configMap *kapi.ConfigMap,
+	annotations map[string]string) error {
+	if err := c.simulate(); err != nil {
+		return err
+	}
+	return c.client.SetAnnotationsOnConfigMap(configMap, annotations)
+}
+
+func (c *latencySimulatingClient) GetResourceQuota(namespace string) (*kapi.ResourceQuota, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetResourceQuota(namespace)
+}
+
+func (c *latencySimulatingClient) GetServiceAccount(namespace, name string) (*kapi.ServiceAccount, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetServiceAccount(namespace, name)
+}
+
+func (c *latencySimulatingClient) GetSecret(namespace, name string) (*kapi.Secret, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetSecret(namespace, name)
+}
+
+func (c *latencySimulatingClient) GetServiceAccountToken(namespace, name string) (string, error) {
+	if err := c.simulate(); err != nil {
+		return "", err
+	}
+	return c.client.GetServiceAccountToken(namespace, name)
+}
+
+func (c *latencySimulatingClient) UpdateGUIDMigrationStatus(migration *ibapi.GUIDMigration) (
+	*ibapi.GUIDMigration, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.UpdateGUIDMigrationStatus(migration)
+}
+
+func (c *latencySimulatingClient) GetPKeyReservations() (*ibapi.PKeyReservationList, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.GetPKeyReservations()
+}
+
+func (c *latencySimulatingClient) UpdatePKeyReservationStatus(reservation *ibapi.PKeyReservation) (
+	*ibapi.PKeyReservation, error) {
+	if err := c.simulate(); err != nil {
+		return nil, err
+	}
+	return c.client.UpdatePKeyReservationStatus(reservation)
+}
+
+// GetRestClient returns the rest client of the wrapped client, the watchers calls aren't delayed
+func (c *latencySimulatingClient) GetRestClient() rest.Interface {
+	return c.client.GetRestClient()
+}
+
+// GetNetRestClient returns the net rest client of the wrapped client, the watchers calls aren't delayed
+func (c *latencySimulatingClient) GetNetRestClient() rest.Interface {
+	return c.client.GetNetRestClient()
+}
+
+// GetIBRestClient returns the InfiniBand resources rest client of the wrapped client, the watchers calls aren't
+// delayed
+func (c *latencySimulatingClient) GetIBRestClient() rest.Interface {
+	return c.client.GetIBRestClient()
+}
+
+// GetLeasesClient returns the leases client of the wrapped client, the leader election calls aren't delayed
+func (c *latencySimulatingClient) GetLeasesClient() coordinationv1.LeasesGetter {
+	return c.client.GetLeasesClient()
+}
@@ -0,0 +1,50 @@
+package k8sclient
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+
+	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
+)
+
+var _ = Describe("Latency Simulating Client", func() {
+	Context("ParseSimulatedLatency", func() {
+		It("Parse mean and stddev", func() {
+			latency, err := ParseSimulatedLatency("mean=5ms,stddev=2ms")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(latency.Mean).To(Equal(5 * time.Millisecond))
+			Expect(latency.StdDev).To(Equal(2 * time.Millisecond))
+		})
+		It("Parse invalid latency", func() {
+			for _, value := range []string{"5ms", "mean=5", "mean=-5ms", "median=5ms"} {
+				_, err := ParseSimulatedLatency(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+	})
+	Context("Simulate api calls", func() {
+		It("Delay api calls by the simulated latency", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetPods", "default").Return(&kapi.PodList{}, nil)
+			latencyClient := NewLatencySimulatingClient(client,
+				SimulatedLatency{Mean: 20 * time.Millisecond}, 0)
+
+			start := time.Now()
+			pods, err := latencyClient.GetPods("default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pods).ToNot(BeNil())
+			Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond))
+		})
+		It("Inject errors at the error rate", func() {
+			client := &k8sClientMock.Client{}
+			latencyClient := NewLatencySimulatingClient(client, SimulatedLatency{}, 1)
+
+			_, err := latencyClient.GetPods("default")
+			Expect(err).To(Equal(ErrSimulatedAPIError))
+			client.AssertNotCalled(GinkgoT(), "GetPods", "default")
+		})
+	})
+})
@@ -2,18 +2,133 @@
 
 package mocks
 
+import coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
 import corev1 "k8s.io/api/core/v1"
 
 import mock "github.com/stretchr/testify/mock"
 import rest "k8s.io/client-go/rest"
 import types "k8s.io/apimachinery/pkg/types"
 import v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+import v1alpha1 "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
 
 // Client is an autogenerated mock type for the Client type
 type Client struct {
 	mock.Mock
 }
 
+// CreateConfigMap provides a mock function with given fields: configMap
+func (_m *Client) CreateConfigMap(configMap *corev1.ConfigMap) error {
+	ret := _m.Called(configMap)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*corev1.ConfigMap) error); ok {
+		r0 = rf(configMap)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateOrUpdateConfigMap provides a mock function with given fields: namespace, name, data, ignoreOwnedBy
+func (_m *Client) CreateOrUpdateConfigMap(namespace string, name string, data map[string]string, ignoreOwnedBy string) error {
+	ret := _m.Called(namespace, name, data, ignoreOwnedBy)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, map[string]string, string) error); ok {
+		r0 = rf(namespace, name, data, ignoreOwnedBy)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreatePodEvent provides a mock function with given fields: pod, eventType, reason, message
+func (_m *Client) CreatePodEvent(pod *corev1.Pod, eventType string, reason string, message string) error {
+	ret := _m.Called(pod, eventType, reason, message)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*corev1.Pod, string, string, string) error); ok {
+		r0 = rf(pod, eventType, reason, message)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetConfigMap provides a mock function with given fields: namespace, name
+func (_m *Client) GetConfigMap(namespace string, name string) (*corev1.ConfigMap, error) {
+	ret := _m.Called(namespace, name)
+
+	var r0 *corev1.ConfigMap
+	if rf, ok := ret.Get(0).(func(string, string) *corev1.ConfigMap); ok {
+		r0 = rf(namespace, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*corev1.ConfigMap)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(namespace, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetIBRestClient provides a mock function with given fields:
+func (_m *Client) GetIBRestClient() rest.Interface {
+	ret := _m.Called()
+
+	var r0 rest.Interface
+	if rf, ok := ret.Get(0).(func() rest.Interface); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(rest.Interface)
+		}
+	}
+
+	return r0
+}
+
+// GetLeasesClient provides a mock function with given fields:
+func (_m *Client) GetLeasesClient() coordinationv1.LeasesGetter {
+	ret := _m.Called()
+
+	var r0 coordinationv1.LeasesGetter
+	if rf, ok := ret.Get(0).(func() coordinationv1.LeasesGetter); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(coordinationv1.LeasesGetter)
+		}
+	}
+
+	return r0
+}
+
+// GetNetRestClient provides a mock function with given fields:
+func (_m *Client) GetNetRestClient() rest.Interface {
+	ret := _m.Called()
+
+	var r0 rest.Interface
+	if rf, ok := ret.Get(0).(func() rest.Interface); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(rest.Interface)
+		}
+	}
+
+	return r0
+}
+
 // GetNetworkAttachmentDefinition provides a mock function with given fields: namespace, name
 func (_m *Client) GetNetworkAttachmentDefinition(namespace string, name string) (*v1.NetworkAttachmentDefinition, error) {
 	ret := _m.Called(namespace, name)
@@ -37,6 +152,98 @@ func (_m *Client) GetNetworkAttachmentDefinition(namespace string, name string)
 	return r0, r1
 }
 
+// GetNetworkAttachmentDefinitions provides a mock function with given fields: namespace
+func (_m *Client) GetNetworkAttachmentDefinitions(namespace string) (*v1.NetworkAttachmentDefinitionList, error) {
+	ret := _m.Called(namespace)
+
+	var r0 *v1.NetworkAttachmentDefinitionList
+	if rf, ok := ret.Get(0).(func(string) *v1.NetworkAttachmentDefinitionList); ok {
+		r0 = rf(namespace)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*v1.NetworkAttachmentDefinitionList)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(namespace)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetNodePods provides a mock function with given fields: nodeName
+func (_m *Client) GetNodePods(nodeName string) (*corev1.PodList, error) {
+	ret := _m.Called(nodeName)
+
+	var r0 *corev1.PodList
+	if rf, ok := ret.Get(0).(func(string) *corev1.PodList); ok {
+		r0 = rf(nodeName)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*corev1.PodList)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(nodeName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPKeyReservations provides a mock function with given fields:
+func (_m *Client) GetPKeyReservations() (*v1alpha1.PKeyReservationList, error) {
+	ret := _m.Called()
+
+	var r0 *v1alpha1.PKeyReservationList
+	if rf, ok := ret.Get(0).(func() *v1alpha1.PKeyReservationList); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*v1alpha1.PKeyReservationList)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPod provides a mock function with given fields: namespace, name
+func (_m *Client) GetPod(namespace string, name string) (*corev1.Pod, error) {
+	ret := _m.Called(namespace, name)
+
+	var r0 *corev1.Pod
+	if rf, ok := ret.Get(0).(func(string, string) *corev1.Pod); ok {
+		r0 = rf(namespace, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*corev1.Pod)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(namespace, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetPods provides a mock function with given fields: namespace
 func (_m *Client) GetPods(namespace string) (*corev1.PodList, error) {
 	ret := _m.Called(namespace)
@@ -60,6 +267,29 @@ func (_m *Client) GetPods(namespace string) (*corev1.PodList, error) {
 	return r0, r1
 }
 
+// GetResourceQuota provides a mock function with given fields: namespace
+func (_m *Client) GetResourceQuota(namespace string) (*corev1.ResourceQuota, error) {
+	ret := _m.Called(namespace)
+
+	var r0 *corev1.ResourceQuota
+	if rf, ok := ret.Get(0).(func(string) *corev1.ResourceQuota); ok {
+		r0 = rf(namespace)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*corev1.ResourceQuota)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(namespace)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetRestClient provides a mock function with given fields:
 func (_m *Client) GetRestClient() rest.Interface {
 	ret := _m.Called()
@@ -76,6 +306,96 @@ func (_m *Client) GetRestClient() rest.Interface {
 	return r0
 }
 
+// GetSecret provides a mock function with given fields: namespace, name
+func (_m *Client) GetSecret(namespace string, name string) (*corev1.Secret, error) {
+	ret := _m.Called(namespace, name)
+
+	var r0 *corev1.Secret
+	if rf, ok := ret.Get(0).(func(string, string) *corev1.Secret); ok {
+		r0 = rf(namespace, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*corev1.Secret)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(namespace, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetServiceAccount provides a mock function with given fields: namespace, name
+func (_m *Client) GetServiceAccount(namespace string, name string) (*corev1.ServiceAccount, error) {
+	ret := _m.Called(namespace, name)
+
+	var r0 *corev1.ServiceAccount
+	if rf, ok := ret.Get(0).(func(string, string) *corev1.ServiceAccount); ok {
+		r0 = rf(namespace, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*corev1.ServiceAccount)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(namespace, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetServiceAccountToken provides a mock function with given fields: namespace, name
+func (_m *Client) GetServiceAccountToken(namespace string, name string) (string, error) {
+	ret := _m.Called(namespace, name)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string) string); ok {
+		r0 = rf(namespace, name)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(namespace, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListNamespacesWithIBNetworks provides a mock function with given fields:
+func (_m *Client) ListNamespacesWithIBNetworks() ([]string, error) {
+	ret := _m.Called()
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func() []string); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // PatchPod provides a mock function with given fields: pod, patchType, patchData
 func (_m *Client) PatchPod(pod *corev1.Pod, patchType types.PatchType, patchData []byte) error {
 	ret := _m.Called(pod, patchType, patchData)
@@ -90,6 +410,34 @@ func (_m *Client) PatchPod(pod *corev1.Pod, patchType types.PatchType, patchData
 	return r0
 }
 
+// SetAnnotationsOnConfigMap provides a mock function with given fields: configMap, annotations
+func (_m *Client) SetAnnotationsOnConfigMap(configMap *corev1.ConfigMap, annotations map[string]string) error {
+	ret := _m.Called(configMap, annotations)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*corev1.ConfigMap, map[string]string) error); ok {
+		r0 = rf(configMap, annotations)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetAnnotationsOnNetworkAttachmentDefinition provides a mock function with given fields: netAttDef, annotations
+func (_m *Client) SetAnnotationsOnNetworkAttachmentDefinition(netAttDef *v1.NetworkAttachmentDefinition, annotations map[string]string) error {
+	ret := _m.Called(netAttDef, annotations)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*v1.NetworkAttachmentDefinition, map[string]string) error); ok {
+		r0 = rf(netAttDef, annotations)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetAnnotationsOnPod provides a mock function with given fields: pod, annotations
 func (_m *Client) SetAnnotationsOnPod(pod *corev1.Pod, annotations map[string]string) error {
 	ret := _m.Called(pod, annotations)
@@ -103,3 +451,91 @@ func (_m *Client) SetAnnotationsOnPod(pod *corev1.Pod, annotations map[string]st
 
 	return r0
 }
+
+// SetConfigMapData provides a mock function with given fields: namespace, name, data
+func (_m *Client) SetConfigMapData(namespace string, name string, data map[string]string) error {
+	ret := _m.Called(namespace, name, data)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, map[string]string) error); ok {
+		r0 = rf(namespace, name, data)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetPodCondition provides a mock function with given fields: pod, condition
+func (_m *Client) SetPodCondition(pod *corev1.Pod, condition corev1.PodCondition) error {
+	ret := _m.Called(pod, condition)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*corev1.Pod, corev1.PodCondition) error); ok {
+		r0 = rf(pod, condition)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateConfigMap provides a mock function with given fields: configMap
+func (_m *Client) UpdateConfigMap(configMap *corev1.ConfigMap) error {
+	ret := _m.Called(configMap)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*corev1.ConfigMap) error); ok {
+		r0 = rf(configMap)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateGUIDMigrationStatus provides a mock function with given fields: migration
+func (_m *Client) UpdateGUIDMigrationStatus(migration *v1alpha1.GUIDMigration) (*v1alpha1.GUIDMigration, error) {
+	ret := _m.Called(migration)
+
+	var r0 *v1alpha1.GUIDMigration
+	if rf, ok := ret.Get(0).(func(*v1alpha1.GUIDMigration) *v1alpha1.GUIDMigration); ok {
+		r0 = rf(migration)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*v1alpha1.GUIDMigration)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*v1alpha1.GUIDMigration) error); ok {
+		r1 = rf(migration)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdatePKeyReservationStatus provides a mock function with given fields: reservation
+func (_m *Client) UpdatePKeyReservationStatus(reservation *v1alpha1.PKeyReservation) (*v1alpha1.PKeyReservation, error) {
+	ret := _m.Called(reservation)
+
+	var r0 *v1alpha1.PKeyReservation
+	if rf, ok := ret.Get(0).(func(*v1alpha1.PKeyReservation) *v1alpha1.PKeyReservation); ok {
+		r0 = rf(reservation)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*v1alpha1.PKeyReservation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*v1alpha1.PKeyReservation) error); ok {
+		r1 = rf(reservation)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,44 @@
+package k8sclient
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// DefaultPodAnnotationRetries is the default number of retries of setting pod annotations on conflict
+const DefaultPodAnnotationRetries = 3
+
+// podAnnotationBackoff is the backoff between the retries of setting pod annotations, doubled on every retry
+var podAnnotationBackoff = wait.Backoff{Duration: 10 * time.Millisecond, Factor: 2, Jitter: 0.1}
+
+// SetAnnotationsOnPodWithRetry sets the annotations on the pod, retrying with exponential backoff up to maxRetries
+// times if the pod changed concurrently. The latest pod is fetched before every retry, it returns a not found
+// error if the pod was deleted or replaced by a pod with the same name.
+func SetAnnotationsOnPodWithRetry(c Client, pod *kapi.Pod, annotations map[string]string, maxRetries int) error {
+	backoff := podAnnotationBackoff
+	backoff.Steps = maxRetries + 1
+	latest := pod
+	attempt := 0
+	return retry.RetryOnConflict(backoff, func() error {
+		if attempt > 0 {
+			log.Debug().Msgf("pod namespace %s name %s changed concurrently, retrying setting annotations",
+				pod.Namespace, pod.Name)
+			var err error
+			latest, err = c.GetPod(pod.Namespace, pod.Name)
+			if err != nil {
+				return err
+			}
+			if latest.UID != pod.UID {
+				return apiErrors.NewNotFound(kapi.Resource("pods"), pod.Name)
+			}
+		}
+		attempt++
+
+		return c.SetAnnotationsOnPod(latest, annotations)
+	})
+}
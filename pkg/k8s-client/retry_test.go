@@ -0,0 +1,59 @@
+package k8sclient
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("SetAnnotationsOnPodWithRetry", func() {
+	pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "pod-uid"}}
+	conflictingClientset := func(conflicts *int, maxConflicts int) *fake.Clientset {
+		clientset := fake.NewSimpleClientset(pod.DeepCopy())
+		clientset.PrependReactor("patch", "pods", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+			if *conflicts >= maxConflicts {
+				return false, nil, nil
+			}
+			*conflicts++
+			return true, nil, apiErrors.NewConflict(kapi.Resource("pods"), "pod", errors.New("changed"))
+		})
+		return clientset
+	}
+	It("Retry on conflict until the annotations are set", func() {
+		conflicts := 0
+		clientset := conflictingClientset(&conflicts, 2)
+		c := &client{clientset: clientset}
+		Expect(SetAnnotationsOnPodWithRetry(c, pod, map[string]string{"a": "1"}, 3)).To(Succeed())
+		Expect(conflicts).To(Equal(2))
+
+		current, err := c.GetPod("default", "pod")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(current.Annotations).To(Equal(map[string]string{"a": "1"}))
+	})
+	It("Return the conflict after the max retries", func() {
+		conflicts := 0
+		c := &client{clientset: conflictingClientset(&conflicts, 5)}
+		err := SetAnnotationsOnPodWithRetry(c, pod, map[string]string{"a": "1"}, 2)
+		Expect(apiErrors.IsConflict(err)).To(BeTrue())
+		Expect(conflicts).To(Equal(3))
+	})
+	It("Return not found if the pod was replaced", func() {
+		conflicts := 0
+		clientset := conflictingClientset(&conflicts, 1)
+		replaced := pod.DeepCopy()
+		replaced.UID = "other-uid"
+		_, err := clientset.CoreV1().Pods("default").Update(replaced)
+		Expect(err).ToNot(HaveOccurred())
+
+		c := &client{clientset: clientset}
+		err = SetAnnotationsOnPodWithRetry(c, pod, map[string]string{"a": "1"}, 3)
+		Expect(apiErrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,48 @@
+package k8sclient
+
+import (
+	"time"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+)
+
+// annotationWriteLimitedClient limits the number of concurrent pod annotation writes, so scale ups of many pods don't
+// get the daemon rate limited by the api server
+type annotationWriteLimitedClient struct {
+	Client
+	tokens chan struct{} // buffered with a token per concurrent write
+}
+
+// NewAnnotationWriteLimitedClient returns kubernetes client which runs up to maxConcurrentWrites pod annotations
+// writes and patches of the given client at once, the other writes wait for a running write to return
+func NewAnnotationWriteLimitedClient(client Client, maxConcurrentWrites int) Client {
+	return &annotationWriteLimitedClient{Client: client, tokens: make(chan struct{}, maxConcurrentWrites)}
+}
+
+func (c *annotationWriteLimitedClient) SetAnnotationsOnPod(pod *kapi.Pod, annotations map[string]string) error {
+	c.acquire()
+	defer c.release()
+	return c.Client.SetAnnotationsOnPod(pod, annotations)
+}
+
+func (c *annotationWriteLimitedClient) PatchPod(pod *kapi.Pod, patchType types.PatchType, patchData []byte) error {
+	c.acquire()
+	defer c.release()
+	return c.Client.PatchPod(pod, patchType, patchData)
+}
+
+// acquire waits for a write token and records the wait time
+func (c *annotationWriteLimitedClient) acquire() {
+	start := time.Now()
+	c.tokens <- struct{}{}
+	metrics.AnnotationWriteWait.Observe(time.Since(start).Seconds())
+	metrics.AnnotationWritesInFlight.Inc()
+}
+
+func (c *annotationWriteLimitedClient) release() {
+	metrics.AnnotationWritesInFlight.Dec()
+	<-c.tokens
+}
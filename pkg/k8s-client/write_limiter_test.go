@@ -0,0 +1,56 @@
+package k8sclient
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// concurrencyRecordingClient records the maximum number of concurrent pod annotation writes
+type concurrencyRecordingClient struct {
+	Client
+	lock          sync.Mutex
+	running       int
+	maxConcurrent int
+}
+
+func (c *concurrencyRecordingClient) SetAnnotationsOnPod(pod *kapi.Pod, annotations map[string]string) error {
+	c.lock.Lock()
+	c.running++
+	if c.running > c.maxConcurrent {
+		c.maxConcurrent = c.running
+	}
+	c.lock.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.lock.Lock()
+	c.running--
+	c.lock.Unlock()
+	return nil
+}
+
+var _ = Describe("Annotation write limited client", func() {
+	It("Limit the concurrent pod annotation writes", func() {
+		recordingClient := &concurrencyRecordingClient{}
+		c := NewAnnotationWriteLimitedClient(recordingClient, 2)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"}}
+				Expect(c.SetAnnotationsOnPod(pod, map[string]string{"a": "1"})).To(Succeed())
+			}()
+		}
+		wg.Wait()
+
+		Expect(recordingClient.maxConcurrent).To(Equal(2))
+	})
+})
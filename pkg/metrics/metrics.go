@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ib_kubernetes"
+
+var (
+	// SMAddVerificationFailures counts guids reported as added by the subnet manager but missing from the pkey
+	SMAddVerificationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sm_add_verification_failures_total",
+		Help:      "Number of guids missing from the pkey membership after a successful add to the subnet manager",
+	})
+
+	// GUIDPoolFragmentation is the fragmentation score of the free guids in the guid pool
+	GUIDPoolFragmentation = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "guid_pool_fragmentation",
+		Help:      "Fragmentation of the free guids in the guid pool, from 0 (contiguous) to 1 (fully fragmented)",
+	})
+
+	// GUIDPoolAllocated is the number of allocated guids in the guid pool
+	GUIDPoolAllocated = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "guid_pool_allocated",
+		Help:      "Number of allocated guids in the guid pool, including guid ranges",
+	})
+
+	// GUIDPoolAvailable is the number of guids which can be allocated in the guid pool
+	GUIDPoolAvailable = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "guid_pool_available",
+		Help:      "Number of free guids in the guid pool which can be allocated",
+	})
+
+	// GUIDAllocations counts the guids allocated in the guid pools
+	GUIDAllocations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "guid_allocations_total",
+		Help:      "Number of guids allocated in the guid pools",
+	})
+
+	// GUIDReleases counts the guids released in the guid pools
+	GUIDReleases = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "guid_releases_total",
+		Help:      "Number of guids released in the guid pools",
+	})
+
+	// GUIDPoolExhaustions counts the add updates which found a guid pool exhausted
+	GUIDPoolExhaustions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "guid_pool_exhaustions_total",
+		Help:      "Number of times a guid pool was found exhausted by the add periodic update",
+	})
+
+	// GUIDPoolExcluded is the number of guids in the excluded ranges of the guid pool
+	GUIDPoolExcluded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "guid_pool_excluded_count",
+		Help:      "Number of guids in the guid pool excluded ranges, which aren't allocated",
+	})
+
+	// SMCertExpirySeconds is the time until the subnet manager client certificate expires
+	SMCertExpirySeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sm_cert_expiry_seconds",
+		Help:      "Seconds until the subnet manager REST client certificate expires",
+	})
+
+	// PKeyUtilization is the percent of the maximum members used per pkey
+	PKeyUtilization = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pkey_utilization_percent",
+		Help:      "Percent of the maximum number of pkey members used, as reported by the subnet manager",
+	}, []string{"pkey"})
+
+	// WatcherDesyncs counts the detected desyncs of the watcher informer cache from the cluster state
+	WatcherDesyncs = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watcher_desyncs_total",
+		Help:      "Number of times the watcher informer cache was found out of sync and re-listed",
+	})
+
+	// ManagedNamespaces is the number of namespaces with InfiniBand network attachment definitions
+	ManagedNamespaces = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "managed_namespaces_count",
+		Help:      "Number of namespaces with at least one InfiniBand network attachment definition",
+	})
+
+	// PodRestartRequeues counts the pods re-queued for guid verification after a container restart
+	PodRestartRequeues = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pod_restart_requeues_total",
+		Help:      "Number of pods re-queued to re-verify their guids after a container restart",
+	})
+
+	// SMRateLimitWait is the time the subnet manager calls waited for the per network rate limit
+	SMRateLimitWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sm_rate_limit_wait_seconds",
+		Help:      "Time subnet manager calls of a network waited for the per network rate limit",
+		Buckets:   []float64{0, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	})
+
+	// AnnotationWriteWait is the time the pod annotation writes waited for the concurrent annotation writes limit
+	AnnotationWriteWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "annotation_write_wait_seconds",
+		Help:      "Time pod annotation writes waited for the concurrent annotation writes limit",
+		Buckets:   []float64{0, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	})
+
+	// AnnotationWritesInFlight is the number of pod annotation writes running under the concurrent writes limit
+	AnnotationWritesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "annotation_writes_in_flight",
+		Help:      "Number of pod annotation writes running under the concurrent annotation writes limit",
+	})
+
+	// SMDualWriteDivergence counts the pKey changes applied to the primary subnet manager but failed in the secondary
+	SMDualWriteDivergence = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sm_dual_write_divergence_total",
+		Help:      "Number of pKey changes which succeeded in the primary subnet manager and failed in the secondary",
+	})
+
+	// NetworkReconcileDuration is the time the periodic updates spent processing the pods of an InfiniBand network
+	NetworkReconcileDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "network_reconcile_duration_seconds",
+		Help:      "Time the add and delete periodic updates spent processing the pods of an InfiniBand network",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"network"})
+
+	// NetworkReconcileFailures counts the failed processing of the pods of an InfiniBand network by failure reason
+	NetworkReconcileFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "network_reconcile_failures_total",
+		Help:      "Number of failed add and delete periodic updates processing of an InfiniBand network by reason",
+	}, []string{"network", "reason"})
+)
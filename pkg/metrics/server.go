@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Path is the path the metrics are served on
+	Path = "/metrics"
+	// readHeaderTimeout limits the time to read the request headers of the scrape requests
+	readHeaderTimeout = 10 * time.Second
+)
+
+type Server interface {
+	// Run serves the metrics until the stop channel is closed
+	Run(stopChan <-chan struct{}) error
+}
+
+type server struct {
+	httpServer *http.Server
+}
+
+// NewServer returns a http server of the Prometheus metrics on the given address, e.g ":9090"
+func NewServer(address string) Server {
+	mux := http.NewServeMux()
+	mux.Handle(Path, promhttp.Handler())
+	return &server{httpServer: &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: readHeaderTimeout}}
+}
+
+func (s *server) Run(stopChan <-chan struct{}) error {
+	go func() {
+		<-stopChan
+		s.httpServer.Close()
+	}()
+
+	log.Info().Msgf("serving metrics on %s%s", s.httpServer.Addr, Path)
+	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve metrics on %s: %v", s.httpServer.Addr, err)
+	}
+
+	return nil
+}
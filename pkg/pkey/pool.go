@@ -0,0 +1,153 @@
+package pkey
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+const (
+	// minPKey is the first pKey which can be assigned, 0x0000 is invalid
+	minPKey = 0x0001
+	// maxPKey is the last pKey which can be assigned, 0x7FFF is the default pKey
+	maxPKey = 0x7FFE
+)
+
+// ErrPoolExhausted is returned when all the pKeys of the pool are assigned
+var ErrPoolExhausted = errors.New("pKey pool exhausted")
+
+// Pool assigns distinct pKeys of a range to networks
+type Pool interface {
+	// AllocatePKey returns the pKey assigned to the network, a free pKey is assigned if the network has none.
+	// It returns error wrapping ErrPoolExhausted if all the pKeys are assigned.
+	AllocatePKey(network string) (int, error)
+	// GetPKey returns the pKey assigned to the network
+	GetPKey(network string) (int, bool)
+	// ReleasePKey frees the pKey assigned to the network.
+	// It returns error if the network has no pKey.
+	ReleasePKey(network string) error
+	// Allocations returns the assigned pKeys mapped by network
+	Allocations() map[string]int
+	// Restore replaces the assigned pKeys with the given pKeys mapped by network, e.g persisted before restart.
+	// It returns error if a pKey is out of the pool range or assigned to several networks.
+	Restore(allocations map[string]int) error
+}
+
+// pKeyPool is safe for concurrent use
+type pKeyPool struct {
+	lock        sync.Mutex
+	rangeStart  int
+	rangeEnd    int
+	networkPKey map[string]int // assigned pKey mapped by network
+	assigned    map[int]string // network mapped by assigned pKey
+}
+
+// NewPool returns a pKey pool of the configured range.
+// It returns error if the range isn't valid.
+func NewPool(conf *config.PKeyPoolConfig) (Pool, error) {
+	log.Info().Msgf("creating pKey pool, pKeyRangeStart %s, pKeyRangeEnd %s", conf.RangeStart, conf.RangeEnd)
+	rangeStart, err := utils.ParsePKey(conf.RangeStart)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pKey pool range start: %v", err)
+	}
+	rangeEnd, err := utils.ParsePKey(conf.RangeEnd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pKey pool range end: %v", err)
+	}
+
+	if rangeStart < minPKey || rangeEnd > maxPKey || rangeStart > rangeEnd {
+		return nil, fmt.Errorf("invalid pKey pool range %s - %s, should be within 0x%04X - 0x%04X",
+			conf.RangeStart, conf.RangeEnd, minPKey, maxPKey)
+	}
+
+	return &pKeyPool{rangeStart: rangeStart, rangeEnd: rangeEnd, networkPKey: map[string]int{},
+		assigned: map[int]string{}}, nil
+}
+
+func (p *pKeyPool) AllocatePKey(network string) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if pKey, ok := p.networkPKey[network]; ok {
+		return pKey, nil
+	}
+
+	for pKey := p.rangeStart; pKey <= p.rangeEnd; pKey++ {
+		if _, ok := p.assigned[pKey]; ok {
+			continue
+		}
+
+		log.Info().Msgf("assigning pKey 0x%04X to network %s", pKey, network)
+		p.networkPKey[network] = pKey
+		p.assigned[pKey] = network
+		return pKey, nil
+	}
+
+	return 0, fmt.Errorf("%w: all the pKeys of range 0x%04X - 0x%04X are assigned", ErrPoolExhausted,
+		p.rangeStart, p.rangeEnd)
+}
+
+func (p *pKeyPool) GetPKey(network string) (int, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pKey, ok := p.networkPKey[network]
+	return pKey, ok
+}
+
+func (p *pKeyPool) ReleasePKey(network string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pKey, ok := p.networkPKey[network]
+	if !ok {
+		return fmt.Errorf("failed to release pKey of network %s, not assigned", network)
+	}
+
+	log.Info().Msgf("releasing pKey 0x%04X of network %s", pKey, network)
+	delete(p.networkPKey, network)
+	delete(p.assigned, pKey)
+	return nil
+}
+
+func (p *pKeyPool) Allocations() map[string]int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	allocations := make(map[string]int, len(p.networkPKey))
+	for network, pKey := range p.networkPKey {
+		allocations[network] = pKey
+	}
+	return allocations
+}
+
+func (p *pKeyPool) Restore(allocations map[string]int) error {
+	networks := make([]string, 0, len(allocations))
+	for network := range allocations {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+
+	networkPKey := make(map[string]int, len(allocations))
+	assigned := make(map[int]string, len(allocations))
+	for _, network := range networks {
+		pKey := allocations[network]
+		if pKey < p.rangeStart || pKey > p.rangeEnd {
+			return fmt.Errorf("pKey 0x%04X of network %s out of pool range 0x%04X - 0x%04X", pKey, network,
+				p.rangeStart, p.rangeEnd)
+		}
+		if other, ok := assigned[pKey]; ok {
+			return fmt.Errorf("pKey 0x%04X assigned to both networks %s and %s", pKey, other, network)
+		}
+		networkPKey[network] = pKey
+		assigned[pKey] = network
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.networkPKey = networkPKey
+	p.assigned = assigned
+	return nil
+}
@@ -0,0 +1,83 @@
+package pkey
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+)
+
+var _ = Describe("PKey Pool", func() {
+	conf := &config.PKeyPoolConfig{RangeStart: "0x1000", RangeEnd: "0x1001"}
+	Context("NewPool", func() {
+		It("Create pKey pool with valid range", func() {
+			_, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("Create pKey pool with invalid range", func() {
+			_, err := NewPool(&config.PKeyPoolConfig{RangeStart: "0x1001", RangeEnd: "0x1000"})
+			Expect(err).To(HaveOccurred())
+			_, err = NewPool(&config.PKeyPoolConfig{RangeStart: "0x1000", RangeEnd: "0x7FFF"})
+			Expect(err).To(HaveOccurred())
+			_, err = NewPool(&config.PKeyPoolConfig{RangeStart: "1000", RangeEnd: "0x2000"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("AllocatePKey", func() {
+		It("Assign distinct pKeys to the networks until the pool is exhausted", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+
+			pKey, err := pool.AllocatePKey("default_net-a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0x1000))
+			pKey, err = pool.AllocatePKey("default_net-a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0x1000))
+			pKey, err = pool.AllocatePKey("default_net-b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0x1001))
+
+			_, err = pool.AllocatePKey("default_net-c")
+			Expect(errors.Is(err, ErrPoolExhausted)).To(BeTrue())
+		})
+	})
+	Context("ReleasePKey", func() {
+		It("Assign released pKey to another network", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = pool.AllocatePKey("default_net-a")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(pool.ReleasePKey("default_net-a")).To(Succeed())
+			Expect(pool.ReleasePKey("default_net-a")).ToNot(Succeed())
+			_, found := pool.GetPKey("default_net-a")
+			Expect(found).To(BeFalse())
+
+			pKey, err := pool.AllocatePKey("default_net-b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0x1000))
+		})
+	})
+	Context("Restore", func() {
+		It("Restore the assigned pKeys", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.Restore(map[string]int{"default_net-a": 0x1001})).To(Succeed())
+			Expect(pool.Allocations()).To(Equal(map[string]int{"default_net-a": 0x1001}))
+
+			pKey, err := pool.AllocatePKey("default_net-b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0x1000))
+		})
+		It("Restore invalid pKeys", func() {
+			pool, err := NewPool(conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.Restore(map[string]int{"default_net-a": 0x2000})).ToNot(Succeed())
+			Expect(pool.Restore(map[string]int{"default_net-a": 0x1000, "default_net-b": 0x1000})).ToNot(Succeed())
+			Expect(pool.Allocations()).To(BeEmpty())
+		})
+	})
+})
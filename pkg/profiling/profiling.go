@@ -0,0 +1,113 @@
+package profiling
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	runtimePprof "runtime/pprof"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// NewServer returns http server of the net/http/pprof handlers on the given address.
+// In addition to the standard handlers, POST /debug/pprof/heap writes a heap profile to a file in the temp dir.
+func NewServer(addr string) *http.Server {
+	return &http.Server{Addr: addr, Handler: newHandler(os.TempDir())}
+}
+
+func newHandler(profileDir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/pprof/heap", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			pprof.Handler("heap").ServeHTTP(w, r)
+			return
+		}
+
+		profilePath, err := WriteHeapProfile(profileDir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, profilePath)
+	})
+	return mux
+}
+
+// WriteHeapProfile writes heap profile to a new file in the given directory and returns its path
+func WriteHeapProfile(profileDir string) (string, error) {
+	profilePath := profileFilePath(profileDir, "heap")
+	profileFile, err := os.Create(profilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create heap profile file %s: %v", profilePath, err)
+	}
+	defer profileFile.Close()
+
+	// get up-to-date statistics
+	runtime.GC()
+	if err = runtimePprof.WriteHeapProfile(profileFile); err != nil {
+		return "", fmt.Errorf("failed to write heap profile %s: %v", profilePath, err)
+	}
+
+	log.Info().Msgf("wrote heap profile %s", profilePath)
+	return profilePath, nil
+}
+
+// WriteCPUProfile profiles the cpu for the given duration, or until the stop channel is closed,
+// to a new file in the given directory and returns its path
+func WriteCPUProfile(profileDir string, duration time.Duration, stopChan <-chan struct{}) (string, error) {
+	profilePath := profileFilePath(profileDir, "cpu")
+	profileFile, err := os.Create(profilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cpu profile file %s: %v", profilePath, err)
+	}
+	defer profileFile.Close()
+
+	if err = runtimePprof.StartCPUProfile(profileFile); err != nil {
+		return "", fmt.Errorf("failed to start cpu profile: %v", err)
+	}
+
+	log.Info().Msgf("profiling cpu for %s to %s", duration, profilePath)
+	select {
+	case <-time.After(duration):
+	case <-stopChan:
+	}
+	runtimePprof.StopCPUProfile()
+
+	log.Info().Msgf("wrote cpu profile %s", profilePath)
+	return profilePath, nil
+}
+
+// RunCPUProfileOnSignal profiles the cpu for the given duration to a file in the temp dir
+// whenever SIGUSR2 is received, until the stop channel is closed
+func RunCPUProfileOnSignal(duration time.Duration, stopChan <-chan struct{}) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR2)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-sigChan:
+			if _, err := WriteCPUProfile(os.TempDir(), duration, stopChan); err != nil {
+				log.Warn().Msgf("failed to profile cpu with error: %v", err)
+			}
+		}
+	}
+}
+
+func profileFilePath(profileDir, profile string) string {
+	return filepath.Join(profileDir, fmt.Sprintf("ib-kubernetes-%s-%s.pprof", profile,
+		time.Now().Format("20060102-150405.000")))
+}
@@ -0,0 +1,48 @@
+package profiling
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiling", func() {
+	var profileDir string
+	BeforeEach(func() {
+		var err error
+		profileDir, err = ioutil.TempDir("", "profiling")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(profileDir)).ToNot(HaveOccurred())
+	})
+	Context("Server", func() {
+		It("Serve pprof index", func() {
+			recorder := httptest.NewRecorder()
+			newHandler(profileDir).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+		It("Write heap profile on post", func() {
+			recorder := httptest.NewRecorder()
+			newHandler(profileDir).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/pprof/heap", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			profilePath := strings.TrimSpace(recorder.Body.String())
+			Expect(profilePath).To(HavePrefix(profileDir))
+			Expect(profilePath).To(BeARegularFile())
+		})
+	})
+	Context("WriteCPUProfile", func() {
+		It("Write cpu profile for duration", func() {
+			profilePath, err := WriteCPUProfile(profileDir, 10*time.Millisecond, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(profilePath).To(BeARegularFile())
+		})
+	})
+})
@@ -0,0 +1,200 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	httpDriver "github.com/Mellanox/ib-kubernetes/pkg/drivers/http"
+)
+
+// BaseConfig is the requests configuration of the subnet manager REST clients
+type BaseConfig struct {
+	// Number of retries of rate limited and server error responses
+	MaxRetries int
+	// Backoff before the first retry, doubled on every retry
+	RetryBackoff time.Duration
+	// Timeout of a request including its retries, no timeout if 0
+	Timeout time.Duration
+}
+
+// DefaultBaseConfig is the requests configuration used by the plugins unless configured otherwise
+var DefaultBaseConfig = BaseConfig{MaxRetries: 3, RetryBackoff: 500 * time.Millisecond, Timeout: time.Minute}
+
+// BaseSMClient implements the json marshaling, retries, timeout and logging of the subnet manager REST requests.
+// Plugins embed it and implement only the urls construction and the responses parsing.
+type BaseSMClient struct {
+	Config BaseConfig
+	Client httpDriver.Client
+	Logger logr.Logger // requests logger, the global zerolog logger if nil
+}
+
+// DoWithRetry sends the request with the json of body, if not nil, and parses the json response into response,
+// if not nil. Rate limited and server error responses are retried with exponential backoff until the config
+// timeout or the context is done.
+func (b *BaseSMClient) DoWithRetry(ctx context.Context, method, url string, body, response interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to marshal %s %s request body: %v", method, url, err)
+		}
+	}
+
+	if b.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Config.Timeout)
+		defer cancel()
+	}
+
+	backoff := b.Config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		b.logger().V(1).Info("subnet manager request", "method", method, "url", url, "attempt", attempt+1)
+		responseData, err := b.do(ctx, method, url, data)
+		if err == nil {
+			if response == nil {
+				return nil
+			}
+			if err = json.Unmarshal(responseData, response); err != nil {
+				return fmt.Errorf("failed to parse %s %s response: %v", method, url, err)
+			}
+			return nil
+		}
+
+		if attempt >= b.Config.MaxRetries || !isRetryable(err) {
+			return err
+		}
+
+		b.logger().Error(err, "subnet manager request failed, retrying", "method", method, "url", url,
+			"backoff", backoff.String())
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("subnet manager request %s %s failed: %v, last error: %v", method, url, ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// do sends the request once, it returns when the request is done or the context is done. The context clients send
+// the requests with their context, so they cancel them once the context is done and may set their headers from it,
+// e.g the trace context.
+func (b *BaseSMClient) do(ctx context.Context, method, url string, data []byte) ([]byte, error) {
+	if contextClient, ok := b.Client.(httpDriver.ContextClient); ok {
+		switch method {
+		case http.MethodGet:
+			return contextClient.GetWithContext(ctx, url, http.StatusOK)
+		case http.MethodPost:
+			return contextClient.PostWithContext(ctx, url, http.StatusOK, data)
+		default:
+			return nil, fmt.Errorf("unsupported request method %s", method)
+		}
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	// buffered so the request goroutine doesn't leak if the context is done first
+	resultChan := make(chan result, 1)
+	go func() {
+		var res result
+		switch method {
+		case http.MethodGet:
+			res.data, res.err = b.Client.Get(url, http.StatusOK)
+		case http.MethodPost:
+			res.data, res.err = b.Client.Post(url, http.StatusOK, data)
+		default:
+			res.err = fmt.Errorf("unsupported request method %s", method)
+		}
+		resultChan <- res
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("subnet manager request %s %s failed: %v", method, url, ctx.Err())
+	case res := <-resultChan:
+		return res.data, res.err
+	}
+}
+
+func (b *BaseSMClient) logger() logr.Logger {
+	if b.Logger != nil {
+		return b.Logger
+	}
+	return zerologLogger{}
+}
+
+// zerologLogger is logr.Logger of the global zerolog logger, the V(0) messages are logged at info level and the
+// more verbose messages at debug level
+type zerologLogger struct {
+	verbosity int
+	name      string
+	values    []interface{}
+}
+
+func (z zerologLogger) level() zerolog.Level {
+	if z.verbosity > 0 {
+		return zerolog.DebugLevel
+	}
+	return zerolog.InfoLevel
+}
+
+func (z zerologLogger) Enabled() bool {
+	return z.level() >= zerolog.GlobalLevel() && z.level() >= log.Logger.GetLevel()
+}
+
+func (z zerologLogger) Info(msg string, keysAndValues ...interface{}) {
+	z.log(log.WithLevel(z.level()), msg, keysAndValues)
+}
+
+func (z zerologLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	z.log(log.Error().Err(err), msg, keysAndValues)
+}
+
+func (z zerologLogger) V(level int) logr.InfoLogger {
+	z.verbosity += level
+	return z
+}
+
+func (z zerologLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	z.values = append(z.values[:len(z.values):len(z.values)], keysAndValues...)
+	return z
+}
+
+func (z zerologLogger) WithName(name string) logr.Logger {
+	z.name = strings.TrimPrefix(z.name+"/"+name, "/")
+	return z
+}
+
+// log sends the event with the logger name and values and the message key value pairs
+func (z zerologLogger) log(event *zerolog.Event, msg string, keysAndValues []interface{}) {
+	if z.name != "" {
+		event = event.Str("logger", z.name)
+	}
+	fields := map[string]interface{}{}
+	for _, pairs := range [][]interface{}{z.values, keysAndValues} {
+		for index := 0; index+1 < len(pairs); index += 2 {
+			fields[fmt.Sprint(pairs[index])] = pairs[index+1]
+		}
+	}
+	event.Fields(fields).Msg(msg)
+}
+
+// isRetryable returns true for rate limited and server error responses
+func isRetryable(err error) bool {
+	var statusErr *httpDriver.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= http.StatusInternalServerError
+}
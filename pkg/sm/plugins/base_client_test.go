@@ -0,0 +1,130 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-logr/logr"
+	logrTesting "github.com/go-logr/logr/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	httpDriver "github.com/Mellanox/ib-kubernetes/pkg/drivers/http"
+	"github.com/Mellanox/ib-kubernetes/pkg/drivers/http/mocks"
+)
+
+// recordingLogger records the messages of the info and error logs
+type recordingLogger struct {
+	logrTesting.NullLogger
+	messages *[]string
+}
+
+func (r recordingLogger) Info(msg string, _ ...interface{}) {
+	*r.messages = append(*r.messages, msg)
+}
+
+func (r recordingLogger) Error(_ error, msg string, _ ...interface{}) {
+	*r.messages = append(*r.messages, msg)
+}
+
+func (r recordingLogger) V(_ int) logr.InfoLogger {
+	return r
+}
+
+var _ = Describe("Base Subnet Manager Client", func() {
+	url := "http://1.1.1.1:80/ufmRest/resources/pkeys"
+	config := BaseConfig{MaxRetries: 2, RetryBackoff: time.Millisecond}
+	Context("DoWithRetry", func() {
+		It("Marshal request body and unmarshal response", func() {
+			client := &mocks.Client{}
+			client.On("Post", url, http.StatusOK, []byte(`{"pkey":"0x10"}`)).Return([]byte(`{"guids":["a"]}`), nil)
+			base := &BaseSMClient{Config: config, Client: client}
+
+			response := &struct {
+				GUIDs []string `json:"guids"`
+			}{}
+			err := base.DoWithRetry(context.Background(), http.MethodPost, url,
+				map[string]string{"pkey": "0x10"}, response)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.GUIDs).To(Equal([]string{"a"}))
+		})
+		It("Retry rate limited and server error responses", func() {
+			client := &mocks.Client{}
+			client.On("Get", url, http.StatusOK).Return(nil,
+				&httpDriver.StatusError{StatusCode: http.StatusTooManyRequests}).Once()
+			client.On("Get", url, http.StatusOK).Return(nil,
+				&httpDriver.StatusError{StatusCode: http.StatusServiceUnavailable}).Once()
+			client.On("Get", url, http.StatusOK).Return([]byte(`{}`), nil).Once()
+			base := &BaseSMClient{Config: config, Client: client}
+
+			Expect(base.DoWithRetry(context.Background(), http.MethodGet, url, nil, nil)).To(Succeed())
+			client.AssertNumberOfCalls(GinkgoT(), "Get", 3)
+		})
+		It("Fail after max retries", func() {
+			client := &mocks.Client{}
+			client.On("Get", url, http.StatusOK).Return(nil,
+				&httpDriver.StatusError{StatusCode: http.StatusInternalServerError})
+			base := &BaseSMClient{Config: config, Client: client}
+
+			Expect(base.DoWithRetry(context.Background(), http.MethodGet, url, nil, nil)).ToNot(Succeed())
+			client.AssertNumberOfCalls(GinkgoT(), "Get", 3)
+		})
+		It("Don't retry client errors", func() {
+			client := &mocks.Client{}
+			client.On("Get", url, http.StatusOK).Return(nil, &httpDriver.StatusError{StatusCode: http.StatusNotFound})
+			client.On("Post", url, http.StatusOK, []byte(nil)).Return(nil, errors.New("failed"))
+			base := &BaseSMClient{Config: config, Client: client}
+
+			Expect(base.DoWithRetry(context.Background(), http.MethodGet, url, nil, nil)).ToNot(Succeed())
+			Expect(base.DoWithRetry(context.Background(), http.MethodPost, url, nil, nil)).ToNot(Succeed())
+			client.AssertNumberOfCalls(GinkgoT(), "Get", 1)
+			client.AssertNumberOfCalls(GinkgoT(), "Post", 1)
+		})
+		It("Log requests with the client logger", func() {
+			client := &mocks.Client{}
+			client.On("Get", url, http.StatusOK).Return(nil,
+				&httpDriver.StatusError{StatusCode: http.StatusServiceUnavailable}).Once()
+			client.On("Get", url, http.StatusOK).Return([]byte(`{}`), nil).Once()
+			var messages []string
+			base := &BaseSMClient{Config: config, Client: client, Logger: recordingLogger{messages: &messages}}
+
+			Expect(base.DoWithRetry(context.Background(), http.MethodGet, url, nil, nil)).To(Succeed())
+			Expect(messages).To(Equal([]string{"subnet manager request", "subnet manager request failed, retrying",
+				"subnet manager request"}))
+		})
+		It("Fail request after timeout", func() {
+			client := &mocks.Client{}
+			client.On("Get", url, http.StatusOK).Return([]byte(`{}`), nil).After(time.Second)
+			base := &BaseSMClient{Config: BaseConfig{Timeout: 10 * time.Millisecond}, Client: client}
+
+			Expect(base.DoWithRetry(context.Background(), http.MethodGet, url, nil, nil)).ToNot(Succeed())
+		})
+		It("Send requests with their context to context clients", func() {
+			type contextKey struct{}
+			var requestIDs []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestIDs = append(requestIDs, r.Header.Get("X-Request-Id"))
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			client, err := httpDriver.NewClient(false, &httpDriver.BasicAuth{}, "", nil)
+			Expect(err).ToNot(HaveOccurred())
+			client.SetRequestEditor(func(req *http.Request) {
+				if requestID, ok := req.Context().Value(contextKey{}).(string); ok {
+					req.Header.Set("X-Request-Id", requestID)
+				}
+			})
+			base := &BaseSMClient{Config: config, Client: client}
+
+			ctx := context.WithValue(context.Background(), contextKey{}, "get")
+			Expect(base.DoWithRetry(ctx, http.MethodGet, server.URL, nil, nil)).To(Succeed())
+			ctx = context.WithValue(context.Background(), contextKey{}, "post")
+			Expect(base.DoWithRetry(ctx, http.MethodPost, server.URL, map[string]string{}, nil)).To(Succeed())
+			Expect(requestIDs).To(Equal([]string{"get", "post"}))
+		})
+	})
+})
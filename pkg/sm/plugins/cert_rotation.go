@@ -0,0 +1,110 @@
+package plugins
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+)
+
+// expiryMetricInterval is the interval of updating the client certificate expiry metric
+const expiryMetricInterval = time.Minute
+
+// CertRotationWatcher reloads the subnet manager REST client certificate when its files change.
+// Set GetClientCertificate as the tls.Config callback so new connections use the reloaded certificate.
+type CertRotationWatcher struct {
+	clientCert string
+	clientKey  string
+	cert       atomic.Value // current *tls.Certificate with parsed Leaf
+}
+
+// NewCertRotationWatcher loads the client certificate and key from the given files.
+// It returns error if the certificate can't be loaded.
+func NewCertRotationWatcher(clientCert, clientKey string) (*CertRotationWatcher, error) {
+	w := &CertRotationWatcher{clientCert: clientCert, clientKey: clientKey}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// GetClientCertificate returns the current client certificate
+func (w *CertRotationWatcher) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return w.cert.Load().(*tls.Certificate), nil
+}
+
+// TimeUntilExpiry returns the time until the current client certificate expires
+func (w *CertRotationWatcher) TimeUntilExpiry() time.Duration {
+	return time.Until(w.cert.Load().(*tls.Certificate).Leaf.NotAfter)
+}
+
+// Run watches the certificate files and reloads the certificate on change until the stop channel is closed
+func (w *CertRotationWatcher) Run(stopChan <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create certificate files watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	// watch the directories, files mounted from secrets are replaced rather than written
+	dirs := map[string]bool{filepath.Dir(w.clientCert): true, filepath.Dir(w.clientKey): true}
+	for dir := range dirs {
+		if err = watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch certificate directory %s: %v", dir, err)
+		}
+	}
+
+	ticker := time.NewTicker(expiryMetricInterval)
+	defer ticker.Stop()
+	metrics.SMCertExpirySeconds.Set(w.TimeUntilExpiry().Seconds())
+	for {
+		select {
+		case <-stopChan:
+			return nil
+		case <-ticker.C:
+			metrics.SMCertExpirySeconds.Set(w.TimeUntilExpiry().Seconds())
+		case event := <-watcher.Events:
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
+			}
+
+			log.Debug().Msgf("certificate directory event %s", event)
+			// keep the current certificate if the new files are incomplete, e.g the key isn't updated yet
+			if err = w.reload(); err != nil {
+				log.Warn().Msgf("failed to reload client certificate with error: %v", err)
+				continue
+			}
+			metrics.SMCertExpirySeconds.Set(w.TimeUntilExpiry().Seconds())
+		case err = <-watcher.Errors:
+			log.Warn().Msgf("certificate files watcher error: %v", err)
+		}
+	}
+}
+
+func (w *CertRotationWatcher) reload() error {
+	cert, err := tls.LoadX509KeyPair(w.clientCert, w.clientKey)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate %s and key %s: %v", w.clientCert, w.clientKey, err)
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse client certificate %s: %v", w.clientCert, err)
+	}
+
+	if current, ok := w.cert.Load().(*tls.Certificate); ok && current.Leaf.Equal(cert.Leaf) {
+		return nil
+	}
+
+	w.cert.Store(&cert)
+	log.Info().Msgf("loaded client certificate %s, expires at %s", w.clientCert, cert.Leaf.NotAfter)
+	return nil
+}
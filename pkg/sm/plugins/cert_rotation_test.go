@@ -0,0 +1,92 @@
+package plugins
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeCertificate writes self signed certificate which expires after validFor and its key to the given files
+func writeCertificate(certFile, keyFile string, validFor time.Duration) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "ib-kubernetes"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validFor),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		0600)).ToNot(HaveOccurred())
+	Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		0600)).ToNot(HaveOccurred())
+}
+
+var _ = Describe("Certificate Rotation Watcher", func() {
+	var tmpDir, certFile, keyFile string
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "certs")
+		Expect(err).ToNot(HaveOccurred())
+		certFile = filepath.Join(tmpDir, "tls.crt")
+		keyFile = filepath.Join(tmpDir, "tls.key")
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).ToNot(HaveOccurred())
+	})
+	Context("NewCertRotationWatcher", func() {
+		It("Load client certificate", func() {
+			writeCertificate(certFile, keyFile, time.Hour)
+			watcher, err := NewCertRotationWatcher(certFile, keyFile)
+			Expect(err).ToNot(HaveOccurred())
+
+			cert, err := watcher.GetClientCertificate(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Leaf.Subject.CommonName).To(Equal("ib-kubernetes"))
+			Expect(watcher.TimeUntilExpiry()).To(BeNumerically("~", time.Hour, time.Minute))
+		})
+		It("Load missing client certificate", func() {
+			watcher, err := NewCertRotationWatcher(certFile, keyFile)
+			Expect(err).To(HaveOccurred())
+			Expect(watcher).To(BeNil())
+		})
+	})
+	Context("Run", func() {
+		It("Reload client certificate when the files change", func() {
+			writeCertificate(certFile, keyFile, time.Hour)
+			watcher, err := NewCertRotationWatcher(certFile, keyFile)
+			Expect(err).ToNot(HaveOccurred())
+
+			stopChan := make(chan struct{})
+			defer close(stopChan)
+			go func() {
+				defer GinkgoRecover()
+				Expect(watcher.Run(stopChan)).ToNot(HaveOccurred())
+			}()
+			// wait until the watcher starts watching
+			time.Sleep(500 * time.Millisecond)
+
+			writeCertificate(certFile, keyFile, 2*time.Hour)
+			Eventually(watcher.TimeUntilExpiry, 5*time.Second).Should(
+				BeNumerically("~", 2*time.Hour, time.Minute))
+		})
+	})
+})
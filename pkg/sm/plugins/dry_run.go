@@ -0,0 +1,46 @@
+package plugins
+
+import (
+	"context"
+	"net"
+
+	"github.com/rs/zerolog/log"
+)
+
+// dryRunClient logs the pKey changes instead of applying them, the reads are served by the subnet manager
+type dryRunClient struct {
+	SubnetManagerClient
+}
+
+// NewDryRunClient returns subnet manager client which logs the guids it would add to and remove from the pKeys
+// without changing the subnet manager
+func NewDryRunClient(client SubnetManagerClient) SubnetManagerClient {
+	return &dryRunClient{SubnetManagerClient: client}
+}
+
+func (d *dryRunClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr,
+	membership string) error {
+	log.Info().Msgf("dry run: would add guids %v to pKey 0x%04X with %s membership with subnet manager %s", guids,
+		pkey, membership, d.Name())
+	return nil
+}
+
+func (d *dryRunClient) AddGidsToPKey(ctx context.Context, pkey int, gids []net.IP, membership string) error {
+	log.Info().Msgf("dry run: would add gids %v to pKey 0x%04X with %s membership with subnet manager %s", gids,
+		pkey, membership, d.Name())
+	return nil
+}
+
+func (d *dryRunClient) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
+	log.Info().Msgf("dry run: would remove guids %v from pKey 0x%04X with subnet manager %s", guids, pkey,
+		d.Name())
+	return nil
+}
+
+func (d *dryRunClient) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	for pkey, guids := range requests {
+		log.Info().Msgf("dry run: would remove guids %v from pKey 0x%04X with subnet manager %s", guids, pkey,
+			d.Name())
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package plugins
+
+import (
+	"context"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dry run client", func() {
+	guids := []net.HardwareAddr{{0x02, 0, 0, 0, 0, 0, 0, 0x01}}
+	It("Skip the pKey changes and serve the reads", func() {
+		smClient := newFakeSMClient("primary", nil)
+		smClient.added[0x10] = guids
+		dryRunClient := NewDryRunClient(smClient)
+
+		Expect(dryRunClient.AddGuidsToPKey(context.Background(), 0x20, guids, MembershipFull)).To(Succeed())
+		Expect(dryRunClient.RemoveGuidsFromPKey(context.Background(), 0x10, guids)).To(Succeed())
+		Expect(dryRunClient.BulkRemoveGuidsFromPKeys(context.Background(),
+			map[int][]net.HardwareAddr{0x10: guids})).To(Succeed())
+		Expect(smClient.added).To(Equal(map[int][]net.HardwareAddr{0x10: guids}))
+		Expect(smClient.removed).To(BeEmpty())
+
+		members, err := dryRunClient.GetPKeyMembership(context.Background(), 0x10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(members).To(Equal(guids))
+	})
+})
@@ -0,0 +1,78 @@
+package plugins
+
+import (
+	"context"
+	"net"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+)
+
+// dualWriteClient writes the pKey changes to a primary and a secondary subnet manager, e.g during migration from
+// one subnet manager to another. The reads are served by the primary subnet manager.
+type dualWriteClient struct {
+	SubnetManagerClient // primary subnet manager
+	secondary           SubnetManagerClient
+}
+
+// NewDualWriteClient returns subnet manager client which adds and removes guids in both the primary and the
+// secondary subnet managers. The primary result is returned, failures of the secondary subnet manager after the
+// primary succeeded are logged and counted as divergences.
+func NewDualWriteClient(primary, secondary SubnetManagerClient) SubnetManagerClient {
+	return &dualWriteClient{SubnetManagerClient: primary, secondary: secondary}
+}
+
+func (d *dualWriteClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr,
+	membership string) error {
+	if err := d.SubnetManagerClient.AddGuidsToPKey(ctx, pkey, guids, membership); err != nil {
+		return err
+	}
+
+	if err := d.secondary.AddGuidsToPKey(ctx, pkey, guids, membership); err != nil {
+		d.diverged("add guids to", pkey, err)
+	}
+	return nil
+}
+
+func (d *dualWriteClient) AddGidsToPKey(ctx context.Context, pkey int, gids []net.IP, membership string) error {
+	if err := AddGidsToPKey(ctx, d.SubnetManagerClient, pkey, gids, membership); err != nil {
+		return err
+	}
+
+	if err := AddGidsToPKey(ctx, d.secondary, pkey, gids, membership); err != nil {
+		d.diverged("add gids to", pkey, err)
+	}
+	return nil
+}
+
+func (d *dualWriteClient) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
+	if err := d.SubnetManagerClient.RemoveGuidsFromPKey(ctx, pkey, guids); err != nil {
+		return err
+	}
+
+	if err := d.secondary.RemoveGuidsFromPKey(ctx, pkey, guids); err != nil {
+		d.diverged("remove guids from", pkey, err)
+	}
+	return nil
+}
+
+func (d *dualWriteClient) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	if err := d.SubnetManagerClient.BulkRemoveGuidsFromPKeys(ctx, requests); err != nil {
+		return err
+	}
+
+	if err := d.secondary.BulkRemoveGuidsFromPKeys(ctx, requests); err != nil {
+		metrics.SMDualWriteDivergence.Inc()
+		log.Warn().Msgf("failed to bulk remove guids from pKeys with secondary subnet manager %s, "+
+			"which diverged from primary subnet manager %s, with error: %v",
+			d.secondary.Name(), d.Name(), err)
+	}
+	return nil
+}
+
+func (d *dualWriteClient) diverged(operation string, pkey int, err error) {
+	metrics.SMDualWriteDivergence.Inc()
+	log.Warn().Msgf("failed to %s pKey 0x%04X with secondary subnet manager %s, which diverged from primary "+
+		"subnet manager %s, with error: %v", operation, pkey, d.secondary.Name(), d.Name(), err)
+}
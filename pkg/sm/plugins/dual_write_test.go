@@ -0,0 +1,112 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSMClient records the guids changes and fails them with the given error
+type fakeSMClient struct {
+	name    string
+	err     error
+	added   map[int][]net.HardwareAddr
+	removed map[int][]net.HardwareAddr
+}
+
+func newFakeSMClient(name string, err error) *fakeSMClient {
+	return &fakeSMClient{name: name, err: err, added: map[int][]net.HardwareAddr{},
+		removed: map[int][]net.HardwareAddr{}}
+}
+
+func (f *fakeSMClient) Name() string    { return f.name }
+func (f *fakeSMClient) Spec() string    { return "1.0" }
+func (f *fakeSMClient) Validate() error { return nil }
+
+func (f *fakeSMClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr,
+	membership string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.added[pkey] = append(f.added[pkey], guids...)
+	return nil
+}
+
+func (f *fakeSMClient) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.removed[pkey] = append(f.removed[pkey], guids...)
+	return nil
+}
+
+func (f *fakeSMClient) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	return RemoveGuidsFromPKeys(ctx, f, requests)
+}
+
+func (f *fakeSMClient) GetPKeyMembership(ctx context.Context, pkey int) ([]net.HardwareAddr, error) {
+	return f.added[pkey], f.err
+}
+
+func (f *fakeSMClient) GetPKeyUsageStats(ctx context.Context, pkey int) (PKeyStats, error) {
+	return PKeyStats{PKey: pkey, MemberCount: len(f.added[pkey])}, f.err
+}
+
+func (f *fakeSMClient) GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error) {
+	return time.Time{}, f.err
+}
+
+func (f *fakeSMClient) PingGUID(ctx context.Context, guid net.HardwareAddr) error {
+	return f.err
+}
+
+func (f *fakeSMClient) GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (PortCapabilities, error) {
+	return PortCapabilities{}, f.err
+}
+
+func (f *fakeSMClient) GetFabricTopology(ctx context.Context) (FabricTopology, error) {
+	return FabricTopology{}, f.err
+}
+
+var _ = Describe("Dual Write Subnet Manager Client", func() {
+	guid, _ := net.ParseMAC("02:00:00:00:00:00:00:01")
+	It("Write pKey changes to both subnet managers", func() {
+		primary, secondary := newFakeSMClient("primary", nil), newFakeSMClient("secondary", nil)
+		client := NewDualWriteClient(primary, secondary)
+		Expect(client.Name()).To(Equal("primary"))
+
+		Expect(client.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid}, MembershipFull)).To(Succeed())
+		Expect(primary.added).To(Equal(map[int][]net.HardwareAddr{0x10: {guid}}))
+		Expect(secondary.added).To(Equal(map[int][]net.HardwareAddr{0x10: {guid}}))
+
+		Expect(client.BulkRemoveGuidsFromPKeys(context.Background(), map[int][]net.HardwareAddr{0x10: {guid}})).To(Succeed())
+		Expect(primary.removed).To(Equal(map[int][]net.HardwareAddr{0x10: {guid}}))
+		Expect(secondary.removed).To(Equal(map[int][]net.HardwareAddr{0x10: {guid}}))
+
+		members, err := client.GetPKeyMembership(context.Background(), 0x10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(members).To(Equal([]net.HardwareAddr{guid}))
+	})
+	It("Ignore secondary subnet manager failures", func() {
+		primary, secondary := newFakeSMClient("primary", nil), newFakeSMClient("secondary", errors.New("failed"))
+		client := NewDualWriteClient(primary, secondary)
+
+		Expect(client.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid}, MembershipFull)).To(Succeed())
+		Expect(client.RemoveGuidsFromPKey(context.Background(), 0x10, []net.HardwareAddr{guid})).To(Succeed())
+		Expect(primary.added).To(Equal(map[int][]net.HardwareAddr{0x10: {guid}}))
+		Expect(primary.removed).To(Equal(map[int][]net.HardwareAddr{0x10: {guid}}))
+	})
+	It("Fail on primary subnet manager failure", func() {
+		primary, secondary := newFakeSMClient("primary", errors.New("failed")), newFakeSMClient("secondary", nil)
+		client := NewDualWriteClient(primary, secondary)
+
+		Expect(client.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid}, MembershipFull)).ToNot(Succeed())
+		Expect(client.RemoveGuidsFromPKey(context.Background(), 0x10, []net.HardwareAddr{guid})).ToNot(Succeed())
+		Expect(secondary.added).To(BeEmpty())
+		Expect(secondary.removed).To(BeEmpty())
+	})
+})
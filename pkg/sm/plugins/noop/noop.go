@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"net"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
@@ -38,16 +40,52 @@ func (p *plugin) Validate() error {
 	return nil
 }
 
-func (p *plugin) AddGuidsToPKey(pkey int, guids []net.HardwareAddr) error {
+func (p *plugin) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr, membership string) error {
 	log.Info().Msg("noop Plugin AddPkey()")
 	return nil
 }
 
-func (p *plugin) RemoveGuidsFromPKey(pkey int, guids []net.HardwareAddr) error {
+func (p *plugin) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
 	log.Info().Msg("noop Plugin RemovePKey()")
 	return nil
 }
 
+func (p *plugin) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	log.Info().Msg("noop Plugin BulkRemoveGuidsFromPKeys()")
+	return nil
+}
+
+func (p *plugin) GetPKeyMembership(ctx context.Context, pkey int) ([]net.HardwareAddr, error) {
+	log.Info().Msg("noop Plugin GetPKeyMembership()")
+	return nil, plugins.ErrMembershipNotSupported
+}
+
+func (p *plugin) GetPKeyUsageStats(ctx context.Context, pkey int) (plugins.PKeyStats, error) {
+	log.Info().Msg("noop Plugin GetPKeyUsageStats()")
+	return plugins.PKeyStats{PKey: pkey}, nil
+}
+
+func (p *plugin) GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error) {
+	log.Info().Msg("noop Plugin GetGUIDLastActivity()")
+	// noop guids are always active so they are never evicted
+	return time.Now(), nil
+}
+
+func (p *plugin) PingGUID(ctx context.Context, guid net.HardwareAddr) error {
+	log.Info().Msg("noop Plugin PingGUID()")
+	return nil
+}
+
+func (p *plugin) GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (plugins.PortCapabilities, error) {
+	log.Info().Msg("noop Plugin GetPortCapabilities()")
+	return plugins.PortCapabilities{}, nil
+}
+
+func (p *plugin) GetFabricTopology(ctx context.Context) (plugins.FabricTopology, error) {
+	log.Info().Msg("noop Plugin GetFabricTopology()")
+	return plugins.FabricTopology{}, nil
+}
+
 // Initialize applies configs to plugin and return a subnet manager client
 func Initialize() (plugins.SubnetManagerClient, error) {
 	log.Info().Msg("Initializing noop plugin")
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
 )
 
 var _ = Describe("noop plugin", func() {
@@ -18,11 +21,22 @@ var _ = Describe("noop plugin", func() {
 			err = plugin.Validate()
 			Expect(err).ToNot(HaveOccurred())
 
-			err = plugin.AddGuidsToPKey(0, nil)
+			err = plugin.AddGuidsToPKey(context.Background(), 0, nil, plugins.MembershipFull)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = plugin.RemoveGuidsFromPKey(context.Background(), 0, nil)
 			Expect(err).ToNot(HaveOccurred())
 
-			err = plugin.RemoveGuidsFromPKey(0, nil)
+			err = plugin.BulkRemoveGuidsFromPKeys(context.Background(), nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			guids, err := plugin.GetPKeyMembership(context.Background(), 0)
+			Expect(err).To(MatchError(plugins.ErrMembershipNotSupported))
+			Expect(guids).To(BeEmpty())
+
+			stats, err := plugin.GetPKeyUsageStats(context.Background(), 0x10)
 			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.PKey).To(Equal(0x10))
 		})
 	})
 })
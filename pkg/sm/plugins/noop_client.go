@@ -0,0 +1,111 @@
+package plugins
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// NoopPluginName is the name of the built-in subnet manager plugin which doesn't need a fabric
+const NoopPluginName = "noop"
+
+// gidGUIDLength is the length in bytes of the port guid at the end of the gids
+const gidGUIDLength = 8
+
+// noopClient is a subnet manager without fabric, for development, CI and demos. It records the pKeys members in
+// memory so the added guids are reported by the membership and usage queries.
+type noopClient struct {
+	lock    sync.Mutex
+	members map[int]map[string]net.HardwareAddr // pKey members mapped by pKey and guid string
+}
+
+// NewNoopClient returns the built-in noop subnet manager client, the pKeys members are lost on restart
+func NewNoopClient() SubnetManagerClient {
+	return &noopClient{members: map[int]map[string]net.HardwareAddr{}}
+}
+
+func (n *noopClient) Name() string    { return NoopPluginName }
+func (n *noopClient) Spec() string    { return "1.0" }
+func (n *noopClient) Validate() error { return nil }
+
+func (n *noopClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr, membership string) error {
+	log.Info().Msgf("noop subnet manager: adding guids %v to pKey 0x%04X with %s membership", guids, pkey,
+		membership)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.members[pkey] == nil {
+		n.members[pkey] = map[string]net.HardwareAddr{}
+	}
+	for _, guid := range guids {
+		n.members[pkey][guid.String()] = guid
+	}
+	return nil
+}
+
+// AddGidsToPKey records the port guids of the gids, the last 8 bytes, as the pKey members
+func (n *noopClient) AddGidsToPKey(ctx context.Context, pkey int, gids []net.IP, membership string) error {
+	log.Info().Msgf("noop subnet manager: adding gids %v to pKey 0x%04X with %s membership", gids, pkey,
+		membership)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.members[pkey] == nil {
+		n.members[pkey] = map[string]net.HardwareAddr{}
+	}
+	for _, gid := range gids {
+		guid := net.HardwareAddr(gid.To16()[net.IPv6len-gidGUIDLength:])
+		n.members[pkey][guid.String()] = guid
+	}
+	return nil
+}
+
+func (n *noopClient) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
+	log.Info().Msgf("noop subnet manager: removing guids %v from pKey 0x%04X", guids, pkey)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, guid := range guids {
+		delete(n.members[pkey], guid.String())
+	}
+	return nil
+}
+
+func (n *noopClient) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	return RemoveGuidsFromPKeys(ctx, n, requests)
+}
+
+func (n *noopClient) GetPKeyMembership(ctx context.Context, pkey int) ([]net.HardwareAddr, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	members := make([]net.HardwareAddr, 0, len(n.members[pkey]))
+	for _, guid := range n.members[pkey] {
+		members = append(members, guid)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].String() < members[j].String() })
+	return members, nil
+}
+
+func (n *noopClient) GetPKeyUsageStats(ctx context.Context, pkey int) (PKeyStats, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return PKeyStats{PKey: pkey, MemberCount: len(n.members[pkey]), FullMembers: len(n.members[pkey])}, nil
+}
+
+func (n *noopClient) GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error) {
+	// noop guids are always active so they are never evicted
+	return time.Now(), nil
+}
+
+func (n *noopClient) PingGUID(ctx context.Context, guid net.HardwareAddr) error {
+	return nil
+}
+
+func (n *noopClient) GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (PortCapabilities, error) {
+	return PortCapabilities{}, nil
+}
+
+func (n *noopClient) GetFabricTopology(ctx context.Context) (FabricTopology, error) {
+	return FabricTopology{}, nil
+}
@@ -0,0 +1,43 @@
+package plugins
+
+import (
+	"context"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Noop client", func() {
+	It("Record the pKeys members in memory", func() {
+		guid1 := net.HardwareAddr{0x02, 0, 0, 0, 0, 0, 0, 0x01}
+		guid2 := net.HardwareAddr{0x02, 0, 0, 0, 0, 0, 0, 0x02}
+		client := NewNoopClient()
+		Expect(client.Name()).To(Equal(NoopPluginName))
+		Expect(client.Validate()).To(Succeed())
+
+		Expect(client.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid2, guid1},
+			MembershipFull)).To(Succeed())
+		members, err := client.GetPKeyMembership(context.Background(), 0x10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(members).To(Equal([]net.HardwareAddr{guid1, guid2}))
+
+		Expect(client.BulkRemoveGuidsFromPKeys(context.Background(),
+			map[int][]net.HardwareAddr{0x10: {guid1}})).To(Succeed())
+		stats, err := client.GetPKeyUsageStats(context.Background(), 0x10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.MemberCount).To(Equal(1))
+	})
+	It("Record the port guids of the added gids", func() {
+		gid := net.ParseIP("fe80::200:0:0:1")
+		Expect(AddGidsToPKey(context.Background(), NewNoopClient(), 0x10, []net.IP{gid}, MembershipFull)).To(
+			Succeed())
+
+		client := NewNoopClient()
+		Expect(client.(GIDPlugin).AddGidsToPKey(context.Background(), 0x10, []net.IP{gid},
+			MembershipLimited)).To(Succeed())
+		members, err := client.GetPKeyMembership(context.Background(), 0x10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(members).To(Equal([]net.HardwareAddr{{0x02, 0, 0, 0, 0, 0, 0, 0x01}}))
+	})
+})
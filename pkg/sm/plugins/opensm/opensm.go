@@ -0,0 +1,298 @@
+package opensm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/rs/zerolog/log"
+
+	ibUtils "github.com/Mellanox/ib-kubernetes/pkg/ib-utils"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+)
+
+// PluginName is the name of the OpenSM subnet manager plugin, built into the daemon
+const PluginName = "opensm"
+
+const specVersion = "1.0"
+
+type OpenSMConfig struct {
+	// Path of the OpenSM partitions file, it is locked with "<path>.lock" while edited
+	PartitionsConf string `env:"OPENSM_PARTITIONS_CONF" envDefault:"/etc/opensm/partitions.conf"`
+	// Shell command run after the partitions file is changed to reload OpenSM, no reload if empty
+	ReloadCommand string `env:"OPENSM_RELOAD_COMMAND" envDefault:"kill -HUP $(pidof opensm)"`
+}
+
+// openSMPlugin manages the guids of the pkeys in the OpenSM partitions file
+type openSMPlugin struct {
+	conf OpenSMConfig
+	// lock serializes the partitions file edits of the plugin, the file lock serializes them with other processes
+	lock sync.Mutex
+	// reloadPending is set when the partitions file was changed but OpenSM failed to reload, guarded by lock
+	reloadPending bool
+}
+
+func newOpenSMPlugin() (*openSMPlugin, error) {
+	openSMConf := OpenSMConfig{}
+	if err := env.Parse(&openSMConf); err != nil {
+		return nil, err
+	}
+
+	if openSMConf.PartitionsConf == "" {
+		return nil, fmt.Errorf("missing required field for opensm [\"partitions_conf\"]")
+	}
+	return &openSMPlugin{conf: openSMConf}, nil
+}
+
+func (o *openSMPlugin) Name() string {
+	return PluginName
+}
+
+func (o *openSMPlugin) Spec() string {
+	return specVersion
+}
+
+// Validate checks the partitions file can be read and parsed
+func (o *openSMPlugin) Validate() error {
+	_, err := o.readPartitions()
+	if err != nil {
+		return fmt.Errorf("failed to read opensm partitions file: %v", err)
+	}
+	return nil
+}
+
+func (o *openSMPlugin) AddGuidsToPKey(ctx context.Context, pKey int, guids []net.HardwareAddr,
+	membership string) error {
+	log.Debug().Msgf("adding guids %v to pKey 0x%04X with %s membership", guids, pKey, membership)
+
+	if !ibUtils.IsPKeyValid(pKey) {
+		return fmt.Errorf("invalid pkey 0x%04X, out of range 0x0001 - 0xFFFE", pKey)
+	}
+	membership, err := plugins.ParseMembership(membership)
+	if err != nil {
+		return err
+	}
+
+	err = o.updatePartitions(ctx, func(data string) (string, bool, error) {
+		return addPartitionMembers(data, pKey, guids, membership)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add guids %v to PKey 0x%04X with error: %v", guids, pKey, err)
+	}
+	return nil
+}
+
+func (o *openSMPlugin) RemoveGuidsFromPKey(ctx context.Context, pKey int, guids []net.HardwareAddr) error {
+	log.Debug().Msgf("removing guids %v pkey 0x%04X", guids, pKey)
+
+	if !ibUtils.IsPKeyValid(pKey) {
+		return fmt.Errorf("invalid pkey 0x%04X, out of range 0x0001 - 0xFFFE", pKey)
+	}
+
+	err := o.updatePartitions(ctx, func(data string) (string, bool, error) {
+		return removePartitionMembers(data, pKey, guids)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete guids %v from PKey 0x%04X, with error: %v", guids, pKey, err)
+	}
+	return nil
+}
+
+// BulkRemoveGuidsFromPKeys removes the guids of every pkey sequentially, each pkey is a partitions file edit
+func (o *openSMPlugin) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	log.Debug().Msgf("removing guids from %d pkeys", len(requests))
+	return plugins.RemoveGuidsFromPKeys(ctx, o, requests)
+}
+
+func (o *openSMPlugin) GetPKeyMembership(ctx context.Context, pKey int) ([]net.HardwareAddr, error) {
+	log.Debug().Msgf("getting guids of pkey 0x%04X", pKey)
+
+	if !ibUtils.IsPKeyValid(pKey) {
+		return nil, fmt.Errorf("invalid pkey 0x%04X, out of range 0x0001 - 0xFFFE", pKey)
+	}
+
+	partitions, err := o.readPartitions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guids of PKey 0x%04X with error: %v", pKey, err)
+	}
+
+	guids := []net.HardwareAddr{}
+	if part := findPartition(partitions, pKey); part != nil {
+		for _, member := range part.members {
+			if guid, isGUID := memberGUID(member); isGUID {
+				guids = append(guids, guid)
+			}
+		}
+	}
+	return guids, nil
+}
+
+// GetPKeyUsageStats returns the guid members of the pkey partition, members without full membership are limited
+func (o *openSMPlugin) GetPKeyUsageStats(ctx context.Context, pKey int) (plugins.PKeyStats, error) {
+	log.Debug().Msgf("getting usage stats of pkey 0x%04X", pKey)
+
+	if !ibUtils.IsPKeyValid(pKey) {
+		return plugins.PKeyStats{}, fmt.Errorf("invalid pkey 0x%04X, out of range 0x0001 - 0xFFFE", pKey)
+	}
+
+	partitions, err := o.readPartitions()
+	if err != nil {
+		return plugins.PKeyStats{}, fmt.Errorf("failed to get usage stats of PKey 0x%04X with error: %v", pKey, err)
+	}
+
+	stats := plugins.PKeyStats{PKey: pKey}
+	if part := findPartition(partitions, pKey); part != nil {
+		for _, member := range part.members {
+			if _, isGUID := memberGUID(member); !isGUID {
+				continue
+			}
+			stats.MemberCount++
+			if memberMembership(member) == plugins.MembershipFull {
+				stats.FullMembers++
+			} else {
+				stats.LimitedMembers++
+			}
+		}
+	}
+	return stats, nil
+}
+
+// The fabric queries below need the OpenSM fabric state which isn't in the partitions file
+
+func (o *openSMPlugin) GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("guid activity isn't supported by the opensm plugin")
+}
+
+func (o *openSMPlugin) PingGUID(ctx context.Context, guid net.HardwareAddr) error {
+	return fmt.Errorf("guid reachability isn't supported by the opensm plugin")
+}
+
+func (o *openSMPlugin) GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (plugins.PortCapabilities,
+	error) {
+	return plugins.PortCapabilities{}, fmt.Errorf("port capabilities aren't supported by the opensm plugin")
+}
+
+func (o *openSMPlugin) GetFabricTopology(ctx context.Context) (plugins.FabricTopology, error) {
+	return plugins.FabricTopology{}, fmt.Errorf("fabric topology isn't supported by the opensm plugin")
+}
+
+// readPartitions returns the partitions of the partitions file, read with the file shared lock
+func (o *openSMPlugin) readPartitions() ([]*partition, error) {
+	var partitions []*partition
+	err := o.withFileLock(syscall.LOCK_SH, func() error {
+		data, err := ioutil.ReadFile(o.conf.PartitionsConf)
+		if err != nil {
+			return err
+		}
+		partitions, err = parsePartitions(string(data))
+		return err
+	})
+	return partitions, err
+}
+
+// updatePartitions replaces the partitions file content with the updated content, with the file exclusive lock,
+// and reloads OpenSM if the content is changed or the reload of a previous change failed
+func (o *openSMPlugin) updatePartitions(ctx context.Context, update func(data string) (string, bool, error)) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	err := o.withFileLock(syscall.LOCK_EX, func() error {
+		data, err := ioutil.ReadFile(o.conf.PartitionsConf)
+		if err != nil {
+			return err
+		}
+		updated, changed, err := update(string(data))
+		if err != nil || !changed {
+			return err
+		}
+		if err = writeFileAtomic(o.conf.PartitionsConf, []byte(updated)); err != nil {
+			return err
+		}
+		o.reloadPending = true
+		return nil
+	})
+	if err != nil || !o.reloadPending {
+		return err
+	}
+
+	if err = o.reload(ctx); err != nil {
+		return err
+	}
+	o.reloadPending = false
+	return nil
+}
+
+// withFileLock runs fn holding the lock of the partitions lock file, how is syscall.LOCK_SH or syscall.LOCK_EX
+func (o *openSMPlugin) withFileLock(how int, fn func() error) error {
+	lockFile, err := os.OpenFile(o.conf.PartitionsConf+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open partitions lock file: %v", err)
+	}
+	defer lockFile.Close()
+
+	if err = syscall.Flock(int(lockFile.Fd()), how); err != nil {
+		return fmt.Errorf("failed to lock partitions lock file: %v", err)
+	}
+	defer func() {
+		if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN); err != nil {
+			log.Warn().Msgf("failed to unlock partitions lock file with error: %v", err)
+		}
+	}()
+	return fn()
+}
+
+// reload runs the reload command so OpenSM applies the partitions file changes
+func (o *openSMPlugin) reload(ctx context.Context) error {
+	if o.conf.ReloadCommand == "" {
+		return nil
+	}
+
+	log.Info().Msgf("reloading opensm with command %q", o.conf.ReloadCommand)
+	output, err := exec.CommandContext(ctx, "/bin/sh", "-c", o.conf.ReloadCommand).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to reload opensm: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file content with a rename, so OpenSM never reads a partially written file.
+// The file mode is kept.
+func writeFileAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpFile.Name(), info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// Initialize applies the opensm configs of the environment and returns an opensm subnet manager client
+func Initialize() (plugins.SubnetManagerClient, error) {
+	log.Info().Msg("Initializing opensm plugin")
+	return newOpenSMPlugin()
+}
@@ -0,0 +1,13 @@
+package opensm
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOpenSM(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OpenSM Subnet Manager Client Plugin Suite")
+}
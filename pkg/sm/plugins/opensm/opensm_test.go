@@ -0,0 +1,145 @@
+package opensm
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+)
+
+var _ = Describe("OpenSM Subnet Manager Client plugin", func() {
+	var tmpDir, partitionsConf, reloadsFile string
+	var plugin *openSMPlugin
+	guid1 := net.HardwareAddr{0x00, 0x02, 0xc9, 0x03, 0x00, 0x00, 0x00, 0x01}
+	guid2 := net.HardwareAddr{0x00, 0x02, 0xc9, 0x03, 0x00, 0x00, 0x00, 0x02}
+
+	// reloads returns the number of times the reload command ran
+	reloads := func() int {
+		data, err := ioutil.ReadFile(reloadsFile)
+		if os.IsNotExist(err) {
+			return 0
+		}
+		Expect(err).ToNot(HaveOccurred())
+		return strings.Count(string(data), "reload")
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "opensm")
+		Expect(err).ToNot(HaveOccurred())
+		partitionsConf = filepath.Join(tmpDir, "partitions.conf")
+		reloadsFile = filepath.Join(tmpDir, "reloads")
+		Expect(ioutil.WriteFile(partitionsConf, []byte("Default=0x7fff, ipoib : ALL=full;\n"), 0644)).To(Succeed())
+		plugin = &openSMPlugin{conf: OpenSMConfig{PartitionsConf: partitionsConf,
+			ReloadCommand: "echo reload >> " + reloadsFile}}
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	Context("Initialize", func() {
+		AfterEach(func() {
+			os.Clearenv()
+		})
+		It("Initialize opensm plugin", func() {
+			Expect(os.Setenv("OPENSM_PARTITIONS_CONF", partitionsConf)).ToNot(HaveOccurred())
+			smClient, err := Initialize()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(smClient.Name()).To(Equal("opensm"))
+			Expect(smClient.Spec()).To(Equal("1.0"))
+			Expect(smClient.(*openSMPlugin).conf.ReloadCommand).To(Equal("kill -HUP $(pidof opensm)"))
+		})
+	})
+	Context("Validate", func() {
+		It("Validate the partitions file", func() {
+			Expect(plugin.Validate()).To(Succeed())
+		})
+		It("Validate missing partitions file", func() {
+			plugin.conf.PartitionsConf = filepath.Join(tmpDir, "missing.conf")
+			Expect(plugin.Validate()).ToNot(Succeed())
+		})
+	})
+	Context("AddGuidsToPKey and RemoveGuidsFromPKey", func() {
+		It("Add and remove guids of pkey and reload opensm on changes only", func() {
+			Expect(plugin.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid1, guid2},
+				plugins.MembershipFull)).To(Succeed())
+			Expect(plugin.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid1},
+				plugins.MembershipFull)).To(Succeed())
+			Expect(reloads()).To(Equal(1))
+
+			members, err := plugin.GetPKeyMembership(context.Background(), 0x10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(members).To(Equal([]net.HardwareAddr{guid1, guid2}))
+			stats, err := plugin.GetPKeyUsageStats(context.Background(), 0x10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.MemberCount).To(Equal(2))
+			Expect(stats.FullMembers).To(Equal(2))
+
+			Expect(plugin.RemoveGuidsFromPKey(context.Background(), 0x10, []net.HardwareAddr{guid1})).To(Succeed())
+			Expect(plugin.RemoveGuidsFromPKey(context.Background(), 0x10, []net.HardwareAddr{guid1})).To(Succeed())
+			Expect(reloads()).To(Equal(2))
+			members, err = plugin.GetPKeyMembership(context.Background(), 0x10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(members).To(Equal([]net.HardwareAddr{guid2}))
+		})
+		It("Add guids of pkey with limited membership", func() {
+			Expect(plugin.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid1},
+				plugins.MembershipLimited)).To(Succeed())
+			stats, err := plugin.GetPKeyUsageStats(context.Background(), 0x10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.LimitedMembers).To(Equal(1))
+			Expect(stats.FullMembers).To(Equal(0))
+		})
+		It("Add guid to invalid pkey", func() {
+			err := plugin.AddGuidsToPKey(context.Background(), 0xFFFF, []net.HardwareAddr{guid1},
+				plugins.MembershipFull)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid pkey 0xFFFF, out of range 0x0001 - 0xFFFE"))
+		})
+		It("Retry the reload of a change after failed reload", func() {
+			plugin.conf.ReloadCommand = "exit 1"
+			Expect(plugin.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid1},
+				plugins.MembershipFull)).ToNot(Succeed())
+
+			plugin.conf.ReloadCommand = "echo reload >> " + reloadsFile
+			Expect(plugin.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid1},
+				plugins.MembershipFull)).To(Succeed())
+			Expect(reloads()).To(Equal(1))
+		})
+		It("Serialize concurrent edits of the partitions file", func() {
+			var wg sync.WaitGroup
+			for i := 1; i <= 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					defer GinkgoRecover()
+					// every client has its own process lock, so only the file lock serializes them
+					client := &openSMPlugin{conf: plugin.conf}
+					guid := net.HardwareAddr{0x00, 0x02, 0xc9, 0x03, 0x00, 0x00, 0x00, byte(i)}
+					Expect(client.AddGuidsToPKey(context.Background(), 0x10, []net.HardwareAddr{guid},
+						plugins.MembershipFull)).To(Succeed())
+				}(i)
+			}
+			wg.Wait()
+
+			members, err := plugin.GetPKeyMembership(context.Background(), 0x10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(members).To(HaveLen(10))
+		})
+	})
+	Context("Fabric queries", func() {
+		It("Fail the fabric queries which aren't supported", func() {
+			_, err := plugin.GetGUIDLastActivity(context.Background(), guid1)
+			Expect(err).To(HaveOccurred())
+			Expect(plugin.PingGUID(context.Background(), guid1)).ToNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,207 @@
+package opensm
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	ibUtils "github.com/Mellanox/ib-kubernetes/pkg/ib-utils"
+)
+
+// pKeyMask masks the membership bit of the pkeys of the partitions file
+const pKeyMask = 0x7FFF
+
+// partition is a statement of the OpenSM partitions file:
+// "<name>[=<pkey>][,<flag>...] : [<member>[=<membership>][, <member>...]] ;"
+type partition struct {
+	header  string   // name, pkey and flags of the partition as in the file, without comments
+	pKey    int      // pkey without the membership bit, -1 if the partition has no pkey
+	members []string // members as in the file, e.g "0x0002c90300000001=full" or "ALL=full"
+	start   int      // offset in the file of the statement first character
+	end     int      // offset in the file after the statement ';'
+}
+
+// parsePartitions returns the partitions of the OpenSM partitions file content
+func parsePartitions(data string) ([]*partition, error) {
+	var partitions []*partition
+	var statement strings.Builder
+	start := -1
+	inComment := false
+	for index, char := range data {
+		switch {
+		case inComment:
+			inComment = char != '\n'
+		case char == '#':
+			inComment = true
+		case char == ';':
+			if start == -1 {
+				start = index
+			}
+			part, err := parsePartition(statement.String())
+			if err != nil {
+				return nil, err
+			}
+			part.start, part.end = start, index+1
+			partitions = append(partitions, part)
+			statement.Reset()
+			start = -1
+		default:
+			if start == -1 && !isSpace(char) {
+				start = index
+			}
+			statement.WriteRune(char)
+		}
+	}
+
+	if strings.TrimSpace(statement.String()) != "" {
+		return nil, fmt.Errorf("partition statement %q isn't terminated with ';'", strings.TrimSpace(statement.String()))
+	}
+	return partitions, nil
+}
+
+// parsePartition parses a partition statement without its comments and its ';'
+func parsePartition(statement string) (*partition, error) {
+	fields := strings.SplitN(statement, ":", 2)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("partition statement %q has no ':' separator", strings.TrimSpace(statement))
+	}
+
+	part := &partition{header: strings.Join(strings.Fields(fields[0]), " "), pKey: -1}
+	// the pkey is given as the value of the first header field, "<name>=<pkey>"
+	nameAndPKey := strings.SplitN(strings.Split(part.header, ",")[0], "=", 2)
+	if len(nameAndPKey) == 2 {
+		pKey, err := strconv.ParseInt(strings.TrimSpace(nameAndPKey[1]), 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pkey of partition %q: %v", part.header, err)
+		}
+		part.pKey = int(pKey) & pKeyMask
+	}
+
+	for _, member := range strings.Split(fields[1], ",") {
+		if member = strings.Join(strings.Fields(member), ""); member != "" {
+			part.members = append(part.members, member)
+		}
+	}
+	return part, nil
+}
+
+func isSpace(char rune) bool {
+	return char == ' ' || char == '\t' || char == '\n' || char == '\r'
+}
+
+// memberGUID returns the guid of the partition member, false if the member isn't a guid, e.g "ALL"
+func memberGUID(member string) (net.HardwareAddr, bool) {
+	name := strings.SplitN(member, "=", 2)[0]
+	if !strings.HasPrefix(strings.ToLower(name), "0x") {
+		return nil, false
+	}
+	guid, err := ibUtils.StringToGUID(name)
+	if err != nil {
+		return nil, false
+	}
+	return guid, true
+}
+
+// memberMembership returns the membership of the partition member, empty if it has no membership
+func memberMembership(member string) string {
+	fields := strings.SplitN(member, "=", 2)
+	if len(fields) != 2 {
+		return ""
+	}
+	return strings.ToLower(fields[1])
+}
+
+// findPartition returns the first partition of the pkey, nil if not found
+func findPartition(partitions []*partition, pKey int) *partition {
+	for _, part := range partitions {
+		if part.pKey != -1 && part.pKey == pKey&pKeyMask {
+			return part
+		}
+	}
+	return nil
+}
+
+// guidMember returns the partition member of the guid with the membership
+func guidMember(guid net.HardwareAddr, membership string) string {
+	return fmt.Sprintf("0x%s=%s", ibUtils.GUIDToString(guid), membership)
+}
+
+// renderPartition returns the partition statement, the comments of the original statement aren't kept
+func renderPartition(header string, members []string) string {
+	return fmt.Sprintf("%s : %s;", header, strings.Join(members, ", "))
+}
+
+// addPartitionMembers returns the partitions file content with the guids added to the partition of the pkey with the
+// membership, a new partition is appended if the pkey has no partition. The existing members keep their membership.
+// It returns false if all the guids are already members.
+func addPartitionMembers(data string, pKey int, guids []net.HardwareAddr, membership string) (string, bool, error) {
+	partitions, err := parsePartitions(data)
+	if err != nil {
+		return "", false, err
+	}
+
+	part := findPartition(partitions, pKey)
+	existing := map[string]bool{}
+	if part != nil {
+		for _, member := range part.members {
+			if guid, isGUID := memberGUID(member); isGUID {
+				existing[guid.String()] = true
+			}
+		}
+	}
+
+	var added []string
+	for _, guid := range guids {
+		if !existing[guid.String()] {
+			existing[guid.String()] = true
+			added = append(added, guidMember(guid, membership))
+		}
+	}
+	if len(added) == 0 {
+		return data, false, nil
+	}
+
+	if part == nil {
+		if data != "" && !strings.HasSuffix(data, "\n") {
+			data += "\n"
+		}
+		header := fmt.Sprintf("ib_kubernetes_0x%04X=0x%04X, ipoib", pKey, pKey)
+		return data + renderPartition(header, added) + "\n", true, nil
+	}
+
+	members := append(append([]string{}, part.members...), added...)
+	return data[:part.start] + renderPartition(part.header, members) + data[part.end:], true, nil
+}
+
+// removePartitionMembers returns the partitions file content with the guids removed from the partition of the
+// pkey, the partition is kept even if it has no members left. It returns false if none of the guids is a member.
+func removePartitionMembers(data string, pKey int, guids []net.HardwareAddr) (string, bool, error) {
+	partitions, err := parsePartitions(data)
+	if err != nil {
+		return "", false, err
+	}
+
+	part := findPartition(partitions, pKey)
+	if part == nil {
+		return data, false, nil
+	}
+
+	removed := map[string]bool{}
+	for _, guid := range guids {
+		removed[guid.String()] = true
+	}
+
+	members := make([]string, 0, len(part.members))
+	for _, member := range part.members {
+		if guid, isGUID := memberGUID(member); isGUID && removed[guid.String()] {
+			continue
+		}
+		members = append(members, member)
+	}
+	if len(members) == len(part.members) {
+		return data, false, nil
+	}
+
+	return data[:part.start] + renderPartition(part.header, members) + data[part.end:], true, nil
+}
@@ -0,0 +1,87 @@
+package opensm
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Partitions file", func() {
+	guid1 := net.HardwareAddr{0x00, 0x02, 0xc9, 0x03, 0x00, 0x00, 0x00, 0x01}
+	guid2 := net.HardwareAddr{0x00, 0x02, 0xc9, 0x03, 0x00, 0x00, 0x00, 0x02}
+	const data = "# default partition\n" +
+		"Default=0x7fff, ipoib : ALL=full;\n" +
+		"storage=0x8010, ipoib, defmember=full :\n" +
+		"  0x0002c90300000001=full, # first node\n" +
+		"  ALL_SWITCHES ;\n"
+
+	Context("parsePartitions", func() {
+		It("Parse partitions with comments and multiple lines statements", func() {
+			partitions, err := parsePartitions(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(partitions).To(HaveLen(2))
+			Expect(partitions[0].header).To(Equal("Default=0x7fff, ipoib"))
+			Expect(partitions[0].pKey).To(Equal(0x7fff))
+			Expect(partitions[0].members).To(Equal([]string{"ALL=full"}))
+			Expect(partitions[1].pKey).To(Equal(0x10))
+			Expect(partitions[1].members).To(Equal([]string{"0x0002c90300000001=full", "ALL_SWITCHES"}))
+			Expect(data[partitions[1].start:partitions[1].end]).To(HavePrefix("storage="))
+		})
+		It("Fail on statement without separator", func() {
+			_, err := parsePartitions("Default=0x7fff ALL;")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Fail on statement without terminator", func() {
+			_, err := parsePartitions("Default=0x7fff : ALL")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Fail on invalid pkey", func() {
+			_, err := parsePartitions("Default=foo : ALL;")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("addPartitionMembers", func() {
+		It("Add guids to the existing partition of the pkey", func() {
+			updated, changed, err := addPartitionMembers(data, 0x10, []net.HardwareAddr{guid1, guid2}, "full")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			Expect(updated).To(Equal("# default partition\n" +
+				"Default=0x7fff, ipoib : ALL=full;\n" +
+				"storage=0x8010, ipoib, defmember=full : 0x0002c90300000001=full, ALL_SWITCHES, " +
+				"0x0002c90300000002=full;\n"))
+		})
+		It("Append a partition for a pkey without partition", func() {
+			updated, changed, err := addPartitionMembers(data, 0x20, []net.HardwareAddr{guid2}, "full")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			Expect(updated).To(Equal(data + "ib_kubernetes_0x0020=0x0020, ipoib : 0x0002c90300000002=full;\n"))
+		})
+		It("Don't change the file if the guids are already members", func() {
+			updated, changed, err := addPartitionMembers(data, 0x10, []net.HardwareAddr{guid1}, "full")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeFalse())
+			Expect(updated).To(Equal(data))
+		})
+	})
+	Context("removePartitionMembers", func() {
+		It("Remove guids from the partition of the pkey", func() {
+			updated, changed, err := removePartitionMembers(data, 0x10, []net.HardwareAddr{guid1, guid2})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			Expect(updated).To(Equal("# default partition\n" +
+				"Default=0x7fff, ipoib : ALL=full;\n" +
+				"storage=0x8010, ipoib, defmember=full : ALL_SWITCHES;\n"))
+		})
+		It("Don't change the file if the guids aren't members", func() {
+			updated, changed, err := removePartitionMembers(data, 0x10, []net.HardwareAddr{guid2})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeFalse())
+			Expect(updated).To(Equal(data))
+
+			_, changed, err = removePartitionMembers(data, 0x20, []net.HardwareAddr{guid1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeFalse())
+		})
+	})
+})
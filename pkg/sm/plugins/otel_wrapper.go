@@ -0,0 +1,133 @@
+//go:build otel
+// +build otel
+
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the subnet manager calls spans
+const tracerName = "github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+
+// otelClient adds a span to the trace of the context of every subnet manager call, the plugins propagating the
+// trace context to the subnet manager send it the context of the call span
+type otelClient struct {
+	SubnetManagerClient
+	tracer trace.Tracer
+}
+
+// NewOTelClient returns subnet manager client which traces the calls with the global OpenTelemetry tracer provider,
+// the calls aren't traced until a tracer provider is registered
+func NewOTelClient(client SubnetManagerClient) SubnetManagerClient {
+	return newOTelClient(client, otel.Tracer(tracerName))
+}
+
+func newOTelClient(client SubnetManagerClient, tracer trace.Tracer) SubnetManagerClient {
+	return &otelClient{SubnetManagerClient: client, tracer: tracer}
+}
+
+// call runs the subnet manager call with the context of its span, the span records the call error
+func (o *otelClient) call(ctx context.Context, name string, fn func(ctx context.Context) error,
+	attributes ...attribute.KeyValue) error {
+	attributes = append(attributes, attribute.String("sm.plugin", o.Name()))
+	ctx, span := o.tracer.Start(ctx, "SubnetManagerClient."+name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...))
+	defer span.End()
+
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// pKeyAttribute is the span attribute of the pKey of the call
+func pKeyAttribute(pkey int) attribute.KeyValue {
+	return attribute.String("sm.pkey", fmt.Sprintf("0x%04X", pkey))
+}
+
+func (o *otelClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr,
+	membership string) error {
+	return o.call(ctx, "AddGuidsToPKey", func(ctx context.Context) error {
+		return o.SubnetManagerClient.AddGuidsToPKey(ctx, pkey, guids, membership)
+	}, pKeyAttribute(pkey), attribute.Int("sm.guids", len(guids)))
+}
+
+func (o *otelClient) AddGidsToPKey(ctx context.Context, pkey int, gids []net.IP, membership string) error {
+	return o.call(ctx, "AddGidsToPKey", func(ctx context.Context) error {
+		return AddGidsToPKey(ctx, o.SubnetManagerClient, pkey, gids, membership)
+	}, pKeyAttribute(pkey), attribute.Int("sm.gids", len(gids)))
+}
+
+func (o *otelClient) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
+	return o.call(ctx, "RemoveGuidsFromPKey", func(ctx context.Context) error {
+		return o.SubnetManagerClient.RemoveGuidsFromPKey(ctx, pkey, guids)
+	}, pKeyAttribute(pkey), attribute.Int("sm.guids", len(guids)))
+}
+
+func (o *otelClient) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	return o.call(ctx, "BulkRemoveGuidsFromPKeys", func(ctx context.Context) error {
+		return o.SubnetManagerClient.BulkRemoveGuidsFromPKeys(ctx, requests)
+	}, attribute.Int("sm.pkeys", len(requests)))
+}
+
+func (o *otelClient) GetPKeyMembership(ctx context.Context, pkey int) ([]net.HardwareAddr, error) {
+	var members []net.HardwareAddr
+	err := o.call(ctx, "GetPKeyMembership", func(ctx context.Context) (err error) {
+		members, err = o.SubnetManagerClient.GetPKeyMembership(ctx, pkey)
+		return err
+	}, pKeyAttribute(pkey))
+	return members, err
+}
+
+func (o *otelClient) GetPKeyUsageStats(ctx context.Context, pkey int) (PKeyStats, error) {
+	var stats PKeyStats
+	err := o.call(ctx, "GetPKeyUsageStats", func(ctx context.Context) (err error) {
+		stats, err = o.SubnetManagerClient.GetPKeyUsageStats(ctx, pkey)
+		return err
+	}, pKeyAttribute(pkey))
+	return stats, err
+}
+
+func (o *otelClient) GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error) {
+	var lastActivity time.Time
+	err := o.call(ctx, "GetGUIDLastActivity", func(ctx context.Context) (err error) {
+		lastActivity, err = o.SubnetManagerClient.GetGUIDLastActivity(ctx, guid)
+		return err
+	}, attribute.String("sm.guid", guid.String()))
+	return lastActivity, err
+}
+
+func (o *otelClient) PingGUID(ctx context.Context, guid net.HardwareAddr) error {
+	return o.call(ctx, "PingGUID", func(ctx context.Context) error {
+		return o.SubnetManagerClient.PingGUID(ctx, guid)
+	}, attribute.String("sm.guid", guid.String()))
+}
+
+func (o *otelClient) GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (PortCapabilities, error) {
+	var capabilities PortCapabilities
+	err := o.call(ctx, "GetPortCapabilities", func(ctx context.Context) (err error) {
+		capabilities, err = o.SubnetManagerClient.GetPortCapabilities(ctx, guid)
+		return err
+	}, attribute.String("sm.guid", guid.String()))
+	return capabilities, err
+}
+
+func (o *otelClient) GetFabricTopology(ctx context.Context) (FabricTopology, error) {
+	var topology FabricTopology
+	err := o.call(ctx, "GetFabricTopology", func(ctx context.Context) (err error) {
+		topology, err = o.SubnetManagerClient.GetFabricTopology(ctx)
+		return err
+	})
+	return topology, err
+}
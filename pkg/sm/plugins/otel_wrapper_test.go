@@ -0,0 +1,98 @@
+//go:build otel
+// +build otel
+
+package plugins
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeSpan records the span attributes, errors and status, the other span methods are no-ops
+type fakeSpan struct {
+	trace.Span
+	name       string
+	attributes []attribute.KeyValue
+	errs       []error
+	status     codes.Code
+	ended      bool
+}
+
+func (f *fakeSpan) RecordError(err error, _ ...trace.EventOption) { f.errs = append(f.errs, err) }
+func (f *fakeSpan) SetStatus(code codes.Code, _ string)           { f.status = code }
+func (f *fakeSpan) End(_ ...trace.SpanEndOption)                  { f.ended = true }
+
+// fakeTracer records the started spans
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (f *fakeTracer) Start(ctx context.Context, name string,
+	opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	span := &fakeSpan{Span: trace.SpanFromContext(context.Background()), name: name,
+		attributes: config.Attributes()}
+	f.spans = append(f.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// contextSMClient records the span of the context of the pKey additions
+type contextSMClient struct {
+	*fakeSMClient
+	span trace.Span
+}
+
+func (c *contextSMClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr,
+	membership string) error {
+	c.span = trace.SpanFromContext(ctx)
+	return c.fakeSMClient.AddGuidsToPKey(ctx, pkey, guids, membership)
+}
+
+var _ = Describe("OpenTelemetry client", func() {
+	guids := []net.HardwareAddr{{0x02, 0, 0, 0, 0, 0, 0, 0x01}}
+	It("Add span of the call to the plugin context", func() {
+		tracer := &fakeTracer{}
+		smClient := &contextSMClient{fakeSMClient: newFakeSMClient("primary", nil)}
+		otelClient := newOTelClient(smClient, tracer)
+
+		Expect(otelClient.AddGuidsToPKey(context.Background(), 0x10, guids, MembershipFull)).To(Succeed())
+		Expect(tracer.spans).To(HaveLen(1))
+		span := tracer.spans[0]
+		Expect(span.name).To(Equal("SubnetManagerClient.AddGuidsToPKey"))
+		Expect(span.attributes).To(ContainElement(attribute.String("sm.plugin", "primary")))
+		Expect(span.attributes).To(ContainElement(attribute.String("sm.pkey", "0x0010")))
+		Expect(span.ended).To(BeTrue())
+		Expect(span.status).To(Equal(codes.Unset))
+		Expect(smClient.span).To(BeIdenticalTo(span))
+	})
+	It("Record the call error in its span", func() {
+		tracer := &fakeTracer{}
+		otelClient := newOTelClient(newFakeSMClient("primary", errors.New("failed")), tracer)
+
+		_, err := otelClient.GetPKeyMembership(context.Background(), 0x10)
+		Expect(err).To(HaveOccurred())
+		Expect(tracer.spans).To(HaveLen(1))
+		span := tracer.spans[0]
+		Expect(span.name).To(Equal("SubnetManagerClient.GetPKeyMembership"))
+		Expect(span.errs).To(Equal([]error{err}))
+		Expect(span.status).To(Equal(codes.Error))
+		Expect(span.ended).To(BeTrue())
+	})
+	It("Return the results of the call", func() {
+		tracer := &fakeTracer{}
+		otelClient := newOTelClient(newFakeSMClient("primary", nil), tracer)
+
+		Expect(otelClient.AddGuidsToPKey(context.Background(), 0x10, guids, MembershipFull)).To(Succeed())
+		members, err := otelClient.GetPKeyMembership(context.Background(), 0x10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(members).To(Equal(guids))
+		Expect(tracer.spans).To(HaveLen(2))
+	})
+})
@@ -1,7 +1,118 @@
 package plugins
 
-import "net"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
+// PKeyStats is the usage of a pkey in the subnet manager
+type PKeyStats struct {
+	PKey               int
+	MemberCount        int
+	MaxMembers         int     // maximum number of pkey members, 0 if unknown to the subnet manager
+	UtilizationPercent float64 // percent of MaxMembers used, 0 if MaxMembers is unknown
+	FullMembers        int
+	LimitedMembers     int
+}
+
+// PortCapabilities are the active speed and width of the InfiniBand port of a guid
+type PortCapabilities struct {
+	Speed string // per lane speed, SDR, DDR, QDR, FDR, EDR, HDR or NDR
+	Width string // number of lanes, 1x, 4x, 8x or 12x
+}
+
+// laneRates are the data rates in Gb/s of a single lane of the port speeds
+var laneRates = map[string]float64{
+	"SDR": 2.5, "DDR": 5, "QDR": 10, "FDR": 14, "EDR": 25, "HDR": 50, "NDR": 100,
+}
+
+// Rate returns the port data rate of all its lanes, e.g "100Gb" for EDR 4x.
+// It returns empty string if the speed or the width is unknown.
+func (c PortCapabilities) Rate() string {
+	laneRate, ok := laneRates[strings.ToUpper(c.Speed)]
+	if !ok {
+		return ""
+	}
+
+	lanes, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(c.Width), "x"))
+	if err != nil || lanes <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(laneRate*float64(lanes), 'f', -1, 64) + "Gb"
+}
+
+// FabricTopology is the InfiniBand switches of the fabric and the ports connected to them
+type FabricTopology struct {
+	Switches []Switch
+}
+
+// Switch is an InfiniBand switch and the ports of the nodes connected to it
+type Switch struct {
+	GUID  string // switch node guid
+	Name  string // switch system name, empty if unknown
+	Ports []Port
+}
+
+// Port is an InfiniBand port connected to a switch
+type Port struct {
+	GUID     string // port guid
+	NodeGUID string // guid of the node of the port
+	NodeName string // host name of the node of the port, empty if unknown to the subnet manager
+}
+
+// GetNodeSwitch returns the guid of the switch which the ports of the node with the given host name are connected
+// to, the switch with the most node ports if they are connected to several switches.
+// It returns false if no port of the node is found.
+func (t FabricTopology) GetNodeSwitch(nodeName string) (string, bool) {
+	var nodeSwitch string
+	var maxPorts int
+	for _, fabricSwitch := range t.Switches {
+		var ports int
+		for _, port := range fabricSwitch.Ports {
+			if port.NodeName != "" && port.NodeName == nodeName {
+				ports++
+			}
+		}
+
+		if ports > maxPorts {
+			nodeSwitch, maxPorts = fabricSwitch.GUID, ports
+		}
+	}
+
+	return nodeSwitch, maxPorts != 0
+}
+
+const (
+	// MembershipFull is the pkey membership of the members communicating with all the pkey members
+	MembershipFull = "full"
+	// MembershipLimited is the pkey membership of the members communicating only with the full members of the pkey
+	MembershipLimited = "limited"
+)
+
+// ParseMembership returns the pkey membership of the InfiniBand network membership, MembershipFull if empty.
+// It returns error if the membership is neither full nor limited.
+func ParseMembership(membership string) (string, error) {
+	switch strings.ToLower(membership) {
+	case "", MembershipFull:
+		return MembershipFull, nil
+	case MembershipLimited:
+		return MembershipLimited, nil
+	}
+	return "", fmt.Errorf("invalid pkey membership %q, should be %q or %q", membership, MembershipFull,
+		MembershipLimited)
+}
+
+// ErrMembershipNotSupported is returned by GetPKeyMembership of the plugins which can't report the pKeys members
+var ErrMembershipNotSupported = errors.New("pKey membership isn't supported by the subnet manager plugin")
+
+// SubnetManagerClient is the subnet manager plugin client, the context of its requests methods is passed to the
+// subnet manager requests so they are canceled with it and carry its values, e.g trace propagation.
 type SubnetManagerClient interface {
 	// Name returns the name of the plugin
 	Name() string
@@ -12,11 +123,90 @@ type SubnetManagerClient interface {
 	// Validate Check the client can reach the subnet manager and return error in case if it is not reachable.
 	Validate() error
 
-	// AddGuidsToPKey add pkey for the given guid.
+	// AddGuidsToPKey add pkey for the given guid with the given membership, MembershipFull or MembershipLimited.
 	// It return error if failed.
-	AddGuidsToPKey(pkey int, guids []net.HardwareAddr) error
+	AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr, membership string) error
 
 	// RemoveGuidsFromPKey remove guids for given pkey.
 	// It return error if failed.
-	RemoveGuidsFromPKey(pkey int, guids []net.HardwareAddr) error
+	RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error
+
+	// BulkRemoveGuidsFromPKeys remove guids from multiple pkeys in one operation, requests map pkey to its guids.
+	// Plugins without bulk operation can use RemoveGuidsFromPKeys.
+	// It return error if failed.
+	BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error
+
+	// GetPKeyMembership return the guids that are members of the given pkey.
+	// It return error if failed, ErrMembershipNotSupported if the plugin can't report the pkey members.
+	GetPKeyMembership(ctx context.Context, pkey int) ([]net.HardwareAddr, error)
+
+	// GetPKeyUsageStats return the usage stats of the given pkey.
+	// It return error if failed.
+	GetPKeyUsageStats(ctx context.Context, pkey int) (PKeyStats, error)
+
+	// GetGUIDLastActivity return the last fabric activity time of the given guid, zero time if it was never active.
+	// It return error if failed.
+	GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error)
+
+	// PingGUID checks the given guid is reachable on the InfiniBand fabric.
+	// It return error if the guid is unreachable or failed.
+	PingGUID(ctx context.Context, guid net.HardwareAddr) error
+
+	// GetPortCapabilities return the speed and width of the InfiniBand port of the given guid.
+	// It return error if failed.
+	GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (PortCapabilities, error)
+
+	// GetFabricTopology return the switches of the fabric and the ports connected to them.
+	// It return error if failed.
+	GetFabricTopology(ctx context.Context) (FabricTopology, error)
+}
+
+// ConfigurablePlugin is implemented by the subnet manager plugins receiving their configuration from the daemon,
+// e.g the credentials of a mounted Secret, instead of reading it themselves
+type ConfigurablePlugin interface {
+	// Init applies the content of the plugin configuration file, it is called right after the plugin is
+	// initialized and before Validate, only if the configuration file exists.
+	// It return error if the configuration is invalid.
+	Init(conf []byte) error
+}
+
+// GIDPlugin is implemented by the subnet manager plugins identifying the pKey members by their gid, the subnet
+// prefix followed by the port guid, instead of the guid only
+type GIDPlugin interface {
+	// AddGidsToPKey add pkey for the given gids with the given membership, MembershipFull or MembershipLimited.
+	// It return error if failed.
+	AddGidsToPKey(ctx context.Context, pkey int, gids []net.IP, membership string) error
+}
+
+// AddGidsToPKey adds the gids to the pkey with the client if it implements GIDPlugin.
+// It returns error if the client doesn't implement GIDPlugin or failed.
+func AddGidsToPKey(ctx context.Context, client SubnetManagerClient, pkey int, gids []net.IP,
+	membership string) error {
+	gidClient, ok := client.(GIDPlugin)
+	if !ok {
+		return fmt.Errorf("subnet manager plugin %s doesn't support adding gids to pkeys", client.Name())
+	}
+	return gidClient.AddGidsToPKey(ctx, pkey, gids, membership)
+}
+
+// RemoveGuidsFromPKeys is the default BulkRemoveGuidsFromPKeys implementation, it removes the guids of every pkey
+// with sequential RemoveGuidsFromPKey calls. It returns error of all the failed pkeys.
+func RemoveGuidsFromPKeys(ctx context.Context, client SubnetManagerClient, requests map[int][]net.HardwareAddr) error {
+	pKeys := make([]int, 0, len(requests))
+	for pKey := range requests {
+		pKeys = append(pKeys, pKey)
+	}
+	sort.Ints(pKeys)
+
+	var errs []string
+	for _, pKey := range pKeys {
+		if err := client.RemoveGuidsFromPKey(ctx, pKey, requests[pKey]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to remove guids from pkeys: %s", strings.Join(errs, "; "))
+	}
+	return nil
 }
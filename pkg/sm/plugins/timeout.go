@@ -0,0 +1,133 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// timeoutClient bounds every subnet manager call with a timeout, so the daemon recovers when the subnet manager
+// doesn't respond. The calls of plugins which ignore their context are abandoned when the timeout expires.
+type timeoutClient struct {
+	SubnetManagerClient
+	timeout time.Duration
+}
+
+// NewTimeoutClient returns subnet manager client which fails the calls which don't return within the timeout,
+// the context of the calls is cancelled when the timeout expires
+func NewTimeoutClient(client SubnetManagerClient, timeout time.Duration) SubnetManagerClient {
+	return &timeoutClient{SubnetManagerClient: client, timeout: timeout}
+}
+
+// call runs the subnet manager call with the timeout context, it returns when the call returns or the context is done
+func (t *timeoutClient) call(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	// buffered so the call goroutine doesn't leak if the context is done first
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- fn(ctx)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("subnet manager %s call %s failed: %v", t.Name(), name, ctx.Err())
+	}
+}
+
+func (t *timeoutClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr,
+	membership string) error {
+	return t.call(ctx, "AddGuidsToPKey", func(ctx context.Context) error {
+		return t.SubnetManagerClient.AddGuidsToPKey(ctx, pkey, guids, membership)
+	})
+}
+
+func (t *timeoutClient) AddGidsToPKey(ctx context.Context, pkey int, gids []net.IP, membership string) error {
+	return t.call(ctx, "AddGidsToPKey", func(ctx context.Context) error {
+		return AddGidsToPKey(ctx, t.SubnetManagerClient, pkey, gids, membership)
+	})
+}
+
+func (t *timeoutClient) RemoveGuidsFromPKey(ctx context.Context, pkey int, guids []net.HardwareAddr) error {
+	return t.call(ctx, "RemoveGuidsFromPKey", func(ctx context.Context) error {
+		return t.SubnetManagerClient.RemoveGuidsFromPKey(ctx, pkey, guids)
+	})
+}
+
+func (t *timeoutClient) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	return t.call(ctx, "BulkRemoveGuidsFromPKeys", func(ctx context.Context) error {
+		return t.SubnetManagerClient.BulkRemoveGuidsFromPKeys(ctx, requests)
+	})
+}
+
+// The results of the calls below are read only if the call returned, an abandoned call may still set them
+
+func (t *timeoutClient) GetPKeyMembership(ctx context.Context, pkey int) ([]net.HardwareAddr, error) {
+	var members []net.HardwareAddr
+	err := t.call(ctx, "GetPKeyMembership", func(ctx context.Context) (err error) {
+		members, err = t.SubnetManagerClient.GetPKeyMembership(ctx, pkey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (t *timeoutClient) GetPKeyUsageStats(ctx context.Context, pkey int) (PKeyStats, error) {
+	var stats PKeyStats
+	err := t.call(ctx, "GetPKeyUsageStats", func(ctx context.Context) (err error) {
+		stats, err = t.SubnetManagerClient.GetPKeyUsageStats(ctx, pkey)
+		return err
+	})
+	if err != nil {
+		return PKeyStats{}, err
+	}
+	return stats, nil
+}
+
+func (t *timeoutClient) GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error) {
+	var lastActivity time.Time
+	err := t.call(ctx, "GetGUIDLastActivity", func(ctx context.Context) (err error) {
+		lastActivity, err = t.SubnetManagerClient.GetGUIDLastActivity(ctx, guid)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lastActivity, nil
+}
+
+func (t *timeoutClient) PingGUID(ctx context.Context, guid net.HardwareAddr) error {
+	return t.call(ctx, "PingGUID", func(ctx context.Context) error {
+		return t.SubnetManagerClient.PingGUID(ctx, guid)
+	})
+}
+
+func (t *timeoutClient) GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (PortCapabilities, error) {
+	var capabilities PortCapabilities
+	err := t.call(ctx, "GetPortCapabilities", func(ctx context.Context) (err error) {
+		capabilities, err = t.SubnetManagerClient.GetPortCapabilities(ctx, guid)
+		return err
+	})
+	if err != nil {
+		return PortCapabilities{}, err
+	}
+	return capabilities, nil
+}
+
+func (t *timeoutClient) GetFabricTopology(ctx context.Context) (FabricTopology, error) {
+	var topology FabricTopology
+	err := t.call(ctx, "GetFabricTopology", func(ctx context.Context) (err error) {
+		topology, err = t.SubnetManagerClient.GetFabricTopology(ctx)
+		return err
+	})
+	if err != nil {
+		return FabricTopology{}, err
+	}
+	return topology, nil
+}
@@ -0,0 +1,56 @@
+package plugins
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// blockingSMClient blocks the pKey additions until released, ignoring their context
+type blockingSMClient struct {
+	*fakeSMClient
+	release chan struct{}
+}
+
+func (b *blockingSMClient) AddGuidsToPKey(ctx context.Context, pkey int, guids []net.HardwareAddr,
+	membership string) error {
+	<-b.release
+	return nil
+}
+
+var _ = Describe("Timeout client", func() {
+	guids := []net.HardwareAddr{{0x02, 0, 0, 0, 0, 0, 0, 0x01}}
+	It("Pass the calls which return within the timeout", func() {
+		smClient := newFakeSMClient("primary", nil)
+		timeoutClient := NewTimeoutClient(smClient, time.Second)
+
+		Expect(timeoutClient.AddGuidsToPKey(context.Background(), 0x10, guids, MembershipFull)).To(Succeed())
+		members, err := timeoutClient.GetPKeyMembership(context.Background(), 0x10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(members).To(Equal(guids))
+	})
+	It("Fail the calls which ignore their context after the timeout", func() {
+		smClient := &blockingSMClient{fakeSMClient: newFakeSMClient("primary", nil), release: make(chan struct{})}
+		defer close(smClient.release)
+		timeoutClient := NewTimeoutClient(smClient, 10*time.Millisecond)
+
+		start := time.Now()
+		err := timeoutClient.AddGuidsToPKey(context.Background(), 0x10, guids, MembershipFull)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(context.DeadlineExceeded.Error()))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+	It("Add gids only with plugins supporting gids", func() {
+		gids := []net.IP{net.ParseIP("fe80::200:0:0:1")}
+		Expect(AddGidsToPKey(context.Background(), NewTimeoutClient(NewNoopClient(), time.Second), 0x10, gids,
+			MembershipFull)).To(Succeed())
+
+		err := NewTimeoutClient(newFakeSMClient("primary", nil), time.Second).(GIDPlugin).AddGidsToPKey(
+			context.Background(), 0x10, gids, MembershipFull)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("doesn't support adding gids"))
+	})
+})
@@ -0,0 +1,17 @@
+//go:build otel
+// +build otel
+
+package ufm
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// injectTraceContext sets the trace context headers of the ufm request from its context with the global propagator,
+// so the ufm operations are part of the daemon traces
+func injectTraceContext(req *http.Request) {
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+}
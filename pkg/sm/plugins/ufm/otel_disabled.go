@@ -0,0 +1,12 @@
+//go:build !otel
+// +build !otel
+
+package ufm
+
+import (
+	"net/http"
+)
+
+// injectTraceContext sends the ufm request unchanged, the trace context is propagated only by builds with the otel
+// tag
+func injectTraceContext(_ *http.Request) {}
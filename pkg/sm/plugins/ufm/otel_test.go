@@ -0,0 +1,46 @@
+//go:build otel
+// +build otel
+
+package ufm
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("Ufm trace context propagation", func() {
+	var propagator propagation.TextMapPropagator
+	BeforeEach(func() {
+		propagator = otel.GetTextMapPropagator()
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	AfterEach(func() {
+		otel.SetTextMapPropagator(propagator)
+	})
+	It("Inject the trace context of the request context into its headers", func() {
+		traceID, err := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+		Expect(err).ToNot(HaveOccurred())
+		spanID, err := trace.SpanIDFromHex("0102030405060708")
+		Expect(err).ToNot(HaveOccurred())
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled}))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://1.1.1.1/ufmRest/resources/pkeys", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		injectTraceContext(req)
+		Expect(req.Header.Get("traceparent")).To(Equal("00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01"))
+	})
+	It("Don't set trace headers without trace context", func() {
+		req, err := http.NewRequest(http.MethodGet, "http://1.1.1.1/ufmRest/resources/pkeys", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		injectTraceContext(req)
+		Expect(req.Header.Get("traceparent")).To(BeEmpty())
+	})
+})
@@ -1,28 +1,33 @@
-package main
+package ufm
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/rs/zerolog/log"
 
 	httpDriver "github.com/Mellanox/ib-kubernetes/pkg/drivers/http"
 	ibUtils "github.com/Mellanox/ib-kubernetes/pkg/ib-utils"
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
 	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
 )
 
 type ufmPlugin struct {
+	plugins.BaseSMClient
 	PluginName  string
 	SpecVersion string
 	conf        UFMConfig
-	client      httpDriver.Client
 }
 
+// PluginName is the name of the ufm subnet manager plugin, built into the daemon
+const PluginName = "ufm"
+
 const (
-	pluginName  = "ufm"
 	specVersion = "1.0"
 	httpsProto  = "https"
 )
@@ -34,6 +39,12 @@ type UFMConfig struct {
 	Port        int    `env:"UFM_PORT"`        // REST API port of ufm
 	HTTPSchema  string `env:"UFM_HTTP_SCHEMA"` // http or https
 	Certificate string `env:"UFM_CERTIFICATE"` // Certificate of ufm
+	// Service account as <namespace>/<name> to authenticate to ufm with its tokens instead of username and password
+	ServiceAccount string `env:"UFM_SERVICE_ACCOUNT"`
+	// Expiration in seconds of the requested service account tokens
+	TokenExpiration int64  `env:"UFM_SERVICE_ACCOUNT_TOKEN_EXPIRATION" envDefault:"3600"`
+	ClientCert      string `env:"UFM_CLIENT_CERT"` // Path of client certificate file for mutual tls
+	ClientKey       string `env:"UFM_CLIENT_KEY"`  // Path of client key file for mutual tls
 }
 
 func newUfmPlugin() (*ufmPlugin, error) {
@@ -42,7 +53,7 @@ func newUfmPlugin() (*ufmPlugin, error) {
 		return nil, err
 	}
 
-	if ufmConf.Username == "" || ufmConf.Password == "" || ufmConf.Address == "" {
+	if ufmConf.ServiceAccount == "" && (ufmConf.Username == "" || ufmConf.Password == "") || ufmConf.Address == "" {
 		return nil, fmt.Errorf("missing one or more required fileds for ufm [\"username\", \"password\", \"address\"]")
 	}
 
@@ -60,15 +71,77 @@ func newUfmPlugin() (*ufmPlugin, error) {
 	}
 
 	isSecure := strings.EqualFold(ufmConf.HTTPSchema, httpsProto)
-	auth := &httpDriver.BasicAuth{Username: ufmConf.Username, Password: ufmConf.Password}
-	client, err := httpDriver.NewClient(isSecure, auth, ufmConf.Certificate)
+	getClientCert, err := newClientCertificateFunc(&ufmConf)
+	if err != nil {
+		return nil, err
+	}
+
+	var client httpDriver.ContextClient
+	if ufmConf.ServiceAccount != "" {
+		client, err = newServiceAccountClient(&ufmConf, isSecure, getClientCert)
+	} else {
+		auth := &httpDriver.BasicAuth{Username: ufmConf.Username, Password: ufmConf.Password}
+		client, err = httpDriver.NewClient(isSecure, auth, ufmConf.Certificate, getClientCert)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create http client err: %v", err)
 	}
-	return &ufmPlugin{PluginName: pluginName,
+	client.SetRequestEditor(injectTraceContext)
+	return &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Config: plugins.DefaultBaseConfig, Client: client},
+		PluginName:  PluginName,
 		SpecVersion: specVersion,
-		conf:        ufmConf,
-		client:      client}, nil
+		conf:        ufmConf}, nil
+}
+
+// newServiceAccountClient returns http client authenticated with short-lived tokens of the configured service account
+func newServiceAccountClient(ufmConf *UFMConfig, isSecure bool,
+	getClientCert httpDriver.GetClientCertificateFunc) (httpDriver.ContextClient, error) {
+	const expectedLen = 2
+	serviceAccount := strings.Split(ufmConf.ServiceAccount, "/")
+	if len(serviceAccount) != expectedLen || serviceAccount[0] == "" || serviceAccount[1] == "" {
+		return nil, fmt.Errorf("invalid service account %s, should be <namespace>/<name>", ufmConf.ServiceAccount)
+	}
+	if ufmConf.TokenExpiration <= 0 {
+		return nil, fmt.Errorf("invalid service account token expiration %d", ufmConf.TokenExpiration)
+	}
+
+	kubeClient, err := k8sClient.NewK8sClientWithTokenExpiration(ufmConf.TokenExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, name := serviceAccount[0], serviceAccount[1]
+	if _, err = kubeClient.GetServiceAccount(namespace, name); err != nil {
+		return nil, fmt.Errorf("failed to get service account %s: %v", ufmConf.ServiceAccount, err)
+	}
+
+	return httpDriver.NewTokenClient(isSecure, func() (string, error) {
+		return kubeClient.GetServiceAccountToken(namespace, name)
+	}, ufmConf.Certificate, getClientCert)
+}
+
+// newClientCertificateFunc returns the client certificate callback of the configured client certificate,
+// the certificate is reloaded when its files change. It returns nil if no client certificate is configured.
+func newClientCertificateFunc(ufmConf *UFMConfig) (httpDriver.GetClientCertificateFunc, error) {
+	if ufmConf.ClientCert == "" && ufmConf.ClientKey == "" {
+		return nil, nil
+	}
+	if ufmConf.ClientCert == "" || ufmConf.ClientKey == "" {
+		return nil, fmt.Errorf("both client certificate and client key are required for ufm mutual tls")
+	}
+
+	certWatcher, err := plugins.NewCertRotationWatcher(ufmConf.ClientCert, ufmConf.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// the watcher runs for the plugin lifetime
+	go func() {
+		if runErr := certWatcher.Run(nil); runErr != nil {
+			log.Error().Msgf("failed to watch ufm client certificate with error: %v", runErr)
+		}
+	}()
+	return certWatcher.GetClientCertificate, nil
 }
 
 func (u *ufmPlugin) Name() string {
@@ -80,8 +153,7 @@ func (u *ufmPlugin) Spec() string {
 }
 
 func (u *ufmPlugin) Validate() error {
-	_, err := u.client.Get(u.buildURL("/ufmRest/app/ufm_version"), http.StatusOK)
-
+	err := u.DoWithRetry(context.Background(), http.MethodGet, u.buildURL("/ufmRest/app/ufm_version"), nil, nil)
 	if err != nil {
 		return fmt.Errorf("failed to connect to ufm subnet manager: %v", err)
 	}
@@ -89,55 +161,247 @@ func (u *ufmPlugin) Validate() error {
 	return nil
 }
 
-func (u *ufmPlugin) AddGuidsToPKey(pKey int, guids []net.HardwareAddr) error {
-	log.Debug().Msgf("adding guids %v to pKey 0x%04X", guids, pKey)
+func (u *ufmPlugin) AddGuidsToPKey(ctx context.Context, pKey int, guids []net.HardwareAddr, membership string) error {
+	log.Debug().Msgf("adding guids %v to pKey 0x%04X with %s membership", guids, pKey, membership)
 
 	if !ibUtils.IsPKeyValid(pKey) {
 		return fmt.Errorf("invalid pkey 0x%04X, out of range 0x0001 - 0xFFFE", pKey)
 	}
-
-	guidsString := make([]string, 0, len(guids))
-	for _, guid := range guids {
-		guidAddr := ibUtils.GUIDToString(guid)
-		guidsString = append(guidsString, fmt.Sprintf("%q", guidAddr))
+	membership, err := plugins.ParseMembership(membership)
+	if err != nil {
+		return err
 	}
-	data := []byte(fmt.Sprintf(
-		`{"pkey": "0x%04X", "index0": true, "ip_over_ib": true, "membership": "full", "guids": [%v]}`,
-		pKey, strings.Join(guidsString, ",")))
 
-	if _, err := u.client.Post(u.buildURL("/ufmRest/resources/pkeys"), http.StatusOK, data); err != nil {
+	data := &addGUIDsData{PKey: fmt.Sprintf("0x%04X", pKey), Index0: true, IPOverIB: true, Membership: membership,
+		GUIDs: guidsToStrings(guids)}
+	if err := u.DoWithRetry(ctx, http.MethodPost, u.buildURL("/ufmRest/resources/pkeys"),
+		data, nil); err != nil {
 		return fmt.Errorf("failed to add guids %v to PKey 0x%04X with error: %v", guids, pKey, err)
 	}
 
 	return nil
 }
 
-func (u *ufmPlugin) RemoveGuidsFromPKey(pKey int, guids []net.HardwareAddr) error {
+func (u *ufmPlugin) RemoveGuidsFromPKey(ctx context.Context, pKey int, guids []net.HardwareAddr) error {
 	log.Debug().Msgf("removing guids %v pkey 0x%04X", guids, pKey)
 
 	if !ibUtils.IsPKeyValid(pKey) {
 		return fmt.Errorf("invalid pkey 0x%04X, out of range 0x0001 - 0xFFFE", pKey)
 	}
 
+	data := &removeGUIDsData{PKey: fmt.Sprintf("0x%04X", pKey), GUIDs: guidsToStrings(guids)}
+	if err := u.DoWithRetry(ctx, http.MethodPost,
+		u.buildURL("/ufmRest/actions/remove_guids_from_pkey"), data, nil); err != nil {
+		return fmt.Errorf("failed to delete guids %v from PKey 0x%04X, with error: %v", guids, pKey, err)
+	}
+
+	return nil
+}
+
+type addGUIDsData struct {
+	PKey       string   `json:"pkey"`
+	Index0     bool     `json:"index0"`
+	IPOverIB   bool     `json:"ip_over_ib"`
+	Membership string   `json:"membership"`
+	GUIDs      []string `json:"guids"`
+}
+
+type removeGUIDsData struct {
+	PKey  string   `json:"pkey"`
+	GUIDs []string `json:"guids"`
+}
+
+// guidsToStrings returns the guids in the ufm format
+func guidsToStrings(guids []net.HardwareAddr) []string {
 	guidsString := make([]string, 0, len(guids))
 	for _, guid := range guids {
-		guidAddr := ibUtils.GUIDToString(guid)
-		guidsString = append(guidsString, fmt.Sprintf("%q", guidAddr))
+		guidsString = append(guidsString, ibUtils.GUIDToString(guid))
+	}
+	return guidsString
+}
+
+// BulkRemoveGuidsFromPKeys removes the guids of every pkey sequentially, ufm has no bulk remove operation
+func (u *ufmPlugin) BulkRemoveGuidsFromPKeys(ctx context.Context, requests map[int][]net.HardwareAddr) error {
+	log.Debug().Msgf("removing guids from %d pkeys", len(requests))
+	return plugins.RemoveGuidsFromPKeys(ctx, u, requests)
+}
+
+type pKeyGUIDData struct {
+	GUID string `json:"guid"`
+}
+
+type pKeyData struct {
+	GUIDs []pKeyGUIDData `json:"guids"`
+}
+
+func (u *ufmPlugin) GetPKeyMembership(ctx context.Context, pKey int) ([]net.HardwareAddr, error) {
+	log.Debug().Msgf("getting guids of pkey 0x%04X", pKey)
+
+	if !ibUtils.IsPKeyValid(pKey) {
+		return nil, fmt.Errorf("invalid pkey 0x%04X, out of range 0x0001 - 0xFFFE", pKey)
 	}
-	data := []byte(fmt.Sprintf(`{"pkey": "0x%04X", "guids": [%v]}`, pKey, strings.Join(guidsString, ",")))
 
-	if _, err := u.client.Post(u.buildURL("/ufmRest/actions/remove_guids_from_pkey"), http.StatusOK, data); err != nil {
-		return fmt.Errorf("failed to delete guids %v from PKey 0x%04X, with error: %v", guids, pKey, err)
+	pKeyInfo := &pKeyData{}
+	err := u.DoWithRetry(ctx, http.MethodGet,
+		u.buildURL(fmt.Sprintf("/ufmRest/resources/pkeys/0x%04X?guids_data=true", pKey)), nil, pKeyInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guids of PKey 0x%04X with error: %v", pKey, err)
+	}
+
+	guids := make([]net.HardwareAddr, 0, len(pKeyInfo.GUIDs))
+	for _, guidData := range pKeyInfo.GUIDs {
+		guidAddr, err := ibUtils.StringToGUID(guidData.GUID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse guid of PKey 0x%04X with error: %v", pKey, err)
+		}
+		guids = append(guids, guidAddr)
 	}
 
+	return guids, nil
+}
+
+type pKeyStatsData struct {
+	MemberCount    int `json:"members_count"`
+	MaxMembers     int `json:"max_members"`
+	FullMembers    int `json:"full_members"`
+	LimitedMembers int `json:"limited_members"`
+}
+
+func (u *ufmPlugin) GetPKeyUsageStats(ctx context.Context, pKey int) (plugins.PKeyStats, error) {
+	log.Debug().Msgf("getting usage stats of pkey 0x%04X", pKey)
+
+	if !ibUtils.IsPKeyValid(pKey) {
+		return plugins.PKeyStats{}, fmt.Errorf("invalid pkey 0x%04X, out of range 0x0001 - 0xFFFE", pKey)
+	}
+
+	statsData := &pKeyStatsData{}
+	err := u.DoWithRetry(ctx, http.MethodGet,
+		u.buildURL(fmt.Sprintf("/ufmRest/app/pkeys/0x%04X/stats", pKey)), nil, statsData)
+	if err != nil {
+		return plugins.PKeyStats{}, fmt.Errorf("failed to get usage stats of PKey 0x%04X with error: %v", pKey, err)
+	}
+
+	stats := plugins.PKeyStats{
+		PKey:           pKey,
+		MemberCount:    statsData.MemberCount,
+		MaxMembers:     statsData.MaxMembers,
+		FullMembers:    statsData.FullMembers,
+		LimitedMembers: statsData.LimitedMembers,
+	}
+	if stats.MaxMembers > 0 {
+		stats.UtilizationPercent = float64(stats.MemberCount) * 100 / float64(stats.MaxMembers)
+	}
+	return stats, nil
+}
+
+type guidActivityData struct {
+	LastActivity string `json:"last_activity"`
+}
+
+func (u *ufmPlugin) GetGUIDLastActivity(ctx context.Context, guid net.HardwareAddr) (time.Time, error) {
+	log.Debug().Msgf("getting last activity of guid %s", guid)
+
+	activityData := &guidActivityData{}
+	err := u.DoWithRetry(ctx, http.MethodGet,
+		u.buildURL(fmt.Sprintf("/ufmRest/app/guids/%s/activity", ibUtils.GUIDToString(guid))), nil, activityData)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last activity of guid %s with error: %v", guid, err)
+	}
+
+	if activityData.LastActivity == "" {
+		return time.Time{}, nil
+	}
+
+	lastActivity, err := time.Parse(time.RFC3339, activityData.LastActivity)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse last activity of guid %s with error: %v", guid, err)
+	}
+	return lastActivity, nil
+}
+
+type guidPingData struct {
+	Reachable bool `json:"reachable"`
+}
+
+func (u *ufmPlugin) PingGUID(ctx context.Context, guid net.HardwareAddr) error {
+	log.Debug().Msgf("pinging guid %s", guid)
+
+	pingData := &guidPingData{}
+	err := u.DoWithRetry(ctx, http.MethodPost,
+		u.buildURL(fmt.Sprintf("/ufmRest/app/guids/%s/ping", ibUtils.GUIDToString(guid))), nil, pingData)
+	if err != nil {
+		return fmt.Errorf("failed to ping guid %s with error: %v", guid, err)
+	}
+
+	if !pingData.Reachable {
+		return fmt.Errorf("guid %s is unreachable on the fabric", guid)
+	}
 	return nil
 }
 
+type portCapabilitiesData struct {
+	ActiveSpeed string `json:"active_speed"`
+	ActiveWidth string `json:"active_width"`
+}
+
+func (u *ufmPlugin) GetPortCapabilities(ctx context.Context, guid net.HardwareAddr) (plugins.PortCapabilities, error) {
+	log.Debug().Msgf("getting port capabilities of guid %s", guid)
+
+	portData := &portCapabilitiesData{}
+	err := u.DoWithRetry(ctx, http.MethodGet,
+		u.buildURL(fmt.Sprintf("/ufmRest/app/guids/%s/port", ibUtils.GUIDToString(guid))), nil, portData)
+	if err != nil {
+		return plugins.PortCapabilities{}, fmt.Errorf("failed to get port capabilities of guid %s with error: %v",
+			guid, err)
+	}
+
+	return plugins.PortCapabilities{Speed: portData.ActiveSpeed, Width: portData.ActiveWidth}, nil
+}
+
+type topologyPortData struct {
+	GUID       string `json:"guid"`
+	NodeGUID   string `json:"node_guid"`
+	SystemName string `json:"system_name"`
+}
+
+type topologySwitchData struct {
+	GUID       string             `json:"guid"`
+	SystemName string             `json:"system_name"`
+	Ports      []topologyPortData `json:"ports"`
+}
+
+type topologyData struct {
+	Switches []topologySwitchData `json:"switches"`
+}
+
+func (u *ufmPlugin) GetFabricTopology(ctx context.Context) (plugins.FabricTopology, error) {
+	log.Debug().Msg("getting fabric topology")
+
+	data := &topologyData{}
+	if err := u.DoWithRetry(ctx, http.MethodGet, u.buildURL("/ufmRest/app/topology"), nil,
+		data); err != nil {
+		return plugins.FabricTopology{}, fmt.Errorf("failed to get fabric topology with error: %v", err)
+	}
+
+	topology := plugins.FabricTopology{Switches: make([]plugins.Switch, 0, len(data.Switches))}
+	for _, switchData := range data.Switches {
+		fabricSwitch := plugins.Switch{GUID: switchData.GUID, Name: switchData.SystemName,
+			Ports: make([]plugins.Port, 0, len(switchData.Ports))}
+		for _, portData := range switchData.Ports {
+			fabricSwitch.Ports = append(fabricSwitch.Ports, plugins.Port{GUID: portData.GUID,
+				NodeGUID: portData.NodeGUID, NodeName: portData.SystemName})
+		}
+		topology.Switches = append(topology.Switches, fabricSwitch)
+	}
+
+	return topology, nil
+}
+
 func (u *ufmPlugin) buildURL(path string) string {
 	return fmt.Sprintf("%s://%s:%d%s", u.conf.HTTPSchema, u.conf.Address, u.conf.Port, path)
 }
 
-// Initialize applies configs to plugin and return a subnet manager client
+// Initialize applies the ufm configs of the environment and returns a ufm subnet manager client
 func Initialize() (plugins.SubnetManagerClient, error) {
 	log.Info().Msg("Initializing ufm plugin")
 	return newUfmPlugin()
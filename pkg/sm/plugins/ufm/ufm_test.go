@@ -1,16 +1,20 @@
-package main
+package ufm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/Mellanox/ib-kubernetes/pkg/drivers/http/mocks"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
 )
 
 var _ = Describe("Ufm Subnet Manager Client plugin", func() {
@@ -56,13 +60,30 @@ var _ = Describe("Ufm Subnet Manager Client plugin", func() {
 			Expect(err.Error()).To(Equal(`missing one or more required fileds for ufm ["username", "password", "address"]`))
 			Expect(plugin).To(BeNil())
 		})
+		It("newUfmPlugin with invalid service account config", func() {
+			Expect(os.Setenv("UFM_ADDRESS", "1.1.1.1")).ToNot(HaveOccurred())
+			Expect(os.Setenv("UFM_SERVICE_ACCOUNT", "ufm-sa")).ToNot(HaveOccurred())
+			plugin, err := newUfmPlugin()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(
+				"failed to create http client err: invalid service account ufm-sa, should be <namespace>/<name>"))
+			Expect(plugin).To(BeNil())
+		})
+		It("newUfmPlugin with service account and invalid token expiration config", func() {
+			Expect(os.Setenv("UFM_ADDRESS", "1.1.1.1")).ToNot(HaveOccurred())
+			Expect(os.Setenv("UFM_SERVICE_ACCOUNT", "kube-system/ufm-sa")).ToNot(HaveOccurred())
+			Expect(os.Setenv("UFM_SERVICE_ACCOUNT_TOKEN_EXPIRATION", "0")).ToNot(HaveOccurred())
+			plugin, err := newUfmPlugin()
+			Expect(err).To(HaveOccurred())
+			Expect(plugin).To(BeNil())
+		})
 	})
 	Context("Validate", func() {
 		It("Validate connection to ufm", func() {
 			client := &mocks.Client{}
 			client.On("Get", mock.Anything, mock.Anything).Return(nil, nil)
 
-			plugin := &ufmPlugin{client: client, conf: UFMConfig{}}
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
 			err := plugin.Validate()
 			Expect(err).ToNot(HaveOccurred())
 		})
@@ -70,7 +91,7 @@ var _ = Describe("Ufm Subnet Manager Client plugin", func() {
 			client := &mocks.Client{}
 			client.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
 
-			plugin := &ufmPlugin{client: client, conf: UFMConfig{}}
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
 			err := plugin.Validate()
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("failed to connect to ufm subnet manager: failed"))
@@ -81,19 +102,36 @@ var _ = Describe("Ufm Subnet Manager Client plugin", func() {
 			client := &mocks.Client{}
 			client.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
 
-			plugin := &ufmPlugin{client: client, conf: UFMConfig{}}
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
+			Expect(err).ToNot(HaveOccurred())
+
+			err = plugin.AddGuidsToPKey(context.Background(), 0x1234, []net.HardwareAddr{guid}, plugins.MembershipFull)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("Add guid to pkey with limited membership", func() {
+			client := &mocks.Client{}
+			client.On("Post", mock.Anything, mock.Anything, mock.MatchedBy(func(body []byte) bool {
+				return strings.Contains(string(body), `"membership":"limited"`)
+			})).Return(nil, nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
 			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
 			Expect(err).ToNot(HaveOccurred())
 
-			err = plugin.AddGuidsToPKey(0x1234, []net.HardwareAddr{guid})
+			err = plugin.AddGuidsToPKey(context.Background(), 0x1234, []net.HardwareAddr{guid}, plugins.MembershipLimited)
 			Expect(err).ToNot(HaveOccurred())
+			client.AssertExpectations(GinkgoT())
+
+			err = plugin.AddGuidsToPKey(context.Background(), 0x1234, []net.HardwareAddr{guid}, "partial")
+			Expect(err).To(HaveOccurred())
 		})
 		It("Add guid to invalid pkey", func() {
 			plugin := &ufmPlugin{conf: UFMConfig{}}
 			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
 			Expect(err).ToNot(HaveOccurred())
 
-			err = plugin.AddGuidsToPKey(0xFFFF, []net.HardwareAddr{guid})
+			err = plugin.AddGuidsToPKey(context.Background(), 0xFFFF, []net.HardwareAddr{guid}, plugins.MembershipFull)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("invalid pkey 0xFFFF, out of range 0x0001 - 0xFFFE"))
 		})
@@ -101,13 +139,13 @@ var _ = Describe("Ufm Subnet Manager Client plugin", func() {
 			client := &mocks.Client{}
 			client.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
 
-			plugin := &ufmPlugin{client: client, conf: UFMConfig{}}
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
 			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
 			Expect(err).ToNot(HaveOccurred())
 
 			guids := []net.HardwareAddr{guid}
 			pKey := 0x1234
-			err = plugin.AddGuidsToPKey(pKey, guids)
+			err = plugin.AddGuidsToPKey(context.Background(), pKey, guids, plugins.MembershipFull)
 			Expect(err).To(HaveOccurred())
 			errMessage := fmt.Sprintf("failed to add guids %v to PKey 0x%04X with error: failed", guids, pKey)
 			Expect(err.Error()).To(Equal(errMessage))
@@ -118,11 +156,11 @@ var _ = Describe("Ufm Subnet Manager Client plugin", func() {
 			client := &mocks.Client{}
 			client.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
 
-			plugin := &ufmPlugin{client: client, conf: UFMConfig{}}
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
 			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
 			Expect(err).ToNot(HaveOccurred())
 
-			err = plugin.RemoveGuidsFromPKey(0x1234, []net.HardwareAddr{guid})
+			err = plugin.RemoveGuidsFromPKey(context.Background(), 0x1234, []net.HardwareAddr{guid})
 			Expect(err).ToNot(HaveOccurred())
 		})
 		It("Remove guid from invalid pkey", func() {
@@ -130,7 +168,7 @@ var _ = Describe("Ufm Subnet Manager Client plugin", func() {
 			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
 			Expect(err).ToNot(HaveOccurred())
 
-			err = plugin.RemoveGuidsFromPKey(0xFFFF, []net.HardwareAddr{guid})
+			err = plugin.RemoveGuidsFromPKey(context.Background(), 0xFFFF, []net.HardwareAddr{guid})
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("invalid pkey 0xFFFF, out of range 0x0001 - 0xFFFE"))
 		})
@@ -138,13 +176,13 @@ var _ = Describe("Ufm Subnet Manager Client plugin", func() {
 			client := &mocks.Client{}
 			client.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
 
-			plugin := &ufmPlugin{client: client, conf: UFMConfig{}}
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
 			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
 			Expect(err).ToNot(HaveOccurred())
 
 			guids := []net.HardwareAddr{guid}
 			pKey := 0x1234
-			err = plugin.RemoveGuidsFromPKey(pKey, guids)
+			err = plugin.RemoveGuidsFromPKey(context.Background(), pKey, guids)
 			Expect(err).To(HaveOccurred())
 			errMessage := fmt.Sprintf("failed to delete guids %v from PKey 0x%04X, with error: failed",
 				guids, pKey)
@@ -152,4 +190,233 @@ var _ = Describe("Ufm Subnet Manager Client plugin", func() {
 			Expect(&errMsg).To(Equal(&errMessage))
 		})
 	})
+	Context("BulkRemoveGuidsFromPKeys", func() {
+		It("Remove guids from multiple pkeys", func() {
+			client := &mocks.Client{}
+			client.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
+			Expect(err).ToNot(HaveOccurred())
+
+			err = plugin.BulkRemoveGuidsFromPKeys(context.Background(), map[int][]net.HardwareAddr{
+				0x1234: {guid}, 0x5678: {guid}})
+			Expect(err).ToNot(HaveOccurred())
+			client.AssertNumberOfCalls(GinkgoT(), "Post", 2)
+		})
+		It("Remove guids from multiple pkeys with invalid pkey", func() {
+			client := &mocks.Client{}
+			client.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			guid, err := net.ParseMAC("11:22:33:44:55:66:77:88")
+			Expect(err).ToNot(HaveOccurred())
+
+			err = plugin.BulkRemoveGuidsFromPKeys(context.Background(), map[int][]net.HardwareAddr{
+				0x1234: {guid}, 0xFFFF: {guid}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(
+				"failed to remove guids from pkeys: invalid pkey 0xFFFF, out of range 0x0001 - 0xFFFE"))
+			client.AssertNumberOfCalls(GinkgoT(), "Post", 1)
+		})
+	})
+	Context("GetPKeyMembership", func() {
+		It("Get guids of valid pkey", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return(
+				[]byte(`{"guids": [{"guid": "1122334455667788", "membership": "full"}]}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			guids, err := plugin.GetPKeyMembership(context.Background(), 0x1234)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(guids)).To(Equal(1))
+			Expect(guids[0].String()).To(Equal("11:22:33:44:55:66:77:88"))
+		})
+		It("Get guids of invalid pkey", func() {
+			plugin := &ufmPlugin{conf: UFMConfig{}}
+			_, err := plugin.GetPKeyMembership(context.Background(), 0xFFFF)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid pkey 0xFFFF, out of range 0x0001 - 0xFFFE"))
+		})
+		It("Get guids of pkey failed from ufm", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			_, err := plugin.GetPKeyMembership(context.Background(), 0x1234)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("failed to get guids of PKey 0x1234 with error: failed"))
+		})
+	})
+	Context("GetPKeyUsageStats", func() {
+		It("Get usage stats of valid pkey", func() {
+			client := &mocks.Client{}
+			client.On("Get", "http://1.1.1.1:80/ufmRest/app/pkeys/0x1234/stats", mock.Anything).Return(
+				[]byte(`{"members_count": 50, "max_members": 200, "full_members": 40, "limited_members": 10}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client},
+				conf: UFMConfig{HTTPSchema: "http", Address: "1.1.1.1", Port: 80}}
+			stats, err := plugin.GetPKeyUsageStats(context.Background(), 0x1234)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.PKey).To(Equal(0x1234))
+			Expect(stats.MemberCount).To(Equal(50))
+			Expect(stats.MaxMembers).To(Equal(200))
+			Expect(stats.UtilizationPercent).To(Equal(25.0))
+			Expect(stats.FullMembers).To(Equal(40))
+			Expect(stats.LimitedMembers).To(Equal(10))
+		})
+		It("Get usage stats of pkey without max members", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return([]byte(`{"members_count": 50}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			stats, err := plugin.GetPKeyUsageStats(context.Background(), 0x1234)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.MemberCount).To(Equal(50))
+			Expect(stats.UtilizationPercent).To(Equal(0.0))
+		})
+		It("Get usage stats of invalid pkey", func() {
+			plugin := &ufmPlugin{conf: UFMConfig{}}
+			_, err := plugin.GetPKeyUsageStats(context.Background(), 0xFFFF)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid pkey 0xFFFF, out of range 0x0001 - 0xFFFE"))
+		})
+		It("Get usage stats of pkey failed from ufm", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			_, err := plugin.GetPKeyUsageStats(context.Background(), 0x1234)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("failed to get usage stats of PKey 0x1234 with error: failed"))
+		})
+	})
+	Context("GetGUIDLastActivity", func() {
+		guid, _ := net.ParseMAC("11:22:33:44:55:66:77:88")
+		It("Get last activity of active guid", func() {
+			client := &mocks.Client{}
+			client.On("Get", "http://1.1.1.1:80/ufmRest/app/guids/1122334455667788/activity", mock.Anything).Return(
+				[]byte(`{"last_activity": "2020-05-01T10:00:00Z"}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client},
+				conf: UFMConfig{HTTPSchema: "http", Address: "1.1.1.1", Port: 80}}
+			lastActivity, err := plugin.GetGUIDLastActivity(context.Background(), guid)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lastActivity).To(Equal(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)))
+		})
+		It("Get last activity of never active guid", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return([]byte(`{}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			lastActivity, err := plugin.GetGUIDLastActivity(context.Background(), guid)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lastActivity.IsZero()).To(BeTrue())
+		})
+		It("Get last activity of guid with invalid time", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return([]byte(`{"last_activity": "yesterday"}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			_, err := plugin.GetGUIDLastActivity(context.Background(), guid)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Get last activity of guid failed from ufm", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			_, err := plugin.GetGUIDLastActivity(context.Background(), guid)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(
+				"failed to get last activity of guid 11:22:33:44:55:66:77:88 with error: failed"))
+		})
+	})
+	Context("PingGUID", func() {
+		guid, _ := net.ParseMAC("11:22:33:44:55:66:77:88")
+		It("Ping reachable guid", func() {
+			client := &mocks.Client{}
+			client.On("Post", "http://1.1.1.1:80/ufmRest/app/guids/1122334455667788/ping", mock.Anything,
+				mock.Anything).Return([]byte(`{"reachable": true}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client},
+				conf: UFMConfig{HTTPSchema: "http", Address: "1.1.1.1", Port: 80}}
+			Expect(plugin.PingGUID(context.Background(), guid)).To(Succeed())
+		})
+		It("Ping unreachable guid", func() {
+			client := &mocks.Client{}
+			client.On("Post", mock.Anything, mock.Anything, mock.Anything).Return([]byte(`{"reachable": false}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			err := plugin.PingGUID(context.Background(), guid)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("guid 11:22:33:44:55:66:77:88 is unreachable on the fabric"))
+		})
+		It("Ping guid failed from ufm", func() {
+			client := &mocks.Client{}
+			client.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			err := plugin.PingGUID(context.Background(), guid)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("failed to ping guid 11:22:33:44:55:66:77:88 with error: failed"))
+		})
+	})
+	Context("GetPortCapabilities", func() {
+		guid, _ := net.ParseMAC("11:22:33:44:55:66:77:88")
+		It("Get port capabilities of guid", func() {
+			client := &mocks.Client{}
+			client.On("Get", "http://1.1.1.1:80/ufmRest/app/guids/1122334455667788/port", mock.Anything).Return(
+				[]byte(`{"active_speed": "EDR", "active_width": "4x"}`), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client},
+				conf: UFMConfig{HTTPSchema: "http", Address: "1.1.1.1", Port: 80}}
+			capabilities, err := plugin.GetPortCapabilities(context.Background(), guid)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capabilities).To(Equal(plugins.PortCapabilities{Speed: "EDR", Width: "4x"}))
+			Expect(capabilities.Rate()).To(Equal("100Gb"))
+		})
+		It("Get port capabilities of guid failed from ufm", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			_, err := plugin.GetPortCapabilities(context.Background(), guid)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("GetFabricTopology", func() {
+		It("Get fabric topology", func() {
+			topologyJSON := `{"switches": [{"guid": "0x1", "system_name": "leaf-1", "ports": [` +
+				`{"guid": "0x11", "node_guid": "0x10", "system_name": "node-1"}]}, ` +
+				`{"guid": "0x2", "system_name": "leaf-2", "ports": [` +
+				`{"guid": "0x21", "node_guid": "0x20", "system_name": "node-2"}, ` +
+				`{"guid": "0x22", "node_guid": "0x20", "system_name": "node-2"}]}]}`
+			client := &mocks.Client{}
+			client.On("Get", "http://1.1.1.1:80/ufmRest/app/topology", mock.Anything).Return(
+				[]byte(topologyJSON), nil)
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client},
+				conf: UFMConfig{HTTPSchema: "http", Address: "1.1.1.1", Port: 80}}
+			topology, err := plugin.GetFabricTopology(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(topology.Switches).To(HaveLen(2))
+			Expect(topology.Switches[0]).To(Equal(plugins.Switch{GUID: "0x1", Name: "leaf-1", Ports: []plugins.Port{
+				{GUID: "0x11", NodeGUID: "0x10", NodeName: "node-1"}}}))
+
+			nodeSwitch, found := topology.GetNodeSwitch("node-2")
+			Expect(found).To(BeTrue())
+			Expect(nodeSwitch).To(Equal("0x2"))
+			_, found = topology.GetNodeSwitch("node-3")
+			Expect(found).To(BeFalse())
+		})
+		It("Get fabric topology failed from ufm", func() {
+			client := &mocks.Client{}
+			client.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("failed"))
+
+			plugin := &ufmPlugin{BaseSMClient: plugins.BaseSMClient{Client: client}, conf: UFMConfig{}}
+			_, err := plugin.GetFabricTopology(context.Background())
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
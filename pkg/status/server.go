@@ -0,0 +1,199 @@
+package status
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// serviceName is the rpc service name of the status requests, e.g "StatusService.GetStatus"
+const serviceName = "StatusService"
+
+// Health levels of the daemon, used as the exit codes of the status command
+const (
+	Healthy  = 0
+	Degraded = 1 // subnet manager disconnected or guid pool usage above poolDegradedPercent
+	Critical = 2 // guid pool usage above poolCriticalPercent or periodic updates stalled
+)
+
+const (
+	poolDegradedPercent = 80
+	poolCriticalPercent = 95
+	percent             = 100
+)
+
+// Status is the current state of the daemon
+type Status struct {
+	PoolAllocated       uint64    `json:"poolAllocated"`
+	PoolTotal           uint64    `json:"poolTotal"`
+	SMName              string    `json:"smName"`
+	SMVersion           string    `json:"smVersion"`
+	SMConnected         bool      `json:"smConnected"`
+	AddQueueNetworks    int       `json:"addQueueNetworks"`
+	AddQueuePods        int       `json:"addQueuePods"`
+	DeleteQueueNetworks int       `json:"deleteQueueNetworks"`
+	DeleteQueuePods     int       `json:"deleteQueuePods"`
+	StartTime           time.Time `json:"startTime"`
+	LastAddUpdate       time.Time `json:"lastAddUpdate"`    // zero if the add update didn't run yet
+	LastDeleteUpdate    time.Time `json:"lastDeleteUpdate"` // zero if the delete update didn't run yet
+	Stalled             bool      `json:"stalled"`          // periodic updates didn't run for several periods
+	Now                 time.Time `json:"now"`              // time the status was taken
+}
+
+// PoolUsagePercent returns the percent of the allocated guids in the guid pool
+func (s *Status) PoolUsagePercent() float64 {
+	if s.PoolTotal == 0 {
+		return 0
+	}
+	return float64(s.PoolAllocated) * percent / float64(s.PoolTotal)
+}
+
+// Health returns Critical, Degraded or Healthy health level of the daemon
+func (s *Status) Health() int {
+	usage := s.PoolUsagePercent()
+	switch {
+	case usage > poolCriticalPercent || s.Stalled:
+		return Critical
+	case usage > poolDegradedPercent || !s.SMConnected:
+		return Degraded
+	default:
+		return Healthy
+	}
+}
+
+// String returns the human readable one line status
+func (s *Status) String() string {
+	smState := "connected"
+	if !s.SMConnected {
+		smState = "disconnected"
+	}
+
+	return fmt.Sprintf("GuidPool: %d/%d (%.0f%% used), SM: %s (%s %s), Add queue: %s/%s, "+
+		"Delete queue: %s/%s, Uptime: %s, LastAddUpdate: %s, LastDeleteUpdate: %s",
+		s.PoolAllocated, s.PoolTotal, s.PoolUsagePercent(), smState, s.SMName, s.SMVersion,
+		plural(s.AddQueueNetworks, "network"), plural(s.AddQueuePods, "pod"),
+		plural(s.DeleteQueueNetworks, "network"), plural(s.DeleteQueuePods, "pod"),
+		formatDuration(s.Now.Sub(s.StartTime)), formatAgo(s.Now, s.LastAddUpdate),
+		formatAgo(s.Now, s.LastDeleteUpdate))
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+func formatAgo(now, at time.Time) string {
+	if at.IsZero() {
+		return "never"
+	}
+	return formatDuration(now.Sub(at)) + " ago"
+}
+
+// formatDuration returns the duration in its two most significant units, e.g 2d5h
+func formatDuration(duration time.Duration) string {
+	const day = 24 * time.Hour
+	duration = duration.Round(time.Second)
+	switch {
+	case duration >= day:
+		return fmt.Sprintf("%dd%dh", duration/day, duration%day/time.Hour)
+	case duration >= time.Hour:
+		return fmt.Sprintf("%dh%dm", duration/time.Hour, duration%time.Hour/time.Minute)
+	case duration >= time.Minute:
+		return fmt.Sprintf("%dm%ds", duration/time.Minute, duration%time.Minute/time.Second)
+	default:
+		return fmt.Sprintf("%ds", duration/time.Second)
+	}
+}
+
+// Provider provides the current state of the daemon
+type Provider interface {
+	Status() Status
+}
+
+// GetStatusArgs are the arguments of StatusService.GetStatus
+type GetStatusArgs struct{}
+
+type Server interface {
+	// Run serves status requests on the unix socket until the stop channel is closed
+	Run(stopChan <-chan struct{}) error
+}
+
+type server struct {
+	socketPath string
+	rpcServer  *rpc.Server
+}
+
+type statusService struct {
+	provider Provider
+}
+
+// GetStatus is the rpc handler of the status requests
+func (s *statusService) GetStatus(args *GetStatusArgs, reply *Status) error {
+	*reply = s.provider.Status()
+	return nil
+}
+
+// NewServer returns a json-rpc server of the status requests on the given unix socket
+func NewServer(socketPath string, provider Provider) (Server, error) {
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName(serviceName, &statusService{provider: provider}); err != nil {
+		return nil, fmt.Errorf("failed to register status service: %v", err)
+	}
+
+	return &server{socketPath: socketPath, rpcServer: rpcServer}, nil
+}
+
+func (s *server) Run(stopChan <-chan struct{}) error {
+	// remove stale socket of previous run
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove socket %s: %v", s.socketPath, err)
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on socket %s: %v", s.socketPath, err)
+	}
+
+	go func() {
+		<-stopChan
+		listener.Close()
+	}()
+
+	log.Info().Msgf("serving status requests on %s", s.socketPath)
+	for {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			select {
+			case <-stopChan:
+				return nil
+			default:
+				return fmt.Errorf("failed to accept connection on socket %s: %v", s.socketPath, acceptErr)
+			}
+		}
+
+		go s.rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// GetStatus requests the status of the daemon listening on the given unix socket
+func GetStatus(socketPath string) (*Status, error) {
+	client, err := jsonrpc.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to socket %s: %v", socketPath, err)
+	}
+	defer client.Close()
+
+	reply := &Status{}
+	if err = client.Call(serviceName+".GetStatus", &GetStatusArgs{}, reply); err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
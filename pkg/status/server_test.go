@@ -0,0 +1,78 @@
+package status
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeProvider struct {
+	status Status
+}
+
+func (f *fakeProvider) Status() Status { return f.status }
+
+var _ = Describe("Status", func() {
+	now := time.Date(2020, 1, 3, 5, 0, 0, 0, time.UTC)
+	healthyStatus := func() Status {
+		return Status{PoolAllocated: 50, PoolTotal: 1000, SMName: "ufm", SMVersion: "1.0", SMConnected: true,
+			AddQueueNetworks: 3, AddQueuePods: 12, DeleteQueueNetworks: 1, DeleteQueuePods: 4,
+			StartTime: now.Add(-53 * time.Hour), LastAddUpdate: now.Add(-3 * time.Second), Now: now}
+	}
+	Context("String", func() {
+		It("Format status", func() {
+			status := healthyStatus()
+			Expect(status.String()).To(Equal("GuidPool: 50/1000 (5% used), SM: connected (ufm 1.0), " +
+				"Add queue: 3 networks/12 pods, Delete queue: 1 network/4 pods, Uptime: 2d5h, " +
+				"LastAddUpdate: 3s ago, LastDeleteUpdate: never"))
+		})
+	})
+	Context("Health", func() {
+		It("Health of healthy, degraded and critical daemon", func() {
+			status := healthyStatus()
+			Expect(status.Health()).To(Equal(Healthy))
+
+			status.SMConnected = false
+			Expect(status.Health()).To(Equal(Degraded))
+			status.SMConnected = true
+			status.PoolAllocated = 900
+			Expect(status.Health()).To(Equal(Degraded))
+
+			status.PoolAllocated = 960
+			Expect(status.Health()).To(Equal(Critical))
+			status.PoolAllocated = 50
+			status.Stalled = true
+			Expect(status.Health()).To(Equal(Critical))
+		})
+	})
+	Context("GetStatus", func() {
+		It("Get status from the server", func() {
+			socketDir, err := ioutil.TempDir("", "status")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(socketDir)
+			socketPath := filepath.Join(socketDir, "status.sock")
+
+			statusServer, err := NewServer(socketPath, &fakeProvider{status: healthyStatus()})
+			Expect(err).ToNot(HaveOccurred())
+			stopChan := make(chan struct{})
+			runErr := make(chan error, 1)
+			go func() { runErr <- statusServer.Run(stopChan) }()
+			Eventually(func() error {
+				_, statErr := os.Stat(socketPath)
+				return statErr
+			}).ShouldNot(HaveOccurred())
+
+			status, err := GetStatus(socketPath)
+			Expect(err).ToNot(HaveOccurred())
+			expected := healthyStatus()
+			Expect(status.String()).To(Equal(expected.String()))
+
+			close(stopChan)
+			Eventually(runErr).Should(Receive(BeNil()))
+		})
+	})
+})
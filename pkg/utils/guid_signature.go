@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	kapi "k8s.io/api/core/v1"
+)
+
+// GUIDSignatureAnnotation is the pod annotation of the daemon signatures of the pod networks guids,
+// its value is a json object of the InfiniBand network names to their guid signatures
+const GUIDSignatureAnnotation = "ib.mellanox.com/guid-signature"
+
+// ErrGUIDSignatureInvalid is returned when the signature of the guid of a pod network doesn't match the guid
+var ErrGUIDSignatureInvalid = errors.New("guid signature invalid")
+
+// ErrGUIDUnsigned is returned when the guid of a pod network has no signature, e.g the guid was allocated before the
+// guids were signed
+var ErrGUIDUnsigned = errors.New("guid unsigned")
+
+// GUIDSignature is the daemon signature of the guid of a pod network, signed with the time it was signed at
+type GUIDSignature struct {
+	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature"`
+}
+
+// ParseGUIDSigningKey parses the PEM encoded PKCS #8 Ed25519 private key
+func ParseGUIDSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode guid signing key PEM")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse guid signing key: %v", err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("guid signing key is %T, expected an Ed25519 private key", key)
+	}
+
+	return privateKey, nil
+}
+
+// SignGUIDAnnotation adds the signature of the pod network guid to the guid signature annotation in the pod
+// annotations, the signed message is the guid, the pod uid, the network name and the signature timestamp.
+// The network name is the pod network interface key of pods with several interfaces of the network.
+func SignGUIDAnnotation(pod *kapi.Pod, networkName, guidAddr string, signingKey ed25519.PrivateKey,
+	timestamp time.Time) error {
+	signatures, err := getGUIDSignatures(pod)
+	if err != nil {
+		return err
+	}
+
+	message := guidSignatureMessage(guidAddr, pod, networkName, timestamp.Unix())
+	signatures[networkName] = GUIDSignature{Timestamp: timestamp.Unix(),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, message))}
+
+	value, err := json.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[GUIDSignatureAnnotation] = string(value)
+	return nil
+}
+
+// VerifyGUIDAnnotation checks the guids of the pod networks configured with InfiniBand, marked with the given
+// annotation key, are signed by the daemon.
+// It returns error wrapping ErrGUIDSignatureInvalid if the signature of a network guid doesn't match its guid, e.g the
+// guid was changed in the pod network annotation, or wrapping ErrGUIDUnsigned if the signatures of all the network
+// guids match but some guids aren't signed.
+func VerifyGUIDAnnotation(pod *kapi.Pod, verifyKey ed25519.PublicKey, annotationKey string) error {
+	networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+	if err != nil {
+		return fmt.Errorf("failed to parse network annotations with error: %v", err)
+	}
+
+	signatures, err := getGUIDSignatures(pod)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrGUIDSignatureInvalid, err)
+	}
+
+	var unsignedErr error
+	for _, network := range networks {
+		if !IsPodNetworkConfiguredWithInfiniBand(network, annotationKey) || !PodNetworkHasGUID(network) {
+			continue
+		}
+
+		guidAddr, guidErr := GetPodNetworkGUID(network)
+		if guidErr != nil {
+			return guidErr
+		}
+
+		interfaceKey := GetPodNetworkInterfaceKey(networks, network)
+		signature, signed := signatures[interfaceKey]
+		if !signed {
+			if unsignedErr == nil {
+				unsignedErr = fmt.Errorf("%w: guid %s of network %s isn't signed", ErrGUIDUnsigned, guidAddr,
+					interfaceKey)
+			}
+			continue
+		}
+
+		signatureBytes, decodeErr := base64.StdEncoding.DecodeString(signature.Signature)
+		message := guidSignatureMessage(guidAddr, pod, interfaceKey, signature.Timestamp)
+		if decodeErr != nil || !ed25519.Verify(verifyKey, message, signatureBytes) {
+			return fmt.Errorf("%w: guid %s of network %s doesn't match its signature", ErrGUIDSignatureInvalid,
+				guidAddr, interfaceKey)
+		}
+	}
+
+	return unsignedErr
+}
+
+func getGUIDSignatures(pod *kapi.Pod) (map[string]GUIDSignature, error) {
+	signatures := map[string]GUIDSignature{}
+	if value, exist := pod.Annotations[GUIDSignatureAnnotation]; exist && value != "" {
+		if err := json.Unmarshal([]byte(value), &signatures); err != nil {
+			return nil, fmt.Errorf("failed to parse %s annotation %q: %v", GUIDSignatureAnnotation, value, err)
+		}
+	}
+
+	return signatures, nil
+}
+
+// guidSignatureMessage returns the signed message of the pod network guid, the guid is signed in its canonical form
+// so the letter case of a user requested guid doesn't change its signature
+func guidSignatureMessage(guidAddr string, pod *kapi.Pod, networkName string, timestamp int64) []byte {
+	if parsedGUID, err := net.ParseMAC(guidAddr); err == nil {
+		guidAddr = parsedGUID.String()
+	}
+
+	return []byte(guidAddr + string(pod.UID) + networkName + strconv.FormatInt(timestamp, 10))
+}
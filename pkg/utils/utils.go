@@ -1,25 +1,72 @@
 package utils
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	kapi "k8s.io/api/core/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
 )
 
 type IbSriovCniSpec struct {
 	Type string `json:"type"`
 	PKey string `json:"pkey"`
+	// Membership is the pkey membership of the network guids, "full" or "limited", full if empty
+	Membership string `json:"membership"`
 }
 
 const (
+	// InfiniBandAnnotation is the default pod network cni-args key marking the network configured with InfiniBand
 	InfiniBandAnnotation    = "mellanox.infiniband.app"
 	ConfiguredInfiniBandPod = "configured"
 	InfiniBandSriovCni      = "ib-sriov"
+	// CNIConfNameAnnotation is the network attachment definition annotation of the ConfigMap holding the cni config
+	CNIConfNameAnnotation = "k8s.v1.cni.cncf.io/cni-conf-name"
+	// GUIDRangeStartAnnotation is the network attachment definition annotation of the first guid in its guid range
+	GUIDRangeStartAnnotation = "ib.mellanox.com/guid-range-start"
+	// GUIDRangeEndAnnotation is the network attachment definition annotation of the last guid in its guid range
+	GUIDRangeEndAnnotation = "ib.mellanox.com/guid-range-end"
+	// PortSpeedAnnotation is the pod annotation of the data rate of its InfiniBand port, e.g "100Gb"
+	PortSpeedAnnotation = "ib.mellanox.com/port-speed"
+	// PortWidthAnnotation is the pod annotation of the width of its InfiniBand port, e.g "4x"
+	PortWidthAnnotation = "ib.mellanox.com/port-width"
+	// GUIDReservationAnnotation is the pod annotation of the guids reserved for the pod name, as comma separated
+	// <network name>=<guid> pairs, e.g "ib-net=02:00:00:00:00:00:00:10"
+	GUIDReservationAnnotation = "ib.mellanox.com/guid-reservation"
+	// GUIDReleaseFinalizer is the pod finalizer keeping the deleted pods until the daemon releases their guids
+	GUIDReleaseFinalizer = "ib.mellanox.com/guid-release"
+	// IBReadyConditionType is the pod readiness gate condition set when the pod InfiniBand networks are configured
+	IBReadyConditionType kapi.PodConditionType = "ib.mellanox.com/IBReady"
+)
+
+// ErrGUIDSanityFailed is returned when a user specified guid can't be a valid port guid
+var ErrGUIDSanityFailed = errors.New("guid sanity check failed")
+
+const (
+	// guidLength is the number of bytes of a guid
+	guidLength = 8
+	// loopbackGUID is the guid reserved for the local port
+	loopbackGUID = 1
+)
+
+const (
+	// GUIDFormatColon is the format of colon separated guid bytes, e.g "02:00:00:00:00:00:00:01"
+	GUIDFormatColon = "colon"
+	// GUIDFormatDash is the format of dash separated guid bytes, e.g "02-00-00-00-00-00-00-01"
+	GUIDFormatDash = "dash"
+	// GUIDFormatHex is the format of the guid as 16 hex digits, e.g "0200000000000001"
+	GUIDFormatHex = "hex"
 )
 
 // PodWantsNetwork check if pod needs cni
@@ -37,20 +84,62 @@ func HasNetworkAttachment(pod *kapi.Pod) bool {
 	return len(pod.Annotations[v1.NetworkAttachmentAnnot]) > 0
 }
 
+// HasIBReadyGate check if the pod readiness gates include the InfiniBand ready condition
+func HasIBReadyGate(pod *kapi.Pod) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == IBReadyConditionType {
+			return true
+		}
+	}
+	return false
+}
+
+// PodHasGUIDReleaseFinalizer checks if the pod has the GUIDReleaseFinalizer
+func PodHasGUIDReleaseFinalizer(pod *kapi.Pod) bool {
+	for _, finalizer := range pod.Finalizers {
+		if finalizer == GUIDReleaseFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// PodIsReleasingGUIDs checks if the pod is deleted and kept by the GUIDReleaseFinalizer until its guids are released
+func PodIsReleasingGUIDs(pod *kapi.Pod) bool {
+	return pod.DeletionTimestamp != nil && PodHasGUIDReleaseFinalizer(pod)
+}
+
 // PodIsRunning check if pod is in "Running" state
 func PodIsRunning(pod *kapi.Pod) bool {
 	return pod.Status.Phase == kapi.PodRunning
 }
 
-// IsPodNetworkConfiguredWithInfiniBand check if pod is already InfiniBand supported
-func IsPodNetworkConfiguredWithInfiniBand(network *v1.NetworkSelectionElement) bool {
+// IsPodNetworkConfiguredWithInfiniBand check if pod is already InfiniBand supported, marked with the given cni-args
+// annotation key, InfiniBandAnnotation if empty. Networks marked with the default InfiniBandAnnotation key are
+// recognized with any key, e.g networks configured before the key was changed.
+func IsPodNetworkConfiguredWithInfiniBand(network *v1.NetworkSelectionElement, annotationKey string) bool {
 	if network == nil || network.CNIArgs == nil {
 		return false
 	}
 
+	if annotationKey != "" && (*network.CNIArgs)[annotationKey] == ConfiguredInfiniBandPod {
+		return true
+	}
 	return (*network.CNIArgs)[InfiniBandAnnotation] == ConfiguredInfiniBandPod
 }
 
+// SetPodNetworkConfiguredWithInfiniBand marks the pod network as configured with InfiniBand with the given cni-args
+// annotation key, InfiniBandAnnotation if empty
+func SetPodNetworkConfiguredWithInfiniBand(network *v1.NetworkSelectionElement, annotationKey string) {
+	if annotationKey == "" {
+		annotationKey = InfiniBandAnnotation
+	}
+	if network.CNIArgs == nil {
+		network.CNIArgs = &map[string]interface{}{}
+	}
+	(*network.CNIArgs)[annotationKey] = ConfiguredInfiniBandPod
+}
+
 // PodNetworkHasGUID check if network cni-args has guid field
 func PodNetworkHasGUID(network *v1.NetworkSelectionElement) bool {
 	_, err := GetPodNetworkGUID(network)
@@ -69,7 +158,88 @@ func GetPodNetworkGUID(network *v1.NetworkSelectionElement) (string, error) {
 		return "", fmt.Errorf("no \"guid\" field in network %v", network)
 	}
 
-	return fmt.Sprintf("%s", guid), nil
+	// guids annotated in the dash or hex format are returned colon separated, as parsed by net.ParseMAC
+	guidString := fmt.Sprintf("%s", guid)
+	if !strings.Contains(guidString, ":") {
+		if guidAddr, err := ParseFormattedGUID(guidString); err == nil {
+			return guidAddr.String(), nil
+		}
+	}
+	return guidString, nil
+}
+
+// GetPodNetworkGUIDStrict returns network cni-args guid field like GetPodNetworkGUID, for guids specified by the
+// user. It returns error wrapping ErrGUIDSanityFailed if the guid isn't an 8 bytes address, or is the all-zeros,
+// broadcast or loopback guid.
+func GetPodNetworkGUIDStrict(network *v1.NetworkSelectionElement) (string, error) {
+	guid, err := GetPodNetworkGUID(network)
+	if err != nil {
+		return "", err
+	}
+
+	guidAddr, err := net.ParseMAC(guid)
+	if err != nil || len(guidAddr) != guidLength {
+		return "", fmt.Errorf("%w: guid %s isn't an 8 bytes address", ErrGUIDSanityFailed, guid)
+	}
+
+	switch binary.BigEndian.Uint64(guidAddr) {
+	case 0:
+		return "", fmt.Errorf("%w: guid %s is all-zeros", ErrGUIDSanityFailed, guid)
+	case math.MaxUint64:
+		return "", fmt.Errorf("%w: guid %s is the broadcast guid", ErrGUIDSanityFailed, guid)
+	case loopbackGUID:
+		return "", fmt.Errorf("%w: guid %s is the loopback guid", ErrGUIDSanityFailed, guid)
+	}
+
+	return guid, nil
+}
+
+// FormatGUID returns the colon separated guid in the guid format, the guid is returned unchanged for the colon
+// format or if it can't be parsed
+func FormatGUID(guid, format string) string {
+	if format == GUIDFormatColon {
+		return guid
+	}
+
+	guidAddr, err := net.ParseMAC(guid)
+	if err != nil {
+		return guid
+	}
+
+	switch format {
+	case GUIDFormatDash:
+		return strings.ReplaceAll(guidAddr.String(), ":", "-")
+	case GUIDFormatHex:
+		return hex.EncodeToString(guidAddr)
+	}
+	return guid
+}
+
+// ParseFormattedGUID parses the guid in any of the guid formats, or in the other formats of net.ParseMAC
+func ParseFormattedGUID(guid string) (net.HardwareAddr, error) {
+	if len(guid) == 2*guidLength {
+		if guidAddr, err := hex.DecodeString(guid); err == nil {
+			return guidAddr, nil
+		}
+	}
+	return net.ParseMAC(guid)
+}
+
+// GetGID returns the gid of the guid, the subnet prefix followed by the guid. The subnet prefix is a 16 bytes gid
+// whose last 8 bytes are replaced with the guid.
+// It returns error if the subnet prefix isn't 16 bytes or the guid isn't 8 bytes.
+func GetGID(subnetPrefix net.IP, guid net.HardwareAddr) (net.IP, error) {
+	if len(subnetPrefix) != net.IPv6len {
+		return nil, fmt.Errorf("invalid subnet prefix %v, should be 16 bytes", subnetPrefix)
+	}
+	if len(guid) != guidLength {
+		return nil, fmt.Errorf("invalid guid %v, should be 8 bytes", guid)
+	}
+
+	gid := make(net.IP, net.IPv6len)
+	copy(gid, subnetPrefix[:net.IPv6len-guidLength])
+	copy(gid[net.IPv6len-guidLength:], guid)
+	return gid, nil
 }
 
 // SetPodNetworkGUID set network cni-args guid
@@ -98,6 +268,9 @@ func GetIbSriovCniFromNetwork(networkSpec map[string]interface{}) (*IbSriovCniSp
 		if ok {
 			ibSpec.PKey = fmt.Sprintf("%s", pkey)
 		}
+		if membership, ok := networkSpec["membership"]; ok {
+			ibSpec.Membership = fmt.Sprintf("%s", membership)
+		}
 
 		return ibSpec, nil
 	}
@@ -129,6 +302,90 @@ func GetIbSriovCniFromNetwork(networkSpec map[string]interface{}) (*IbSriovCniSp
 	return nil, fmt.Errorf("cni plugin ib-sriov not found")
 }
 
+// HasCniConfig returns true if the network spec has a cni config, of a single plugin or of a plugins list
+func HasCniConfig(networkSpec map[string]interface{}) bool {
+	_, hasType := networkSpec["type"]
+	_, hasPlugins := networkSpec["plugins"]
+	return hasType || hasPlugins
+}
+
+// GetIbSriovCniFromConfigMap returns the IB-SR-IOV-CNi spec of the cni configs stored in the ConfigMap, the cni config
+// file name is not known so the first config which uses IB-SR-IOV-CNi in the keys order is used
+func GetIbSriovCniFromConfigMap(configMap *kapi.ConfigMap) (*IbSriovCniSpec, error) {
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		confSpec := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(configMap.Data[key]), &confSpec); err != nil {
+			continue
+		}
+
+		if ibSpec, err := GetIbSriovCniFromNetwork(confSpec); err == nil {
+			return ibSpec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("cni plugin ib-sriov not found in ConfigMap %s in namespace %s", configMap.Name,
+		configMap.Namespace)
+}
+
+// IsInfiniBandNetworkAttachmentDefinition check if the network attachment definition config uses IB-SR-IOV-CNi
+func IsInfiniBandNetworkAttachmentDefinition(netAttDef *v1.NetworkAttachmentDefinition) bool {
+	if netAttDef.Spec.Config == "" {
+		return false
+	}
+
+	networkSpec := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(netAttDef.Spec.Config), &networkSpec); err != nil {
+		return false
+	}
+
+	_, err := GetIbSriovCniFromNetwork(networkSpec)
+	return err == nil
+}
+
+// GetNetworkAttachmentDefinitionGUIDRange returns the guid range annotations of the network attachment definition
+// or false if the network attachment definition has no guid range
+func GetNetworkAttachmentDefinitionGUIDRange(netAttDef *v1.NetworkAttachmentDefinition) (string, string, bool) {
+	rangeStart, hasStart := netAttDef.Annotations[GUIDRangeStartAnnotation]
+	rangeEnd, hasEnd := netAttDef.Annotations[GUIDRangeEndAnnotation]
+	return rangeStart, rangeEnd, hasStart && hasEnd
+}
+
+// GetPodGUIDReservations returns the colon separated guids of the pod guid reservation annotation mapped by network
+// name, or an empty map if the pod has no reservation.
+// It returns error if the annotation isn't valid or reserves several guids for a network.
+func GetPodGUIDReservations(pod *kapi.Pod) (map[string]string, error) {
+	reservations := map[string]string{}
+	value := strings.TrimSpace(pod.Annotations[GUIDReservationAnnotation])
+	if value == "" {
+		return reservations, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		fields := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid %s annotation %q, expected <network name>=<guid> pairs",
+				GUIDReservationAnnotation, value)
+		}
+		guidAddr, err := ParseFormattedGUID(fields[1])
+		if err != nil || len(guidAddr) != guidLength {
+			return nil, fmt.Errorf("invalid %s annotation guid %q of network %s", GUIDReservationAnnotation,
+				fields[1], fields[0])
+		}
+		if _, exist := reservations[fields[0]]; exist {
+			return nil, fmt.Errorf("invalid %s annotation, several guids reserved for network %s",
+				GUIDReservationAnnotation, fields[0])
+		}
+		reservations[fields[0]] = guidAddr.String()
+	}
+	return reservations, nil
+}
+
 func GetPodNetwork(networks []*v1.NetworkSelectionElement, networkName string) (*v1.NetworkSelectionElement, error) {
 	for _, network := range networks {
 		if network.Name == networkName {
@@ -139,14 +396,62 @@ func GetPodNetwork(networks []*v1.NetworkSelectionElement, networkName string) (
 	return nil, fmt.Errorf("network %s not found", networkName)
 }
 
-// ParsePKey returns parsed PKey from string
+// GetPodNetworks returns the pod networks of the network name, pods have several networks of the same name when they
+// attach several interfaces to the network, e.g dual-rail pods
+func GetPodNetworks(networks []*v1.NetworkSelectionElement, networkName string) (
+	[]*v1.NetworkSelectionElement, error) {
+	var podNetworks []*v1.NetworkSelectionElement
+	for _, network := range networks {
+		if network.Name == networkName {
+			podNetworks = append(podNetworks, network)
+		}
+	}
+
+	if len(podNetworks) == 0 {
+		return nil, fmt.Errorf("network %s not found", networkName)
+	}
+	return podNetworks, nil
+}
+
+// GetPodNetworkInterfaceKey returns the key of the pod network in the annotations mapped by network, the network
+// name for the first interface of the network and the network name followed by the interface index otherwise
+func GetPodNetworkInterfaceKey(networks []*v1.NetworkSelectionElement, network *v1.NetworkSelectionElement) string {
+	index := 0
+	for _, podNetwork := range networks {
+		if podNetwork == network {
+			break
+		}
+		if podNetwork.Name == network.Name {
+			index++
+		}
+	}
+
+	if index == 0 {
+		return network.Name
+	}
+	return fmt.Sprintf("%s/%d", network.Name, index)
+}
+
+// pKeyPrefixes are optional prefixes of the PKey hex value, "ibv_" as printed by InfiniBand userspace tools
+// and "p_key=" as used by some HPC cluster managers
+var pKeyPrefixes = []string{"ibv_", "p_key="}
+
+// ParsePKey returns parsed PKey from string, the PKey may be prefixed with one of the pKeyPrefixes
 func ParsePKey(pKey string) (int, error) {
-	match := regexp.MustCompile(`0[xX]\d+`)
-	if !match.MatchString(pKey) {
+	value := pKey
+	for _, prefix := range pKeyPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			value = value[len(prefix):]
+			break
+		}
+	}
+
+	match := regexp.MustCompile(`^0[xX][0-9a-fA-F]+$`)
+	if !match.MatchString(value) {
 		return 0, fmt.Errorf("invalid pkey %s, should be leading by 0x ", pKey)
 	}
 
-	i, err := strconv.ParseUint(pKey[2:], 16, 32)
+	i, err := strconv.ParseUint(value[2:], 16, 32)
 	if err != nil {
 		return 0, err
 	}
@@ -168,3 +473,23 @@ func ParseNetworkID(networkID string) (string, string, error) {
 func GenerateNetworkID(network *v1.NetworkSelectionElement) string {
 	return fmt.Sprintf("%s_%s", network.Namespace, network.Name)
 }
+
+// GenerateNetAttDefNetworkID returns the network id of the network attachment definition
+func GenerateNetAttDefNetworkID(netAttDef *v1.NetworkAttachmentDefinition) string {
+	return fmt.Sprintf("%s_%s", netAttDef.Namespace, netAttDef.Name)
+}
+
+// GetNamespaceFromGUID returns the namespace of the pod which the guid is allocated for in the pool, if the guid
+// namespace prefix matches it. The namespace hash can't be reversed, so guids which aren't allocated aren't resolved.
+func GetNamespaceFromGUID(guidAddr net.HardwareAddr, pool guid.Pool) (string, bool) {
+	poolGUID, err := guid.ParseGUID(guidAddr.String())
+	if err != nil {
+		return "", false
+	}
+
+	namespace, found := pool.GetGUIDNamespace(poolGUID.String())
+	if !found || !pool.HasNamespacePrefix(poolGUID, namespace) {
+		return "", false
+	}
+	return namespace, true
+}
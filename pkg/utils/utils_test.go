@@ -1,11 +1,21 @@
 package utils
 
 import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"time"
+
 	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	kapi "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/config"
+	"github.com/Mellanox/ib-kubernetes/pkg/guid"
 )
 
 var _ = Describe("Utils", func() {
@@ -34,18 +44,45 @@ var _ = Describe("Utils", func() {
 			Expect(PodIsRunning(pod)).To(BeTrue())
 		})
 	})
+	Context("PodIsReleasingGUIDs", func() {
+		It("Check if deleted pod has the guid release finalizer", func() {
+			deletionTime := metav1.Now()
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Finalizers: []string{"other", GUIDReleaseFinalizer}}}
+			Expect(PodIsReleasingGUIDs(pod)).To(BeFalse())
+			Expect(PodHasGUIDReleaseFinalizer(pod)).To(BeTrue())
+			pod.DeletionTimestamp = &deletionTime
+			Expect(PodIsReleasingGUIDs(pod)).To(BeTrue())
+			pod.Finalizers = []string{"other"}
+			Expect(PodIsReleasingGUIDs(pod)).To(BeFalse())
+		})
+	})
 	Context("IsPodNetworkConfiguredWithInfiniBand", func() {
 		It("Pod network is InfiniBand configured", func() {
 			network := &v1.NetworkSelectionElement{CNIArgs: &map[string]interface{}{
 				InfiniBandAnnotation: ConfiguredInfiniBandPod}}
-			Expect(IsPodNetworkConfiguredWithInfiniBand(network)).To(BeTrue())
+			Expect(IsPodNetworkConfiguredWithInfiniBand(network, "")).To(BeTrue())
 		})
 		It("Pod network is not InfiniBand configured", func() {
 			network := &v1.NetworkSelectionElement{CNIArgs: &map[string]interface{}{InfiniBandAnnotation: ""}}
-			Expect(IsPodNetworkConfiguredWithInfiniBand(network)).To(BeFalse())
+			Expect(IsPodNetworkConfiguredWithInfiniBand(network, "")).To(BeFalse())
 		})
 		It("Nil network", func() {
-			Expect(IsPodNetworkConfiguredWithInfiniBand(nil)).To(BeFalse())
+			Expect(IsPodNetworkConfiguredWithInfiniBand(nil, "")).To(BeFalse())
+		})
+		It("Pod network is InfiniBand configured with annotation key", func() {
+			network := &v1.NetworkSelectionElement{}
+			SetPodNetworkConfiguredWithInfiniBand(network, "fabric-b.infiniband.app")
+			Expect(*network.CNIArgs).To(Equal(map[string]interface{}{
+				"fabric-b.infiniband.app": ConfiguredInfiniBandPod}))
+			Expect(IsPodNetworkConfiguredWithInfiniBand(network, "fabric-b.infiniband.app")).To(BeTrue())
+			// networks configured with another key aren't recognized
+			Expect(IsPodNetworkConfiguredWithInfiniBand(network, "fabric-c.infiniband.app")).To(BeFalse())
+			Expect(IsPodNetworkConfiguredWithInfiniBand(network, "")).To(BeFalse())
+		})
+		It("Pod network configured with the default annotation key is recognized with any key", func() {
+			network := &v1.NetworkSelectionElement{CNIArgs: &map[string]interface{}{
+				InfiniBandAnnotation: ConfiguredInfiniBandPod}}
+			Expect(IsPodNetworkConfiguredWithInfiniBand(network, "fabric-b.infiniband.app")).To(BeTrue())
 		})
 	})
 	Context("PodNetworkHasGUID", func() {
@@ -66,6 +103,60 @@ var _ = Describe("Utils", func() {
 			Expect(hasGUID).To(BeFalse())
 		})
 	})
+	Context("GetPodNetworkGUIDStrict", func() {
+		guidNetwork := func(guid string) *v1.NetworkSelectionElement {
+			return &v1.NetworkSelectionElement{CNIArgs: &map[string]interface{}{"guid": guid}}
+		}
+		It("Get valid guid", func() {
+			guid, err := GetPodNetworkGUIDStrict(guidNetwork("02:00:00:00:00:00:00:01"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid).To(Equal("02:00:00:00:00:00:00:01"))
+		})
+		It("Get network without guid", func() {
+			_, err := GetPodNetworkGUIDStrict(&v1.NetworkSelectionElement{})
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrGUIDSanityFailed)).To(BeFalse())
+		})
+		It("Get invalid guids", func() {
+			for _, guid := range []string{"00:00:00:00:00:00:00:00", "FF:FF:FF:FF:FF:FF:FF:FF",
+				"00:00:00:00:00:00:00:01", "02:00:00:00:00:01", "guid"} {
+				_, err := GetPodNetworkGUIDStrict(guidNetwork(guid))
+				Expect(errors.Is(err, ErrGUIDSanityFailed)).To(BeTrue(), guid)
+			}
+		})
+	})
+	Context("FormatGUID", func() {
+		It("Format guid in every guid format", func() {
+			Expect(FormatGUID("02:00:00:00:00:00:00:0a", GUIDFormatColon)).To(Equal("02:00:00:00:00:00:00:0a"))
+			Expect(FormatGUID("02:00:00:00:00:00:00:0a", GUIDFormatDash)).To(Equal("02-00-00-00-00-00-00-0a"))
+			Expect(FormatGUID("02:00:00:00:00:00:00:0a", GUIDFormatHex)).To(Equal("020000000000000a"))
+		})
+		It("Get the annotated guid colon separated in every guid format", func() {
+			for _, format := range []string{GUIDFormatColon, GUIDFormatDash, GUIDFormatHex} {
+				network := &v1.NetworkSelectionElement{}
+				Expect(SetPodNetworkGUID(network, FormatGUID("02:00:00:00:00:00:00:0a", format))).To(Succeed())
+				guid, err := GetPodNetworkGUIDStrict(network)
+				Expect(err).ToNot(HaveOccurred(), format)
+				Expect(guid).To(Equal("02:00:00:00:00:00:00:0a"), format)
+				guidAddr, err := ParseFormattedGUID(FormatGUID(guid, format))
+				Expect(err).ToNot(HaveOccurred(), format)
+				Expect(guidAddr.String()).To(Equal(guid), format)
+			}
+		})
+	})
+	Context("GetGID", func() {
+		It("Get gid of the subnet prefix and the guid", func() {
+			gid, err := GetGID(net.ParseIP("fe80::"), net.HardwareAddr{0x02, 0, 0, 0, 0, 0, 0, 0x0a})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gid.String()).To(Equal("fe80::200:0:0:a"))
+		})
+		It("Get gid with invalid subnet prefix or guid", func() {
+			_, err := GetGID(net.ParseIP("10.0.0.0").To4(), net.HardwareAddr{0x02, 0, 0, 0, 0, 0, 0, 0x0a})
+			Expect(err).To(HaveOccurred())
+			_, err = GetGID(net.ParseIP("fe80::"), net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0a})
+			Expect(err).To(HaveOccurred())
+		})
+	})
 	Context("SetPodNetworkGUID", func() {
 		It("Set guid for network", func() {
 			network := &v1.NetworkSelectionElement{}
@@ -91,6 +182,18 @@ var _ = Describe("Utils", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(ibSpec.Type).To(Equal(InfiniBandSriovCni))
 		})
+		It("Get Ib SR-IOV Spec with pkey membership", func() {
+			spec := map[string]interface{}{"type": InfiniBandSriovCni, "pkey": "0x10", "membership": "limited"}
+			ibSpec, err := GetIbSriovCniFromNetwork(spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ibSpec.Membership).To(Equal("limited"))
+
+			spec = map[string]interface{}{"plugins": []interface{}{
+				map[string]interface{}{"type": InfiniBandSriovCni, "pkey": "0x10", "membership": "limited"}}}
+			ibSpec, err = GetIbSriovCniFromNetwork(spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ibSpec.Membership).To(Equal("limited"))
+		})
 		It("Get Ib SR-IOV Spec from invalid network spec", func() {
 			ibSpec, err := GetIbSriovCniFromNetwork(nil)
 			Expect(err).To(HaveOccurred())
@@ -116,4 +219,218 @@ var _ = Describe("Utils", func() {
 			Expect(ibSpec).To(BeNil())
 		})
 	})
+	Context("HasCniConfig", func() {
+		It("Network spec with cni config", func() {
+			Expect(HasCniConfig(map[string]interface{}{"type": InfiniBandSriovCni})).To(BeTrue())
+			Expect(HasCniConfig(map[string]interface{}{"plugins": []interface{}{}})).To(BeTrue())
+		})
+		It("Network spec without cni config", func() {
+			Expect(HasCniConfig(map[string]interface{}{})).To(BeFalse())
+		})
+	})
+	Context("GetIbSriovCniFromConfigMap", func() {
+		It("Get Ib SR-IOV Spec from ConfigMap", func() {
+			configMap := &kapi.ConfigMap{Data: map[string]string{
+				"10-other.conf": `{"type": "bridge"}`,
+				"20-ib.conf":    `{"type": "ib-sriov", "pkey": "0x10"}`}}
+
+			ibSpec, err := GetIbSriovCniFromConfigMap(configMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ibSpec.Type).To(Equal(InfiniBandSriovCni))
+			Expect(ibSpec.PKey).To(Equal("0x10"))
+		})
+		It("Get Ib SR-IOV Spec from ConfigMap without ib-sriov config", func() {
+			configMap := &kapi.ConfigMap{Data: map[string]string{"10-other.conf": `{"type": "bridge"}`}}
+
+			ibSpec, err := GetIbSriovCniFromConfigMap(configMap)
+			Expect(err).To(HaveOccurred())
+			Expect(ibSpec).To(BeNil())
+		})
+	})
+	Context("IsInfiniBandNetworkAttachmentDefinition", func() {
+		It("Network attachment definition with ib-sriov config", func() {
+			netAttDef := &v1.NetworkAttachmentDefinition{
+				Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}
+			Expect(IsInfiniBandNetworkAttachmentDefinition(netAttDef)).To(BeTrue())
+		})
+		It("Network attachment definition with other cni config", func() {
+			netAttDef := &v1.NetworkAttachmentDefinition{
+				Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "bridge"}`}}
+			Expect(IsInfiniBandNetworkAttachmentDefinition(netAttDef)).To(BeFalse())
+		})
+		It("Network attachment definition without config", func() {
+			Expect(IsInfiniBandNetworkAttachmentDefinition(&v1.NetworkAttachmentDefinition{})).To(BeFalse())
+		})
+	})
+	Context("GetNetworkAttachmentDefinitionGUIDRange", func() {
+		It("Network attachment definition with guid range", func() {
+			netAttDef := &v1.NetworkAttachmentDefinition{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				GUIDRangeStartAnnotation: "02:00:00:00:00:00:00:00",
+				GUIDRangeEndAnnotation:   "02:00:00:00:00:00:00:FF"}}}
+			rangeStart, rangeEnd, ok := GetNetworkAttachmentDefinitionGUIDRange(netAttDef)
+			Expect(ok).To(BeTrue())
+			Expect(rangeStart).To(Equal("02:00:00:00:00:00:00:00"))
+			Expect(rangeEnd).To(Equal("02:00:00:00:00:00:00:FF"))
+		})
+		It("Network attachment definition without guid range", func() {
+			netAttDef := &v1.NetworkAttachmentDefinition{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				GUIDRangeStartAnnotation: "02:00:00:00:00:00:00:00"}}}
+			_, _, ok := GetNetworkAttachmentDefinitionGUIDRange(netAttDef)
+			Expect(ok).To(BeFalse())
+		})
+	})
+	Context("GetPodGUIDReservations", func() {
+		It("Get the guids reserved for the pod networks", func() {
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				GUIDReservationAnnotation: "ib-net=02:00:00:00:00:00:00:10, ib-net-2=0200000000000011"}}}
+			reservations, err := GetPodGUIDReservations(pod)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reservations).To(Equal(map[string]string{"ib-net": "02:00:00:00:00:00:00:10",
+				"ib-net-2": "02:00:00:00:00:00:00:11"}))
+
+			reservations, err = GetPodGUIDReservations(&kapi.Pod{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reservations).To(BeEmpty())
+		})
+		It("Get the guids of invalid reservation annotation", func() {
+			for _, value := range []string{"02:00:00:00:00:00:00:10", "=02:00:00:00:00:00:00:10", "ib-net=invalid",
+				"ib-net=02:00:00:00:00:10", "ib-net=02:00:00:00:00:00:00:10,ib-net=02:00:00:00:00:00:00:11"} {
+				pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					GUIDReservationAnnotation: value}}}
+				_, err := GetPodGUIDReservations(pod)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+	})
+	Context("GetPodNetworks", func() {
+		It("Get all the networks of the network name", func() {
+			networks := []*v1.NetworkSelectionElement{
+				{Name: "test", InterfaceRequest: "net1"}, {Name: "other"}, {Name: "test", InterfaceRequest: "net2"}}
+			podNetworks, err := GetPodNetworks(networks, "test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(podNetworks).To(Equal([]*v1.NetworkSelectionElement{networks[0], networks[2]}))
+		})
+		It("Get networks of a missing network name", func() {
+			_, err := GetPodNetworks([]*v1.NetworkSelectionElement{{Name: "other"}}, "test")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("GetPodNetworkInterfaceKey", func() {
+		It("Get keys of the interfaces of a network", func() {
+			networks := []*v1.NetworkSelectionElement{
+				{Name: "test", InterfaceRequest: "net1"}, {Name: "other"}, {Name: "test", InterfaceRequest: "net2"}}
+			Expect(GetPodNetworkInterfaceKey(networks, networks[0])).To(Equal("test"))
+			Expect(GetPodNetworkInterfaceKey(networks, networks[1])).To(Equal("other"))
+			Expect(GetPodNetworkInterfaceKey(networks, networks[2])).To(Equal("test/1"))
+		})
+	})
+	Context("GetNamespaceFromGUID", func() {
+		It("Get namespace of guids with namespace prefix", func() {
+			pool, err := guid.NewPool(&config.GUIDPoolConfig{RangeStart: "02:00:00:00:00:00:00:00",
+				RangeEnd: "02:00:00:00:00:00:FF:FF", NamespacePrefix: "6"})
+			Expect(err).ToNot(HaveOccurred())
+			namespaceGUID, err := pool.GenerateNamespaceGUID("default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.AllocateGUID("pod-1", "default", "ib", namespaceGUID.String())).To(Succeed())
+			// explicitly requested guids may not have the namespace prefix
+			Expect(pool.AllocateGUID("pod-2", "default", "ib", "02:00:00:00:00:00:00:01")).To(Succeed())
+
+			namespace, found := GetNamespaceFromGUID(namespaceGUID.HardWareAddress(), pool)
+			Expect(found).To(BeTrue())
+			Expect(namespace).To(Equal("default"))
+			_, found = GetNamespaceFromGUID(guid.GUID(0x0200000000000001).HardWareAddress(), pool)
+			Expect(found).To(BeFalse())
+			_, found = GetNamespaceFromGUID(guid.GUID(0x0200000000000002).HardWareAddress(), pool)
+			Expect(found).To(BeFalse())
+		})
+	})
+	Context("GUIDSignature", func() {
+		var (
+			signingKey ed25519.PrivateKey
+			verifyKey  ed25519.PublicKey
+			pod        *kapi.Pod
+		)
+		BeforeEach(func() {
+			var err error
+			verifyKey, signingKey, err = ed25519.GenerateKey(nil)
+			Expect(err).ToNot(HaveOccurred())
+			pod = &kapi.Pod{ObjectMeta: metav1.ObjectMeta{UID: "pod-uid", Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"ib","cni-args":{"guid":"02:00:00:00:00:00:00:0A",` +
+					`"mellanox.infiniband.app":"configured"}},{"name":"eth"}]`}}}
+		})
+		It("Verify signed guid annotation", func() {
+			Expect(SignGUIDAnnotation(pod, "ib", "02:00:00:00:00:00:00:0a", signingKey, time.Now())).To(Succeed())
+			Expect(pod.Annotations).To(HaveKey(GUIDSignatureAnnotation))
+			Expect(VerifyGUIDAnnotation(pod, verifyKey, "")).To(Succeed())
+		})
+		It("Reject tampered guid annotation", func() {
+			Expect(SignGUIDAnnotation(pod, "ib", "02:00:00:00:00:00:00:0a", signingKey, time.Now())).To(Succeed())
+			pod.Annotations[v1.NetworkAttachmentAnnot] = `[{"name":"ib","cni-args":{` +
+				`"guid":"02:00:00:00:00:00:00:0B","mellanox.infiniband.app":"configured"}}]`
+			err := VerifyGUIDAnnotation(pod, verifyKey, "")
+			Expect(errors.Is(err, ErrGUIDSignatureInvalid)).To(BeTrue())
+		})
+		It("Reject guid annotation signed for another pod", func() {
+			Expect(SignGUIDAnnotation(pod, "ib", "02:00:00:00:00:00:00:0a", signingKey, time.Now())).To(Succeed())
+			pod.UID = "other-uid"
+			err := VerifyGUIDAnnotation(pod, verifyKey, "")
+			Expect(errors.Is(err, ErrGUIDSignatureInvalid)).To(BeTrue())
+		})
+		It("Report unsigned guid annotation", func() {
+			err := VerifyGUIDAnnotation(pod, verifyKey, "")
+			Expect(errors.Is(err, ErrGUIDUnsigned)).To(BeTrue())
+			Expect(errors.Is(err, ErrGUIDSignatureInvalid)).To(BeFalse())
+		})
+		It("Reject tampered guid of pod with unsigned guids", func() {
+			pod.Annotations[v1.NetworkAttachmentAnnot] = `[{"name":"ib","cni-args":{"guid":"02:00:00:00:00:00:00:0A",` +
+				`"mellanox.infiniband.app":"configured"}},{"name":"ib2","cni-args":{"guid":"02:00:00:00:00:00:00:0C",` +
+				`"mellanox.infiniband.app":"configured"}}]`
+			Expect(SignGUIDAnnotation(pod, "ib2", "02:00:00:00:00:00:00:0c", signingKey, time.Now())).To(Succeed())
+			Expect(errors.Is(VerifyGUIDAnnotation(pod, verifyKey, ""), ErrGUIDUnsigned)).To(BeTrue())
+
+			pod.Annotations[v1.NetworkAttachmentAnnot] = `[{"name":"ib","cni-args":{"guid":"02:00:00:00:00:00:00:0A",` +
+				`"mellanox.infiniband.app":"configured"}},{"name":"ib2","cni-args":{"guid":"02:00:00:00:00:00:00:0D",` +
+				`"mellanox.infiniband.app":"configured"}}]`
+			err := VerifyGUIDAnnotation(pod, verifyKey, "")
+			Expect(errors.Is(err, ErrGUIDSignatureInvalid)).To(BeTrue())
+		})
+		It("Parse PEM encoded signing key", func() {
+			keyBytes, err := x509.MarshalPKCS8PrivateKey(signingKey)
+			Expect(err).ToNot(HaveOccurred())
+			key, err := ParseGUIDSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(Equal(signingKey))
+
+			_, err = ParseGUIDSigningKey([]byte("invalid"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("ParsePKey", func() {
+		It("Parse hex pkey", func() {
+			pKey, err := ParsePKey("0x7fff")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0x7fff))
+		})
+		It("Parse hex pkey with letter digits", func() {
+			pKey, err := ParsePKey("0xbeef")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0xbeef))
+		})
+		It("Parse pkey with ibv_ prefix", func() {
+			pKey, err := ParsePKey("ibv_0x7fff")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0x7fff))
+		})
+		It("Parse pkey with p_key= prefix", func() {
+			pKey, err := ParsePKey("p_key=0xBEEF")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pKey).To(Equal(0xbeef))
+		})
+		It("Parse invalid pkeys", func() {
+			for _, pKey := range []string{"7fff", "ibv_7fff", "p_key=", "ibv_p_key=0x10", "0x", "0xzz", "b0x10"} {
+				_, err := ParsePKey(pKey)
+				Expect(err).To(HaveOccurred(), pKey)
+			}
+		})
+	})
 })
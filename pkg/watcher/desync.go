@@ -0,0 +1,115 @@
+package watcher
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
+)
+
+// desyncSampleSize is the maximum number of resources listed by a desync check
+const desyncSampleSize = 500
+
+// DesyncDetector is implemented by watchers which can detect their informer cache desync from the cluster state
+type DesyncDetector interface {
+	// DetectDesync compares live list of the resources against the informer cache, and returns the number of
+	// resources missing from the cache or deleted from the cluster. If it exceeds the threshold the cache is re-listed.
+	DetectDesync(threshold int) (int, error)
+}
+
+// DetectDesync compares a sample of up to desyncSampleSize resources, listed in key order, against the informer cache
+// without changing it. When the desync exceeds the threshold the informer re-lists the resources, it passes the
+// resources missing from the cache to the event handler as added and the deleted ones as deleted.
+func (w *watcher) DetectDesync(threshold int) (int, error) {
+	w.lock.Lock()
+	store, controller := w.store, w.controller
+	w.lock.Unlock()
+	if store == nil {
+		return 0, fmt.Errorf("watcher is not running")
+	}
+
+	if !controller.HasSynced() {
+		return 0, fmt.Errorf("watcher cache is not synced yet")
+	}
+
+	// snapshot the cache before listing, resources added afterwards may be missing from the live list
+	cachedKeys := store.ListKeys()
+
+	// list a single page, the pager of cache.ListWatch lists all the pages
+	list := w.watchList.List
+	if listWatch, ok := w.watchList.(*cache.ListWatch); ok {
+		list = listWatch.ListFunc
+	}
+	listObj, err := list(metav1.ListOptions{Limit: desyncSampleSize})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list resources: %v", err)
+	}
+	items, err := meta.ExtractList(listObj)
+	if err != nil {
+		return 0, fmt.Errorf("failed to extract listed resources: %v", err)
+	}
+	listMeta, err := meta.ListAccessor(listObj)
+	if err != nil {
+		return 0, fmt.Errorf("failed to access listed resources metadata: %v", err)
+	}
+
+	liveKeys := make(map[string]bool, len(items))
+	lastKey := ""
+	missing := 0
+	for _, item := range items {
+		key, keyErr := cache.MetaNamespaceKeyFunc(item)
+		if keyErr != nil {
+			return 0, keyErr
+		}
+		liveKeys[key] = true
+		if key > lastKey {
+			lastKey = key
+		}
+
+		if _, exists, _ := store.GetByKey(key); !exists {
+			missing++
+		}
+	}
+
+	deleted := 0
+	// a partial list samples the resources up to its last key
+	partial := listMeta.GetContinue() != ""
+	for _, key := range cachedKeys {
+		if liveKeys[key] || partial && key > lastKey {
+			continue
+		}
+		// skip resources which the informer already deleted
+		if _, exists, _ := store.GetByKey(key); exists {
+			deleted++
+		}
+	}
+
+	desync := missing + deleted
+	if desync <= threshold {
+		return desync, nil
+	}
+
+	log.Warn().Msgf("watcher cache is out of sync, %d sampled resources missing and %d resources deleted, re-listing",
+		missing, deleted)
+	metrics.WatcherDesyncs.Inc()
+	w.relist()
+	return desync, nil
+}
+
+// relist restarts the informer controller with the same cache, so the controller re-lists the resources and passes
+// the changes which the cache missed to the event handler
+func (w *watcher) relist() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	// the watcher was stopped
+	if w.stopChan == nil {
+		return
+	}
+
+	w.stopController()
+	w.runController()
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	ibapi "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// GUIDMigrationResource is the resource name of the guid migration crd
+const GUIDMigrationResource = ibapi.GUIDMigrationsResource
+
+type guidMigrationEventHandler struct {
+	addedMigrations   *utils.SynchronizedMap
+	deletedMigrations *utils.SynchronizedMap
+}
+
+// NewGUIDMigrationEventHandler returns event handler for the guid migrations, its added results are the migrations
+// which aren't finished mapped by "<namespace>/<name>" to their latest guid migration object.
+// Deleted migrations are removed from the added results and aren't tracked.
+func NewGUIDMigrationEventHandler() ResourceEventHandler {
+	return &guidMigrationEventHandler{
+		addedMigrations:   utils.NewSynchronizedMap(),
+		deletedMigrations: utils.NewSynchronizedMap(),
+	}
+}
+
+func (g *guidMigrationEventHandler) GetResourceObject() runtime.Object {
+	return &ibapi.GUIDMigration{TypeMeta: metav1.TypeMeta{Kind: GUIDMigrationResource}}
+}
+
+func (g *guidMigrationEventHandler) OnAdd(obj interface{}) {
+	log.Debug().Msgf("guid migration add event: %v", obj)
+	migration, ok := obj.(*ibapi.GUIDMigration)
+	if !ok {
+		log.Warn().Msgf("unexpected guid migration add event object %T", obj)
+		return
+	}
+	log.Info().Msgf("guid migration add event: namespace %s name %s phase %s", migration.Namespace,
+		migration.Name, migration.Status.Phase)
+
+	if migration.IsFinished() {
+		g.addedMigrations.Remove(migration.Namespace + "/" + migration.Name)
+		return
+	}
+
+	g.addedMigrations.Set(migration.Namespace+"/"+migration.Name, migration)
+}
+
+func (g *guidMigrationEventHandler) OnUpdate(oldObj, newObj interface{}) {
+	log.Debug().Msgf("guid migration update event: old %v, new %v", oldObj, newObj)
+	g.OnAdd(newObj)
+}
+
+func (g *guidMigrationEventHandler) OnDelete(obj interface{}) {
+	log.Debug().Msgf("guid migration delete event: %v", obj)
+	migration, ok := obj.(*ibapi.GUIDMigration)
+	if !ok {
+		log.Warn().Msgf("unexpected guid migration delete event object %T", obj)
+		return
+	}
+	log.Info().Msgf("guid migration delete event: namespace %s name %s", migration.Namespace, migration.Name)
+
+	g.addedMigrations.Remove(migration.Namespace + "/" + migration.Name)
+}
+
+func (g *guidMigrationEventHandler) GetResults() (*utils.SynchronizedMap, *utils.SynchronizedMap) {
+	return g.addedMigrations, g.deletedMigrations
+}
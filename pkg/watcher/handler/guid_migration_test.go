@@ -0,0 +1,72 @@
+package handler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	ibapi "github.com/Mellanox/ib-kubernetes/pkg/apis/ib/v1alpha1"
+)
+
+var _ = Describe("GUID Migration Event Handler", func() {
+	newMigration := func(phase ibapi.GUIDMigrationPhase) *ibapi.GUIDMigration {
+		return &ibapi.GUIDMigration{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+			Spec:   ibapi.GUIDMigrationSpec{NetworkName: "ib", NewGUID: "02:00:00:00:00:00:00:10"},
+			Status: ibapi.GUIDMigrationStatus{Phase: phase}}
+	}
+	Context("GetResourceObject", func() {
+		It("Get guid migration resource object", func() {
+			eventHandler := NewGUIDMigrationEventHandler()
+			Expect(eventHandler.GetResourceObject().GetObjectKind().GroupVersionKind().Kind).To(
+				Equal(GUIDMigrationResource))
+		})
+	})
+	Context("OnAdd", func() {
+		It("On add guid migration event", func() {
+			eventHandler := NewGUIDMigrationEventHandler()
+			migration := newMigration("")
+			eventHandler.OnAdd(migration)
+			addedMigrations, deletedMigrations := eventHandler.GetResults()
+			Expect(addedMigrations.Items).To(HaveKeyWithValue("default/test", migration))
+			Expect(deletedMigrations.Items).To(BeEmpty())
+		})
+		It("On add finished guid migration event", func() {
+			eventHandler := NewGUIDMigrationEventHandler()
+			eventHandler.OnAdd(newMigration(ibapi.GUIDMigrationComplete))
+			eventHandler.OnAdd(newMigration(ibapi.GUIDMigrationFailed))
+			addedMigrations, _ := eventHandler.GetResults()
+			Expect(addedMigrations.Items).To(BeEmpty())
+		})
+	})
+	Context("OnUpdate", func() {
+		It("On update guid migration event", func() {
+			eventHandler := NewGUIDMigrationEventHandler()
+			eventHandler.OnAdd(newMigration(""))
+			adding := newMigration(ibapi.GUIDMigrationAdding)
+			eventHandler.OnUpdate(newMigration(""), adding)
+			addedMigrations, _ := eventHandler.GetResults()
+			Expect(addedMigrations.Items).To(HaveKeyWithValue("default/test", adding))
+
+			eventHandler.OnUpdate(adding, newMigration(ibapi.GUIDMigrationComplete))
+			Expect(addedMigrations.Items).To(BeEmpty())
+		})
+	})
+	Context("OnDelete", func() {
+		It("On delete guid migration event", func() {
+			eventHandler := NewGUIDMigrationEventHandler()
+			eventHandler.OnAdd(newMigration(ibapi.GUIDMigrationVerifying))
+			eventHandler.OnDelete(newMigration(ibapi.GUIDMigrationVerifying))
+			addedMigrations, deletedMigrations := eventHandler.GetResults()
+			Expect(addedMigrations.Items).To(BeEmpty())
+			Expect(deletedMigrations.Items).To(BeEmpty())
+		})
+		It("On delete event of unexpected object", func() {
+			eventHandler := NewGUIDMigrationEventHandler()
+			eventHandler.OnAdd(newMigration(""))
+			eventHandler.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/test"})
+			addedMigrations, _ := eventHandler.GetResults()
+			Expect(addedMigrations.Items).To(HaveLen(1))
+		})
+	})
+})
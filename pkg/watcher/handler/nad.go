@@ -0,0 +1,81 @@
+package handler
+
+import (
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// NetworkAttachmentDefinitionResource is the resource name of the network attachment definition crd
+const NetworkAttachmentDefinitionResource = "network-attachment-definitions"
+
+type netAttDefEventHandler struct {
+	addedNetAttDefs   *utils.SynchronizedMap
+	deletedNetAttDefs *utils.SynchronizedMap
+}
+
+// NewNetworkAttachmentDefinitionEventHandler returns event handler for InfiniBand network attachment definitions,
+// its results are mapped by network id to the network attachment definition object
+func NewNetworkAttachmentDefinitionEventHandler() ResourceEventHandler {
+	return &netAttDefEventHandler{
+		addedNetAttDefs:   utils.NewSynchronizedMap(),
+		deletedNetAttDefs: utils.NewSynchronizedMap(),
+	}
+}
+
+func (n *netAttDefEventHandler) GetResourceObject() runtime.Object {
+	return &v1.NetworkAttachmentDefinition{TypeMeta: metav1.TypeMeta{Kind: NetworkAttachmentDefinitionResource}}
+}
+
+func (n *netAttDefEventHandler) OnAdd(obj interface{}) {
+	log.Debug().Msgf("network attachment definition add event: %v", obj)
+	netAttDef := obj.(*v1.NetworkAttachmentDefinition)
+	log.Info().Msgf("network attachment definition add event: namespace %s name %s",
+		netAttDef.Namespace, netAttDef.Name)
+
+	if !utils.IsInfiniBandNetworkAttachmentDefinition(netAttDef) {
+		log.Debug().Msg("network attachment definition doesn't use InfiniBand SR-IOV CNI")
+		return
+	}
+
+	n.addedNetAttDefs.Set(utils.GenerateNetAttDefNetworkID(netAttDef), netAttDef)
+}
+
+func (n *netAttDefEventHandler) OnUpdate(oldObj, newObj interface{}) {
+	log.Debug().Msgf("network attachment definition update event: old %v, new %v", oldObj, newObj)
+	netAttDef := newObj.(*v1.NetworkAttachmentDefinition)
+
+	// re-process network attachment definitions that lost their guid range annotations
+	if _, _, ok := utils.GetNetworkAttachmentDefinitionGUIDRange(netAttDef); ok {
+		return
+	}
+
+	n.OnAdd(netAttDef)
+}
+
+func (n *netAttDefEventHandler) OnDelete(obj interface{}) {
+	log.Debug().Msgf("network attachment definition delete event: %v", obj)
+	netAttDef, ok := obj.(*v1.NetworkAttachmentDefinition)
+	if !ok {
+		log.Warn().Msgf("unexpected network attachment definition delete event object %T", obj)
+		return
+	}
+	log.Info().Msgf("network attachment definition delete event: namespace %s name %s",
+		netAttDef.Namespace, netAttDef.Name)
+
+	if !utils.IsInfiniBandNetworkAttachmentDefinition(netAttDef) {
+		log.Debug().Msg("network attachment definition doesn't use InfiniBand SR-IOV CNI")
+		return
+	}
+
+	networkID := utils.GenerateNetAttDefNetworkID(netAttDef)
+	n.addedNetAttDefs.Remove(networkID)
+	n.deletedNetAttDefs.Set(networkID, netAttDef)
+}
+
+func (n *netAttDefEventHandler) GetResults() (*utils.SynchronizedMap, *utils.SynchronizedMap) {
+	return n.addedNetAttDefs, n.deletedNetAttDefs
+}
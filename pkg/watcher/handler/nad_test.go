@@ -0,0 +1,73 @@
+package handler
+
+import (
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+var _ = Describe("Network Attachment Definition Event Handler", func() {
+	ibNetAttDef := func(namespace, name string) *v1.NetworkAttachmentDefinition {
+		return &v1.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}
+	}
+	Context("Create new Network Attachment Definition Event Handler", func() {
+		It("Create new Network Attachment Definition Event Handler", func() {
+			eventHandler := NewNetworkAttachmentDefinitionEventHandler()
+			Expect(eventHandler.GetResourceObject().GetObjectKind().GroupVersionKind().Kind).To(
+				Equal("network-attachment-definitions"))
+		})
+	})
+	Context("OnAdd", func() {
+		It("On add network attachment definition event", func() {
+			otherNetAttDef := &v1.NetworkAttachmentDefinition{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "bridge"},
+				Spec:       v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "bridge"}`}}
+
+			eventHandler := NewNetworkAttachmentDefinitionEventHandler()
+			eventHandler.OnAdd(ibNetAttDef("default", "test"))
+			eventHandler.OnAdd(ibNetAttDef("kube-system", "test"))
+			eventHandler.OnAdd(otherNetAttDef)
+
+			addMap, _ := eventHandler.GetResults()
+			Expect(len(addMap.Items)).To(Equal(2))
+			Expect(addMap.Items).To(HaveKey("default_test"))
+			Expect(addMap.Items).To(HaveKey("kube-system_test"))
+		})
+	})
+	Context("OnUpdate", func() {
+		It("On update network attachment definition event", func() {
+			netAttDef := ibNetAttDef("default", "test")
+			rangeNetAttDef := ibNetAttDef("default", "range")
+			rangeNetAttDef.Annotations = map[string]string{
+				utils.GUIDRangeStartAnnotation: "02:00:00:00:00:00:00:00",
+				utils.GUIDRangeEndAnnotation:   "02:00:00:00:00:00:00:FF"}
+
+			eventHandler := NewNetworkAttachmentDefinitionEventHandler()
+			eventHandler.OnUpdate(netAttDef, netAttDef)
+			eventHandler.OnUpdate(rangeNetAttDef, rangeNetAttDef)
+
+			addMap, _ := eventHandler.GetResults()
+			Expect(len(addMap.Items)).To(Equal(1))
+			Expect(addMap.Items).To(HaveKey("default_test"))
+		})
+	})
+	Context("OnDelete", func() {
+		It("On delete network attachment definition event", func() {
+			netAttDef := ibNetAttDef("default", "test")
+
+			eventHandler := NewNetworkAttachmentDefinitionEventHandler()
+			eventHandler.OnAdd(netAttDef)
+			eventHandler.OnDelete(netAttDef)
+
+			addMap, deleteMap := eventHandler.GetResults()
+			Expect(len(addMap.Items)).To(Equal(0))
+			Expect(len(deleteMap.Items)).To(Equal(1))
+			Expect(deleteMap.Items["default_test"]).To(Equal(netAttDef))
+		})
+	})
+})
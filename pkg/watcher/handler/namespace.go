@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// NamespaceResource is the resource name of the namespaces
+const NamespaceResource = "namespaces"
+
+type namespaceEventHandler struct {
+	addedNamespaces   *utils.SynchronizedMap
+	deletedNamespaces *utils.SynchronizedMap
+}
+
+// NewNamespaceEventHandler returns event handler for the namespaces deletion, its deleted results are mapped by
+// namespace name to the namespace object. Added namespaces aren't tracked.
+func NewNamespaceEventHandler() ResourceEventHandler {
+	return &namespaceEventHandler{
+		addedNamespaces:   utils.NewSynchronizedMap(),
+		deletedNamespaces: utils.NewSynchronizedMap(),
+	}
+}
+
+func (n *namespaceEventHandler) GetResourceObject() runtime.Object {
+	return &kapi.Namespace{TypeMeta: metav1.TypeMeta{Kind: NamespaceResource}}
+}
+
+func (n *namespaceEventHandler) OnAdd(obj interface{}) {
+	log.Debug().Msgf("namespace add event: %v", obj)
+}
+
+func (n *namespaceEventHandler) OnUpdate(oldObj, newObj interface{}) {
+	log.Debug().Msgf("namespace update event: old %v, new %v", oldObj, newObj)
+}
+
+func (n *namespaceEventHandler) OnDelete(obj interface{}) {
+	log.Debug().Msgf("namespace delete event: %v", obj)
+	namespace, ok := obj.(*kapi.Namespace)
+	if !ok {
+		log.Warn().Msgf("unexpected namespace delete event object %T", obj)
+		return
+	}
+	log.Info().Msgf("namespace delete event: name %s", namespace.Name)
+
+	n.deletedNamespaces.Set(namespace.Name, namespace)
+}
+
+func (n *namespaceEventHandler) GetResults() (*utils.SynchronizedMap, *utils.SynchronizedMap) {
+	return n.addedNamespaces, n.deletedNamespaces
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var _ = Describe("Namespace Event Handler", func() {
+	namespace := &kapi.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	Context("GetResourceObject", func() {
+		It("Get namespace resource object", func() {
+			eventHandler := NewNamespaceEventHandler()
+			Expect(eventHandler.GetResourceObject().GetObjectKind().GroupVersionKind().Kind).To(
+				Equal(NamespaceResource))
+		})
+	})
+	Context("OnDelete", func() {
+		It("On delete namespace event", func() {
+			eventHandler := NewNamespaceEventHandler()
+			eventHandler.OnAdd(namespace)
+			addedNamespaces, deletedNamespaces := eventHandler.GetResults()
+			Expect(addedNamespaces.Items).To(BeEmpty())
+			Expect(deletedNamespaces.Items).To(BeEmpty())
+
+			eventHandler.OnDelete(namespace)
+			Expect(deletedNamespaces.Items).To(HaveKeyWithValue("test", namespace))
+		})
+		It("On delete event of unexpected object", func() {
+			eventHandler := NewNamespaceEventHandler()
+			eventHandler.OnDelete(cache.DeletedFinalStateUnknown{Key: "test"})
+			_, deletedNamespaces := eventHandler.GetResults()
+			Expect(deletedNamespaces.Items).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+const (
+	// NodeResource is the resource name of the nodes
+	NodeResource = "nodes"
+	// RDMAHCAResource is the node allocatable resource of the SR-IOV VFs
+	RDMAHCAResource = kapi.ResourceName("rdma/hca")
+	// NumVFsAnnotation is the node annotation of the SR-IOV VFs count, used if the node has no RDMAHCAResource
+	NumVFsAnnotation = "ib.mellanox.com/num-vfs"
+)
+
+type nodeEventHandler struct {
+	updatedNodes *utils.SynchronizedMap
+	deletedNodes *utils.SynchronizedMap
+}
+
+// NewNodeEventHandler returns event handler for the nodes SR-IOV VFs count changes,
+// its results are mapped by node name to the node object
+func NewNodeEventHandler() ResourceEventHandler {
+	return &nodeEventHandler{
+		updatedNodes: utils.NewSynchronizedMap(),
+		deletedNodes: utils.NewSynchronizedMap(),
+	}
+}
+
+// GetNodeVFs returns the SR-IOV VFs count of the node, from its rdma/hca allocatable resource or its
+// NumVFsAnnotation annotation. It returns false if the node has neither.
+func GetNodeVFs(node *kapi.Node) (int64, bool) {
+	if quantity, ok := node.Status.Allocatable[RDMAHCAResource]; ok {
+		return quantity.Value(), true
+	}
+
+	if value, ok := node.Annotations[NumVFsAnnotation]; ok {
+		numVFs, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Warn().Msgf("invalid %s annotation %q of node %s", NumVFsAnnotation, value, node.Name)
+			return 0, false
+		}
+		return numVFs, true
+	}
+
+	return 0, false
+}
+
+func (n *nodeEventHandler) GetResourceObject() runtime.Object {
+	return &kapi.Node{TypeMeta: metav1.TypeMeta{Kind: NodeResource}}
+}
+
+func (n *nodeEventHandler) OnAdd(obj interface{}) {
+	log.Debug().Msgf("node add event: %v", obj)
+	node := obj.(*kapi.Node)
+
+	if _, ok := GetNodeVFs(node); !ok {
+		log.Debug().Msgf("node %s has no SR-IOV VFs count", node.Name)
+		return
+	}
+
+	n.updatedNodes.Set(node.Name, node)
+}
+
+func (n *nodeEventHandler) OnUpdate(oldObj, newObj interface{}) {
+	oldNode := oldObj.(*kapi.Node)
+	newNode := newObj.(*kapi.Node)
+
+	// nodes are updated frequently by their status heartbeats, ignore updates which don't change the VFs count
+	oldVFs, oldOk := GetNodeVFs(oldNode)
+	newVFs, newOk := GetNodeVFs(newNode)
+	if oldOk == newOk && oldVFs == newVFs {
+		return
+	}
+
+	log.Info().Msgf("node %s SR-IOV VFs count changed from %d to %d", newNode.Name, oldVFs, newVFs)
+	n.OnAdd(newNode)
+}
+
+func (n *nodeEventHandler) OnDelete(obj interface{}) {
+	log.Debug().Msgf("node delete event: %v", obj)
+	node, ok := obj.(*kapi.Node)
+	if !ok {
+		log.Warn().Msgf("unexpected node delete event object %T", obj)
+		return
+	}
+
+	n.updatedNodes.Remove(node.Name)
+	n.deletedNodes.Set(node.Name, node)
+}
+
+func (n *nodeEventHandler) GetResults() (*utils.SynchronizedMap, *utils.SynchronizedMap) {
+	return n.updatedNodes, n.deletedNodes
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Node Event Handler", func() {
+	allocatableNode := func(numVFs string) *kapi.Node {
+		return &kapi.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}, Status: kapi.NodeStatus{
+			Allocatable: kapi.ResourceList{RDMAHCAResource: resource.MustParse(numVFs)}}}
+	}
+	Context("GetResourceObject", func() {
+		It("Get node resource object", func() {
+			eventHandler := NewNodeEventHandler()
+			Expect(eventHandler.GetResourceObject().GetObjectKind().GroupVersionKind().Kind).To(
+				Equal(NodeResource))
+		})
+	})
+	Context("GetNodeVFs", func() {
+		It("Get node VFs from allocatable resource and annotation", func() {
+			numVFs, ok := GetNodeVFs(allocatableNode("8"))
+			Expect(ok).To(BeTrue())
+			Expect(numVFs).To(Equal(int64(8)))
+
+			numVFs, ok = GetNodeVFs(&kapi.Node{ObjectMeta: metav1.ObjectMeta{
+				Name: "node", Annotations: map[string]string{NumVFsAnnotation: "16"}}})
+			Expect(ok).To(BeTrue())
+			Expect(numVFs).To(Equal(int64(16)))
+		})
+		It("Get VFs of node without VFs count", func() {
+			_, ok := GetNodeVFs(&kapi.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}})
+			Expect(ok).To(BeFalse())
+			_, ok = GetNodeVFs(&kapi.Node{ObjectMeta: metav1.ObjectMeta{
+				Name: "node", Annotations: map[string]string{NumVFsAnnotation: "many"}}})
+			Expect(ok).To(BeFalse())
+		})
+	})
+	Context("OnUpdate", func() {
+		It("On update node event with changed VFs count", func() {
+			eventHandler := NewNodeEventHandler()
+			eventHandler.OnUpdate(allocatableNode("8"), allocatableNode("8"))
+			updatedNodes, _ := eventHandler.GetResults()
+			Expect(updatedNodes.Items).To(BeEmpty())
+
+			eventHandler.OnUpdate(allocatableNode("8"), allocatableNode("16"))
+			Expect(updatedNodes.Items).To(HaveKey("node"))
+		})
+	})
+})
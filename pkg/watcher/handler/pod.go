@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 
@@ -9,22 +10,75 @@ import (
 	"github.com/rs/zerolog/log"
 	kapi "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 
+	"github.com/Mellanox/ib-kubernetes/pkg/metrics"
 	"github.com/Mellanox/ib-kubernetes/pkg/utils"
 )
 
+// PendingQuotaHandler is implemented by event handlers which hold pods until their namespace quota is available
+type PendingQuotaHandler interface {
+	// RecheckPendingQuota moves the pending pods of namespaces which are within their quota to the add results
+	RecheckPendingQuota()
+}
+
+// PodIPChangesTracker is implemented by event handlers which can re-verify the pods guids when their IP changes
+type PodIPChangesTracker interface {
+	// TrackPodIPChanges re-queues the InfiniBand networks of pods whose IP changed and whose guid is missing or
+	// invalid, to re-allocate their guids. It must be called before the handler receives events.
+	TrackPodIPChanges()
+}
+
+// PodAnnotationsSelector is implemented by event handlers which can add only the pods selected by their annotations
+type PodAnnotationsSelector interface {
+	// SelectPodsByAnnotations sets the selector of the pods annotations, the added pods which aren't selected are
+	// ignored. It must be called before the handler receives events.
+	SelectPodsByAnnotations(selector labels.Selector)
+}
+
+// PodNamespacesFilter is implemented by event handlers which can add only the pods of some namespaces
+type PodNamespacesFilter interface {
+	// FilterNamespaces sets the namespaces of the added pods, all the namespaces if watched is empty, and the
+	// namespaces whose added pods are ignored. It must be called before the handler receives events.
+	FilterNamespaces(watched, excluded []string)
+}
+
+// InfiniBandAnnotationKeySetter is implemented by event handlers which can recognize the configured pods networks by
+// another cni-args annotation key than utils.InfiniBandAnnotation
+type InfiniBandAnnotationKeySetter interface {
+	// SetInfiniBandAnnotationKey sets the cni-args key of the pods networks configured with InfiniBand, the pods
+	// networks configured with the default key are still recognized. It must be called before the handler receives
+	// events.
+	SetInfiniBandAnnotationKey(key string)
+}
+
 type podEventHandler struct {
-	retryPods   sync.Map
-	addedPods   *utils.SynchronizedMap
-	deletedPods *utils.SynchronizedMap
+	retryPods         sync.Map
+	releasingPods     sync.Map // deleted pods kept by the guid release finalizer, added to the results once
+	pendingQuota      sync.Map // pods of namespaces which exceeded their InfiniBand quota mapped by pod uid
+	quotaChecker      QuotaChecker
+	trackPodIPChanges bool
+	// selector of the added pods annotations, kubernetes field selectors don't support annotations
+	annotationSelector labels.Selector
+	// namespaces of the added pods, all the namespaces if empty, and namespaces whose added pods are ignored
+	watchedNamespaces  map[string]bool
+	excludedNamespaces map[string]bool
+	// cni-args key of the pods networks configured with InfiniBand, utils.InfiniBandAnnotation if empty
+	ibAnnotationKey string
+	addedPods       *utils.SynchronizedMap
+	deletedPods     *utils.SynchronizedMap
 }
 
-func NewPodEventHandler() ResourceEventHandler {
+// NewPodEventHandler returns event handler for pods, pods of namespaces which exceeded their InfiniBand
+// resource quota are held until the quota is available. The quota isn't checked if quotaChecker is nil.
+func NewPodEventHandler(quotaChecker QuotaChecker) ResourceEventHandler {
 	eventHandler := &podEventHandler{
-		retryPods:   sync.Map{},
-		addedPods:   utils.NewSynchronizedMap(),
-		deletedPods: utils.NewSynchronizedMap(),
+		retryPods:    sync.Map{},
+		pendingQuota: sync.Map{},
+		quotaChecker: quotaChecker,
+		addedPods:    utils.NewSynchronizedMap(),
+		deletedPods:  utils.NewSynchronizedMap(),
 	}
 
 	return eventHandler
@@ -39,11 +93,22 @@ func (p *podEventHandler) OnAdd(obj interface{}) {
 	pod := obj.(*kapi.Pod)
 	log.Info().Msgf("pod add Event: namespace %s name %s", pod.Namespace, pod.Name)
 
+	// the pod deletion was missed, e.g while the daemon was down
+	if utils.PodIsReleasingGUIDs(pod) {
+		p.addReleasingPod(pod)
+		return
+	}
+
 	if !utils.PodWantsNetwork(pod) {
 		log.Debug().Msg("pod doesn't require network")
 		return
 	}
 
+	if !p.namespaceSelected(pod.Namespace) {
+		log.Debug().Msgf("pod namespace %s isn't watched", pod.Namespace)
+		return
+	}
+
 	if utils.PodIsRunning(pod) {
 		log.Debug().Msg("pod is already in running state")
 		return
@@ -54,11 +119,23 @@ func (p *podEventHandler) OnAdd(obj interface{}) {
 		return
 	}
 
+	if p.annotationSelector != nil && !p.annotationSelector.Matches(labels.Set(pod.Annotations)) {
+		log.Debug().Msgf("pod annotations aren't selected by \"%v\"", p.annotationSelector)
+		return
+	}
+
 	if !utils.PodScheduled(pod) {
 		p.retryPods.Store(pod.UID, true)
 		return
 	}
 
+	if !p.canAllocateGUID(pod.Namespace) {
+		log.Info().Msgf("pod add event: namespace %s exceeded its InfiniBand quota, pod %s is pending",
+			pod.Namespace, pod.Name)
+		p.pendingQuota.Store(pod.UID, pod)
+		return
+	}
+
 	if err := p.addNetworksFromPod(pod); err != nil {
 		log.Err(err)
 		return
@@ -72,14 +149,33 @@ func (p *podEventHandler) OnUpdate(oldObj, newObj interface{}) {
 	pod := newObj.(*kapi.Pod)
 	log.Info().Msgf("pod update event: namespace %s name %s", pod.Namespace, pod.Name)
 
+	if utils.PodIsReleasingGUIDs(pod) {
+		p.addReleasingPod(pod)
+		return
+	}
+
 	if !utils.PodWantsNetwork(pod) {
 		log.Debug().Msg("pod doesn't require network")
 		return
 	}
 
+	if !p.namespaceSelected(pod.Namespace) {
+		log.Debug().Msgf("pod namespace %s isn't watched", pod.Namespace)
+		return
+	}
+
+	if oldPod, ok := oldObj.(*kapi.Pod); ok {
+		if containerRestarted(oldPod, pod) {
+			p.requeueRestartedPod(pod)
+		} else if p.trackPodIPChanges && podIPChanged(oldPod, pod) {
+			p.requeueInvalidGUIDNetworks(pod)
+		}
+	}
+
 	if utils.PodIsRunning(pod) {
 		log.Debug().Msg("pod is already in running state")
 		p.retryPods.Delete(pod.UID)
+		p.pendingQuota.Delete(pod.UID)
 		return
 	}
 
@@ -93,6 +189,14 @@ func (p *podEventHandler) OnUpdate(oldObj, newObj interface{}) {
 		return
 	}
 
+	if !p.canAllocateGUID(pod.Namespace) {
+		log.Info().Msgf("pod update event: namespace %s exceeded its InfiniBand quota, pod %s is pending",
+			pod.Namespace, pod.Name)
+		p.retryPods.Delete(pod.UID)
+		p.pendingQuota.Store(pod.UID, pod)
+		return
+	}
+
 	if err := p.addNetworksFromPod(pod); err != nil {
 		log.Err(err)
 		return
@@ -107,27 +211,62 @@ func (p *podEventHandler) OnDelete(obj interface{}) {
 	pod := obj.(*kapi.Pod)
 	log.Info().Msgf("pod delete event: namespace %s name %s", pod.Namespace, pod.Name)
 
-	// make sure this pod won't be in the retry pods
+	// make sure this pod won't be in the retry or pending pods
 	p.retryPods.Delete(pod.UID)
+	p.pendingQuota.Delete(pod.UID)
+
+	// the guids of the pod were released, and may be allocated again, while the finalizer kept it
+	if _, releasing := p.releasingPods.Load(pod.UID); releasing {
+		p.releasingPods.Delete(pod.UID)
+		log.Info().Msgf("pod delete event: guids of namespace %s name %s were released by its finalizer",
+			pod.Namespace, pod.Name)
+		return
+	}
 
+	if p.addDeletedPod(pod) {
+		log.Info().Msgf("successfully deleted namespace %s name %s", pod.Namespace, pod.Name)
+	}
+}
+
+// addReleasingPod adds the InfiniBand networks of the deleted pod kept by the guid release finalizer to the delete
+// results once, the daemon removes the finalizer once its guids are released
+func (p *podEventHandler) addReleasingPod(pod *kapi.Pod) {
+	p.retryPods.Delete(pod.UID)
+	p.pendingQuota.Delete(pod.UID)
+	if _, added := p.releasingPods.LoadOrStore(pod.UID, true); added {
+		return
+	}
+
+	if !p.addDeletedPod(pod) {
+		log.Warn().Msgf("pod namespace %s name %s kept by finalizer %s has no network annotation to release, "+
+			"the finalizer should be removed manually", pod.Namespace, pod.Name, utils.GUIDReleaseFinalizer)
+		return
+	}
+	log.Info().Msgf("pod namespace %s name %s is deleted, releasing its guids before removing its finalizer",
+		pod.Namespace, pod.Name)
+}
+
+// addDeletedPod adds the InfiniBand networks of the deleted pod to the delete results. It returns false if the pod
+// doesn't require network or has no network annotation to parse.
+func (p *podEventHandler) addDeletedPod(pod *kapi.Pod) bool {
 	if !utils.PodWantsNetwork(pod) {
 		log.Debug().Msg("pod doesn't require network")
-		return
+		return false
 	}
 
 	if !utils.HasNetworkAttachment(pod) {
 		log.Debug().Msgf("pod doesn't have network annotation \"%v\"", v1.NetworkAttachmentAnnot)
-		return
+		return false
 	}
 
 	networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
 	if err != nil {
 		log.Error().Msgf("failed to parse network annotations with error: %v", err)
-		return
+		return false
 	}
 
 	for _, network := range networks {
-		if !utils.IsPodNetworkConfiguredWithInfiniBand(network) {
+		if !utils.IsPodNetworkConfiguredWithInfiniBand(network, p.ibAnnotationKey) {
 			continue
 		}
 
@@ -147,14 +286,194 @@ func (p *podEventHandler) OnDelete(obj interface{}) {
 		}
 		p.deletedPods.Set(networkID, pods)
 	}
-
-	log.Info().Msgf("successfully deleted namespace %s name %s", pod.Namespace, pod.Name)
+	return true
 }
 
 func (p *podEventHandler) GetResults() (*utils.SynchronizedMap, *utils.SynchronizedMap) {
 	return p.addedPods, p.deletedPods
 }
 
+func (p *podEventHandler) TrackPodIPChanges() {
+	p.trackPodIPChanges = true
+}
+
+func (p *podEventHandler) SelectPodsByAnnotations(selector labels.Selector) {
+	p.annotationSelector = selector
+}
+
+func (p *podEventHandler) FilterNamespaces(watched, excluded []string) {
+	p.watchedNamespaces = map[string]bool{}
+	for _, namespace := range watched {
+		p.watchedNamespaces[namespace] = true
+	}
+	p.excludedNamespaces = map[string]bool{}
+	for _, namespace := range excluded {
+		p.excludedNamespaces[namespace] = true
+	}
+}
+
+func (p *podEventHandler) SetInfiniBandAnnotationKey(key string) {
+	p.ibAnnotationKey = key
+}
+
+// namespaceSelected checks if the added pods of the namespace are handled, the deleted pods of all the namespaces
+// are handled to release the guids allocated before the namespace was filtered
+func (p *podEventHandler) namespaceSelected(namespace string) bool {
+	if p.excludedNamespaces[namespace] {
+		return false
+	}
+
+	return len(p.watchedNamespaces) == 0 || p.watchedNamespaces[namespace]
+}
+
+func (p *podEventHandler) RecheckPendingQuota() {
+	// check every namespace quota once per recheck
+	namespaces := map[string]bool{}
+	p.pendingQuota.Range(func(key, value interface{}) bool {
+		pod := value.(*kapi.Pod)
+		canAllocate, ok := namespaces[pod.Namespace]
+		if !ok {
+			canAllocate = p.canAllocateGUID(pod.Namespace)
+			namespaces[pod.Namespace] = canAllocate
+		}
+
+		if !canAllocate {
+			return true
+		}
+
+		p.pendingQuota.Delete(key)
+		if err := p.addNetworksFromPod(pod); err != nil {
+			log.Err(err)
+			return true
+		}
+
+		log.Info().Msgf("pod namespace %s name %s is within quota, added", pod.Namespace, pod.Name)
+		return true
+	})
+}
+
+// containerRestarted checks if the restart count of any of the pod containers increased
+func containerRestarted(oldPod, newPod *kapi.Pod) bool {
+	restartCounts := map[string]int32{}
+	for _, status := range oldPod.Status.ContainerStatuses {
+		restartCounts[status.Name] = status.RestartCount
+	}
+
+	for _, status := range newPod.Status.ContainerStatuses {
+		if status.RestartCount > restartCounts[status.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// requeueRestartedPod adds the InfiniBand networks of the pod, which are already configured, to the add results,
+// the VF may be re-initialized on container restart so the guid annotation and pKey membership are re-verified
+func (p *podEventHandler) requeueRestartedPod(pod *kapi.Pod) {
+	if !utils.HasNetworkAttachment(pod) {
+		return
+	}
+
+	networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+	if err != nil {
+		log.Error().Msgf("failed to parse network annotations with error: %v", err)
+		return
+	}
+
+	var requeued bool
+	for _, network := range networks {
+		if !utils.IsPodNetworkConfiguredWithInfiniBand(network, p.ibAnnotationKey) {
+			continue
+		}
+
+		networkID := utils.GenerateNetworkID(network)
+		pods, ok := p.addedPods.Get(networkID)
+		if !ok {
+			pods = []*kapi.Pod{pod}
+		} else {
+			pods = append(pods.([]*kapi.Pod), pod)
+		}
+		p.addedPods.Set(networkID, pods)
+		requeued = true
+	}
+
+	if requeued {
+		metrics.PodRestartRequeues.Inc()
+		log.Info().Msgf("pod update event: container of pod namespace %s name %s restarted, re-queued its "+
+			"InfiniBand networks", pod.Namespace, pod.Name)
+	}
+}
+
+// podIPChanged checks if the IP of the pod changed, the first IP assignment of the pod isn't a change
+func podIPChanged(oldPod, newPod *kapi.Pod) bool {
+	return oldPod.Status.PodIP != "" && oldPod.Status.PodIP != newPod.Status.PodIP
+}
+
+// requeueInvalidGUIDNetworks adds the InfiniBand networks of the pod whose guid is missing or invalid to the add
+// results with their guid removed, so new guids are allocated for them. The IP of the pod changed, e.g on CNI
+// reconvergence, and its guids are expected to be kept.
+func (p *podEventHandler) requeueInvalidGUIDNetworks(pod *kapi.Pod) {
+	if !utils.HasNetworkAttachment(pod) {
+		return
+	}
+
+	networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+	if err != nil {
+		log.Error().Msgf("failed to parse network annotations with error: %v", err)
+		return
+	}
+
+	var invalidNetworkIDs []string
+	for _, network := range networks {
+		if !utils.IsPodNetworkConfiguredWithInfiniBand(network, p.ibAnnotationKey) {
+			continue
+		}
+
+		_, guidErr := utils.GetPodNetworkGUIDStrict(network)
+		if guidErr == nil {
+			continue
+		}
+
+		if network.CNIArgs != nil {
+			log.Warn().Msgf("pod namespace %s name %s network %s has invalid guid after its IP changed: %v",
+				pod.Namespace, pod.Name, network.Name, guidErr)
+			delete(*network.CNIArgs, "guid")
+		}
+		invalidNetworkIDs = append(invalidNetworkIDs, utils.GenerateNetworkID(network))
+	}
+
+	if len(invalidNetworkIDs) == 0 {
+		log.Debug().Msgf("pod namespace %s name %s IP changed, its guids are valid", pod.Namespace, pod.Name)
+		return
+	}
+
+	netAnnotations, err := json.Marshal(networks)
+	if err != nil {
+		log.Error().Msgf("failed to dump networks %+v of pod into json with error: %v", networks, err)
+		return
+	}
+
+	// the pod is shared with the watcher cache
+	requeuedPod := pod.DeepCopy()
+	requeuedPod.Annotations[v1.NetworkAttachmentAnnot] = string(netAnnotations)
+	for _, networkID := range invalidNetworkIDs {
+		pods, ok := p.addedPods.Get(networkID)
+		if !ok {
+			pods = []*kapi.Pod{requeuedPod}
+		} else {
+			pods = append(pods.([]*kapi.Pod), requeuedPod)
+		}
+		p.addedPods.Set(networkID, pods)
+	}
+
+	log.Info().Msgf("pod update event: IP of pod namespace %s name %s changed, re-queued its InfiniBand networks "+
+		"%v with missing or invalid guids", pod.Namespace, pod.Name, invalidNetworkIDs)
+}
+
+func (p *podEventHandler) canAllocateGUID(namespace string) bool {
+	return p.quotaChecker == nil || p.quotaChecker.CanAllocateGUID(namespace)
+}
+
 func (p *podEventHandler) addNetworksFromPod(pod *kapi.Pod) error {
 	networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
 	if err != nil {
@@ -164,7 +483,7 @@ func (p *podEventHandler) addNetworksFromPod(pod *kapi.Pod) error {
 
 	for _, network := range networks {
 		// check if pod network is configured
-		if utils.IsPodNetworkConfiguredWithInfiniBand(network) {
+		if utils.IsPodNetworkConfiguredWithInfiniBand(network, p.ibAnnotationKey) {
 			continue
 		}
 
@@ -6,12 +6,23 @@ import (
 	. "github.com/onsi/gomega"
 	kapi "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
 )
 
+type fakeQuotaChecker struct {
+	exceeded bool
+}
+
+func (f *fakeQuotaChecker) CanAllocateGUID(namespace string) bool {
+	return !f.exceeded
+}
+
 var _ = Describe("Pod Event Handler", func() {
 	Context("Create new Pod Event Handler", func() {
 		It("Create new Pod Event Handler", func() {
-			podEventHandler := NewPodEventHandler()
+			podEventHandler := NewPodEventHandler(nil)
 			Expect(podEventHandler.GetResourceObject().GetObjectKind().GroupVersionKind().Kind).To(Equal("pods"))
 		})
 	})
@@ -32,7 +43,7 @@ var _ = Describe("Pod Event Handler", func() {
 				v1.NetworkAttachmentAnnot: `[{"name":"test", "namespace":"kube-system"}]`}},
 				Spec: kapi.PodSpec{NodeName: "test"}}
 
-			podEventHandler := NewPodEventHandler()
+			podEventHandler := NewPodEventHandler(nil)
 			podEventHandler.OnAdd(pod1)
 			podEventHandler.OnAdd(pod2)
 			podEventHandler.OnAdd(pod3)
@@ -44,6 +55,72 @@ var _ = Describe("Pod Event Handler", func() {
 			pods = addMap.Items["kube-system_test"].([]*kapi.Pod)
 			Expect(len(pods)).To(Equal(1))
 		})
+		It("On add pod with cross-namespace network", func() {
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"test", "namespace":"bar"}, {"name":"test2"}]`}},
+				Spec: kapi.PodSpec{NodeName: "test"}}
+
+			podEventHandler := NewPodEventHandler(nil)
+			podEventHandler.OnAdd(pod)
+
+			addMap, _ := podEventHandler.GetResults()
+			Expect(len(addMap.Items)).To(Equal(2))
+			Expect(addMap.Items).To(HaveKey("bar_test"))
+			Expect(addMap.Items).To(HaveKey("foo_test2"))
+		})
+		It("On add pod selected by annotations", func() {
+			selectedPod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot:        `[{"name":"test"}]`,
+				"hpc.example.com/workload-class": "mpi"}},
+				Spec: kapi.PodSpec{NodeName: "test"}}
+			otherPod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"other"}]`}},
+				Spec: kapi.PodSpec{NodeName: "test"}}
+			selector, err := labels.Parse("hpc.example.com/workload-class=mpi")
+			Expect(err).ToNot(HaveOccurred())
+
+			podEventHandler := NewPodEventHandler(nil)
+			podEventHandler.(PodAnnotationsSelector).SelectPodsByAnnotations(selector)
+			podEventHandler.OnAdd(selectedPod)
+			podEventHandler.OnAdd(otherPod)
+
+			addMap, _ := podEventHandler.GetResults()
+			Expect(addMap.Items).To(HaveLen(1))
+			Expect(addMap.Items).To(HaveKey("default_test"))
+		})
+		It("On add pod of filtered namespaces", func() {
+			newPod := func(namespace string) *kapi.Pod {
+				return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Annotations: map[string]string{
+					v1.NetworkAttachmentAnnot: `[{"name":"test"}]`}},
+					Spec: kapi.PodSpec{NodeName: "test"}}
+			}
+
+			podEventHandler := NewPodEventHandler(nil)
+			podEventHandler.(PodNamespacesFilter).FilterNamespaces([]string{"foo", "bar"}, []string{"bar"})
+			podEventHandler.OnAdd(newPod("foo"))
+			podEventHandler.OnAdd(newPod("bar"))
+			podEventHandler.OnAdd(newPod("baz"))
+
+			addMap, _ := podEventHandler.GetResults()
+			Expect(addMap.Items).To(HaveLen(1))
+			Expect(addMap.Items).To(HaveKey("foo_test"))
+		})
+		It("On add pod networks configured with the InfiniBand annotation key", func() {
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"a","cni-args":{"fabric-b.infiniband.app":"configured"}},` +
+					`{"name":"b","cni-args":{"mellanox.infiniband.app":"configured"}},` +
+					`{"name":"c","cni-args":{"fabric-c.infiniband.app":"configured"}},{"name":"d"}]`}},
+				Spec: kapi.PodSpec{NodeName: "test"}}
+
+			podEventHandler := NewPodEventHandler(nil)
+			podEventHandler.(InfiniBandAnnotationKeySetter).SetInfiniBandAnnotationKey("fabric-b.infiniband.app")
+			podEventHandler.OnAdd(pod)
+
+			addMap, _ := podEventHandler.GetResults()
+			Expect(addMap.Items).To(HaveLen(2))
+			Expect(addMap.Items).To(HaveKey("default_c"))
+			Expect(addMap.Items).To(HaveKey("default_d"))
+		})
 		It("On add pod invalid cases", func() {
 			// No network needed
 			pod1 := &kapi.Pod{Spec: kapi.PodSpec{HostNetwork: true}}
@@ -59,7 +136,7 @@ var _ = Describe("Pod Event Handler", func() {
 				v1.NetworkAttachmentAnnot: `[invalid]`}},
 				Spec: kapi.PodSpec{NodeName: "test"}}
 
-			podEventHandler := NewPodEventHandler()
+			podEventHandler := NewPodEventHandler(nil)
 			podEventHandler.OnAdd(pod1)
 			podEventHandler.OnAdd(pod2)
 			podEventHandler.OnAdd(pod3)
@@ -76,7 +153,7 @@ var _ = Describe("Pod Event Handler", func() {
 				v1.NetworkAttachmentAnnot: `[
                   {"name":"test", "namespace":"default"},{"name":"test2", "namespace":"default"}]`}}}
 
-			podEventHandler := NewPodEventHandler()
+			podEventHandler := NewPodEventHandler(nil)
 			podEventHandler.OnAdd(pod)
 			pod.Spec = kapi.PodSpec{NodeName: "test"}
 			podEventHandler.OnUpdate(nil, pod)
@@ -101,7 +178,7 @@ var _ = Describe("Pod Event Handler", func() {
 				v1.NetworkAttachmentAnnot: `[invalid]`}},
 				Spec: kapi.PodSpec{}}
 
-			podEventHandler := NewPodEventHandler()
+			podEventHandler := NewPodEventHandler(nil)
 			podEventHandler.OnUpdate(nil, pod1)
 			podEventHandler.OnUpdate(nil, pod2)
 			podEventHandler.OnUpdate(nil, pod3)
@@ -113,6 +190,98 @@ var _ = Describe("Pod Event Handler", func() {
 			addMap, _ := podEventHandler.GetResults()
 			Expect(len(addMap.Items)).To(Equal(0))
 		})
+		It("On update pod event with restarted container", func() {
+			oldPod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"test", "namespace":"default", "cni-args":{` +
+					`"guid":"02:00:00:00:00:00:00:01", "mellanox.infiniband.app":"configured"}},` +
+					`{"name":"other", "namespace":"default"}]`}},
+				Spec: kapi.PodSpec{NodeName: "test"},
+				Status: kapi.PodStatus{Phase: kapi.PodRunning, ContainerStatuses: []kapi.ContainerStatus{
+					{Name: "app", RestartCount: 1}}}}
+			newPod := oldPod.DeepCopy()
+
+			podEventHandler := NewPodEventHandler(nil)
+			podEventHandler.OnUpdate(oldPod, newPod)
+			addMap, _ := podEventHandler.GetResults()
+			Expect(len(addMap.Items)).To(Equal(0))
+
+			newPod.Status.ContainerStatuses[0].RestartCount = 2
+			podEventHandler.OnUpdate(oldPod, newPod)
+			Expect(len(addMap.Items)).To(Equal(1))
+			Expect(addMap.Items["default_test"]).To(Equal([]*kapi.Pod{newPod}))
+		})
+		It("On update pod event with changed IP", func() {
+			oldPod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod",
+				Annotations: map[string]string{v1.NetworkAttachmentAnnot: `[{"name":"test", "namespace":"default",` +
+					`"cni-args":{"guid":"02:00:00:00:00:00:00:01", "mellanox.infiniband.app":"configured"}},` +
+					`{"name":"invalid", "namespace":"default", "cni-args":{"guid":"00:00:00:00:00:00:00:00",` +
+					`"mellanox.infiniband.app":"configured"}}]`}},
+				Spec:   kapi.PodSpec{NodeName: "test"},
+				Status: kapi.PodStatus{Phase: kapi.PodRunning, PodIP: "10.0.0.1"}}
+			newPod := oldPod.DeepCopy()
+			newPod.Status.PodIP = "10.0.0.2"
+
+			// pod IP changes aren't tracked by default
+			podEventHandler := NewPodEventHandler(nil)
+			podEventHandler.OnUpdate(oldPod, newPod)
+			addMap, _ := podEventHandler.GetResults()
+			Expect(addMap.Items).To(BeEmpty())
+
+			podEventHandler.(PodIPChangesTracker).TrackPodIPChanges()
+			podEventHandler.OnUpdate(oldPod, oldPod.DeepCopy())
+			Expect(addMap.Items).To(BeEmpty())
+
+			podEventHandler.OnUpdate(oldPod, newPod)
+			Expect(addMap.Items).To(HaveLen(1))
+			requeuedPods := addMap.Items["default_invalid"].([]*kapi.Pod)
+			Expect(requeuedPods).To(HaveLen(1))
+			Expect(requeuedPods[0].Annotations[v1.NetworkAttachmentAnnot]).ToNot(ContainSubstring(
+				"00:00:00:00:00:00:00:00"))
+			Expect(requeuedPods[0].Annotations[v1.NetworkAttachmentAnnot]).To(ContainSubstring(
+				"02:00:00:00:00:00:00:01"))
+			// the watcher cache pod isn't modified
+			Expect(newPod.Annotations[v1.NetworkAttachmentAnnot]).To(ContainSubstring("00:00:00:00:00:00:00:00"))
+		})
+	})
+	Context("Pending quota", func() {
+		It("Hold pods until namespace quota is available", func() {
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", UID: "pod", Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"test", "namespace":"default"}]`}},
+				Spec: kapi.PodSpec{NodeName: "test"}}
+
+			quotaChecker := &fakeQuotaChecker{exceeded: true}
+			podEventHandler := NewPodEventHandler(quotaChecker)
+			podEventHandler.OnAdd(pod)
+
+			addMap, _ := podEventHandler.GetResults()
+			Expect(len(addMap.Items)).To(Equal(0))
+
+			podEventHandler.(PendingQuotaHandler).RecheckPendingQuota()
+			Expect(len(addMap.Items)).To(Equal(0))
+
+			quotaChecker.exceeded = false
+			podEventHandler.(PendingQuotaHandler).RecheckPendingQuota()
+			Expect(len(addMap.Items["default_test"].([]*kapi.Pod))).To(Equal(1))
+
+			// pod is no longer pending
+			podEventHandler.(PendingQuotaHandler).RecheckPendingQuota()
+			Expect(len(addMap.Items["default_test"].([]*kapi.Pod))).To(Equal(1))
+		})
+		It("Deleted pods aren't pending", func() {
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", UID: "pod", Annotations: map[string]string{
+				v1.NetworkAttachmentAnnot: `[{"name":"test", "namespace":"default"}]`}},
+				Spec: kapi.PodSpec{NodeName: "test"}}
+
+			quotaChecker := &fakeQuotaChecker{exceeded: true}
+			podEventHandler := NewPodEventHandler(quotaChecker)
+			podEventHandler.OnAdd(pod)
+			podEventHandler.OnDelete(pod)
+
+			quotaChecker.exceeded = false
+			podEventHandler.(PendingQuotaHandler).RecheckPendingQuota()
+			addMap, _ := podEventHandler.GetResults()
+			Expect(len(addMap.Items)).To(Equal(0))
+		})
 	})
 	Context("OnDelete", func() {
 		It("On delete pod event", func() {
@@ -134,7 +303,7 @@ var _ = Describe("Pod Event Handler", func() {
                         "cni-args":{"guid":"02:00:00:00:02:00:00:01", "mellanox.infiniband.app":"configured"}}
                      ]`}}}
 
-			podEventHandler := NewPodEventHandler()
+			podEventHandler := NewPodEventHandler(nil)
 			podEventHandler.OnDelete(pod1)
 			podEventHandler.OnDelete(pod2)
 
@@ -156,7 +325,7 @@ var _ = Describe("Pod Event Handler", func() {
 				v1.NetworkAttachmentAnnot: `[{"name":"test", "cni-args":{"mellanox.infiniband.app":"configured"}}]`}},
 				Spec: kapi.PodSpec{}}
 
-			podEventHandler := NewPodEventHandler()
+			podEventHandler := NewPodEventHandler(nil)
 			podEventHandler.OnDelete(pod1)
 			podEventHandler.OnDelete(pod2)
 			podEventHandler.OnDelete(pod3)
@@ -165,5 +334,27 @@ var _ = Describe("Pod Event Handler", func() {
 			_, delMap := podEventHandler.GetResults()
 			Expect(len(delMap.Items)).To(Equal(0))
 		})
+		It("Delete pods kept by the guid release finalizer once", func() {
+			deletionTime := metav1.Now()
+			pod := &kapi.Pod{ObjectMeta: metav1.ObjectMeta{UID: "pod-uid", DeletionTimestamp: &deletionTime,
+				Finalizers: []string{utils.GUIDReleaseFinalizer}, Annotations: map[string]string{
+					v1.NetworkAttachmentAnnot: `[{"name":"test","namespace":"default",` +
+						`"cni-args":{"guid":"02:00:00:00:02:00:00:00","mellanox.infiniband.app":"configured"}}]`}},
+				Status: kapi.PodStatus{Phase: kapi.PodRunning}}
+
+			podEventHandler := NewPodEventHandler(nil)
+			podEventHandler.OnUpdate(pod, pod)
+			podEventHandler.OnUpdate(pod, pod)
+			podEventHandler.OnAdd(pod)
+			_, delMap := podEventHandler.GetResults()
+			Expect(len(delMap.Items["default_test"].([]*kapi.Pod))).To(Equal(1))
+
+			// the guids were released before the finalizer was removed
+			delMap.Remove("default_test")
+			releasedPod := pod.DeepCopy()
+			releasedPod.Finalizers = nil
+			podEventHandler.OnDelete(releasedPod)
+			Expect(delMap.Items).To(BeEmpty())
+		})
 	})
 })
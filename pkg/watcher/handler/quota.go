@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	kapi "k8s.io/api/core/v1"
+
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+)
+
+// QuotaChecker checks the namespaces InfiniBand resource quotas
+type QuotaChecker interface {
+	// CanAllocateGUID returns false if the namespace exceeded its InfiniBand resource quota
+	CanAllocateGUID(namespace string) bool
+}
+
+// quotaCacheTTL is how long the ResourceQuota of a namespace is cached, so the pod events of the namespace don't list
+// its ResourceQuotas every time
+const quotaCacheTTL = 10 * time.Second
+
+// cachedQuota is the ResourceQuota of a namespace, nil if the namespace has no InfiniBand quota
+type cachedQuota struct {
+	quota     *kapi.ResourceQuota
+	expiresAt time.Time
+}
+
+type quotaChecker struct {
+	client k8sClient.Client
+	ttl    time.Duration
+	now    func() time.Time
+	lock   sync.Mutex
+	quotas map[string]*cachedQuota // ResourceQuotas mapped by namespace
+}
+
+// NewQuotaChecker returns quota checker of the ResourceQuotas limiting k8sClient.InfiniBandQuotaResources, the
+// ResourceQuota of a namespace is cached for quotaCacheTTL
+func NewQuotaChecker(client k8sClient.Client) QuotaChecker {
+	return &quotaChecker{client: client, ttl: quotaCacheTTL, now: time.Now, quotas: map[string]*cachedQuota{}}
+}
+
+// getResourceQuota returns the cached ResourceQuota of the namespace, the quota is read from the api server once its
+// cache expires. The failures to read it aren't cached.
+func (q *quotaChecker) getResourceQuota(namespace string) (*kapi.ResourceQuota, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := q.now()
+	if cached, ok := q.quotas[namespace]; ok && now.Before(cached.expiresAt) {
+		return cached.quota, nil
+	}
+
+	quota, err := q.client.GetResourceQuota(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// drop the expired quotas, e.g of deleted namespaces
+	for cachedNamespace, cached := range q.quotas {
+		if !now.Before(cached.expiresAt) {
+			delete(q.quotas, cachedNamespace)
+		}
+	}
+	q.quotas[namespace] = &cachedQuota{quota: quota, expiresAt: now.Add(q.ttl)}
+	return quota, nil
+}
+
+func (q *quotaChecker) CanAllocateGUID(namespace string) bool {
+	quota, err := q.getResourceQuota(namespace)
+	if err != nil {
+		// don't block pods on api server errors
+		log.Warn().Msgf("failed to get resource quota of namespace %s with error: %v", namespace, err)
+		return true
+	}
+
+	if quota == nil {
+		return true
+	}
+
+	for _, resource := range k8sClient.InfiniBandQuotaResources {
+		hard, ok := quota.Status.Hard[resource]
+		if !ok {
+			continue
+		}
+
+		used := quota.Status.Used[resource]
+		if used.Cmp(hard) > 0 {
+			log.Info().Msgf("namespace %s exceeded resource quota %s of %s: used %s, hard %s",
+				namespace, quota.Name, resource, used.String(), hard.String())
+			return false
+		}
+	}
+
+	return true
+}
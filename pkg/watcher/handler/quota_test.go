@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
+)
+
+var _ = Describe("Quota Checker", func() {
+	guidQuota := func(hard, used string) *kapi.ResourceQuota {
+		return &kapi.ResourceQuota{Status: kapi.ResourceQuotaStatus{
+			Hard: kapi.ResourceList{"ib.mellanox.com/guid": resource.MustParse(hard)},
+			Used: kapi.ResourceList{"ib.mellanox.com/guid": resource.MustParse(used)}}}
+	}
+	Context("CanAllocateGUID", func() {
+		It("Namespace within quota", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetResourceQuota", "default").Return(guidQuota("4", "4"), nil)
+			Expect(NewQuotaChecker(client).CanAllocateGUID("default")).To(BeTrue())
+		})
+		It("Namespace exceeded quota", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetResourceQuota", "default").Return(guidQuota("4", "5"), nil)
+			Expect(NewQuotaChecker(client).CanAllocateGUID("default")).To(BeFalse())
+		})
+		It("Namespace without quota", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetResourceQuota", "default").Return(nil, nil)
+			Expect(NewQuotaChecker(client).CanAllocateGUID("default")).To(BeTrue())
+		})
+		It("Failed to get quota", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetResourceQuota", "default").Return(nil, errors.New("failed"))
+			Expect(NewQuotaChecker(client).CanAllocateGUID("default")).To(BeTrue())
+		})
+		It("Cache the namespace quota until it expires", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetResourceQuota", "default").Return(guidQuota("4", "5"), nil).Once()
+			client.On("GetResourceQuota", "default").Return(guidQuota("4", "4"), nil).Once()
+			now := time.Now()
+			checker := NewQuotaChecker(client).(*quotaChecker)
+			checker.now = func() time.Time { return now }
+
+			Expect(checker.CanAllocateGUID("default")).To(BeFalse())
+			Expect(checker.CanAllocateGUID("default")).To(BeFalse())
+			client.AssertNumberOfCalls(GinkgoT(), "GetResourceQuota", 1)
+
+			now = now.Add(quotaCacheTTL)
+			Expect(checker.CanAllocateGUID("default")).To(BeTrue())
+			client.AssertNumberOfCalls(GinkgoT(), "GetResourceQuota", 2)
+		})
+		It("Don't cache the failure to get quota", func() {
+			client := &k8sClientMock.Client{}
+			client.On("GetResourceQuota", "default").Return(nil, errors.New("failed")).Once()
+			client.On("GetResourceQuota", "default").Return(guidQuota("4", "5"), nil).Once()
+			checker := NewQuotaChecker(client)
+
+			Expect(checker.CanAllocateGUID("default")).To(BeTrue())
+			Expect(checker.CanAllocateGUID("default")).To(BeFalse())
+		})
+	})
+})
@@ -1,10 +1,13 @@
 package watcher
 
 import (
-	"time"
+	"sync"
 
 	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 
 	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
@@ -23,23 +26,129 @@ type Watcher interface {
 type watcher struct {
 	eventHandler resEventHandler.ResourceEventHandler
 	watchList    cache.ListerWatcher
+	lock         sync.Mutex       // guards the informer controller and its channels
+	store        cache.Store      // informer cache, set when the watcher runs
+	controller   cache.Controller // informer controller, set when the watcher runs
+	stopChan     chan struct{}    // closed to stop the informer controller, nil once the watcher is stopped
+	stopped      chan struct{}    // closed once the informer controller stopped
 }
 
 func NewWatcher(eventHandler resEventHandler.ResourceEventHandler, client k8sClient.Client) Watcher {
+	return newWatcher(eventHandler, client.GetRestClient(), fields.Everything(), labels.Everything())
+}
+
+// NewPodWatcher creates watcher of the pods selected by the label selector, the other pods never reach the event
+// handler
+func NewPodWatcher(eventHandler resEventHandler.ResourceEventHandler, client k8sClient.Client,
+	labelSelector labels.Selector) Watcher {
+	return newWatcher(eventHandler, client.GetRestClient(), fields.Everything(), labelSelector)
+}
+
+// NewNetworkAttachmentDefinitionWatcher creates watcher of the network attachment definition crds
+func NewNetworkAttachmentDefinitionWatcher(eventHandler resEventHandler.ResourceEventHandler,
+	client k8sClient.Client) Watcher {
+	return newWatcher(eventHandler, client.GetNetRestClient(), fields.Everything(), labels.Everything())
+}
+
+// NewGUIDMigrationWatcher creates watcher of the guid migration crds
+func NewGUIDMigrationWatcher(eventHandler resEventHandler.ResourceEventHandler, client k8sClient.Client) Watcher {
+	return newWatcher(eventHandler, client.GetIBRestClient(), fields.Everything(), labels.Everything())
+}
+
+// NewNodeWatcher creates watcher of the resources scheduled on the given node, e.g pods, and selected by the label
+// selector
+func NewNodeWatcher(eventHandler resEventHandler.ResourceEventHandler, client k8sClient.Client,
+	nodeName string, labelSelector labels.Selector) Watcher {
+	return newWatcher(eventHandler, client.GetRestClient(), fields.OneTermEqualSelector("spec.nodeName", nodeName),
+		labelSelector)
+}
+
+// NewNodeObjectWatcher creates watcher of the node object with the given name
+func NewNodeObjectWatcher(eventHandler resEventHandler.ResourceEventHandler, client k8sClient.Client,
+	nodeName string) Watcher {
+	return newWatcher(eventHandler, client.GetRestClient(), fields.OneTermEqualSelector("metadata.name", nodeName),
+		labels.Everything())
+}
+
+func newWatcher(eventHandler resEventHandler.ResourceEventHandler, restClient rest.Interface,
+	fieldSelector fields.Selector, labelSelector labels.Selector) Watcher {
 	resource := eventHandler.GetResourceObject().GetObjectKind().GroupVersionKind().Kind
-	watchList := cache.NewListWatchFromClient(client.GetRestClient(), resource, kapi.NamespaceAll, fields.Everything())
+	watchList := cache.NewFilteredListWatchFromClient(restClient, resource, kapi.NamespaceAll,
+		func(options *metav1.ListOptions) {
+			options.FieldSelector = fieldSelector.String()
+			options.LabelSelector = labelSelector.String()
+		})
 	return &watcher{eventHandler: eventHandler, watchList: watchList}
 }
 
 // Run Watcher in the background, listening for k8s resource events, until StopFunc is called
 func (w *watcher) RunBackground() StopFunc {
-	stopChan := make(chan struct{})
-	_, controller := cache.NewInformer(w.watchList, w.eventHandler.GetResourceObject(), time.Second*0, w.eventHandler)
-	go controller.Run(stopChan)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.store = cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+	w.runController()
 	return func() {
-		stopChan <- struct{}{}
-		close(stopChan)
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		w.stopController()
+		w.stopChan = nil
+	}
+}
+
+// runController runs informer controller which syncs the store with the resources and passes their events to the
+// event handler. The controller of an already synced store re-lists the resources and passes only the changes which
+// the store missed. The caller must hold the lock.
+func (w *watcher) runController() {
+	controller := cache.New(&cache.Config{
+		Queue:         cache.NewDeltaFIFO(cache.MetaNamespaceKeyFunc, w.store),
+		ListerWatcher: w.watchList,
+		ObjectType:    w.eventHandler.GetResourceObject(),
+		Process:       w.process,
+	})
+	stopChan, stopped := make(chan struct{}), make(chan struct{})
+	w.controller, w.stopChan, w.stopped = controller, stopChan, stopped
+	go func() {
+		defer close(stopped)
+		controller.Run(stopChan)
+	}()
+}
+
+// stopController stops the informer controller and waits until it stopped. The caller must hold the lock.
+func (w *watcher) stopController() {
+	close(w.stopChan)
+	<-w.stopped
+}
+
+// process applies the deltas of a resource to the store and passes them to the event handler, as the informer of
+// cache.NewInformer does
+func (w *watcher) process(obj interface{}) error {
+	for _, delta := range obj.(cache.Deltas) {
+		switch delta.Type {
+		case cache.Sync, cache.Added, cache.Updated:
+			if old, exists, err := w.store.Get(delta.Object); err == nil && exists {
+				if err = w.store.Update(delta.Object); err != nil {
+					return err
+				}
+				w.eventHandler.OnUpdate(old, delta.Object)
+			} else {
+				if err = w.store.Add(delta.Object); err != nil {
+					return err
+				}
+				w.eventHandler.OnAdd(delta.Object)
+			}
+		case cache.Deleted:
+			if err := w.store.Delete(delta.Object); err != nil {
+				return err
+			}
+			// the resources deleted while the watch was down are passed as their last state in the store
+			if tombstone, ok := delta.Object.(cache.DeletedFinalStateUnknown); ok {
+				w.eventHandler.OnDelete(tombstone.Obj)
+			} else {
+				w.eventHandler.OnDelete(delta.Object)
+			}
+		}
 	}
+	return nil
 }
 
 func (w *watcher) GetHandler() resEventHandler.ResourceEventHandler {
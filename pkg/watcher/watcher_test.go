@@ -1,6 +1,7 @@
 package watcher
 
 import (
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -8,7 +9,11 @@ import (
 	"github.com/stretchr/testify/mock"
 	kapi "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 	cacheTesting "k8s.io/client-go/tools/cache/testing"
 
 	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
@@ -21,13 +26,51 @@ var _ = Describe("Kubernetes Watcher", func() {
 		It("Create new watcher", func() {
 			fakeClient := fake.NewSimpleClientset()
 			client := &k8sClientMock.Client{}
-			eventHandler := resEventHandler.NewPodEventHandler()
+			eventHandler := resEventHandler.NewPodEventHandler(nil)
 
 			client.On("GetRestClient").Return(fakeClient.CoreV1().RESTClient())
 			watcher := NewWatcher(eventHandler, client)
 			Expect(watcher.GetHandler()).To(Equal(eventHandler))
 		})
 	})
+	Context("NewNetworkAttachmentDefinitionWatcher", func() {
+		It("Create new network attachment definition watcher", func() {
+			fakeClient := fake.NewSimpleClientset()
+			client := &k8sClientMock.Client{}
+			eventHandler := resEventHandler.NewNetworkAttachmentDefinitionEventHandler()
+
+			client.On("GetNetRestClient").Return(fakeClient.CoreV1().RESTClient())
+			watcher := NewNetworkAttachmentDefinitionWatcher(eventHandler, client)
+			Expect(watcher.GetHandler()).To(Equal(eventHandler))
+			client.AssertCalled(GinkgoT(), "GetNetRestClient")
+		})
+	})
+	Context("NewPodWatcher", func() {
+		It("Create new pod watcher with label selector", func() {
+			fakeClient := fake.NewSimpleClientset()
+			client := &k8sClientMock.Client{}
+			eventHandler := resEventHandler.NewPodEventHandler(nil)
+			selector, err := labels.Parse("network=infiniband")
+			Expect(err).ToNot(HaveOccurred())
+
+			client.On("GetRestClient").Return(fakeClient.CoreV1().RESTClient())
+			watcher := NewPodWatcher(eventHandler, client, selector)
+			Expect(watcher.GetHandler()).To(Equal(eventHandler))
+			client.AssertCalled(GinkgoT(), "GetRestClient")
+		})
+	})
+	Context("NewNodeWatcher", func() {
+		It("Create new node watcher", func() {
+			fakeClient := fake.NewSimpleClientset()
+			client := &k8sClientMock.Client{}
+			eventHandler := resEventHandler.NewPodEventHandler(nil)
+
+			client.On("GetRestClient").Return(fakeClient.CoreV1().RESTClient())
+			watcher := NewNodeWatcher(eventHandler, client, "node1", labels.Everything())
+			Expect(watcher.GetHandler()).To(Equal(eventHandler))
+			client.AssertCalled(GinkgoT(), "GetRestClient")
+		})
+	})
 	Context("RunBackground", func() {
 		It("Run watcher listening for events", func() {
 			eventHandler := &mocks.ResourceEventHandler{}
@@ -72,4 +115,94 @@ var _ = Describe("Kubernetes Watcher", func() {
 			stopFunc()
 		})
 	})
+	Context("DetectDesync", func() {
+		newPod := func(name string) *kapi.Pod {
+			return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+		}
+		It("Re-list watcher cache when desync exceeds threshold", func() {
+			var added, updated, deleted int32
+			eventHandler := &mocks.ResourceEventHandler{}
+			eventHandler.On("GetResourceObject").Return(&kapi.Pod{})
+			eventHandler.On("OnAdd", mock.Anything).Run(func(args mock.Arguments) { atomic.AddInt32(&added, 1) })
+			eventHandler.On("OnUpdate", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				atomic.AddInt32(&updated, 1)
+			})
+			eventHandler.On("OnDelete", mock.Anything).Run(func(args mock.Arguments) {
+				Expect(args[0]).To(BeAssignableToTypeOf(&kapi.Pod{}))
+				atomic.AddInt32(&deleted, 1)
+			})
+
+			wl := cacheTesting.NewFakeControllerSource()
+			wl.Add(newPod("test1"))
+			wl.Add(newPod("test2"))
+			wl.Add(newPod("test3"))
+			watcher := &watcher{eventHandler: eventHandler, watchList: wl}
+
+			_, err := watcher.DetectDesync(0)
+			Expect(err).To(HaveOccurred())
+
+			stopFunc := watcher.RunBackground()
+			defer stopFunc()
+			Eventually(func() int32 { return atomic.LoadInt32(&added) }).Should(Equal(int32(3)))
+
+			desync, err := watcher.DetectDesync(0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(desync).To(Equal(0))
+
+			// simulate missed add and delete events
+			Expect(watcher.store.Delete(newPod("test1"))).To(Succeed())
+			Expect(watcher.store.Add(newPod("stale"))).To(Succeed())
+
+			// the check doesn't change the cache
+			desync, err = watcher.DetectDesync(2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(desync).To(Equal(2))
+			Expect(watcher.store.ListKeys()).To(ConsistOf("default/test2", "default/test3", "default/stale"))
+
+			desync, err = watcher.DetectDesync(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(desync).To(Equal(2))
+			Eventually(func() int32 { return atomic.LoadInt32(&added) }).Should(Equal(int32(4)))
+			Eventually(func() int32 { return atomic.LoadInt32(&deleted) }).Should(Equal(int32(1)))
+			Eventually(func() int32 { return atomic.LoadInt32(&updated) }).Should(Equal(int32(2)))
+			Expect(watcher.store.ListKeys()).To(ConsistOf("default/test1", "default/test2", "default/test3"))
+		})
+		It("Compare only the sampled resources when the list is partial", func() {
+			eventHandler := &mocks.ResourceEventHandler{}
+			eventHandler.On("GetResourceObject").Return(&kapi.Pod{})
+			eventHandler.On("OnAdd", mock.Anything)
+
+			wl := cacheTesting.NewFakeControllerSource()
+			wl.Add(newPod("test1"))
+			wl.Add(newPod("test2"))
+			wl.Add(newPod("test3"))
+			listWatch := &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					// the informer lists from the watch cache, the desync check samples the first page
+					if options.ResourceVersion != "" {
+						return wl.List(options)
+					}
+					Expect(options.Limit).To(Equal(int64(desyncSampleSize)))
+					return &kapi.PodList{ListMeta: metav1.ListMeta{Continue: "test1"},
+						Items: []kapi.Pod{*newPod("test1")}}, nil
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) { return wl.Watch(options) },
+			}
+			watcher := &watcher{eventHandler: eventHandler, watchList: listWatch}
+			stopFunc := watcher.RunBackground()
+			defer stopFunc()
+			Eventually(watcher.store.ListKeys).Should(HaveLen(3))
+
+			desync, err := watcher.DetectDesync(0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(desync).To(Equal(0))
+
+			// only the cached resources up to the last sampled resource are compared
+			Expect(watcher.store.Add(newPod("stale"))).To(Succeed())
+			Expect(watcher.store.Add(newPod("test4"))).To(Succeed())
+			desync, err = watcher.DetectDesync(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(desync).To(Equal(1))
+		})
+	})
 })
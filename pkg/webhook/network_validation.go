@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"github.com/rs/zerolog/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibUtils "github.com/Mellanox/ib-kubernetes/pkg/ib-utils"
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	"github.com/Mellanox/ib-kubernetes/pkg/sm/plugins"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// NetworkValidationPath is the url path of the network attachment definitions validating webhook
+const NetworkValidationPath = "/validate-network-attachment-definition"
+
+type networkValidationHandler struct {
+	kubeClient k8sClient.Client
+}
+
+// NewNetworkValidationHandler returns a validating admission webhook handler which rejects created and updated
+// InfiniBand SR-IOV network attachment definitions with an invalid pKey, instead of their pods never getting guids
+func NewNetworkValidationHandler(kubeClient k8sClient.Client) http.Handler {
+	return &networkValidationHandler{kubeClient: kubeClient}
+}
+
+func (h *networkValidationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveAdmissionReview(w, r, h.admit)
+}
+
+// admit returns the admission response of the request, network attachment definitions which can't be parsed or
+// aren't InfiniBand SR-IOV networks are allowed
+func (h *networkValidationHandler) admit(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{Allowed: true}
+	if request.Kind.Kind != "NetworkAttachmentDefinition" ||
+		(request.Operation != admissionv1.Create && request.Operation != admissionv1.Update) {
+		return response
+	}
+
+	netAttDef := &v1.NetworkAttachmentDefinition{}
+	if err := json.Unmarshal(request.Object.Raw, netAttDef); err != nil {
+		log.Warn().Msgf("failed to parse network attachment definition of admission request %s with error: %v",
+			request.UID, err)
+		return response
+	}
+	if netAttDef.Namespace == "" {
+		netAttDef.Namespace = request.Namespace
+	}
+
+	if err := h.validate(netAttDef); err != nil {
+		log.Info().Msgf("rejecting network attachment definition %s/%s: %v", netAttDef.Namespace, netAttDef.Name,
+			err)
+		response.Allowed = false
+		response.Result = &metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonInvalid,
+			Code: http.StatusUnprocessableEntity, Message: err.Error()}
+	}
+	return response
+}
+
+// validate returns error if the network attachment definition is an InfiniBand SR-IOV network with an invalid pKey
+// or pKey membership
+func (h *networkValidationHandler) validate(netAttDef *v1.NetworkAttachmentDefinition) error {
+	networkSpec := make(map[string]interface{})
+	if netAttDef.Spec.Config != "" && json.Unmarshal([]byte(netAttDef.Spec.Config), &networkSpec) != nil {
+		return nil
+	}
+
+	ibCniSpec, err := h.getIbSriovCniSpec(networkSpec, netAttDef.Namespace,
+		netAttDef.Annotations[utils.CNIConfNameAnnotation])
+	if err != nil || ibCniSpec.PKey == "" {
+		return nil
+	}
+
+	pKey, err := utils.ParsePKey(ibCniSpec.PKey)
+	if err != nil {
+		return fmt.Errorf("invalid %s pkey %q: %v", utils.InfiniBandSriovCni, ibCniSpec.PKey, err)
+	}
+	if !ibUtils.IsPKeyValid(pKey) {
+		return fmt.Errorf("invalid %s pkey %q, larger than 0x7FFF", utils.InfiniBandSriovCni, ibCniSpec.PKey)
+	}
+	if _, err = plugins.ParseMembership(ibCniSpec.Membership); err != nil {
+		return fmt.Errorf("invalid %s pkey %q membership: %v", utils.InfiniBandSriovCni, ibCniSpec.PKey, err)
+	}
+	return nil
+}
+
+// getIbSriovCniSpec returns the InfiniBand SR-IOV CNI spec of the network spec, if the network spec has no cni config
+// it falls back to the cni config stored in the ConfigMap confName in the given namespace
+func (h *networkValidationHandler) getIbSriovCniSpec(networkSpec map[string]interface{}, namespace,
+	confName string) (*utils.IbSriovCniSpec, error) {
+	if utils.HasCniConfig(networkSpec) || confName == "" {
+		return utils.GetIbSriovCniFromNetwork(networkSpec)
+	}
+
+	configMap, err := h.kubeClient.GetConfigMap(namespace, confName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cni config ConfigMap %s in namespace %s: %v", confName, namespace, err)
+	}
+	return utils.GetIbSriovCniFromConfigMap(configMap)
+}
@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
+)
+
+var _ = Describe("Network Validation Webhook", func() {
+	var handler http.Handler
+	BeforeEach(func() {
+		handler = NewNetworkValidationHandler(&k8sClientMock.Client{})
+	})
+	review := func(config string) *admissionv1.AdmissionResponse {
+		netAttDef := &v1.NetworkAttachmentDefinition{ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Spec: v1.NetworkAttachmentDefinitionSpec{Config: config}}
+		netAttDefData, err := json.Marshal(netAttDef)
+		Expect(err).ToNot(HaveOccurred())
+		request := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+			UID: "review-uid",
+			Kind: metav1.GroupVersionKind{Group: "k8s.cni.cncf.io", Version: "v1",
+				Kind: "NetworkAttachmentDefinition"},
+			Namespace: "default",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: netAttDefData}}}
+		requestData, err := json.Marshal(request)
+		Expect(err).ToNot(HaveOccurred())
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, NetworkValidationPath,
+			bytes.NewReader(requestData)))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		response := &admissionv1.AdmissionReview{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), response)).To(Succeed())
+		Expect(response.Response.UID).To(BeEquivalentTo("review-uid"))
+		return response.Response
+	}
+	It("Allow InfiniBand network with valid pKey or without pKey", func() {
+		Expect(review(`{"type": "ib-sriov", "pkey": "0x10"}`).Allowed).To(BeTrue())
+		Expect(review(`{"plugins": [{"type": "ib-sriov", "pkey": "0x7FFF"}, {"type": "tuning"}]}`).Allowed).To(BeTrue())
+		Expect(review(`{"type": "ib-sriov"}`).Allowed).To(BeTrue())
+		Expect(review(`{"type": "ib-sriov", "pkey": "0x10", "membership": "limited"}`).Allowed).To(BeTrue())
+	})
+	It("Reject InfiniBand network with invalid pKey", func() {
+		for _, config := range []string{`{"type": "ib-sriov", "pkey": "10"}`, `{"type": "ib-sriov", "pkey": 16}`,
+			`{"type": "ib-sriov", "pkey": "0x8010"}`, `{"plugins": [{"type": "ib-sriov", "pkey": "0xZZ"}]}`,
+			`{"type": "ib-sriov", "pkey": "0x10", "membership": "partial"}`} {
+			response := review(config)
+			Expect(response.Allowed).To(BeFalse(), config)
+			Expect(response.Result.Message).To(ContainSubstring("invalid ib-sriov pkey"), config)
+		}
+	})
+	It("Allow networks which aren't InfiniBand networks", func() {
+		Expect(review(`{"type": "bridge", "pkey": "invalid"}`).Allowed).To(BeTrue())
+		Expect(review(`invalid`).Allowed).To(BeTrue())
+	})
+})
@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+
+	netAttUtils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	"github.com/rs/zerolog/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	kapi "k8s.io/api/core/v1"
+
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+	"github.com/Mellanox/ib-kubernetes/pkg/utils"
+)
+
+// ReadinessGatePath is the url path of the readiness gate mutating webhook
+const ReadinessGatePath = "/mutate-pod-readiness-gate"
+
+// jsonPatchOperation is a json patch (RFC 6902) operation of the admission response
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type readinessGateHandler struct {
+	kubeClient k8sClient.Client
+}
+
+// NewReadinessGateHandler returns a mutating admission webhook handler which adds the InfiniBand ready readiness
+// gate to created pods with InfiniBand networks, the daemon sets the gate condition once their guids are configured
+func NewReadinessGateHandler(kubeClient k8sClient.Client) http.Handler {
+	return &readinessGateHandler{kubeClient: kubeClient}
+}
+
+func (h *readinessGateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveAdmissionReview(w, r, h.admit)
+}
+
+// admit returns the admission response of the request, pods are always allowed and the readiness gate
+// isn't added if the pod networks can't be checked
+func (h *readinessGateHandler) admit(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{Allowed: true}
+	if request.Kind.Kind != "Pod" || request.Operation != admissionv1.Create {
+		return response
+	}
+
+	pod := &kapi.Pod{}
+	if err := json.Unmarshal(request.Object.Raw, pod); err != nil {
+		log.Warn().Msgf("failed to parse pod of admission request %s with error: %v", request.UID, err)
+		return response
+	}
+	// the namespace of pods created by controllers is only set in the request
+	if pod.Namespace == "" {
+		pod.Namespace = request.Namespace
+	}
+
+	if utils.HasIBReadyGate(pod) || !h.hasInfiniBandNetwork(pod) {
+		return response
+	}
+
+	patchData, err := json.Marshal(readinessGatePatch(pod))
+	if err != nil {
+		log.Warn().Msgf("failed to create readiness gate patch of pod namespace %s name %s with error: %v",
+			pod.Namespace, pod.Name, err)
+		return response
+	}
+
+	log.Debug().Msgf("adding readiness gate %s to pod namespace %s name %s", utils.IBReadyConditionType,
+		pod.Namespace, pod.GenerateName+pod.Name)
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.Patch = patchData
+	response.PatchType = &patchType
+	return response
+}
+
+// hasInfiniBandNetwork check if one of the pod networks is an InfiniBand network
+func (h *readinessGateHandler) hasInfiniBandNetwork(pod *kapi.Pod) bool {
+	if !utils.HasNetworkAttachment(pod) {
+		return false
+	}
+
+	networks, err := netAttUtils.ParsePodNetworkAnnotation(pod)
+	if err != nil {
+		return false
+	}
+
+	for _, network := range networks {
+		netAttDef, getErr := h.kubeClient.GetNetworkAttachmentDefinition(network.Namespace, network.Name)
+		if getErr != nil {
+			log.Warn().Msgf("failed to get network attachment definition %s/%s with error: %v",
+				network.Namespace, network.Name, getErr)
+			continue
+		}
+
+		if utils.IsInfiniBandNetworkAttachmentDefinition(netAttDef) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readinessGatePatch returns the json patch adding the InfiniBand ready readiness gate to the pod
+func readinessGatePatch(pod *kapi.Pod) []jsonPatchOperation {
+	gate := kapi.PodReadinessGate{ConditionType: utils.IBReadyConditionType}
+	if len(pod.Spec.ReadinessGates) == 0 {
+		return []jsonPatchOperation{{Op: "add", Path: "/spec/readinessGates", Value: []kapi.PodReadinessGate{gate}}}
+	}
+
+	return []jsonPatchOperation{{Op: "add", Path: "/spec/readinessGates/-", Value: gate}}
+}
@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	v1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	k8sClientMock "github.com/Mellanox/ib-kubernetes/pkg/k8s-client/mocks"
+)
+
+var _ = Describe("Readiness Gate Webhook", func() {
+	var handler http.Handler
+	BeforeEach(func() {
+		client := &k8sClientMock.Client{}
+		client.On("GetNetworkAttachmentDefinition", "default", "ib").Return(&v1.NetworkAttachmentDefinition{
+			Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "ib-sriov", "pkey": "0x10"}`}}, nil)
+		client.On("GetNetworkAttachmentDefinition", "default", "bridge").Return(&v1.NetworkAttachmentDefinition{
+			Spec: v1.NetworkAttachmentDefinitionSpec{Config: `{"type": "bridge"}`}}, nil)
+		handler = NewReadinessGateHandler(client)
+	})
+	review := func(pod *kapi.Pod) *admissionv1.AdmissionResponse {
+		podData, err := json.Marshal(pod)
+		Expect(err).ToNot(HaveOccurred())
+		request := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+			UID:       "review-uid",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Namespace: "default",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: podData}}}
+		requestData, err := json.Marshal(request)
+		Expect(err).ToNot(HaveOccurred())
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ReadinessGatePath,
+			bytes.NewReader(requestData)))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		response := &admissionv1.AdmissionReview{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), response)).To(Succeed())
+		Expect(response.Response.UID).To(BeEquivalentTo("review-uid"))
+		Expect(response.Response.Allowed).To(BeTrue())
+		return response.Response
+	}
+	networkPod := func(networks string) *kapi.Pod {
+		return &kapi.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test",
+			Annotations: map[string]string{v1.NetworkAttachmentAnnot: networks}}}
+	}
+	It("Add readiness gate to pod with InfiniBand network", func() {
+		response := review(networkPod(`[{"name":"bridge"},{"name":"ib"}]`))
+		Expect(*response.PatchType).To(Equal(admissionv1.PatchTypeJSONPatch))
+		Expect(response.Patch).To(MatchJSON(
+			`[{"op":"add","path":"/spec/readinessGates","value":[{"conditionType":"ib.mellanox.com/IBReady"}]}]`))
+	})
+	It("Append readiness gate to pod readiness gates", func() {
+		pod := networkPod(`[{"name":"ib"}]`)
+		pod.Spec.ReadinessGates = []kapi.PodReadinessGate{{ConditionType: "example.com/ready"}}
+		response := review(pod)
+		Expect(response.Patch).To(MatchJSON(
+			`[{"op":"add","path":"/spec/readinessGates/-","value":{"conditionType":"ib.mellanox.com/IBReady"}}]`))
+	})
+	It("Don't add readiness gate to pod without InfiniBand network", func() {
+		Expect(review(networkPod(`[{"name":"bridge"}]`)).Patch).To(BeNil())
+		Expect(review(&kapi.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test"}}).Patch).To(BeNil())
+	})
+	It("Reject invalid admission review", func() {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ReadinessGatePath,
+			bytes.NewReader([]byte("invalid"))))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	admissionv1 "k8s.io/api/admission/v1"
+
+	k8sClient "github.com/Mellanox/ib-kubernetes/pkg/k8s-client"
+)
+
+// readHeaderTimeout limits the time to read the request headers of the webhook requests
+const readHeaderTimeout = 10 * time.Second
+
+type Server interface {
+	// Run serves the admission webhooks until the stop channel is closed
+	Run(stopChan <-chan struct{}) error
+}
+
+type server struct {
+	httpServer *http.Server
+	certFile   string
+	keyFile    string
+}
+
+// NewServer returns a https server of the admission webhooks on the given address, e.g ":8443",
+// with the given tls certificate and key files
+func NewServer(address, certFile, keyFile string, kubeClient k8sClient.Client) Server {
+	mux := http.NewServeMux()
+	mux.Handle(ReadinessGatePath, NewReadinessGateHandler(kubeClient))
+	mux.Handle(NetworkValidationPath, NewNetworkValidationHandler(kubeClient))
+	return &server{
+		httpServer: &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: readHeaderTimeout},
+		certFile:   certFile,
+		keyFile:    keyFile,
+	}
+}
+
+// serveAdmissionReview writes the admission review of the request with the response of the admit function
+func serveAdmissionReview(w http.ResponseWriter, r *http.Request,
+	admit func(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) {
+	review := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = admit(review.Request)
+	review.Response.UID = review.Request.UID
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		log.Warn().Msgf("failed to write admission review response with error: %v", err)
+	}
+}
+
+func (s *server) Run(stopChan <-chan struct{}) error {
+	go func() {
+		<-stopChan
+		s.httpServer.Close()
+	}()
+
+	log.Info().Msgf("serving admission webhooks on %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServeTLS(s.certFile, s.keyFile); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve admission webhooks on %s: %v", s.httpServer.Addr, err)
+	}
+
+	return nil
+}